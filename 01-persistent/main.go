@@ -223,36 +223,27 @@ func demonstrateDataMigration(ctx context.Context, baseDir string) {
 
 	// Migration process
 	fmt.Printf("\n🔄 Migrating %d blocks...\n", len(sourceCids))
-	start := time.Now()
-
-	migrated := 0
-	for _, cidToMigrate := range sourceCids {
-		// Read from source
-		data, err := source.GetRaw(ctx, cidToMigrate)
-		if err != nil {
-			fmt.Printf("   ❌ Failed to read %s: %v\n", cidToMigrate.String(), err)
-			continue
-		}
-
-		// Write to target
-		targetCid, err := target.PutV1Cid(ctx, data, nil)
-		if err != nil {
-			fmt.Printf("   ❌ Failed to write %s: %v\n", cidToMigrate.String(), err)
-			continue
-		}
-
-		// Verify CID consistency
-		if !cidToMigrate.Equals(targetCid) {
-			fmt.Printf("   ❌ CID mismatch: %s != %s\n", cidToMigrate.String(), targetCid.String())
-			continue
-		}
-
-		migrated++
-		fmt.Printf("   ✅ Migrated: %s\n", cidToMigrate.String()[:20]+"...")
+	result, err := persistent.Migrate(ctx, source, target, persistent.MigrateOptions{
+		Concurrency:    4,
+		RateLimitBytes: 1 << 20, // 1MiB/s
+		Resume:         true,
+		OnProgress: func(p persistent.MigrateProgress) {
+			if p.Finished {
+				return
+			}
+			fmt.Printf("   ⏳ scanned=%d copied=%d skipped=%d bytes=%d\n", p.Scanned, p.Copied, p.Skipped, p.Bytes)
+		},
+	})
+	if err != nil {
+		fmt.Printf("   ❌ Migration failed: %v\n", err)
+		return
+	}
+	for _, c := range result.CorruptCIDs {
+		fmt.Printf("   ❌ Corrupt block skipped: %s\n", c.String())
 	}
 
-	migrationTime := time.Since(start)
-	fmt.Printf("\n📊 Migration complete: %d/%d blocks in %v\n", migrated, len(sourceCids), migrationTime)
+	fmt.Printf("\n📊 Migration complete: %d copied, %d skipped, %d corrupt (%d bytes) in %v\n",
+		result.Copied, result.Skipped, result.Corrupt, result.Bytes, result.Elapsed)
 
 	// Verify migration by reading from target
 	fmt.Printf("\n🔍 Verification: Reading from target...\n")
@@ -430,6 +421,17 @@ func demonstrateStorageEfficiency(ctx context.Context, baseDir string) {
 			fmt.Printf("   ✅ %s: %s\n", testData.desc, cidResult.String()[:20]+"...")
 		}
 
+		if report, err := p.Analyze(ctx, persistent.AnalyzeOptions{TopN: 1}); err == nil {
+			fmt.Printf("   🔎 %d blocks, mean %s, p50 ≤ %s\n", report.Blocks,
+				formatSize(int64(report.Sizes.Mean)), formatSize(int64(report.Sizes.Quantile(0.5))))
+			for codec, usage := range report.ByCodec {
+				fmt.Printf("      %-8s %d blocks, %s\n", codec, usage.Blocks, formatSize(usage.Bytes))
+			}
+			if len(report.Largest) > 0 {
+				fmt.Printf("   🏔️  Largest: %s (%s)\n", report.Largest[0].CID.String()[:20]+"...", formatSize(int64(report.Largest[0].Size)))
+			}
+		}
+
 		p.Close()
 
 		// Measure storage usage
@@ -1,14 +1,31 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
 )
 
 func TestPersistentBackends(t *testing.T) {
@@ -65,3 +82,727 @@ func TestPersistentBackends(t *testing.T) {
 		require.NoError(t, err, "must close persistent wrapper")
 	}
 }
+
+func TestPersistentNamespaces(t *testing.T) {
+	ctx := context.TODO()
+	data := []byte("namespaced block")
+
+	root, err := persistent.New(persistent.Badgerdb, filepath.Join(t.TempDir(), "shared"))
+	require.NoError(t, err)
+	defer root.Close()
+
+	blocks := root.WithNamespace(persistent.NamespaceBlocks)
+	pins := root.WithNamespace(persistent.NamespacePins)
+
+	c, err := blocks.PutV1Cid(ctx, data, nil)
+	require.NoError(t, err)
+
+	ok, err := blocks.Has(ctx, c)
+	require.NoError(t, err)
+	assert.True(t, ok, "block must be visible in its own namespace")
+
+	ok, err = pins.Has(ctx, c)
+	require.NoError(t, err)
+	assert.False(t, ok, "block must not leak into another namespace")
+
+	ok, err = root.Has(ctx, c)
+	require.NoError(t, err)
+	assert.False(t, ok, "root view must not see namespaced keys at its top level")
+
+	// Closing a view must not close the shared database
+	require.NoError(t, blocks.Close())
+	got, err := root.WithNamespace(persistent.NamespaceBlocks).GetRaw(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestPersistentMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	src, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer src.Close()
+
+	var cids []cid.Cid
+	for i := 0; i < 50; i++ {
+		c, err := src.PutV1Cid(ctx, []byte(fmt.Sprintf("block-%d", i)), nil)
+		require.NoError(t, err)
+		cids = append(cids, c)
+	}
+
+	// A block whose bytes no longer match its key must not be copied
+	bad, err := src.PutV1Cid(ctx, []byte("original"), nil)
+	require.NoError(t, err)
+	badKey := ds.NewKey("/blocks" + dshelp.MultihashToDsKey(bad.Hash()).String())
+	require.NoError(t, src.Datastore().Put(ctx, badKey, []byte("tampered")))
+
+	dst, err := persistent.New(persistent.Badgerdb, filepath.Join(t.TempDir(), "dst"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	// Interrupt after the first batch
+	cctx, cancel := context.WithCancel(ctx)
+	first, err := persistent.Migrate(cctx, src, dst, persistent.MigrateOptions{
+		BatchSize:  10,
+		OnProgress: func(persistent.MigrateProgress) { cancel() },
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	var events []persistent.MigrateProgress
+	res, err := persistent.Migrate(ctx, src, dst, persistent.MigrateOptions{
+		Concurrency:    4,
+		BatchSize:      10,
+		RateLimitBytes: 1 << 20,
+		Resume:         true,
+		OnProgress:     func(p persistent.MigrateProgress) { events = append(events, p) },
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(51), res.Scanned)
+	assert.Equal(t, int64(10), res.Skipped, "resume must skip the checkpointed batch")
+	assert.Equal(t, int64(51), first.Copied+first.Corrupt+res.Copied+res.Corrupt)
+
+	corrupt := append(first.CorruptCIDs, res.CorruptCIDs...)
+	require.Len(t, corrupt, 1)
+	assert.Equal(t, bad.Hash(), corrupt[0].Hash())
+
+	require.NotEmpty(t, events)
+	assert.True(t, events[len(events)-1].Finished)
+
+	for _, c := range cids {
+		ok, err := dst.Has(ctx, c)
+		require.NoError(t, err)
+		assert.True(t, ok, "migrated block %s must exist in dst", c)
+	}
+	ok, err := dst.Has(ctx, bad)
+	require.NoError(t, err)
+	assert.False(t, ok, "corrupt block must not be migrated")
+}
+
+func TestPersistentMetrics(t *testing.T) {
+	ctx := context.TODO()
+
+	var slow []persistent.SlowOp
+	pw, err := persistent.New(persistent.Memory, t.TempDir(),
+		persistent.WithSlowOpThreshold(time.Nanosecond),
+		persistent.WithSlowOpHook(func(op persistent.SlowOp) { slow = append(slow, op) }),
+	)
+	require.NoError(t, err)
+	defer pw.Close()
+
+	data := []byte("instrumented block")
+	c, err := pw.PutV1Cid(ctx, data, nil)
+	require.NoError(t, err)
+	_, err = pw.GetRaw(ctx, c)
+	require.NoError(t, err)
+	require.NoError(t, pw.Delete(ctx, c))
+	_, err = pw.GetRaw(ctx, c)
+	require.Error(t, err)
+
+	stats := pw.GetMetrics()
+	assert.Equal(t, int64(1), stats[persistent.OpPut].Metrics.SuccessfulRequests)
+	assert.Equal(t, int64(len(data)), stats[persistent.OpPut].Metrics.BytesProcessed)
+	assert.Equal(t, int64(1), stats[persistent.OpPut].Size.Count)
+	assert.Equal(t, int64(2), stats[persistent.OpGet].Latency.Count)
+	assert.Equal(t, int64(1), stats[persistent.OpGet].Metrics.ErrorsByType["not_found"])
+	assert.Equal(t, int64(1), stats[persistent.OpDelete].Metrics.SuccessfulRequests)
+
+	// Namespaced views report into the same metrics
+	view := pw.WithNamespace(persistent.NamespacePins)
+	require.NoError(t, view.Datastore().Put(ctx, ds.NewKey("/pin"), []byte("x")))
+	assert.Equal(t, int64(2), pw.GetMetrics()[persistent.OpPut].Metrics.TotalRequests)
+
+	require.NotEmpty(t, slow)
+	ops := make(map[string]bool)
+	for _, op := range slow {
+		assert.Equal(t, persistent.Memory, op.Backend)
+		ops[op.Op] = true
+	}
+	assert.True(t, ops[persistent.OpPut], "every op exceeds a 1ns threshold")
+
+	// GetSize is counted on its own, not as a has
+	hasBefore := pw.GetMetrics()[persistent.OpHas].Metrics.TotalRequests
+	_, err = pw.GetSize(ctx, c)
+	require.Error(t, err)
+	assert.Equal(t, int64(1), pw.GetMetrics()[persistent.OpGetSize].Metrics.TotalRequests)
+	assert.Equal(t, hasBefore, pw.GetMetrics()[persistent.OpHas].Metrics.TotalRequests)
+}
+
+func TestPersistentGlobalMetrics(t *testing.T) {
+	globalPuts := func() []string {
+		var names []string
+		for name := range metrics.GetGlobalSnapshot() {
+			if strings.HasPrefix(name, "persistent."+string(persistent.Memory)+".") && strings.HasSuffix(name, "."+persistent.OpPut) {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	before := len(globalPuts())
+
+	pw1, err := persistent.New(persistent.Memory, t.TempDir())
+	require.NoError(t, err)
+	defer pw1.Close()
+	pw2, err := persistent.New(persistent.Memory, t.TempDir())
+	require.NoError(t, err)
+
+	// Two stores of the same backend keep separate entries
+	assert.Len(t, globalPuts(), before+2)
+
+	require.NoError(t, pw2.Close())
+	assert.Len(t, globalPuts(), before+1)
+}
+
+func TestPersistentTxn(t *testing.T) {
+	ctx := context.TODO()
+
+	for _, typ := range []persistent.PersistentType{persistent.Memory, persistent.Badgerdb, persistent.Pebbledb} {
+		t.Run(string(typ), func(t *testing.T) {
+			root, err := persistent.New(typ, filepath.Join(t.TempDir(), string(typ)))
+			require.NoError(t, err)
+			defer root.Close()
+			pw := root.WithNamespace(persistent.NamespaceBlocks)
+
+			var blks []blocks.Block
+			for i := range 3 {
+				blks = append(blks, blocks.NewBlock([]byte(fmt.Sprintf("txn block %d", i))))
+			}
+
+			// A failing callback must leave nothing behind
+			errAbort := errors.New("abort")
+			err = pw.Txn(ctx, func(txn persistent.BlockTxn) error {
+				require.NoError(t, txn.PutMany(ctx, blks))
+				ok, err := txn.Has(ctx, blks[0].Cid())
+				require.NoError(t, err)
+				assert.True(t, ok, "staged put must be visible inside the txn")
+				return errAbort
+			})
+			require.ErrorIs(t, err, errAbort)
+			for _, b := range blks {
+				ok, err := pw.Has(ctx, b.Cid())
+				require.NoError(t, err)
+				assert.False(t, ok, "aborted txn must not persist %s", b.Cid())
+			}
+
+			err = pw.Txn(ctx, func(txn persistent.BlockTxn) error {
+				assert.Equal(t, typ != persistent.Memory, txn.Atomic())
+				return txn.PutMany(ctx, blks)
+			})
+			require.NoError(t, err)
+			for _, b := range blks {
+				got, err := pw.GetRaw(ctx, b.Cid())
+				require.NoError(t, err)
+				assert.Equal(t, b.RawData(), got)
+			}
+
+			// Blocks land in the view's namespace only
+			ok, err := root.Has(ctx, blks[0].Cid())
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			err = pw.Txn(ctx, func(txn persistent.BlockTxn) error {
+				return txn.Delete(ctx, blks[0].Cid())
+			})
+			require.NoError(t, err)
+			ok, err = pw.Has(ctx, blks[0].Cid())
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestPersistentCompression(t *testing.T) {
+	ctx := context.TODO()
+	text := []byte(strings.Repeat(`{"name":"boxo","kind":"dag-json"}`, 64))
+	small := []byte("tiny")
+
+	for _, codec := range []persistent.Compression{persistent.CompressionSnappy, persistent.CompressionZstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			pw, err := persistent.New(persistent.Badgerdb, filepath.Join(t.TempDir(), "badger"),
+				persistent.WithCompression(codec, 128))
+			require.NoError(t, err)
+			defer pw.Close()
+
+			big, err := pw.PutV1Cid(ctx, text, nil)
+			require.NoError(t, err)
+			tiny, err := pw.PutV1Cid(ctx, small, nil)
+			require.NoError(t, err)
+
+			got, err := pw.GetRaw(ctx, big)
+			require.NoError(t, err)
+			assert.Equal(t, text, got)
+			got, err = pw.GetRaw(ctx, tiny)
+			require.NoError(t, err)
+			assert.Equal(t, small, got)
+
+			size, err := pw.GetSize(ctx, big)
+			require.NoError(t, err)
+			assert.Equal(t, len(text), size, "GetSize must report the logical size")
+
+			// Blocks written inside a transaction are compressed too
+			blk := blocks.NewBlock(append([]byte("txn "), text...))
+			require.NoError(t, pw.Txn(ctx, func(txn persistent.BlockTxn) error {
+				return txn.Put(ctx, blk)
+			}))
+			got, err = pw.GetRaw(ctx, blk.Cid())
+			require.NoError(t, err)
+			assert.Equal(t, blk.RawData(), got)
+
+			stats := pw.CompressionStats()
+			assert.Equal(t, codec, stats.Codec)
+			assert.Equal(t, int64(2), stats.Compressed)
+			assert.Equal(t, int64(1), stats.Uncompressed)
+			assert.Greater(t, stats.Ratio(), 2.0)
+		})
+	}
+
+	t.Run("format mismatch", func(t *testing.T) {
+		zstd := persistent.WithCompression(persistent.CompressionZstd, 0)
+
+		plainPath := filepath.Join(t.TempDir(), "plain")
+		plain, err := persistent.New(persistent.Pebbledb, plainPath)
+		require.NoError(t, err)
+		// raw data that happens to start like a compression header
+		lookalike := append([]byte{0xb0, 0x78, 0x63, 0x02}, text...)
+		c, err := plain.PutV1Cid(ctx, lookalike, nil)
+		require.NoError(t, err)
+		require.NoError(t, plain.Close())
+
+		_, err = persistent.New(persistent.Pebbledb, plainPath, zstd)
+		require.ErrorIs(t, err, persistent.ErrCompressionMismatch, "compression must not be enabled over uncompressed values")
+
+		plain, err = persistent.New(persistent.Pebbledb, plainPath)
+		require.NoError(t, err)
+		defer plain.Close()
+		compressedPath := filepath.Join(t.TempDir(), "compressed")
+		pw, err := persistent.New(persistent.Pebbledb, compressedPath, zstd)
+		require.NoError(t, err)
+		_, err = persistent.Migrate(ctx, plain, pw, persistent.MigrateOptions{})
+		require.NoError(t, err)
+
+		got, err := pw.GetRaw(ctx, c)
+		require.NoError(t, err)
+		assert.Equal(t, lookalike, got)
+		stats, err := pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Blocks)
+		require.NoError(t, pw.Close())
+
+		_, err = persistent.New(persistent.Pebbledb, compressedPath)
+		require.ErrorIs(t, err, persistent.ErrCompressionMismatch, "a compressed store must not be opened without compression")
+		pw, err = persistent.New(persistent.Pebbledb, compressedPath, zstd)
+		require.NoError(t, err)
+		defer pw.Close()
+		got, err = pw.GetRaw(ctx, c)
+		require.NoError(t, err)
+		assert.Equal(t, lookalike, got)
+	})
+
+	_, err := persistent.New(persistent.Memory, t.TempDir(), persistent.WithCompression("lz4", 0))
+	assert.Error(t, err)
+}
+
+// failingPutDatastore fails every Put once fail is set
+type failingPutDatastore struct {
+	ds.Batching
+	fail bool
+}
+
+func (d *failingPutDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if d.fail {
+		return errors.New("disk full")
+	}
+	return d.Batching.Put(ctx, key, value)
+}
+
+func TestPersistentQuota(t *testing.T) {
+	ctx := context.TODO()
+	block := func(i int) []byte { return []byte(fmt.Sprintf("quota block %03d", i)) } // 15 bytes
+
+	t.Run("reject", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pebble")
+		pw, err := persistent.New(persistent.Pebbledb, path,
+			persistent.WithQuota(persistent.Quota{MaxBlocks: 3}))
+		require.NoError(t, err)
+
+		for i := range 3 {
+			_, err := pw.PutV1Cid(ctx, block(i), nil)
+			require.NoError(t, err)
+		}
+		_, err = pw.PutV1Cid(ctx, block(3), nil)
+		require.ErrorIs(t, err, persistent.ErrQuotaExceeded)
+
+		err = pw.Txn(ctx, func(txn persistent.BlockTxn) error {
+			return txn.Put(ctx, blocks.NewBlock(block(4)))
+		})
+		require.ErrorIs(t, err, persistent.ErrQuotaExceeded)
+
+		stats, err := pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), stats.Blocks)
+		assert.Equal(t, int64(45), stats.Bytes)
+		assert.Equal(t, int64(2), stats.Rejected)
+		require.NoError(t, pw.Close())
+
+		// Usage is rebuilt when the store is reopened
+		pw, err = persistent.New(persistent.Pebbledb, path,
+			persistent.WithQuota(persistent.Quota{MaxBlocks: 3}))
+		require.NoError(t, err)
+		defer pw.Close()
+		stats, err = pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), stats.Blocks)
+		assert.Equal(t, int64(45), stats.Bytes)
+	})
+
+	t.Run("lru", func(t *testing.T) {
+		pw, err := persistent.New(persistent.Memory, t.TempDir(),
+			persistent.WithQuota(persistent.Quota{MaxBytes: 45, Policy: persistent.QuotaEvictLRU}))
+		require.NoError(t, err)
+		defer pw.Close()
+
+		var cids []cid.Cid
+		for i := range 3 {
+			c, err := pw.PutV1Cid(ctx, block(i), nil)
+			require.NoError(t, err)
+			cids = append(cids, c)
+		}
+		_, err = pw.GetRaw(ctx, cids[0]) // block 1 is now least recently used
+		require.NoError(t, err)
+		_, err = pw.PutV1Cid(ctx, block(3), nil)
+		require.NoError(t, err)
+
+		for i, want := range []bool{true, false, true} {
+			ok, err := pw.Has(ctx, cids[i])
+			require.NoError(t, err)
+			assert.Equal(t, want, ok, "block %d", i)
+		}
+		stats, err := pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Evicted)
+		assert.Equal(t, int64(45), stats.Bytes)
+	})
+
+	t.Run("gc-unpinned", func(t *testing.T) {
+		_, err := persistent.New(persistent.Memory, t.TempDir(),
+			persistent.WithQuota(persistent.Quota{Policy: persistent.QuotaEvictUnpinned}))
+		require.Error(t, err, "gc-unpinned needs a Pinned callback")
+
+		pinned := map[string]bool{}
+		pw, err := persistent.New(persistent.Badgerdb, filepath.Join(t.TempDir(), "badger"),
+			persistent.WithQuota(persistent.Quota{
+				MaxBlocks: 2,
+				Policy:    persistent.QuotaEvictUnpinned,
+				Pinned: func(_ context.Context, hash mh.Multihash) (bool, error) {
+					return pinned[hash.String()], nil
+				},
+			}))
+		require.NoError(t, err)
+		defer pw.Close()
+
+		first, err := pw.PutV1Cid(ctx, block(0), nil)
+		require.NoError(t, err)
+		pinned[first.Hash().String()] = true
+		second, err := pw.PutV1Cid(ctx, block(1), nil)
+		require.NoError(t, err)
+
+		// The oldest block is pinned, so the second one is evicted instead
+		require.NoError(t, pw.Txn(ctx, func(txn persistent.BlockTxn) error {
+			return txn.Put(ctx, blocks.NewBlock(block(2)))
+		}))
+		ok, err := pw.Has(ctx, first)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = pw.Has(ctx, second)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// Only pinned blocks left to evict
+		pinned[blocks.NewBlock(block(2)).Cid().Hash().String()] = true
+		_, err = pw.PutV1Cid(ctx, block(3), nil)
+		require.ErrorIs(t, err, persistent.ErrQuotaExceeded)
+	})
+
+	t.Run("pinned callback reads the store", func(t *testing.T) {
+		var pw *persistent.PersistentWrapper
+		pw, err := persistent.New(persistent.Memory, t.TempDir(),
+			persistent.WithQuota(persistent.Quota{
+				MaxBlocks: 1,
+				Policy:    persistent.QuotaEvictLRU,
+				Pinned: func(ctx context.Context, hash mh.Multihash) (bool, error) {
+					_, err := pw.GetRaw(ctx, cid.NewCidV1(cid.Raw, hash))
+					return false, err
+				},
+			}))
+		require.NoError(t, err)
+		defer pw.Close()
+
+		_, err = pw.PutV1Cid(ctx, block(0), nil)
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := pw.PutV1Cid(ctx, block(1), nil)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("eviction deadlocked on the Pinned callback")
+		}
+		stats, err := pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Evicted)
+	})
+
+	t.Run("failed rewrite keeps usage", func(t *testing.T) {
+		backend := &failingPutDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+		name := backendName(t)
+		persistent.Register(name, func(string) (ds.Batching, error) { return backend, nil })
+		pw, err := persistent.New(name, t.TempDir(),
+			persistent.WithQuota(persistent.Quota{MaxBlocks: 3}))
+		require.NoError(t, err)
+		defer pw.Close()
+		c, err := pw.PutV1Cid(ctx, block(0), nil)
+		require.NoError(t, err)
+
+		// The block stays stored when rewriting it fails
+		backend.fail = true
+		key := ds.NewKey("/blocks").Child(dshelp.MultihashToDsKey(c.Hash()))
+		require.Error(t, pw.Datastore().Put(ctx, key, block(0)))
+		stats, err := pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Blocks)
+		assert.Equal(t, int64(15), stats.Bytes)
+	})
+
+	t.Run("stats without quota", func(t *testing.T) {
+		pw, err := persistent.New(persistent.Memory, t.TempDir(), persistent.WithCompression(persistent.CompressionSnappy, 0))
+		require.NoError(t, err)
+		defer pw.Close()
+		_, err = pw.PutV1Cid(ctx, block(0), nil)
+		require.NoError(t, err)
+
+		stats, err := pw.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Blocks)
+		assert.Equal(t, int64(15), stats.Bytes, "sizes are logical, not compressed")
+	})
+}
+
+func TestPersistentScrub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	pw, err := persistent.New(persistent.Memory, t.TempDir())
+	require.NoError(t, err)
+	defer pw.Close()
+
+	for i := range 5 {
+		_, err := pw.PutV1Cid(ctx, []byte(fmt.Sprintf("scrub block %d", i)), nil)
+		require.NoError(t, err)
+	}
+	bad, err := pw.PutV1Cid(ctx, []byte("original"), nil)
+	require.NoError(t, err)
+	badKey := ds.NewKey("/blocks").Child(dshelp.MultihashToDsKey(bad.Hash()))
+	require.NoError(t, pw.Datastore().Put(ctx, badKey, []byte("bit rot")))
+
+	results := make(chan persistent.ScrubResult, 1)
+	scrubber := pw.Scrub(ctx, persistent.ScrubOptions{
+		RateLimitBytes: 1 << 20,
+		OnResult:       func(r persistent.ScrubResult) { results <- r },
+	})
+
+	res := <-results
+	require.NoError(t, res.Err)
+	assert.Equal(t, int64(6), res.Scanned)
+	assert.Equal(t, int64(1), res.Corrupt)
+	require.Len(t, res.Quarantined, 1)
+	assert.Equal(t, bad.Hash(), res.Quarantined[0].Hash())
+
+	ok, err := pw.Has(ctx, bad)
+	require.NoError(t, err)
+	assert.False(t, ok, "corrupt block must be removed from /blocks")
+	quarantined, err := pw.Datastore().Get(ctx, ds.NewKey(persistent.NamespaceQuarantine).Child(dshelp.MultihashToDsKey(bad.Hash())))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bit rot"), quarantined)
+
+	check := scrubber.HealthCheck().Check(ctx)
+	assert.Equal(t, health.StatusUnhealthy, check.Status)
+	assert.Equal(t, "1", check.Metadata["corrupt"])
+
+	// A second pass over the cleaned store is healthy
+	again := pw.ScrubOnce(ctx, persistent.ScrubOptions{})
+	require.NoError(t, again.Err)
+	assert.Equal(t, int64(5), again.Scanned)
+	assert.Zero(t, again.Corrupt)
+	assert.Positive(t, again.Elapsed)
+
+	cancel()
+	<-scrubber.Done()
+}
+
+func TestPersistentTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	pw, err := persistent.New(persistent.Memory, t.TempDir())
+	require.NoError(t, err)
+	defer pw.Close()
+
+	expired, err := pw.PutWithTTL(ctx, []byte("expired"), -time.Second)
+	require.NoError(t, err)
+	pinnedCid, err := pw.PutWithTTL(ctx, []byte("expired but pinned"), -time.Second)
+	require.NoError(t, err)
+	fresh, err := pw.PutWithTTL(ctx, []byte("fresh"), time.Hour)
+	require.NoError(t, err)
+	forever, err := pw.PutV1Cid(ctx, []byte("no ttl"), nil)
+	require.NoError(t, err)
+	promoted, err := pw.PutWithTTL(ctx, []byte("stored again without ttl"), -time.Second)
+	require.NoError(t, err)
+
+	// Re-putting with a shorter TTL keeps the later expiry
+	before, ok, err := pw.ExpiresAt(ctx, fresh)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = pw.PutWithTTL(ctx, []byte("fresh"), time.Minute)
+	require.NoError(t, err)
+	after, _, err := pw.ExpiresAt(ctx, fresh)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// A block stored without a TTL stays permanent
+	_, err = pw.PutWithTTL(ctx, []byte("no ttl"), -time.Second)
+	require.NoError(t, err)
+	_, ok, err = pw.ExpiresAt(ctx, forever)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Storing a TTL block again without a TTL makes it permanent
+	_, err = pw.PutV1Cid(ctx, []byte("stored again without ttl"), nil)
+	require.NoError(t, err)
+	_, ok, err = pw.ExpiresAt(ctx, promoted)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	sweeps := make(chan persistent.SweepResult, 1)
+	sweeper := pw.StartSweeper(ctx, persistent.SweepOptions{
+		Interval: 10 * time.Millisecond,
+		Pinned: func(_ context.Context, hash mh.Multihash) (bool, error) {
+			return bytes.Equal(hash, pinnedCid.Hash()), nil
+		},
+		OnSweep: func(r persistent.SweepResult) {
+			select {
+			case sweeps <- r:
+			default:
+			}
+		},
+	})
+
+	res := <-sweeps
+	require.NoError(t, res.Err)
+	assert.Equal(t, int64(3), res.Scanned)
+	assert.Equal(t, int64(2), res.Expired)
+	assert.Equal(t, int64(1), res.Removed)
+	assert.Equal(t, int64(1), res.Pinned)
+
+	for c, want := range map[cid.Cid]bool{expired: false, pinnedCid: true, fresh: true, forever: true, promoted: true} {
+		ok, err := pw.Has(ctx, c)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, "block %s", c)
+	}
+
+	cancel()
+	<-sweeper.Done()
+}
+
+var backendSeq atomic.Int64
+
+// backendName returns a registry name no earlier run has taken; the
+// registry is process-global and Register panics on duplicates, so fixed
+// names break go test -count=2.
+func backendName(t *testing.T) persistent.PersistentType {
+	return persistent.PersistentType(fmt.Sprintf("%s-%d", t.Name(), backendSeq.Add(1)))
+}
+
+func TestPersistentRegister(t *testing.T) {
+	ctx := context.TODO()
+
+	name := backendName(t)
+	var opened string
+	persistent.Register(name, func(path string) (ds.Batching, error) {
+		opened = path
+		return dssync.MutexWrap(ds.NewMapDatastore()), nil
+	})
+	assert.Contains(t, persistent.Registered(), name)
+	assert.Panics(t, func() {
+		persistent.Register(name, func(string) (ds.Batching, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { persistent.Register(backendName(t), nil) })
+
+	dir := t.TempDir()
+	pw, err := persistent.New(name, dir, persistent.WithCompression(persistent.CompressionSnappy, 0))
+	require.NoError(t, err)
+	defer pw.Close()
+	assert.Equal(t, dir, opened)
+
+	c, err := pw.PutV1Cid(ctx, []byte("custom backend"), nil)
+	require.NoError(t, err)
+	got, err := pw.GetRaw(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("custom backend"), got)
+	assert.Contains(t, pw.GetMetrics(), persistent.OpPut)
+
+	_, err = persistent.New("does-not-exist", t.TempDir())
+	assert.ErrorContains(t, err, "unknown persistent type")
+}
+
+func TestPersistentAnalyze(t *testing.T) {
+	ctx := context.TODO()
+
+	pw, err := persistent.New(persistent.Memory, t.TempDir())
+	require.NoError(t, err)
+	defer pw.Close()
+
+	raw := make([]byte, 2048)
+	for i := range raw {
+		raw[i] = byte(i * 7)
+	}
+	var cbor bytes.Buffer
+	nb := basicnode.Prototype.Any.NewBuilder()
+	ma, err := nb.BeginMap(1)
+	require.NoError(t, err)
+	require.NoError(t, ma.AssembleKey().AssignString("name"))
+	require.NoError(t, ma.AssembleValue().AssignString("boxo"))
+	require.NoError(t, ma.Finish())
+	require.NoError(t, dagcbor.Encode(nb.Build(), &cbor))
+
+	for _, data := range [][]byte{
+		raw,
+		[]byte(`{"name":"boxo","tags":["ipfs","ipld"]}`),
+		merkledag.NodeWithData([]byte("unixfs payload")).RawData(),
+		cbor.Bytes(),
+	} {
+		_, err := pw.PutV1Cid(ctx, data, nil)
+		require.NoError(t, err)
+	}
+
+	report, err := pw.Analyze(ctx, persistent.AnalyzeOptions{TopN: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), report.Blocks)
+	assert.Equal(t, int64(4), report.Sizes.Count)
+	assert.Equal(t, int64(4), report.ByHash["sha2-256"])
+	for _, codec := range []string{"raw", "dag-json", "dag-pb", "dag-cbor"} {
+		assert.Equal(t, int64(1), report.ByCodec[codec].Blocks, codec)
+	}
+	require.Len(t, report.Largest, 2)
+	assert.Equal(t, 2048, report.Largest[0].Size)
+	assert.Equal(t, "raw", report.Largest[0].Codec)
+	assert.GreaterOrEqual(t, report.Largest[0].Size, report.Largest[1].Size)
+
+	keysOnly, err := pw.Analyze(ctx, persistent.AnalyzeOptions{KeysOnly: true})
+	require.NoError(t, err)
+	assert.Equal(t, report.TotalBytes, keysOnly.TotalBytes)
+	assert.Nil(t, keysOnly.ByCodec)
+	assert.Len(t, keysOnly.Largest, 4)
+}
@@ -0,0 +1,172 @@
+package persistent
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/boxo/blockstore"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mc "github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
+)
+
+type AnalyzeOptions struct {
+	TopN        int       // largest blocks to report (default 10)
+	SizeBuckets []float64 // histogram bounds in bytes (default metrics.SizeBucketsBytes)
+
+	// KeysOnly skips reading block data. Codec counts are then not
+	// reported, but the scan is much cheaper on large stores.
+	KeysOnly bool
+}
+
+type BlockInfo struct {
+	CID   cid.Cid
+	Size  int
+	Codec string
+}
+
+type CodecUsage struct {
+	Blocks int64 `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// UsageReport summarises the blocks in a wrapper. The blockstore only keeps
+// multihashes, so codecs are inferred by trying to decode each block as
+// dag-json, dag-pb and dag-cbor, falling back to raw.
+type UsageReport struct {
+	Blocks     int64                     `json:"blocks"`
+	TotalBytes int64                     `json:"total_bytes"`
+	Sizes      metrics.HistogramSnapshot `json:"sizes"`
+	ByCodec    map[string]CodecUsage     `json:"by_codec,omitempty"`
+	ByHash     map[string]int64          `json:"by_hash"`
+	Largest    []BlockInfo               `json:"largest"`
+}
+
+// Analyze enumerates the blocks in p and reports their size distribution,
+// codec and hash function counts, and the largest blocks.
+func (p *PersistentWrapper) Analyze(ctx context.Context, opts AnalyzeOptions) (*UsageReport, error) {
+	if opts.TopN <= 0 {
+		opts.TopN = 10
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = metrics.SizeBucketsBytes
+	}
+
+	results, err := p.batching.Query(ctx, query.Query{
+		Prefix:       blockstore.BlockPrefix.String(),
+		KeysOnly:     opts.KeysOnly,
+		ReturnsSizes: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query blocks: %w", err)
+	}
+	defer results.Close()
+
+	report := &UsageReport{ByHash: make(map[string]int64)}
+	if !opts.KeysOnly {
+		report.ByCodec = make(map[string]CodecUsage)
+	}
+	sizes := metrics.NewHistogram(opts.SizeBuckets)
+	largest := &blockHeap{}
+
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("iterate blocks: %w", r.Error)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		hash, err := dshelp.DsKeyToMultihash(ds.NewKey(strings.TrimPrefix(r.Key, blockstore.BlockPrefix.String())))
+		if err != nil {
+			continue
+		}
+		size := r.Size
+		if !opts.KeysOnly {
+			size = len(r.Value)
+		}
+
+		report.Blocks++
+		report.TotalBytes += int64(size)
+		sizes.Observe(float64(size))
+		if dec, err := mh.Decode(hash); err == nil {
+			report.ByHash[mh.Codes[dec.Code]]++
+		}
+
+		codec := mc.Raw
+		if !opts.KeysOnly {
+			codec = sniffCodec(r.Value)
+			usage := report.ByCodec[codec.String()]
+			usage.Blocks++
+			usage.Bytes += int64(size)
+			report.ByCodec[codec.String()] = usage
+		}
+
+		info := BlockInfo{CID: cid.NewCidV1(uint64(codec), hash), Size: size}
+		if !opts.KeysOnly {
+			info.Codec = codec.String()
+		}
+		if largest.Len() < opts.TopN {
+			heap.Push(largest, info)
+		} else if (*largest)[0].Size < size {
+			(*largest)[0] = info
+			heap.Fix(largest, 0)
+		}
+	}
+
+	report.Sizes = sizes.Snapshot()
+	report.Largest = append([]BlockInfo(nil), *largest...)
+	sort.Slice(report.Largest, func(i, j int) bool { return report.Largest[i].Size > report.Largest[j].Size })
+	return report, nil
+}
+
+// sniffCodec guesses the codec of a block from its content
+func sniffCodec(data []byte) mc.Code {
+	if decodes(data, dagjson.Decode) {
+		return mc.DagJson
+	}
+	if len(data) > 0 {
+		if _, err := merkledag.DecodeProtobuf(data); err == nil {
+			return mc.DagPb
+		}
+	}
+	if decodes(data, dagcbor.Decode) {
+		return mc.DagCbor
+	}
+	return mc.Raw
+}
+
+func decodes(data []byte, decode func(na datamodel.NodeAssembler, r io.Reader) error) bool {
+	r := bytes.NewReader(data)
+	nb := basicnode.Prototype.Any.NewBuilder()
+	return decode(nb, r) == nil && r.Len() == 0
+}
+
+// blockHeap is a min-heap on size used to keep the top-N largest blocks
+type blockHeap []BlockInfo
+
+func (h blockHeap) Len() int           { return len(h) }
+func (h blockHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h blockHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *blockHeap) Push(x any)        { *h = append(*h, x.(BlockInfo)) }
+func (h *blockHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,362 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+type Compression string
+
+const (
+	CompressionNone   Compression = "none"
+	CompressionSnappy Compression = "snappy"
+	CompressionZstd   Compression = "zstd"
+)
+
+// DefaultCompressionThreshold skips values too small to benefit
+const DefaultCompressionThreshold = 512
+
+// Every value written with compression enabled starts with
+// magic(3) | codec(1) | uvarint(original size). Raw data can start with the
+// same bytes, so a store is either compressed throughout or not at all:
+// compressionFormatKey marks the stores whose values all carry the header.
+var compressionMagic = []byte{0xb0, 0x78, 0x63}
+
+// compressionFormatKey is written when compression is enabled on an empty
+// store. Its own value carries a header so full scans decode it.
+var compressionFormatKey = ds.NewKey("/local/compression")
+
+const (
+	codecNone byte = iota
+	codecSnappy
+	codecZstd
+)
+
+var errCorruptHeader = errors.New("persistent: corrupt compression header")
+
+// ErrCompressionMismatch is returned by New when WithCompression does not
+// match how the store was written: compression cannot be turned on for a
+// store holding uncompressed values, or off for a compressed one. Migrate
+// into a new store to change it.
+var ErrCompressionMismatch = errors.New("persistent: compression setting does not match the store")
+
+// checkCompressionFormat makes sure child is written consistently with
+// compression enabled or not, marking empty stores that turn it on
+func checkCompressionFormat(ctx context.Context, child ds.Batching, enabled bool) error {
+	marked, err := child.Has(ctx, compressionFormatKey)
+	if err != nil {
+		return fmt.Errorf("read compression format: %w", err)
+	}
+	switch {
+	case marked && !enabled:
+		return fmt.Errorf("%w: store is compressed", ErrCompressionMismatch)
+	case marked || !enabled:
+		return nil
+	}
+
+	results, err := child.Query(ctx, query.Query{KeysOnly: true, Limit: 1})
+	if err != nil {
+		return fmt.Errorf("check store is empty: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return fmt.Errorf("check store is empty: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%w: store holds uncompressed values", ErrCompressionMismatch)
+	}
+	if err := child.Put(ctx, compressionFormatKey, appendHeader(nil, codecNone, 0)); err != nil {
+		return fmt.Errorf("write compression format: %w", err)
+	}
+	return child.Sync(ctx, compressionFormatKey)
+}
+
+func appendHeader(dst []byte, codec byte, size int) []byte {
+	dst = append(dst, compressionMagic...)
+	dst = append(dst, codec)
+	return binary.AppendUvarint(dst, uint64(size))
+}
+
+// WithCompression enables transparent compression for values of at least
+// minSize bytes. Values that do not shrink are stored as-is.
+func WithCompression(c Compression, minSize int) Option {
+	return func(o *options) {
+		o.compression = c
+		o.compressionMin = minSize
+	}
+}
+
+// CompressionStats reports how much compression is saving
+type CompressionStats struct {
+	Codec        Compression `json:"codec"`
+	Compressed   int64       `json:"compressed"`   // values stored compressed
+	Uncompressed int64       `json:"uncompressed"` // below threshold or incompressible
+	LogicalBytes int64       `json:"logical_bytes"`
+	StoredBytes  int64       `json:"stored_bytes"`
+}
+
+// Ratio is logical/stored bytes; 2.0 means values take half the space
+func (s CompressionStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.LogicalBytes) / float64(s.StoredBytes)
+}
+
+// compressingDatastore compresses values on the way in and decompresses
+// them on the way out.
+type compressingDatastore struct {
+	child   ds.Batching
+	codec   Compression
+	minSize int
+
+	enc     *zstd.Encoder
+	dec     *zstd.Decoder
+	decOnce sync.Once
+	decErr  error
+
+	compressed   atomic.Int64
+	uncompressed atomic.Int64
+	logicalBytes atomic.Int64
+	storedBytes  atomic.Int64
+}
+
+var _ ds.Batching = (*compressingDatastore)(nil)
+var _ ds.PersistentDatastore = (*compressingDatastore)(nil)
+
+func newCompressingDatastore(child ds.Batching, codec Compression, minSize int) (*compressingDatastore, error) {
+	d := &compressingDatastore{child: child, codec: codec, minSize: minSize}
+	switch codec {
+	case CompressionSnappy, CompressionNone:
+	case CompressionZstd:
+		var err error
+		if d.enc, err = zstd.NewWriter(nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+	return d, nil
+}
+
+func (d *compressingDatastore) encode(value []byte) []byte {
+	var codec byte
+	body := value
+	if len(value) >= d.minSize {
+		var out []byte
+		switch d.codec {
+		case CompressionSnappy:
+			codec, out = codecSnappy, snappy.Encode(nil, value)
+		case CompressionZstd:
+			codec, out = codecZstd, d.enc.EncodeAll(value, nil)
+		}
+		if out != nil && len(out) < len(value) {
+			body = out
+		} else {
+			codec = codecNone
+		}
+	}
+
+	header := make([]byte, 0, len(compressionMagic)+1+binary.MaxVarintLen64+len(body))
+	stored := append(appendHeader(header, codec, len(value)), body...)
+
+	if codec == codecNone {
+		d.uncompressed.Add(1)
+	} else {
+		d.compressed.Add(1)
+	}
+	d.logicalBytes.Add(int64(len(value)))
+	d.storedBytes.Add(int64(len(stored)))
+	return stored
+}
+
+// parseHeader returns the codec, original size and body of a stored value
+func parseHeader(stored []byte) (codec byte, size int, body []byte, err error) {
+	if !bytes.HasPrefix(stored, compressionMagic) || len(stored) <= len(compressionMagic) {
+		return 0, 0, nil, errCorruptHeader
+	}
+	codec = stored[len(compressionMagic)]
+	n, read := binary.Uvarint(stored[len(compressionMagic)+1:])
+	if read <= 0 {
+		return 0, 0, nil, errCorruptHeader
+	}
+	return codec, int(n), stored[len(compressionMagic)+1+read:], nil
+}
+
+func (d *compressingDatastore) decode(stored []byte) ([]byte, error) {
+	codec, _, body, err := parseHeader(stored)
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case codecNone:
+		return body, nil
+	case codecSnappy:
+		return snappy.Decode(nil, body)
+	case codecZstd:
+		// Created on demand so zstd blocks stay readable after switching codecs
+		d.decOnce.Do(func() { d.dec, d.decErr = zstd.NewReader(nil) })
+		if d.decErr != nil {
+			return nil, d.decErr
+		}
+		return d.dec.DecodeAll(body, nil)
+	default:
+		return nil, fmt.Errorf("%w: unknown codec %d", errCorruptHeader, codec)
+	}
+}
+
+func (d *compressingDatastore) stats() CompressionStats {
+	return CompressionStats{
+		Codec:        d.codec,
+		Compressed:   d.compressed.Load(),
+		Uncompressed: d.uncompressed.Load(),
+		LogicalBytes: d.logicalBytes.Load(),
+		StoredBytes:  d.storedBytes.Load(),
+	}
+}
+
+func (d *compressingDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	stored, err := d.child.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(stored)
+}
+
+func (d *compressingDatastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	return d.child.Has(ctx, key)
+}
+
+func (d *compressingDatastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	stored, err := d.child.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	_, size, _, err := parseHeader(stored)
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+func (d *compressingDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	if q.KeysOnly && !q.ReturnsSizes {
+		return d.child.Query(ctx, q)
+	}
+	// Logical sizes live in the value header, so sized key-only queries
+	// still read values but skip decompression.
+	childQuery := q
+	childQuery.KeysOnly = false
+	res, err := d.child.Query(ctx, childQuery)
+	if err != nil {
+		return nil, err
+	}
+	return query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := res.NextSync()
+			if !ok || r.Error != nil {
+				return r, ok
+			}
+			if q.KeysOnly {
+				_, size, _, err := parseHeader(r.Value)
+				if err != nil {
+					return query.Result{Error: fmt.Errorf("decode %s: %w", r.Key, err)}, true
+				}
+				r.Value, r.Size = nil, size
+				return r, true
+			}
+			value, err := d.decode(r.Value)
+			if err != nil {
+				return query.Result{Error: fmt.Errorf("decode %s: %w", r.Key, err)}, true
+			}
+			r.Value = value
+			r.Size = len(value)
+			return r, true
+		},
+		Close: res.Close,
+	}), nil
+}
+
+func (d *compressingDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	return d.child.Put(ctx, key, d.encode(value))
+}
+
+func (d *compressingDatastore) Delete(ctx context.Context, key ds.Key) error {
+	return d.child.Delete(ctx, key)
+}
+
+func (d *compressingDatastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *compressingDatastore) Close() error {
+	if d.enc != nil {
+		d.enc.Close()
+	}
+	if d.dec != nil {
+		d.dec.Close()
+	}
+	return d.child.Close()
+}
+
+func (d *compressingDatastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *compressingDatastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &compressingBatch{Batch: b, ds: d}, nil
+}
+
+// Children returns the wrapped datastore
+func (d *compressingDatastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+func (d *compressingDatastore) inner() ds.Batching {
+	return d.child
+}
+
+func (d *compressingDatastore) wrapTxn(t ds.Txn) ds.Txn {
+	return &compressingTxn{Txn: t, ds: d}
+}
+
+type compressingBatch struct {
+	ds.Batch
+	ds *compressingDatastore
+}
+
+func (b *compressingBatch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	return b.Batch.Put(ctx, key, b.ds.encode(value))
+}
+
+// compressingTxn applies the same encoding inside a backend transaction
+type compressingTxn struct {
+	ds.Txn
+	ds *compressingDatastore
+}
+
+func (t *compressingTxn) Put(ctx context.Context, key ds.Key, value []byte) error {
+	return t.Txn.Put(ctx, key, t.ds.encode(value))
+}
+
+func (t *compressingTxn) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	stored, err := t.Txn.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return t.ds.decode(stored)
+}
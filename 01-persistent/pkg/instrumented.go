@@ -0,0 +1,258 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
+)
+
+// Instrumented operations
+const (
+	OpPut     = "put"
+	OpGet     = "get"
+	OpHas     = "has"
+	OpGetSize = "getsize"
+	OpDelete  = "delete"
+)
+
+// DefaultSlowOpThreshold is the latency above which an operation is reported
+const DefaultSlowOpThreshold = 250 * time.Millisecond
+
+// SlowOp describes a datastore operation that exceeded the slow-op threshold
+type SlowOp struct {
+	Backend  PersistentType
+	Op       string
+	Key      string
+	Size     int
+	Duration time.Duration
+	Err      error
+}
+
+// Option configures a PersistentWrapper
+type Option func(*options)
+
+type options struct {
+	slowOpThreshold time.Duration
+	onSlowOp        func(SlowOp)
+	compression     Compression
+	compressionMin  int
+	quota           *Quota
+}
+
+// WithSlowOpThreshold sets the latency above which operations are reported.
+// Zero disables slow-op reporting.
+func WithSlowOpThreshold(d time.Duration) Option {
+	return func(o *options) { o.slowOpThreshold = d }
+}
+
+// WithSlowOpHook replaces the default slow-op logger
+func WithSlowOpHook(fn func(SlowOp)) Option {
+	return func(o *options) { o.onSlowOp = fn }
+}
+
+func defaultOptions() options {
+	return options{
+		slowOpThreshold: DefaultSlowOpThreshold,
+		onSlowOp:        logSlowOp,
+		compression:     CompressionNone,
+		compressionMin:  DefaultCompressionThreshold,
+	}
+}
+
+func logSlowOp(op SlowOp) {
+	ev := log.Warn().
+		Str("backend", string(op.Backend)).
+		Str("op", op.Op).
+		Str("key", op.Key).
+		Int("size", op.Size).
+		Dur("duration", op.Duration)
+	if op.Err != nil {
+		ev = ev.Err(op.Err)
+	}
+	ev.Msg("slow datastore operation")
+}
+
+// OpStats holds the counters and histograms for one operation type
+type OpStats struct {
+	Metrics metrics.MetricsSnapshot   `json:"metrics"`
+	Latency metrics.HistogramSnapshot `json:"latency_ms"`
+	Size    metrics.HistogramSnapshot `json:"size_bytes"`
+}
+
+type opMetrics struct {
+	component *metrics.ComponentMetrics
+	latency   *metrics.Histogram
+	size      *metrics.Histogram
+}
+
+// instrumentedDatastore records per-operation metrics for a backend and
+// reports operations slower than the configured threshold.
+type instrumentedDatastore struct {
+	child   ds.Batching
+	backend PersistentType
+	ops     map[string]*opMetrics
+	opts    options
+}
+
+// instanceSeq numbers datastores so two of the same backend keep separate
+// global metrics
+var instanceSeq atomic.Int64
+
+var _ ds.Batching = (*instrumentedDatastore)(nil)
+var _ ds.PersistentDatastore = (*instrumentedDatastore)(nil)
+
+func newInstrumentedDatastore(child ds.Batching, backend PersistentType, opts options) *instrumentedDatastore {
+	d := &instrumentedDatastore{
+		child:   child,
+		backend: backend,
+		ops:     make(map[string]*opMetrics),
+		opts:    opts,
+	}
+	instance := instanceSeq.Add(1)
+	for _, op := range []string{OpPut, OpGet, OpHas, OpGetSize, OpDelete} {
+		component := metrics.NewComponentMetrics(fmt.Sprintf("persistent.%s.%d.%s", backend, instance, op))
+		metrics.RegisterGlobalComponent(component)
+		d.ops[op] = &opMetrics{
+			component: component,
+			latency:   metrics.NewHistogram(metrics.LatencyBucketsMs),
+			size:      metrics.NewHistogram(metrics.SizeBucketsBytes),
+		}
+	}
+	return d
+}
+
+func (d *instrumentedDatastore) record(op string, key ds.Key, size int, start time.Time, err error) {
+	elapsed := time.Since(start)
+	m := d.ops[op]
+	m.component.RecordRequest()
+	m.latency.ObserveDuration(elapsed)
+
+	switch {
+	case err == nil:
+		m.component.RecordSuccess(elapsed, int64(size))
+		m.size.Observe(float64(size))
+	case errors.Is(err, ds.ErrNotFound):
+		m.component.RecordFailure(elapsed, "not_found")
+	default:
+		m.component.RecordFailure(elapsed, "error")
+	}
+
+	if d.opts.slowOpThreshold > 0 && elapsed >= d.opts.slowOpThreshold && d.opts.onSlowOp != nil {
+		d.opts.onSlowOp(SlowOp{
+			Backend:  d.backend,
+			Op:       op,
+			Key:      key.String(),
+			Size:     size,
+			Duration: elapsed,
+			Err:      err,
+		})
+	}
+}
+
+func (d *instrumentedDatastore) stats() map[string]OpStats {
+	out := make(map[string]OpStats, len(d.ops))
+	for op, m := range d.ops {
+		out[op] = OpStats{
+			Metrics: m.component.GetSnapshot(),
+			Latency: m.latency.Snapshot(),
+			Size:    m.size.Snapshot(),
+		}
+	}
+	return out
+}
+
+func (d *instrumentedDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	start := time.Now()
+	value, err := d.child.Get(ctx, key)
+	d.record(OpGet, key, len(value), start, err)
+	return value, err
+}
+
+func (d *instrumentedDatastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	start := time.Now()
+	exists, err := d.child.Has(ctx, key)
+	d.record(OpHas, key, 0, start, err)
+	return exists, err
+}
+
+func (d *instrumentedDatastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	start := time.Now()
+	size, err := d.child.GetSize(ctx, key)
+	d.record(OpGetSize, key, 0, start, err)
+	return size, err
+}
+
+func (d *instrumentedDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+func (d *instrumentedDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	start := time.Now()
+	err := d.child.Put(ctx, key, value)
+	d.record(OpPut, key, len(value), start, err)
+	return err
+}
+
+func (d *instrumentedDatastore) Delete(ctx context.Context, key ds.Key) error {
+	start := time.Now()
+	err := d.child.Delete(ctx, key)
+	d.record(OpDelete, key, 0, start, err)
+	return err
+}
+
+func (d *instrumentedDatastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *instrumentedDatastore) Close() error {
+	for _, m := range d.ops {
+		metrics.UnregisterGlobalComponent(m.component.ComponentName)
+	}
+	return d.child.Close()
+}
+
+func (d *instrumentedDatastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *instrumentedDatastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedBatch{Batch: b, ds: d}, nil
+}
+
+// Children returns the wrapped datastore
+func (d *instrumentedDatastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// instrumentedBatch counts batched puts and deletes under the same metrics.
+// Latency covers only staging; the write happens on Commit.
+type instrumentedBatch struct {
+	ds.Batch
+	ds *instrumentedDatastore
+}
+
+func (b *instrumentedBatch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	start := time.Now()
+	err := b.Batch.Put(ctx, key, value)
+	b.ds.record(OpPut, key, len(value), start, err)
+	return err
+}
+
+func (b *instrumentedBatch) Delete(ctx context.Context, key ds.Key) error {
+	start := time.Now()
+	err := b.Batch.Delete(ctx, key)
+	b.ds.record(OpDelete, key, 0, start, err)
+	return err
+}
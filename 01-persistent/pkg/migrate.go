@@ -0,0 +1,238 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	mh "github.com/multiformats/go-multihash"
+	"golang.org/x/time/rate"
+)
+
+// migrationCheckpointKey is stored in the destination so an interrupted
+// migration can pick up after the last fully copied batch.
+var migrationCheckpointKey = ds.NewKey("/local/migration/checkpoint")
+
+type MigrateOptions struct {
+	Concurrency    int   // parallel copy workers (default 4)
+	BatchSize      int   // keys per checkpoint (default 256)
+	RateLimitBytes int64 // max bytes/sec copied; 0 = unlimited
+	Resume         bool  // continue from the destination's checkpoint
+
+	// OnProgress is called after every batch and once at the end.
+	OnProgress func(MigrateProgress)
+}
+
+type MigrateProgress struct {
+	Scanned  int64
+	Copied   int64
+	Skipped  int64 // already present in dst or before the checkpoint
+	Corrupt  int64 // data did not hash to its key
+	Bytes    int64
+	LastKey  string
+	Elapsed  time.Duration
+	Finished bool
+}
+
+type MigrateResult struct {
+	MigrateProgress
+	CorruptCIDs []cid.Cid
+}
+
+// Migrate streams every block from src into dst in key order, re-hashing
+// each block against its key before writing it. Progress is checkpointed
+// in dst after each batch so that Resume can skip completed work.
+func Migrate(ctx context.Context, src, dst *PersistentWrapper, opts MigrateOptions) (*MigrateResult, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 256
+	}
+
+	var limiter *rate.Limiter
+	if opts.RateLimitBytes > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RateLimitBytes), int(opts.RateLimitBytes))
+	}
+
+	var checkpoint string
+	if opts.Resume {
+		v, err := dst.batching.Get(ctx, migrationCheckpointKey)
+		switch {
+		case err == nil:
+			checkpoint = string(v)
+		case err != ds.ErrNotFound:
+			return nil, fmt.Errorf("read checkpoint: %w", err)
+		}
+	}
+
+	results, err := src.batching.Query(ctx, query.Query{
+		Prefix: blockstore.BlockPrefix.String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query source: %w", err)
+	}
+	defer results.Close()
+
+	start := time.Now()
+	res := &MigrateResult{}
+	var mu sync.Mutex
+
+	copyOne := func(e query.Entry) error {
+		c, ok := verifyEntry(e)
+		if !ok {
+			mu.Lock()
+			res.Corrupt++
+			if c.Defined() {
+				res.CorruptCIDs = append(res.CorruptCIDs, c)
+			}
+			mu.Unlock()
+			return nil
+		}
+
+		if opts.Resume {
+			has, err := dst.Has(ctx, c)
+			if err != nil {
+				return err
+			}
+			if has {
+				mu.Lock()
+				res.Skipped++
+				mu.Unlock()
+				return nil
+			}
+		}
+
+		if err := waitBytes(ctx, limiter, len(e.Value)); err != nil {
+			return err
+		}
+		if err := dst.PutWithCID(ctx, e.Value, c); err != nil {
+			return fmt.Errorf("put %s: %w", c, err)
+		}
+
+		mu.Lock()
+		res.Copied++
+		res.Bytes += int64(len(e.Value))
+		mu.Unlock()
+		return nil
+	}
+
+	flush := func(batch []query.Entry) error {
+		sem := make(chan struct{}, opts.Concurrency)
+		errCh := make(chan error, len(batch))
+		var wg sync.WaitGroup
+		for _, e := range batch {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(e query.Entry) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := copyOne(e); err != nil {
+					errCh <- err
+				}
+			}(e)
+		}
+		wg.Wait()
+		close(errCh)
+		if err := <-errCh; err != nil {
+			return err
+		}
+
+		last := batch[len(batch)-1].Key
+		if err := dst.batching.Put(ctx, migrationCheckpointKey, []byte(last)); err != nil {
+			return fmt.Errorf("write checkpoint: %w", err)
+		}
+		res.LastKey = last
+		res.Elapsed = time.Since(start)
+		if opts.OnProgress != nil {
+			opts.OnProgress(res.MigrateProgress)
+		}
+		return nil
+	}
+
+	batch := make([]query.Entry, 0, opts.BatchSize)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return res, fmt.Errorf("iterate source: %w", r.Error)
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		res.Scanned++
+		if checkpoint != "" && r.Key <= checkpoint {
+			res.Skipped++
+			continue
+		}
+
+		batch = append(batch, r.Entry)
+		if len(batch) == opts.BatchSize {
+			if err := flush(batch); err != nil {
+				return res, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(batch); err != nil {
+			return res, err
+		}
+	}
+
+	if err := dst.batching.Delete(ctx, migrationCheckpointKey); err != nil {
+		return res, fmt.Errorf("clear checkpoint: %w", err)
+	}
+
+	res.Elapsed = time.Since(start)
+	res.Finished = true
+	if opts.OnProgress != nil {
+		opts.OnProgress(res.MigrateProgress)
+	}
+	return res, nil
+}
+
+// verifyEntry decodes the multihash from a blockstore key and checks that the
+// value hashes to it. The returned CID is CIDv1/raw, as the blockstore only
+// records the multihash.
+func verifyEntry(e query.Entry) (cid.Cid, bool) {
+	k := strings.TrimPrefix(e.Key, blockstore.BlockPrefix.String())
+	hash, err := dshelp.DsKeyToMultihash(ds.NewKey(k))
+	if err != nil {
+		return cid.Undef, false
+	}
+	c := cid.NewCidV1(cid.Raw, hash)
+
+	dec, err := mh.Decode(hash)
+	if err != nil {
+		return c, false
+	}
+	sum, err := mh.Sum(e.Value, dec.Code, dec.Length)
+	if err != nil {
+		return c, false
+	}
+	return c, bytes.Equal(sum, hash)
+}
+
+// waitBytes blocks until the limiter allows n bytes, in burst-sized steps so
+// blocks larger than one second of budget still pass.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		step := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
@@ -1,13 +1,13 @@
 package persistent
 
 import (
+	"context"
+	"fmt"
 	"os"
 
 	ds "github.com/ipfs/go-datastore"
-	"github.com/ipfs/go-datastore/examples"
-	dssync "github.com/ipfs/go-datastore/sync"
-	badgerds "github.com/ipfs/go-ds-badger"
-	pebbleds "github.com/ipfs/go-ds-pebble"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
 
 	block "github.com/gosuda/boxo-starter-kit/00-block-cid/pkg"
 )
@@ -21,12 +21,34 @@ const (
 	Pebbledb PersistentType = "pebbledb"
 )
 
+// Suggested prefixes for subsystems sharing one datastore via WithNamespace.
+const (
+	NamespaceBlocks = "blocks"
+	NamespaceDHT    = "dht"
+	NamespaceIPNS   = "ipns"
+	NamespacePins   = "pins"
+)
+
 type PersistentWrapper struct {
 	batching ds.Batching
 	*block.BlockWrapper
+
+	// metrics and compression are shared with namespaced views.
+	metrics     *instrumentedDatastore
+	compression *compressingDatastore
+	quota       *quotaDatastore
+
+	// parent is set on namespaced views; the parent owns the datastore.
+	parent *PersistentWrapper
+	// prefix is the accumulated namespace of a view, relative to the root.
+	prefix ds.Key
 }
 
-func New(ptype PersistentType, path string) (*PersistentWrapper, error) {
+func New(ptype PersistentType, path string, opts ...Option) (*PersistentWrapper, error) {
+	cfg := defaultOptions()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	if path == "" {
 		path = os.TempDir() + string(ptype)
 	}
@@ -38,35 +60,77 @@ func New(ptype PersistentType, path string) (*PersistentWrapper, error) {
 		return nil, err
 	}
 
-	switch ptype {
-	case Memory:
-		batching = dssync.MutexWrap(ds.NewMapDatastore())
-	case File:
-		datastore, err := examples.NewDatastore(path)
-		if err != nil {
-			return nil, err
-		}
-		batching = datastore.(*examples.Datastore)
-	case Badgerdb:
-		batching, err = badgerds.NewDatastore(path, nil)
+	factory, ok := lookupFactory(ptype)
+	if !ok {
+		return nil, fmt.Errorf("unknown persistent type %q (registered: %v)", ptype, Registered())
+	}
+	batching, err = factory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	compress := cfg.compression != "" && cfg.compression != CompressionNone
+	if err := checkCompressionFormat(context.Background(), batching, compress); err != nil {
+		batching.Close()
+		return nil, err
+	}
+
+	var compressing *compressingDatastore
+	if compress {
+		compressing, err = newCompressingDatastore(batching, cfg.compression, cfg.compressionMin)
 		if err != nil {
+			batching.Close()
 			return nil, err
 		}
-	case Pebbledb:
-		batching, err = pebbleds.NewDatastore(path, nil)
+		batching = compressing
+	}
+
+	var quota *quotaDatastore
+	if cfg.quota != nil {
+		quota, err = newQuotaDatastore(context.Background(), batching, *cfg.quota)
 		if err != nil {
+			batching.Close()
 			return nil, err
 		}
+		batching = quota
 	}
-	blockWrapper := block.New(batching)
+
+	instrumented := newInstrumentedDatastore(batching, ptype, cfg)
+	blockWrapper := block.New(instrumented)
 
 	return &PersistentWrapper{
-		batching:     batching,
+		batching:     instrumented,
 		BlockWrapper: blockWrapper,
+		metrics:      instrumented,
+		compression:  compressing,
+		quota:        quota,
 	}, nil
 }
 
+// WithNamespace returns a view of p whose keys all live under /prefix, so
+// DHT records, blocks, IPNS and pins can share one Badger/Pebble directory.
+// Closing a view is a no-op; only the root wrapper closes the datastore.
+func (p *PersistentWrapper) WithNamespace(prefix string) *PersistentWrapper {
+	root := p
+	if p.parent != nil {
+		root = p.parent
+	}
+	nsds := namespace.Wrap(p.batching, ds.NewKey(prefix))
+	return &PersistentWrapper{
+		batching:     nsds,
+		BlockWrapper: block.New(nsds),
+		metrics:      p.metrics,
+		compression:  p.compression,
+		quota:        p.quota,
+		parent:       root,
+		prefix:       p.prefix.Child(ds.NewKey(prefix)),
+	}
+}
+
 func (p *PersistentWrapper) Close() error {
+	if p.parent != nil {
+		return nil
+	}
 	return p.batching.Close()
 }
 
@@ -74,3 +138,44 @@ func (p *PersistentWrapper) Close() error {
 func (p *PersistentWrapper) Datastore() ds.Datastore {
 	return p.batching
 }
+
+// GetMetrics returns per-operation counters and latency/size histograms
+// for the backend, keyed by OpPut, OpGet, OpHas, OpGetSize and OpDelete.
+func (p *PersistentWrapper) GetMetrics() map[string]OpStats {
+	return p.metrics.stats()
+}
+
+// CompressionStats reports compression savings since the wrapper was opened.
+// Codec is CompressionNone when compression is disabled.
+func (p *PersistentWrapper) CompressionStats() CompressionStats {
+	if p.compression == nil {
+		return CompressionStats{Codec: CompressionNone}
+	}
+	return p.compression.stats()
+}
+
+// Stats reports block usage across all namespaces. With a quota configured
+// it is tracked on every write; otherwise the datastore is scanned.
+func (p *PersistentWrapper) Stats() (UsageStats, error) {
+	if p.quota != nil {
+		return p.quota.stats(), nil
+	}
+
+	results, err := p.metrics.Query(context.Background(), query.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		return UsageStats{}, err
+	}
+	defer results.Close()
+
+	var stats UsageStats
+	for r := range results.Next() {
+		if r.Error != nil {
+			return UsageStats{}, fmt.Errorf("scan usage: %w", r.Error)
+		}
+		if isBlockKey(ds.RawKey(r.Key)) {
+			stats.Blocks++
+			stats.Bytes += int64(r.Size)
+		}
+	}
+	return stats, nil
+}
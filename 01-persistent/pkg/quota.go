@@ -0,0 +1,435 @@
+package persistent
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/boxo/blockstore"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ErrQuotaExceeded is returned when a write would exceed the quota and the
+// policy cannot (or may not) free enough space.
+var ErrQuotaExceeded = errors.New("persistent: quota exceeded")
+
+type QuotaPolicy string
+
+const (
+	// QuotaReject fails writes that would exceed the quota
+	QuotaReject QuotaPolicy = "error"
+	// QuotaEvictUnpinned deletes unpinned blocks, oldest written first
+	QuotaEvictUnpinned QuotaPolicy = "gc-unpinned"
+	// QuotaEvictLRU deletes the least recently read or written blocks
+	QuotaEvictLRU QuotaPolicy = "lru"
+)
+
+// PinChecker reports whether a block must be kept regardless of quota or TTL
+type PinChecker func(ctx context.Context, hash mh.Multihash) (bool, error)
+
+// Quota limits the blocks kept by a wrapper. Zero limits are unlimited.
+// Only keys under /blocks count, in any namespace.
+type Quota struct {
+	MaxBytes  int64
+	MaxBlocks int64
+	Policy    QuotaPolicy
+
+	// Pinned protects blocks from eviction. Required for QuotaEvictUnpinned;
+	// optional for QuotaEvictLRU. It may read the store.
+	Pinned PinChecker
+}
+
+// WithQuota enforces q on every write
+func WithQuota(q Quota) Option {
+	return func(o *options) { o.quota = &q }
+}
+
+// UsageStats reports the blocks currently stored and quota activity
+type UsageStats struct {
+	Blocks    int64       `json:"blocks"`
+	Bytes     int64       `json:"bytes"`
+	MaxBlocks int64       `json:"max_blocks,omitempty"`
+	MaxBytes  int64       `json:"max_bytes,omitempty"`
+	Policy    QuotaPolicy `json:"policy,omitempty"`
+	Evicted   int64       `json:"evicted"`
+	Rejected  int64       `json:"rejected"`
+}
+
+type quotaEntry struct {
+	key  ds.Key
+	size int64
+}
+
+// quotaDatastore tracks block usage in memory and enforces the quota on
+// writes. Usage is rebuilt from a key scan when the wrapper is opened.
+type quotaDatastore struct {
+	child ds.Batching
+	quota Quota
+
+	mu      sync.Mutex
+	entries map[ds.Key]*list.Element // front = most recently used/written
+	order   *list.List
+	bytes   int64
+
+	evicted  int64
+	rejected int64
+}
+
+var _ ds.Batching = (*quotaDatastore)(nil)
+var _ ds.PersistentDatastore = (*quotaDatastore)(nil)
+
+func newQuotaDatastore(ctx context.Context, child ds.Batching, q Quota) (*quotaDatastore, error) {
+	switch q.Policy {
+	case "":
+		q.Policy = QuotaReject
+	case QuotaReject, QuotaEvictLRU:
+	case QuotaEvictUnpinned:
+		if q.Pinned == nil {
+			return nil, fmt.Errorf("quota policy %q requires a Pinned callback", q.Policy)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported quota policy %q", q.Policy)
+	}
+
+	d := &quotaDatastore{
+		child:   child,
+		quota:   q,
+		entries: make(map[ds.Key]*list.Element),
+		order:   list.New(),
+	}
+
+	results, err := child.Query(ctx, query.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		return nil, fmt.Errorf("scan usage: %w", err)
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("scan usage: %w", r.Error)
+		}
+		key := ds.RawKey(r.Key)
+		if isBlockKey(key) {
+			d.track(key, int64(r.Size))
+		}
+	}
+	return d, nil
+}
+
+func isBlockKey(key ds.Key) bool {
+	return key.Parent().BaseNamespace() == blockstore.BlockPrefix.BaseNamespace()
+}
+
+// track records key as most recent, returning the previous size if known
+func (d *quotaDatastore) track(key ds.Key, size int64) {
+	if el, ok := d.entries[key]; ok {
+		e := el.Value.(*quotaEntry)
+		d.bytes += size - e.size
+		e.size = size
+		d.order.MoveToFront(el)
+		return
+	}
+	d.entries[key] = d.order.PushFront(&quotaEntry{key: key, size: size})
+	d.bytes += size
+}
+
+func (d *quotaDatastore) untrack(key ds.Key) {
+	if el, ok := d.entries[key]; ok {
+		d.bytes -= el.Value.(*quotaEntry).size
+		d.order.Remove(el)
+		delete(d.entries, key)
+	}
+}
+
+func (d *quotaDatastore) fits(key ds.Key, size int64) bool {
+	blocks, bytes := int64(len(d.entries)), d.bytes+size
+	if el, ok := d.entries[key]; ok {
+		bytes -= el.Value.(*quotaEntry).size
+	} else {
+		blocks++
+	}
+	if d.quota.MaxBlocks > 0 && blocks > d.quota.MaxBlocks {
+		return false
+	}
+	return d.quota.MaxBytes <= 0 || bytes <= d.quota.MaxBytes
+}
+
+// reservation is the accounting of a key before reserve changed it, so a
+// failed write can put it back
+type reservation struct {
+	key     ds.Key
+	size    int64
+	tracked bool
+}
+
+// reserve makes room for key and accounts for it. Callers hold d.mu;
+// evict releases it while asking the Pinned callback.
+func (d *quotaDatastore) reserve(ctx context.Context, key ds.Key, size int64) (reservation, error) {
+	r := reservation{key: key}
+	if !isBlockKey(key) {
+		return r, nil
+	}
+	if !d.fits(key, size) && d.quota.Policy != QuotaReject {
+		if err := d.evict(ctx, key, size); err != nil {
+			return r, err
+		}
+	}
+	if !d.fits(key, size) {
+		d.rejected++
+		return r, fmt.Errorf("%w: %s (%d bytes)", ErrQuotaExceeded, key, size)
+	}
+	if el, ok := d.entries[key]; ok {
+		r.size, r.tracked = el.Value.(*quotaEntry).size, true
+	}
+	d.track(key, size)
+	return r, nil
+}
+
+// release undoes a reservation whose write failed: a key that was already
+// stored keeps its old size. Callers hold d.mu.
+func (d *quotaDatastore) release(r reservation) {
+	if r.tracked {
+		d.track(r.key, r.size)
+		return
+	}
+	d.untrack(r.key)
+}
+
+// evict deletes blocks from the back of the order until key fits. Callers
+// hold d.mu; it is released while the Pinned callback runs, which may
+// read the store.
+func (d *quotaDatastore) evict(ctx context.Context, key ds.Key, size int64) error {
+	var candidates []ds.Key
+	for el := d.order.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*quotaEntry); e.key != key {
+			candidates = append(candidates, e.key)
+		}
+	}
+	for _, k := range candidates {
+		if d.fits(key, size) {
+			break
+		}
+		if _, ok := d.entries[k]; !ok {
+			continue
+		}
+		if d.quota.Pinned != nil {
+			hash, err := dshelp.DsKeyToMultihash(ds.NewKey(k.BaseNamespace()))
+			if err != nil {
+				continue
+			}
+			d.mu.Unlock()
+			pinned, err := d.quota.Pinned(ctx, hash)
+			d.mu.Lock()
+			if err != nil {
+				return fmt.Errorf("check pin for %s: %w", k, err)
+			}
+			if _, ok := d.entries[k]; pinned || !ok {
+				continue
+			}
+		}
+		if err := d.child.Delete(ctx, k); err != nil {
+			return fmt.Errorf("evict %s: %w", k, err)
+		}
+		d.untrack(k)
+		d.evicted++
+	}
+	return nil
+}
+
+func (d *quotaDatastore) stats() UsageStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return UsageStats{
+		Blocks:    int64(len(d.entries)),
+		Bytes:     d.bytes,
+		MaxBlocks: d.quota.MaxBlocks,
+		MaxBytes:  d.quota.MaxBytes,
+		Policy:    d.quota.Policy,
+		Evicted:   d.evicted,
+		Rejected:  d.rejected,
+	}
+}
+
+func (d *quotaDatastore) touch(key ds.Key) {
+	if d.quota.Policy != QuotaEvictLRU {
+		return
+	}
+	d.mu.Lock()
+	if el, ok := d.entries[key]; ok {
+		d.order.MoveToFront(el)
+	}
+	d.mu.Unlock()
+}
+
+func (d *quotaDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	value, err := d.child.Get(ctx, key)
+	if err == nil {
+		d.touch(key)
+	}
+	return value, err
+}
+
+func (d *quotaDatastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	return d.child.Has(ctx, key)
+}
+
+func (d *quotaDatastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	return d.child.GetSize(ctx, key)
+}
+
+func (d *quotaDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+func (d *quotaDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, err := d.reserve(ctx, key, int64(len(value)))
+	if err != nil {
+		return err
+	}
+	if err := d.child.Put(ctx, key, value); err != nil {
+		d.release(r)
+		return err
+	}
+	return nil
+}
+
+func (d *quotaDatastore) Delete(ctx context.Context, key ds.Key) error {
+	if err := d.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.untrack(key)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *quotaDatastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *quotaDatastore) Close() error {
+	return d.child.Close()
+}
+
+func (d *quotaDatastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *quotaDatastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaBatch{Batch: b, ds: d}, nil
+}
+
+// Children returns the wrapped datastore
+func (d *quotaDatastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+func (d *quotaDatastore) inner() ds.Batching {
+	return d.child
+}
+
+func (d *quotaDatastore) wrapTxn(t ds.Txn) ds.Txn {
+	return &quotaTxn{Txn: t, ds: d}
+}
+
+// quotaBatch reserves space as puts are staged and releases it if the
+// commit fails.
+type quotaBatch struct {
+	ds.Batch
+	ds      *quotaDatastore
+	puts    []reservation
+	deletes []ds.Key
+}
+
+func (b *quotaBatch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	b.ds.mu.Lock()
+	r, err := b.ds.reserve(ctx, key, int64(len(value)))
+	b.ds.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	b.puts = append(b.puts, r)
+	return b.Batch.Put(ctx, key, value)
+}
+
+func (b *quotaBatch) Delete(ctx context.Context, key ds.Key) error {
+	b.deletes = append(b.deletes, key)
+	return b.Batch.Delete(ctx, key)
+}
+
+func (b *quotaBatch) Commit(ctx context.Context) error {
+	err := b.Batch.Commit(ctx)
+	b.ds.mu.Lock()
+	defer b.ds.mu.Unlock()
+	if err != nil {
+		for i := len(b.puts) - 1; i >= 0; i-- {
+			b.ds.release(b.puts[i])
+		}
+		return err
+	}
+	for _, key := range b.deletes {
+		b.ds.untrack(key)
+	}
+	return nil
+}
+
+// quotaTxn is the transactional counterpart of quotaBatch
+type quotaTxn struct {
+	ds.Txn
+	ds      *quotaDatastore
+	puts    []reservation
+	deletes []ds.Key
+}
+
+func (t *quotaTxn) Put(ctx context.Context, key ds.Key, value []byte) error {
+	t.ds.mu.Lock()
+	r, err := t.ds.reserve(ctx, key, int64(len(value)))
+	t.ds.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	t.puts = append(t.puts, r)
+	return t.Txn.Put(ctx, key, value)
+}
+
+func (t *quotaTxn) Delete(ctx context.Context, key ds.Key) error {
+	t.deletes = append(t.deletes, key)
+	return t.Txn.Delete(ctx, key)
+}
+
+func (t *quotaTxn) Commit(ctx context.Context) error {
+	if err := t.Txn.Commit(ctx); err != nil {
+		t.release()
+		return err
+	}
+	t.ds.mu.Lock()
+	defer t.ds.mu.Unlock()
+	t.puts = nil // written; a later Discard must not release them
+	for _, key := range t.deletes {
+		t.ds.untrack(key)
+	}
+	return nil
+}
+
+func (t *quotaTxn) Discard(ctx context.Context) {
+	t.Txn.Discard(ctx)
+	t.release()
+}
+
+func (t *quotaTxn) release() {
+	t.ds.mu.Lock()
+	defer t.ds.mu.Unlock()
+	for i := len(t.puts) - 1; i >= 0; i-- {
+		t.ds.release(t.puts[i])
+	}
+}
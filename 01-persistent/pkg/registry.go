@@ -0,0 +1,80 @@
+package persistent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/examples"
+	dssync "github.com/ipfs/go-datastore/sync"
+	badgerds "github.com/ipfs/go-ds-badger"
+	pebbleds "github.com/ipfs/go-ds-pebble"
+)
+
+// Factory opens a datastore rooted at path. New creates the directory
+// before calling it.
+type Factory func(path string) (ds.Batching, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[PersistentType]Factory)
+)
+
+func init() {
+	Register(Memory, func(string) (ds.Batching, error) {
+		return dssync.MutexWrap(ds.NewMapDatastore()), nil
+	})
+	Register(File, func(path string) (ds.Batching, error) {
+		datastore, err := examples.NewDatastore(path)
+		if err != nil {
+			return nil, err
+		}
+		return datastore.(*examples.Datastore), nil
+	})
+	Register(Badgerdb, func(path string) (ds.Batching, error) {
+		return badgerds.NewDatastore(path, nil)
+	})
+	Register(Pebbledb, func(path string) (ds.Batching, error) {
+		return pebbleds.NewDatastore(path, nil)
+	})
+}
+
+// Register makes a datastore implementation available to New under name,
+// e.g. from an init function in a downstream package:
+//
+//	persistent.Register("leveldb", func(path string) (ds.Batching, error) {
+//		return leveldb.NewDatastore(path, nil)
+//	})
+//
+// Like database/sql.Register, it panics if factory is nil or name is taken.
+func Register(name PersistentType, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("persistent: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("persistent: Register called twice for %q", name))
+	}
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of all available datastore types
+func Registered() []PersistentType {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]PersistentType, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func lookupFactory(name PersistentType) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[name]
+	return f, ok
+}
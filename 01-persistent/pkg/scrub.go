@@ -0,0 +1,207 @@
+package persistent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/time/rate"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
+)
+
+// NamespaceQuarantine holds blocks that failed verification, keyed like
+// /blocks so they can be inspected or restored by hand.
+const NamespaceQuarantine = "quarantine"
+
+// DefaultScrubInterval is the pause between background scrub passes
+const DefaultScrubInterval = 24 * time.Hour
+
+type ScrubOptions struct {
+	RateLimitBytes int64         // max bytes/sec re-hashed; 0 = unlimited
+	Interval       time.Duration // pause between passes (default 24h)
+
+	// OnResult is called after every pass
+	OnResult func(ScrubResult)
+}
+
+type ScrubResult struct {
+	Scanned     int64
+	Bytes       int64
+	Corrupt     int64
+	Quarantined []cid.Cid
+	Started     time.Time
+	Elapsed     time.Duration
+	Err         error
+}
+
+// Scrubber runs scrub passes in the background until its context ends
+type Scrubber struct {
+	p    *PersistentWrapper
+	opts ScrubOptions
+
+	mu     sync.RWMutex
+	last   *ScrubResult
+	passes int64
+	done   chan struct{}
+}
+
+// Scrub starts a background job that re-hashes every stored block against
+// its CID, moving mismatches into NamespaceQuarantine. The first pass starts
+// immediately.
+func (p *PersistentWrapper) Scrub(ctx context.Context, opts ScrubOptions) *Scrubber {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultScrubInterval
+	}
+	s := &Scrubber{p: p, opts: opts, done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			res := p.ScrubOnce(ctx, opts)
+			if ctx.Err() != nil {
+				return
+			}
+			s.mu.Lock()
+			s.last = &res
+			s.passes++
+			s.mu.Unlock()
+			if opts.OnResult != nil {
+				opts.OnResult(res)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// Last returns the most recent completed pass
+func (s *Scrubber) Last() (ScrubResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.last == nil {
+		return ScrubResult{}, false
+	}
+	return *s.last, true
+}
+
+// Done is closed once the background job has stopped
+func (s *Scrubber) Done() <-chan struct{} {
+	return s.done
+}
+
+// HealthCheck reports the last pass: unhealthy if it found corruption,
+// degraded if it failed, unknown before the first pass completes.
+func (s *Scrubber) HealthCheck() health.HealthChecker {
+	const name = "persistent-scrub"
+	return health.NewHealthCheckFunc(name, func(ctx context.Context) health.CheckResult {
+		result := health.CheckResult{
+			ComponentName: name,
+			Status:        health.StatusUnknown,
+			Message:       "No scrub pass completed yet",
+			Metadata:      make(map[string]string),
+		}
+
+		s.mu.RLock()
+		last, passes := s.last, s.passes
+		s.mu.RUnlock()
+		if last == nil {
+			return result
+		}
+
+		result.Metadata["passes"] = fmt.Sprint(passes)
+		result.Metadata["scanned"] = fmt.Sprint(last.Scanned)
+		result.Metadata["corrupt"] = fmt.Sprint(last.Corrupt)
+		result.Metadata["last_run"] = last.Started.Format(time.RFC3339)
+
+		switch {
+		case last.Corrupt > 0:
+			result.Status = health.StatusUnhealthy
+			result.Message = fmt.Sprintf("%d corrupt blocks quarantined", last.Corrupt)
+		case last.Err != nil:
+			result.Status = health.StatusDegraded
+			result.Message = fmt.Sprintf("Scrub failed: %v", last.Err)
+			result.Metadata["error"] = last.Err.Error()
+		default:
+			result.Status = health.StatusHealthy
+			result.Message = fmt.Sprintf("%d blocks verified", last.Scanned)
+		}
+		return result
+	})
+}
+
+// ScrubOnce runs a single verification pass in the foreground
+func (p *PersistentWrapper) ScrubOnce(ctx context.Context, opts ScrubOptions) (res ScrubResult) {
+	res.Started = time.Now()
+	defer func() { res.Elapsed = time.Since(res.Started) }()
+
+	var limiter *rate.Limiter
+	if opts.RateLimitBytes > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RateLimitBytes), int(opts.RateLimitBytes))
+	}
+
+	results, err := p.batching.Query(ctx, query.Query{Prefix: blockstore.BlockPrefix.String()})
+	if err != nil {
+		res.Err = fmt.Errorf("query blocks: %w", err)
+		return res
+	}
+
+	// Quarantine after the scan so the iterator never sees its own deletes
+	var corrupt []query.Entry
+	for r := range results.Next() {
+		if r.Error != nil {
+			res.Err = fmt.Errorf("iterate blocks: %w", r.Error)
+			break
+		}
+		if err := waitBytes(ctx, limiter, len(r.Value)); err != nil {
+			res.Err = err
+			break
+		}
+		res.Scanned++
+		res.Bytes += int64(len(r.Value))
+		if _, ok := verifyEntry(r.Entry); !ok {
+			corrupt = append(corrupt, r.Entry)
+		}
+	}
+	results.Close()
+
+	for _, e := range corrupt {
+		res.Corrupt++
+		c, err := p.quarantine(ctx, e)
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		if c.Defined() {
+			res.Quarantined = append(res.Quarantined, c)
+		}
+	}
+	return res
+}
+
+func (p *PersistentWrapper) quarantine(ctx context.Context, e query.Entry) (cid.Cid, error) {
+	c, _ := verifyEntry(e)
+	rel := strings.TrimPrefix(e.Key, blockstore.BlockPrefix.String())
+	qkey := ds.NewKey(NamespaceQuarantine).Child(ds.NewKey(rel))
+
+	if err := p.batching.Put(ctx, qkey, e.Value); err != nil {
+		return c, fmt.Errorf("quarantine %s: %w", e.Key, err)
+	}
+	if err := p.batching.Delete(ctx, ds.RawKey(e.Key)); err != nil {
+		return c, fmt.Errorf("remove %s: %w", e.Key, err)
+	}
+	return c, nil
+}
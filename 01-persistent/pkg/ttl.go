@@ -0,0 +1,256 @@
+package persistent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	mh "github.com/multiformats/go-multihash"
+
+	block "github.com/gosuda/boxo-starter-kit/00-block-cid/pkg"
+)
+
+// NamespaceTTL holds the expiry time of blocks written with PutWithTTL
+const NamespaceTTL = "ttl"
+
+// DefaultSweepInterval is the pause between background TTL sweeps
+const DefaultSweepInterval = time.Minute
+
+func (p *PersistentWrapper) ttlKey(hash mh.Multihash) ds.Key {
+	return ds.NewKey(NamespaceTTL).Child(dshelp.MultihashToDsKey(hash))
+}
+
+// clearTTL makes a block permanent by dropping its TTL record, if any
+func (p *PersistentWrapper) clearTTL(ctx context.Context, hash mh.Multihash) error {
+	// Check first so ordinary puts do not write a tombstone each time
+	key := p.ttlKey(hash)
+	has, err := p.batching.Has(ctx, key)
+	if err != nil {
+		return fmt.Errorf("check ttl: %w", err)
+	}
+	if !has {
+		return nil
+	}
+	if err := p.batching.Delete(ctx, key); err != nil && !errors.Is(err, ds.ErrNotFound) {
+		return fmt.Errorf("clear ttl: %w", err)
+	}
+	return nil
+}
+
+// Put stores b permanently. A TTL set earlier by PutWithTTL is dropped so
+// the sweeper keeps the block.
+func (p *PersistentWrapper) Put(ctx context.Context, b blocks.Block) error {
+	if err := p.BlockWrapper.Put(ctx, b); err != nil {
+		return err
+	}
+	return p.clearTTL(ctx, b.Cid().Hash())
+}
+
+// PutMany is Put for several blocks
+func (p *PersistentWrapper) PutMany(ctx context.Context, bs []blocks.Block) error {
+	if err := p.BlockWrapper.PutMany(ctx, bs); err != nil {
+		return err
+	}
+	for _, b := range bs {
+		if err := p.clearTTL(ctx, b.Cid().Hash()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutV0Cid stores data permanently as a CIDv0 block
+func (p *PersistentWrapper) PutV0Cid(ctx context.Context, data []byte) (cid.Cid, error) {
+	c, err := p.BlockWrapper.PutV0Cid(ctx, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, p.clearTTL(ctx, c.Hash())
+}
+
+// PutV1Cid stores data permanently as a CIDv1 block
+func (p *PersistentWrapper) PutV1Cid(ctx context.Context, data []byte, prefix *cid.Prefix) (cid.Cid, error) {
+	c, err := p.BlockWrapper.PutV1Cid(ctx, data, prefix)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, p.clearTTL(ctx, c.Hash())
+}
+
+// PutWithCID stores data permanently under c
+func (p *PersistentWrapper) PutWithCID(ctx context.Context, data []byte, c cid.Cid) error {
+	if err := p.BlockWrapper.PutWithCID(ctx, data, c); err != nil {
+		return err
+	}
+	return p.clearTTL(ctx, c.Hash())
+}
+
+// PutWithTTL stores data as a CIDv1/raw block that the sweeper may remove
+// once ttl has passed. Storing the same block again only extends its expiry;
+// a block already stored without a TTL is left permanent, and storing a
+// block with any of the other Put methods makes it permanent.
+func (p *PersistentWrapper) PutWithTTL(ctx context.Context, data []byte, ttl time.Duration) (cid.Cid, error) {
+	c, err := block.ComputeCID(data, block.NewV1Prefix(0, 0, 0))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	expires := time.Now().Add(ttl)
+	current, ok, err := p.ExpiresAt(ctx, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if ok && current.After(expires) {
+		expires = current
+	}
+	if !ok {
+		has, err := p.Has(ctx, c)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if has {
+			return c, nil
+		}
+	}
+
+	if err := p.BlockWrapper.PutWithCID(ctx, data, c); err != nil {
+		return cid.Undef, err
+	}
+	buf := binary.BigEndian.AppendUint64(nil, uint64(expires.UnixNano()))
+	if err := p.batching.Put(ctx, p.ttlKey(c.Hash()), buf); err != nil {
+		return cid.Undef, fmt.Errorf("store ttl: %w", err)
+	}
+	return c, nil
+}
+
+// ExpiresAt returns when a block written with PutWithTTL expires. ok is
+// false for blocks stored without a TTL.
+func (p *PersistentWrapper) ExpiresAt(ctx context.Context, c cid.Cid) (time.Time, bool, error) {
+	v, err := p.batching.Get(ctx, p.ttlKey(c.Hash()))
+	switch {
+	case errors.Is(err, ds.ErrNotFound):
+		return time.Time{}, false, nil
+	case err != nil:
+		return time.Time{}, false, err
+	case len(v) != 8:
+		return time.Time{}, false, fmt.Errorf("corrupt ttl record for %s", c)
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true, nil
+}
+
+type SweepOptions struct {
+	Interval time.Duration // pause between sweeps (default 1m)
+	Pinned   PinChecker    // expired blocks that are pinned are kept
+
+	// OnSweep is called after every sweep
+	OnSweep func(SweepResult)
+}
+
+type SweepResult struct {
+	Scanned int64 // TTL records checked
+	Expired int64
+	Removed int64
+	Pinned  int64 // expired but kept because they are pinned
+	Err     error
+}
+
+// Sweeper removes expired blocks in the background until its context ends
+type Sweeper struct {
+	done chan struct{}
+}
+
+// Done is closed once the sweeper has stopped
+func (s *Sweeper) Done() <-chan struct{} {
+	return s.done
+}
+
+// StartSweeper launches a goroutine that calls SweepExpired every Interval
+func (p *PersistentWrapper) StartSweeper(ctx context.Context, opts SweepOptions) *Sweeper {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultSweepInterval
+	}
+	s := &Sweeper{done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			res := p.SweepExpired(ctx, opts.Pinned)
+			if opts.OnSweep != nil && ctx.Err() == nil {
+				opts.OnSweep(res)
+			}
+		}
+	}()
+	return s
+}
+
+// SweepExpired deletes every expired, unpinned block written with
+// PutWithTTL along with its TTL record.
+func (p *PersistentWrapper) SweepExpired(ctx context.Context, pinned PinChecker) SweepResult {
+	var res SweepResult
+	now := time.Now()
+
+	results, err := p.batching.Query(ctx, query.Query{Prefix: "/" + NamespaceTTL})
+	if err != nil {
+		res.Err = fmt.Errorf("query ttl records: %w", err)
+		return res
+	}
+	var expired []ds.Key
+	for r := range results.Next() {
+		if r.Error != nil {
+			res.Err = fmt.Errorf("iterate ttl records: %w", r.Error)
+			break
+		}
+		res.Scanned++
+		if len(r.Value) == 8 && time.Unix(0, int64(binary.BigEndian.Uint64(r.Value))).After(now) {
+			continue
+		}
+		expired = append(expired, ds.RawKey(r.Key))
+	}
+	results.Close()
+
+	for _, key := range expired {
+		res.Expired++
+		hash, err := dshelp.DsKeyToMultihash(ds.NewKey(strings.TrimPrefix(key.String(), "/"+NamespaceTTL)))
+		if err != nil {
+			res.Err = fmt.Errorf("decode %s: %w", key, err)
+			continue
+		}
+		if pinned != nil {
+			ok, err := pinned(ctx, hash)
+			if err != nil {
+				res.Err = fmt.Errorf("check pin for %s: %w", key, err)
+				continue
+			}
+			if ok {
+				res.Pinned++
+				continue
+			}
+		}
+
+		if err := p.Delete(ctx, cid.NewCidV1(cid.Raw, hash)); err != nil && !errors.Is(err, ds.ErrNotFound) {
+			res.Err = fmt.Errorf("delete expired block: %w", err)
+			continue
+		}
+		if err := p.batching.Delete(ctx, key); err != nil {
+			res.Err = fmt.Errorf("delete ttl record: %w", err)
+			continue
+		}
+		res.Removed++
+	}
+	return res
+}
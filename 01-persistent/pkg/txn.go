@@ -0,0 +1,216 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+// BlockTxn stages block writes that become visible together when the
+// surrounding Txn returns nil. Nothing is written if it returns an error.
+type BlockTxn interface {
+	Put(ctx context.Context, blk blocks.Block) error
+	PutMany(ctx context.Context, blks []blocks.Block) error
+	Delete(ctx context.Context, c cid.Cid) error
+	// Has reports staged puts and deletes before falling back to the store
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	// Atomic reports whether the backend commits all-or-nothing
+	Atomic() bool
+}
+
+// Txn runs fn and commits every block it staged in one step. Badger uses
+// a native transaction and Pebble an atomic write batch; memory and file
+// backends fall back to a best-effort batch that may persist partially if
+// the process dies mid-commit.
+func (p *PersistentWrapper) Txn(ctx context.Context, fn func(txn BlockTxn) error) error {
+	t, err := p.backendTxn(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if t != nil {
+		bt := &nativeTxn{p: p, txn: t}
+		if err := fn(bt); err != nil {
+			t.Discard(ctx)
+			return err
+		}
+		start := time.Now()
+		if err := t.Commit(ctx); err != nil {
+			return fmt.Errorf("commit transaction: %w", err)
+		}
+		bt.recordPuts(start)
+		return nil
+	}
+
+	bt := &stagedTxn{p: p, staged: make(map[ds.Key][]byte)}
+	if err := fn(bt); err != nil {
+		return err
+	}
+	return bt.commit(ctx)
+}
+
+// txnLayer is implemented by datastore layers that must also see writes
+// made inside a backend transaction.
+type txnLayer interface {
+	inner() ds.Batching
+	wrapTxn(t ds.Txn) ds.Txn
+}
+
+// backendTxn opens a native transaction wrapped by every layer above the
+// backend, or returns nil if the backend has none.
+func (p *PersistentWrapper) backendTxn(ctx context.Context) (ds.Txn, error) {
+	var layers []txnLayer
+	cur := p.metrics.child
+	for {
+		l, ok := cur.(txnLayer)
+		if !ok {
+			break
+		}
+		layers = append(layers, l)
+		cur = l.inner()
+	}
+
+	txnds, ok := cur.(ds.TxnDatastore)
+	if !ok {
+		return nil, nil
+	}
+	t, err := txnds.NewTransaction(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		t = layers[i].wrapTxn(t)
+	}
+	return t, nil
+}
+
+func (p *PersistentWrapper) blockKey(c cid.Cid) ds.Key {
+	return p.prefix.Child(blockstore.BlockPrefix.Child(dshelp.MultihashToDsKey(c.Hash())))
+}
+
+// nativeTxn writes through a backend transaction (Badger)
+type nativeTxn struct {
+	p    *PersistentWrapper
+	txn  ds.Txn
+	puts []putRecord
+}
+
+type putRecord struct {
+	key  ds.Key
+	size int
+}
+
+func (t *nativeTxn) Put(ctx context.Context, blk blocks.Block) error {
+	key := t.p.blockKey(blk.Cid())
+	if err := t.txn.Put(ctx, key, blk.RawData()); err != nil {
+		return err
+	}
+	t.puts = append(t.puts, putRecord{key: key, size: len(blk.RawData())})
+	return nil
+}
+
+func (t *nativeTxn) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := t.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *nativeTxn) Delete(ctx context.Context, c cid.Cid) error {
+	return t.txn.Delete(ctx, t.p.blockKey(c))
+}
+
+func (t *nativeTxn) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return t.txn.Has(ctx, t.p.blockKey(c))
+}
+
+func (t *nativeTxn) Atomic() bool { return true }
+
+func (t *nativeTxn) recordPuts(start time.Time) {
+	for _, r := range t.puts {
+		t.p.metrics.record(OpPut, r.key, r.size, start, nil)
+	}
+}
+
+// stagedTxn buffers writes in memory and flushes them through a batch on
+// commit. A nil value marks a staged delete.
+type stagedTxn struct {
+	p      *PersistentWrapper
+	staged map[ds.Key][]byte
+	order  []ds.Key
+}
+
+func (t *stagedTxn) stage(key ds.Key, value []byte) {
+	if _, ok := t.staged[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.staged[key] = value
+}
+
+func (t *stagedTxn) Put(ctx context.Context, blk blocks.Block) error {
+	t.stage(t.p.blockKey(blk.Cid()), blk.RawData())
+	return nil
+}
+
+func (t *stagedTxn) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := t.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *stagedTxn) Delete(ctx context.Context, c cid.Cid) error {
+	t.stage(t.p.blockKey(c), nil)
+	return nil
+}
+
+func (t *stagedTxn) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if v, ok := t.staged[t.p.blockKey(c)]; ok {
+		return v != nil, nil
+	}
+	return t.p.Has(ctx, c)
+}
+
+func (t *stagedTxn) Atomic() bool {
+	// Pebble commits a batch as a single atomic write
+	return t.p.metrics.backend == Pebbledb
+}
+
+func (t *stagedTxn) commit(ctx context.Context) error {
+	if len(t.order) == 0 {
+		return nil
+	}
+	// Bypass the namespace wrapper: keys already carry the view prefix
+	b, err := t.p.metrics.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("begin batch: %w", err)
+	}
+	for _, key := range t.order {
+		v := t.staged[key]
+		if v == nil {
+			err = b.Delete(ctx, key)
+			if errors.Is(err, ds.ErrNotFound) {
+				err = nil
+			}
+		} else {
+			err = b.Put(ctx, key, v)
+		}
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", key, err)
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
@@ -109,4 +109,13 @@ func main() {
 			fmt.Printf("   ✅ Retrieved block %d: %s\n", i+1, string(content))
 		}
 	}
+
+	fmt.Println("\n📊 Bandwidth")
+	fmt.Println("-------------")
+	time.Sleep(time.Second) // let the meters catch up
+	total := node1.Bandwidth()
+	fmt.Printf("   Node 1 sent %d bytes, received %d bytes\n", total.TotalOut, total.TotalIn)
+	for _, p := range node1.TopPeers(3) {
+		fmt.Printf("   🔝 %s...: in %d / out %d bytes\n", p.Peer.String()[:12], p.TotalIn, p.TotalOut)
+	}
 }
@@ -0,0 +1,215 @@
+package network
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	libp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"golang.org/x/time/rate"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
+)
+
+// RateLimit is a token bucket: BytesPerSec sustained, Burst bytes at once.
+// A zero BytesPerSec means unlimited.
+type RateLimit struct {
+	BytesPerSec float64
+	Burst       int
+}
+
+func (r RateLimit) newLimiter() *rate.Limiter {
+	burst := r.Burst
+	if burst <= 0 {
+		burst = int(r.BytesPerSec)
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(r.BytesPerSec), burst)
+}
+
+// PeerBandwidth is the traffic exchanged with one peer
+type PeerBandwidth struct {
+	Peer peer.ID
+	metrics.BandwidthTotals
+}
+
+func toTotals(s libp2pmetrics.Stats) metrics.BandwidthTotals {
+	return metrics.BandwidthTotals{TotalIn: s.TotalIn, TotalOut: s.TotalOut, RateIn: s.RateIn, RateOut: s.RateOut}
+}
+
+// Bandwidth returns traffic totals across all peers and protocols
+func (n *HostWrapper) Bandwidth() metrics.BandwidthTotals {
+	return toTotals(n.bw.GetBandwidthTotals())
+}
+
+// BandwidthForPeer returns traffic totals for p
+func (n *HostWrapper) BandwidthForPeer(p peer.ID) metrics.BandwidthTotals {
+	return toTotals(n.bw.GetBandwidthForPeer(p))
+}
+
+// BandwidthForProtocol returns traffic totals for proto
+func (n *HostWrapper) BandwidthForProtocol(proto protocol.ID) metrics.BandwidthTotals {
+	return toTotals(n.bw.GetBandwidthForProtocol(proto))
+}
+
+// TopPeers returns up to limit peers ordered by total bytes exchanged
+func (n *HostWrapper) TopPeers(limit int) []PeerBandwidth {
+	byPeer := n.bw.GetBandwidthByPeer()
+	out := make([]PeerBandwidth, 0, len(byPeer))
+	for p, s := range byPeer {
+		out = append(out, PeerBandwidth{Peer: p, BandwidthTotals: toTotals(s)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].TotalIn+out[i].TotalOut > out[j].TotalIn+out[j].TotalOut
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// rateLimiter hands out token buckets per peer and per protocol
+type rateLimiter struct {
+	perPeer  RateLimit
+	perProto map[protocol.ID]RateLimit
+
+	// ctx aborts pending waits when the host closes
+	ctx  context.Context
+	stop context.CancelFunc
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*rate.Limiter
+	protocols map[protocol.ID]*rate.Limiter
+}
+
+func newRateLimiter(perPeer RateLimit, perProto map[string]RateLimit) *rateLimiter {
+	if perPeer.BytesPerSec <= 0 && len(perProto) == 0 {
+		return nil
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	l := &rateLimiter{
+		perPeer:   perPeer,
+		ctx:       ctx,
+		stop:      stop,
+		perProto:  make(map[protocol.ID]RateLimit, len(perProto)),
+		peers:     make(map[peer.ID]*rate.Limiter),
+		protocols: make(map[protocol.ID]*rate.Limiter),
+	}
+	for id, r := range perProto {
+		if r.BytesPerSec > 0 {
+			l.perProto[protocol.ID(id)] = r
+		}
+	}
+	return l
+}
+
+// limiters returns the buckets that apply to a stream, if any
+func (l *rateLimiter) limiters(p peer.ID, proto protocol.ID) []*rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []*rate.Limiter
+	if l.perPeer.BytesPerSec > 0 {
+		lim, ok := l.peers[p]
+		if !ok {
+			lim = l.perPeer.newLimiter()
+			l.peers[p] = lim
+		}
+		out = append(out, lim)
+	}
+	if r, ok := l.perProto[proto]; ok {
+		lim, ok := l.protocols[proto]
+		if !ok {
+			lim = r.newLimiter()
+			l.protocols[proto] = lim
+		}
+		out = append(out, lim)
+	}
+	return out
+}
+
+// watch drops a peer's bucket once its last connection closes
+func (l *rateLimiter) watch(net network.Network) {
+	net.Notify(&network.NotifyBundle{
+		DisconnectedF: func(net network.Network, c network.Conn) {
+			if net.Connectedness(c.RemotePeer()) == network.Connected {
+				return
+			}
+			l.mu.Lock()
+			delete(l.peers, c.RemotePeer())
+			l.mu.Unlock()
+		},
+	})
+}
+
+// limitedStream delays reads and writes until the token buckets allow them
+type limitedStream struct {
+	network.Stream
+	limiter *rateLimiter
+}
+
+func (s *limitedStream) wait(n int) error {
+	lims := s.limiter.limiters(s.Conn().RemotePeer(), s.Protocol())
+	if len(lims) == 0 || n == 0 {
+		return nil
+	}
+	for _, lim := range lims {
+		// WaitN rejects requests above the burst, so take tokens in chunks
+		for left := n; left > 0; {
+			chunk := min(left, lim.Burst())
+			if err := lim.WaitN(s.limiter.ctx, chunk); err != nil {
+				return err
+			}
+			left -= chunk
+		}
+	}
+	return nil
+}
+
+func (s *limitedStream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	if werr := s.wait(n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (s *limitedStream) Write(b []byte) (int, error) {
+	if err := s.wait(len(b)); err != nil {
+		return 0, err
+	}
+	return s.Stream.Write(b)
+}
+
+func (n *HostWrapper) limit(s network.Stream) network.Stream {
+	if n.limiter == nil {
+		return s
+	}
+	return &limitedStream{Stream: s, limiter: n.limiter}
+}
+
+// NewStream opens a stream that is subject to the configured rate limits
+func (n *HostWrapper) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := n.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return n.limit(s), nil
+}
+
+// SetStreamHandler registers handler with inbound streams rate limited
+func (n *HostWrapper) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	n.Host.SetStreamHandler(pid, func(s network.Stream) { handler(n.limit(s)) })
+}
+
+// SetStreamHandlerMatch is SetStreamHandler with a protocol matcher
+func (n *HostWrapper) SetStreamHandlerMatch(pid protocol.ID, match func(protocol.ID) bool, handler network.StreamHandler) {
+	n.Host.SetStreamHandlerMatch(pid, match, func(s network.Stream) { handler(n.limit(s)) })
+}
+
+var _ host.Host = (*HostWrapper)(nil)
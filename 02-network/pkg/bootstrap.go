@@ -0,0 +1,156 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/rs/zerolog/log"
+)
+
+// BootstrapEnv overrides the configured bootstrap peers: a comma-separated
+// list of /p2p multiaddrs, where "public" stands for the public IPFS
+// bootstrappers and "none" disables bootstrapping.
+const BootstrapEnv = "BOXO_BOOTSTRAP"
+
+const (
+	// DefaultBootstrapInterval is the pause between connectivity checks
+	DefaultBootstrapInterval = 5 * time.Minute
+	// DefaultBootstrapMinPeers triggers a re-bootstrap when fewer peers are connected
+	DefaultBootstrapMinPeers = 4
+)
+
+type BootstrapConfig struct {
+	Peers     []string // custom /p2p multiaddrs
+	UsePublic bool     // also use the public IPFS bootstrappers
+
+	Interval time.Duration // pause between checks (default 5m)
+	MinPeers int           // re-bootstrap below this many peers (default 4)
+}
+
+// ParseBootstrap parses the BootstrapEnv syntax
+func ParseBootstrap(s string) BootstrapConfig {
+	var cfg BootstrapConfig
+	for _, part := range strings.Split(s, ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "", "none":
+		case "public":
+			cfg.UsePublic = true
+		default:
+			cfg.Peers = append(cfg.Peers, part)
+		}
+	}
+	return cfg
+}
+
+// BootstrapFromEnv returns cfg with its peers replaced by BootstrapEnv, if set
+func BootstrapFromEnv(cfg BootstrapConfig) BootstrapConfig {
+	v, ok := os.LookupEnv(BootstrapEnv)
+	if !ok {
+		return cfg
+	}
+	env := ParseBootstrap(v)
+	cfg.Peers, cfg.UsePublic = env.Peers, env.UsePublic
+	return cfg
+}
+
+// Enabled reports whether cfg names any bootstrap peer
+func (c BootstrapConfig) Enabled() bool {
+	return c.UsePublic || len(c.Peers) > 0
+}
+
+// AddrInfos resolves the configured peers, public ones first
+func (c BootstrapConfig) AddrInfos() ([]peer.AddrInfo, error) {
+	var out []peer.AddrInfo
+	if c.UsePublic {
+		out = append(out, dht.GetDefaultBootstrapPeerAddrInfos()...)
+	}
+	custom, err := ToAddrInfos(c.Peers)
+	if err != nil {
+		return nil, fmt.Errorf("bootstrap peers: %w", err)
+	}
+	return append(out, custom...), nil
+}
+
+// BootstrapPeers returns the bootstrap peers this host was configured with
+func (n *HostWrapper) BootstrapPeers() []peer.AddrInfo {
+	return n.bootstrap
+}
+
+// Bootstrap connects to every bootstrap peer in parallel and returns how
+// many succeeded; the error is only set when all of them failed.
+func (n *HostWrapper) Bootstrap(ctx context.Context, peers []peer.AddrInfo) (int, error) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		connected int
+		lastErr   error
+	)
+	for _, pi := range peers {
+		if pi.ID == n.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			n.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.PermanentAddrTTL)
+			cctx, cancel := context.WithTimeout(ctx, n.timeout)
+			defer cancel()
+			err := n.Connect(cctx, pi)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			connected++
+		}(pi)
+	}
+	wg.Wait()
+
+	if connected == 0 && lastErr != nil {
+		return 0, fmt.Errorf("bootstrap: no peer reachable: %w", lastErr)
+	}
+	return connected, nil
+}
+
+// runBootstrap bootstraps now and again whenever the host drops below
+// MinPeers, until the host is closed.
+func (n *HostWrapper) runBootstrap(cfg BootstrapConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultBootstrapInterval
+	}
+	if cfg.MinPeers <= 0 {
+		cfg.MinPeers = DefaultBootstrapMinPeers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-n.done
+		cancel()
+	}()
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if len(n.Peers()) < cfg.MinPeers {
+			if c, err := n.Bootstrap(ctx, n.bootstrap); err != nil {
+				log.Warn().Err(err).Msg("bootstrap failed")
+			} else {
+				log.Debug().Int("connected", c).Msg("bootstrapped")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultServiceName is the mDNS service and rendezvous namespace used
+	// when none is configured
+	DefaultServiceName = "boxo-starter-kit"
+	// DefaultRendezvousInterval is the pause between rendezvous lookups
+	DefaultRendezvousInterval = 30 * time.Second
+)
+
+type DiscoverySource string
+
+const (
+	SourceMDNS       DiscoverySource = "mdns"
+	SourceRendezvous DiscoverySource = "rendezvous"
+)
+
+// PeerFound is emitted for every peer a discovery mechanism reports
+type PeerFound struct {
+	peer.AddrInfo
+	Source DiscoverySource
+}
+
+type DiscoveryConfig struct {
+	// Announce and browse for peers on the local network
+	MDNS bool
+
+	// Advertise and look up Namespace through Router (typically a DHT).
+	// Nil disables rendezvous discovery.
+	Router   routing.ContentRouting
+	Interval time.Duration // pause between rendezvous lookups (default 30s)
+
+	// mDNS service name and rendezvous namespace (default "boxo-starter-kit").
+	// Only peers using the same value find each other.
+	Namespace string
+
+	// Connect to peers as they are found
+	AutoConnect bool
+}
+
+// Discovery runs the configured mechanisms until Close or its context ends
+type Discovery struct {
+	host   *HostWrapper
+	cfg    DiscoveryConfig
+	found  chan PeerFound
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mdns   mdns.Service
+	once   sync.Once
+
+	mu     sync.RWMutex // guards sends on found against Close
+	closed bool
+}
+
+// StartDiscovery starts mDNS and/or rendezvous discovery. Found peers are
+// sent on Peers(); events are dropped if the channel is not drained.
+func (n *HostWrapper) StartDiscovery(ctx context.Context, cfg DiscoveryConfig) (*Discovery, error) {
+	if !cfg.MDNS && cfg.Router == nil {
+		return nil, fmt.Errorf("discovery: enable mDNS or set a Router")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = DefaultServiceName
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultRendezvousInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	d := &Discovery{
+		host:   n,
+		cfg:    cfg,
+		found:  make(chan PeerFound, 32),
+		cancel: cancel,
+	}
+
+	if cfg.MDNS {
+		d.mdns = mdns.NewMdnsService(n, cfg.Namespace, mdnsNotifee{d: d, ctx: ctx})
+		if err := d.mdns.Start(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("start mdns: %w", err)
+		}
+	}
+	if cfg.Router != nil {
+		rd := drouting.NewRoutingDiscovery(cfg.Router)
+		d.wg.Add(2)
+		go func() {
+			defer d.wg.Done()
+			dutil.Advertise(ctx, rd, cfg.Namespace)
+		}()
+		go func() {
+			defer d.wg.Done()
+			d.rendezvous(ctx, rd)
+		}()
+	}
+	return d, nil
+}
+
+// Peers delivers discovered peers; it is closed by Close
+func (d *Discovery) Peers() <-chan PeerFound {
+	return d.found
+}
+
+// Close stops discovery and closes the Peers channel
+func (d *Discovery) Close() error {
+	var err error
+	d.once.Do(func() {
+		d.cancel()
+		if d.mdns != nil {
+			err = d.mdns.Close()
+		}
+		d.wg.Wait()
+		d.mu.Lock()
+		d.closed = true
+		close(d.found)
+		d.mu.Unlock()
+	})
+	return err
+}
+
+func (d *Discovery) rendezvous(ctx context.Context, rd *drouting.RoutingDiscovery) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		peers, err := rd.FindPeers(ctx, d.cfg.Namespace)
+		if err != nil {
+			log.Debug().Err(err).Str("namespace", d.cfg.Namespace).Msg("rendezvous lookup failed")
+		} else {
+			for pi := range peers {
+				d.handle(ctx, pi, SourceRendezvous)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Discovery) handle(ctx context.Context, pi peer.AddrInfo, src DiscoverySource) {
+	if pi.ID == d.host.ID() || len(pi.Addrs) == 0 || ctx.Err() != nil {
+		return
+	}
+	if d.cfg.AutoConnect {
+		cctx, cancel := context.WithTimeout(ctx, d.host.timeout)
+		if err := d.host.Connect(cctx, pi); err != nil {
+			log.Debug().Err(err).Str("peer", pi.ID.String()).Str("source", string(src)).Msg("auto-connect failed")
+		}
+		cancel()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.found <- PeerFound{AddrInfo: pi, Source: src}:
+	default:
+	}
+}
+
+// mdnsNotifee adapts mDNS callbacks to Discovery
+type mdnsNotifee struct {
+	d   *Discovery
+	ctx context.Context
+}
+
+func (m mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	m.d.handle(m.ctx, pi, SourceMDNS)
+}
@@ -0,0 +1,221 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Gater is a connection gater driven by peer ID and CIDR allow/deny lists.
+// Deny entries always win. A non-empty peer allow list admits only those
+// peers; a non-empty CIDR allow list admits only remote addresses inside
+// those subnets. Lists can be changed at runtime.
+type Gater struct {
+	mu         sync.RWMutex
+	allowPeers map[peer.ID]struct{}
+	denyPeers  map[peer.ID]struct{}
+	allowNets  []*net.IPNet
+	denyNets   []*net.IPNet
+}
+
+var _ connmgr.ConnectionGater = (*Gater)(nil)
+
+// NewGater builds a gater from peer ID strings and CIDR strings
+func NewGater(allowPeers, denyPeers, allowCIDRs, denyCIDRs []string) (*Gater, error) {
+	g := &Gater{
+		allowPeers: make(map[peer.ID]struct{}),
+		denyPeers:  make(map[peer.ID]struct{}),
+	}
+	for _, list := range []struct {
+		ids []string
+		set map[peer.ID]struct{}
+	}{{allowPeers, g.allowPeers}, {denyPeers, g.denyPeers}} {
+		for _, s := range list.ids {
+			id, err := peer.Decode(s)
+			if err != nil {
+				return nil, fmt.Errorf("gater: peer %q: %w", s, err)
+			}
+			list.set[id] = struct{}{}
+		}
+	}
+	var err error
+	if g.allowNets, err = parseCIDRs(allowCIDRs); err != nil {
+		return nil, err
+	}
+	if g.denyNets, err = parseCIDRs(denyCIDRs); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("gater: %w", err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// BlockPeer denies p, overriding the allow list
+func (g *Gater) BlockPeer(p peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.denyPeers[p] = struct{}{}
+}
+
+// UnblockPeer removes p from the deny list
+func (g *Gater) UnblockPeer(p peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.denyPeers, p)
+}
+
+// AllowPeer adds p to the allow list, which then admits only listed peers
+func (g *Gater) AllowPeer(p peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.allowPeers[p] = struct{}{}
+}
+
+// BlockedPeers returns the peers on the deny list
+func (g *Gater) BlockedPeers() []peer.ID {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make([]peer.ID, 0, len(g.denyPeers))
+	for p := range g.denyPeers {
+		out = append(out, p)
+	}
+	return out
+}
+
+// BlockSubnet denies every address inside cidr
+func (g *Gater) BlockSubnet(cidr string) error {
+	nets, err := parseCIDRs([]string{cidr})
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.denyNets = append(g.denyNets, nets[0])
+	return nil
+}
+
+// UnblockSubnet removes cidr from the deny list
+func (g *Gater) UnblockSubnet(cidr string) error {
+	nets, err := parseCIDRs([]string{cidr})
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	kept := g.denyNets[:0]
+	for _, n := range g.denyNets {
+		if n.String() != nets[0].String() {
+			kept = append(kept, n)
+		}
+	}
+	g.denyNets = kept
+	return nil
+}
+
+func (g *Gater) peerAllowed(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if _, denied := g.denyPeers[p]; denied {
+		return false
+	}
+	if len(g.allowPeers) == 0 {
+		return true
+	}
+	_, ok := g.allowPeers[p]
+	return ok
+}
+
+func (g *Gater) addrAllowed(addr multiaddr.Multiaddr) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		// Not IP based (e.g. a relay circuit); only an allow list can reject it
+		return len(g.allowNets) == 0
+	}
+	for _, n := range g.denyNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(g.allowNets) == 0 {
+		return true
+	}
+	for _, n := range g.allowNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	return g.peerAllowed(p)
+}
+
+func (g *Gater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return g.peerAllowed(p) && g.addrAllowed(addr)
+}
+
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.addrAllowed(addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptSecured(_ network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return g.peerAllowed(p) && g.addrAllowed(addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// Gater returns the host's connection gater for runtime changes
+func (n *HostWrapper) Gater() *Gater {
+	return n.gater
+}
+
+// BlockPeer denies p and closes any open connections to it
+func (n *HostWrapper) BlockPeer(p peer.ID) error {
+	n.gater.BlockPeer(p)
+	return n.Network().ClosePeer(p)
+}
+
+// UnblockPeer allows p to connect again
+func (n *HostWrapper) UnblockPeer(p peer.ID) {
+	n.gater.UnblockPeer(p)
+}
+
+// BlockSubnet denies cidr and closes open connections from inside it
+func (n *HostWrapper) BlockSubnet(cidr string) error {
+	if err := n.gater.BlockSubnet(cidr); err != nil {
+		return err
+	}
+	for _, c := range n.Network().Conns() {
+		if !n.gater.addrAllowed(c.RemoteMultiaddr()) {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// UnblockSubnet removes cidr from the deny list
+func (n *HostWrapper) UnblockSubnet(cidr string) error {
+	return n.gater.UnblockSubnet(cidr)
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+)
+
+var (
+	// identityKey holds the marshalled private key inside Config.Datastore
+	identityKey = ds.NewKey("/identity/privkey")
+	// peerstorePrefix namespaces the persistent peerstore inside Config.Datastore
+	peerstorePrefix = ds.NewKey("/peerstore")
+)
+
+// reputationKey is the peerstore metadata key used by SetReputation
+const reputationKey = "reputation"
+
+// LoadOrCreateKeyFile reads an Ed25519 identity from path, generating and
+// saving a new one (mode 0600) if the file does not exist.
+func LoadOrCreateKeyFile(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		sk, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse key file %s: %w", path, err)
+		}
+		return sk, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	sk, data, err := newIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create key dir: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("write key file: %w", err)
+	}
+	return sk, nil
+}
+
+// LoadOrCreateKey reads the identity stored in d, generating and storing a
+// new Ed25519 key on first use.
+func LoadOrCreateKey(ctx context.Context, d ds.Datastore) (crypto.PrivKey, error) {
+	data, err := d.Get(ctx, identityKey)
+	if err == nil {
+		sk, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse stored identity: %w", err)
+		}
+		return sk, nil
+	}
+	if !errors.Is(err, ds.ErrNotFound) {
+		return nil, fmt.Errorf("load identity: %w", err)
+	}
+
+	sk, data, err := newIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Put(ctx, identityKey, data); err != nil {
+		return nil, fmt.Errorf("store identity: %w", err)
+	}
+	if err := d.Sync(ctx, identityKey); err != nil {
+		return nil, fmt.Errorf("sync identity: %w", err)
+	}
+	return sk, nil
+}
+
+func newIdentity() (crypto.PrivKey, []byte, error) {
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate identity: %w", err)
+	}
+	data, err := crypto.MarshalPrivateKey(sk)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal identity: %w", err)
+	}
+	return sk, data, nil
+}
+
+// identity picks the private key for cfg: the key file if set, else the
+// datastore, else nil so libp2p generates a throwaway one.
+func identity(ctx context.Context, cfg *Config) (crypto.PrivKey, error) {
+	switch {
+	case cfg.IdentityFile != "":
+		return LoadOrCreateKeyFile(cfg.IdentityFile)
+	case cfg.Datastore != nil:
+		return LoadOrCreateKey(ctx, cfg.Datastore)
+	default:
+		return nil, nil
+	}
+}
+
+// newPeerstore returns a peerstore persisted under /peerstore in d. Only
+// addresses added with a permanent TTL survive a restart.
+func newPeerstore(ctx context.Context, d ds.Batching) (peerstore.Peerstore, error) {
+	ps, err := pstoreds.NewPeerstore(ctx, namespace.Wrap(d, peerstorePrefix), pstoreds.DefaultOpts())
+	if err != nil {
+		return nil, fmt.Errorf("open peerstore: %w", err)
+	}
+	return ps, nil
+}
+
+// SetReputation records an application-defined score for p in the
+// peerstore, so it persists when Config.Datastore is set.
+func (n *HostWrapper) SetReputation(p peer.ID, score float64) error {
+	return n.Peerstore().Put(p, reputationKey, score)
+}
+
+// Reputation returns the score recorded by SetReputation; ok is false if
+// none was recorded.
+func (n *HostWrapper) Reputation(p peer.ID) (score float64, ok bool, err error) {
+	v, err := n.Peerstore().Get(p, reputationKey)
+	if errors.Is(err, peerstore.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	score, ok = v.(float64)
+	if !ok {
+		return 0, false, fmt.Errorf("unexpected reputation type %T", v)
+	}
+	return score, true, nil
+}
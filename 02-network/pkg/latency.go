@@ -0,0 +1,178 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	// DefaultLatencyWindow is the number of RTT samples kept per peer
+	DefaultLatencyWindow = 32
+	// DefaultProbeInterval is the pause between background ping rounds
+	DefaultProbeInterval = 30 * time.Second
+)
+
+// LatencyStats summarises the recent round-trip times to a peer
+type LatencyStats struct {
+	Samples int
+	Last    time.Duration
+	Mean    time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Updated time.Time
+}
+
+// LatencySource provides measured RTTs, e.g. to rank providers
+type LatencySource interface {
+	PeerLatency(p peer.ID) (LatencyStats, bool)
+}
+
+var _ LatencySource = (*HostWrapper)(nil)
+
+// latencyTracker keeps a ring of the most recent samples per peer
+type latencyTracker struct {
+	window int
+
+	mu    sync.RWMutex
+	peers map[peer.ID]*rttRing
+}
+
+type rttRing struct {
+	samples []time.Duration
+	next    int
+	updated time.Time
+}
+
+func newLatencyTracker(window int) *latencyTracker {
+	if window <= 0 {
+		window = DefaultLatencyWindow
+	}
+	return &latencyTracker{window: window, peers: make(map[peer.ID]*rttRing)}
+}
+
+func (t *latencyTracker) record(p peer.ID, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.peers[p]
+	if !ok {
+		r = &rttRing{samples: make([]time.Duration, 0, t.window)}
+		t.peers[p] = r
+	}
+	if len(r.samples) < t.window {
+		r.samples = append(r.samples, rtt)
+	} else {
+		r.samples[r.next] = rtt
+	}
+	r.next = (r.next + 1) % t.window
+	r.updated = time.Now()
+}
+
+func (t *latencyTracker) stats(p peer.ID) (LatencyStats, bool) {
+	t.mu.RLock()
+	r, ok := t.peers[p]
+	if !ok {
+		t.mu.RUnlock()
+		return LatencyStats{}, false
+	}
+	last := r.samples[(r.next-1+len(r.samples))%len(r.samples)]
+	sorted := slices.Clone(r.samples)
+	updated := r.updated
+	t.mu.RUnlock()
+
+	slices.Sort(sorted)
+	var sum time.Duration
+	for _, s := range sorted {
+		sum += s
+	}
+	pct := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1)+0.5)]
+	}
+	return LatencyStats{
+		Samples: len(sorted),
+		Last:    last,
+		Mean:    sum / time.Duration(len(sorted)),
+		Min:     sorted[0],
+		Max:     sorted[len(sorted)-1],
+		P50:     pct(0.50),
+		P90:     pct(0.90),
+		P99:     pct(0.99),
+		Updated: updated,
+	}, true
+}
+
+// Ping measures one round trip to p with the libp2p ping protocol and
+// records it in the latency tracker.
+func (n *HostWrapper) Ping(ctx context.Context, p peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	res, ok := <-ping.Ping(ctx, n.Host, p)
+	if !ok {
+		return 0, fmt.Errorf("ping %s: %w", p, ctx.Err())
+	}
+	if res.Error != nil {
+		return 0, fmt.Errorf("ping %s: %w", p, res.Error)
+	}
+	n.latency.record(p, res.RTT)
+	return res.RTT, nil
+}
+
+// PeerLatency returns rolling RTT percentiles for p; ok is false until p
+// has been pinged successfully.
+func (n *HostWrapper) PeerLatency(p peer.ID) (LatencyStats, bool) {
+	return n.latency.stats(p)
+}
+
+// LatencyProbe pings connected peers in the background until its context ends
+type LatencyProbe struct {
+	done chan struct{}
+}
+
+// Done is closed once the probe has stopped
+func (lp *LatencyProbe) Done() <-chan struct{} {
+	return lp.done
+}
+
+// StartLatencyProbe pings every connected peer once per interval (default
+// 30s), feeding PeerLatency.
+func (n *HostWrapper) StartLatencyProbe(ctx context.Context, interval time.Duration) *LatencyProbe {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	lp := &LatencyProbe{done: make(chan struct{})}
+
+	go func() {
+		defer close(lp.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			var wg sync.WaitGroup
+			for _, p := range n.Peers() {
+				wg.Add(1)
+				go func(p peer.ID) {
+					defer wg.Done()
+					_, _ = n.Ping(ctx, p)
+				}(p)
+			}
+			wg.Wait()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return lp
+}
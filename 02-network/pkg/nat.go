@@ -0,0 +1,109 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
+)
+
+// natOptions returns the AutoNAT options selected in cfg
+func natOptions(cfg *Config) ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+	if cfg.AutoNATService {
+		opts = append(opts, libp2p.EnableNATService())
+	}
+	switch cfg.ForceReachability {
+	case network.ReachabilityUnknown:
+	case network.ReachabilityPublic:
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	case network.ReachabilityPrivate:
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	default:
+		return nil, fmt.Errorf("unsupported forced reachability %q", cfg.ForceReachability)
+	}
+	return opts, nil
+}
+
+// watchReachability records AutoNAT's verdict until the host is closed
+func (n *HostWrapper) watchReachability() error {
+	sub, err := n.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return fmt.Errorf("subscribe reachability: %w", err)
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				n.reachability.Store(int32(e.(event.EvtLocalReachabilityChanged).Reachability))
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Reachability is AutoNAT's current view of whether this node is dialable
+// from the public internet.
+func (n *HostWrapper) Reachability() network.Reachability {
+	return network.Reachability(n.reachability.Load())
+}
+
+// RelayAddrs returns the /p2p-circuit addresses reserved through relays
+func (n *HostWrapper) RelayAddrs() []multiaddr.Multiaddr {
+	var out []multiaddr.Multiaddr
+	for _, a := range n.Addrs() {
+		if strings.Contains(a.String(), "/p2p-circuit") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// ReachabilityCheck reports NAT status: healthy when publicly reachable,
+// degraded when private but reachable through a relay, unhealthy when
+// private with no relay address, unknown until AutoNAT has decided.
+func (n *HostWrapper) ReachabilityCheck() health.HealthChecker {
+	const name = "network-reachability"
+	return health.NewHealthCheckFunc(name, func(ctx context.Context) health.CheckResult {
+		reach := n.Reachability()
+		relays := n.RelayAddrs()
+		result := health.CheckResult{
+			ComponentName: name,
+			Metadata: map[string]string{
+				"reachability":  reach.String(),
+				"relay_addrs":   fmt.Sprint(len(relays)),
+				"peers":         fmt.Sprint(len(n.Peers())),
+				"hole_punching": fmt.Sprint(n.holePunching),
+				"relay_service": fmt.Sprint(n.relayService),
+			},
+		}
+
+		switch {
+		case reach == network.ReachabilityPublic:
+			result.Status = health.StatusHealthy
+			result.Message = "Publicly reachable"
+		case reach == network.ReachabilityPrivate && len(relays) > 0:
+			result.Status = health.StatusDegraded
+			result.Message = fmt.Sprintf("Behind NAT, reachable via %d relay address(es)", len(relays))
+		case reach == network.ReachabilityPrivate:
+			result.Status = health.StatusUnhealthy
+			result.Message = "Behind NAT with no relay reservation; inbound connections will fail"
+		default:
+			result.Status = health.StatusUnknown
+			result.Message = "Reachability not yet determined by AutoNAT"
+		}
+		return result
+	})
+}
@@ -6,14 +6,18 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
+	libp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	noisec "github.com/libp2p/go-libp2p/p2p/security/noise"
 	tlssec "github.com/libp2p/go-libp2p/p2p/security/tls"
@@ -37,8 +41,25 @@ type HostWrapper struct {
 	waiters map[string][]chan msg // by cid.String()
 	buf     map[string]msg
 
+	// NAT status, updated from AutoNAT events
+	reachability atomic.Int32
+	holePunching bool
+	relayService bool
+
+	gater   *Gater
+	bw      *libp2pmetrics.BandwidthCounter
+	limiter *rateLimiter // nil when no rate limits are configured
+	latency *latencyTracker
+
+	bootstrap []peer.AddrInfo
+	blocked   *blockCounter // nil when the resource manager is disabled
+
+	routerMu sync.RWMutex
+	router   routing.PeerRouting // set by SetPeerRouting, used by ConnectByID
+
 	// Metrics
-	metrics *metrics.ComponentMetrics
+	metrics     *metrics.ComponentMetrics
+	metricsName string // "network/<peer ID>" in the global bandwidth registry
 }
 
 type Config struct {
@@ -68,6 +89,61 @@ type Config struct {
 	// Run THIS node as a public relay (HOP).
 	// Use on well-connected/public hosts; clients usually keep OFF.
 	RelayService bool
+
+	// Answer AutoNAT dial-back requests so other peers can learn whether
+	// they are reachable. Use on public hosts.
+	AutoNATService bool
+
+	// Skip AutoNAT probing and assume this reachability (public/private);
+	// the zero value (unknown) lets AutoNAT decide.
+	// Mainly for tests and hosts whose NAT situation is known.
+	ForceReachability network.Reachability
+
+	// Transports to enable (TCP, QUIC, WebSocket, WebTransport, WebRTC).
+	// Empty keeps libp2p's defaults. Without ListenAddrs, each selected
+	// transport listens on a random port on all IPv4 interfaces.
+	Transports []Transport
+
+	// Connection manager watermarks. When more than ConnMgrHigh peers are
+	// connected, connections are trimmed down to ConnMgrLow, sparing those
+	// younger than ConnMgrGrace. Zero ConnMgrHigh keeps libp2p's default.
+	ConnMgrLow   int
+	ConnMgrHigh  int
+	ConnMgrGrace time.Duration
+
+	// Keep the peer ID across runs by loading the private key from this
+	// file, creating it on first use.
+	IdentityFile string
+
+	// Persist the peerstore (addresses, keys, protocols, reputations) and,
+	// unless IdentityFile is set, the private key. Typically the Batching
+	// of a 01-persistent wrapper. Nil keeps everything in memory.
+	Datastore ds.Batching
+
+	// Connection gating by peer ID and CIDR (e.g. "10.0.0.0/8"). Deny
+	// entries win; a non-empty allow list admits only what it lists.
+	// Lists can be changed later with BlockPeer/BlockSubnet.
+	AllowPeers []string
+	DenyPeers  []string
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// Token-bucket limits on stream traffic through this wrapper, applied
+	// to each peer separately and to each listed protocol as a whole.
+	// Streams opened on the underlying host directly are not limited.
+	PeerRateLimit      RateLimit
+	ProtocolRateLimits map[string]RateLimit
+
+	// RTT samples kept per peer for PeerLatency (default 32)
+	LatencyWindow int
+
+	// Peers to connect to on start and whenever connectivity drops.
+	// The BOXO_BOOTSTRAP environment variable overrides the peer list.
+	Bootstrap BootstrapConfig
+
+	// Resource manager limits (memory, streams, connections) per scope.
+	// The zero value keeps libp2p's auto-scaled defaults.
+	Resources ResourceConfig
 }
 
 func New(cfg *Config) (*HostWrapper, error) {
@@ -83,10 +159,23 @@ func New(cfg *Config) (*HostWrapper, error) {
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 10 * time.Second
 	}
+	transportOpts, transportAddrs, err := transportOptions(cfg.Transports)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.ListenAddrs) == 0 {
+		cfg.ListenAddrs = transportAddrs
+	}
 	if len(cfg.ListenAddrs) == 0 {
 		cfg.ListenAddrs = []string{"/ip4/0.0.0.0/tcp/0", "/ip4/0.0.0.0/udp/0/quic-v1"}
 	}
 
+	bootstrapCfg := BootstrapFromEnv(cfg.Bootstrap)
+	bootstrapPeers, err := bootstrapCfg.AddrInfos()
+	if err != nil {
+		return nil, err
+	}
+
 	las, err := ToMultiaddrs(cfg.ListenAddrs)
 	if err != nil {
 		return nil, fmt.Errorf("listen addrs: %w", err)
@@ -95,6 +184,14 @@ func New(cfg *Config) (*HostWrapper, error) {
 		libp2p.ListenAddrs(las...),
 		libp2p.EnableAutoNATv2(),
 	}
+	opts = append(opts, transportOpts...)
+	if cfg.ConnMgrHigh > 0 {
+		cm, err := connManagerOption(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, cm)
+	}
 	if cfg.UseTLS {
 		opts = append(opts, libp2p.Security(tlssec.ID, tlssec.New))
 	}
@@ -120,6 +217,41 @@ func New(cfg *Config) (*HostWrapper, error) {
 	if cfg.RelayService {
 		opts = append(opts, libp2p.EnableRelayService())
 	}
+	natOpts, err := natOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, natOpts...)
+
+	gater, err := NewGater(cfg.AllowPeers, cfg.DenyPeers, cfg.AllowCIDRs, cfg.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, libp2p.ConnectionGater(gater))
+
+	rm, blocked, err := newResourceManager(cfg.Resources)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, libp2p.ResourceManager(rm))
+
+	bw := libp2pmetrics.NewBandwidthCounter()
+	opts = append(opts, libp2p.BandwidthReporter(bw))
+
+	sk, err := identity(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sk != nil {
+		opts = append(opts, libp2p.Identity(sk))
+	}
+	if cfg.Datastore != nil {
+		ps, err := newPeerstore(context.Background(), cfg.Datastore)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.Peerstore(ps))
+	}
 
 	h, err := libp2p.New(opts...)
 	if err != nil {
@@ -129,20 +261,41 @@ func New(cfg *Config) (*HostWrapper, error) {
 	// Initialize metrics
 	networkMetrics := metrics.NewComponentMetrics("network")
 	metrics.RegisterGlobalComponent(networkMetrics)
+	metricsName := "network/" + h.ID().String()
+	metrics.RegisterGlobalBandwidth(metricsName, func() metrics.BandwidthTotals {
+		return toTotals(bw.GetBandwidthTotals())
+	})
 
 	n := &HostWrapper{
-		Host:       h,
-		protoID:    protocol.ID(cfg.ProtocolID),
-		maxPayload: cfg.MaxPayload,
-		timeout:    cfg.Timeout,
-		inbox:      make(chan network.Stream, 32),
-		done:       make(chan struct{}),
-		waiters:    make(map[string][]chan msg),
-		buf:        make(map[string]msg),
-		metrics:    networkMetrics,
-	}
-
-	h.SetStreamHandler(n.protoID, func(s network.Stream) {
+		Host:        h,
+		protoID:     protocol.ID(cfg.ProtocolID),
+		maxPayload:  cfg.MaxPayload,
+		timeout:     cfg.Timeout,
+		inbox:       make(chan network.Stream, 32),
+		done:        make(chan struct{}),
+		waiters:     make(map[string][]chan msg),
+		buf:         make(map[string]msg),
+		metrics:     networkMetrics,
+		metricsName: metricsName,
+
+		holePunching: cfg.HolePunching,
+		relayService: cfg.RelayService,
+		gater:        gater,
+		bw:           bw,
+		limiter:      newRateLimiter(cfg.PeerRateLimit, cfg.ProtocolRateLimits),
+		latency:      newLatencyTracker(cfg.LatencyWindow),
+		bootstrap:    bootstrapPeers,
+		blocked:      blocked,
+	}
+	if n.limiter != nil {
+		n.limiter.watch(h.Network())
+	}
+	if err := n.watchReachability(); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	n.SetStreamHandler(n.protoID, func(s network.Stream) {
 		select {
 		case n.inbox <- s:
 		case <-n.done:
@@ -150,12 +303,19 @@ func New(cfg *Config) (*HostWrapper, error) {
 		}
 	})
 	go n.dispatch()
+	if len(bootstrapPeers) > 0 {
+		go n.runBootstrap(bootstrapCfg)
+	}
 
 	return n, nil
 }
 
 func (n *HostWrapper) Close() error {
+	metrics.UnregisterGlobalBandwidth(n.metricsName)
 	close(n.done)
+	if n.limiter != nil {
+		n.limiter.stop()
+	}
 	if n.Host != nil {
 		return n.Host.Close()
 	}
@@ -0,0 +1,303 @@
+package network
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+)
+
+// Reconnect backoff defaults
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// protectTag keeps managed peers from being trimmed by the connection manager
+const protectTag = "peer-manager"
+
+type PeerState string
+
+const (
+	PeerConnected    PeerState = "connected"
+	PeerDisconnected PeerState = "disconnected" // dropped, reconnect pending
+	PeerBackoff      PeerState = "backoff"      // last attempt failed, waiting to retry
+	PeerFailed       PeerState = "failed"       // MaxAttempts reached, no more retries
+)
+
+// PeerStateChange is passed to state callbacks
+type PeerStateChange struct {
+	Peer    peer.ID
+	State   PeerState
+	Attempt int           // failed attempts since the last connection
+	Retry   time.Duration // delay before the next attempt, for PeerBackoff
+	Err     error         // last dial error, for PeerBackoff and PeerFailed
+}
+
+// PeerStatus is a snapshot of one managed peer
+type PeerStatus struct {
+	Peer      peer.ID
+	State     PeerState
+	Attempts  int
+	NextRetry time.Time
+	LastErr   error
+}
+
+type PeerManagerConfig struct {
+	MinBackoff  time.Duration // first retry delay (default 1s)
+	MaxBackoff  time.Duration // cap on the retry delay (default 5m)
+	MaxAttempts int           // give up after this many failures in a row; 0 retries forever
+}
+
+// PeerManager keeps connections to a set of important peers, such as
+// bootstrap nodes or pinned providers, reconnecting with exponential
+// backoff when they drop.
+type PeerManager struct {
+	host *HostWrapper
+	cfg  PeerManagerConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	notify *network.NotifyBundle
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*managedPeer
+	callbacks []func(PeerStateChange)
+}
+
+type managedPeer struct {
+	info   peer.AddrInfo
+	kick   chan struct{}
+	cancel context.CancelFunc
+	status PeerStatus
+}
+
+// NewPeerManager starts a manager for n. Close it before closing n.
+func (n *HostWrapper) NewPeerManager(cfg PeerManagerConfig) *PeerManager {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.MinBackoff)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pm := &PeerManager{
+		host:   n,
+		cfg:    cfg,
+		ctx:    ctx,
+		cancel: cancel,
+		peers:  make(map[peer.ID]*managedPeer),
+	}
+	pm.notify = &network.NotifyBundle{
+		ConnectedF:    func(_ network.Network, c network.Conn) { pm.wake(c.RemotePeer()) },
+		DisconnectedF: func(_ network.Network, c network.Conn) { pm.wake(c.RemotePeer()) },
+	}
+	n.Network().Notify(pm.notify)
+	return pm
+}
+
+// OnStateChange registers fn to be called on every state change. Callbacks
+// run on the peer's reconnect goroutine and should not block.
+func (pm *PeerManager) OnStateChange(fn func(PeerStateChange)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.callbacks = append(pm.callbacks, fn)
+}
+
+// Add starts managing info.ID, connecting right away. Adding a peer that is
+// already managed updates its addresses and resets a failed peer.
+func (pm *PeerManager) Add(info peer.AddrInfo) {
+	pm.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.PermanentAddrTTL)
+	pm.host.ConnManager().Protect(info.ID, protectTag)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if mp, ok := pm.peers[info.ID]; ok {
+		mp.info.Addrs = append(mp.info.Addrs, info.Addrs...)
+		if mp.status.State == PeerFailed {
+			mp.cancel()
+			delete(pm.peers, info.ID)
+		} else {
+			pm.kick(mp)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(pm.ctx)
+	mp := &managedPeer{
+		info:   info,
+		kick:   make(chan struct{}, 1),
+		cancel: cancel,
+		status: PeerStatus{Peer: info.ID, State: PeerDisconnected},
+	}
+	pm.peers[info.ID] = mp
+	pm.wg.Add(1)
+	go pm.run(ctx, mp)
+}
+
+// Remove stops managing p. Existing connections are left open.
+func (pm *PeerManager) Remove(p peer.ID) {
+	pm.mu.Lock()
+	mp, ok := pm.peers[p]
+	delete(pm.peers, p)
+	pm.mu.Unlock()
+	if ok {
+		mp.cancel()
+		pm.host.ConnManager().Unprotect(p, protectTag)
+	}
+}
+
+// Peers returns the status of every managed peer
+func (pm *PeerManager) Peers() []PeerStatus {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	out := make([]PeerStatus, 0, len(pm.peers))
+	for _, mp := range pm.peers {
+		out = append(out, mp.status)
+	}
+	return out
+}
+
+// Status returns the status of p; ok is false if p is not managed
+func (pm *PeerManager) Status(p peer.ID) (PeerStatus, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	mp, ok := pm.peers[p]
+	if !ok {
+		return PeerStatus{}, false
+	}
+	return mp.status, true
+}
+
+// Close stops all reconnect loops
+func (pm *PeerManager) Close() error {
+	pm.host.Network().StopNotify(pm.notify)
+	pm.cancel()
+	pm.wg.Wait()
+	return nil
+}
+
+func (pm *PeerManager) wake(p peer.ID) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if mp, ok := pm.peers[p]; ok {
+		pm.kick(mp)
+	}
+}
+
+func (pm *PeerManager) kick(mp *managedPeer) {
+	select {
+	case mp.kick <- struct{}{}:
+	default:
+	}
+}
+
+// run keeps one peer connected until its context ends
+func (pm *PeerManager) run(ctx context.Context, mp *managedPeer) {
+	defer pm.wg.Done()
+	p := mp.info.ID
+	backoff := pm.cfg.MinBackoff
+	attempts := 0
+
+	for ctx.Err() == nil {
+		if pm.host.Network().Connectedness(p) == network.Connected {
+			attempts, backoff = 0, pm.cfg.MinBackoff
+			pm.transition(mp, PeerStateChange{Peer: p, State: PeerConnected})
+			if !pm.wait(ctx, mp, 0) {
+				return
+			}
+			continue
+		}
+		if pm.status(mp) == PeerConnected {
+			pm.transition(mp, PeerStateChange{Peer: p, State: PeerDisconnected})
+		}
+
+		// We keep our own backoff, so skip the swarm's
+		if sw, ok := pm.host.Network().(*swarm.Swarm); ok {
+			sw.Backoff().Clear(p)
+		}
+		dctx, cancel := context.WithTimeout(ctx, pm.host.timeout)
+		err := pm.host.Connect(dctx, pm.addrInfo(mp))
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		attempts++
+		if pm.cfg.MaxAttempts > 0 && attempts >= pm.cfg.MaxAttempts {
+			pm.transition(mp, PeerStateChange{Peer: p, State: PeerFailed, Attempt: attempts, Err: err})
+			return
+		}
+		delay := jitter(backoff)
+		pm.transition(mp, PeerStateChange{Peer: p, State: PeerBackoff, Attempt: attempts, Retry: delay, Err: err})
+		backoff = min(backoff*2, pm.cfg.MaxBackoff)
+		if !pm.wait(ctx, mp, delay) {
+			return
+		}
+	}
+}
+
+// wait blocks until a kick, the delay (if non-zero) or the end of ctx
+func (pm *PeerManager) wait(ctx context.Context, mp *managedPeer, delay time.Duration) bool {
+	var timer <-chan time.Time
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-mp.kick:
+	case <-timer:
+	}
+	return true
+}
+
+func (pm *PeerManager) addrInfo(mp *managedPeer) peer.AddrInfo {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return peer.AddrInfo{ID: mp.info.ID, Addrs: append(mp.info.Addrs[:0:0], mp.info.Addrs...)}
+}
+
+func (pm *PeerManager) status(mp *managedPeer) PeerState {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return mp.status.State
+}
+
+// transition records a state change and notifies callbacks if it is new
+func (pm *PeerManager) transition(mp *managedPeer, ch PeerStateChange) {
+	pm.mu.Lock()
+	if mp.status.State == ch.State && ch.State == PeerConnected {
+		pm.mu.Unlock()
+		return
+	}
+	mp.status.State = ch.State
+	mp.status.Attempts = ch.Attempt
+	mp.status.LastErr = ch.Err
+	mp.status.NextRetry = time.Time{}
+	if ch.Retry > 0 {
+		mp.status.NextRetry = time.Now().Add(ch.Retry)
+	}
+	callbacks := slices.Clone(pm.callbacks)
+	pm.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(ch)
+	}
+}
+
+// jitter spreads retries by ±10% so peers dropped together do not
+// reconnect in lockstep
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*0.2-0.1)*float64(d))
+}
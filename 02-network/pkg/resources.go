@@ -0,0 +1,230 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// ScopeLimit overrides the resource manager limits of one scope. Zero
+// fields keep the default; a negative value means unlimited.
+type ScopeLimit struct {
+	Memory          int64 // bytes
+	Streams         int
+	StreamsInbound  int
+	StreamsOutbound int
+	Conns           int
+	ConnsInbound    int
+	ConnsOutbound   int
+}
+
+func (l ScopeLimit) toResourceLimits() rcmgr.ResourceLimits {
+	val := func(n int) rcmgr.LimitVal {
+		if n < 0 {
+			return rcmgr.Unlimited
+		}
+		return rcmgr.LimitVal(n)
+	}
+	mem := rcmgr.LimitVal64(l.Memory)
+	if l.Memory < 0 {
+		mem = rcmgr.Unlimited64
+	}
+	return rcmgr.ResourceLimits{
+		Streams:         val(l.Streams),
+		StreamsInbound:  val(l.StreamsInbound),
+		StreamsOutbound: val(l.StreamsOutbound),
+		Conns:           val(l.Conns),
+		ConnsInbound:    val(l.ConnsInbound),
+		ConnsOutbound:   val(l.ConnsOutbound),
+		Memory:          mem,
+	}
+}
+
+// ResourceConfig tunes libp2p's resource manager. The defaults are
+// libp2p's, scaled to the machine's memory and file descriptors; each
+// scope can be overridden on top of them.
+type ResourceConfig struct {
+	Disable bool // no resource manager at all; not for production
+
+	System      ScopeLimit            // everything this host uses
+	Transient   ScopeLimit            // conns/streams not yet tied to a peer or protocol
+	PeerDefault ScopeLimit            // each remote peer
+	Protocols   map[string]ScopeLimit // e.g. "/ipfs/bitswap/1.2.0"
+	Peers       map[string]ScopeLimit // by peer ID string
+}
+
+// limitConfig builds the concrete limits from the defaults plus overrides
+func (c ResourceConfig) limitConfig() (rcmgr.ConcreteLimitConfig, error) {
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+
+	partial := rcmgr.PartialLimitConfig{
+		System:      c.System.toResourceLimits(),
+		Transient:   c.Transient.toResourceLimits(),
+		PeerDefault: c.PeerDefault.toResourceLimits(),
+	}
+	if len(c.Protocols) > 0 {
+		partial.Protocol = make(map[protocol.ID]rcmgr.ResourceLimits, len(c.Protocols))
+		for id, l := range c.Protocols {
+			partial.Protocol[protocol.ID(id)] = l.toResourceLimits()
+		}
+	}
+	if len(c.Peers) > 0 {
+		partial.Peer = make(map[peer.ID]rcmgr.ResourceLimits, len(c.Peers))
+		for s, l := range c.Peers {
+			id, err := peer.Decode(s)
+			if err != nil {
+				return rcmgr.ConcreteLimitConfig{}, fmt.Errorf("resource limits: peer %q: %w", s, err)
+			}
+			partial.Peer[id] = l.toResourceLimits()
+		}
+	}
+	return partial.Build(scaling.AutoScale()), nil
+}
+
+// newResourceManager returns the manager for c and the counter of requests
+// it blocked; the counter is nil when the resource manager is disabled.
+func newResourceManager(c ResourceConfig) (network.ResourceManager, *blockCounter, error) {
+	if c.Disable {
+		return &network.NullResourceManager{}, nil, nil
+	}
+	limits, err := c.limitConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	blocked := &blockCounter{scopes: make(map[string]BlockedCount)}
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits), rcmgr.WithTraceReporter(blocked))
+	if err != nil {
+		return nil, nil, fmt.Errorf("resource manager: %w", err)
+	}
+	return rm, blocked, nil
+}
+
+// BlockedCount is how often a scope refused a reservation
+type BlockedCount struct {
+	Memory  int64
+	Streams int64
+	Conns   int64
+}
+
+// blockCounter tallies block events from the resource manager trace
+type blockCounter struct {
+	mu     sync.Mutex
+	scopes map[string]BlockedCount
+}
+
+func (b *blockCounter) ConsumeEvent(evt rcmgr.TraceEvt) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.scopes[evt.Name]
+	switch evt.Type {
+	case rcmgr.TraceBlockReserveMemoryEvt:
+		c.Memory++
+	case rcmgr.TraceBlockAddStreamEvt:
+		c.Streams++
+	case rcmgr.TraceBlockAddConnEvt:
+		c.Conns++
+	default:
+		return
+	}
+	b.scopes[evt.Name] = c
+}
+
+func (b *blockCounter) snapshot() map[string]BlockedCount {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make(map[string]BlockedCount, len(b.scopes))
+	for k, v := range b.scopes {
+		out[k] = v
+	}
+	return out
+}
+
+// ResourceUsage is the current use of one scope next to its limits
+type ResourceUsage struct {
+	Memory      int64
+	MemoryLimit int64
+
+	StreamsInbound  int
+	StreamsOutbound int
+	StreamLimit     int
+
+	ConnsInbound  int
+	ConnsOutbound int
+	ConnLimit     int
+
+	FD      int
+	FDLimit int
+}
+
+// ResourceReport is a snapshot of the resource manager. Blocked is keyed
+// by scope name ("system", "transient", "protocol:/ipfs/bitswap/1.2.0",
+// "peer:12D3...") and counts refusals since the host started; a growing
+// count on a protocol scope means its transfers are being throttled.
+type ResourceReport struct {
+	System    ResourceUsage
+	Transient ResourceUsage
+	Protocols map[protocol.ID]ResourceUsage
+	Blocked   map[string]BlockedCount
+}
+
+// Throttled reports whether any scope has refused a reservation
+func (r ResourceReport) Throttled() bool {
+	return len(r.Blocked) > 0
+}
+
+func usage(scope network.ResourceScope) ResourceUsage {
+	st := scope.Stat()
+	u := ResourceUsage{
+		Memory:          st.Memory,
+		StreamsInbound:  st.NumStreamsInbound,
+		StreamsOutbound: st.NumStreamsOutbound,
+		ConnsInbound:    st.NumConnsInbound,
+		ConnsOutbound:   st.NumConnsOutbound,
+		FD:              st.NumFD,
+	}
+	if sl, ok := scope.(rcmgr.ResourceScopeLimiter); ok {
+		l := sl.Limit()
+		u.MemoryLimit = l.GetMemoryLimit()
+		u.StreamLimit = l.GetStreamTotalLimit()
+		u.ConnLimit = l.GetConnTotalLimit()
+		u.FDLimit = l.GetFDLimit()
+	}
+	return u
+}
+
+// ReportResources returns current usage and limits for the system,
+// transient and active protocol scopes, plus how often each scope has
+// blocked. It returns an error when the resource manager is disabled.
+func (n *HostWrapper) ReportResources() (ResourceReport, error) {
+	if n.blocked == nil {
+		return ResourceReport{}, fmt.Errorf("resource manager disabled")
+	}
+	rm := n.Network().ResourceManager()
+	report := ResourceReport{
+		Protocols: make(map[protocol.ID]ResourceUsage),
+		Blocked:   n.blocked.snapshot(),
+	}
+	_ = rm.ViewSystem(func(s network.ResourceScope) error {
+		report.System = usage(s)
+		return nil
+	})
+	_ = rm.ViewTransient(func(s network.ResourceScope) error {
+		report.Transient = usage(s)
+		return nil
+	})
+	if state, ok := rm.(rcmgr.ResourceManagerState); ok {
+		for _, id := range state.ListProtocols() {
+			_ = rm.ViewProtocol(id, func(s network.ProtocolScope) error {
+				report.Protocols[id] = usage(s)
+				return nil
+			})
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,43 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// SetPeerRouting lets ConnectByID look up addresses of peers known only by
+// ID, typically with the DHT built on this host. Nil turns lookups off.
+func (n *HostWrapper) SetPeerRouting(r routing.PeerRouting) {
+	n.routerMu.Lock()
+	defer n.routerMu.Unlock()
+	n.router = r
+}
+
+func (n *HostWrapper) peerRouting() routing.PeerRouting {
+	n.routerMu.RLock()
+	defer n.routerMu.RUnlock()
+	return n.router
+}
+
+// ConnectByID dials the peer id. When the peerstore has no address for it,
+// it is looked up through the peer routing set with SetPeerRouting first.
+// Connect itself never looks peers up: the DHT dials through it, and a
+// lookup there would recurse into the DHT.
+func (n *HostWrapper) ConnectByID(ctx context.Context, id peer.ID) error {
+	pi := peer.AddrInfo{ID: id}
+	if len(n.Peerstore().Addrs(id)) == 0 {
+		if r := n.peerRouting(); r != nil && id != n.ID() {
+			found, err := r.FindPeer(ctx, id)
+			if err != nil {
+				return fmt.Errorf("no known addresses for %s: %w", id, err)
+			}
+			n.Peerstore().AddAddrs(id, found.Addrs, peerstore.TempAddrTTL)
+			pi.Addrs = found.Addrs
+		}
+	}
+	return n.Host.Connect(ctx, pi)
+}
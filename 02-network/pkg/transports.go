@@ -0,0 +1,80 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	webrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+)
+
+type Transport string
+
+const (
+	TransportTCP          Transport = "tcp"
+	TransportQUIC         Transport = "quic"
+	TransportWebSocket    Transport = "websocket"
+	TransportWebTransport Transport = "webtransport"
+	TransportWebRTC       Transport = "webrtc"
+)
+
+// defaultListenAddrs are used for each transport when Config.ListenAddrs is empty
+var defaultListenAddrs = map[Transport][]string{
+	TransportTCP:          {"/ip4/0.0.0.0/tcp/0"},
+	TransportQUIC:         {"/ip4/0.0.0.0/udp/0/quic-v1"},
+	TransportWebSocket:    {"/ip4/0.0.0.0/tcp/0/ws"},
+	TransportWebTransport: {"/ip4/0.0.0.0/udp/0/quic-v1/webtransport"},
+	TransportWebRTC:       {"/ip4/0.0.0.0/udp/0/webrtc-direct"},
+}
+
+// transportOptions returns the libp2p options enabling exactly the given
+// transports, plus listen addresses to use if none were configured.
+func transportOptions(transports []Transport) ([]libp2p.Option, []string, error) {
+	var opts []libp2p.Option
+	var addrs []string
+	seen := make(map[Transport]bool)
+	for _, t := range transports {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		switch t {
+		case TransportTCP:
+			opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+		case TransportQUIC:
+			opts = append(opts, libp2p.Transport(quic.NewTransport))
+		case TransportWebSocket:
+			opts = append(opts, libp2p.Transport(ws.New))
+		case TransportWebTransport:
+			opts = append(opts, libp2p.Transport(webtransport.New))
+		case TransportWebRTC:
+			opts = append(opts, libp2p.Transport(webrtc.New))
+		default:
+			return nil, nil, fmt.Errorf("unknown transport %q", t)
+		}
+		addrs = append(addrs, defaultListenAddrs[t]...)
+	}
+	return opts, addrs, nil
+}
+
+// connManagerOption trims connections once more than high are open, down
+// to low, sparing connections younger than the grace period.
+func connManagerOption(cfg *Config) (libp2p.Option, error) {
+	if cfg.ConnMgrLow > cfg.ConnMgrHigh {
+		return nil, fmt.Errorf("connection manager: low watermark %d above high %d", cfg.ConnMgrLow, cfg.ConnMgrHigh)
+	}
+	var cmOpts []connmgr.Option
+	if cfg.ConnMgrGrace > 0 {
+		cmOpts = append(cmOpts, connmgr.WithGracePeriod(cfg.ConnMgrGrace))
+	}
+	cm, err := connmgr.NewConnManager(cfg.ConnMgrLow, cfg.ConnMgrHigh, cmOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("connection manager: %w", err)
+	}
+	return libp2p.ConnectionManager(cm), nil
+}
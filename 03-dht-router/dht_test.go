@@ -2,15 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	p2pnet "github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-base32"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
 	block "github.com/gosuda/boxo-starter-kit/00-block-cid/pkg"
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
 	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
 	dht "github.com/gosuda/boxo-starter-kit/03-dht-router/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
 )
 
 func TestDHTBootstrap(t *testing.T) {
@@ -102,3 +123,896 @@ func TestProvideFindProvidersCID(t *testing.T) {
 	}
 	require.True(t, foundA, "provider A not found")
 }
+
+func TestDHTModes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	t.Run("Client Does Not Serve", func(t *testing.T) {
+		hS, err := network.New(nil)
+		require.NoError(t, err)
+		defer hS.Close()
+		hC, err := network.New(nil)
+		require.NoError(t, err)
+		defer hC.Close()
+
+		server, err := dht.NewWithConfig(ctx, hS, nil, &dht.Config{Mode: dht.ModeServer})
+		require.NoError(t, err)
+		client, err := dht.NewWithConfig(ctx, hC, nil, &dht.Config{Mode: dht.ModeClient})
+		require.NoError(t, err)
+		require.Equal(t, "server", server.Stats().Mode)
+		require.Equal(t, "client", client.Stats().Mode)
+
+		require.NoError(t, hC.ConnectToPeer(ctx, hS.GetFullAddresses()...))
+		require.NoError(t, client.Bootstrap(ctx))
+		require.Eventually(t, func() bool { return client.RoutingTableSize() == 1 }, 5*time.Second, 50*time.Millisecond)
+		// the server never adds a client-only peer to its table
+		require.Zero(t, server.RoutingTableSize())
+	})
+
+	t.Run("Dual", func(t *testing.T) {
+		h, err := network.New(nil)
+		require.NoError(t, err)
+		defer h.Close()
+
+		d, err := dht.NewWithConfig(ctx, h, nil, &dht.Config{Mode: dht.ModeServer, Dual: true})
+		require.NoError(t, err)
+		st := d.Stats()
+		require.True(t, st.Dual)
+		require.Equal(t, "server", st.Mode)
+		require.Equal(t, "server", st.LANMode)
+		require.Equal(t, st.WANTableSize+st.LANTableSize, st.RoutingTableSize)
+	})
+
+	t.Run("Invalid Mode", func(t *testing.T) {
+		_, err := dht.NewWithConfig(ctx, nil, nil, &dht.Config{Mode: dht.Mode(42)})
+		require.Error(t, err)
+	})
+}
+
+func TestIPNSOverDHT(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	hB, err := network.New(nil)
+	require.NoError(t, err)
+	defer hB.Close()
+
+	dA, err := dht.New(ctx, hA, nil)
+	require.NoError(t, err)
+	dB, err := dht.New(ctx, hB, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+
+	c, err := block.ComputeCID([]byte("ipns over dht"), nil)
+	require.NoError(t, err)
+	record := func(seq uint64) *ipns.Record {
+		rec, err := ipns.NewRecord(sk, path.FromCid(c), seq, time.Now().Add(time.Hour), time.Minute)
+		require.NoError(t, err)
+		return rec
+	}
+
+	t.Run("Put Get", func(t *testing.T) {
+		require.NoError(t, dA.PutIPNS(ctx, name, record(1)))
+
+		rec, err := dB.GetIPNS(ctx, name)
+		require.NoError(t, err)
+		seq, err := rec.Sequence()
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), seq)
+		val, err := rec.Value()
+		require.NoError(t, err)
+		require.Equal(t, path.FromCid(c).String(), val.String())
+	})
+
+	t.Run("Search Returns Newest", func(t *testing.T) {
+		require.NoError(t, dA.PutIPNS(ctx, name, record(2)))
+
+		ch, err := dB.SearchIPNS(ctx, name)
+		require.NoError(t, err)
+		var last *ipns.Record
+		for rec := range ch {
+			last = rec
+		}
+		require.NotNil(t, last)
+		seq, err := last.Sequence()
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), seq)
+	})
+
+	t.Run("Rejects Foreign Record", func(t *testing.T) {
+		other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		forged, err := ipns.NewRecord(other, path.FromCid(c), 9, time.Now().Add(time.Hour), time.Minute)
+		require.NoError(t, err)
+		require.Error(t, dA.PutIPNS(ctx, name, forged))
+	})
+}
+
+func TestProviderManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	hB, err := network.New(nil)
+	require.NoError(t, err)
+	defer hB.Close()
+
+	dA, err := dht.New(ctx, hA, nil)
+	require.NoError(t, err)
+	dB, err := dht.New(ctx, hB, nil)
+	require.NoError(t, err)
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer store.Close()
+
+	c, err := block.ComputeCID([]byte("reprovide me"), nil)
+	require.NoError(t, err)
+
+	t.Run("Provide And Track", func(t *testing.T) {
+		pm, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Store: store})
+		require.NoError(t, err)
+		defer pm.Close()
+
+		require.NoError(t, pm.Provide(ctx, c))
+		last, ok := pm.LastProvided(c)
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now(), last, 5*time.Second)
+
+		st := pm.Stats()
+		require.Equal(t, 1, st.Tracked)
+		require.Equal(t, int64(1), st.Provided)
+
+		provs, err := dB.FindProviders(ctx, c, 10)
+		require.NoError(t, err)
+		require.NotEmpty(t, provs)
+	})
+
+	t.Run("Reload And Reprovide", func(t *testing.T) {
+		pm, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Store: store, Interval: 200 * time.Millisecond})
+		require.NoError(t, err)
+		defer pm.Close()
+
+		require.Equal(t, []cid.Cid{c}, pm.Tracked())
+		require.Eventually(t, func() bool { return pm.Stats().Provided >= 2 }, 5*time.Second, 50*time.Millisecond)
+		require.False(t, pm.Stats().LastReprovide.IsZero())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		pm, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Store: store})
+		require.NoError(t, err)
+		require.NoError(t, pm.Remove(ctx, c))
+		require.Empty(t, pm.Tracked())
+		require.NoError(t, pm.Close())
+
+		pm, err = dA.NewProviderManager(ctx, dht.ProviderConfig{Store: store})
+		require.NoError(t, err)
+		defer pm.Close()
+		require.Empty(t, pm.Tracked())
+	})
+}
+
+func TestProvideMany(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	hB, err := network.New(nil)
+	require.NoError(t, err)
+	defer hB.Close()
+
+	dA, err := dht.New(ctx, hA, nil)
+	require.NoError(t, err)
+	dB, err := dht.New(ctx, hB, nil)
+	require.NoError(t, err)
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	t.Run("Bounded With Progress", func(t *testing.T) {
+		var keys []mh.Multihash
+		for i := range 20 {
+			c, err := block.ComputeCID([]byte{byte(i), 'k'}, nil)
+			require.NoError(t, err)
+			keys = append(keys, c.Hash())
+		}
+
+		var mu sync.Mutex
+		var last, total int
+		err := dA.ProvideManyWithOptions(ctx, keys, dht.ProvideManyOptions{
+			Concurrency: 4,
+			Progress: func(done, n int) {
+				mu.Lock()
+				defer mu.Unlock()
+				last, total = max(last, done), n
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 20, last)
+		require.Equal(t, 20, total)
+
+		provs, err := dB.FindProviders(ctx, cid.NewCidV1(cid.DagProtobuf, keys[7]), 10)
+		require.NoError(t, err)
+		require.NotEmpty(t, provs)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		require.NoError(t, dA.ProvideMany(ctx, nil))
+	})
+
+	t.Run("Accelerated", func(t *testing.T) {
+		_, err := dht.NewWithConfig(ctx, hA, nil, &dht.Config{Accelerated: true})
+		require.Error(t, err, "needs bootstrap peers")
+
+		var seeds []string
+		for _, a := range hA.GetFullAddresses() {
+			seeds = append(seeds, a.String())
+		}
+		h, err := network.New(&network.Config{Bootstrap: network.BootstrapConfig{Peers: seeds}})
+		require.NoError(t, err)
+		defer h.Close()
+
+		d, err := dht.NewWithConfig(ctx, h, nil, &dht.Config{Accelerated: true})
+		require.NoError(t, err)
+		defer d.Routing.(io.Closer).Close()
+		require.True(t, d.Stats().Accelerated)
+
+		_, err = dht.NewWithConfig(ctx, h, nil, &dht.Config{Accelerated: true, Dual: true})
+		require.Error(t, err)
+	})
+}
+
+func TestFindPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	newNode := func() (*network.HostWrapper, *dht.DHTWrapper) {
+		h, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+		require.NoError(t, err)
+		d, err := dht.New(ctx, h, nil)
+		require.NoError(t, err)
+		return h, d
+	}
+	hA, dA := newNode()
+	defer hA.Close()
+	hB, dB := newNode()
+	defer hB.Close()
+
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	t.Run("Two Nodes", func(t *testing.T) {
+		pi, err := dB.FindPeer(ctx, hA.ID())
+		require.NoError(t, err)
+		require.Equal(t, hA.ID(), pi.ID)
+		require.NotEmpty(t, pi.Addrs)
+
+		_, err = dB.FindPeer(ctx, "")
+		require.Error(t, err)
+
+		var pr routing.PeerRouting = dB.PeerRouting()
+		pi, err = pr.FindPeer(ctx, hA.ID())
+		require.NoError(t, err)
+		require.Equal(t, hA.ID(), pi.ID)
+	})
+
+	t.Run("Dial By ID", func(t *testing.T) {
+		// C only knows A; it learns B's addresses through the DHT
+		hC, dC := newNode()
+		defer hC.Close()
+		require.NoError(t, hC.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+		require.NoError(t, dC.Bootstrap(ctx))
+		require.Eventually(t, func() bool { return dC.RoutingTableSize() > 0 }, 5*time.Second, 50*time.Millisecond)
+		// forget anything the bootstrap taught C about B
+		_ = hC.Network().ClosePeer(hB.ID())
+		hC.Peerstore().ClearAddrs(hB.ID())
+		dC.Routing.(*kaddht.IpfsDHT).RoutingTable().RemovePeer(hB.ID())
+		require.Empty(t, hC.Peerstore().Addrs(hB.ID()))
+
+		require.NoError(t, hC.ConnectByID(ctx, hB.ID()))
+		require.Equal(t, p2pnet.Connected, hC.Network().Connectedness(hB.ID()))
+	})
+
+	t.Run("Unknown Without Routing", func(t *testing.T) {
+		h, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+		require.NoError(t, err)
+		defer h.Close()
+		require.Error(t, h.ConnectByID(ctx, hB.ID()))
+	})
+}
+
+func TestQueryTracing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+	require.NoError(t, err)
+	defer hA.Close()
+	dA, err := dht.New(ctx, hA, nil)
+	require.NoError(t, err)
+
+	hB, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+	require.NoError(t, err)
+	defer hB.Close()
+	dB, err := dht.NewWithConfig(ctx, hB, nil, &dht.Config{TraceQueries: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	hash, err := mh.Sum([]byte("traced content"), mh.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, hash)
+	require.NoError(t, dA.Provide(ctx, c, true))
+
+	t.Run("Records Hops", func(t *testing.T) {
+		providers, err := dB.FindProviders(ctx, c, 1)
+		require.NoError(t, err)
+		require.NotEmpty(t, providers)
+
+		traces := dB.QueryTraces()
+		require.Len(t, traces, 1)
+		tr := traces[0]
+		require.Equal(t, "find-providers", tr.Op)
+		require.Equal(t, c.String(), tr.Key)
+		require.Equal(t, len(providers), tr.Results)
+		require.Positive(t, tr.Duration)
+		require.NotEmpty(t, tr.Hops)
+		require.Equal(t, hA.ID(), tr.Hops[0].Peer)
+		require.Empty(t, tr.Hops[0].Err)
+
+		slow, ok := tr.Slowest()
+		require.True(t, ok)
+		require.Equal(t, hA.ID(), slow.Peer)
+	})
+
+	t.Run("Keeps Newest", func(t *testing.T) {
+		_, err := dB.FindPeer(ctx, hA.ID())
+		require.NoError(t, err)
+		_, err = dB.FindPeer(ctx, hA.ID())
+		require.NoError(t, err)
+
+		traces := dB.QueryTraces()
+		require.Len(t, traces, 2)
+		for _, tr := range traces {
+			require.Equal(t, "find-peer", tr.Op)
+		}
+		require.True(t, traces[0].Start.After(traces[1].Start))
+	})
+
+	t.Run("Debug Handler", func(t *testing.T) {
+		srv := httptest.NewServer(dB.DebugHandler())
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + "/debug/dht/queries")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var traces []dht.QueryTrace
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&traces))
+		require.Len(t, traces, 2)
+
+		resp, err = http.Get(srv.URL + "/debug/dht")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var st dht.Stats
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		require.Positive(t, st.RoutingTableSize)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		_, err := dA.FindPeer(ctx, hB.ID())
+		require.NoError(t, err)
+		require.Nil(t, dA.QueryTraces())
+
+		srv := httptest.NewServer(dA.DebugHandler())
+		defer srv.Close()
+		resp, err := http.Get(srv.URL + "/debug/dht/queries")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestRoutingTableHealth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	newNode := func() (*network.HostWrapper, *dht.DHTWrapper) {
+		h, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+		require.NoError(t, err)
+		d, err := dht.New(ctx, h, nil)
+		require.NoError(t, err)
+		return h, d
+	}
+	hA, dA := newNode()
+	defer hA.Close()
+
+	t.Run("Empty Degraded", func(t *testing.T) {
+		th, err := dA.TableHealth()
+		require.NoError(t, err)
+		require.Zero(t, th.Size)
+		require.Zero(t, th.ChurnPerMinute)
+
+		res := dA.RoutingTableCheck(0).Check(ctx)
+		require.Equal(t, health.StatusDegraded, res.Status)
+		require.Equal(t, "0", res.Metadata["peers"])
+	})
+
+	for range 3 {
+		h, d := newNode()
+		defer h.Close()
+		require.NoError(t, h.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+		require.NoError(t, d.Bootstrap(ctx))
+	}
+	require.Eventually(t, func() bool { return dA.RoutingTableSize() == 3 }, 5*time.Second, 50*time.Millisecond)
+
+	t.Run("Buckets And Churn", func(t *testing.T) {
+		time.Sleep(10 * time.Millisecond)
+		th, err := dA.TableHealth()
+		require.NoError(t, err)
+		require.Equal(t, 3, th.Size)
+		require.Positive(t, th.ChurnPerMinute)
+
+		var total int
+		for i, b := range th.Buckets {
+			require.Equal(t, i, b.Cpl)
+			total += b.Peers
+		}
+		require.Equal(t, 3, total)
+
+		// no change since the last sample
+		th, err = dA.TableHealth()
+		require.NoError(t, err)
+		require.Zero(t, th.ChurnPerMinute)
+	})
+
+	t.Run("Useful And Refreshed", func(t *testing.T) {
+		// a lookup marks the answering peers useful and refreshes buckets
+		_, err := dA.FindPeer(ctx, peer.ID("missing"))
+		require.Error(t, err)
+
+		th, err := dA.TableHealth()
+		require.NoError(t, err)
+		require.Positive(t, th.UsefulPeers)
+		require.InDelta(t, float64(th.UsefulPeers)/3, th.UsefulRatio, 1e-9)
+		require.False(t, th.LastRefresh.IsZero())
+
+		res := dA.RoutingTableCheck(0).Check(ctx)
+		require.Equal(t, health.StatusHealthy, res.Status, res.Message)
+
+		// anything older than a nanosecond counts as stale
+		res = dA.RoutingTableCheck(time.Nanosecond).Check(ctx)
+		require.Equal(t, health.StatusDegraded, res.Status)
+	})
+}
+
+// staticRouter answers every lookup with the same providers after a delay
+type staticRouter struct {
+	delay      time.Duration
+	providers  []peer.AddrInfo
+	provideErr error
+	provided   int
+	mu         sync.Mutex
+}
+
+func (r *staticRouter) Provide(context.Context, cid.Cid, bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provided++
+	return r.provideErr
+}
+
+func (r *staticRouter) FindProvidersAsync(ctx context.Context, _ cid.Cid, _ int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	go func() {
+		defer close(ch)
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return
+		}
+		for _, pi := range r.providers {
+			select {
+			case ch <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func TestComposedRouter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hash, err := mh.Sum([]byte("composed"), mh.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, hash)
+
+	ids := make([]peer.ID, 4)
+	for i := range ids {
+		_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		ids[i], err = peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+	}
+	infos := func(idx ...int) []peer.AddrInfo {
+		var out []peer.AddrInfo
+		for _, i := range idx {
+			out = append(out, peer.AddrInfo{ID: ids[i]})
+		}
+		return out
+	}
+	collect := func(ch <-chan peer.AddrInfo) []peer.ID {
+		var out []peer.ID
+		for pi := range ch {
+			out = append(out, pi.ID)
+		}
+		return out
+	}
+
+	fast := &staticRouter{providers: infos(0, 1)}
+	slow := &staticRouter{delay: 200 * time.Millisecond, providers: infos(1, 2)}
+	empty := &staticRouter{}
+
+	t.Run("Parallel Merges", func(t *testing.T) {
+		r, err := dht.NewComposedRouter(nil, dht.NamedRouter{Router: fast}, dht.NamedRouter{Router: slow})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []peer.ID{ids[0], ids[1], ids[2]}, collect(r.FindProvidersAsync(ctx, c, 0)))
+		require.Len(t, collect(r.FindProvidersAsync(ctx, c, 2)), 2)
+	})
+
+	t.Run("Race Keeps Winner", func(t *testing.T) {
+		r, err := dht.NewComposedRouter(&dht.ComposedConfig{Strategy: dht.StrategyRace},
+			dht.NamedRouter{Name: "slow", Router: slow}, dht.NamedRouter{Name: "fast", Router: fast})
+		require.NoError(t, err)
+		start := time.Now()
+		require.ElementsMatch(t, []peer.ID{ids[0], ids[1]}, collect(r.FindProvidersAsync(ctx, c, 0)))
+		require.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("Sequential Falls Through", func(t *testing.T) {
+		hanging := &staticRouter{delay: time.Hour, providers: infos(3)}
+		r, err := dht.NewComposedRouter(&dht.ComposedConfig{Strategy: dht.StrategySequential, Timeout: 50 * time.Millisecond},
+			dht.NamedRouter{Name: "hanging", Router: hanging},
+			dht.NamedRouter{Name: "empty", Router: empty},
+			dht.NamedRouter{Name: "slow", Router: slow, Timeout: time.Second},
+			dht.NamedRouter{Name: "fast", Router: fast})
+		require.NoError(t, err)
+		// slow answers within its own timeout, so fast is never asked
+		require.Equal(t, []peer.ID{ids[1], ids[2]}, collect(r.FindProvidersAsync(ctx, c, 0)))
+	})
+
+	t.Run("Provide", func(t *testing.T) {
+		failing := &staticRouter{provideErr: io.ErrUnexpectedEOF}
+		unsupported := &staticRouter{provideErr: routing.ErrNotSupported}
+		readOnly := &staticRouter{}
+		r, err := dht.NewComposedRouter(nil,
+			dht.NamedRouter{Name: "unsupported", Router: unsupported},
+			dht.NamedRouter{Name: "read-only", Router: readOnly, ReadOnly: true})
+		require.NoError(t, err)
+		require.NoError(t, r.Provide(ctx, c, true))
+		require.Equal(t, 1, unsupported.provided)
+		require.Zero(t, readOnly.provided)
+
+		r, err = dht.NewComposedRouter(nil, dht.NamedRouter{Name: "failing", Router: failing}, dht.NamedRouter{Router: empty})
+		require.NoError(t, err)
+		err = r.Provide(ctx, c, true)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		require.ErrorContains(t, err, "failing")
+	})
+
+	t.Run("Delegated", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/routing/v1/providers/"+c.String(), r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"Providers": []map[string]any{{
+					"Schema":    "peer",
+					"ID":        ids[3].String(),
+					"Addrs":     []string{"/ip4/127.0.0.1/tcp/4001"},
+					"Protocols": []string{"transport-bitswap"},
+				}},
+			})
+		}))
+		defer srv.Close()
+
+		delegated, err := dht.NewDelegatedRouter(srv.URL)
+		require.NoError(t, err)
+		r, err := dht.NewComposedRouter(&dht.ComposedConfig{Strategy: dht.StrategySequential},
+			dht.NamedRouter{Name: "empty", Router: empty},
+			dht.NamedRouter{Name: "delegated", Router: delegated, ReadOnly: true})
+		require.NoError(t, err)
+		require.Equal(t, []peer.ID{ids[3]}, collect(r.FindProvidersAsync(ctx, c, 0)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := dht.NewComposedRouter(nil)
+		require.Error(t, err)
+		_, err = dht.NewComposedRouter(nil, dht.NamedRouter{Name: "nil"})
+		require.Error(t, err)
+		_, err = dht.NewComposedRouter(&dht.ComposedConfig{Strategy: dht.Strategy(9)}, dht.NamedRouter{Router: fast})
+		require.Error(t, err)
+	})
+}
+
+// staticPins is a PinSource with a fixed pin set
+type staticPins struct {
+	pinned, roots []cid.Cid
+}
+
+func (p staticPins) PinnedCids(context.Context) ([]cid.Cid, error) { return p.pinned, nil }
+func (p staticPins) PinRoots(context.Context) ([]cid.Cid, error)   { return p.roots, nil }
+
+func TestReprovideStrategy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	hB, err := network.New(nil)
+	require.NoError(t, err)
+	defer hB.Close()
+
+	dA, err := dht.New(ctx, hA, nil)
+	require.NoError(t, err)
+	dB, err := dht.New(ctx, hB, nil)
+	require.NoError(t, err)
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	blocks, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer blocks.Close()
+	var cids []cid.Cid
+	for _, s := range []string{"root", "child", "unpinned"} {
+		blk, err := block.NewBlock([]byte(s), nil)
+		require.NoError(t, err)
+		require.NoError(t, blocks.Put(ctx, blk))
+		cids = append(cids, blk.Cid())
+	}
+	root, child, unpinned := cids[0], cids[1], cids[2]
+	pins := staticPins{pinned: []cid.Cid{root, child}, roots: []cid.Cid{root}}
+
+	t.Run("All", func(t *testing.T) {
+		pm, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Blocks: blocks})
+		require.NoError(t, err)
+		defer pm.Close()
+
+		n, err := pm.Reprovide(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		provs, err := dB.FindProviders(ctx, unpinned, 1)
+		require.NoError(t, err)
+		require.NotEmpty(t, provs)
+	})
+
+	t.Run("Pinned", func(t *testing.T) {
+		pm, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Strategy: dht.ReprovidePinned, Blocks: blocks, Pins: pins})
+		require.NoError(t, err)
+		defer pm.Close()
+
+		n, err := pm.Reprovide(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+	})
+
+	t.Run("Roots Plus Provide Set", func(t *testing.T) {
+		pm, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Strategy: dht.ReprovideRoots, Pins: pins})
+		require.NoError(t, err)
+		defer pm.Close()
+
+		require.NoError(t, pm.Provide(ctx, unpinned))
+		require.NoError(t, pm.Provide(ctx, root))
+		n, err := pm.Reprovide(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := dA.NewProviderManager(ctx, dht.ProviderConfig{Strategy: dht.ReprovidePinned})
+		require.Error(t, err)
+		_, err = dA.NewProviderManager(ctx, dht.ProviderConfig{Strategy: dht.ReprovideStrategy(7)})
+		require.Error(t, err)
+		require.Equal(t, "roots", dht.ReprovideRoots.String())
+	})
+}
+
+func TestRecordStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	hB, err := network.New(nil)
+	require.NoError(t, err)
+	defer hB.Close()
+
+	dA, err := dht.NewWithConfig(ctx, hA, nil, &dht.Config{Records: dht.RecordStoreConfig{MaxRecordAge: time.Hour}})
+	require.NoError(t, err)
+	defer dA.Close()
+	dB, err := dht.New(ctx, hB, nil)
+	require.NoError(t, err)
+	defer dB.Close()
+
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	c, err := block.ComputeCID([]byte("record store"), nil)
+	require.NoError(t, err)
+	rec, err := ipns.NewRecord(sk, path.FromCid(c), 1, time.Now().Add(time.Hour), time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, dB.PutIPNS(ctx, ipns.NameFromPeer(pid), rec))
+
+	records := dA.Records()
+	require.NotNil(t, records)
+
+	t.Run("Stats By Type", func(t *testing.T) {
+		st, err := records.Stats(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, st.Values["ipns"])
+		require.Zero(t, st.Expired)
+	})
+
+	t.Run("GC Drops Expired", func(t *testing.T) {
+		store := records.Datastore()
+		stale, err := proto.Marshal(&recpb.Record{
+			Key:          []byte("/ipns/stale"),
+			Value:        []byte("old"),
+			TimeReceived: time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339Nano),
+		})
+		require.NoError(t, err)
+		staleKey := datastore.NewKey(base32.RawStdEncoding.EncodeToString([]byte("/ipns/stale")))
+		require.NoError(t, store.Put(ctx, staleKey, stale))
+
+		provKey := func(p string) datastore.Key {
+			return datastore.NewKey("/providers/" + base32.RawStdEncoding.EncodeToString(c.Hash()) + "/" + p)
+		}
+		ts := func(at time.Time) []byte {
+			buf := make([]byte, binary.MaxVarintLen64)
+			return buf[:binary.PutVarint(buf, at.UnixNano())]
+		}
+		require.NoError(t, store.Put(ctx, provKey("FRESH"), ts(time.Now())))
+		require.NoError(t, store.Put(ctx, provKey("OLD"), ts(time.Now().Add(-72*time.Hour))))
+
+		st, err := records.Stats(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, st.Values["ipns"])
+		require.Equal(t, 2, st.Providers)
+		require.Equal(t, 2, st.Expired)
+
+		n, err := records.GC(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+
+		st, err = records.Stats(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, st.Values["ipns"])
+		require.Equal(t, 1, st.Providers)
+		require.Zero(t, st.Expired)
+		require.EqualValues(t, 2, st.Collected)
+		require.False(t, st.LastGC.IsZero())
+
+		has, err := store.Has(ctx, staleKey)
+		require.NoError(t, err)
+		require.False(t, has)
+	})
+}
+
+func TestCrawlStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	dA, err := dht.NewWithConfig(ctx, hA, nil, &dht.Config{Mode: dht.ModeServer})
+	require.NoError(t, err)
+	defer dA.Close()
+
+	t.Run("Not Accelerated", func(t *testing.T) {
+		_, err := dA.CrawlStatus()
+		require.Error(t, err)
+		require.Error(t, dA.WaitReady(ctx))
+	})
+
+	var seeds []string
+	for _, a := range hA.GetFullAddresses() {
+		seeds = append(seeds, a.String())
+	}
+	h, err := network.New(&network.Config{Bootstrap: network.BootstrapConfig{Peers: seeds}})
+	require.NoError(t, err)
+	defer h.Close()
+	d, err := dht.NewWithConfig(ctx, h, nil, &dht.Config{Accelerated: true, CrawlInterval: time.Minute})
+	require.NoError(t, err)
+	defer d.Close()
+
+	t.Run("Progress", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			st, err := d.CrawlStatus()
+			return err == nil && st.Rounds > 0
+		}, 10*time.Second, 50*time.Millisecond)
+
+		st, err := d.CrawlStatus()
+		require.NoError(t, err)
+		require.False(t, st.Crawling)
+		require.GreaterOrEqual(t, st.Queried, 1)
+		require.Equal(t, st.Queried, st.Succeeded+st.Failed)
+		require.GreaterOrEqual(t, st.Succeeded, 1, "the bootstrap peer answers")
+		require.False(t, st.LastCrawl.IsZero())
+		// loopback peers never enter the public routing table
+		require.False(t, st.Ready)
+	})
+
+	t.Run("Wait Ready", func(t *testing.T) {
+		wctx, wcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer wcancel()
+		require.ErrorIs(t, d.WaitReady(wctx), context.DeadlineExceeded)
+	})
+
+	t.Run("Debug Endpoint", func(t *testing.T) {
+		srv := httptest.NewServer(d.DebugHandler())
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + "/debug/dht/crawl")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var st dht.CrawlStatus
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		require.GreaterOrEqual(t, st.Rounds, 1)
+	})
+}
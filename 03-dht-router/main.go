@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -14,7 +18,21 @@ import (
 	dht "github.com/gosuda/boxo-starter-kit/03-dht-router/pkg"
 )
 
+// debugAddr serves the multi-node demo's /debug/dht endpoints when set
+var debugAddr string
+
+// accelerated adds the accelerated client to the setup demo
+var accelerated bool
+
 func main() {
+	bootstrap := flag.String("bootstrap", "", `bootstrap peers: comma-separated /p2p multiaddrs, "public" or "none" (overrides $`+network.BootstrapEnv+`)`)
+	flag.StringVar(&debugAddr, "debug", "", "serve /debug/dht on this address (e.g. 127.0.0.1:6060) and wait for Ctrl-C after the multi-node demo")
+	flag.BoolVar(&accelerated, "accelerated", false, "also start the accelerated DHT client (needs -bootstrap) and show its crawl progress")
+	flag.Parse()
+	if *bootstrap != "" {
+		os.Setenv(network.BootstrapEnv, *bootstrap)
+	}
+
 	fmt.Println("🌐 DHT (Distributed Hash Table) Router Demo")
 	fmt.Println("==========================================")
 
@@ -99,10 +117,65 @@ func demonstrateDHTSetup(ctx context.Context) {
 	fmt.Printf("   ✅ Routing table size: %d peers\n", dht2.RoutingTableSize())
 	fmt.Printf("   ✅ Storage: File-based (persistent)\n")
 
+	// 3. Lightweight client-only DHT with separate LAN/WAN tables
+	fmt.Printf("\n🪶 3. Client-only dual DHT:\n")
+	host3, err := network.New(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer host3.Close()
+
+	dht3, err := dht.NewWithConfig(ctx, host3, nil, &dht.Config{Mode: dht.ModeClient, Dual: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stats := dht3.Stats()
+	fmt.Printf("   ✅ Mode: WAN %s, LAN %s (queries only, serves no records)\n", stats.Mode, stats.LANMode)
+	fmt.Printf("   ✅ Routing tables: WAN %d, LAN %d peers\n", stats.WANTableSize, stats.LANTableSize)
+
+	// 4. Accelerated client, crawling the whole network
+	if accelerated {
+		fmt.Printf("\n⚡ 4. Accelerated client:\n")
+		demonstrateAccelerated(ctx)
+	}
+
 	// Clean up
 	filePersistent.Close()
 }
 
+func demonstrateAccelerated(ctx context.Context) {
+	host, err := network.New(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer host.Close()
+
+	d, err := dht.NewWithConfig(ctx, host, nil, &dht.Config{Accelerated: true})
+	if err != nil {
+		fmt.Printf("   ❌ %v\n", err)
+		return
+	}
+	defer d.Close()
+
+	// the first crawl takes minutes on the public network; show a few
+	// seconds of it
+	for range 5 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		st, err := d.CrawlStatus()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("   🔄 crawl: %d queried (%d ok, %d failed), %d peers discovered, ready: %v\n",
+			st.Queried, st.Succeeded, st.Failed, st.Discovered, st.Ready)
+	}
+	fmt.Printf("   💡 Use WaitReady before bulk providing with ProvideMany\n")
+}
+
 func demonstrateProviderOperations(ctx context.Context) {
 	fmt.Printf("Demonstrating provider advertisement and discovery...\n")
 
@@ -238,6 +311,12 @@ func demonstrateRoutingTable(ctx context.Context) {
 		fmt.Printf("   💡 No new peers added (requires bootstrap connections)\n")
 	}
 
+	if th, err := dhtNode.TableHealth(); err == nil {
+		fmt.Printf("   🪣 Buckets: %d, useful peers: %.0f%%, churn: %.1f/min\n", len(th.Buckets), th.UsefulRatio*100, th.ChurnPerMinute)
+	}
+	res := dhtNode.RoutingTableCheck(0).Check(ctx)
+	fmt.Printf("   🩺 Health: %s (%s)\n", res.Status, res.Message)
+
 	fmt.Printf("\n🏗️  DHT Architecture Overview:\n")
 	fmt.Printf("   • Each node maintains a routing table of known peers\n")
 	fmt.Printf("   • Peers are organized by XOR distance from our node ID\n")
@@ -256,14 +335,21 @@ func demonstrateMultiNodeDHT(ctx context.Context) {
 	// Create multiple DHT nodes
 	fmt.Printf("\n🏗️  Creating %d DHT nodes:\n", numNodes)
 	for i := 0; i < numNodes; i++ {
-		host, err := network.New(nil)
+		// Later nodes bootstrap from the first one so they join one network
+		cfg := &network.Config{}
+		if len(hosts) > 0 {
+			for _, a := range hosts[0].GetFullAddresses() {
+				cfg.Bootstrap.Peers = append(cfg.Bootstrap.Peers, a.String())
+			}
+		}
+		host, err := network.New(cfg)
 		if err != nil {
 			log.Printf("   ❌ Failed to create host %d: %v\n", i, err)
 			continue
 		}
 		hosts = append(hosts, host)
 
-		dhtNode, err := dht.New(ctx, host, nil)
+		dhtNode, err := dht.NewWithConfig(ctx, host, nil, &dht.Config{TraceQueries: 16})
 		if err != nil {
 			log.Printf("   ❌ Failed to create DHT %d: %v\n", i, err)
 			host.Close()
@@ -271,7 +357,7 @@ func demonstrateMultiNodeDHT(ctx context.Context) {
 		}
 		nodes = append(nodes, dhtNode)
 
-		fmt.Printf("   ✅ Node %d: %s\n", i+1, host.ID().String()[:20]+"...")
+		fmt.Printf("   ✅ Node %d: %s (%d bootstrap peer(s))\n", i+1, host.ID().String()[:20]+"...", len(host.BootstrapPeers()))
 	}
 
 	// Clean up
@@ -345,6 +431,30 @@ func demonstrateMultiNodeDHT(ctx context.Context) {
 		tableSize := node.RoutingTableSize()
 		fmt.Printf("   Node %d routing table: %d peers\n", i, tableSize)
 	}
+
+	// Query traces show where a lookup spent its time
+	fmt.Printf("\n🧭 Last lookups of node 0:\n")
+	for _, tr := range nodes[0].QueryTraces() {
+		fmt.Printf("   %s %s...: %d result(s) in %v over %d hop(s)", tr.Op, tr.Key[:20], tr.Results, tr.Duration.Round(time.Microsecond), len(tr.Hops))
+		if slow, ok := tr.Slowest(); ok {
+			fmt.Printf(", slowest %s... %v", slow.Peer.String()[:20], slow.Latency.Round(time.Microsecond))
+		}
+		fmt.Println()
+	}
+
+	if debugAddr != "" {
+		srv := &http.Server{Addr: debugAddr, Handler: nodes[0].DebugHandler()}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("   ❌ Debug server: %v\n", err)
+			}
+		}()
+		fmt.Printf("\n🐞 Inspect http://%s/debug/dht/queries, Ctrl-C to continue\n", debugAddr)
+		sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		<-sigCtx.Done()
+		stop()
+		srv.Close()
+	}
 }
 
 func demonstrateDHTMetrics(ctx context.Context) {
@@ -0,0 +1,263 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/contentrouter"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// DefaultDelegatedEndpoint is the public delegated routing endpoint run by
+// the IPFS project; it answers from the DHT and cid.contact
+const DefaultDelegatedEndpoint = "https://delegated-ipfs.dev"
+
+// DefaultRouterTimeout bounds each router of a sequential lookup
+const DefaultRouterTimeout = 5 * time.Second
+
+// NewDelegatedRouter returns a content router backed by a delegated routing
+// HTTP endpoint (/routing/v1). Provide needs an identity on the endpoint,
+// so it is usually added to a ComposedRouter as ReadOnly.
+func NewDelegatedRouter(endpoint string) (routing.ContentRouting, error) {
+	c, err := client.New(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("delegated router %s: %w", endpoint, err)
+	}
+	return contentrouter.NewContentRoutingClient(c), nil
+}
+
+// Strategy decides how a ComposedRouter spreads a provider lookup over its
+// routers
+type Strategy int
+
+const (
+	StrategyParallel   Strategy = iota // ask every router at once and merge the results (default)
+	StrategyRace                       // ask every router at once, keep only the first one to answer
+	StrategySequential                 // ask routers in order until one finds providers
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyParallel:
+		return "parallel"
+	case StrategyRace:
+		return "race"
+	case StrategySequential:
+		return "sequential"
+	}
+	return fmt.Sprintf("strategy(%d)", int(s))
+}
+
+// NamedRouter is one content router inside a ComposedRouter
+type NamedRouter struct {
+	Name   string // shows up in errors; defaults to router-<index>
+	Router routing.ContentRouting
+
+	// Bounds this router in a sequential lookup (default ComposedConfig.Timeout)
+	Timeout time.Duration
+	// Never send provider records here, e.g. a delegated endpoint or an index
+	ReadOnly bool
+}
+
+type ComposedConfig struct {
+	Strategy Strategy
+	Timeout  time.Duration // per router for StrategySequential (default 5s)
+}
+
+// ComposedRouter combines several content routers, e.g. the DHT, an IPNI
+// index and a delegated HTTP endpoint, behind one routing.ContentRouting.
+// Lookups follow the configured Strategy and never return a provider twice.
+type ComposedRouter struct {
+	routers  []NamedRouter
+	strategy Strategy
+}
+
+var _ routing.ContentRouting = (*ComposedRouter)(nil)
+
+func NewComposedRouter(cfg *ComposedConfig, routers ...NamedRouter) (*ComposedRouter, error) {
+	if cfg == nil {
+		cfg = &ComposedConfig{}
+	}
+	switch cfg.Strategy {
+	case StrategyParallel, StrategyRace, StrategySequential:
+	default:
+		return nil, fmt.Errorf("unknown routing strategy %d", int(cfg.Strategy))
+	}
+	if len(routers) == 0 {
+		return nil, fmt.Errorf("composed router needs at least one router")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRouterTimeout
+	}
+
+	out := make([]NamedRouter, len(routers))
+	for i, r := range routers {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("router-%d", i)
+		}
+		if r.Router == nil {
+			return nil, fmt.Errorf("router %s is nil", r.Name)
+		}
+		if r.Timeout <= 0 {
+			r.Timeout = timeout
+		}
+		out[i] = r
+	}
+	return &ComposedRouter{routers: out, strategy: cfg.Strategy}, nil
+}
+
+// Routers returns the composed routers in lookup order
+func (r *ComposedRouter) Routers() []NamedRouter {
+	return append([]NamedRouter(nil), r.routers...)
+}
+
+// Provide announces c on every router that is not ReadOnly, in parallel.
+// Routers that report routing.ErrNotSupported are skipped; the error joins
+// the failures of the others.
+func (r *ComposedRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, nr := range r.routers {
+		if nr.ReadOnly {
+			continue
+		}
+		wg.Add(1)
+		go func(nr NamedRouter) {
+			defer wg.Done()
+			err := nr.Router.Provide(ctx, c, announce)
+			if err == nil || errors.Is(err, routing.ErrNotSupported) {
+				return
+			}
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("%s: %w", nr.Name, err))
+			mu.Unlock()
+		}(nr)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// FindProvidersAsync looks c up according to the strategy and streams up to
+// count distinct providers (0 means no limit).
+func (r *ComposedRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		seen := make(map[peer.ID]struct{})
+		// emit forwards pi unless already sent and reports whether to go on
+		emit := func(pi peer.AddrInfo) bool {
+			if _, ok := seen[pi.ID]; ok {
+				return true
+			}
+			seen[pi.ID] = struct{}{}
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return false
+			}
+			return count <= 0 || len(seen) < count
+		}
+
+		switch r.strategy {
+		case StrategySequential:
+			r.findSequential(ctx, c, count, emit)
+		default:
+			r.findConcurrent(ctx, c, count, r.strategy == StrategyRace, emit)
+		}
+	}()
+	return out
+}
+
+// findSequential asks one router at a time, each within its timeout, and
+// stops after the first that returns anything
+func (r *ComposedRouter) findSequential(ctx context.Context, c cid.Cid, count int, emit func(peer.AddrInfo) bool) {
+	for _, nr := range r.routers {
+		rctx, cancel := context.WithTimeout(ctx, nr.Timeout)
+		found, stop := 0, false
+		for pi := range nr.Router.FindProvidersAsync(rctx, c, count) {
+			found++
+			if !emit(pi) {
+				stop = true
+				break
+			}
+		}
+		cancel()
+		if stop || found > 0 || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// findConcurrent asks all routers at once. With race set, the first router
+// to return a provider wins and the others are cancelled.
+func (r *ComposedRouter) findConcurrent(ctx context.Context, c cid.Cid, count int, race bool, emit func(peer.AddrInfo) bool) {
+	type result struct {
+		router int
+		pi     peer.AddrInfo
+	}
+	results := make(chan result)
+	cancels := make([]context.CancelFunc, len(r.routers))
+
+	var wg sync.WaitGroup
+	for i, nr := range r.routers {
+		rctx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		wg.Add(1)
+		go func(i int, nr NamedRouter) {
+			defer wg.Done()
+			defer cancel()
+			for pi := range nr.Router.FindProvidersAsync(rctx, c, count) {
+				select {
+				case results <- result{router: i, pi: pi}:
+				case <-rctx.Done():
+					return
+				}
+			}
+		}(i, nr)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		// let the router goroutines see the cancellation and exit
+		for range results {
+		}
+	}()
+
+	winner := -1
+	for res := range results {
+		if race {
+			if winner < 0 {
+				winner = res.router
+				for i, cancel := range cancels {
+					if i != winner {
+						cancel()
+					}
+				}
+			}
+			if res.router != winner {
+				continue
+			}
+		}
+		if !emit(res.pi) {
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DefaultCrawlInterval is how often the accelerated client re-crawls the
+// network, the kad-dht default
+const DefaultCrawlInterval = time.Hour
+
+// CrawlStatus reports the network crawls of the accelerated client. Until
+// the first crawl completes, lookups and ProvideMany fall back to the
+// regular path, so check Ready (or WaitReady) before relying on it.
+type CrawlStatus struct {
+	Ready    bool `json:"ready"`    // a recent crawl filled the routing table
+	Crawling bool `json:"crawling"` // a crawl is running now
+	Rounds   int  `json:"rounds"`   // completed crawls
+
+	// Progress of the running crawl, or of the last one when idle
+	Started    time.Time `json:"started"`
+	Queried    int       `json:"queried"`    // peers asked for their routing table
+	Succeeded  int       `json:"succeeded"`  // peers that answered
+	Failed     int       `json:"failed"`     // peers that could not be dialed or queried
+	Discovered int       `json:"discovered"` // distinct peers learned from the answers
+
+	LastCrawl    time.Time     `json:"last_crawl"` // end of the last completed crawl
+	LastDuration time.Duration `json:"last_duration_ns"`
+	TableSize    int           `json:"table_size"` // peers in the full routing table
+}
+
+// crawlProgress wraps the crawler of the accelerated client to count the
+// peers each crawl visits
+type crawlProgress struct {
+	crawler.Crawler
+
+	mu     sync.Mutex
+	status CrawlStatus
+	seen   map[peer.ID]struct{}
+}
+
+func newCrawlProgress(h host.Host) (*crawlProgress, error) {
+	// same parallelism fullrt uses for its own default crawler
+	c, err := crawler.NewDefaultCrawler(h, crawler.WithParallelism(200))
+	if err != nil {
+		return nil, fmt.Errorf("crawler: %w", err)
+	}
+	return &crawlProgress{Crawler: c}, nil
+}
+
+func (c *crawlProgress) Run(ctx context.Context, startingPeers []*peer.AddrInfo, handleSuccess crawler.HandleQueryResult, handleFail crawler.HandleQueryFail) {
+	c.mu.Lock()
+	c.status.Crawling = true
+	c.status.Started = time.Now()
+	c.status.Queried, c.status.Succeeded, c.status.Failed, c.status.Discovered = 0, 0, 0, 0
+	c.seen = make(map[peer.ID]struct{})
+	c.mu.Unlock()
+
+	c.Crawler.Run(ctx, startingPeers,
+		func(p peer.ID, rtPeers []*peer.AddrInfo) {
+			c.mu.Lock()
+			c.status.Queried++
+			c.status.Succeeded++
+			for _, ai := range rtPeers {
+				if _, ok := c.seen[ai.ID]; !ok {
+					c.seen[ai.ID] = struct{}{}
+					c.status.Discovered++
+				}
+			}
+			c.mu.Unlock()
+			handleSuccess(p, rtPeers)
+		},
+		func(p peer.ID, err error) {
+			c.mu.Lock()
+			c.status.Queried++
+			// the crawler reports peers with an empty table as failed,
+			// without an error
+			if err == nil {
+				c.status.Succeeded++
+			} else {
+				c.status.Failed++
+			}
+			c.mu.Unlock()
+			handleFail(p, err)
+		})
+
+	c.mu.Lock()
+	c.status.Crawling = false
+	c.status.Rounds++
+	c.status.LastCrawl = time.Now()
+	c.status.LastDuration = c.status.LastCrawl.Sub(c.status.Started)
+	c.seen = nil
+	c.mu.Unlock()
+}
+
+func (c *crawlProgress) snapshot() CrawlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// CrawlStatus reports the progress of the accelerated client's crawls.
+// It fails unless the DHT was built with Config.Accelerated.
+func (w *DHTWrapper) CrawlStatus() (CrawlStatus, error) {
+	rt, ok := w.Routing.(*fullrt.FullRT)
+	if !ok || w.crawl == nil {
+		return CrawlStatus{}, fmt.Errorf("crawl status needs the accelerated client")
+	}
+	st := w.crawl.snapshot()
+	st.Ready = rt.Ready()
+	st.TableSize = len(rt.Stat())
+	return st, nil
+}
+
+// WaitReady blocks until the accelerated client has crawled the network
+// or ctx is done
+func (w *DHTWrapper) WaitReady(ctx context.Context) error {
+	if _, err := w.CrawlStatus(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if st, _ := w.CrawlStatus(); st.Ready {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("accelerated client not ready: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,9 +3,13 @@ package dht
 import (
 	"context"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/dual"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/routing"
 
@@ -15,6 +19,10 @@ import (
 
 type DHTWrapper struct {
 	routing.Routing
+	records *RecordStore   // nil when built with NewWithRouting
+	tracer  *QueryTracer   // nil unless Config.TraceQueries is set
+	crawl   *crawlProgress // nil unless Config.Accelerated is set
+	churn   churnSampler
 }
 
 func NewWithRouting(ctx context.Context, r routing.Routing) (*DHTWrapper, error) {
@@ -23,8 +31,83 @@ func NewWithRouting(ctx context.Context, r routing.Routing) (*DHTWrapper, error)
 	}, nil
 }
 
+// Mode selects whether this node answers DHT queries
+type Mode int
+
+const (
+	ModeAutoServer Mode = iota // server unless AutoNAT reports private reachability (default)
+	ModeAuto                   // client until AutoNAT reports public reachability
+	ModeClient                 // query only, never serve records
+	ModeServer                 // always serve records
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeAutoServer:
+		return "auto-server"
+	case ModeAuto:
+		return "auto"
+	case ModeClient:
+		return "client"
+	case ModeServer:
+		return "server"
+	}
+	return fmt.Sprintf("mode(%d)", int(m))
+}
+
+func (m Mode) option() (dht.ModeOpt, error) {
+	switch m {
+	case ModeAutoServer:
+		return dht.ModeAutoServer, nil
+	case ModeAuto:
+		return dht.ModeAuto, nil
+	case ModeClient:
+		return dht.ModeClient, nil
+	case ModeServer:
+		return dht.ModeServer, nil
+	}
+	return 0, fmt.Errorf("unknown dht mode %d", int(m))
+}
+
+type Config struct {
+	Mode Mode
+
+	// Run a LAN DHT (private addresses only) next to the WAN one (public
+	// addresses only), like kubo does. Queries go to both.
+	Dual bool
+
+	// Use the accelerated client, which crawls the whole network to keep a
+	// full routing table and can send provider records in bulk
+	// (ProvideMany). Client only, so Mode is ignored; needs bootstrap peers.
+	// The first crawl takes minutes; watch it with CrawlStatus.
+	Accelerated bool
+	// How often the accelerated client re-crawls the network (default 1h)
+	CrawlInterval time.Duration
+
+	// Keep a hop-by-hop trace of the last N FindProviders/FindPeer lookups
+	// (QueryTraces, DebugHandler); 0 disables tracing
+	TraceQueries int
+
+	// Lifetimes and GC interval of the records kept for other peers
+	Records RecordStoreConfig
+}
+
 func New(ctx context.Context, host *network.HostWrapper, persistentWrapper *persistent.PersistentWrapper) (*DHTWrapper, error) {
+	return NewWithConfig(ctx, host, persistentWrapper, nil)
+}
+
+func NewWithConfig(ctx context.Context, host *network.HostWrapper, persistentWrapper *persistent.PersistentWrapper, cfg *Config) (*DHTWrapper, error) {
 	var err error
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	mode, err := cfg.Mode.option()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Accelerated && cfg.Dual {
+		return nil, fmt.Errorf("accelerated client cannot be combined with a dual DHT")
+	}
 	if host == nil {
 		host, err = network.New(nil)
 		if err != nil {
@@ -38,13 +121,81 @@ func New(ctx context.Context, host *network.HostWrapper, persistentWrapper *pers
 		}
 	}
 
-	ipfsdht, err := dht.New(ctx, host,
-		dht.Mode(dht.ModeAutoServer),
-		dht.Datastore(persistentWrapper.Batching))
+	records := NewRecordStore(persistentWrapper, cfg.Records)
+	opts := []dht.Option{
+		dht.Mode(mode),
+		dht.Datastore(records.Datastore()),
+		dht.MaxRecordAge(records.MaxRecordAge()),
+	}
+	if peers := host.BootstrapPeers(); len(peers) > 0 {
+		opts = append(opts, dht.BootstrapPeers(peers...))
+	}
+
+	var crawl *crawlProgress
+	var r interface {
+		routing.Routing
+		Close() error
+	}
+	switch {
+	case cfg.Accelerated:
+		if len(host.BootstrapPeers()) == 0 {
+			return nil, fmt.Errorf("accelerated client needs bootstrap peers")
+		}
+		interval := cfg.CrawlInterval
+		if interval <= 0 {
+			interval = DefaultCrawlInterval
+		}
+		if crawl, err = newCrawlProgress(host); err != nil {
+			return nil, err
+		}
+		r, err = fullrt.NewFullRT(host, dht.DefaultPrefix,
+			fullrt.DHTOption(append(opts, dht.BucketSize(20))...),
+			fullrt.WithCrawler(crawl),
+			fullrt.WithCrawlInterval(interval),
+		)
+	case cfg.Dual:
+		r, err = dual.New(ctx, host, dual.DHTOption(opts...))
+	default:
+		r, err = dht.New(ctx, host, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(host.BootstrapPeers()) > 0 {
+		if err := r.Bootstrap(ctx); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("bootstrap dht: %w", err)
+		}
+	}
+	w, err := NewWithRouting(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	return NewWithRouting(ctx, ipfsdht)
+	if cfg.TraceQueries > 0 {
+		w.tracer = NewQueryTracer(cfg.TraceQueries)
+	}
+	w.records = records
+	w.crawl = crawl
+	records.Start()
+	host.SetPeerRouting(w.PeerRouting())
+	return w, nil
+}
+
+// Records returns the store holding records for other peers; nil when
+// the wrapper was built with NewWithRouting
+func (w *DHTWrapper) Records() *RecordStore {
+	return w.records
+}
+
+// Close stops the record GC and shuts the DHT down
+func (w *DHTWrapper) Close() error {
+	if w.records != nil {
+		w.records.Close()
+	}
+	if c, ok := w.Routing.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
 
 func (w *DHTWrapper) FindProviders(ctx context.Context, c cid.Cid, max int) ([]peer.AddrInfo, error) {
@@ -52,17 +203,87 @@ func (w *DHTWrapper) FindProviders(ctx context.Context, c cid.Cid, max int) ([]p
 		return nil, fmt.Errorf("undefined cid")
 	}
 
+	ctx, q := w.tracer.begin(ctx, "find-providers", c.String())
 	ch := w.Routing.FindProvidersAsync(ctx, c, 0)
 	var out []peer.AddrInfo
 	for pi := range ch {
 		out = append(out, pi)
 	}
+	q.end(len(out), ctx.Err())
 	return out, nil
 }
 
+// FindPeer looks up the addresses of p in the DHT
+func (w *DHTWrapper) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	if p == "" {
+		return peer.AddrInfo{}, fmt.Errorf("missing peer id")
+	}
+	ctx, q := w.tracer.begin(ctx, "find-peer", p.String())
+	pi, err := w.Routing.FindPeer(ctx, p)
+	q.end(len(pi.Addrs), err)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("find peer %s: %w", p, err)
+	}
+	return pi, nil
+}
+
+// PeerRouting returns the wrapper as a routing.PeerRouting, e.g. for
+// network.HostWrapper.SetPeerRouting. (The embedded Routing field already
+// takes the name Routing.)
+func (w *DHTWrapper) PeerRouting() routing.PeerRouting {
+	return w
+}
+
+var _ routing.PeerRouting = (*DHTWrapper)(nil)
+
+// RoutingTableSize returns the number of peers in the routing table,
+// summed over LAN and WAN for a dual DHT
 func (w *DHTWrapper) RoutingTableSize() int {
-	if ipfsdht, ok := w.Routing.(*dht.IpfsDHT); ok {
-		return ipfsdht.RoutingTable().Size()
+	switch r := w.Routing.(type) {
+	case *dht.IpfsDHT:
+		return r.RoutingTable().Size()
+	case *dual.DHT:
+		return r.WAN.RoutingTable().Size() + r.LAN.RoutingTable().Size()
+	case *fullrt.FullRT:
+		return len(r.Stat())
 	}
 	return 0
 }
+
+// Stats describes the DHT this wrapper runs
+type Stats struct {
+	Dual             bool
+	Accelerated      bool
+	Mode             string // "client" or "server" right now; WAN side for a dual DHT
+	LANMode          string // dual only
+	RoutingTableSize int
+	WANTableSize     int // dual only
+	LANTableSize     int // dual only
+}
+
+// Stats reports the current mode and routing table sizes. Auto modes
+// switch between client and server, so Mode is what the DHT runs as now.
+func (w *DHTWrapper) Stats() Stats {
+	st := Stats{RoutingTableSize: w.RoutingTableSize()}
+	switch r := w.Routing.(type) {
+	case *dht.IpfsDHT:
+		st.Mode = modeName(r.Mode())
+	case *fullrt.FullRT:
+		st.Accelerated = true
+		st.Mode = "client"
+	case *dual.DHT:
+		st.Dual = true
+		st.Mode = modeName(r.WAN.Mode())
+		st.LANMode = modeName(r.LAN.Mode())
+		st.WANTableSize = r.WAN.RoutingTable().Size()
+		st.LANTableSize = r.LAN.RoutingTable().Size()
+	}
+	return st
+}
+
+func modeName(m dht.ModeOpt) string {
+	if m == dht.ModeServer {
+		return "server"
+	}
+	return "client"
+}
@@ -0,0 +1,82 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/routing/http/client"
+)
+
+// NewDelegatedIPNSRouter returns a client for the IPNS part of a delegated
+// routing HTTP endpoint (/routing/v1/ipns). Like DHTWrapper it has PutIPNS
+// and GetIPNS, and it checks fetched records against the name.
+func NewDelegatedIPNSRouter(endpoint string) (*client.Client, error) {
+	c, err := client.New(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("delegated ipns router %s: %w", endpoint, err)
+	}
+	return c, nil
+}
+
+// PutIPNS validates rec against name and stores it in the DHT under
+// /ipns/<name>. Peers keep the record with the highest sequence number.
+func (w *DHTWrapper) PutIPNS(ctx context.Context, name ipns.Name, rec *ipns.Record) error {
+	if err := ipns.ValidateWithName(rec, name); err != nil {
+		return fmt.Errorf("invalid ipns record for %s: %w", name, err)
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return err
+	}
+	if err := w.PutValue(ctx, string(name.RoutingKey()), data); err != nil {
+		return fmt.Errorf("put ipns %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetIPNS returns the best record for name found in the DHT. The record is
+// checked against name, so a peer cannot answer with someone else's record.
+func (w *DHTWrapper) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	data, err := w.GetValue(ctx, string(name.RoutingKey()))
+	if err != nil {
+		return nil, fmt.Errorf("get ipns %s: %w", name, err)
+	}
+	return decodeIPNS(name, data)
+}
+
+// SearchIPNS streams records for name as better ones are found; the last
+// one received is the best. The channel closes when the search ends.
+func (w *DHTWrapper) SearchIPNS(ctx context.Context, name ipns.Name) (<-chan *ipns.Record, error) {
+	vals, err := w.SearchValue(ctx, string(name.RoutingKey()))
+	if err != nil {
+		return nil, fmt.Errorf("search ipns %s: %w", name, err)
+	}
+	out := make(chan *ipns.Record)
+	go func() {
+		defer close(out)
+		for data := range vals {
+			rec, err := decodeIPNS(name, data)
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func decodeIPNS(name ipns.Name, data []byte) (*ipns.Record, error) {
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode ipns %s: %w", name, err)
+	}
+	if err := ipns.ValidateWithName(rec, name); err != nil {
+		return nil, fmt.Errorf("invalid ipns record for %s: %w", name, err)
+	}
+	return rec, nil
+}
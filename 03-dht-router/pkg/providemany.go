@@ -0,0 +1,93 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DefaultProvideConcurrency bounds parallel Provide calls in ProvideMany
+const DefaultProvideConcurrency = 16
+
+type ProvideManyOptions struct {
+	// Parallel Provide calls when the accelerated client is not in use
+	// (default 16)
+	Concurrency int
+	// Called after each key with the number handled so far, failures
+	// included, possibly from several goroutines. The accelerated client
+	// reports once, at the end.
+	Progress func(done, total int)
+}
+
+// manyProvider is implemented by the accelerated DHT client
+type manyProvider interface {
+	ProvideMany(ctx context.Context, keys []mh.Multihash) error
+	Ready() bool
+}
+
+// ProvideMany announces keys with default options
+func (w *DHTWrapper) ProvideMany(ctx context.Context, keys []mh.Multihash) error {
+	return w.ProvideManyWithOptions(ctx, keys, ProvideManyOptions{})
+}
+
+// ProvideManyWithOptions announces every key. The accelerated client
+// (Config.Accelerated) sends all records in one bulk pass once its
+// routing table is ready; otherwise keys are provided one by one with
+// bounded concurrency. The error reports how many keys failed.
+func (w *DHTWrapper) ProvideManyWithOptions(ctx context.Context, keys []mh.Multihash, opts ProvideManyOptions) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if mp, ok := w.Routing.(manyProvider); ok && mp.Ready() {
+		if err := mp.ProvideMany(ctx, keys); err != nil {
+			return fmt.Errorf("provide many: %w", err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(len(keys), len(keys))
+		}
+		return nil
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultProvideConcurrency
+	}
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, opts.Concurrency)
+		done     atomic.Int64
+		failed   atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+	)
+	for _, k := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(k mh.Multihash) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := w.Provide(ctx, cid.NewCidV1(cid.Raw, k), true); err != nil {
+				failed.Add(1)
+				errOnce.Do(func() { firstErr = err })
+			}
+			n := done.Add(1)
+			if opts.Progress != nil {
+				opts.Progress(int(n), len(keys))
+			}
+		}(k)
+	}
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("provide many: %d of %d failed: %w", n, len(keys), firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/rs/zerolog/log"
+
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+)
+
+// DefaultReprovideInterval matches the provider record lifetime used by
+// IPFS: records expire after 48h and are refreshed every 12h.
+const DefaultReprovideInterval = 12 * time.Hour
+
+// NamespaceProvide holds the provide set inside the persistent store
+const NamespaceProvide = "provide"
+
+// ReprovideStrategy selects what each reprovide round announces on top of
+// the CIDs passed to Provide
+type ReprovideStrategy int
+
+const (
+	ReprovideAll    ReprovideStrategy = iota // every block in Blocks (default)
+	ReprovidePinned                          // every pinned block, children of recursive pins included
+	ReprovideRoots                           // only the pins themselves, not their children
+)
+
+func (s ReprovideStrategy) String() string {
+	switch s {
+	case ReprovideAll:
+		return "all"
+	case ReprovidePinned:
+		return "pinned"
+	case ReprovideRoots:
+		return "roots"
+	}
+	return fmt.Sprintf("strategy(%d)", int(s))
+}
+
+// BlockLister enumerates a blockstore, e.g. a PersistentWrapper
+type BlockLister interface {
+	AllKeysChan(ctx context.Context) (<-chan cid.Cid, error)
+}
+
+// PinSource lists pinned content for the pinned and roots strategies; the
+// pin module's PinManager implements it
+type PinSource interface {
+	// PinnedCids returns direct and recursive pins plus everything below
+	// the recursive ones
+	PinnedCids(ctx context.Context) ([]cid.Cid, error)
+	// PinRoots returns the direct and recursive pins only
+	PinRoots(ctx context.Context) ([]cid.Cid, error)
+}
+
+type ProviderConfig struct {
+	// Keeps the provide set across restarts; nil keeps it in memory
+	Store *persistent.PersistentWrapper
+	// Pause between reprovide rounds (default 12h)
+	Interval time.Duration
+
+	// What to announce besides the provide set. ReprovideAll with no
+	// Blocks announces the provide set only; the pin strategies need Pins.
+	Strategy ReprovideStrategy
+	Blocks   BlockLister
+	Pins     PinSource
+}
+
+// ProviderStats summarises the provider manager
+type ProviderStats struct {
+	Tracked       int
+	Provided      int64 // successful announcements since start
+	Failed        int64
+	LastReprovide time.Time
+	NextReprovide time.Time
+}
+
+// ProviderManager announces a set of CIDs and keeps re-announcing them
+// before their provider records expire
+type ProviderManager struct {
+	dht      *DHTWrapper
+	store    ds.Batching
+	interval time.Duration
+	strategy ReprovideStrategy
+	blocks   BlockLister
+	pins     PinSource
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	tracked  map[cid.Cid]time.Time // last successful announcement; zero if never
+	provided int64
+	failed   int64
+	lastRun  time.Time
+	nextRun  time.Time
+}
+
+// NewProviderManager loads the stored provide set, re-announces entries
+// that are due and then reprovides everything every Interval. Close it
+// before the DHT.
+func (w *DHTWrapper) NewProviderManager(ctx context.Context, cfg ProviderConfig) (*ProviderManager, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultReprovideInterval
+	}
+	switch cfg.Strategy {
+	case ReprovideAll:
+	case ReprovidePinned, ReprovideRoots:
+		if cfg.Pins == nil {
+			return nil, fmt.Errorf("reprovide strategy %s needs a pin source", cfg.Strategy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown reprovide strategy %d", int(cfg.Strategy))
+	}
+	store := cfg.Store
+	if store == nil {
+		var err error
+		store, err = persistent.New(persistent.Memory, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pm := &ProviderManager{
+		dht:      w,
+		store:    store.WithNamespace(NamespaceProvide).Batching,
+		interval: cfg.Interval,
+		strategy: cfg.Strategy,
+		blocks:   cfg.Blocks,
+		pins:     cfg.Pins,
+		done:     make(chan struct{}),
+		tracked:  make(map[cid.Cid]time.Time),
+	}
+	if err := pm.load(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, pm.cancel = context.WithCancel(context.Background())
+	go pm.run(ctx)
+	return pm, nil
+}
+
+func (pm *ProviderManager) load(ctx context.Context) error {
+	results, err := pm.store.Query(ctx, query.Query{})
+	if err != nil {
+		return fmt.Errorf("load provide set: %w", err)
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return fmt.Errorf("load provide set: %w", r.Error)
+		}
+		c, err := cid.Decode(strings.TrimPrefix(r.Key, "/"))
+		if err != nil || len(r.Value) != 8 {
+			log.Warn().Str("key", r.Key).Msg("skipping corrupt provide record")
+			continue
+		}
+		var last time.Time
+		if ns := int64(binary.BigEndian.Uint64(r.Value)); ns > 0 {
+			last = time.Unix(0, ns)
+		}
+		pm.tracked[c] = last
+	}
+	return nil
+}
+
+func provideKey(c cid.Cid) ds.Key {
+	return ds.NewKey(c.String())
+}
+
+func (pm *ProviderManager) save(ctx context.Context, c cid.Cid, last time.Time) error {
+	var ns int64
+	if !last.IsZero() {
+		ns = last.UnixNano()
+	}
+	return pm.store.Put(ctx, provideKey(c), binary.BigEndian.AppendUint64(nil, uint64(ns)))
+}
+
+// Provide announces c now and keeps reproviding it. A failed announcement
+// is returned but c stays tracked and is retried on the next round.
+func (pm *ProviderManager) Provide(ctx context.Context, c cid.Cid) error {
+	if !c.Defined() {
+		return fmt.Errorf("undefined cid")
+	}
+	pm.mu.Lock()
+	_, known := pm.tracked[c]
+	if !known {
+		pm.tracked[c] = time.Time{}
+	}
+	pm.mu.Unlock()
+	if !known {
+		if err := pm.save(ctx, c, time.Time{}); err != nil {
+			return fmt.Errorf("store provide record: %w", err)
+		}
+	}
+	return pm.announce(ctx, c)
+}
+
+// Remove stops reproviding c. Records already in the DHT expire on their own.
+func (pm *ProviderManager) Remove(ctx context.Context, c cid.Cid) error {
+	pm.mu.Lock()
+	delete(pm.tracked, c)
+	pm.mu.Unlock()
+	return pm.store.Delete(ctx, provideKey(c))
+}
+
+// Tracked returns every CID in the provide set
+func (pm *ProviderManager) Tracked() []cid.Cid {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	out := make([]cid.Cid, 0, len(pm.tracked))
+	for c := range pm.tracked {
+		out = append(out, c)
+	}
+	return out
+}
+
+// LastProvided returns when c was last announced; ok is false if c is
+// not tracked or has never been announced successfully.
+func (pm *ProviderManager) LastProvided(c cid.Cid) (time.Time, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	last, ok := pm.tracked[c]
+	return last, ok && !last.IsZero()
+}
+
+func (pm *ProviderManager) Stats() ProviderStats {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return ProviderStats{
+		Tracked:       len(pm.tracked),
+		Provided:      pm.provided,
+		Failed:        pm.failed,
+		LastReprovide: pm.lastRun,
+		NextReprovide: pm.nextRun,
+	}
+}
+
+// Reprovide announces every tracked CID and the strategy's CIDs now and
+// returns how many succeeded
+func (pm *ProviderManager) Reprovide(ctx context.Context) (int, error) {
+	return pm.reprovide(ctx, false)
+}
+
+// strategyKeys lists the CIDs the reprovide strategy adds to a round
+func (pm *ProviderManager) strategyKeys(ctx context.Context) ([]cid.Cid, error) {
+	switch pm.strategy {
+	case ReprovidePinned:
+		return pm.pins.PinnedCids(ctx)
+	case ReprovideRoots:
+		return pm.pins.PinRoots(ctx)
+	}
+	if pm.blocks == nil {
+		return nil, nil
+	}
+	ch, err := pm.blocks.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []cid.Cid
+	for c := range ch {
+		keys = append(keys, c)
+	}
+	return keys, ctx.Err()
+}
+
+// reprovide announces the strategy's CIDs and the tracked ones, the
+// latter only when older than the interval if dueOnly is set
+func (pm *ProviderManager) reprovide(ctx context.Context, dueOnly bool) (int, error) {
+	now := time.Now()
+	keys, err := pm.strategyKeys(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reprovide: list %s keys: %w", pm.strategy, err)
+	}
+
+	pm.mu.Lock()
+	seen := make(map[cid.Cid]struct{}, len(keys)+len(pm.tracked))
+	var due []cid.Cid
+	for _, c := range keys {
+		if _, ok := seen[c]; !ok {
+			seen[c] = struct{}{}
+			due = append(due, c)
+		}
+	}
+	for c, last := range pm.tracked {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		if !dueOnly || now.Sub(last) >= pm.interval {
+			due = append(due, c)
+		}
+	}
+	pm.mu.Unlock()
+
+	var ok int
+	var lastErr error
+	for _, c := range due {
+		if ctx.Err() != nil {
+			return ok, ctx.Err()
+		}
+		if err := pm.announce(ctx, c); err != nil {
+			lastErr = err
+			continue
+		}
+		ok++
+	}
+
+	pm.mu.Lock()
+	pm.lastRun = now
+	pm.mu.Unlock()
+	if lastErr != nil {
+		return ok, fmt.Errorf("reprovide: %d of %d failed: %w", len(due)-ok, len(due), lastErr)
+	}
+	return ok, nil
+}
+
+func (pm *ProviderManager) announce(ctx context.Context, c cid.Cid) error {
+	err := pm.dht.Provide(ctx, c, true)
+
+	pm.mu.Lock()
+	if err != nil {
+		pm.failed++
+		pm.mu.Unlock()
+		return fmt.Errorf("provide %s: %w", c, err)
+	}
+	pm.provided++
+	_, tracked := pm.tracked[c]
+	now := time.Now()
+	if tracked {
+		pm.tracked[c] = now
+	}
+	pm.mu.Unlock()
+
+	if tracked {
+		if err := pm.save(ctx, c, now); err != nil {
+			log.Warn().Err(err).Str("cid", c.String()).Msg("store provide time")
+		}
+	}
+	return nil
+}
+
+func (pm *ProviderManager) run(ctx context.Context) {
+	defer close(pm.done)
+
+	if _, err := pm.reprovide(ctx, true); err != nil && ctx.Err() == nil {
+		log.Warn().Err(err).Msg("initial reprovide")
+	}
+	ticker := time.NewTicker(pm.interval)
+	defer ticker.Stop()
+	for {
+		pm.mu.Lock()
+		pm.nextRun = time.Now().Add(pm.interval)
+		pm.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n, err := pm.reprovide(ctx, false); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("reprovide")
+		} else {
+			log.Debug().Int("cids", n).Msg("reprovided")
+		}
+	}
+}
+
+// Close stops reproviding; the provide set stays in the store
+func (pm *ProviderManager) Close() error {
+	pm.cancel()
+	<-pm.done
+	return nil
+}
@@ -0,0 +1,256 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/multiformats/go-base32"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+)
+
+// NamespaceDHT holds the DHT's value and provider records inside the
+// persistent store
+const NamespaceDHT = "dht"
+
+const (
+	// DefaultMaxRecordAge matches kad-dht: value records (IPNS, public
+	// keys) must be republished within 36h
+	DefaultMaxRecordAge = 36 * time.Hour
+	// DefaultProviderRecordTTL matches the provider record validity of kad-dht
+	DefaultProviderRecordTTL = 48 * time.Hour
+	// DefaultRecordGCInterval is the pause between GC rounds
+	DefaultRecordGCInterval = time.Hour
+)
+
+// providersPrefix is where kad-dht keeps provider records
+const providersPrefix = "/providers/"
+
+type RecordStoreConfig struct {
+	MaxRecordAge time.Duration // value records older than this are dropped (default 36h)
+	ProviderTTL  time.Duration // provider records older than this are dropped (default 48h)
+	GCInterval   time.Duration // pause between GC rounds (default 1h)
+}
+
+// RecordStats counts the records held for other peers
+type RecordStats struct {
+	Values    map[string]int // value records by namespace, e.g. "ipns", "pk"
+	Providers int            // provider records, one per key and provider
+	Expired   int            // records past their lifetime, waiting for GC
+
+	LastGC    time.Time
+	Collected int64 // records removed by GC since start
+}
+
+// RecordStore is the datastore behind the DHT. It keeps the records in
+// their own namespace and drops them once expired, instead of relying on
+// lookups to notice stale entries.
+type RecordStore struct {
+	store       ds.Batching
+	maxAge      time.Duration
+	providerTTL time.Duration
+	interval    time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	lastGC    time.Time
+	collected int64
+}
+
+// NewRecordStore keeps records in the NamespaceDHT view of store. Call
+// Start to run the GC loop.
+func NewRecordStore(store *persistent.PersistentWrapper, cfg RecordStoreConfig) *RecordStore {
+	if cfg.MaxRecordAge <= 0 {
+		cfg.MaxRecordAge = DefaultMaxRecordAge
+	}
+	if cfg.ProviderTTL <= 0 {
+		cfg.ProviderTTL = DefaultProviderRecordTTL
+	}
+	if cfg.GCInterval <= 0 {
+		cfg.GCInterval = DefaultRecordGCInterval
+	}
+	return &RecordStore{
+		store:       store.WithNamespace(NamespaceDHT).Batching,
+		maxAge:      cfg.MaxRecordAge,
+		providerTTL: cfg.ProviderTTL,
+		interval:    cfg.GCInterval,
+	}
+}
+
+// Datastore is what the DHT should be built on
+func (rs *RecordStore) Datastore() ds.Batching {
+	return rs.store
+}
+
+// MaxRecordAge is the lifetime of value records
+func (rs *RecordStore) MaxRecordAge() time.Duration {
+	return rs.maxAge
+}
+
+// recordInfo classifies a stored record and returns when it was received.
+// kind is "providers" or the namespace of a value record.
+func recordInfo(key string, value []byte) (kind string, received time.Time, err error) {
+	if strings.HasPrefix(key, providersPrefix) {
+		ns, n := binary.Varint(value)
+		if n <= 0 {
+			return "providers", time.Time{}, fmt.Errorf("bad provider timestamp")
+		}
+		return "providers", time.Unix(0, ns), nil
+	}
+
+	raw, err := base32.RawStdEncoding.DecodeString(strings.TrimPrefix(key, "/"))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("not a dht key: %w", err)
+	}
+	kind = "other"
+	if parts := strings.SplitN(string(raw), "/", 3); len(parts) == 3 && parts[0] == "" {
+		kind = parts[1]
+	}
+	rec := new(recpb.Record)
+	if err := proto.Unmarshal(value, rec); err != nil {
+		return kind, time.Time{}, fmt.Errorf("bad record: %w", err)
+	}
+	received, err = time.Parse(time.RFC3339Nano, rec.GetTimeReceived())
+	if err != nil {
+		return kind, time.Time{}, fmt.Errorf("bad receive time: %w", err)
+	}
+	return kind, received, nil
+}
+
+// expired reports whether a record of kind received at t is past its lifetime
+func (rs *RecordStore) expired(kind string, t, now time.Time) bool {
+	if kind == "providers" {
+		return now.Sub(t) > rs.providerTTL
+	}
+	return now.Sub(t) > rs.maxAge
+}
+
+// walk calls fn for every record; unreadable records are reported as expired
+func (rs *RecordStore) walk(ctx context.Context, fn func(key, kind string, expired bool)) error {
+	results, err := rs.store.Query(ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	now := time.Now()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		kind, received, err := recordInfo(r.Key, r.Value)
+		if err != nil {
+			log.Debug().Err(err).Str("key", r.Key).Msg("unreadable dht record")
+			fn(r.Key, kind, true)
+			continue
+		}
+		fn(r.Key, kind, rs.expired(kind, received, now))
+	}
+	return ctx.Err()
+}
+
+func (rs *RecordStore) Stats(ctx context.Context) (RecordStats, error) {
+	st := RecordStats{Values: make(map[string]int)}
+	err := rs.walk(ctx, func(_, kind string, expired bool) {
+		switch kind {
+		case "providers":
+			st.Providers++
+		case "":
+		default:
+			st.Values[kind]++
+		}
+		if expired {
+			st.Expired++
+		}
+	})
+	if err != nil {
+		return RecordStats{}, fmt.Errorf("record stats: %w", err)
+	}
+
+	rs.mu.Lock()
+	st.LastGC, st.Collected = rs.lastGC, rs.collected
+	rs.mu.Unlock()
+	return st, nil
+}
+
+// GC deletes expired and unreadable records and returns how many it removed
+func (rs *RecordStore) GC(ctx context.Context) (int, error) {
+	var stale []ds.Key
+	err := rs.walk(ctx, func(key, _ string, expired bool) {
+		if expired {
+			stale = append(stale, ds.RawKey(key))
+		}
+	})
+	if err != nil {
+		return 0, fmt.Errorf("record gc: %w", err)
+	}
+
+	if len(stale) > 0 {
+		b, err := rs.store.Batch(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("record gc: %w", err)
+		}
+		for _, k := range stale {
+			if err := b.Delete(ctx, k); err != nil {
+				return 0, fmt.Errorf("record gc: %w", err)
+			}
+		}
+		if err := b.Commit(ctx); err != nil {
+			return 0, fmt.Errorf("record gc: %w", err)
+		}
+	}
+
+	rs.mu.Lock()
+	rs.lastGC = time.Now()
+	rs.collected += int64(len(stale))
+	rs.mu.Unlock()
+	return len(stale), nil
+}
+
+// Start runs GC every GCInterval until Close
+func (rs *RecordStore) Start() {
+	if rs.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.cancel = cancel
+	rs.done = make(chan struct{})
+	go func() {
+		defer close(rs.done)
+		ticker := time.NewTicker(rs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if n, err := rs.GC(ctx); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Msg("dht record gc")
+			} else if n > 0 {
+				log.Debug().Int("records", n).Msg("dht records collected")
+			}
+		}
+	}()
+}
+
+// Close stops the GC loop; the records stay in the store
+func (rs *RecordStore) Close() error {
+	if rs.cancel != nil {
+		rs.cancel()
+		<-rs.done
+		rs.cancel = nil
+	}
+	return nil
+}
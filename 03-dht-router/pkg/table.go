@@ -0,0 +1,171 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/dual"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
+)
+
+// DefaultTableStaleAfter is how long the routing table may go without a
+// bucket refresh before it counts as stale. kad-dht refreshes every 10
+// minutes, so an hour means several rounds were missed.
+const DefaultTableStaleAfter = time.Hour
+
+// BucketInfo describes the peers sharing cpl leading bits with our ID
+type BucketInfo struct {
+	Cpl         int
+	Peers       int
+	LastRefresh time.Time // zero if never refreshed
+}
+
+// TableHealth is a snapshot of the routing table
+type TableHealth struct {
+	Size    int
+	Buckets []BucketInfo
+
+	// Peers that answered one of our queries usefully within the last
+	// DefaultTableStaleAfter, and their share of the table
+	UsefulPeers int
+	UsefulRatio float64
+
+	// Peers added plus removed per minute, measured since the previous
+	// TableHealth call; zero on the first call
+	ChurnPerMinute float64
+
+	LastRefresh time.Time // newest bucket refresh
+}
+
+// churnSampler diffs successive routing table snapshots
+type churnSampler struct {
+	mu    sync.Mutex
+	peers map[peer.ID]struct{}
+	at    time.Time
+	rate  float64
+}
+
+func (s *churnSampler) sample(now time.Time, infos []kb.PeerInfo) float64 {
+	current := make(map[peer.ID]struct{}, len(infos))
+	for _, pi := range infos {
+		current[pi.Id] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peers != nil {
+		var changed int
+		for p := range current {
+			if _, ok := s.peers[p]; !ok {
+				changed++
+			}
+		}
+		for p := range s.peers {
+			if _, ok := current[p]; !ok {
+				changed++
+			}
+		}
+		if elapsed := now.Sub(s.at); elapsed > 0 {
+			s.rate = float64(changed) / elapsed.Minutes()
+		}
+	}
+	s.peers, s.at = current, now
+	return s.rate
+}
+
+// kadTable returns the kad-dht instance whose table is inspected: the WAN
+// side of a dual DHT. The accelerated client has no k-bucket table.
+func (w *DHTWrapper) kadTable() (*dht.IpfsDHT, error) {
+	switch r := w.Routing.(type) {
+	case *dht.IpfsDHT:
+		return r, nil
+	case *dual.DHT:
+		return r.WAN, nil
+	}
+	return nil, fmt.Errorf("routing table inspection not supported for %T", w.Routing)
+}
+
+// TableHealth reports per-bucket peer counts and refresh times, the share
+// of useful peers and the churn rate. For a dual DHT it covers the WAN table.
+func (w *DHTWrapper) TableHealth() (TableHealth, error) {
+	d, err := w.kadTable()
+	if err != nil {
+		return TableHealth{}, err
+	}
+	rt := d.RoutingTable()
+	now := time.Now()
+	infos := rt.GetPeerInfos()
+
+	refreshed := rt.GetTrackedCplsForRefresh()
+	h := TableHealth{Size: len(infos)}
+	for cpl, at := range refreshed {
+		h.Buckets = append(h.Buckets, BucketInfo{Cpl: cpl, LastRefresh: at})
+		if at.After(h.LastRefresh) {
+			h.LastRefresh = at
+		}
+	}
+
+	self := kb.ConvertPeerID(d.PeerID())
+	for _, pi := range infos {
+		cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(pi.Id))
+		for len(h.Buckets) <= cpl {
+			h.Buckets = append(h.Buckets, BucketInfo{Cpl: len(h.Buckets)})
+		}
+		h.Buckets[cpl].Peers++
+		if !pi.LastUsefulAt.IsZero() && now.Sub(pi.LastUsefulAt) < DefaultTableStaleAfter {
+			h.UsefulPeers++
+		}
+	}
+	if h.Size > 0 {
+		h.UsefulRatio = float64(h.UsefulPeers) / float64(h.Size)
+	}
+	h.ChurnPerMinute = w.churn.sample(now, infos)
+	return h, nil
+}
+
+// RoutingTableCheck reports the routing table: healthy while it has peers
+// and was refreshed within staleAfter (default DefaultTableStaleAfter),
+// degraded when it is empty or stale.
+func (w *DHTWrapper) RoutingTableCheck(staleAfter time.Duration) health.HealthChecker {
+	const name = "dht-routing-table"
+	if staleAfter <= 0 {
+		staleAfter = DefaultTableStaleAfter
+	}
+	return health.NewHealthCheckFunc(name, func(ctx context.Context) health.CheckResult {
+		result := health.CheckResult{ComponentName: name}
+		h, err := w.TableHealth()
+		if err != nil {
+			result.Status = health.StatusUnknown
+			result.Message = err.Error()
+			return result
+		}
+		result.Metadata = map[string]string{
+			"peers":            fmt.Sprint(h.Size),
+			"buckets":          fmt.Sprint(len(h.Buckets)),
+			"useful_ratio":     fmt.Sprintf("%.2f", h.UsefulRatio),
+			"churn_per_minute": fmt.Sprintf("%.2f", h.ChurnPerMinute),
+		}
+		if !h.LastRefresh.IsZero() {
+			result.Metadata["last_refresh"] = h.LastRefresh.Format(time.RFC3339)
+		}
+
+		switch {
+		case h.Size == 0:
+			result.Status = health.StatusDegraded
+			result.Message = "Routing table is empty; lookups cannot leave this node"
+		case h.LastRefresh.IsZero() || time.Since(h.LastRefresh) > staleAfter:
+			result.Status = health.StatusDegraded
+			result.Message = fmt.Sprintf("Routing table not refreshed within %v", staleAfter)
+		default:
+			result.Status = health.StatusHealthy
+			result.Message = fmt.Sprintf("%d peers in %d buckets", h.Size, len(h.Buckets))
+		}
+		return result
+	})
+}
@@ -0,0 +1,198 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// QueryHop is one peer asked during a lookup
+type QueryHop struct {
+	Peer    peer.ID       `json:"peer"`
+	Latency time.Duration `json:"latency_ns"`      // request to response (or error)
+	Results int           `json:"results"`         // closer peers it returned
+	Err     string        `json:"error,omitempty"` // dial/query failure or "no response"
+}
+
+// QueryTrace records a single DHT lookup hop by hop
+type QueryTrace struct {
+	Op       string        `json:"op"` // "find-providers" or "find-peer"
+	Key      string        `json:"key"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	Results  int           `json:"results"` // providers or addresses found
+	Err      string        `json:"error,omitempty"`
+	Hops     []QueryHop    `json:"hops"`
+}
+
+// Slowest returns the hop that took longest to answer
+func (t QueryTrace) Slowest() (QueryHop, bool) {
+	var slow QueryHop
+	for _, h := range t.Hops {
+		if h.Latency > slow.Latency {
+			slow = h
+		}
+	}
+	return slow, len(t.Hops) > 0
+}
+
+// QueryTracer keeps the most recent query traces
+type QueryTracer struct {
+	mu     sync.Mutex
+	traces []QueryTrace // ring buffer
+	next   int
+	full   bool
+}
+
+func NewQueryTracer(size int) *QueryTracer {
+	if size <= 0 {
+		size = 1
+	}
+	return &QueryTracer{traces: make([]QueryTrace, size)}
+}
+
+// Traces returns the kept traces, newest first
+func (t *QueryTracer) Traces() []QueryTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.next
+	if t.full {
+		n = len(t.traces)
+	}
+	out := make([]QueryTrace, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, t.traces[(t.next-i+len(t.traces))%len(t.traces)])
+	}
+	return out
+}
+
+func (t *QueryTracer) record(tr QueryTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.traces[t.next] = tr
+	t.next = (t.next + 1) % len(t.traces)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// activeQuery collects the query events of one lookup
+type activeQuery struct {
+	tracer *QueryTracer
+	trace  QueryTrace
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// begin subscribes ctx to the DHT's query events. It is a no-op on a nil
+// tracer; the returned query must be ended either way.
+func (t *QueryTracer) begin(ctx context.Context, op, key string) (context.Context, *activeQuery) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ctx, events := routing.RegisterForQueryEvents(ctx)
+	q := &activeQuery{
+		tracer: t,
+		trace:  QueryTrace{Op: op, Key: key, Start: time.Now()},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go q.collect(events)
+	return ctx, q
+}
+
+func (q *activeQuery) collect(events <-chan *routing.QueryEvent) {
+	defer close(q.done)
+	sent := make(map[peer.ID]time.Time)
+	for ev := range events {
+		switch ev.Type {
+		case routing.SendingQuery:
+			sent[ev.ID] = time.Now()
+		case routing.PeerResponse, routing.QueryError:
+			hop := QueryHop{Peer: ev.ID}
+			if at, ok := sent[ev.ID]; ok {
+				hop.Latency = time.Since(at)
+				delete(sent, ev.ID)
+			}
+			if ev.Type == routing.QueryError {
+				hop.Err = ev.Extra
+			} else {
+				hop.Results = len(ev.Responses)
+			}
+			q.trace.Hops = append(q.trace.Hops, hop)
+		}
+	}
+	// peers still outstanding when the lookup finished
+	for p, at := range sent {
+		q.trace.Hops = append(q.trace.Hops, QueryHop{Peer: p, Latency: time.Since(at), Err: "no response"})
+	}
+}
+
+func (q *activeQuery) end(results int, err error) {
+	if q == nil {
+		return
+	}
+	q.trace.Duration = time.Since(q.trace.Start)
+	q.cancel()
+	<-q.done
+	q.trace.Results = results
+	if err != nil {
+		q.trace.Err = err.Error()
+	}
+	q.tracer.record(q.trace)
+}
+
+// QueryTraces returns the recent lookups, newest first; nil unless
+// Config.TraceQueries is set
+func (w *DHTWrapper) QueryTraces() []QueryTrace {
+	if w.tracer == nil {
+		return nil
+	}
+	return w.tracer.Traces()
+}
+
+// DebugHandler serves the DHT state as JSON:
+//
+//	/debug/dht          mode and routing table sizes
+//	/debug/dht/queries  recent query traces (needs Config.TraceQueries)
+//	/debug/dht/crawl    crawl progress (needs Config.Accelerated)
+func (w *DHTWrapper) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body any
+		switch r.URL.Path {
+		case "/debug/dht":
+			body = w.Stats()
+		case "/debug/dht/queries":
+			if w.tracer == nil {
+				http.Error(rw, "query tracing disabled", http.StatusNotFound)
+				return
+			}
+			body = w.tracer.Traces()
+		case "/debug/dht/crawl":
+			st, err := w.CrawlStatus()
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusNotFound)
+				return
+			}
+			body = st
+		default:
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(body); err != nil {
+			http.Error(rw, "Failed to encode response", http.StatusInternalServerError)
+		}
+	})
+}
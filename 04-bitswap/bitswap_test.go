@@ -2,12 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	bsnet "github.com/ipfs/boxo/bitswap/network/bsnet"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 
+	block "github.com/gosuda/boxo-starter-kit/00-block-cid/pkg"
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
 	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
 )
 
 func TestBitswap(t *testing.T) {
@@ -35,6 +55,28 @@ func TestBitswap(t *testing.T) {
 	require.Equal(t, payload, receive)
 }
 
+func TestBitswapGlobalMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bswap1, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer bswap1.Close()
+	bswap2, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+
+	name1 := "bitswap/" + bswap1.HostWrapper.ID().String()
+	name2 := "bitswap/" + bswap2.HostWrapper.ID().String()
+	require.Contains(t, metrics.GetGlobalProtocols(), name1)
+	require.Contains(t, metrics.GetGlobalProtocols(), name2)
+	require.Contains(t, metrics.GetGlobalBandwidth(), name2)
+
+	require.NoError(t, bswap2.Close())
+	require.NotContains(t, metrics.GetGlobalProtocols(), name2)
+	require.NotContains(t, metrics.GetGlobalBandwidth(), name2)
+	require.Contains(t, metrics.GetGlobalProtocols(), name1)
+}
+
 func TestBlockService(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -66,3 +108,827 @@ func TestBlockService(t *testing.T) {
 	require.Equal(t, payload, receive)
 
 }
+
+func TestWantlistAndLedger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bswap1, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer bswap1.Close()
+
+	bswap2, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer bswap2.Close()
+
+	require.NoError(t, bswap1.HostWrapper.ConnectToPeer(ctx, bswap2.HostWrapper.GetFullAddresses()...))
+	p1, p2 := bswap1.HostWrapper.ID(), bswap2.HostWrapper.ID()
+
+	t.Run("Pending Want", func(t *testing.T) {
+		missing, err := block.ComputeCID([]byte("nobody has this"), nil)
+		require.NoError(t, err)
+
+		fctx, fcancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			bswap2.GetBlock(fctx, missing)
+		}()
+
+		// with no known provider the session only broadcasts want-haves
+		require.Eventually(t, func() bool {
+			return slices.ContainsFunc(bswap2.CurrentWants(), func(w bitswap.Want) bool {
+				return w.Cid.Equals(missing) && !w.WantBlock
+			})
+		}, 2*time.Second, 20*time.Millisecond)
+		require.Eventually(t, func() bool {
+			return slices.ContainsFunc(bswap1.WantlistForPeer(p2), missing.Equals)
+		}, 2*time.Second, 20*time.Millisecond)
+
+		fcancel()
+		<-done
+		require.Eventually(t, func() bool {
+			return len(bswap2.CurrentWants()) == 0
+		}, 2*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("Ledger", func(t *testing.T) {
+		payload := []byte("ledger payload")
+		c, err := bswap1.PutBlockRaw(ctx, payload)
+		require.NoError(t, err)
+		_, err = bswap2.GetBlockRaw(ctx, c)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			l := bswap1.LedgerForPeer(p2)
+			return l.BytesSent == uint64(len(payload)) && l.Exchanges == 1
+		}, 2*time.Second, 20*time.Millisecond)
+		require.Eventually(t, func() bool {
+			return bswap2.LedgerForPeer(p1).BytesReceived == uint64(len(payload))
+		}, 2*time.Second, 20*time.Millisecond)
+
+		ledgers := bswap1.Ledgers()
+		require.Len(t, ledgers, 1)
+		require.Equal(t, p2, ledgers[0].Peer)
+
+		require.Zero(t, bswap1.LedgerForPeer(peer.ID("unknown")).Exchanges)
+	})
+}
+
+// recordingRouter remembers the CIDs it was asked to provide
+type recordingRouter struct {
+	mu       sync.Mutex
+	provided []cid.Cid
+}
+
+func (r *recordingRouter) Provide(_ context.Context, c cid.Cid, _ bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provided = append(r.provided, c)
+	return nil
+}
+
+func (r *recordingRouter) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func TestBitswapConfig(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg := &bitswap.BitswapConfig{
+		TaskWorkerCount:            1,
+		MaxOutstandingBytesPerPeer: 1024,
+		TargetMessageSize:          1024,
+		ProviderSearchDelay:        100 * time.Millisecond,
+	}
+	bswap1, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, cfg)
+	require.NoError(t, err)
+	defer bswap1.Close()
+
+	router := &recordingRouter{}
+	withProvide := *cfg
+	withProvide.Provide = true
+	bswap2, err := bitswap.NewBitswapWithConfig(ctx, router, nil, nil, &withProvide)
+	require.NoError(t, err)
+	defer bswap2.Close()
+
+	require.NoError(t, bswap1.HostWrapper.ConnectToPeer(ctx, bswap2.HostWrapper.GetFullAddresses()...))
+
+	t.Run("Tuned Exchange", func(t *testing.T) {
+		// blocks larger than the per-peer and message limits still go through
+		var cids []cid.Cid
+		for i := range 4 {
+			payload := make([]byte, 4096)
+			payload[0] = byte(i)
+			c, err := bswap2.PutBlockRaw(ctx, payload)
+			require.NoError(t, err)
+			cids = append(cids, c)
+		}
+		ch, err := bswap1.GetBlocks(ctx, cids)
+		require.NoError(t, err)
+		var received int
+		for range ch {
+			received++
+		}
+		require.Equal(t, len(cids), received)
+	})
+
+	t.Run("Provide", func(t *testing.T) {
+		c, err := bswap2.PutBlockRaw(ctx, []byte("announce me"))
+		require.NoError(t, err)
+		router.mu.Lock()
+		defer router.mu.Unlock()
+		require.Contains(t, router.provided, c)
+	})
+
+	t.Run("Nil Router", func(t *testing.T) {
+		_, err := bitswap.NewBitswapWithConfig(ctx, nil, nil, nil, cfg)
+		require.Error(t, err)
+	})
+}
+
+func TestBitswapModes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	full, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer full.Close()
+
+	t.Run("Client Only Never Serves", func(t *testing.T) {
+		client, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{Mode: bitswap.ModeClientOnly})
+		require.NoError(t, err)
+		defer client.Close()
+		require.Equal(t, bitswap.ModeClientOnly, client.Mode())
+		require.NoError(t, full.HostWrapper.ConnectToPeer(ctx, client.HostWrapper.GetFullAddresses()...))
+
+		// the client holds the block but must not hand it out
+		held, err := client.PutBlockRaw(ctx, []byte("held by a client-only node"))
+		require.NoError(t, err)
+		fctx, fcancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer fcancel()
+		_, err = full.GetBlock(fctx, held)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Empty(t, client.WantlistForPeer(full.HostWrapper.ID()))
+		require.Zero(t, client.LedgerForPeer(full.HostWrapper.ID()).Exchanges)
+
+		// fetching still works
+		c, err := full.PutBlockRaw(ctx, []byte("fetched by a client-only node"))
+		require.NoError(t, err)
+		blk, err := client.GetBlock(ctx, c)
+		require.NoError(t, err)
+		require.Equal(t, c, blk.Cid())
+	})
+
+	t.Run("Server Only Never Fetches", func(t *testing.T) {
+		server, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{Mode: bitswap.ModeServerOnly})
+		require.NoError(t, err)
+		defer server.Close()
+		require.NoError(t, full.HostWrapper.ConnectToPeer(ctx, server.HostWrapper.GetFullAddresses()...))
+
+		c, err := server.PutBlockRaw(ctx, []byte("seeded by a server-only node"))
+		require.NoError(t, err)
+		blk, err := full.GetBlock(ctx, c)
+		require.NoError(t, err)
+		require.Equal(t, c, blk.Cid())
+
+		_, err = server.GetBlock(ctx, c)
+		require.ErrorIs(t, err, bitswap.ErrServerOnly)
+		_, err = server.GetBlocks(ctx, []cid.Cid{c})
+		require.ErrorIs(t, err, bitswap.ErrServerOnly)
+		_, err = server.NewSession(ctx).GetBlock(ctx, c)
+		require.ErrorIs(t, err, bitswap.ErrServerOnly)
+		_, err = server.GetBlockFromPeer(ctx, c, full.HostWrapper.ID())
+		require.ErrorIs(t, err, bitswap.ErrServerOnly)
+		require.Empty(t, server.CurrentWants())
+	})
+
+	t.Run("Unknown Mode", func(t *testing.T) {
+		_, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{Mode: bitswap.Mode(42)})
+		require.Error(t, err)
+	})
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer provider.Close()
+
+	// root -> 3 inner nodes -> 3 raw leaves each
+	put := func(blk blocks.Block) {
+		require.NoError(t, provider.PersistentWrapper.Put(ctx, blk))
+		require.NoError(t, provider.NotifyNewBlocks(ctx, blk))
+	}
+	root := merkledag.NodeWithData([]byte("root"))
+	var inner, leaves []cid.Cid
+	for i := range 3 {
+		nd := merkledag.NodeWithData([]byte{byte(i)})
+		for j := range 3 {
+			leaf := merkledag.NewRawNode([]byte{byte(i), byte(j)})
+			put(leaf)
+			leaves = append(leaves, leaf.Cid())
+			require.NoError(t, nd.AddNodeLink("", leaf))
+		}
+		put(nd)
+		inner = append(inner, nd.Cid())
+		require.NoError(t, root.AddNodeLink("", nd))
+	}
+	put(root)
+
+	fetchRoot := func(t *testing.T, root cid.Cid, cfg *bitswap.BitswapConfig) *bitswap.BitswapWrapper {
+		fetcher, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { fetcher.Close() })
+		require.NoError(t, fetcher.HostWrapper.ConnectToPeer(ctx, provider.HostWrapper.GetFullAddresses()...))
+		_, err = fetcher.GetBlock(ctx, root)
+		require.NoError(t, err)
+		return fetcher
+	}
+	fetch := func(t *testing.T, cfg *bitswap.PrefetchConfig) *bitswap.BitswapWrapper {
+		return fetchRoot(t, root.Cid(), &bitswap.BitswapConfig{Prefetch: cfg})
+	}
+	stored := func(b *bitswap.BitswapWrapper, cs []cid.Cid) int {
+		var n int
+		for _, c := range cs {
+			if has, _ := b.PersistentWrapper.Has(ctx, c); has {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("Whole Tree", func(t *testing.T) {
+		fetcher := fetch(t, &bitswap.PrefetchConfig{Depth: 2})
+		require.Eventually(t, func() bool {
+			return stored(fetcher, inner) == 3 && stored(fetcher, leaves) == 9 && fetcher.PrefetchStats().InFlight == 0
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, bitswap.PrefetchStats{Requested: 12, Fetched: 12}, fetcher.PrefetchStats())
+
+		// served from the local store
+		blk, err := fetcher.GetBlock(ctx, leaves[0])
+		require.NoError(t, err)
+		require.Equal(t, leaves[0], blk.Cid())
+	})
+
+	t.Run("Depth", func(t *testing.T) {
+		fetcher := fetch(t, &bitswap.PrefetchConfig{Depth: 1})
+		require.Eventually(t, func() bool {
+			return fetcher.PrefetchStats().Fetched == 3
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, 3, stored(fetcher, inner))
+		require.Zero(t, stored(fetcher, leaves))
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		fetcher := fetch(t, &bitswap.PrefetchConfig{Depth: 2, Budget: 2})
+		require.Eventually(t, func() bool {
+			return fetcher.PrefetchStats().Fetched == 2
+		}, 5*time.Second, 20*time.Millisecond)
+		require.EqualValues(t, 2, fetcher.PrefetchStats().Requested)
+		require.Zero(t, stored(fetcher, leaves))
+	})
+
+	t.Run("Missing Child", func(t *testing.T) {
+		// partial -> a stored leaf and a child no peer has
+		partial := merkledag.NodeWithData([]byte("partial"))
+		present := merkledag.NewRawNode([]byte("present"))
+		put(present)
+		require.NoError(t, partial.AddNodeLink("", present))
+		missing := merkledag.NewRawNode([]byte("nobody has this"))
+		require.NoError(t, partial.AddNodeLink("", missing))
+		put(partial)
+
+		fetcher := fetchRoot(t, partial.Cid(), &bitswap.BitswapConfig{
+			Prefetch: &bitswap.PrefetchConfig{Depth: 1},
+			Retry:    &bitswap.RetryConfig{Timeout: 200 * time.Millisecond},
+		})
+		require.Eventually(t, func() bool {
+			return fetcher.PrefetchStats().Fetched == 1
+		}, 5*time.Second, 20*time.Millisecond)
+
+		// the missing child is given up after the request timeout
+		require.Eventually(t, func() bool {
+			return fetcher.PrefetchStats().InFlight == 0
+		}, 5*time.Second, 20*time.Millisecond)
+		require.EqualValues(t, 2, fetcher.PrefetchStats().Requested)
+		require.Eventually(t, func() bool {
+			for _, w := range fetcher.CurrentWants() {
+				if w.Cid == missing.Cid() {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		fetcher := fetch(t, nil)
+		time.Sleep(200 * time.Millisecond)
+		require.Zero(t, fetcher.PrefetchStats())
+		require.Zero(t, stored(fetcher, inner))
+	})
+}
+
+func TestPeerScoring(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{
+		Scoring: &bitswap.ScoreConfig{BanThreshold: -20, BanDuration: 500 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	provider, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer provider.Close()
+	require.NoError(t, node.HostWrapper.ConnectToPeer(ctx, provider.HostWrapper.GetFullAddresses()...))
+
+	// a peer speaking raw bitswap, pushing blocks nobody asked for
+	attacker, err := network.New(nil)
+	require.NoError(t, err)
+	defer attacker.Close()
+	require.NoError(t, attacker.ConnectToPeer(ctx, node.HostWrapper.GetFullAddresses()...))
+	push := func(blks ...blocks.Block) error {
+		msg := bsmsg.New(false)
+		for _, blk := range blks {
+			msg.AddBlock(blk)
+		}
+		return bsnet.NewFromIpfsHost(attacker).SendMessage(ctx, node.HostWrapper.ID(), msg)
+	}
+
+	t.Run("Useful", func(t *testing.T) {
+		c, err := provider.PutBlockRaw(ctx, []byte("wanted block"))
+		require.NoError(t, err)
+		_, err = node.GetBlock(ctx, c)
+		require.NoError(t, err)
+		ps, ok := node.Score(provider.HostWrapper.ID())
+		require.True(t, ok)
+		require.EqualValues(t, 1, ps.Useful)
+		require.Equal(t, 1, ps.Score)
+	})
+
+	t.Run("Unsolicited", func(t *testing.T) {
+		require.NoError(t, push(merkledag.NewRawNode([]byte("spam 1")), merkledag.NewRawNode([]byte("spam 2"))))
+		require.Eventually(t, func() bool {
+			ps, _ := node.Score(attacker.ID())
+			return ps.Unsolicited == 2
+		}, 2*time.Second, 20*time.Millisecond)
+		ps, _ := node.Score(attacker.ID())
+		require.Equal(t, -10, ps.Score)
+		require.False(t, ps.Banned())
+	})
+
+	t.Run("Corrupt Block Bans", func(t *testing.T) {
+		junk := []byte("not protobuf at all \xff\xff")
+		c, err := cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: mh.SHA2_256, MhLength: -1}.Sum(junk)
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(junk, c)
+		require.NoError(t, err)
+		// the connection may drop before the stream is closed cleanly
+		_ = push(blk)
+
+		require.Eventually(t, func() bool {
+			return slices.Contains(node.BannedPeers(), attacker.ID())
+		}, 2*time.Second, 20*time.Millisecond)
+		ps, _ := node.Score(attacker.ID())
+		require.EqualValues(t, 1, ps.Corrupt)
+		require.Eventually(t, func() bool {
+			return !node.IsConnectedToPeer(attacker.ID())
+		}, 2*time.Second, 20*time.Millisecond)
+		require.Error(t, node.HostWrapper.ConnectToPeer(ctx, attacker.GetFullAddresses()...))
+
+		// the ban runs out and the score starts over
+		require.Eventually(t, func() bool {
+			ps, _ := node.Score(attacker.ID())
+			return len(node.BannedPeers()) == 0 && ps.Score == 0
+		}, 3*time.Second, 20*time.Millisecond)
+		require.NoError(t, node.HostWrapper.ConnectToPeer(ctx, attacker.GetFullAddresses()...))
+	})
+
+	t.Run("Manual Ban", func(t *testing.T) {
+		node.Ban(provider.HostWrapper.ID(), time.Minute)
+		require.Equal(t, []peer.ID{provider.HostWrapper.ID()}, node.BannedPeers())
+		require.Contains(t, node.HostWrapper.Gater().BlockedPeers(), provider.HostWrapper.ID())
+		node.Unban(provider.HostWrapper.ID())
+		require.Empty(t, node.BannedPeers())
+		require.NotContains(t, node.HostWrapper.Gater().BlockedPeers(), provider.HostWrapper.ID())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		_, ok := provider.Score(node.HostWrapper.ID())
+		require.False(t, ok)
+		require.Nil(t, provider.Scores())
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{
+		Retry: &bitswap.RetryConfig{Timeout: 100 * time.Millisecond, Attempts: 3, Backoff: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer node.Close()
+	missing := merkledag.NewRawNode([]byte("nobody has this")).Cid()
+
+	t.Run("Not Found", func(t *testing.T) {
+		start := time.Now()
+		_, err := node.GetBlockRaw(ctx, missing)
+		require.ErrorIs(t, err, bitswap.ErrBlockNotFound)
+		// 3 attempts plus 50ms and 100ms of backoff
+		require.GreaterOrEqual(t, time.Since(start), 450*time.Millisecond)
+		require.Less(t, time.Since(start), 2*time.Second)
+	})
+
+	t.Run("Caller Deadline Wins", func(t *testing.T) {
+		cctx, ccancel := context.WithTimeout(ctx, 150*time.Millisecond)
+		defer ccancel()
+		_, err := node.GetBlock(cctx, missing)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.NotErrorIs(t, err, bitswap.ErrBlockNotFound)
+	})
+
+	t.Run("Later Attempt Succeeds", func(t *testing.T) {
+		provider, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+		require.NoError(t, err)
+		defer provider.Close()
+		c, err := provider.PutBlockRaw(ctx, []byte("appears after the first attempt"))
+		require.NoError(t, err)
+
+		// the provider only connects once the first attempt timed out
+		go func() {
+			time.Sleep(150 * time.Millisecond)
+			_ = provider.HostWrapper.ConnectToPeer(ctx, node.HostWrapper.GetFullAddresses()...)
+		}()
+		blk, err := node.GetBlock(ctx, c)
+		require.NoError(t, err)
+		require.Equal(t, c, blk.Cid())
+	})
+}
+
+func TestGatewayFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	onGateway := merkledag.NewRawNode([]byte("only on the gateway"))
+	var hits atomic.Int32
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/ipfs/"+onGateway.Cid().String() || r.URL.Query().Get("format") != "raw" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+		w.Write(onGateway.RawData())
+	}))
+	defer good.Close()
+	// answers every request with the wrong bytes
+	lying := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered"))
+	}))
+	defer lying.Close()
+
+	newService := func(t *testing.T, bs *bitswap.BitswapWrapper, gateways ...string) *bitswap.BlockServiceWrapper {
+		svc, err := bitswap.NewBlockServiceWithConfig(ctx, nil, bs, &bitswap.BlockServiceConfig{Gateways: gateways})
+		require.NoError(t, err)
+		return svc
+	}
+
+	t.Run("No Peers", func(t *testing.T) {
+		svc := newService(t, nil, lying.URL, good.URL)
+		defer svc.Close()
+		data, err := svc.GetBlockRaw(ctx, onGateway.Cid())
+		require.NoError(t, err)
+		require.Equal(t, onGateway.RawData(), data)
+		has, err := svc.HasBlock(ctx, onGateway.Cid())
+		require.NoError(t, err)
+		require.True(t, has, "fetched blocks are cached locally")
+	})
+
+	t.Run("Hash Mismatch", func(t *testing.T) {
+		svc := newService(t, nil, lying.URL)
+		defer svc.Close()
+		_, err := svc.GetBlock(ctx, onGateway.Cid())
+		require.ErrorIs(t, err, bitswap.ErrHashMismatch)
+		require.ErrorIs(t, err, bitswap.ErrBlockNotFound)
+	})
+
+	t.Run("After Bitswap Gives Up", func(t *testing.T) {
+		peerNode, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+		require.NoError(t, err)
+		defer peerNode.Close()
+		bs, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{
+			Retry: &bitswap.RetryConfig{Timeout: 100 * time.Millisecond, Attempts: 1},
+		})
+		require.NoError(t, err)
+		require.NoError(t, bs.HostWrapper.ConnectToPeer(ctx, peerNode.HostWrapper.GetFullAddresses()...))
+		svc := newService(t, bs, good.URL)
+		defer svc.Close()
+
+		// bitswap first, for blocks a peer has
+		onPeer, err := peerNode.PutBlockRaw(ctx, []byte("on a connected peer"))
+		require.NoError(t, err)
+		before := hits.Load()
+		_, err = svc.GetBlock(ctx, onPeer)
+		require.NoError(t, err)
+		require.Equal(t, before, hits.Load())
+
+		var got []cid.Cid
+		for blk := range svc.GetBlocks(ctx, []cid.Cid{onGateway.Cid()}) {
+			got = append(got, blk.Cid())
+		}
+		require.Equal(t, []cid.Cid{onGateway.Cid()}, got)
+	})
+
+	t.Run("Bad URL", func(t *testing.T) {
+		_, err := bitswap.NewGatewayFetcher([]string{"ftp://example.com"}, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestBitswapStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer provider.Close()
+	fetcher, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, nil)
+	require.NoError(t, err)
+	defer fetcher.Close()
+	require.NoError(t, fetcher.HostWrapper.ConnectToPeer(ctx, provider.HostWrapper.GetFullAddresses()...))
+
+	var cids []cid.Cid
+	var size int
+	for i := range 3 {
+		data := []byte{'s', 't', 'a', 't', byte(i)}
+		c, err := provider.PutBlockRaw(ctx, data)
+		require.NoError(t, err)
+		cids = append(cids, c)
+		size += len(data)
+	}
+	_, err = fetcher.GetBlock(ctx, cids[0])
+	require.NoError(t, err)
+	ch, err := fetcher.GetBlocks(ctx, cids[1:])
+	require.NoError(t, err)
+	for range ch {
+	}
+
+	st := fetcher.Stats()
+	require.EqualValues(t, 3, st.BlocksReceived)
+	require.EqualValues(t, size, st.DataReceived)
+	require.EqualValues(t, 2, st.TimeToFirstBlock.Count, "one GetBlock and one GetBlocks")
+	require.NotZero(t, st.WantlistSize.Count)
+	require.Zero(t, st.WantBlocks)
+	require.Eventually(t, func() bool {
+		return fetcher.Stats().Throughput.TotalIn == int64(size) &&
+			provider.Stats().Throughput.TotalOut == int64(size)
+	}, 2*time.Second, 20*time.Millisecond)
+	require.EqualValues(t, 3, provider.Stats().BlocksSent)
+
+	// a block we already hold, pushed again, counts as a duplicate
+	blk, err := provider.PersistentWrapper.Get(ctx, cids[0])
+	require.NoError(t, err)
+	require.NoError(t, fetcher.PersistentWrapper.Put(ctx, blk))
+	pusher, err := network.New(nil)
+	require.NoError(t, err)
+	defer pusher.Close()
+	require.NoError(t, pusher.ConnectToPeer(ctx, fetcher.HostWrapper.GetFullAddresses()...))
+	msg := bsmsg.New(false)
+	msg.AddBlock(blk)
+	require.NoError(t, bsnet.NewFromIpfsHost(pusher).SendMessage(ctx, fetcher.HostWrapper.ID(), msg))
+	require.Eventually(t, func() bool {
+		return fetcher.Stats().DupBlocksReceived == 1
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestBlockServiceWriteBack(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	store, err := persistent.New(persistent.File, dir)
+	require.NoError(t, err)
+	bs, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, store, nil)
+	require.NoError(t, err)
+	svc, err := bitswap.NewBlockServiceWithConfig(ctx, store, bs, &bitswap.BlockServiceConfig{
+		WriteBack: &bitswap.WriteBackConfig{Workers: 2, BatchSize: 16},
+	})
+	require.NoError(t, err)
+
+	var blks []blocks.Block
+	for i := range 100 {
+		blks = append(blks, merkledag.NewRawNode([]byte{'w', 'b', byte(i)}))
+	}
+	require.NoError(t, svc.AddBlocks(ctx, blks[:50]))
+
+	// queued blocks are readable before they are persisted
+	for _, blk := range blks[:50] {
+		got, err := svc.GetBlock(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+
+	require.NoError(t, svc.Flush(ctx))
+	st := svc.WriteBackStats()
+	require.Zero(t, st.Pending)
+	require.EqualValues(t, 50, st.Written)
+	require.Less(t, st.Batches, int64(50), "writes are batched")
+	has, err := store.Has(ctx, blks[0].Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// Close persists whatever is still queued
+	for _, blk := range blks[50:] {
+		require.NoError(t, svc.AddBlock(ctx, blk))
+	}
+	require.NoError(t, svc.Close())
+
+	reopened, err := persistent.New(persistent.File, dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+	for _, blk := range blks {
+		has, err := reopened.Has(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.True(t, has, blk.Cid())
+	}
+}
+
+func TestBlockFilter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	bad := merkledag.NewRawNode([]byte("bad block"))
+	badbit := merkledag.NewRawNode([]byte("badbits block"))
+	later := merkledag.NewRawNode([]byte("denied later"))
+	good := merkledag.NewRawNode([]byte("good block"))
+
+	// the badbits anchor is sha256 of the CIDv1 with a trailing slash
+	sum := sha256.Sum256([]byte(badbit.Cid().String() + "/"))
+	list := filepath.Join(t.TempDir(), "denylist")
+	header := "version: 1\nname: test\n---\n# comments are skipped\n"
+	require.NoError(t, os.WriteFile(list, []byte(header+
+		"/ipfs/"+bad.Cid().String()+"\n"+
+		"//"+hex.EncodeToString(sum[:])+"\n"), 0o644))
+
+	filter, err := bitswap.NewBlockFilter(bitswap.FilterConfig{
+		DenyListFile:   list,
+		ReloadInterval: 50 * time.Millisecond,
+		AllowedCodecs:  []uint64{cid.Raw, cid.DagProtobuf},
+	})
+	require.NoError(t, err)
+	defer filter.Close()
+	require.Equal(t, 2, filter.Stats().Entries)
+
+	bs, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, nil)
+	require.NoError(t, err)
+	svc, err := bitswap.NewBlockServiceWithConfig(ctx, nil, bs, &bitswap.BlockServiceConfig{Filter: filter})
+	require.NoError(t, err)
+	defer svc.Close()
+
+	t.Run("Deny List", func(t *testing.T) {
+		require.ErrorIs(t, svc.AddBlock(ctx, bad), bitswap.ErrBlockDenied)
+		require.ErrorIs(t, svc.AddBlock(ctx, badbit), bitswap.ErrBlockDenied)
+		require.NoError(t, svc.AddBlock(ctx, good))
+
+		// the same multihash under another codec is denied too
+		other := cid.NewCidV1(cid.DagProtobuf, bad.Cid().Hash())
+		require.ErrorIs(t, filter.Check(other), bitswap.ErrBlockDenied)
+	})
+
+	t.Run("Codec Allow List", func(t *testing.T) {
+		c := cid.NewCidV1(cid.DagCBOR, good.Cid().Hash())
+		require.ErrorIs(t, filter.Check(c), bitswap.ErrBlockDenied)
+	})
+
+	t.Run("Hot Reload", func(t *testing.T) {
+		require.NoError(t, svc.AddBlock(ctx, later))
+		_, err := svc.GetBlock(ctx, later.Cid())
+		require.NoError(t, err)
+
+		// make sure the new modification time differs from the loaded one
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, os.WriteFile(list, []byte(later.Cid().String()+"\n"), 0o644))
+		require.Eventually(t, func() bool {
+			return filter.Check(later.Cid()) != nil
+		}, 2*time.Second, 20*time.Millisecond)
+
+		// stored before it was listed, it is no longer handed out
+		_, err = svc.GetBlock(ctx, later.Cid())
+		require.ErrorIs(t, err, bitswap.ErrBlockDenied)
+		// the old entries are gone with the old file
+		require.NoError(t, filter.Check(bad.Cid()))
+		require.Equal(t, 1, filter.Stats().Entries)
+	})
+
+	t.Run("Not Served", func(t *testing.T) {
+		provider, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{Filter: filter})
+		require.NoError(t, err)
+		defer provider.Close()
+		client, err := bitswap.NewBitswapWithConfig(ctx, &recordingRouter{}, nil, nil, &bitswap.BitswapConfig{
+			Retry: &bitswap.RetryConfig{Timeout: 300 * time.Millisecond, Attempts: 1},
+		})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.HostWrapper.ConnectToPeer(ctx, provider.HostWrapper.GetFullAddresses()...))
+
+		okCid, err := provider.PutBlockRaw(ctx, []byte("served"))
+		require.NoError(t, err)
+		_, err = client.GetBlock(ctx, okCid)
+		require.NoError(t, err)
+
+		require.NoError(t, provider.PersistentWrapper.Put(ctx, later))
+		_, err = client.GetBlock(ctx, later.Cid())
+		require.ErrorIs(t, err, bitswap.ErrBlockNotFound)
+		require.Positive(t, filter.Stats().Rejected)
+	})
+}
+
+func TestGetMany(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer provider.Close()
+	node, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	svc, err := bitswap.NewBlockService(ctx, nil, node)
+	require.NoError(t, err)
+	defer svc.Close()
+	require.NoError(t, node.HostWrapper.ConnectToPeer(ctx, provider.HostWrapper.GetFullAddresses()...))
+
+	// local and remote blocks interleaved, a missing one and a repeat
+	var cids []cid.Cid
+	for i := range 6 {
+		blk := merkledag.NewRawNode([]byte{'g', 'm', byte(i)})
+		if i%2 == 0 {
+			require.NoError(t, svc.AddBlock(ctx, blk))
+		} else {
+			require.NoError(t, provider.PersistentWrapper.Put(ctx, blk))
+		}
+		cids = append(cids, blk.Cid())
+	}
+	missing := merkledag.NewRawNode([]byte("nobody has this")).Cid()
+	cids = slices.Insert(cids, 2, missing)
+	cids = append(cids, cids[1])
+
+	collect := func(ordered bool) []bitswap.BlockResult {
+		gctx, gcancel := context.WithTimeout(ctx, time.Second)
+		defer gcancel()
+		var out []bitswap.BlockResult
+		for r := range svc.GetMany(gctx, cids, ordered) {
+			out = append(out, r)
+		}
+		return out
+	}
+	check := func(t *testing.T, res []bitswap.BlockResult) {
+		require.Len(t, res, len(cids))
+		for _, r := range res {
+			require.Equal(t, cids[r.Index], r.Cid)
+			if r.Cid == missing {
+				require.ErrorIs(t, r.Err, context.DeadlineExceeded)
+				require.Nil(t, r.Block)
+				continue
+			}
+			require.NoError(t, r.Err)
+			require.Equal(t, r.Cid, r.Block.Cid())
+		}
+	}
+
+	t.Run("Ordered", func(t *testing.T) {
+		res := collect(true)
+		check(t, res)
+		for i, r := range res {
+			require.Equal(t, i, r.Index)
+		}
+	})
+
+	t.Run("Unordered", func(t *testing.T) {
+		res := collect(false)
+		check(t, res)
+		// nothing waits for the missing block
+		require.Equal(t, missing, res[len(res)-1].Cid)
+	})
+}
+
+func TestAdvancedFeaturesDemo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// the Badger store lands in the given directory, not the working one
+	dir := t.TempDir()
+	demonstrateAdvancedFeatures(ctx, dir)
+	_, err := os.Stat(filepath.Join(dir, "MANIFEST"))
+	require.NoError(t, err)
+	_, err = os.Stat("bitswap_badger")
+	require.True(t, os.IsNotExist(err))
+}
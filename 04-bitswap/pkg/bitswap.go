@@ -12,6 +12,7 @@ import (
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 
 	block "github.com/gosuda/boxo-starter-kit/00-block-cid/pkg"
 	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
@@ -29,8 +30,17 @@ type BitswapWrapper struct {
 	PersistentWrapper *persistent.PersistentWrapper
 	*bitswap.Bitswap
 
+	router   routing.ContentRouting
+	provide  bool
+	mode     Mode
+	prefetch *prefetcher // nil unless BitswapConfig.Prefetch is set
+	scorer   *scorer     // nil unless BitswapConfig.Scoring is set
+	retry    RetryConfig
+	stats    *bitswapStats
+
 	// Metrics
-	metrics *metrics.ComponentMetrics
+	metrics     *metrics.ComponentMetrics
+	metricsName string // "bitswap/<peer ID>" in the global registries
 }
 
 // NewBitswap creates a new simplified bitswap node for educational purposes
@@ -54,13 +64,129 @@ func NewBitswap(ctx context.Context, dhtWrapper *dht.DHTWrapper, host *network.H
 			return nil, fmt.Errorf("failed to create DHT: %w", err)
 		}
 	}
+	return NewBitswapWithRouter(ctx, dhtWrapper, host, persistentWrapper)
+}
+
+// NewBitswapWithRouter is NewBitswap with any content router for provider
+// discovery, e.g. a dht.ComposedRouter over the DHT, IPNI and delegated
+// HTTP routing. The router must not be nil.
+func NewBitswapWithRouter(ctx context.Context, router routing.ContentRouting, host *network.HostWrapper, persistentWrapper *persistent.PersistentWrapper) (*BitswapWrapper, error) {
+	return NewBitswapWithConfig(ctx, router, host, persistentWrapper, nil)
+}
 
+// BitswapConfig tunes the bitswap engine. Zero values keep the boxo
+// defaults noted on each field.
+type BitswapConfig struct {
+	// Fetch only, serve only, or both (default)
+	Mode Mode
+
+	// Workers sending blocks to peers (default 8)
+	TaskWorkerCount int
+	// Bytes queued for a single peer before the engine moves on to the
+	// next one (default 1 MiB)
+	MaxOutstandingBytesPerPeer int
+	// Size the engine aims for when batching blocks into one message
+	// (default 16 KiB)
+	TargetMessageSize int
+	// Wait before asking the router for providers of a wanted block
+	// (default 1s)
+	ProviderSearchDelay time.Duration
+
+	// Request the children of fetched dag-pb blocks ahead of time;
+	// nil disables prefetching
+	Prefetch *PrefetchConfig
+
+	// Per-attempt timeout and retries of block fetches; nil uses the
+	// defaults (3 attempts of 5s)
+	Retry *RetryConfig
+
+	// Refuse to serve blocks the filter denies; share it with the
+	// BlockServiceConfig so they are not stored either
+	Filter *BlockFilter
+
+	// Score peers by the blocks they send and ban the ones sending junk;
+	// nil disables scoring
+	Scoring *ScoreConfig
+
+	// Announce blocks stored with PutBlockRaw to the content router.
+	// Bitswap itself never provides, so without this peers only find the
+	// blocks by asking connected nodes.
+	Provide bool
+}
+
+// NewBitswapWithConfig is NewBitswapWithRouter with engine tuning; a nil
+// cfg uses the defaults
+func NewBitswapWithConfig(ctx context.Context, router routing.ContentRouting, host *network.HostWrapper, persistentWrapper *persistent.PersistentWrapper, cfg *BitswapConfig) (*BitswapWrapper, error) {
+	if cfg == nil {
+		cfg = &BitswapConfig{}
+	}
+	switch cfg.Mode {
+	case ModeFull, ModeClientOnly, ModeServerOnly:
+	default:
+		return nil, fmt.Errorf("unknown bitswap mode %d", int(cfg.Mode))
+	}
+	if router == nil {
+		return nil, fmt.Errorf("content router is required")
+	}
+	var err error
+	if host == nil {
+		host, err = network.New(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+		}
+	}
+	if persistentWrapper == nil {
+		persistentWrapper, err = persistent.New(persistent.Memory, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create persistent storage: %w", err)
+		}
+	}
+
+	stats := newBitswapStats()
 	bsnet := bsnet.NewFromIpfsHost(host)
-	bsnet = bnet.New(nil, bsnet, nil)
-	bswap := bitswap.New(ctx, bsnet, dhtWrapper, persistentWrapper,
+	bsnet = statsNetwork{BitSwapNetwork: bnet.New(nil, bsnet, nil), stats: stats}
+	opts := []bitswap.Option{
 		bitswap.SetSendDontHaves(true),
 		bitswap.ProviderSearchDelay(time.Second),
-	)
+	}
+	if cfg.ProviderSearchDelay > 0 {
+		opts = append(opts, bitswap.ProviderSearchDelay(cfg.ProviderSearchDelay))
+	}
+	if cfg.TaskWorkerCount > 0 {
+		opts = append(opts, bitswap.TaskWorkerCount(cfg.TaskWorkerCount))
+	}
+	if cfg.MaxOutstandingBytesPerPeer > 0 {
+		opts = append(opts, bitswap.MaxOutstandingBytesPerPeer(cfg.MaxOutstandingBytesPerPeer))
+	}
+	if cfg.TargetMessageSize > 0 {
+		opts = append(opts, bitswap.WithTargetMessageSize(cfg.TargetMessageSize))
+	}
+	if cfg.Mode == ModeClientOnly {
+		opts = append(opts, bitswap.WithServerEnabled(false))
+	}
+	tracers := multiTracer{stats}
+	var serveFilters []func(peer.ID, cid.Cid) bool
+	var sc *scorer
+	if cfg.Scoring != nil {
+		sc = newScorer(cfg.Scoring)
+		tracers = append(tracers, sc)
+		serveFilters = append(serveFilters, sc.allowServe)
+	}
+	if cfg.Filter != nil {
+		serveFilters = append(serveFilters, cfg.Filter.allowServe)
+	}
+	if len(serveFilters) > 0 {
+		opts = append(opts, bitswap.WithPeerBlockRequestFilter(func(p peer.ID, c cid.Cid) bool {
+			for _, allow := range serveFilters {
+				if !allow(p, c) {
+					return false
+				}
+			}
+			return true
+		}))
+	}
+	opts = append(opts, bitswap.WithTracer(tracers))
+	bswap := bitswap.New(ctx, bsnet, router, persistentWrapper, opts...)
 
 	// Initialize metrics
 	bitswapMetrics := metrics.NewComponentMetrics("bitswap")
@@ -70,13 +196,38 @@ func NewBitswap(ctx context.Context, dhtWrapper *dht.DHTWrapper, host *network.H
 		HostWrapper:       host,
 		PersistentWrapper: persistentWrapper,
 		Bitswap:           bswap,
+		router:            router,
+		provide:           cfg.Provide,
+		mode:              cfg.Mode,
+		scorer:            sc,
+		retry:             cfg.Retry.withDefaults(),
+		stats:             stats,
 		metrics:           bitswapMetrics,
+		metricsName:       "bitswap/" + host.ID().String(),
+	}
+	if sc != nil {
+		sc.mu.Lock()
+		sc.b = node
+		sc.mu.Unlock()
+	}
+	metrics.RegisterGlobalProtocol(node.metricsName, func() any { return node.Stats() })
+	metrics.RegisterGlobalBandwidth(node.metricsName, func() metrics.BandwidthTotals { return node.Stats().Throughput })
+	if cfg.Prefetch != nil && cfg.Mode != ModeServerOnly {
+		node.prefetch = newPrefetcher(node, cfg.Prefetch)
 	}
 
 	return node, nil
 }
 
 func (b *BitswapWrapper) Close() error {
+	metrics.UnregisterGlobalProtocol(b.metricsName)
+	metrics.UnregisterGlobalBandwidth(b.metricsName)
+	if b.prefetch != nil {
+		b.prefetch.close()
+	}
+	if b.scorer != nil {
+		b.scorer.close()
+	}
 	if err := b.Bitswap.Close(); err != nil {
 		return err
 	}
@@ -105,13 +256,41 @@ func (b *BitswapWrapper) PutBlockRaw(ctx context.Context, data []byte) (cid.Cid,
 	if err := b.Bitswap.NotifyNewBlocks(ctx, blk); err != nil {
 		return cid.Undef, fmt.Errorf("bitswap announce failed: %w", err)
 	}
+	if b.provide {
+		if err := b.router.Provide(ctx, blk.Cid(), true); err != nil {
+			return cid.Undef, fmt.Errorf("provide failed: %w", err)
+		}
+	}
 
 	return blk.Cid(), nil
 }
 
-// GetBlock retrieves a block by CID (simplified implementation)
+// GetBlock retrieves a block by CID, retrying per BitswapConfig.Retry;
+// ErrBlockNotFound once the attempts run out
 func (b *BitswapWrapper) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
-	return b.Bitswap.GetBlock(ctx, c)
+	if b.mode == ModeServerOnly {
+		return nil, ErrServerOnly
+	}
+	start := time.Now()
+	fetch := func(ctx context.Context) (blocks.Block, error) {
+		blk, err := b.Bitswap.GetBlock(ctx, c)
+		if err == nil {
+			b.stats.firstBlock.ObserveDuration(time.Since(start))
+		}
+		return blk, err
+	}
+	if b.prefetch == nil {
+		return b.fetchWithRetry(ctx, c, fetch)
+	}
+	// prefetched blocks are served locally and push the window further down
+	blk, err := b.PersistentWrapper.Get(ctx, c)
+	if err != nil {
+		if blk, err = b.fetchWithRetry(ctx, c, fetch); err != nil {
+			return nil, err
+		}
+	}
+	b.prefetch.onBlock(blk)
+	return blk, nil
 }
 
 func (b *BitswapWrapper) GetBlockRaw(ctx context.Context, c cid.Cid) ([]byte, error) {
@@ -124,6 +303,9 @@ func (b *BitswapWrapper) GetBlockRaw(ctx context.Context, c cid.Cid) ([]byte, er
 
 // GetBlockFromPeer retrieves a block from a specific peer
 func (b *BitswapWrapper) GetBlockFromPeer(ctx context.Context, c cid.Cid, targetPeer peer.ID) (blocks.Block, error) {
+	if b.mode == ModeServerOnly {
+		return nil, ErrServerOnly
+	}
 	start := time.Now()
 	b.metrics.RecordRequest()
 
@@ -145,12 +327,18 @@ func (b *BitswapWrapper) GetBlockFromPeer(ctx context.Context, c cid.Cid, target
 	}
 
 	// Create a session for targeted fetching
-	session := b.Bitswap.NewSession(ctx)
+	session := b.NewSession(ctx)
 
 	// Use the session to fetch the block
 	// Note: This still relies on the underlying bitswap routing,
 	// but sessions provide better performance for targeted requests
-	block, err := session.GetBlock(ctx, c)
+	block, err := b.fetchWithRetry(ctx, c, func(ctx context.Context) (blocks.Block, error) {
+		blk, err := session.GetBlock(ctx, c)
+		if err == nil {
+			b.stats.firstBlock.ObserveDuration(time.Since(start))
+		}
+		return blk, err
+	})
 	if err != nil {
 		b.metrics.RecordFailure(time.Since(start), "block_fetch_failed")
 		return nil, fmt.Errorf("failed to get block %s from peer %s: %w", c, targetPeer, err)
@@ -171,6 +359,9 @@ func (b *BitswapWrapper) GetBlockFromPeerRaw(ctx context.Context, c cid.Cid, tar
 
 // RequestBlockFromPeer sends a block request to a specific peer without blocking
 func (b *BitswapWrapper) RequestBlockFromPeer(ctx context.Context, c cid.Cid, targetPeer peer.ID) error {
+	if b.mode == ModeServerOnly {
+		return ErrServerOnly
+	}
 	start := time.Now()
 	b.metrics.RecordRequest()
 
@@ -191,7 +382,7 @@ func (b *BitswapWrapper) RequestBlockFromPeer(ctx context.Context, c cid.Cid, ta
 	}
 
 	// Send want request (non-blocking)
-	session := b.Bitswap.NewSession(ctx)
+	session := b.NewSession(ctx)
 
 	// Start fetching in background
 	go func() {
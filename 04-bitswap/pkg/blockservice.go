@@ -2,9 +2,14 @@ package bitswap
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 
@@ -15,9 +20,43 @@ import (
 type BlockServiceWrapper struct {
 	PersistentWrapper *persistent.PersistentWrapper
 	blockservice.BlockService
+
+	writeBack *writeBack // nil unless BlockServiceConfig.WriteBack is set
+}
+
+// BlockServiceConfig adds fallbacks to the bitswap exchange of a BlockService
+type BlockServiceConfig struct {
+	// Trustless gateways asked, in order, for blocks bitswap cannot find,
+	// e.g. DefaultGateway. With no connected peers they are asked first.
+	Gateways []string
+	// Bounds each gateway request (default 10s)
+	GatewayTimeout time.Duration
+
+	// Acknowledge added blocks once queued and persist them in the
+	// background, for slow backends; nil writes through. Close flushes.
+	// Peers are served a block only once it is persisted.
+	WriteBack *WriteBackConfig
+
+	// Refuse to store or fetch blocks the filter denies; stored blocks
+	// that become denied are hidden
+	Filter *BlockFilter
+
+	// Fetch missing blocks through this exchange, e.g. a multifetcher
+	// racing several protocols, instead of bitswap. Gateways are not
+	// consulted then.
+	Exchange exchange.Interface
 }
 
 func NewBlockService(ctx context.Context, persistentWrapper *persistent.PersistentWrapper, bitswapWrapper *BitswapWrapper) (*BlockServiceWrapper, error) {
+	return NewBlockServiceWithConfig(ctx, persistentWrapper, bitswapWrapper, nil)
+}
+
+// NewBlockServiceWithConfig is NewBlockService with fallbacks; a nil cfg
+// fetches through bitswap only
+func NewBlockServiceWithConfig(ctx context.Context, persistentWrapper *persistent.PersistentWrapper, bitswapWrapper *BitswapWrapper, cfg *BlockServiceConfig) (*BlockServiceWrapper, error) {
+	if cfg == nil {
+		cfg = &BlockServiceConfig{}
+	}
 	var err error
 	if persistentWrapper == nil {
 		if bitswapWrapper != nil && bitswapWrapper.PersistentWrapper != nil {
@@ -31,26 +70,69 @@ func NewBlockService(ctx context.Context, persistentWrapper *persistent.Persiste
 			}
 		}
 	}
-	if bitswapWrapper == nil {
+	if bitswapWrapper == nil && cfg.Exchange == nil {
 		bitswapWrapper, err = NewBitswap(ctx, nil, nil, persistentWrapper)
 		if err != nil {
 			return nil, fmt.Errorf("init bitswap: %w", err)
 		}
 	}
 
-	bs := blockservice.New(persistentWrapper, bitswapWrapper)
+	var ex exchange.Interface = bitswapWrapper
+	if cfg.Exchange != nil {
+		ex = cfg.Exchange
+	} else if len(cfg.Gateways) > 0 {
+		gw, err := NewGatewayFetcher(cfg.Gateways, cfg.GatewayTimeout)
+		if err != nil {
+			return nil, err
+		}
+		ex = gatewayExchange{gatewayFallback{primary: bitswapWrapper, gw: gw, b: bitswapWrapper}}
+	}
+	var store blockstore.Blockstore = persistentWrapper
+	var wb *writeBack
+	if cfg.WriteBack != nil {
+		wb = newWriteBack(persistentWrapper, cfg.WriteBack)
+		store = wb
+	}
+	if cfg.Filter != nil {
+		store = filteredStore{Blockstore: store, f: cfg.Filter}
+	}
+	bs := blockservice.New(store, ex)
 
 	return &BlockServiceWrapper{
 		PersistentWrapper: persistentWrapper,
 		BlockService:      bs,
+		writeBack:         wb,
 	}, nil
 }
 
+// Close persists any queued blocks before shutting down
 func (b *BlockServiceWrapper) Close() error {
+	var err error
+	if b.writeBack != nil {
+		err = b.writeBack.close()
+	}
 	if b.BlockService == nil {
+		return err
+	}
+	return errors.Join(err, b.BlockService.Close())
+}
+
+// Flush waits until every block added so far is persisted; a no-op
+// without write-back. It returns the first failed write since the last
+// Flush.
+func (b *BlockServiceWrapper) Flush(ctx context.Context) error {
+	if b.writeBack == nil {
 		return nil
 	}
-	return b.BlockService.Close()
+	return b.writeBack.flush(ctx)
+}
+
+// WriteBackStats reports the write-back queue; zero without write-back
+func (b *BlockServiceWrapper) WriteBackStats() WriteBackStats {
+	if b.writeBack == nil {
+		return WriteBackStats{}
+	}
+	return b.writeBack.stats()
 }
 
 func (b *BlockServiceWrapper) GetBlockRaw(ctx context.Context, cid cid.Cid) ([]byte, error) {
@@ -69,6 +151,70 @@ func (b *BlockServiceWrapper) GetBlocks(ctx context.Context, cids []cid.Cid) <-c
 	return b.BlockService.GetBlocks(ctx, cids)
 }
 
+// BlockResult is the outcome of one CID asked for with GetMany
+type BlockResult struct {
+	Index int // position of Cid in the request
+	Cid   cid.Cid
+	Block blocks.Block // nil if Err is set
+	Err   error
+}
+
+// GetMany fetches cids and reports one result per requested CID. With
+// ordered set results come in request order, blocks that arrive early
+// being held back until the ones before them are in; otherwise they come
+// as they arrive. CIDs that were not found end with ErrBlockNotFound, or
+// with ctx's error if it ended first, so a fetch for a block nobody has
+// lasts until ctx ends: bound it. The channel is buffered for the whole
+// request and closed after the last result.
+func (b *BlockServiceWrapper) GetMany(ctx context.Context, cids []cid.Cid, ordered bool) <-chan BlockResult {
+	out := make(chan BlockResult, len(cids))
+	positions := make(map[cid.Cid][]int, len(cids))
+	for i, c := range cids {
+		positions[c] = append(positions[c], i)
+	}
+
+	go func() {
+		defer close(out)
+		results := make([]*BlockResult, len(cids))
+		next := 0 // first result not yet delivered, in ordered mode
+		deliver := func(r BlockResult) {
+			if !ordered {
+				out <- r
+				return
+			}
+			results[r.Index] = &r
+			for next < len(results) && results[next] != nil {
+				out <- *results[next]
+				results[next] = nil
+				next++
+			}
+		}
+
+		for blk := range b.BlockService.GetBlocks(ctx, cids) {
+			for _, i := range positions[blk.Cid()] {
+				deliver(BlockResult{Index: i, Cid: blk.Cid(), Block: blk})
+			}
+			delete(positions, blk.Cid())
+		}
+
+		// whatever is left did not arrive
+		missing := make([]int, 0, len(positions))
+		for _, idx := range positions {
+			missing = append(missing, idx...)
+		}
+		slices.Sort(missing)
+		for _, i := range missing {
+			c := cids[i]
+			err := fmt.Errorf("%w: %s", ErrBlockNotFound, c)
+			if ctx.Err() != nil {
+				err = fmt.Errorf("get block %s: %w", c, ctx.Err())
+			}
+			deliver(BlockResult{Index: i, Cid: c, Err: err})
+		}
+	}()
+	return out
+}
+
 func (b *BlockServiceWrapper) AddBlockRaw(ctx context.Context, payload []byte) (cid.Cid, error) {
 	blk, err := block.NewBlock(payload, nil)
 	if err != nil {
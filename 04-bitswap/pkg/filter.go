@@ -0,0 +1,307 @@
+package bitswap
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultFilterReload is how often the deny list file is checked for changes
+const DefaultFilterReload = 30 * time.Second
+
+// ErrBlockDenied is returned for blocks the filter rejects
+var ErrBlockDenied = errors.New("block denied by filter")
+
+// FilterConfig sets which blocks a node refuses to store and serve.
+type FilterConfig struct {
+	// Deny list file, reloaded when it changes. One entry per line:
+	//   /ipfs/<cid> or <cid>   blocks with that multihash, any codec
+	//   //<hex>                 badbits double hash, sha256("<CIDv1>/")
+	// Empty lines and lines starting with # are skipped; a YAML header
+	// ending in "---" is skipped as well.
+	DenyListFile string
+	// Pause between checks of the file (default 30s)
+	ReloadInterval time.Duration
+
+	// If set, only these codecs (e.g. cid.Raw, cid.DagProtobuf) and
+	// multihash functions (e.g. mh.SHA2_256) are accepted
+	AllowedCodecs []uint64
+	AllowedHashes []uint64
+}
+
+// FilterStats reports the filter's list and its hits
+type FilterStats struct {
+	Entries    int       // deny list entries
+	Rejected   int64     // blocks refused since start
+	LastReload time.Time // last successful load of the file
+}
+
+// BlockFilter rejects blocks on a deny list or with a codec or hash
+// function that is not allowed. Share one filter between BitswapConfig
+// (not served to peers) and BlockServiceConfig (not stored or fetched).
+type BlockFilter struct {
+	cfg    FilterConfig
+	codecs map[uint64]struct{}
+	hashes map[uint64]struct{}
+
+	mu         sync.RWMutex
+	denied     map[string]struct{} // multihashes
+	doubles    map[string]struct{} // badbits double hashes, hex
+	modTime    time.Time
+	lastReload time.Time
+
+	rejected atomic.Int64
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewBlockFilter loads the deny list, if any, and starts watching it
+func NewBlockFilter(cfg FilterConfig) (*BlockFilter, error) {
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = DefaultFilterReload
+	}
+	f := &BlockFilter{cfg: cfg, denied: map[string]struct{}{}, doubles: map[string]struct{}{}}
+	if len(cfg.AllowedCodecs) > 0 {
+		f.codecs = make(map[uint64]struct{})
+		for _, c := range cfg.AllowedCodecs {
+			f.codecs[c] = struct{}{}
+		}
+	}
+	if len(cfg.AllowedHashes) > 0 {
+		f.hashes = make(map[uint64]struct{})
+		for _, h := range cfg.AllowedHashes {
+			f.hashes[h] = struct{}{}
+		}
+	}
+	if cfg.DenyListFile == "" {
+		return f, nil
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.done = make(chan struct{})
+	go f.watch(ctx)
+	return f, nil
+}
+
+// parseDenyList reads the entries of a deny list file
+func parseDenyList(path string) (denied, doubles map[string]struct{}, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deny list: %w", err)
+	}
+	defer file.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "---" {
+			lines = lines[:0] // everything so far was the header
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, fmt.Errorf("deny list: %w", err)
+	}
+
+	denied, doubles = make(map[string]struct{}), make(map[string]struct{})
+	for n, line := range lines {
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "//"):
+			doubles[strings.ToLower(strings.TrimPrefix(line, "//"))] = struct{}{}
+		default:
+			c, err := cid.Decode(strings.TrimPrefix(line, "/ipfs/"))
+			if err != nil {
+				log.Debug().Str("line", line).Int("n", n).Msg("skipping deny list entry")
+				continue
+			}
+			denied[string(c.Hash())] = struct{}{}
+		}
+	}
+	return denied, doubles, nil
+}
+
+// Reload reads the deny list file again
+func (f *BlockFilter) Reload() error {
+	if f.cfg.DenyListFile == "" {
+		return nil
+	}
+	fi, err := os.Stat(f.cfg.DenyListFile)
+	if err != nil {
+		return fmt.Errorf("deny list: %w", err)
+	}
+	denied, doubles, err := parseDenyList(f.cfg.DenyListFile)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.denied, f.doubles = denied, doubles
+	f.modTime, f.lastReload = fi.ModTime(), time.Now()
+	f.mu.Unlock()
+	log.Info().Str("file", f.cfg.DenyListFile).Int("entries", len(denied)+len(doubles)).Msg("deny list loaded")
+	return nil
+}
+
+// watch reloads the file whenever its modification time changes
+func (f *BlockFilter) watch(ctx context.Context) {
+	defer close(f.done)
+	ticker := time.NewTicker(f.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(f.cfg.DenyListFile)
+		if err != nil {
+			log.Warn().Err(err).Msg("deny list unavailable, keeping the loaded one")
+			continue
+		}
+		f.mu.RLock()
+		changed := !fi.ModTime().Equal(f.modTime)
+		f.mu.RUnlock()
+		if changed {
+			if err := f.Reload(); err != nil {
+				log.Warn().Err(err).Msg("deny list reload failed, keeping the loaded one")
+			}
+		}
+	}
+}
+
+// doubleHash is the badbits anchor of c
+func doubleHash(c cid.Cid) string {
+	v1 := cid.NewCidV1(c.Type(), c.Hash())
+	sum := sha256.Sum256([]byte(v1.String() + "/"))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check returns ErrBlockDenied if c must not be stored or served
+func (f *BlockFilter) Check(c cid.Cid) error {
+	if err := f.check(c); err != nil {
+		f.rejected.Add(1)
+		return err
+	}
+	return nil
+}
+
+func (f *BlockFilter) check(c cid.Cid) error {
+	if f.codecs != nil {
+		if _, ok := f.codecs[c.Type()]; !ok {
+			return fmt.Errorf("%w: codec 0x%x not allowed", ErrBlockDenied, c.Type())
+		}
+	}
+	if f.hashes != nil {
+		if _, ok := f.hashes[c.Prefix().MhType]; !ok {
+			name := mh.Codes[c.Prefix().MhType]
+			return fmt.Errorf("%w: hash function %s not allowed", ErrBlockDenied, name)
+		}
+	}
+
+	f.mu.RLock()
+	_, denied := f.denied[string(c.Hash())]
+	checkDoubles := len(f.doubles) > 0
+	f.mu.RUnlock()
+	if !denied && checkDoubles {
+		d := doubleHash(c)
+		f.mu.RLock()
+		_, denied = f.doubles[d]
+		f.mu.RUnlock()
+	}
+	if denied {
+		return fmt.Errorf("%w: %s is on the deny list", ErrBlockDenied, c)
+	}
+	return nil
+}
+
+// Stats reports the size of the list and how many blocks were refused
+func (f *BlockFilter) Stats() FilterStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return FilterStats{
+		Entries:    len(f.denied) + len(f.doubles),
+		Rejected:   f.rejected.Load(),
+		LastReload: f.lastReload,
+	}
+}
+
+// Close stops watching the deny list file
+func (f *BlockFilter) Close() error {
+	if f.cancel != nil {
+		f.cancel()
+		<-f.done
+		f.cancel = nil
+	}
+	return nil
+}
+
+// allowServe is the bitswap server's request filter
+func (f *BlockFilter) allowServe(_ peer.ID, c cid.Cid) bool {
+	return f.Check(c) == nil
+}
+
+// filteredStore refuses to store denied blocks and hides stored ones, so
+// a block added to the list after it was stored is not handed out either
+type filteredStore struct {
+	blockstore.Blockstore
+	f *BlockFilter
+}
+
+func (s filteredStore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := s.f.Check(blk.Cid()); err != nil {
+		return err
+	}
+	return s.Blockstore.Put(ctx, blk)
+}
+
+func (s filteredStore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := s.f.Check(blk.Cid()); err != nil {
+			return err
+		}
+	}
+	return s.Blockstore.PutMany(ctx, blks)
+}
+
+func (s filteredStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if err := s.f.Check(c); err != nil {
+		return nil, err
+	}
+	return s.Blockstore.Get(ctx, c)
+}
+
+func (s filteredStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if s.f.check(c) != nil {
+		return false, nil
+	}
+	return s.Blockstore.Has(ctx, c)
+}
+
+func (s filteredStore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	if s.f.check(c) != nil {
+		return -1, ipld.ErrNotFound{Cid: c}
+	}
+	return s.Blockstore.GetSize(ctx, c)
+}
@@ -0,0 +1,224 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultGateway is a public trustless gateway that serves raw blocks
+	DefaultGateway = "https://trustless-gateway.link"
+	// DefaultGatewayTimeout bounds one block request to one gateway
+	DefaultGatewayTimeout = 10 * time.Second
+	// maxGatewayBlockSize is the largest block accepted from a gateway,
+	// the same limit bitswap enforces
+	maxGatewayBlockSize = 2 << 20
+)
+
+// ErrHashMismatch is returned when a gateway sends data that does not hash
+// to the requested CID
+var ErrHashMismatch = errors.New("gateway: block does not match its cid")
+
+// GatewayFetcher fetches single blocks from trustless HTTP gateways
+// (GET /ipfs/{cid}?format=raw). Gateways are not trusted: every block is
+// hashed and dropped unless it matches the CID asked for.
+type GatewayFetcher struct {
+	urls   []string
+	client *http.Client
+}
+
+var _ exchange.Fetcher = (*GatewayFetcher)(nil)
+
+// NewGatewayFetcher tries urls in order; timeout bounds each request
+// (default 10s)
+func NewGatewayFetcher(urls []string, timeout time.Duration) (*GatewayFetcher, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("at least one gateway url is required")
+	}
+	if timeout <= 0 {
+		timeout = DefaultGatewayTimeout
+	}
+	out := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("gateway url %q: must be http or https", u)
+		}
+		out = append(out, strings.TrimSuffix(u, "/"))
+	}
+	return &GatewayFetcher{
+		urls:   out,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// GetBlock asks each gateway in turn and returns the first block that
+// verifies against c
+func (g *GatewayFetcher) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	var errs []error
+	for _, u := range g.urls {
+		blk, err := g.fetch(ctx, u, c)
+		if err == nil {
+			return blk, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Debug().Err(err).Str("gateway", u).Str("cid", c.String()).Msg("gateway fetch failed")
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("%w: %s from %d gateways: %w", ErrBlockNotFound, c, len(g.urls), errors.Join(errs...))
+}
+
+// GetBlocks fetches the blocks one after another; missing ones are skipped
+func (g *GatewayFetcher) GetBlocks(ctx context.Context, cs []cid.Cid) (<-chan blocks.Block, error) {
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for _, c := range cs {
+			blk, err := g.GetBlock(ctx, c)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (g *GatewayFetcher) fetch(ctx context.Context, base string, c cid.Cid) (blocks.Block, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/ipfs/"+c.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxGatewayBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGatewayBlockSize {
+		return nil, fmt.Errorf("block larger than %d bytes", maxGatewayBlockSize)
+	}
+
+	got, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !got.Equals(c) {
+		return nil, ErrHashMismatch
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// gatewayFallback fetches through primary and turns to the gateways for
+// whatever primary could not find. With no connected peers bitswap cannot
+// find anything, so it goes to the gateways straight away.
+type gatewayFallback struct {
+	primary exchange.Fetcher
+	gw      *GatewayFetcher
+	b       *BitswapWrapper
+}
+
+func (f gatewayFallback) offline() bool {
+	return len(f.b.GetConnectedPeers()) == 0
+}
+
+func (f gatewayFallback) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if f.offline() {
+		return f.gw.GetBlock(ctx, c)
+	}
+	blk, err := f.primary.GetBlock(ctx, c)
+	if err == nil || ctx.Err() != nil {
+		return blk, err
+	}
+	return f.gw.GetBlock(ctx, c)
+}
+
+func (f gatewayFallback) GetBlocks(ctx context.Context, cs []cid.Cid) (<-chan blocks.Block, error) {
+	if f.offline() {
+		return f.gw.GetBlocks(ctx, cs)
+	}
+	// give bitswap one attempt's worth of time, then fill the gaps
+	bctx, cancel := context.WithTimeout(ctx, f.b.retry.Timeout)
+	in, err := f.primary.GetBlocks(bctx, cs)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		defer cancel()
+		got := make(map[cid.Cid]struct{}, len(cs))
+		for blk := range in {
+			got[blk.Cid()] = struct{}{}
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+		}
+		var missing []cid.Cid
+		for _, c := range cs {
+			if _, ok := got[c]; !ok {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+		rest, _ := f.gw.GetBlocks(ctx, missing)
+		for blk := range rest {
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// gatewayExchange is the exchange of a BlockService with gateway fallback
+type gatewayExchange struct {
+	gatewayFallback
+}
+
+var _ exchange.SessionExchange = gatewayExchange{}
+
+func (e gatewayExchange) NotifyNewBlocks(ctx context.Context, blks ...blocks.Block) error {
+	return e.b.NotifyNewBlocks(ctx, blks...)
+}
+
+func (e gatewayExchange) NewSession(ctx context.Context) exchange.Fetcher {
+	return gatewayFallback{primary: e.b.NewSession(ctx), gw: e.gw, b: e.b}
+}
+
+func (e gatewayExchange) Close() error {
+	return e.b.Close()
+}
@@ -0,0 +1,79 @@
+package bitswap
+
+import (
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Want is a block this node is currently asking the network for
+type Want struct {
+	Cid cid.Cid
+	// WantBlock is set when we asked for the block itself; otherwise we
+	// only asked peers whether they have it (want-have)
+	WantBlock bool
+}
+
+// PeerLedger is the bitswap accounting kept for one peer
+type PeerLedger struct {
+	Peer          peer.ID
+	BytesSent     uint64  // block data we sent to the peer
+	BytesReceived uint64  // block data the peer sent us
+	Exchanges     uint64  // blocks sent plus blocks received
+	Value         float64 // the server's score; higher peers get served first
+}
+
+// CurrentWants lists the blocks this node is waiting for, want-blocks
+// first. A fetch that stays in this list is stuck: no connected peer
+// has sent the block yet.
+func (b *BitswapWrapper) CurrentWants() []Want {
+	var wants []Want
+	seen := make(map[cid.Cid]struct{})
+	for _, c := range b.Bitswap.GetWantBlocks() {
+		seen[c] = struct{}{}
+		wants = append(wants, Want{Cid: c, WantBlock: true})
+	}
+	for _, c := range b.Bitswap.GetWantHaves() {
+		if _, ok := seen[c]; !ok {
+			wants = append(wants, Want{Cid: c})
+		}
+	}
+	return wants
+}
+
+// WantlistForPeer lists the blocks p has asked us for and not yet been
+// sent, i.e. what this node owes p. Always empty on a client-only node.
+func (b *BitswapWrapper) WantlistForPeer(p peer.ID) []cid.Cid {
+	return b.Bitswap.WantlistForPeer(p)
+}
+
+// LedgerForPeer returns the accounting for p; all zero if we never
+// exchanged blocks with it. The ledger lives in the server, so a
+// client-only node keeps none.
+func (b *BitswapWrapper) LedgerForPeer(p peer.ID) PeerLedger {
+	l := PeerLedger{Peer: p}
+	if b.Bitswap.Server == nil {
+		return l
+	}
+	if r := b.Bitswap.LedgerForPeer(p); r != nil {
+		l.BytesSent, l.BytesReceived = r.Sent, r.Recv
+		l.Exchanges, l.Value = r.Exchanged, r.Value
+	}
+	return l
+}
+
+// Ledgers returns the ledgers of all connected peers, busiest first
+func (b *BitswapWrapper) Ledgers() []PeerLedger {
+	var out []PeerLedger
+	for _, p := range b.GetConnectedPeers() {
+		out = append(out, b.LedgerForPeer(p))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Exchanges != out[j].Exchanges {
+			return out[i].Exchanges > out[j].Exchanges
+		}
+		return out[i].Peer < out[j].Peer
+	})
+	return out
+}
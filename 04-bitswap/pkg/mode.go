@@ -0,0 +1,80 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// Mode selects which half of the protocol a node runs
+type Mode int
+
+const (
+	ModeFull       Mode = iota // fetch and serve blocks (default)
+	ModeClientOnly             // fetch only and never answer wants, e.g. a gateway edge node
+	ModeServerOnly             // serve only and never send wants, e.g. a seeder
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeFull:
+		return "full"
+	case ModeClientOnly:
+		return "client-only"
+	case ModeServerOnly:
+		return "server-only"
+	}
+	return fmt.Sprintf("mode(%d)", int(m))
+}
+
+// ErrServerOnly is returned by every fetch on a ModeServerOnly node
+var ErrServerOnly = errors.New("bitswap: node is server-only and does not fetch")
+
+// Mode reports which half of the protocol this node runs
+func (b *BitswapWrapper) Mode() Mode {
+	return b.mode
+}
+
+// GetBlocks fetches blocks from the network; ErrServerOnly on a
+// server-only node
+func (b *BitswapWrapper) GetBlocks(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+	if b.mode == ModeServerOnly {
+		return nil, ErrServerOnly
+	}
+	ch, err := b.Bitswap.GetBlocks(ctx, cids)
+	if err != nil {
+		return nil, err
+	}
+	ch = b.stats.timeFirstBlock(ctx, time.Now(), ch)
+	if b.prefetch == nil {
+		return ch, nil
+	}
+	return b.prefetch.prefetchChan(ctx, ch), nil
+}
+
+// NewSession starts a fetch session; on a server-only node every fetch
+// through it fails with ErrServerOnly
+func (b *BitswapWrapper) NewSession(ctx context.Context) exchange.Fetcher {
+	if b.mode == ModeServerOnly {
+		return serverOnlyFetcher{}
+	}
+	if b.prefetch != nil {
+		return prefetchFetcher{Fetcher: b.Bitswap.NewSession(ctx), p: b.prefetch}
+	}
+	return b.Bitswap.NewSession(ctx)
+}
+
+type serverOnlyFetcher struct{}
+
+func (serverOnlyFetcher) GetBlock(context.Context, cid.Cid) (blocks.Block, error) {
+	return nil, ErrServerOnly
+}
+
+func (serverOnlyFetcher) GetBlocks(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+	return nil, ErrServerOnly
+}
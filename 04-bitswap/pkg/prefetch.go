@@ -0,0 +1,237 @@
+package bitswap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/boxo/exchange"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultPrefetchDepth is how many link levels below a fetched block
+	// are requested ahead of time
+	DefaultPrefetchDepth = 2
+	// DefaultPrefetchBudget caps the blocks requested ahead of time for a
+	// single fetched block
+	DefaultPrefetchBudget = 128
+)
+
+// PrefetchConfig enables speculative fetching: once a dag-pb block (e.g. a
+// UnixFS file or directory node) arrives from the network, its children are
+// requested in the background and stored locally, so walking the DAG does
+// not pay a network round trip per block. Other codecs are not followed.
+type PrefetchConfig struct {
+	Depth  int // link levels below the fetched block (default 2)
+	Budget int // blocks requested ahead per fetched block (default 128)
+}
+
+// PrefetchStats counts the speculative fetches
+type PrefetchStats struct {
+	Requested int64 // children asked for ahead of time
+	Fetched   int64 // of those, blocks that arrived and were stored
+	InFlight  int64 // children claimed by a walk that is still waiting for them
+}
+
+type prefetcher struct {
+	b      *BitswapWrapper
+	depth  int
+	budget int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	inflight map[cid.Cid]struct{}
+
+	requested atomic.Int64
+	fetched   atomic.Int64
+}
+
+func newPrefetcher(b *BitswapWrapper, cfg *PrefetchConfig) *prefetcher {
+	p := &prefetcher{
+		b:        b,
+		depth:    cfg.Depth,
+		budget:   cfg.Budget,
+		inflight: make(map[cid.Cid]struct{}),
+	}
+	if p.depth <= 0 {
+		p.depth = DefaultPrefetchDepth
+	}
+	if p.budget <= 0 {
+		p.budget = DefaultPrefetchBudget
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+// links returns the children of a dag-pb block
+func links(blk blocks.Block) []cid.Cid {
+	if blk.Cid().Prefix().Codec != cid.DagProtobuf {
+		return nil
+	}
+	nd, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return nil
+	}
+	out := make([]cid.Cid, 0, len(nd.Links()))
+	for _, l := range nd.Links() {
+		out = append(out, l.Cid)
+	}
+	return out
+}
+
+// claim filters cs down to children that are neither stored nor already
+// being prefetched, up to max
+func (p *prefetcher) claim(cs []cid.Cid, max int) []cid.Cid {
+	var out []cid.Cid
+	for _, c := range cs {
+		if len(out) >= max {
+			break
+		}
+		if has, err := p.b.PersistentWrapper.Has(p.ctx, c); err == nil && has {
+			continue
+		}
+		p.mu.Lock()
+		_, busy := p.inflight[c]
+		if !busy {
+			p.inflight[c] = struct{}{}
+		}
+		p.mu.Unlock()
+		if !busy {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (p *prefetcher) release(cs []cid.Cid) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range cs {
+		delete(p.inflight, c)
+	}
+}
+
+// onBlock starts prefetching below blk, a block handed to the caller
+func (p *prefetcher) onBlock(blk blocks.Block) {
+	children := links(blk)
+	if len(children) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.walk(children)
+	}()
+}
+
+// walk fetches the tree below a block level by level until the depth or
+// the budget runs out
+func (p *prefetcher) walk(level []cid.Cid) {
+	budget := p.budget
+	for depth := 0; depth < p.depth && budget > 0 && len(level) > 0; depth++ {
+		want := p.claim(level, budget)
+		if len(want) == 0 {
+			return
+		}
+		budget -= len(want)
+		p.requested.Add(int64(len(want)))
+
+		// Children no peer has would otherwise stay wanted, and claimed,
+		// until Close; whatever has not arrived in time is given up
+		ctx, cancel := context.WithTimeout(p.ctx, p.b.retry.Timeout)
+		ch, err := p.b.Bitswap.GetBlocks(ctx, want)
+		if err != nil {
+			cancel()
+			p.release(want)
+			return
+		}
+		var next []cid.Cid
+		for blk := range ch {
+			if err := p.b.PersistentWrapper.Put(p.ctx, blk); err != nil {
+				log.Debug().Err(err).Str("cid", blk.Cid().String()).Msg("prefetch store failed")
+				continue
+			}
+			p.fetched.Add(1)
+			next = append(next, links(blk)...)
+		}
+		cancel()
+		p.release(want)
+		level = next
+	}
+}
+
+func (p *prefetcher) close() {
+	p.mu.Lock()
+	p.cancel()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// PrefetchStats reports the speculative fetches so far; zero unless
+// BitswapConfig.Prefetch is set
+func (b *BitswapWrapper) PrefetchStats() PrefetchStats {
+	if b.prefetch == nil {
+		return PrefetchStats{}
+	}
+	b.prefetch.mu.Lock()
+	inflight := len(b.prefetch.inflight)
+	b.prefetch.mu.Unlock()
+	return PrefetchStats{
+		Requested: b.prefetch.requested.Load(),
+		Fetched:   b.prefetch.fetched.Load(),
+		InFlight:  int64(inflight),
+	}
+}
+
+// prefetchChan forwards blocks from in and starts prefetching below each
+func (p *prefetcher) prefetchChan(ctx context.Context, in <-chan blocks.Block) <-chan blocks.Block {
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for blk := range in {
+			p.onBlock(blk)
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// prefetchFetcher is a session that prefetches below what it fetches
+type prefetchFetcher struct {
+	exchange.Fetcher
+	p *prefetcher
+}
+
+func (f prefetchFetcher) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := f.Fetcher.GetBlock(ctx, c)
+	if err == nil {
+		f.p.onBlock(blk)
+	}
+	return blk, err
+}
+
+func (f prefetchFetcher) GetBlocks(ctx context.Context, cs []cid.Cid) (<-chan blocks.Block, error) {
+	ch, err := f.Fetcher.GetBlocks(ctx, cs)
+	if err != nil {
+		return nil, err
+	}
+	return f.p.prefetchChan(ctx, ch), nil
+}
@@ -0,0 +1,93 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultRequestTimeout bounds a single attempt to fetch a block
+	DefaultRequestTimeout = 5 * time.Second
+	// DefaultRequestAttempts is how often a fetch is tried before giving up
+	DefaultRequestAttempts = 3
+	// DefaultRetryBackoff is the pause after the first failed attempt; it
+	// doubles after each further one
+	DefaultRetryBackoff = 250 * time.Millisecond
+	// DefaultMaxRetryBackoff caps the pause between attempts
+	DefaultMaxRetryBackoff = 2 * time.Second
+)
+
+// ErrBlockNotFound is returned when every attempt to fetch a block timed
+// out, i.e. no reachable peer sent it. Callers with other ways to get the
+// block (HTTP, GraphSync) can fail over on it.
+var ErrBlockNotFound = errors.New("bitswap: block not found")
+
+// RetryConfig bounds how long a fetch may take. Without it a fetch for a
+// block nobody has waits until the caller's context ends. Zero values keep
+// the defaults noted on each field.
+type RetryConfig struct {
+	Timeout    time.Duration // per attempt (default 5s)
+	Attempts   int           // attempts before ErrBlockNotFound (default 3)
+	Backoff    time.Duration // pause after the first failure, doubled after each (default 250ms)
+	MaxBackoff time.Duration // cap on the pause (default 2s)
+}
+
+func (c *RetryConfig) withDefaults() RetryConfig {
+	var out RetryConfig
+	if c != nil {
+		out = *c
+	}
+	if out.Timeout <= 0 {
+		out.Timeout = DefaultRequestTimeout
+	}
+	if out.Attempts <= 0 {
+		out.Attempts = DefaultRequestAttempts
+	}
+	if out.Backoff <= 0 {
+		out.Backoff = DefaultRetryBackoff
+	}
+	if out.MaxBackoff <= 0 {
+		out.MaxBackoff = DefaultMaxRetryBackoff
+	}
+	return out
+}
+
+// fetchWithRetry runs fetch with a timeout per attempt and a growing pause
+// between attempts. Each attempt sends a fresh want, so newly connected
+// peers and providers found since the last one get a chance. The caller's
+// context ending stops it with that context's error; running out of
+// attempts yields ErrBlockNotFound.
+func (b *BitswapWrapper) fetchWithRetry(ctx context.Context, c cid.Cid, fetch func(context.Context) (blocks.Block, error)) (blocks.Block, error) {
+	backoff := b.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, b.retry.Timeout)
+		blk, err := fetch(actx)
+		cancel()
+		if err == nil {
+			return blk, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("get block %s: %w", c, ctx.Err())
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		if attempt == b.retry.Attempts {
+			return nil, fmt.Errorf("%w: %s after %d attempts", ErrBlockNotFound, c, attempt)
+		}
+		log.Debug().Str("cid", c.String()).Int("attempt", attempt).Dur("backoff", backoff).Msg("block fetch timed out, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("get block %s: %w", c, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, b.retry.MaxBackoff)
+	}
+}
@@ -0,0 +1,315 @@
+package bitswap
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultBanThreshold is the score at which a peer gets banned
+	DefaultBanThreshold = -100
+	// DefaultBanDuration is how long a ban lasts
+	DefaultBanDuration = 10 * time.Minute
+	// maxScore caps the reward for useful blocks, so a long good history
+	// cannot hide a burst of junk
+	maxScore = 100
+	// duplicateWindow is how long a received block counts as recent when
+	// deciding whether another copy of it is a duplicate
+	duplicateWindow = time.Minute
+	// scoreTag is the connection manager tag holding a peer's score
+	scoreTag = "bitswap-score"
+)
+
+// ScoreConfig sets how peers are judged by the blocks they send. The
+// penalties are subtracted from the peer's score; zero values keep the
+// defaults noted on each field.
+type ScoreConfig struct {
+	BanThreshold int           // score at or below which the peer is banned (default -100)
+	BanDuration  time.Duration // how long a ban lasts (default 10m)
+
+	UsefulReward       int // a block we wanted, first copy (default 1)
+	DuplicatePenalty   int // a block another peer already sent us (default 1)
+	UnsolicitedPenalty int // a block nobody asked for (default 5)
+	CorruptPenalty     int // a dag-pb block that does not decode (default 50)
+}
+
+func (c *ScoreConfig) withDefaults() ScoreConfig {
+	out := *c
+	if out.BanThreshold >= 0 {
+		out.BanThreshold = DefaultBanThreshold
+	}
+	if out.BanDuration <= 0 {
+		out.BanDuration = DefaultBanDuration
+	}
+	if out.UsefulReward <= 0 {
+		out.UsefulReward = 1
+	}
+	if out.DuplicatePenalty <= 0 {
+		out.DuplicatePenalty = 1
+	}
+	if out.UnsolicitedPenalty <= 0 {
+		out.UnsolicitedPenalty = 5
+	}
+	if out.CorruptPenalty <= 0 {
+		out.CorruptPenalty = 50
+	}
+	return out
+}
+
+// PeerScore is what a peer's blocks have earned it so far
+type PeerScore struct {
+	Peer  peer.ID
+	Score int // higher is better; new peers start at 0
+
+	Useful      uint64 // blocks we wanted
+	Duplicate   uint64 // copies of blocks we already had
+	Unsolicited uint64 // blocks nobody asked for
+	Corrupt     uint64 // blocks that do not decode
+
+	BannedUntil time.Time // zero unless banned
+}
+
+// Banned reports whether the peer is banned right now
+func (s PeerScore) Banned() bool {
+	return time.Now().Before(s.BannedUntil)
+}
+
+// scorer watches incoming bitswap messages and bans peers whose score
+// drops too low. Banned peers are denied by the connection gater and
+// never served. The score is also tagged on the connection manager, so
+// low scoring peers are the first connections trimmed.
+type scorer struct {
+	b   *BitswapWrapper
+	cfg ScoreConfig
+
+	mu     sync.Mutex
+	peers  map[peer.ID]*PeerScore
+	recent map[cid.Cid]time.Time // blocks received lately, for duplicates
+	timers map[peer.ID]*time.Timer
+	closed bool
+}
+
+func newScorer(cfg *ScoreConfig) *scorer {
+	return &scorer{
+		cfg:    cfg.withDefaults(),
+		peers:  make(map[peer.ID]*PeerScore),
+		recent: make(map[cid.Cid]time.Time),
+		timers: make(map[peer.ID]*time.Timer),
+	}
+}
+
+// corrupt reports whether blk claims to be dag-pb but does not decode.
+// The hash always matches: bitswap derives the CID from the data.
+func corrupt(blk blocks.Block) bool {
+	if blk.Cid().Prefix().Codec != cid.DagProtobuf {
+		return false
+	}
+	_, err := merkledag.DecodeProtobuf(blk.RawData())
+	return err != nil
+}
+
+// MessageReceived scores the blocks in a message before bitswap handles it
+func (s *scorer) MessageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	blks := msg.Blocks()
+	if len(blks) == 0 {
+		return
+	}
+	s.mu.Lock()
+	b := s.b
+	s.mu.Unlock()
+	if b == nil {
+		return
+	}
+	wanted := make(map[cid.Cid]struct{})
+	if b.mode != ModeServerOnly {
+		for _, c := range b.Bitswap.GetWantlist() {
+			wanted[c] = struct{}{}
+		}
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, t := range s.recent {
+		if now.Sub(t) > duplicateWindow {
+			delete(s.recent, c)
+		}
+	}
+
+	ps := s.score(p)
+	for _, blk := range blks {
+		c := blk.Cid()
+		_, isWanted := wanted[c]
+		_, isRecent := s.recent[c]
+		switch {
+		case corrupt(blk):
+			ps.Corrupt++
+			ps.Score -= s.cfg.CorruptPenalty
+		case isRecent:
+			ps.Duplicate++
+			ps.Score -= s.cfg.DuplicatePenalty
+		case isWanted:
+			ps.Useful++
+			ps.Score = min(ps.Score+s.cfg.UsefulReward, maxScore)
+			s.recent[c] = now
+		default:
+			ps.Unsolicited++
+			ps.Score -= s.cfg.UnsolicitedPenalty
+		}
+	}
+	b.HostWrapper.ConnManager().TagPeer(p, scoreTag, ps.Score)
+	if ps.Score <= s.cfg.BanThreshold && !ps.Banned() {
+		log.Warn().Str("peer", p.String()).Int("score", ps.Score).Msg("banning bitswap peer")
+		s.ban(p, s.cfg.BanDuration)
+	}
+}
+
+func (s *scorer) MessageSent(peer.ID, bsmsg.BitSwapMessage) {}
+
+// score returns the entry for p, creating it; s.mu must be held
+func (s *scorer) score(p peer.ID) *PeerScore {
+	ps, ok := s.peers[p]
+	if !ok {
+		ps = &PeerScore{Peer: p}
+		s.peers[p] = ps
+	}
+	return ps
+}
+
+// ban denies p for d and lifts the ban afterwards; s.mu must be held
+func (s *scorer) ban(p peer.ID, d time.Duration) {
+	if s.closed {
+		return
+	}
+	s.score(p).BannedUntil = time.Now().Add(d)
+	if err := s.b.HostWrapper.BlockPeer(p); err != nil {
+		log.Debug().Err(err).Str("peer", p.String()).Msg("closing banned peer")
+	}
+	if t, ok := s.timers[p]; ok {
+		t.Stop()
+	}
+	s.timers[p] = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.closed {
+			s.unban(p)
+		}
+	})
+}
+
+// unban lifts a ban and gives p a fresh start; s.mu must be held
+func (s *scorer) unban(p peer.ID) {
+	if t, ok := s.timers[p]; ok {
+		t.Stop()
+		delete(s.timers, p)
+	}
+	if ps, ok := s.peers[p]; ok && !ps.BannedUntil.IsZero() {
+		ps.BannedUntil = time.Time{}
+		ps.Score = 0
+		s.b.HostWrapper.ConnManager().UntagPeer(p, scoreTag)
+		s.b.HostWrapper.UnblockPeer(p)
+	}
+}
+
+func (s *scorer) get(p peer.ID) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.peers[p]
+	if !ok {
+		return 0, false
+	}
+	return ps.Score, ps.Banned()
+}
+
+// allowServe is the server's request filter: banned peers get nothing
+func (s *scorer) allowServe(p peer.ID, _ cid.Cid) bool {
+	_, banned := s.get(p)
+	return !banned
+}
+
+func (s *scorer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, t := range s.timers {
+		t.Stop()
+	}
+}
+
+// Score reports what p's blocks have earned it; ok is false if scoring
+// is disabled or p never sent us a block
+func (b *BitswapWrapper) Score(p peer.ID) (PeerScore, bool) {
+	if b.scorer == nil {
+		return PeerScore{}, false
+	}
+	b.scorer.mu.Lock()
+	defer b.scorer.mu.Unlock()
+	ps, ok := b.scorer.peers[p]
+	if !ok {
+		return PeerScore{Peer: p}, false
+	}
+	return *ps, true
+}
+
+// Scores lists every scored peer, lowest score first
+func (b *BitswapWrapper) Scores() []PeerScore {
+	if b.scorer == nil {
+		return nil
+	}
+	b.scorer.mu.Lock()
+	out := make([]PeerScore, 0, len(b.scorer.peers))
+	for _, ps := range b.scorer.peers {
+		out = append(out, *ps)
+	}
+	b.scorer.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score < out[j].Score
+		}
+		return out[i].Peer < out[j].Peer
+	})
+	return out
+}
+
+// BannedPeers lists the peers banned right now
+func (b *BitswapWrapper) BannedPeers() []peer.ID {
+	var out []peer.ID
+	for _, ps := range b.Scores() {
+		if ps.Banned() {
+			out = append(out, ps.Peer)
+		}
+	}
+	return out
+}
+
+// Ban disconnects p and refuses it for d, whatever its score; zero d uses
+// ScoreConfig.BanDuration. It is a no-op if scoring is disabled.
+func (b *BitswapWrapper) Ban(p peer.ID, d time.Duration) {
+	if b.scorer == nil {
+		return
+	}
+	if d <= 0 {
+		d = b.scorer.cfg.BanDuration
+	}
+	b.scorer.mu.Lock()
+	defer b.scorer.mu.Unlock()
+	b.scorer.ban(p, d)
+}
+
+// Unban lifts a ban early and resets p's score
+func (b *BitswapWrapper) Unban(p peer.ID) {
+	if b.scorer == nil {
+		return
+	}
+	b.scorer.mu.Lock()
+	defer b.scorer.mu.Unlock()
+	b.scorer.unban(p)
+}
@@ -0,0 +1,175 @@
+package bitswap
+
+import (
+	"context"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	bnet "github.com/ipfs/boxo/bitswap/network"
+	"github.com/ipfs/boxo/bitswap/tracer"
+	blocks "github.com/ipfs/go-block-format"
+	flow "github.com/libp2p/go-flow-metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
+)
+
+var (
+	// firstBlockBucketsMs cover a local hit up to a fetch that waits for
+	// provider search
+	firstBlockBucketsMs = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+	// wantlistBuckets count the entries of an outgoing wantlist message
+	wantlistBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}
+)
+
+// BitswapStats is what the node has exchanged so far. It is also served
+// as the "bitswap" protocol on the metrics endpoint.
+type BitswapStats struct {
+	BlocksReceived    uint64 `json:"blocks_received"`
+	DataReceived      uint64 `json:"data_received"`
+	DupBlocksReceived uint64 `json:"dup_blocks_received"` // blocks that arrived after we already had them
+	DupDataReceived   uint64 `json:"dup_data_received"`
+	BlocksSent        uint64 `json:"blocks_sent"`
+	DataSent          uint64 `json:"data_sent"`
+	MessagesReceived  uint64 `json:"messages_received"`
+	Peers             int    `json:"peers"`
+
+	WantBlocks int `json:"want_blocks"` // current wantlist
+	WantHaves  int `json:"want_haves"`
+
+	// Block data in and out with its current rate
+	Throughput metrics.BandwidthTotals `json:"throughput"`
+	// Entries per wantlist message sent to a peer
+	WantlistSize metrics.HistogramSnapshot `json:"wantlist_size"`
+	// From asking for blocks to receiving the first one
+	TimeToFirstBlock metrics.HistogramSnapshot `json:"time_to_first_block_ms"`
+}
+
+// bitswapStats keeps the counters bitswap itself does not: block
+// throughput, wantlist sizes and want latency
+type bitswapStats struct {
+	in, out    *flow.Meter
+	wantlist   *metrics.Histogram
+	firstBlock *metrics.Histogram
+}
+
+func newBitswapStats() *bitswapStats {
+	return &bitswapStats{
+		in:         flow.NewMeter(),
+		out:        flow.NewMeter(),
+		wantlist:   metrics.NewHistogram(wantlistBuckets),
+		firstBlock: metrics.NewHistogram(firstBlockBucketsMs),
+	}
+}
+
+func blockBytes(msg bsmsg.BitSwapMessage) uint64 {
+	var n uint64
+	for _, blk := range msg.Blocks() {
+		n += uint64(len(blk.RawData()))
+	}
+	return n
+}
+
+func (s *bitswapStats) MessageReceived(_ peer.ID, msg bsmsg.BitSwapMessage) {
+	if n := blockBytes(msg); n > 0 {
+		s.in.Mark(n)
+	}
+}
+
+// MessageSent only sees what the server sends, i.e. blocks and presences
+func (s *bitswapStats) MessageSent(_ peer.ID, msg bsmsg.BitSwapMessage) {
+	if n := blockBytes(msg); n > 0 {
+		s.out.Mark(n)
+	}
+}
+
+// statsNetwork measures the wantlists the client sends, which the tracer
+// does not see
+type statsNetwork struct {
+	bnet.BitSwapNetwork
+	stats *bitswapStats
+}
+
+func (n statsNetwork) NewMessageSender(ctx context.Context, p peer.ID, opts *bnet.MessageSenderOpts) (bnet.MessageSender, error) {
+	ms, err := n.BitSwapNetwork.NewMessageSender(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return statsSender{MessageSender: ms, stats: n.stats}, nil
+}
+
+type statsSender struct {
+	bnet.MessageSender
+	stats *bitswapStats
+}
+
+func (s statsSender) SendMsg(ctx context.Context, msg bsmsg.BitSwapMessage) error {
+	err := s.MessageSender.SendMsg(ctx, msg)
+	if n := len(msg.Wantlist()); err == nil && n > 0 {
+		s.stats.wantlist.Observe(float64(n))
+	}
+	return err
+}
+
+// timeFirstBlock forwards in and records when the first block arrives
+func (s *bitswapStats) timeFirstBlock(ctx context.Context, start time.Time, in <-chan blocks.Block) <-chan blocks.Block {
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		first := true
+		for blk := range in {
+			if first {
+				s.firstBlock.ObserveDuration(time.Since(start))
+				first = false
+			}
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// multiTracer hands every message to each tracer in turn
+type multiTracer []tracer.Tracer
+
+func (m multiTracer) MessageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, t := range m {
+		t.MessageReceived(p, msg)
+	}
+}
+
+func (m multiTracer) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, t := range m {
+		t.MessageSent(p, msg)
+	}
+}
+
+// Stats reports what the node has exchanged so far
+func (b *BitswapWrapper) Stats() BitswapStats {
+	var st BitswapStats
+	if s, _ := b.Bitswap.Stat(); s != nil {
+		st.BlocksReceived, st.DataReceived = s.BlocksReceived, s.DataReceived
+		st.DupBlocksReceived, st.DupDataReceived = s.DupBlksReceived, s.DupDataReceived
+		st.BlocksSent, st.DataSent = s.BlocksSent, s.DataSent
+		st.MessagesReceived = s.MessagesReceived
+		st.Peers = len(s.Peers)
+	}
+	st.WantBlocks = len(b.Bitswap.GetWantBlocks())
+	st.WantHaves = len(b.Bitswap.GetWantHaves())
+
+	in, out := b.stats.in.Snapshot(), b.stats.out.Snapshot()
+	st.Throughput = metrics.BandwidthTotals{
+		TotalIn:  int64(in.Total),
+		TotalOut: int64(out.Total),
+		RateIn:   in.Rate,
+		RateOut:  out.Rate,
+	}
+	st.WantlistSize = b.stats.wantlist.Snapshot()
+	st.TimeToFirstBlock = b.stats.firstBlock.Snapshot()
+	return st
+}
@@ -0,0 +1,280 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultWriteBackWorkers is the number of background writers
+	DefaultWriteBackWorkers = 4
+	// DefaultWriteBackBatch is the most blocks written in one PutMany
+	DefaultWriteBackBatch = 64
+	// DefaultWriteBackQueue is how many blocks may wait to be written
+	// before adds block
+	DefaultWriteBackQueue = 1024
+	// DefaultWriteBackLinger is how long a writer waits to fill a batch
+	DefaultWriteBackLinger = 10 * time.Millisecond
+)
+
+// ErrWriteBackClosed is returned by adds after the BlockService was closed
+var ErrWriteBackClosed = errors.New("blockservice: write-back queue closed")
+
+// WriteBackConfig makes adds return once a block is queued; background
+// workers persist the queue in batches. Queued blocks are served from
+// memory until their batch reaches the blockstore.
+type WriteBackConfig struct {
+	Workers   int           // background writers (default 4)
+	BatchSize int           // blocks per PutMany (default 64)
+	QueueSize int           // queued blocks before adds wait (default 1024)
+	Linger    time.Duration // wait for a batch to fill (default 10ms)
+}
+
+// WriteBackStats counts the work of the write-back queue
+type WriteBackStats struct {
+	Pending int   // queued, not yet persisted
+	Written int64 // blocks persisted
+	Batches int64 // PutMany calls
+	Failed  int64 // blocks whose write failed
+}
+
+// writeBack is a blockstore that acknowledges writes once queued. Queued
+// blocks are served from memory until a worker has persisted them.
+type writeBack struct {
+	blockstore.Blockstore // the backing store
+
+	batch  int
+	linger time.Duration
+	queue  chan blocks.Block
+	wg     sync.WaitGroup
+
+	sendMu sync.RWMutex // held for reading while sending on queue
+	closed bool
+
+	mu      sync.Mutex
+	idle    *sync.Cond // signalled when pending empties
+	pending map[cid.Cid]blocks.Block
+	err     error // first failed write since the last Flush
+
+	written, batches, failed atomic.Int64
+}
+
+func newWriteBack(store blockstore.Blockstore, cfg *WriteBackConfig) *writeBack {
+	workers, batch, size, linger := cfg.Workers, cfg.BatchSize, cfg.QueueSize, cfg.Linger
+	if workers <= 0 {
+		workers = DefaultWriteBackWorkers
+	}
+	if batch <= 0 {
+		batch = DefaultWriteBackBatch
+	}
+	if size <= 0 {
+		size = DefaultWriteBackQueue
+	}
+	if linger <= 0 {
+		linger = DefaultWriteBackLinger
+	}
+	w := &writeBack{
+		Blockstore: store,
+		batch:      batch,
+		linger:     linger,
+		queue:      make(chan blocks.Block, size),
+		pending:    make(map[cid.Cid]blocks.Block),
+	}
+	w.idle = sync.NewCond(&w.mu)
+	w.wg.Add(workers)
+	for range workers {
+		go w.worker()
+	}
+	return w
+}
+
+// worker writes batches until the queue is closed and drained
+func (w *writeBack) worker() {
+	defer w.wg.Done()
+	for blk := range w.queue {
+		batch := []blocks.Block{blk}
+		timer := time.NewTimer(w.linger)
+	fill:
+		for len(batch) < w.batch {
+			select {
+			case blk, ok := <-w.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, blk)
+			case <-timer.C:
+				break fill
+			}
+		}
+		timer.Stop()
+		w.write(batch)
+	}
+}
+
+func (w *writeBack) write(batch []blocks.Block) {
+	err := w.Blockstore.PutMany(context.Background(), batch)
+	w.batches.Add(1)
+	if err != nil {
+		w.failed.Add(int64(len(batch)))
+		log.Error().Err(err).Int("blocks", len(batch)).Msg("write-back batch failed")
+	} else {
+		w.written.Add(int64(len(batch)))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil && w.err == nil {
+		w.err = fmt.Errorf("write-back: %w", err)
+	}
+	for _, blk := range batch {
+		delete(w.pending, blk.Cid())
+	}
+	if len(w.pending) == 0 {
+		w.idle.Broadcast()
+	}
+}
+
+func (w *writeBack) Put(ctx context.Context, blk blocks.Block) error {
+	w.sendMu.RLock()
+	defer w.sendMu.RUnlock()
+	if w.closed {
+		return ErrWriteBackClosed
+	}
+
+	w.mu.Lock()
+	if _, ok := w.pending[blk.Cid()]; ok {
+		w.mu.Unlock()
+		return nil
+	}
+	w.pending[blk.Cid()] = blk
+	w.mu.Unlock()
+
+	select {
+	case w.queue <- blk:
+		return nil
+	case <-ctx.Done():
+		w.mu.Lock()
+		delete(w.pending, blk.Cid())
+		if len(w.pending) == 0 {
+			w.idle.Broadcast()
+		}
+		w.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (w *writeBack) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := w.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writeBack) queued(c cid.Cid) (blocks.Block, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	blk, ok := w.pending[c]
+	return blk, ok
+}
+
+func (w *writeBack) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if blk, ok := w.queued(c); ok {
+		return blk, nil
+	}
+	return w.Blockstore.Get(ctx, c)
+}
+
+func (w *writeBack) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if _, ok := w.queued(c); ok {
+		return true, nil
+	}
+	return w.Blockstore.Has(ctx, c)
+}
+
+func (w *writeBack) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	if blk, ok := w.queued(c); ok {
+		return len(blk.RawData()), nil
+	}
+	return w.Blockstore.GetSize(ctx, c)
+}
+
+// DeleteBlock flushes first, so a queued write cannot bring the block back
+func (w *writeBack) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	if err := w.flush(ctx); err != nil {
+		return err
+	}
+	return w.Blockstore.DeleteBlock(ctx, c)
+}
+
+// AllKeysChan flushes first, so queued blocks are listed too
+func (w *writeBack) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	if err := w.flush(ctx); err != nil {
+		return nil, err
+	}
+	return w.Blockstore.AllKeysChan(ctx)
+}
+
+// flush waits until every block queued so far is persisted and returns
+// the first write error since the last flush
+func (w *writeBack) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.mu.Lock()
+		for len(w.pending) > 0 {
+			w.idle.Wait()
+		}
+		w.mu.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// close stops accepting blocks and returns once the queue is persisted
+func (w *writeBack) close() error {
+	w.sendMu.Lock()
+	if w.closed {
+		w.sendMu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.sendMu.Unlock()
+
+	w.wg.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *writeBack) stats() WriteBackStats {
+	w.mu.Lock()
+	pending := len(w.pending)
+	w.mu.Unlock()
+	return WriteBackStats{
+		Pending: pending,
+		Written: w.written.Load(),
+		Batches: w.batches.Load(),
+		Failed:  w.failed.Load(),
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
@@ -93,7 +103,7 @@ func TestResolvePath_ByName(t *testing.T) {
 	require.NoError(t, err)
 
 	// Resolve "a/b" → leaf
-	gotNode, gotCID, err := w.ResolvePath(ctx, rootCID, "a/b")
+	gotNode, gotCID, _, err := w.ResolvePath(ctx, rootCID, "a/b")
 	require.NoError(t, err)
 	require.Equal(t, leafCID, gotCID)
 	require.Equal(t, leafCID, gotNode.Cid())
@@ -121,7 +131,7 @@ func TestResolvePath_ByIndex(t *testing.T) {
 	rootCID, err := w.PutNode(ctx, root)
 	require.NoError(t, err)
 
-	gotNode, gotCID, err := w.ResolvePath(ctx, rootCID, "0/0")
+	gotNode, gotCID, _, err := w.ResolvePath(ctx, rootCID, "0/0")
 	require.NoError(t, err)
 	require.Equal(t, leafCID, gotCID)
 	require.Equal(t, leafCID, gotNode.Cid())
@@ -156,7 +166,7 @@ func TestResolvePath_MixedNameIndex(t *testing.T) {
 	require.NoError(t, err)
 
 	// "m/0" should pick the first link under mid => leaf1
-	gotNode, gotCID, err := w.ResolvePath(ctx, rootCID, "m/0")
+	gotNode, gotCID, _, err := w.ResolvePath(ctx, rootCID, "m/0")
 	require.NoError(t, err)
 	require.Equal(t, leaf1CID, gotCID)
 	require.Equal(t, leaf1CID, gotNode.Cid())
@@ -173,7 +183,7 @@ func TestResolvePath_EmptyPathReturnsRoot(t *testing.T) {
 	rootCID, err := w.PutNode(ctx, root)
 	require.NoError(t, err)
 
-	gotNode, gotCID, err := w.ResolvePath(ctx, rootCID, "")
+	gotNode, gotCID, _, err := w.ResolvePath(ctx, rootCID, "")
 	require.NoError(t, err)
 	require.Equal(t, rootCID, gotCID)
 	require.Equal(t, rootCID, gotNode.Cid())
@@ -195,10 +205,727 @@ func TestResolvePath_Errors(t *testing.T) {
 	require.NoError(t, err)
 
 	// missing link name
-	_, _, err = w.ResolvePath(ctx, rootCID, "missing")
+	_, _, _, err = w.ResolvePath(ctx, rootCID, "missing")
 	require.Error(t, err, "expected error for missing link name")
 
 	// index out of range
-	_, _, err = w.ResolvePath(ctx, rootCID, "0")
+	_, _, _, err = w.ResolvePath(ctx, rootCID, "0")
 	require.Error(t, err, "expected error for index out of range")
 }
+
+func TestPutAnyWithCodec(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	type doc struct {
+		Name   string   `json:"name"`
+		Tags   []string `json:"tags"`
+		Parent cid.Cid  `json:"parent"`
+	}
+	leaf, err := w.AddRaw(ctx, []byte("parent"))
+	require.NoError(t, err)
+	in := doc{Name: "child", Tags: []string{"a", "b"}, Parent: leaf}
+
+	for _, codec := range []uint64{cid.DagCBOR, cid.DagJSON} {
+		c, err := w.PutAnyWithCodec(ctx, in, codec)
+		require.NoError(t, err)
+		require.Equal(t, codec, c.Type())
+
+		var out doc
+		require.NoError(t, w.GetAny(ctx, c, &out))
+		require.Equal(t, in, out)
+	}
+
+	// the link is a real IPLD link, not a string
+	c, err := w.PutAnyWithCodec(ctx, in, cid.DagCBOR)
+	require.NoError(t, err)
+	blk, err := w.BlockServiceWrapper.GetBlock(ctx, c)
+	require.NoError(t, err)
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, dagcbor.Decode(nb, bytes.NewReader(blk.RawData())))
+	parent, err := nb.Build().LookupByString("parent")
+	require.NoError(t, err)
+	require.Equal(t, datamodel.Kind_Link, parent.Kind())
+
+	_, err = w.PutAnyWithCodec(ctx, in, cid.DagProtobuf)
+	require.ErrorIs(t, err, dag.ErrUnsupportedCodec)
+}
+
+func TestGetAnyExternalDagCBOR(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	// a block written by another tool
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "title", qp.String("external"))
+		qp.MapEntry(ma, "size", qp.Int(42))
+	})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, dagcbor.Encode(n, &buf))
+	c, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}.Sum(buf.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	require.NoError(t, err)
+	require.NoError(t, w.BlockServiceWrapper.AddBlock(ctx, blk))
+
+	var out map[string]any
+	require.NoError(t, w.GetAny(ctx, c, &out))
+	require.Equal(t, map[string]any{"title": "external", "size": float64(42)}, out)
+
+	// JSON stored by PutAny still reads back
+	legacy, err := w.PutAny(ctx, map[string]any{"v": 1})
+	require.NoError(t, err)
+	require.NoError(t, w.GetAny(ctx, legacy, &out))
+	require.Equal(t, float64(1), out["v"])
+}
+
+func TestPutAnyLinks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	leaf, err := w.AddRaw(ctx, []byte("readme"))
+	require.NoError(t, err)
+	sub, err := w.PutAny(ctx, map[string]any{"files": map[string]cid.Cid{"README": leaf}})
+	require.NoError(t, err)
+	root, err := w.PutAny(ctx, map[string]any{
+		"name": "root",
+		"dirs": []any{sub},
+		"src":  map[string]string{"/": sub.String()}, // dag-json form
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(cid.DagCBOR), root.Type())
+
+	n, err := w.GetNode(ctx, root)
+	require.NoError(t, err)
+	require.Len(t, n.Links(), 2)
+
+	_, got, _, err := w.ResolvePath(ctx, root, "dirs/0/files/README")
+	require.NoError(t, err)
+	require.Equal(t, leaf, got)
+	_, got, _, err = w.ResolvePath(ctx, root, "src")
+	require.NoError(t, err)
+	require.Equal(t, sub, got)
+
+	_, _, _, err = w.ResolvePath(ctx, root, "missing")
+	require.Error(t, err)
+}
+
+func TestResolvePath_Table(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	leaf, err := w.AddRaw(ctx, []byte("readme"))
+	require.NoError(t, err)
+	sub, err := w.PutAny(ctx, map[string]any{
+		"files": map[string]cid.Cid{"README": leaf, "a/b.txt": leaf},
+		"meta":  map[string]any{"size": 6},
+	})
+	require.NoError(t, err)
+	root, err := w.PutAny(ctx, map[string]any{
+		"name": "root",
+		"dirs": []any{sub, map[string]any{"inline": sub}},
+		"tags": []string{"x", "y"},
+	})
+	require.NoError(t, err)
+
+	// a dag-pb directory linking to the dag-cbor root
+	rootNode, err := w.GetNode(ctx, root)
+	require.NoError(t, err)
+	pb := merkledag.NodeWithData(nil)
+	addNamedLink(t, pb, "doc", rootNode)
+	pbCID, err := w.PutNode(ctx, pb)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		root    cid.Cid
+		path    string
+		want    cid.Cid
+		rest    []string
+		wantErr bool
+	}{
+		{name: "empty", root: root, path: "", want: root},
+		{name: "list index", root: root, path: "dirs/0", want: sub},
+		{name: "two blocks", root: root, path: "dirs/0/files/README", want: leaf},
+		{name: "map in list", root: root, path: "dirs/1/inline/files/README", want: leaf},
+		{name: "escaped slash", root: root, path: "dirs/0/files/a%2Fb.txt", want: leaf},
+		{name: "empty segments", root: root, path: "/dirs//0/", want: sub},
+		{name: "dag-pb to dag-cbor", root: pbCID, path: "doc/dirs/0", want: sub},
+		{name: "scalar leaf", root: root, path: "name", want: root, rest: []string{"name"}},
+		{name: "list item leaf", root: root, path: "tags/1", want: root, rest: []string{"tags", "1"}},
+		{name: "leaf in next block", root: root, path: "dirs/0/meta/size", want: sub, rest: []string{"meta", "size"}},
+		{name: "map leaf", root: root, path: "dirs/0/meta", want: sub, rest: []string{"meta"}},
+		{name: "missing key", root: root, path: "missing", wantErr: true},
+		{name: "index out of range", root: root, path: "dirs/2", wantErr: true},
+		{name: "index not a number", root: root, path: "dirs/x", wantErr: true},
+		{name: "past a scalar", root: root, path: "name/x", wantErr: true},
+		{name: "unescaped slash", root: root, path: "dirs/0/files/a/b.txt", wantErr: true},
+		{name: "bad escape", root: root, path: "dirs/%zz", wantErr: true},
+		{name: "missing dag-pb link", root: pbCID, path: "nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nd, got, rest, err := w.ResolvePath(ctx, tt.root, tt.path)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.want, nd.Cid())
+			require.Equal(t, tt.rest, rest)
+		})
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	// how older versions of PutAny stored linked data
+	leaf, err := w.AddRaw(ctx, []byte("leaf data"))
+	require.NoError(t, err)
+	child, err := w.AddRaw(ctx, []byte(`{"name":"child","leaf":"`+leaf.String()+`"}`))
+	require.NoError(t, err)
+	root, err := w.AddRaw(ctx, []byte(`{"name":"root","count":3,"children":["`+child.String()+`"],"also":{"/":"`+child.String()+`"}}`))
+	require.NoError(t, err)
+
+	migrated, err := w.MigrateLegacy(ctx, root)
+	require.NoError(t, err)
+	require.Equal(t, uint64(cid.DagCBOR), migrated.Type())
+
+	_, got, _, err := w.ResolvePath(ctx, migrated, "children/0/leaf")
+	require.NoError(t, err)
+	require.Equal(t, leaf, got, "plain raw blocks keep their cid")
+
+	var out struct {
+		Name     string    `json:"name"`
+		Count    int       `json:"count"`
+		Children []cid.Cid `json:"children"`
+		Also     cid.Cid   `json:"also"`
+	}
+	require.NoError(t, w.GetAny(ctx, migrated, &out))
+	require.Equal(t, "root", out.Name)
+	require.Equal(t, 3, out.Count)
+	require.Equal(t, uint64(cid.DagCBOR), out.Children[0].Type())
+	require.Equal(t, out.Children[0], out.Also, "a shared child is migrated once")
+}
+
+func TestDagStat(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	// root → {a, b}; a and b both link the same shared leaf
+	shared := newLeaf("shared leaf data")
+	_, err = w.PutNode(ctx, shared)
+	require.NoError(t, err)
+	a := merkledag.NodeWithData([]byte("a"))
+	addNamedLink(t, a, "leaf", shared)
+	_, err = w.PutNode(ctx, a)
+	require.NoError(t, err)
+	b, err := w.PutAny(ctx, map[string]any{"leaf": shared.Cid()})
+	require.NoError(t, err)
+	bNode, err := w.GetNode(ctx, b)
+	require.NoError(t, err)
+	root := merkledag.NodeWithData(nil)
+	addNamedLink(t, root, "a", a)
+	addNamedLink(t, root, "b", bNode)
+	rootCID, err := w.PutNode(ctx, root)
+	require.NoError(t, err)
+
+	st, err := w.Stat(ctx, rootCID)
+	require.NoError(t, err)
+	require.Equal(t, 4, st.Blocks)
+	require.Equal(t, 4, st.Links)
+	require.Equal(t, 2, st.MaxDepth)
+	require.Equal(t, map[string]int{"dag-pb": 2, "dag-cbor": 1, "raw": 1}, st.Codecs)
+
+	leafSize := uint64(len(shared.RawData()))
+	require.EqualValues(t, 5, st.ExpandedBlocks)
+	require.Equal(t, leafSize, st.DedupSavings)
+	require.Equal(t, st.Bytes+leafSize, st.ExpandedBytes)
+	require.Greater(t, st.DedupRatio(), 1.0)
+
+	// a single block
+	st, err = w.Stat(ctx, shared.Cid())
+	require.NoError(t, err)
+	require.Equal(t, 1, st.Blocks)
+	require.Zero(t, st.MaxDepth)
+	require.Zero(t, st.DedupSavings)
+	require.Equal(t, 1.0, st.DedupRatio())
+}
+
+func TestSharedBlocks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	size := func(c cid.Cid) uint64 {
+		raw, err := w.GetRaw(ctx, c)
+		require.NoError(t, err)
+		return uint64(len(raw))
+	}
+	raw := func(s string) cid.Cid {
+		c, err := w.AddRaw(ctx, []byte(s))
+		require.NoError(t, err)
+		return c
+	}
+
+	// v1: root → {docs → {a, b}, readme}; v2 changes b only
+	a, b, readme := raw("file a"), raw("file b"), raw("readme")
+	docs1, err := w.PutAny(ctx, map[string]any{"a": a, "b": b})
+	require.NoError(t, err)
+	v1, err := w.PutAny(ctx, map[string]any{"docs": docs1, "readme": readme})
+	require.NoError(t, err)
+	v2, err := w.Patch(ctx, v1, []dag.PatchOp{{Op: dag.PatchReplace, Path: "docs/b", Value: raw("file b, edited")}})
+	require.NoError(t, err)
+	_, b2, _, err := w.ResolvePath(ctx, v2, "docs/b")
+	require.NoError(t, err)
+	_, docs2, _, err := w.ResolvePath(ctx, v2, "docs")
+	require.NoError(t, err)
+
+	ov, err := w.SharedBlocks(ctx, v1, v2)
+	require.NoError(t, err)
+	require.Equal(t, map[cid.Cid]struct{}{a: {}, readme: {}}, ov.Shared)
+	require.Equal(t, size(a)+size(readme), ov.SharedBytes)
+	require.Equal(t, 3, ov.OnlyA)
+	require.Equal(t, size(v1)+size(docs1)+size(b), ov.OnlyABytes)
+	require.Equal(t, 3, ov.OnlyB)
+	require.Equal(t, size(v2)+size(docs2)+size(b2), ov.OnlyBBytes)
+
+	// a subtree of the other DAG is entirely shared
+	ov, err = w.SharedBlocks(ctx, v1, docs1)
+	require.NoError(t, err)
+	require.Len(t, ov.Shared, 3)
+	require.Zero(t, ov.OnlyB)
+	require.Equal(t, 2, ov.OnlyA)
+
+	ov, err = w.SharedBlocks(ctx, v1, v1)
+	require.NoError(t, err)
+	require.Len(t, ov.Shared, 5)
+	require.Zero(t, ov.OnlyA+ov.OnlyB)
+}
+
+func TestPutStruct(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	type author struct {
+		Name string `ipld:"name"`
+	}
+	type chapter struct {
+		Title string  `ipld:"title"`
+		Body  cid.Cid `ipld:"body"`
+	}
+	type book struct {
+		Title    string             `ipld:"title"`
+		Year     uint16             `ipld:"year"`
+		Rating   float64            `ipld:"rating"`
+		Cover    []byte             `ipld:"cover"`
+		Author   *author            `ipld:"author"`
+		Chapters []chapter          `ipld:"chapters"`
+		Extras   map[string]cid.Cid `ipld:"extras"`
+		Notes    string             `ipld:"notes,omitempty"`
+		Meta     any                `ipld:"meta"`
+		Draft    bool               `ipld:"-"`
+		Untagged int
+	}
+
+	body, err := w.AddRaw(ctx, []byte("chapter one"))
+	require.NoError(t, err)
+	in := book{
+		Title:    "IPLD",
+		Year:     2024,
+		Rating:   4.5,
+		Cover:    []byte{0xff, 0x00, 0x01},
+		Author:   &author{Name: "someone"},
+		Chapters: []chapter{{Title: "one", Body: body}},
+		Extras:   map[string]cid.Cid{"appendix": body},
+		Meta:     map[string]any{"tags": []any{"a"}, "link": body},
+		Draft:    true,
+		Untagged: 7,
+	}
+	c, err := w.PutStruct(ctx, &in)
+	require.NoError(t, err)
+	require.Equal(t, uint64(cid.DagCBOR), c.Type())
+
+	var out book
+	require.NoError(t, w.GetStruct(ctx, c, &out))
+	want := in
+	want.Draft = false
+	want.Meta = map[string]any{"tags": []any{"a"}, "link": body}
+	require.Equal(t, want, out)
+
+	// the stored map uses the tag names and real links
+	_, got, _, err := w.ResolvePath(ctx, c, "chapters/0/body")
+	require.NoError(t, err)
+	require.Equal(t, body, got)
+	var raw map[string]any
+	require.NoError(t, w.GetAny(ctx, c, &raw))
+	require.Contains(t, raw, "Untagged")
+	require.NotContains(t, raw, "notes")
+	require.NotContains(t, raw, "Draft")
+
+	_, err = w.PutStruct(ctx, "not a struct")
+	require.Error(t, err)
+	require.Error(t, w.GetStruct(ctx, c, out))
+}
+
+func TestPatch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	// doc → {meta, sections → [s0, s1]}, sections linked as separate blocks
+	s0, err := w.PutAny(ctx, map[string]any{"title": "one", "body": "first"})
+	require.NoError(t, err)
+	s1, err := w.PutAny(ctx, map[string]any{"title": "two", "body": "second"})
+	require.NoError(t, err)
+	meta, err := w.PutAny(ctx, map[string]any{"author": "a", "rev": 1})
+	require.NoError(t, err)
+	root, err := w.PutAny(ctx, map[string]any{
+		"meta":     meta,
+		"sections": []cid.Cid{s0, s1},
+		"tags":     []string{"x", "y"},
+	})
+	require.NoError(t, err)
+
+	newRoot, err := w.Patch(ctx, root, []dag.PatchOp{
+		{Op: dag.PatchReplace, Path: "sections/1/body", Value: "second, edited"},
+		{Op: dag.PatchAdd, Path: "sections/1/draft", Value: true},
+		{Op: dag.PatchAdd, Path: "tags/0", Value: "w"},
+		{Op: dag.PatchRemove, Path: "tags/2"},
+		{Op: dag.PatchAdd, Path: "tags/-", Value: "z"},
+		{Op: dag.PatchAdd, Path: "appendix", Value: s0},
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, root, newRoot)
+
+	var doc struct {
+		Meta     cid.Cid   `ipld:"meta"`
+		Sections []cid.Cid `ipld:"sections"`
+		Tags     []string  `ipld:"tags"`
+		Appendix cid.Cid   `ipld:"appendix"`
+	}
+	require.NoError(t, w.GetStruct(ctx, newRoot, &doc))
+	require.Equal(t, []string{"w", "x", "z"}, doc.Tags)
+	require.Equal(t, s0, doc.Appendix)
+	// untouched subtrees keep their blocks, the edited one is new
+	require.Equal(t, meta, doc.Meta)
+	require.Equal(t, s0, doc.Sections[0])
+	require.NotEqual(t, s1, doc.Sections[1])
+
+	var sec map[string]any
+	require.NoError(t, w.GetAny(ctx, doc.Sections[1], &sec))
+	require.Equal(t, map[string]any{"title": "two", "body": "second, edited", "draft": true}, sec)
+	// the old version is still there
+	require.NoError(t, w.GetAny(ctx, s1, &sec))
+	require.Equal(t, "second", sec["body"])
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, op := range []dag.PatchOp{
+			{Op: dag.PatchReplace, Path: "missing", Value: 1},
+			{Op: dag.PatchRemove, Path: "tags/5"},
+			{Op: dag.PatchAdd, Path: "tags/x", Value: 1},
+			{Op: dag.PatchAdd, Path: "meta/author/deeper", Value: 1},
+			{Op: dag.PatchRemove, Path: ""},
+		} {
+			_, err := w.Patch(ctx, root, []dag.PatchOp{op})
+			require.ErrorIs(t, err, dag.ErrPatchPath, op)
+		}
+	})
+
+	t.Run("Replace Root", func(t *testing.T) {
+		c, err := w.Patch(ctx, root, []dag.PatchOp{{Op: dag.PatchReplace, Value: map[string]any{"fresh": true}}})
+		require.NoError(t, err)
+		var out map[string]any
+		require.NoError(t, w.GetAny(ctx, c, &out))
+		require.Equal(t, map[string]any{"fresh": true}, out)
+	})
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	// a binary tree of depth 4 whose leaves are shared: 1+2+4+8 nodes
+	// above 2 distinct leaves
+	leaves := []format.Node{newLeaf("left"), newLeaf("right")}
+	for _, l := range leaves {
+		_, err := w.PutNode(ctx, l)
+		require.NoError(t, err)
+	}
+	level := leaves
+	for range 4 {
+		var up []format.Node
+		for i := range max(len(level)*2, 2) {
+			n := merkledag.NodeWithData([]byte{byte(len(level)), byte(i)})
+			addNamedLink(t, n, "l", level[0])
+			addNamedLink(t, n, "r", level[len(level)-1])
+			_, err := w.PutNode(ctx, n)
+			require.NoError(t, err)
+			up = append(up, n)
+		}
+		level = up
+	}
+	top := merkledag.NodeWithData([]byte("top"))
+	addNamedLink(t, top, "l", level[0])
+	addNamedLink(t, top, "r", level[len(level)-1])
+	root, err := w.PutNode(ctx, top)
+	require.NoError(t, err)
+
+	walk := func(opts dag.WalkOptions, visit func(format.Node, int) error) (map[cid.Cid]int, error) {
+		var mu sync.Mutex
+		seen := make(map[cid.Cid]int)
+		err := w.Walk(ctx, root, func(nd format.Node, depth int) error {
+			mu.Lock()
+			seen[nd.Cid()]++
+			mu.Unlock()
+			if visit != nil {
+				return visit(nd, depth)
+			}
+			return nil
+		}, opts)
+		return seen, err
+	}
+
+	t.Run("Each Once", func(t *testing.T) {
+		seen, err := walk(dag.WalkOptions{Concurrency: 4}, nil)
+		require.NoError(t, err)
+		st, err := w.Stat(ctx, root)
+		require.NoError(t, err)
+		require.Len(t, seen, st.Blocks)
+		for c, n := range seen {
+			require.Equal(t, 1, n, c)
+		}
+	})
+
+	t.Run("Max Depth", func(t *testing.T) {
+		var deepest atomic.Int32
+		seen, err := walk(dag.WalkOptions{MaxDepth: 2}, func(_ format.Node, depth int) error {
+			if int32(depth) > deepest.Load() {
+				deepest.Store(int32(depth))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, deepest.Load())
+		require.Len(t, seen, 1+2+2)
+	})
+
+	t.Run("Skip Links", func(t *testing.T) {
+		seen, err := walk(dag.WalkOptions{}, func(nd format.Node, _ int) error {
+			if nd.Cid() == root {
+				return dag.ErrSkipLinks
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, seen, 1)
+	})
+
+	t.Run("Stop On Error", func(t *testing.T) {
+		stop := errors.New("found it")
+		_, err := walk(dag.WalkOptions{}, func(_ format.Node, depth int) error {
+			if depth == 1 {
+				return stop
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, stop)
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err = w.Walk(cctx, root, func(format.Node, int) error { return nil }, dag.WalkOptions{})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+
+	section := func(body string) cid.Cid {
+		c, err := w.PutAny(ctx, map[string]any{"body": body, "rev": 1})
+		require.NoError(t, err)
+		return c
+	}
+	put := func(v map[string]any) cid.Cid {
+		c, err := w.PutAny(ctx, v)
+		require.NoError(t, err)
+		return c
+	}
+	intro := section("intro")
+	base := put(map[string]any{
+		"title": "doc", "status": "draft", "tags": []string{"a"},
+		"intro": intro, "meta": map[string]any{"owner": "x", "rev": 1},
+	})
+
+	// ours edits the title and the linked intro's body; theirs edits the
+	// status, the intro's rev and adds a key
+	ourIntro, err := w.Patch(ctx, intro, []dag.PatchOp{{Op: dag.PatchReplace, Path: "body", Value: "our intro"}})
+	require.NoError(t, err)
+	theirIntro, err := w.Patch(ctx, intro, []dag.PatchOp{{Op: dag.PatchReplace, Path: "rev", Value: 2}})
+	require.NoError(t, err)
+	ours := put(map[string]any{
+		"title": "our doc", "status": "draft", "tags": []string{"a"},
+		"intro": ourIntro, "meta": map[string]any{"owner": "x", "rev": 2},
+	})
+	theirs := put(map[string]any{
+		"title": "doc", "status": "final", "tags": []string{"a"},
+		"intro": theirIntro, "meta": map[string]any{"owner": "y", "rev": 1}, "extra": true,
+	})
+
+	merged, err := w.Merge(ctx, base, ours, theirs, nil)
+	require.NoError(t, err)
+	var out map[string]any
+	require.NoError(t, w.GetAny(ctx, merged, &out))
+	require.Equal(t, "our doc", out["title"])
+	require.Equal(t, "final", out["status"])
+	require.Equal(t, true, out["extra"])
+	require.Equal(t, map[string]any{"owner": "y", "rev": float64(2)}, out["meta"])
+
+	var introOut map[string]any
+	_, introCID, _, err := w.ResolvePath(ctx, merged, "intro")
+	require.NoError(t, err)
+	require.NoError(t, w.GetAny(ctx, introCID, &introOut))
+	require.Equal(t, map[string]any{"body": "our intro", "rev": float64(2)}, introOut)
+
+	t.Run("Conflict", func(t *testing.T) {
+		a := put(map[string]any{"title": "A", "tags": []string{"a", "x"}})
+		b := put(map[string]any{"title": "B", "tags": []string{"a"}})
+		_, err := w.Merge(ctx, base, a, b, nil)
+		require.ErrorIs(t, err, dag.ErrMergeConflict)
+
+		var conflicts []dag.Conflict
+		c, err := w.Merge(ctx, base, a, b, func(c dag.Conflict) (any, error) {
+			conflicts = append(conflicts, c)
+			return dag.PreferTheirs(c)
+		})
+		require.NoError(t, err)
+		require.Len(t, conflicts, 1)
+		require.Equal(t, "/title", conflicts[0].Path)
+		require.Equal(t, "A", conflicts[0].Ours)
+
+		// everything else was deleted by both or changed by one side
+		out := map[string]any{}
+		require.NoError(t, w.GetAny(ctx, c, &out))
+		require.Equal(t, map[string]any{"title": "B", "tags": []any{"a", "x"}}, out)
+	})
+
+	t.Run("Delete vs Edit", func(t *testing.T) {
+		a := put(map[string]any{"title": "doc", "status": "review", "tags": []string{"a"}, "intro": intro, "meta": map[string]any{"owner": "x", "rev": 1}})
+		b := put(map[string]any{"title": "doc", "tags": []string{"a"}, "intro": intro, "meta": map[string]any{"owner": "x", "rev": 1}})
+		c, err := w.Merge(ctx, base, a, b, func(c dag.Conflict) (any, error) {
+			require.True(t, c.InOurs)
+			require.False(t, c.InTheirs)
+			return dag.MergeRemove, nil
+		})
+		require.NoError(t, err)
+		out := map[string]any{}
+		require.NoError(t, w.GetAny(ctx, c, &out))
+		require.NotContains(t, out, "status")
+	})
+}
+
+type recordingPinner struct {
+	mu     sync.Mutex
+	locked int
+	roots  []cid.Cid
+	err    error
+}
+
+func (p *recordingPinner) WriteLock() func() {
+	p.mu.Lock()
+	p.locked++
+	p.mu.Unlock()
+	return func() {
+		p.mu.Lock()
+		p.locked--
+		p.mu.Unlock()
+	}
+}
+
+func (p *recordingPinner) PinRoot(ctx context.Context, c cid.Cid) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.locked == 0 {
+		return errors.New("pinned without the write lock")
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.roots = append(p.roots, c)
+	return nil
+}
+
+func TestPinner(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	p := &recordingPinner{}
+	w.SetPinner(p)
+
+	raw, err := w.AddRaw(ctx, []byte("raw"))
+	require.NoError(t, err)
+	leaf, err := w.PutAny(ctx, map[string]any{"name": "leaf"})
+	require.NoError(t, err)
+	root, err := w.PutAny(ctx, map[string]any{"child": leaf, "raw": raw})
+	require.NoError(t, err)
+	patched, err := w.Patch(ctx, root, []dag.PatchOp{{Op: dag.PatchReplace, Path: "child/name", Value: "new"}})
+	require.NoError(t, err)
+
+	// only the returned roots are pinned, not the blocks Patch rewrote
+	// below the new root
+	require.Equal(t, []cid.Cid{raw, leaf, root, patched}, p.roots)
+	require.Zero(t, p.locked)
+
+	t.Run("pin failure fails the write", func(t *testing.T) {
+		p.err = errors.New("pinner down")
+		_, err := w.PutAny(ctx, map[string]any{"name": "lost"})
+		require.ErrorIs(t, err, p.err)
+		require.Zero(t, p.locked)
+		p.err = nil
+	})
+
+	t.Run("no pinner", func(t *testing.T) {
+		w.SetPinner(nil)
+		_, err := w.PutAny(ctx, map[string]any{"name": "unpinned"})
+		require.NoError(t, err)
+		require.Len(t, p.roots, 4)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -205,9 +206,9 @@ func demonstrateDAGStructures(ctx context.Context) {
 	branch1Data := map[string]interface{}{
 		"type": "branch",
 		"name": "user-branch",
-		"links": map[string]string{
-			"user-data": leafCids[0].String(),
-			"settings":  leafCids[1].String(),
+		"links": map[string]cid.Cid{
+			"user-data": leafCids[0],
+			"settings":  leafCids[1],
 		},
 	}
 	branch1Cid, err := ipld.PutAny(ctx, branch1Data)
@@ -219,9 +220,9 @@ func demonstrateDAGStructures(ctx context.Context) {
 	branch2Data := map[string]interface{}{
 		"type": "branch",
 		"name": "system-branch",
-		"links": map[string]string{
-			"session": leafCids[2].String(),
-			"cache":   leafCids[3].String(),
+		"links": map[string]cid.Cid{
+			"session": leafCids[2],
+			"cache":   leafCids[3],
 		},
 	}
 	branch2Cid, err := ipld.PutAny(ctx, branch2Data)
@@ -236,9 +237,9 @@ func demonstrateDAGStructures(ctx context.Context) {
 	rootData := map[string]interface{}{
 		"type":        "root",
 		"description": "Application state tree",
-		"branches": map[string]string{
-			"user-branch":   branch1Cid.String(),
-			"system-branch": branch2Cid.String(),
+		"branches": map[string]cid.Cid{
+			"user-branch":   branch1Cid,
+			"system-branch": branch2Cid,
 		},
 	}
 	rootCid, err := ipld.PutAny(ctx, rootData)
@@ -249,7 +250,14 @@ func demonstrateDAGStructures(ctx context.Context) {
 
 	fmt.Printf("\n📊 DAG Structure Analysis:\n")
 	fmt.Printf("   Structure: Root → Branches → Leaves\n")
-	fmt.Printf("   Total nodes: %d (1 root + 2 branches + %d leaves)\n", 3+len(leafCids), len(leafCids))
+	st, err := ipld.Stat(ctx, rootCid)
+	if err != nil {
+		fmt.Printf("   ❌ Failed to stat DAG: %v\n", err)
+		return
+	}
+	fmt.Printf("   Total nodes: %d blocks, %d bytes, depth %d\n", st.Blocks, st.Bytes, st.MaxDepth)
+	fmt.Printf("   Codecs: %v\n", st.Codecs)
+	fmt.Printf("   Deduplication: saves %d bytes (%.2fx)\n", st.DedupSavings, st.DedupRatio())
 	fmt.Printf("   Properties:\n")
 	fmt.Printf("     • Directed: Links point from parent to child\n")
 	fmt.Printf("     • Acyclic: No circular references possible\n")
@@ -258,20 +266,22 @@ func demonstrateDAGStructures(ctx context.Context) {
 
 	// Verify structure by retrieving
 	fmt.Printf("\n🔍 Verifying DAG structure:\n")
-	var rootRetrieved map[string]interface{}
+	var rootRetrieved struct {
+		Type        string             `json:"type"`
+		Description string             `json:"description"`
+		Branches    map[string]cid.Cid `json:"branches"`
+	}
 	err = ipld.GetAny(ctx, rootCid, &rootRetrieved)
 	if err != nil {
 		fmt.Printf("   ❌ Failed to retrieve root: %v\n", err)
 		return
 	}
 
-	fmt.Printf("   ✅ Root type: %v\n", rootRetrieved["type"])
-	fmt.Printf("   ✅ Root description: %v\n", rootRetrieved["description"])
-	if branches, ok := rootRetrieved["branches"].(map[string]interface{}); ok {
-		fmt.Printf("   🔗 Root links: %d branches\n", len(branches))
-		for name, cidStr := range branches {
-			fmt.Printf("      - %s → %s\n", name, cidStr.(string)[:20]+"...")
-		}
+	fmt.Printf("   ✅ Root type: %v\n", rootRetrieved.Type)
+	fmt.Printf("   ✅ Root description: %v\n", rootRetrieved.Description)
+	fmt.Printf("   🔗 Root links: %d branches\n", len(rootRetrieved.Branches))
+	for name, c := range rootRetrieved.Branches {
+		fmt.Printf("      - %s → %s\n", name, c.String()[:20]+"...")
 	}
 }
 
@@ -298,17 +308,34 @@ func demonstrateLinkedStructures(ctx context.Context) {
 		{"Conclusion", "IPLD enables powerful data structures for modern distributed applications."},
 	}
 
+	// Typed nodes: PutStruct stores fields under their ipld tags and
+	// cid.Cid fields as links
+	type Chapter struct {
+		Type    string `ipld:"type"`
+		Title   string `ipld:"title"`
+		Content string `ipld:"content"`
+		Number  int    `ipld:"number"`
+	}
+	type TableOfContents struct {
+		Type     string             `ipld:"type"`
+		Chapters map[string]cid.Cid `ipld:"chapters"`
+	}
+	type Document struct {
+		Type    string  `ipld:"type"`
+		Title   string  `ipld:"title"`
+		Author  string  `ipld:"author"`
+		Version string  `ipld:"version"`
+		TOC     cid.Cid `ipld:"toc"`
+	}
+
 	var chapterCids []cid.Cid
 	for i, chapter := range chapters {
-		// Create chapter structure
-		chapterData := map[string]interface{}{
-			"type":    "chapter",
-			"title":   chapter.title,
-			"content": chapter.content,
-			"number":  i + 1,
-		}
-
-		chapterCid, err := ipld.PutAny(ctx, chapterData)
+		chapterCid, err := ipld.PutStruct(ctx, Chapter{
+			Type:    "chapter",
+			Title:   chapter.title,
+			Content: chapter.content,
+			Number:  i + 1,
+		})
 		if err != nil {
 			fmt.Printf("   ❌ Failed to create chapter %d: %v\n", i+1, err)
 			continue
@@ -321,35 +348,31 @@ func demonstrateLinkedStructures(ctx context.Context) {
 	fmt.Printf("\n📖 Creating document with table of contents:\n")
 
 	// Create table of contents structure
-	tocChapters := make(map[string]string)
+	tocChapters := make(map[string]cid.Cid)
 	for i := range chapters {
 		if i < len(chapterCids) {
 			key := fmt.Sprintf("chapter-%d", i+1)
-			tocChapters[key] = chapterCids[i].String()
+			tocChapters[key] = chapterCids[i]
 		}
 	}
 
-	tocData := map[string]interface{}{
-		"type":     "table-of-contents",
-		"chapters": tocChapters,
-	}
-
-	tocCid, err := ipld.PutAny(ctx, tocData)
+	tocCid, err := ipld.PutStruct(ctx, TableOfContents{
+		Type:     "table-of-contents",
+		Chapters: tocChapters,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("   📑 Table of Contents: %s\n", tocCid.String()[:20]+"...")
 
 	// Create document root
-	docData := map[string]interface{}{
-		"type":    "document",
-		"title":   "IPLD Guide",
-		"author":  "Demo",
-		"version": "1.0",
-		"toc":     tocCid.String(),
-	}
-
-	docCid, err := ipld.PutAny(ctx, docData)
+	docCid, err := ipld.PutStruct(ctx, Document{
+		Type:    "document",
+		Title:   "IPLD Guide",
+		Author:  "Demo",
+		Version: "1.0",
+		TOC:     tocCid,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -363,25 +386,20 @@ func demonstrateLinkedStructures(ctx context.Context) {
 
 	// Demonstrate navigation
 	fmt.Printf("\n🧭 Navigating the document structure:\n")
-	docNodeRetrieved, err := ipld.GetNode(ctx, docCid)
-	if err != nil {
+	var docRetrieved Document
+	if err := ipld.GetStruct(ctx, docCid, &docRetrieved); err != nil {
 		fmt.Printf("   ❌ Failed to retrieve document: %v\n", err)
 		return
 	}
+	fmt.Printf("   📚 Document metadata: %s (%s)\n", docRetrieved.Title, docRetrieved.Version)
 
-	fmt.Printf("   📚 Document metadata: %s\n", string(docNodeRetrieved.RawData()))
-
-	for _, link := range docNodeRetrieved.Links() {
-		fmt.Printf("   🔗 Link: %s → %s\n", link.Name, link.Cid.String()[:20]+"...")
-
-		if link.Name == "table-of-contents" {
-			tocNodeRetrieved, err := ipld.GetNode(ctx, link.Cid)
-			if err != nil {
-				continue
-			}
-			fmt.Printf("      📑 TOC has %d chapter links\n", len(tocNodeRetrieved.Links()))
-		}
+	tocNodeRetrieved, tocResolved, _, err := ipld.ResolvePath(ctx, docCid, "toc")
+	if err != nil {
+		fmt.Printf("   ❌ Failed to follow toc link: %v\n", err)
+		return
 	}
+	fmt.Printf("   🔗 Link: toc → %s\n", tocResolved.String()[:20]+"...")
+	fmt.Printf("      📑 TOC has %d chapter links\n", len(tocNodeRetrieved.Links()))
 }
 
 func demonstrateJSONHandling(ctx context.Context) {
@@ -539,12 +557,12 @@ func demonstratePathResolution(ctx context.Context) {
 	}
 
 	// Create src directory structure
-	srcFiles := make(map[string]string)
+	srcFiles := make(map[string]cid.Cid)
 	if cid, ok := fileCids["main.go"]; ok {
-		srcFiles["main.go"] = cid.String()
+		srcFiles["main.go"] = cid
 	}
 	if cid, ok := fileCids["test.go"]; ok {
-		srcFiles["test.go"] = cid.String()
+		srcFiles["test.go"] = cid
 	}
 
 	srcData := map[string]interface{}{
@@ -560,19 +578,19 @@ func demonstratePathResolution(ctx context.Context) {
 	fmt.Printf("   📁 src/: %s\n", srcCid.String()[:20]+"...")
 
 	// Create root directory structure
-	rootFiles := make(map[string]string)
+	rootFiles := make(map[string]cid.Cid)
 	if cid, ok := fileCids["readme.txt"]; ok {
-		rootFiles["README.txt"] = cid.String()
+		rootFiles["README.txt"] = cid
 	}
 	if cid, ok := fileCids["config.json"]; ok {
-		rootFiles["config.json"] = cid.String()
+		rootFiles["config.json"] = cid
 	}
 
 	rootData := map[string]interface{}{
 		"type":        "directory",
 		"name":        "root",
 		"files":       rootFiles,
-		"directories": map[string]string{"src": srcCid.String()},
+		"directories": map[string]cid.Cid{"src": srcCid},
 	}
 
 	rootCid, err := ipld.PutAny(ctx, rootData)
@@ -585,16 +603,17 @@ func demonstratePathResolution(ctx context.Context) {
 
 	// Test various path resolutions
 	testPaths := []string{
-		"",            // Root
-		"README.txt",  // File in root
-		"src",         // Directory
-		"src/main.go", // File in subdirectory
-		"src/test.go", // Another file in subdirectory
+		"",                              // Root
+		"files/README.txt",              // File in root
+		"directories/src",               // Directory
+		"directories/src/files/main.go", // File in subdirectory
+		"directories/src/files/test.go", // Another file in subdirectory
+		"directories/src/name",          // Value inside the src block
 	}
 
 	for _, path := range testPaths {
 		start := time.Now()
-		node, resolvedCid, err := ipld.ResolvePath(ctx, rootCid, path)
+		node, resolvedCid, rest, err := ipld.ResolvePath(ctx, rootCid, path)
 		duration := time.Since(start)
 
 		if err != nil {
@@ -611,7 +630,9 @@ func demonstratePathResolution(ctx context.Context) {
 			displayPath, resolvedCid.String()[:20]+"...", duration)
 
 		// Show node type and basic info
-		if len(node.Links()) > 0 {
+		if len(rest) > 0 {
+			fmt.Printf("      🔎 Value at '%s' inside that block\n", strings.Join(rest, "/"))
+		} else if len(node.Links()) > 0 {
 			fmt.Printf("      📁 Directory with %d items\n", len(node.Links()))
 		} else {
 			dataPreview := string(node.RawData())
@@ -624,7 +645,7 @@ func demonstratePathResolution(ctx context.Context) {
 
 	// Test invalid path
 	fmt.Printf("\n🚫 Testing invalid path resolution:\n")
-	_, _, err = ipld.ResolvePath(ctx, rootCid, "nonexistent/file.txt")
+	_, _, _, err = ipld.ResolvePath(ctx, rootCid, "nonexistent/file.txt")
 	if err != nil {
 		fmt.Printf("   ✅ Invalid path correctly rejected: %v\n", err)
 	} else {
@@ -730,7 +751,7 @@ func demonstratePerformance(ctx context.Context) {
 			levelData := map[string]interface{}{
 				"level": i,
 				"data":  fmt.Sprintf("level %d", i),
-				"child": currentCid.String(),
+				"child": currentCid,
 			}
 
 			currentCid, err = ipld.PutAny(ctx, levelData)
@@ -756,7 +777,7 @@ func demonstratePerformance(ctx context.Context) {
 			path += "child"
 		}
 
-		_, _, err = ipld.ResolvePath(ctx, currentCid, path)
+		_, _, _, err = ipld.ResolvePath(ctx, currentCid, path)
 		traversalTime := time.Since(start)
 
 		if err != nil {
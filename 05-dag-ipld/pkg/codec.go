@@ -0,0 +1,194 @@
+package dag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ErrUnsupportedCodec is returned for codecs PutAnyWithCodec and GetAny
+// cannot map to Go values
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// PutAnyWithCodec stores v as a cid.DagCBOR or cid.DagJSON block. v goes
+// through encoding/json first, so json tags apply and cid.Cid fields
+// (which marshal as {"/": "..."}) become real IPLD links. []byte fields
+// marshal as base64 strings, not IPLD bytes; PutStruct keeps them.
+func (d *IpldWrapper) PutAnyWithCodec(ctx context.Context, v any, codec uint64) (cid.Cid, error) {
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		return d.putAny(ctx, v, codec)
+	})
+}
+
+func (d *IpldWrapper) putAny(ctx context.Context, v any, codec uint64) (cid.Cid, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return cid.Undef, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewReader(data)); err != nil {
+		return cid.Undef, fmt.Errorf("value is not valid IPLD data: %w", err)
+	}
+	return d.putNode(ctx, nb.Build(), codec)
+}
+
+// putNode encodes n with codec and stores it as a CIDv1 block
+func (d *IpldWrapper) putNode(ctx context.Context, n datamodel.Node, codec uint64) (cid.Cid, error) {
+	var encode func(datamodel.Node, io.Writer) error
+	switch codec {
+	case cid.DagCBOR:
+		encode = dagcbor.Encode
+	case cid.DagJSON:
+		encode = dagjson.Encode
+	default:
+		return cid.Undef, fmt.Errorf("%w: 0x%x", ErrUnsupportedCodec, codec)
+	}
+	var buf bytes.Buffer
+	if err := encode(n, &buf); err != nil {
+		return cid.Undef, err
+	}
+
+	c, err := cid.Prefix{Version: 1, Codec: codec, MhType: mh.SHA2_256, MhLength: -1}.Sum(buf.Bytes())
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := d.BlockServiceWrapper.AddBlock(ctx, blk); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// loadNode decodes the dag-cbor or dag-json block at c
+func (d *IpldWrapper) loadNode(ctx context.Context, c cid.Cid) (datamodel.Node, error) {
+	var decode func(datamodel.NodeAssembler, io.Reader) error
+	switch c.Type() {
+	case cid.DagCBOR:
+		decode = dagcbor.Decode
+	case cid.DagJSON:
+		decode = dagjson.Decode
+	default:
+		return nil, fmt.Errorf("%w: 0x%x", ErrUnsupportedCodec, c.Type())
+	}
+	blk, err := d.BlockServiceWrapper.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decode(nb, bytes.NewReader(blk.RawData())); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", c, err)
+	}
+	return nb.Build(), nil
+}
+
+// GetAny decodes the block at c into v, picking the codec from the CID:
+// dag-cbor and dag-json blocks, including ones written by other tools,
+// and JSON stored raw by older versions of PutAny. Links decode into
+// cid.Cid fields, or {"/": "<cid>"} maps in untyped values.
+func (d *IpldWrapper) GetAny(ctx context.Context, c cid.Cid, v any) error {
+	if c.Type() == cid.Raw {
+		blk, err := d.BlockServiceWrapper.GetBlock(ctx, c)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(blk.RawData(), v)
+	}
+
+	n, err := d.loadNode(ctx, c)
+	if err != nil {
+		return err
+	}
+	// dag-json is plain JSON with links as {"/": "..."}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+// MigrateLegacy rewrites a DAG written by older versions of PutAny, JSON
+// in raw blocks with child CIDs as strings, to dag-cbor with real links
+// and returns the new root. Strings that parse as a CID become links, and
+// children that are legacy JSON blocks are migrated before their parents.
+// The old blocks are left in place.
+func (d *IpldWrapper) MigrateLegacy(ctx context.Context, root cid.Cid) (cid.Cid, error) {
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		return d.migrate(ctx, root, make(map[cid.Cid]cid.Cid))
+	})
+}
+
+func (d *IpldWrapper) migrate(ctx context.Context, c cid.Cid, done map[cid.Cid]cid.Cid) (cid.Cid, error) {
+	if n, ok := done[c]; ok {
+		return n, nil
+	}
+	done[c] = c
+	if c.Type() != cid.Raw {
+		return c, nil
+	}
+	blk, err := d.BlockServiceWrapper.GetBlock(ctx, c)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("migrate %s: %w", c, err)
+	}
+	var v any
+	if err := json.Unmarshal(blk.RawData(), &v); err != nil {
+		return c, nil // plain data, not a PutAny object
+	}
+	switch v.(type) {
+	case map[string]any, []any:
+	default:
+		return c, nil
+	}
+
+	if v, err = d.relink(ctx, v, done); err != nil {
+		return cid.Undef, err
+	}
+	n, err := d.putAny(ctx, v, cid.DagCBOR)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("migrate %s: %w", c, err)
+	}
+	done[c] = n
+	return n, nil
+}
+
+// relink replaces CID strings in v with links to their migrated blocks
+func (d *IpldWrapper) relink(ctx context.Context, v any, done map[cid.Cid]cid.Cid) (any, error) {
+	var err error
+	switch x := v.(type) {
+	case map[string]any:
+		if s, ok := x["/"].(string); ok && len(x) == 1 {
+			return d.relink(ctx, s, done) // already a dag-json style link
+		}
+		for k, e := range x {
+			if x[k], err = d.relink(ctx, e, done); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for i, e := range x {
+			if x[i], err = d.relink(ctx, e, done); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		c, err := cid.Decode(x)
+		if err != nil {
+			return x, nil
+		}
+		return d.migrate(ctx, c, done)
+	}
+	return v, nil
+}
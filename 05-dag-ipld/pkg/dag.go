@@ -15,6 +15,7 @@ import (
 type DagServiceWrapper struct {
 	BlockServiceWrapper *bitswap.BlockServiceWrapper
 	format.DAGService
+	pinner Pinner // nil: writes are not pinned
 }
 
 func NewDagServiceWrapper(ctx context.Context, blockserviceWrapper *bitswap.BlockServiceWrapper) (*DagServiceWrapper, error) {
@@ -35,12 +36,13 @@ func NewDagServiceWrapper(ctx context.Context, blockserviceWrapper *bitswap.Bloc
 }
 
 func (d *DagServiceWrapper) AddRaw(ctx context.Context, payload []byte) (cid.Cid, error) {
-	pn := merkledag.NewRawNode(payload)
-	err := d.DAGService.Add(ctx, pn)
-	if err != nil {
-		return cid.Undef, err
-	}
-	return pn.Cid(), nil
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		pn := merkledag.NewRawNode(payload)
+		if err := d.DAGService.Add(ctx, pn); err != nil {
+			return cid.Undef, err
+		}
+		return pn.Cid(), nil
+	})
 }
 
 func (d *DagServiceWrapper) GetRaw(ctx context.Context, c cid.Cid) ([]byte, error) {
@@ -2,14 +2,15 @@ package dag
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 
-	"github.com/ipfs/boxo/ipld/merkledag"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/datamodel"
 
 	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
 )
@@ -44,66 +45,128 @@ func NewIpldWrapper(ctx context.Context,  blockserviceWrapper *bitswap.BlockServ
 // ------------------------------------------------------------------
 
 func (d *IpldWrapper) PutNode(ctx context.Context, n format.Node) (cid.Cid, error) {
-	if err := d.DagServiceWrapper.Add(ctx, n); err != nil {
-		return cid.Undef, err
-	}
-	return n.Cid(), nil
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		if err := d.DagServiceWrapper.Add(ctx, n); err != nil {
+			return cid.Undef, err
+		}
+		return n.Cid(), nil
+	})
 }
 
+// PutAny stores v as dag-cbor. cid.Cid values, also inside maps and
+// slices, become IPLD links that ResolvePath, pinning and GC follow.
 func (d *IpldWrapper) PutAny(ctx context.Context, v any) (cid.Cid, error) {
-	data, err := json.Marshal(v)
-	if err != nil {
-		return cid.Undef, err
-	}
-
-	node := merkledag.NewRawNode(data)
-	return d.PutNode(ctx, node)
+	return d.PutAnyWithCodec(ctx, v, cid.DagCBOR)
 }
 
 func (d *IpldWrapper) GetNode(ctx context.Context, c cid.Cid) (format.Node, error) {
 	return d.DagServiceWrapper.Get(ctx, c)
 }
 
-func (d *IpldWrapper) GetAny(ctx context.Context, c cid.Cid, v any) error {
-	n, err := d.DagServiceWrapper.Get(ctx, c)
+// ResolvePath follows path from root through as many blocks as it leads
+// to. In dag-pb nodes a segment is a link name or index; in dag-cbor and
+// dag-json nodes segments walk map keys and list indexes and step through
+// links into the next block, e.g. "dirs/0/files/README.txt". Segments are
+// percent-decoded, so a key containing "/" is written as "%2F", and empty
+// segments are ignored.
+//
+// It returns the last block reached. When the path ends inside that block
+// on a value that is not a link, such as a string or a nested map, rest
+// holds the decoded segments leading to it from the block; rest is empty
+// when the path ends on a block.
+func (d *IpldWrapper) ResolvePath(ctx context.Context, root cid.Cid, path string) (format.Node, cid.Cid, []string, error) {
+	segs, err := parsePath(path)
 	if err != nil {
-		return err
+		return nil, cid.Undef, nil, err
 	}
-	return json.Unmarshal(n.RawData(), v)
-}
 
-func (d *IpldWrapper) ResolvePath(ctx context.Context, root cid.Cid, path string) (format.Node, cid.Cid, error) {
-	cur, err := d.GetNode(ctx, root)
-	if err != nil {
-		return nil, cid.Undef, err
+	cur := root
+	for len(segs) > 0 {
+		var (
+			next cid.Cid
+			used int
+		)
+		switch cur.Type() {
+		case cid.DagProtobuf, cid.Raw:
+			var nd format.Node
+			if nd, err = d.GetNode(ctx, cur); err != nil {
+				return nil, cid.Undef, nil, err
+			}
+			used = 1
+			if next, err = findChildCID(nd, segs[0]); err != nil {
+				return nil, cid.Undef, nil, fmt.Errorf("path %q: %w", segs[0], err)
+			}
+		default:
+			if next, used, err = d.resolveInBlock(ctx, cur, segs); err != nil {
+				return nil, cid.Undef, nil, fmt.Errorf("path %q in %s: %w", strings.Join(segs, "/"), cur, err)
+			}
+		}
+		if !next.Defined() {
+			break // the rest of the path is inside cur
+		}
+		cur, segs = next, segs[used:]
+	}
+	if len(segs) == 0 {
+		segs = nil
 	}
-	curCID := root
 
-	seg := strings.Trim(path, "/")
-	if seg == "" {
-		return cur, curCID, nil
+	nd, err := d.GetNode(ctx, cur)
+	if err != nil {
+		return nil, cid.Undef, nil, err
 	}
-	parts := strings.Split(seg, "/")
+	return nd, cur, segs, nil
+}
 
-	for i, p := range parts {
-		nextCID, err := findChildCID(cur, p)
-		if err != nil {
-			return nil, cid.Undef, fmt.Errorf("path %q: %w", p, err)
+// parsePath splits path into percent-decoded segments
+func parsePath(path string) ([]string, error) {
+	var segs []string
+	for _, s := range strings.Split(path, "/") {
+		if s == "" {
+			continue
 		}
-
-		nextNode, err := d.GetNode(ctx, nextCID)
+		seg, err := url.PathUnescape(s)
 		if err != nil {
-			return nil, cid.Undef, err
+			return nil, fmt.Errorf("path segment %q: %w", s, err)
 		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
 
-		cur = nextNode
-		curCID = nextCID
-
-		if i == len(parts)-1 {
-			return cur, curCID, nil
+// resolveInBlock walks segs inside the dag-cbor or dag-json block at c up
+// to the first link and returns its CID and the segments used. If the
+// path ends before a link it returns cid.Undef.
+func (d *IpldWrapper) resolveInBlock(ctx context.Context, c cid.Cid, segs []string) (cid.Cid, int, error) {
+	n, err := d.loadNode(ctx, c)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	for i, seg := range segs {
+		switch n.Kind() {
+		case datamodel.Kind_Map:
+			if n, err = n.LookupByString(seg); err != nil {
+				return cid.Undef, 0, fmt.Errorf("key %q not found", seg)
+			}
+		case datamodel.Kind_List:
+			idx, err := strconv.ParseInt(seg, 10, 64)
+			if err != nil || idx < 0 || idx >= n.Length() {
+				return cid.Undef, 0, fmt.Errorf("index %q out of range (%d items)", seg, n.Length())
+			}
+			if n, err = n.LookupByIndex(idx); err != nil {
+				return cid.Undef, 0, err
+			}
+		default:
+			return cid.Undef, 0, fmt.Errorf("cannot look up %q in a %s", seg, n.Kind())
+		}
+		if n.Kind() == datamodel.Kind_Link {
+			var next cid.Cid
+			if err := disassemble(n, reflect.ValueOf(&next).Elem()); err != nil {
+				return cid.Undef, 0, err
+			}
+			return next, i + 1, nil
 		}
 	}
-	return cur, curCID, nil
+	return cid.Undef, len(segs), nil
 }
 
 func findChildCID(n format.Node, seg string) (cid.Cid, error) {
@@ -0,0 +1,237 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// ErrMergeConflict is returned by Merge when both sides changed the same
+// value differently and no resolver settled it
+var ErrMergeConflict = errors.New("merge conflict")
+
+// MergeRemove returned by a ConflictResolver drops the conflicting key
+var MergeRemove = &struct{ remove bool }{true}
+
+// Conflict is a value both sides changed differently. Values are plain Go
+// values as GetStruct decodes them into an any field, with links as
+// cid.Cid; a side where the key does not exist has In<Side> false.
+type Conflict struct {
+	Path                     string
+	Base, Ours, Theirs       any
+	InBase, InOurs, InTheirs bool
+}
+
+// ConflictResolver picks the merged value for a conflict: one of the
+// sides, a new value, or MergeRemove
+type ConflictResolver func(Conflict) (any, error)
+
+// PreferOurs resolves every conflict with our side
+func PreferOurs(c Conflict) (any, error) {
+	if !c.InOurs {
+		return MergeRemove, nil
+	}
+	return c.Ours, nil
+}
+
+// PreferTheirs resolves every conflict with their side
+func PreferTheirs(c Conflict) (any, error) {
+	if !c.InTheirs {
+		return MergeRemove, nil
+	}
+	return c.Theirs, nil
+}
+
+// absent marks a map key that does not exist on one side
+type absent struct{}
+
+// Merge combines two versions of a map-shaped dag-cbor or dag-json node
+// that were both derived from base. Keys changed on one side only take
+// that side's value; maps changed on both sides are merged key by key,
+// also across links, whose merged nodes are stored as new blocks. Any
+// other value changed differently on both sides goes to resolve, or fails
+// with ErrMergeConflict if resolve is nil. Lists are merged as a whole.
+func (d *IpldWrapper) Merge(ctx context.Context, base, ours, theirs cid.Cid, resolve ConflictResolver) (cid.Cid, error) {
+	if ours.Equals(theirs) {
+		return ours, nil
+	}
+	m := merger{d: d, ctx: ctx, resolve: resolve}
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		merged, err := m.mergeLinks("", base, ours, theirs)
+		if err != nil {
+			return cid.Undef, err
+		}
+		c, ok := merged.(cid.Cid)
+		if !ok {
+			return cid.Undef, fmt.Errorf("%w: the roots are not maps and were resolved to a %T", ErrMergeConflict, merged)
+		}
+		return c, nil
+	})
+}
+
+type merger struct {
+	d       *IpldWrapper
+	ctx     context.Context
+	resolve ConflictResolver
+}
+
+func (m *merger) load(c cid.Cid) (any, error) {
+	n, err := m.d.loadNode(m.ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return toTree(n)
+}
+
+// mergeLinks merges the nodes behind three links into a new block; base
+// may be cid.Undef for a node that did not exist in base
+func (m *merger) mergeLinks(path string, base, ours, theirs cid.Cid) (any, error) {
+	var b any = map[string]any{}
+	if base.Defined() {
+		var err error
+		if b, err = m.load(base); err != nil {
+			return nil, err
+		}
+	}
+	o, err := m.load(ours)
+	if err != nil {
+		return nil, err
+	}
+	t, err := m.load(theirs)
+	if err != nil {
+		return nil, err
+	}
+	om, ook := o.(map[string]any)
+	tm, tok := t.(map[string]any)
+	if !ook || !tok {
+		var bv any = absent{}
+		if base.Defined() {
+			bv = base
+		}
+		return m.conflict(path, bv, ours, theirs)
+	}
+	bm, _ := b.(map[string]any)
+
+	merged, err := m.mergeMaps(path, bm, om, tm)
+	if err != nil {
+		return nil, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := assemble(nb, reflect.ValueOf(&merged).Elem()); err != nil {
+		return nil, err
+	}
+	return m.d.putNode(m.ctx, nb.Build(), ours.Type())
+}
+
+func (m *merger) mergeMaps(path string, b, o, t map[string]any) (any, error) {
+	keys := make(map[string]struct{}, len(o)+len(t))
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	for k := range o {
+		keys[k] = struct{}{}
+	}
+	for k := range t {
+		keys[k] = struct{}{}
+	}
+
+	out := make(map[string]any, len(keys))
+	for k := range keys {
+		get := func(mp map[string]any) any {
+			if v, ok := mp[k]; ok {
+				return v
+			}
+			return absent{}
+		}
+		v, err := m.merge(path+"/"+k, get(b), get(o), get(t))
+		if err != nil {
+			return nil, err
+		}
+		if _, gone := v.(absent); !gone {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// merge returns the merged value, or absent{} if the key goes away
+func (m *merger) merge(path string, b, o, t any) (any, error) {
+	switch {
+	case reflect.DeepEqual(o, t):
+		return o, nil
+	case reflect.DeepEqual(b, o):
+		return t, nil
+	case reflect.DeepEqual(b, t):
+		return o, nil
+	}
+
+	// both changed: look inside maps and linked nodes
+	if om, ok := o.(map[string]any); ok {
+		if tm, ok := t.(map[string]any); ok {
+			bm, _ := b.(map[string]any)
+			return m.mergeMaps(path, bm, om, tm)
+		}
+	}
+	if oc, ok := o.(cid.Cid); ok && mergeable(oc) {
+		if tc, ok := t.(cid.Cid); ok && mergeable(tc) {
+			bc, _ := b.(cid.Cid)
+			if !mergeable(bc) {
+				bc = cid.Undef
+			}
+			return m.mergeLinks(path, bc, oc, tc)
+		}
+	}
+	return m.conflict(path, b, o, t)
+}
+
+func mergeable(c cid.Cid) bool {
+	return c.Defined() && (c.Type() == cid.DagCBOR || c.Type() == cid.DagJSON)
+}
+
+func (m *merger) conflict(path string, b, o, t any) (any, error) {
+	c := Conflict{Path: path}
+	c.Base, c.InBase = plain(b)
+	c.Ours, c.InOurs = plain(o)
+	c.Theirs, c.InTheirs = plain(t)
+	if m.resolve == nil {
+		return nil, fmt.Errorf("%w at %q", ErrMergeConflict, path)
+	}
+	v, err := m.resolve(c)
+	if err != nil {
+		return nil, err
+	}
+	if v == MergeRemove {
+		return absent{}, nil
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := assemble(nb, reflect.ValueOf(&v).Elem()); err != nil {
+		return nil, fmt.Errorf("resolved value at %q: %w", path, err)
+	}
+	return toTree(nb.Build())
+}
+
+// plain copies a tree value into the form GetAny gives an any
+func plain(v any) (any, bool) {
+	switch x := v.(type) {
+	case absent:
+		return nil, false
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			out[k], _ = plain(e)
+		}
+		return out, true
+	case *[]any:
+		out := slices.Clone(*x)
+		for i, e := range out {
+			out[i], _ = plain(e)
+		}
+		return out, true
+	}
+	return v, true
+}
@@ -0,0 +1,297 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// PatchOpType is the kind of change a PatchOp makes
+type PatchOpType string
+
+const (
+	// PatchAdd sets a map key, or inserts into a list before the index
+	// ("-" appends)
+	PatchAdd PatchOpType = "add"
+	// PatchReplace changes a value that must exist; an empty path replaces
+	// the whole root node
+	PatchReplace PatchOpType = "replace"
+	// PatchRemove deletes a map key or list item
+	PatchRemove PatchOpType = "remove"
+)
+
+// ErrPatchPath is returned when a patch path does not lead to a value the
+// operation can change
+var ErrPatchPath = errors.New("invalid patch path")
+
+// PatchOp is one change to a DAG. Path is resolved like in ResolvePath:
+// segments walk map keys and list indexes and step through links into the
+// linked dag-cbor or dag-json node. Value is converted like PutStruct
+// converts fields, so cid.Cid values become links.
+type PatchOp struct {
+	Op    PatchOpType
+	Path  string
+	Value any
+}
+
+// patchNode is a block loaded while patching; dirty ones are written back
+type patchNode struct {
+	orig  cid.Cid
+	value any // tree form: map[string]any, *[]any, cid.Cid or scalars
+	dirty bool
+}
+
+// Patch applies ops in order and returns the new root. Only blocks on the
+// paths of changed values are rewritten; every other subtree keeps its
+// CID, and the blocks of the old root stay in place. If an op fails
+// nothing is written.
+func (d *IpldWrapper) Patch(ctx context.Context, root cid.Cid, ops []PatchOp) (cid.Cid, error) {
+	top, err := d.loadPatchNode(ctx, root)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, op := range ops {
+		if err := d.applyPatch(ctx, top, op); err != nil {
+			return cid.Undef, fmt.Errorf("patch %s %q: %w", op.Op, op.Path, err)
+		}
+	}
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		return d.flushPatch(ctx, top)
+	})
+}
+
+func (d *IpldWrapper) loadPatchNode(ctx context.Context, c cid.Cid) (*patchNode, error) {
+	n, err := d.loadNode(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	v, err := toTree(n)
+	if err != nil {
+		return nil, err
+	}
+	return &patchNode{orig: c, value: v}, nil
+}
+
+func (d *IpldWrapper) applyPatch(ctx context.Context, top *patchNode, op PatchOp) error {
+	var segs []string
+	if p := strings.Trim(op.Path, "/"); p != "" {
+		segs = strings.Split(p, "/")
+	}
+
+	var val any
+	if op.Op != PatchRemove {
+		nb := basicnode.Prototype.Any.NewBuilder()
+		if err := assemble(nb, reflect.ValueOf(&op.Value).Elem()); err != nil {
+			return err
+		}
+		var err error
+		if val, err = toTree(nb.Build()); err != nil {
+			return err
+		}
+	}
+
+	if len(segs) == 0 {
+		if op.Op != PatchReplace {
+			return fmt.Errorf("%w: only replace applies to the root", ErrPatchPath)
+		}
+		top.value, top.dirty = val, true
+		return nil
+	}
+
+	// walk to the container holding the last segment, loading links
+	chain := []*patchNode{top}
+	cur := top.value
+	for _, seg := range segs[:len(segs)-1] {
+		child, err := childOf(cur, seg)
+		if err != nil {
+			return err
+		}
+		if c, ok := child.(cid.Cid); ok {
+			pn, err := d.loadPatchNode(ctx, c)
+			if err != nil {
+				return err
+			}
+			setChild(cur, seg, pn)
+			child = pn
+		}
+		if pn, ok := child.(*patchNode); ok {
+			chain = append(chain, pn)
+			child = pn.value
+		}
+		cur = child
+	}
+
+	last := segs[len(segs)-1]
+	var err error
+	switch op.Op {
+	case PatchAdd:
+		err = addChild(cur, last, val)
+	case PatchReplace:
+		if _, err = childOf(cur, last); err == nil {
+			setChild(cur, last, val)
+		}
+	case PatchRemove:
+		err = removeChild(cur, last)
+	default:
+		err = fmt.Errorf("unknown op %q", op.Op)
+	}
+	if err != nil {
+		return err
+	}
+	for _, pn := range chain {
+		pn.dirty = true
+	}
+	return nil
+}
+
+// flushPatch stores the dirty blocks under pn, children first
+func (d *IpldWrapper) flushPatch(ctx context.Context, pn *patchNode) (cid.Cid, error) {
+	if !pn.dirty {
+		return pn.orig, nil
+	}
+	v, err := d.resolvePatchLinks(ctx, pn.value)
+	if err != nil {
+		return cid.Undef, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := assemble(nb, reflect.ValueOf(&v).Elem()); err != nil {
+		return cid.Undef, err
+	}
+	return d.putNode(ctx, nb.Build(), pn.orig.Type())
+}
+
+// resolvePatchLinks replaces loaded blocks in v by their (new) CIDs
+func (d *IpldWrapper) resolvePatchLinks(ctx context.Context, v any) (any, error) {
+	var err error
+	switch x := v.(type) {
+	case *patchNode:
+		return d.flushPatch(ctx, x)
+	case map[string]any:
+		for k, e := range x {
+			if x[k], err = d.resolvePatchLinks(ctx, e); err != nil {
+				return nil, err
+			}
+		}
+	case *[]any:
+		for i, e := range *x {
+			if (*x)[i], err = d.resolvePatchLinks(ctx, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// toTree turns n into mutable Go values; lists are *[]any so they can
+// grow and shrink in place
+func toTree(n datamodel.Node) (any, error) {
+	g, err := generic(n)
+	if err != nil {
+		return nil, err
+	}
+	return wrapLists(g), nil
+}
+
+func wrapLists(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, e := range x {
+			x[k] = wrapLists(e)
+		}
+	case []any:
+		for i, e := range x {
+			x[i] = wrapLists(e)
+		}
+		return &x
+	}
+	return v
+}
+
+func listIndex(l []any, seg string, allowEnd bool) (int, error) {
+	if allowEnd && seg == "-" {
+		return len(l), nil
+	}
+	i, err := strconv.Atoi(seg)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a list index", ErrPatchPath, seg)
+	}
+	end := len(l)
+	if allowEnd {
+		end++
+	}
+	if i < 0 || i >= end {
+		return 0, fmt.Errorf("%w: index %d out of range (%d items)", ErrPatchPath, i, len(l))
+	}
+	return i, nil
+}
+
+func childOf(container any, seg string) (any, error) {
+	switch x := container.(type) {
+	case map[string]any:
+		v, ok := x[seg]
+		if !ok {
+			return nil, fmt.Errorf("%w: key %q not found", ErrPatchPath, seg)
+		}
+		return v, nil
+	case *[]any:
+		i, err := listIndex(*x, seg, false)
+		if err != nil {
+			return nil, err
+		}
+		return (*x)[i], nil
+	}
+	return nil, fmt.Errorf("%w: %q is inside a %T", ErrPatchPath, seg, container)
+}
+
+// setChild overwrites an existing entry
+func setChild(container any, seg string, v any) {
+	switch x := container.(type) {
+	case map[string]any:
+		x[seg] = v
+	case *[]any:
+		i, _ := strconv.Atoi(seg)
+		(*x)[i] = v
+	}
+}
+
+func addChild(container any, seg string, v any) error {
+	switch x := container.(type) {
+	case map[string]any:
+		x[seg] = v
+		return nil
+	case *[]any:
+		i, err := listIndex(*x, seg, true)
+		if err != nil {
+			return err
+		}
+		*x = append((*x)[:i], append([]any{v}, (*x)[i:]...)...)
+		return nil
+	}
+	return fmt.Errorf("%w: cannot add %q to a %T", ErrPatchPath, seg, container)
+}
+
+func removeChild(container any, seg string) error {
+	switch x := container.(type) {
+	case map[string]any:
+		if _, ok := x[seg]; !ok {
+			return fmt.Errorf("%w: key %q not found", ErrPatchPath, seg)
+		}
+		delete(x, seg)
+		return nil
+	case *[]any:
+		i, err := listIndex(*x, seg, false)
+		if err != nil {
+			return err
+		}
+		*x = append((*x)[:i], (*x)[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("%w: cannot remove %q from a %T", ErrPatchPath, seg, container)
+}
@@ -0,0 +1,46 @@
+package dag
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Pinner records the roots of new DAGs so garbage collection keeps them.
+// The 08-pin-gc PinManager implements it.
+type Pinner interface {
+	// WriteLock holds off garbage collection until unlock is called;
+	// several writes may hold it at once
+	WriteLock() (unlock func())
+	// PinRoot pins c and everything below it; c may already be pinned
+	PinRoot(ctx context.Context, c cid.Cid) error
+}
+
+// SetPinner makes every write through the wrappers pin the root it
+// returns: AddRaw, PutNode, PutAny, PutAnyWithCodec, PutStruct, Patch,
+// Merge, MigrateLegacy and the 06-unixfs-car imports. nil turns it off.
+func (d *DagServiceWrapper) SetPinner(p Pinner) {
+	d.pinner = p
+}
+
+// Pinned runs write, which stores a DAG and returns its root, and pins
+// that root. The pinner's write lock is held across both, so a GC cannot
+// run after the blocks were stored but before they are pinned. Without a
+// pinner it just runs write.
+func (d *DagServiceWrapper) Pinned(ctx context.Context, write func() (cid.Cid, error)) (cid.Cid, error) {
+	if d.pinner == nil {
+		return write()
+	}
+	unlock := d.pinner.WriteLock()
+	defer unlock()
+
+	root, err := write()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := d.pinner.PinRoot(ctx, root); err != nil {
+		return cid.Undef, fmt.Errorf("pin %s: %w", root, err)
+	}
+	return root, nil
+}
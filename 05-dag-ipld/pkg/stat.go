@@ -0,0 +1,166 @@
+package dag
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multicodec"
+)
+
+// DagStat describes a DAG. Blocks reachable along several paths are
+// stored once; the Expanded fields count them once per reference, which is
+// what the data would take without content addressing.
+type DagStat struct {
+	Root     cid.Cid
+	Blocks   int            // distinct blocks
+	Bytes    uint64         // size of the distinct blocks
+	Links    int            // links in the distinct blocks
+	MaxDepth int            // links on the longest path from the root
+	Codecs   map[string]int // distinct blocks per codec, e.g. "dag-pb"
+
+	ExpandedBlocks uint64 // blocks counted once per reference
+	ExpandedBytes  uint64 // their size
+	DedupSavings   uint64 // ExpandedBytes - Bytes
+}
+
+// DedupRatio is ExpandedBytes / Bytes: 1 without shared blocks
+func (s DagStat) DedupRatio() float64 {
+	if s.Bytes == 0 {
+		return 1
+	}
+	return float64(s.ExpandedBytes) / float64(s.Bytes)
+}
+
+// subtree is what the part of the DAG below one block adds up to
+type subtree struct {
+	blocks, bytes uint64
+	depth         int
+}
+
+// Stat walks the DAG under root and reports its size, depth, codecs and
+// how much deduplication saves. Each block is fetched once, so shared
+// subtrees cost nothing extra; blocks that are not local are fetched
+// through the BlockService.
+func (d *DagServiceWrapper) Stat(ctx context.Context, root cid.Cid) (*DagStat, error) {
+	st := &DagStat{Root: root, Codecs: make(map[string]int)}
+	seen := make(map[cid.Cid]subtree)
+
+	var walk func(c cid.Cid) (subtree, error)
+	walk = func(c cid.Cid) (subtree, error) {
+		if sub, ok := seen[c]; ok {
+			return sub, nil
+		}
+		nd, err := d.DAGService.Get(ctx, c)
+		if err != nil {
+			return subtree{}, fmt.Errorf("stat %s: %w", c, err)
+		}
+		size := uint64(len(nd.RawData()))
+		links := nd.Links()
+
+		st.Blocks++
+		st.Bytes += size
+		st.Links += len(links)
+		st.Codecs[multicodec.Code(c.Type()).String()]++
+
+		sub := subtree{blocks: 1, bytes: size}
+		for _, l := range links {
+			child, err := walk(l.Cid)
+			if err != nil {
+				return subtree{}, err
+			}
+			sub.blocks += child.blocks
+			sub.bytes += child.bytes
+			sub.depth = max(sub.depth, child.depth+1)
+		}
+		seen[c] = sub
+		return sub, nil
+	}
+
+	sub, err := walk(root)
+	if err != nil {
+		return nil, err
+	}
+	st.MaxDepth = sub.depth
+	st.ExpandedBlocks = sub.blocks
+	st.ExpandedBytes = sub.bytes
+	st.DedupSavings = sub.bytes - st.Bytes
+	return st, nil
+}
+
+// Overlap describes the blocks two DAGs have in common. Comparing an old
+// and a new version of some content, OnlyBBytes is the storage the new
+// version actually adds.
+type Overlap struct {
+	Shared      map[cid.Cid]struct{} // blocks reachable from both roots
+	SharedBytes uint64               // their size
+
+	OnlyA, OnlyB           int    // distinct blocks reachable from one root only
+	OnlyABytes, OnlyBBytes uint64 // their size
+}
+
+// sharedInfo is what SharedBlocks keeps of a block of the first DAG
+type sharedInfo struct {
+	size  uint64
+	links []cid.Cid
+}
+
+// SharedBlocks compares the DAGs under a and b block by block. Every block
+// of a is fetched once; of b only the blocks outside a are, plus the top
+// block of each subtree the two share, since everything below a shared
+// block is shared as well.
+func (d *DagServiceWrapper) SharedBlocks(ctx context.Context, a, b cid.Cid) (*Overlap, error) {
+	var (
+		mu     sync.Mutex
+		inA    = make(map[cid.Cid]sharedInfo)
+		aBytes uint64
+	)
+	err := d.Walk(ctx, a, func(nd format.Node, _ int) error {
+		info := sharedInfo{size: uint64(len(nd.RawData()))}
+		for _, l := range nd.Links() {
+			info.links = append(info.links, l.Cid)
+		}
+		mu.Lock()
+		inA[nd.Cid()] = info
+		aBytes += info.size
+		mu.Unlock()
+		return nil
+	}, WalkOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ov := &Overlap{Shared: make(map[cid.Cid]struct{})}
+	var tops []cid.Cid
+	err = d.Walk(ctx, b, func(nd format.Node, _ int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := inA[nd.Cid()]; ok {
+			tops = append(tops, nd.Cid())
+			return ErrSkipLinks
+		}
+		ov.OnlyB++
+		ov.OnlyBBytes += uint64(len(nd.RawData()))
+		return nil
+	}, WalkOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// the subtrees below the shared tops are known from walking a
+	for len(tops) > 0 {
+		c := tops[len(tops)-1]
+		tops = tops[:len(tops)-1]
+		if _, ok := ov.Shared[c]; ok {
+			continue
+		}
+		ov.Shared[c] = struct{}{}
+		ov.SharedBytes += inA[c].size
+		tops = append(tops, inA[c].links...)
+	}
+	ov.OnlyA = len(inA) - len(ov.Shared)
+	ov.OnlyABytes = aBytes - ov.SharedBytes
+	return ov, nil
+}
@@ -0,0 +1,402 @@
+package dag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+var cidType = reflect.TypeOf(cid.Cid{})
+
+// PutStruct stores v, a struct or a pointer to one, as a dag-cbor map.
+// Fields are keyed by their `ipld:"name"` tag, or by the field name
+// without one; `ipld:"-"` skips a field and `ipld:"name,omitempty"` leaves
+// it out when zero. cid.Cid values, also in slices, maps and nested
+// structs, become links; []byte becomes IPLD bytes.
+func (d *IpldWrapper) PutStruct(ctx context.Context, v any) (cid.Cid, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return cid.Undef, fmt.Errorf("PutStruct: %T is not a struct", v)
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := assemble(nb, rv); err != nil {
+		return cid.Undef, fmt.Errorf("PutStruct %T: %w", v, err)
+	}
+	return d.Pinned(ctx, func() (cid.Cid, error) {
+		return d.putNode(ctx, nb.Build(), cid.DagCBOR)
+	})
+}
+
+// GetStruct decodes the dag-cbor or dag-json map at c into v, a pointer
+// to a struct, using the same field names as PutStruct. Keys without a
+// field are ignored and fields without a key keep their value.
+func (d *IpldWrapper) GetStruct(ctx context.Context, c cid.Cid, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("GetStruct: %T is not a pointer to a struct", v)
+	}
+	n, err := d.loadNode(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := disassemble(n, rv.Elem()); err != nil {
+		return fmt.Errorf("GetStruct %s: %w", c, err)
+	}
+	return nil
+}
+
+type structField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// structFields lists the exported fields of t under their ipld names
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("ipld"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+// assemble builds the IPLD form of v into na
+func assemble(na datamodel.NodeAssembler, v reflect.Value) error {
+	if v.Type() == cidType {
+		c := v.Interface().(cid.Cid)
+		if !c.Defined() {
+			return na.AssignNull()
+		}
+		return na.AssignLink(cidlink.Link{Cid: c})
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return na.AssignNull()
+		}
+		return assemble(na, v.Elem())
+	case reflect.Bool:
+		return na.AssignBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return na.AssignInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return fmt.Errorf("%d overflows an IPLD int", v.Uint())
+		}
+		return na.AssignInt(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return na.AssignFloat(v.Float())
+	case reflect.String:
+		return na.AssignString(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return na.AssignBytes(v.Bytes())
+		}
+		if v.IsNil() {
+			return na.AssignNull()
+		}
+		return assembleList(na, v)
+	case reflect.Array:
+		return assembleList(na, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("map key %s: only string keys are supported", v.Type().Key())
+		}
+		if v.IsNil() {
+			return na.AssignNull()
+		}
+		ma, err := na.BeginMap(int64(v.Len()))
+		if err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := ma.AssembleKey().AssignString(iter.Key().String()); err != nil {
+				return err
+			}
+			if err := assemble(ma.AssembleValue(), iter.Value()); err != nil {
+				return fmt.Errorf("%s: %w", iter.Key().String(), err)
+			}
+		}
+		return ma.Finish()
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		ma, err := na.BeginMap(int64(len(fields)))
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			fv := v.Field(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			if err := ma.AssembleKey().AssignString(f.name); err != nil {
+				return err
+			}
+			if err := assemble(ma.AssembleValue(), fv); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+		return ma.Finish()
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func assembleList(na datamodel.NodeAssembler, v reflect.Value) error {
+	la, err := na.BeginList(int64(v.Len()))
+	if err != nil {
+		return err
+	}
+	for i := range v.Len() {
+		if err := assemble(la.AssembleValue(), v.Index(i)); err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+	}
+	return la.Finish()
+}
+
+// disassemble sets v, which must be settable, from n
+func disassemble(n datamodel.Node, v reflect.Value) error {
+	if n.IsNull() {
+		v.SetZero()
+		return nil
+	}
+	if v.Type() == cidType {
+		l, err := n.AsLink()
+		if err != nil {
+			return err
+		}
+		cl, ok := l.(cidlink.Link)
+		if !ok {
+			return fmt.Errorf("unsupported link type %T", l)
+		}
+		v.Set(reflect.ValueOf(cl.Cid))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return disassemble(n, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		g, err := generic(n)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(g))
+		return nil
+	case reflect.Bool:
+		b, err := n.AsBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := n.AsInt()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := n.AsInt()
+		if err != nil {
+			return err
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return fmt.Errorf("%d overflows %s", i, v.Type())
+		}
+		v.SetUint(uint64(i))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if n.Kind() == datamodel.Kind_Int {
+			i, _ := n.AsInt()
+			v.SetFloat(float64(i))
+			return nil
+		}
+		f, err := n.AsFloat()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.String:
+		s, err := n.AsString()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := n.AsBytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(bytes.Clone(b))
+			return nil
+		}
+		if n.Kind() != datamodel.Kind_List {
+			return fmt.Errorf("expected a list, got %s", n.Kind())
+		}
+		s := reflect.MakeSlice(v.Type(), int(n.Length()), int(n.Length()))
+		if err := disassembleList(n, s); err != nil {
+			return err
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		if n.Kind() != datamodel.Kind_List {
+			return fmt.Errorf("expected a list, got %s", n.Kind())
+		}
+		if int(n.Length()) > v.Len() {
+			return fmt.Errorf("%d items do not fit %s", n.Length(), v.Type())
+		}
+		return disassembleList(n, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("map key %s: only string keys are supported", v.Type().Key())
+		}
+		if n.Kind() != datamodel.Kind_Map {
+			return fmt.Errorf("expected a map, got %s", n.Kind())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), int(n.Length()))
+		for it := n.MapIterator(); !it.Done(); {
+			k, val, err := it.Next()
+			if err != nil {
+				return err
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := disassemble(val, elem); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		if n.Kind() != datamodel.Kind_Map {
+			return fmt.Errorf("expected a map, got %s", n.Kind())
+		}
+		for _, f := range structFields(v.Type()) {
+			fn, err := n.LookupByString(f.name)
+			if errors.As(err, new(datamodel.ErrNotExists)) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := disassemble(fn, v.Field(f.index)); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func disassembleList(n datamodel.Node, v reflect.Value) error {
+	for it := n.ListIterator(); !it.Done(); {
+		i, item, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if err := disassemble(item, v.Index(int(i))); err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// generic turns n into plain Go values for an untyped (any) field; links
+// become cid.Cid
+func generic(n datamodel.Node) (any, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Null:
+		return nil, nil
+	case datamodel.Kind_Bool:
+		return n.AsBool()
+	case datamodel.Kind_Int:
+		return n.AsInt()
+	case datamodel.Kind_Float:
+		return n.AsFloat()
+	case datamodel.Kind_String:
+		return n.AsString()
+	case datamodel.Kind_Bytes:
+		return n.AsBytes()
+	case datamodel.Kind_Link:
+		var c cid.Cid
+		if err := disassemble(n, reflect.ValueOf(&c).Elem()); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case datamodel.Kind_List:
+		out := make([]any, 0, n.Length())
+		for it := n.ListIterator(); !it.Done(); {
+			_, item, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			g, err := generic(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, g)
+		}
+		return out, nil
+	case datamodel.Kind_Map:
+		out := make(map[string]any, n.Length())
+		for it := n.MapIterator(); !it.Done(); {
+			k, val, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return nil, err
+			}
+			if out[key], err = generic(val); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported kind %s", n.Kind())
+}
@@ -0,0 +1,115 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// DefaultWalkConcurrency is how many nodes Walk fetches at once
+const DefaultWalkConcurrency = 8
+
+// ErrSkipLinks can be returned by a VisitFunc to not descend into the
+// links of the node it was given; the walk goes on elsewhere
+var ErrSkipLinks = errors.New("skip links")
+
+// VisitFunc is called once per node. depth is the number of links from
+// the root. It runs on several goroutines at once and must be safe for
+// concurrent use.
+type VisitFunc func(nd format.Node, depth int) error
+
+// WalkOptions tunes Walk; zero values keep the defaults
+type WalkOptions struct {
+	Concurrency int // nodes fetched and visited at once (default 8)
+	MaxDepth    int // links followed from the root; 0 means no limit
+}
+
+// Walk visits every node reachable from root, each CID once, level by
+// level, fetching up to Concurrency nodes in parallel. Since each level
+// is finished before the next begins, a node is always visited at its
+// smallest depth. The first error from visit, other than ErrSkipLinks, or
+// the end of ctx stops the walk and is returned.
+func (d *DagServiceWrapper) Walk(ctx context.Context, root cid.Cid, visit VisitFunc, opts WalkOptions) error {
+	conc := opts.Concurrency
+	if conc <= 0 {
+		conc = DefaultWalkConcurrency
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	seen := map[cid.Cid]struct{}{root: {}}
+	level := []cid.Cid{root}
+	for depth := 0; len(level) > 0; depth++ {
+		expand := opts.MaxDepth <= 0 || depth < opts.MaxDepth
+
+		var (
+			mu   sync.Mutex
+			next []cid.Cid
+			wg   sync.WaitGroup
+		)
+		work := make(chan cid.Cid)
+		for range min(conc, len(level)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for c := range work {
+					links, err := d.walkOne(ctx, c, depth, visit)
+					if err != nil {
+						cancel(err)
+						continue
+					}
+					if !expand {
+						continue
+					}
+					mu.Lock()
+					for _, l := range links {
+						if _, ok := seen[l.Cid]; !ok {
+							seen[l.Cid] = struct{}{}
+							next = append(next, l.Cid)
+						}
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+
+	feed:
+		for _, c := range level {
+			select {
+			case work <- c:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(work)
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		level = next
+	}
+	return nil
+}
+
+// walkOne fetches and visits c and returns the links to follow
+func (d *DagServiceWrapper) walkOne(ctx context.Context, c cid.Cid, depth int, visit VisitFunc) ([]*format.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	nd, err := d.DAGService.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", c, err)
+	}
+	if err := visit(nd, depth); err != nil {
+		if errors.Is(err, ErrSkipLinks) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return nd.Links(), nil
+}
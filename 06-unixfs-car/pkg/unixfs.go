@@ -11,17 +11,26 @@ import (
 
 	chunk "github.com/ipfs/boxo/chunker"
 	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
 	ufs "github.com/ipfs/boxo/ipld/unixfs"
 	uio "github.com/ipfs/boxo/ipld/unixfs/file"
 	"github.com/ipfs/boxo/ipld/unixfs/importer"
 	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
 
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 )
 
+// Provider announces blocks to the network. The 03-dht-router
+// DHTWrapper implements it.
+type Provider interface {
+	ProvideMany(ctx context.Context, keys []mh.Multihash) error
+}
+
 type UnixFsWrapper struct {
 	defaultChunkSize int64
 	*dag.IpldWrapper
+	provider Provider // nil: imports are not announced
 }
 
 func New(defaultChunkSize int64, dagWrapper *dag.IpldWrapper) (*UnixFsWrapper, error) {
@@ -42,7 +51,20 @@ func New(defaultChunkSize int64, dagWrapper *dag.IpldWrapper) (*UnixFsWrapper, e
 	}, nil
 }
 
+// SetProvider makes PutPath announce every block of an imported tree
+func (u *UnixFsWrapper) SetProvider(p Provider) {
+	u.provider = p
+}
+
+// Put imports a file or directory tree and returns its root, which is
+// pinned when the wrapper has a Pinner
 func (u *UnixFsWrapper) Put(ctx context.Context, node files.Node) (cid.Cid, error) {
+	return u.Pinned(ctx, func() (cid.Cid, error) {
+		return u.put(ctx, node)
+	})
+}
+
+func (u *UnixFsWrapper) put(ctx context.Context, node files.Node) (cid.Cid, error) {
 	switch v := node.(type) {
 	case files.File:
 		return u.putFile(ctx, v)
@@ -79,7 +101,31 @@ func (u *UnixFsWrapper) PutPath(ctx context.Context, path string) (cid.Cid, erro
 	}
 	defer node.Close()
 
-	return u.Put(ctx, node)
+	root, err := u.Put(ctx, node)
+	if err != nil || u.provider == nil {
+		return root, err
+	}
+	if err := u.provideTree(ctx, root); err != nil {
+		return root, fmt.Errorf("imported %s but announcing failed: %w", root, err)
+	}
+	return root, nil
+}
+
+// provideTree announces root and every block below it in one batch
+func (u *UnixFsWrapper) provideTree(ctx context.Context, root cid.Cid) error {
+	var keys []mh.Multihash
+	seen := cid.NewSet()
+	err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(u.IpldWrapper), root, func(c cid.Cid) bool {
+		if !seen.Visit(c) {
+			return false
+		}
+		keys = append(keys, c.Hash())
+		return true
+	}, merkledag.Concurrent())
+	if err != nil {
+		return fmt.Errorf("walk %s: %w", root, err)
+	}
+	return u.provider.ProvideMany(ctx, keys)
 }
 
 func (u *UnixFsWrapper) putFile(ctx context.Context, file files.File) (cid.Cid, error) {
@@ -116,7 +162,7 @@ func (u *UnixFsWrapper) putDir(ctx context.Context, d files.Directory) (cid.Cid,
 		name := it.Name()
 		n := it.Node()
 
-		childCid, err := u.Put(ctx, n)
+		childCid, err := u.put(ctx, n)
 		_ = n.Close()
 		if err != nil {
 			return cid.Undef, fmt.Errorf("put child %q: %w", name, err)
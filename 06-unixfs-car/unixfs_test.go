@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 
 	unixfs "github.com/gosuda/boxo-starter-kit/06-unixfs-car/pkg"
@@ -130,3 +132,41 @@ func TestCar(t *testing.T) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, []cid.Cid{rootX, rootY}, imported)
 }
+
+type recordingProvider struct {
+	keys []mh.Multihash
+	err  error
+}
+
+func (p *recordingProvider) ProvideMany(_ context.Context, keys []mh.Multihash) error {
+	p.keys = append(p.keys, keys...)
+	return p.err
+}
+
+func TestUnixFsProvide(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 15*time.Second)
+	defer timeout()
+
+	ufs, err := unixfs.New(0, nil)
+	require.NoError(t, err)
+	prov := &recordingProvider{}
+	ufs.SetProvider(prov)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "a.bin"), make([]byte, 4096), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "b.txt"), []byte("small"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "c.txt"), []byte("small"), 0o644))
+
+	root, err := ufs.PutPath(ctx, tmp)
+	require.NoError(t, err)
+
+	// the directory and the two distinct files, each announced once even
+	// though b.txt and c.txt share a block
+	require.Len(t, prov.keys, 3)
+	require.Contains(t, prov.keys, root.Hash())
+
+	prov.err = errors.New("no peers")
+	root2, err := ufs.PutPath(ctx, tmp)
+	require.Error(t, err)
+	require.Equal(t, root, root2, "root is returned even when announcing fails")
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -15,6 +16,9 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/spf13/cobra"
 
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 	unixfs "github.com/gosuda/boxo-starter-kit/06-unixfs-car/pkg"
 	mymfs "github.com/gosuda/boxo-starter-kit/07-mfs/pkg"
 )
@@ -27,7 +31,8 @@ func repoDir() string {
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".mfs-mini")
 }
-func statePath() string { return filepath.Join(repoDir(), "state.json") }
+func statePath() string  { return filepath.Join(repoDir(), "state.json") }
+func blocksPath() string { return filepath.Join(repoDir(), "blocks") }
 
 func loadState() (State, error) {
 	_ = os.MkdirAll(repoDir(), 0o755)
@@ -58,6 +63,8 @@ func must(err error) {
 type App struct {
 	ctx   context.Context
 	state State
+	store *persistent.PersistentWrapper
+	bs    *bitswap.BlockServiceWrapper
 	ufs   *unixfs.UnixFsWrapper
 	mfsw  *mymfs.MFSWrapper
 }
@@ -77,7 +84,20 @@ func (a *App) setup() error {
 	}
 	a.state = state
 
-	ufs, err := unixfs.New(0, nil)
+	// blocks live next to the state so the saved root can be loaded again
+	a.store, err = persistent.New(persistent.File, blocksPath())
+	if err != nil {
+		return err
+	}
+	a.bs, err = bitswap.NewBlockService(a.ctx, a.store, nil)
+	if err != nil {
+		return err
+	}
+	ipld, err := dag.NewIpldWrapper(a.ctx, a.bs)
+	if err != nil {
+		return err
+	}
+	ufs, err := unixfs.New(0, ipld)
 	if err != nil {
 		return err
 	}
@@ -100,6 +120,17 @@ func (a *App) setup() error {
 	return nil
 }
 
+func (a *App) Close() error {
+	var errs []error
+	if a.bs != nil {
+		errs = append(errs, a.bs.Close())
+	}
+	if a.store != nil {
+		errs = append(errs, a.store.Close())
+	}
+	return errors.Join(errs...)
+}
+
 func (a *App) commitAndPrint() error {
 	c, err := a.mfsw.SnapshotCID(a.ctx)
 	if err != nil {
@@ -114,7 +145,9 @@ func (a *App) commitAndPrint() error {
 }
 
 type CommandOptions struct {
-	writeAppend bool
+	writeAppend  bool
+	mkdirParents bool
+	rmRecursive  bool
 }
 
 type CommandHandler func(*App, *CommandOptions, []string) error
@@ -125,15 +158,21 @@ func wrapCommand(handler CommandHandler) func(*cobra.Command, []string) {
 		must(err)
 
 		opts := &CommandOptions{
-			writeAppend: writeAppend,
+			writeAppend:  writeAppend,
+			mkdirParents: mkdirParents,
+			rmRecursive:  rmRecursive,
 		}
 
 		err = handler(app, opts, args)
-		must(err)
+		must(errors.Join(err, app.Close()))
 	}
 }
 
-var writeAppend bool
+var (
+	writeAppend  bool
+	mkdirParents bool
+	rmRecursive  bool
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "mfs-mini",
@@ -198,23 +237,26 @@ var catCmd = &cobra.Command{
 }
 
 var putCmd = &cobra.Command{
-	Use:   "put <src-file> <dst-path-in-mfs>",
-	Short: "Put local file into MFS",
+	Use:   "put <src-path> <dst-path-in-mfs>",
+	Short: "Put a local file or directory into MFS, replacing dst",
 	Args:  cobra.ExactArgs(2),
 	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
-		src := args[0]
-		dst := mymfs.NormPath(args[1])
-		b, err := os.ReadFile(src)
-		if err != nil {
-			return err
-		}
-		if err := app.mfsw.WriteBytes(app.ctx, dst, b, true); err != nil {
+		if _, err := app.mfsw.ImportPath(app.ctx, args[0], args[1]); err != nil {
 			return err
 		}
 		return app.commitAndPrint()
 	}),
 }
 
+var getCmd = &cobra.Command{
+	Use:   "get <path-in-mfs> <dst-path>",
+	Short: "Write an MFS file or directory to the local disk",
+	Args:  cobra.ExactArgs(2),
+	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
+		return app.mfsw.ExportPath(app.ctx, args[0], args[1])
+	}),
+}
+
 var writeCmd = &cobra.Command{
 	Use:   "write <dst-path-in-mfs> <string>...",
 	Short: "Write a string to a file (use --append to append)",
@@ -223,15 +265,11 @@ var writeCmd = &cobra.Command{
 		dst := mymfs.NormPath(args[0])
 		payload := []byte(strings.Join(args[1:], " "))
 
+		write := func() error { return app.mfsw.WriteBytes(app.ctx, dst, payload, true) }
 		if opts.writeAppend {
-			old, err := app.mfsw.ReadBytes(app.ctx, dst)
-			if err != nil && !errors.Is(err, os.ErrNotExist) {
-				return err
-			}
-			payload = append(old, payload...)
+			write = func() error { return app.mfsw.Append(app.ctx, dst, payload) }
 		}
-
-		if err := app.mfsw.WriteBytes(app.ctx, dst, payload, !opts.writeAppend); err != nil {
+		if err := write(); err != nil {
 			return err
 		}
 		return app.commitAndPrint()
@@ -240,10 +278,22 @@ var writeCmd = &cobra.Command{
 
 var mkdirCmd = &cobra.Command{
 	Use:   "mkdir <path>",
-	Short: "Create directory (parents=true)",
+	Short: "Create directory (use -p to create parents)",
 	Args:  cobra.ExactArgs(1),
 	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
-		if err := mfs.Mkdir(app.mfsw.Root(), mymfs.NormPath(args[0]), mfs.MkdirOpts{Mkparents: true}); err != nil {
+		if err := app.mfsw.Mkdir(app.ctx, args[0], mfs.MkdirOpts{Mkparents: opts.mkdirParents}); err != nil {
+			return err
+		}
+		return app.commitAndPrint()
+	}),
+}
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <src> <dst>",
+	Short: "Copy an MFS path or /ipfs/<cid>[/path] to dst",
+	Args:  cobra.ExactArgs(2),
+	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
+		if err := app.mfsw.Copy(app.ctx, args[0], args[1]); err != nil {
 			return err
 		}
 		return app.commitAndPrint()
@@ -264,16 +314,79 @@ var mvCmd = &cobra.Command{
 
 var rmCmd = &cobra.Command{
 	Use:   "rm <path>",
-	Short: "Remove a file or directory",
+	Short: "Remove a file or empty directory (use -r for directories)",
 	Args:  cobra.ExactArgs(1),
 	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
-		if err := app.mfsw.Remove(app.ctx, mymfs.NormPath(args[0])); err != nil {
+		remove := app.mfsw.Remove
+		if opts.rmRecursive {
+			remove = app.mfsw.RemoveAll
+		}
+		if err := remove(app.ctx, mymfs.NormPath(args[0])); err != nil {
 			return err
 		}
 		return app.commitAndPrint()
 	}),
 }
 
+var statCmd = &cobra.Command{
+	Use:   "stat <path>",
+	Short: "Show type, size and CID of a path",
+	Args:  cobra.ExactArgs(1),
+	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
+		st, err := app.mfsw.StatPath(app.ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(st.Cid)
+		fmt.Printf("Type: %s\n", st.Type)
+		fmt.Printf("Size: %d\n", st.Size)
+		fmt.Printf("CumulativeSize: %d\n", st.CumulativeSize)
+		fmt.Printf("ChildBlocks: %d\n", st.Blocks)
+		if st.Mode != 0 {
+			fmt.Printf("Mode: %s\n", st.Mode)
+		}
+		if !st.ModTime.IsZero() {
+			fmt.Printf("Mtime: %s\n", st.ModTime.Format(time.RFC3339))
+		}
+		return nil
+	}),
+}
+
+var duCmd = &cobra.Command{
+	Use:   "du [path]",
+	Short: "Show bytes stored under a path and each of its entries",
+	Args:  cobra.MaximumNArgs(1),
+	Run: wrapCommand(func(app *App, opts *CommandOptions, args []string) error {
+		p := "/"
+		if len(args) == 1 {
+			p = mymfs.NormPath(args[0])
+		}
+		fsn, err := mfs.Lookup(app.mfsw.Root(), p)
+		if err != nil {
+			return err
+		}
+		if dir, ok := fsn.(*mfs.Directory); ok {
+			names, err := dir.ListNames(app.ctx)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				size, err := app.mfsw.DiskUsage(app.ctx, path.Join(p, name))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%d\t%s\n", size, path.Join(p, name))
+			}
+		}
+		total, err := app.mfsw.DiskUsage(app.ctx, p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d\t%s\n", total, p)
+		return nil
+	}),
+}
+
 var chmodCmd = &cobra.Command{
 	Use:   "chmod <octal> <path>",
 	Short: "Change file mode (e.g. 0644)",
@@ -358,16 +471,22 @@ var importCmd = &cobra.Command{
 
 func init() {
 	writeCmd.Flags().BoolVar(&writeAppend, "append", false, "append instead of truncate")
+	mkdirCmd.Flags().BoolVarP(&mkdirParents, "parents", "p", false, "create missing parent directories")
+	rmCmd.Flags().BoolVarP(&rmRecursive, "recursive", "r", false, "remove directories and their contents")
 
 	rootCmd.AddCommand(
 		initCmd,
 		lsCmd,
 		catCmd,
 		putCmd,
+		getCmd,
 		writeCmd,
 		mkdirCmd,
+		cpCmd,
 		mvCmd,
 		rmCmd,
+		statCmd,
+		duCmd,
 		chmodCmd,
 		touchCmd,
 		snapshotCmd,
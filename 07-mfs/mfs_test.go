@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"path/filepath"
@@ -105,3 +106,187 @@ func TestMFSCAR(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte("day 1"), got)
 }
+
+func TestMFSCopyRemoveStat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	m, err := mfs.New(ctx, nil, cid.Undef)
+	require.NoError(t, err)
+
+	require.NoError(t, m.WriteBytes(ctx, "/src/a.txt", []byte("hello"), true))
+
+	// MFS source into a directory, creating parents
+	require.NoError(t, m.Copy(ctx, "/src/a.txt", "/dst/sub/"))
+	got, err := m.ReadBytes(ctx, "/dst/sub/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), got)
+	require.Error(t, m.Copy(ctx, "/src/a.txt", "/dst/sub/a.txt"), "dst exists")
+
+	// /ipfs source, with a path below the CID
+	snap, err := m.SnapshotCID(ctx)
+	require.NoError(t, err)
+	require.NoError(t, m.Copy(ctx, "/ipfs/"+snap.String()+"/src", "/copy"))
+	got, err = m.ReadBytes(ctx, "/copy/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), got)
+
+	st, err := m.StatPath(ctx, "/copy/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "file", st.Type)
+	require.EqualValues(t, 5, st.Size)
+	st, err = m.StatPath(ctx, "/copy")
+	require.NoError(t, err)
+	require.Equal(t, "directory", st.Type)
+	require.Equal(t, 1, st.Blocks)
+
+	// a.txt is stored once however many paths point at it
+	fileUsage, err := m.DiskUsage(ctx, "/src/a.txt")
+	require.NoError(t, err)
+	srcUsage, err := m.DiskUsage(ctx, "/src")
+	require.NoError(t, err)
+	rootUsage, err := m.DiskUsage(ctx, "/")
+	require.NoError(t, err)
+	require.Greater(t, srcUsage, fileUsage)
+	rootStat, err := m.StatPath(ctx, "/")
+	require.NoError(t, err)
+	require.Less(t, rootUsage, rootStat.CumulativeSize)
+
+	require.Error(t, m.Remove(ctx, "/dst"), "not empty")
+	require.NoError(t, m.RemoveAll(ctx, "/dst"))
+	require.NoError(t, m.Remove(ctx, "/copy/a.txt"))
+	require.NoError(t, m.Remove(ctx, "/copy"))
+	require.Error(t, m.RemoveAll(ctx, "/"))
+
+	_, err = m.StatPath(ctx, "/dst")
+	require.Error(t, err)
+}
+
+func TestMFSPartialWrites(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	m, err := mfs.New(ctx, nil, cid.Undef)
+	require.NoError(t, err)
+
+	const chunk = 256 * 1024
+	data := bytes.Repeat([]byte("abcdefgh"), 4*chunk/8) // four chunks
+	require.NoError(t, m.WriteBytes(ctx, "/big.bin", data, true))
+	leaves := func() []cid.Cid {
+		nd, err := m.FlushPath(ctx, "/big.bin")
+		require.NoError(t, err)
+		var out []cid.Cid
+		for _, l := range nd.Links() {
+			out = append(out, l.Cid)
+		}
+		return out
+	}
+	before := leaves()
+	require.Len(t, before, 4)
+
+	// overwrite a few bytes inside the third chunk
+	require.NoError(t, m.WriteAt(ctx, "/big.bin", []byte("XYZ"), 2*chunk+10))
+	copy(data[2*chunk+10:], "XYZ")
+	got, err := m.ReadBytes(ctx, "/big.bin")
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	after := leaves()
+	require.Len(t, after, 4)
+	require.Equal(t, before[0], after[0])
+	require.Equal(t, before[1], after[1])
+	require.NotEqual(t, before[2], after[2])
+	require.Equal(t, before[3], after[3])
+
+	// append keeps every existing chunk
+	require.NoError(t, m.Append(ctx, "/big.bin", []byte("tail")))
+	got, err = m.ReadBytes(ctx, "/big.bin")
+	require.NoError(t, err)
+	require.Equal(t, append(data, "tail"...), got)
+	require.Equal(t, after, leaves()[:4])
+
+	require.NoError(t, m.Truncate(ctx, "/big.bin", chunk+5))
+	got, err = m.ReadBytes(ctx, "/big.bin")
+	require.NoError(t, err)
+	require.Equal(t, data[:chunk+5], got)
+	require.Equal(t, before[0], leaves()[0])
+
+	// new files, writes past the end and growing truncates
+	require.NoError(t, m.Append(ctx, "/logs/app.log", []byte("one\n")))
+	require.NoError(t, m.Append(ctx, "/logs/app.log", []byte("two\n")))
+	got, err = m.ReadBytes(ctx, "/logs/app.log")
+	require.NoError(t, err)
+	require.Equal(t, []byte("one\ntwo\n"), got)
+
+	require.NoError(t, m.WriteAt(ctx, "/sparse", []byte("x"), 3))
+	got, err = m.ReadBytes(ctx, "/sparse")
+	require.NoError(t, err)
+	require.Equal(t, []byte("\x00\x00\x00x"), got)
+
+	require.NoError(t, m.Truncate(ctx, "/sparse", 6))
+	got, err = m.ReadBytes(ctx, "/sparse")
+	require.NoError(t, err)
+	require.Equal(t, []byte("\x00\x00\x00x\x00\x00"), got)
+
+	require.Error(t, m.Truncate(ctx, "/missing", 1))
+	require.Error(t, m.WriteAt(ctx, "/logs", []byte("x"), 0), "a directory")
+}
+
+func TestMFSImportExportPath(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("b"), 0o644))
+
+	m, err := mfs.New(ctx, nil, cid.Undef)
+	require.NoError(t, err)
+	require.NoError(t, m.WriteBytes(ctx, "/keep.txt", []byte("keep"), true))
+
+	// into a new location, next to existing content
+	_, err = m.ImportPath(ctx, src, "/projects/site")
+	require.NoError(t, err)
+	got, err := m.ReadBytes(ctx, "/projects/site/sub/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), got)
+	got, err = m.ReadBytes(ctx, "/keep.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("keep"), got)
+
+	// again over the same location, after the local tree changed
+	require.NoError(t, os.Remove(filepath.Join(src, "a.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "c.txt"), []byte("c"), 0o644))
+	_, err = m.ImportPath(ctx, src, "/projects/site")
+	require.NoError(t, err)
+	_, err = m.ReadBytes(ctx, "/projects/site/a.txt")
+	require.Error(t, err)
+
+	// a single file
+	_, err = m.ImportPath(ctx, filepath.Join(src, "c.txt"), "/c.txt")
+	require.NoError(t, err)
+	got, err = m.ReadBytes(ctx, "/c.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), got)
+
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, m.ExportPath(ctx, "/projects/site", dst))
+	b, err := os.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), b)
+	b, err = os.ReadFile(filepath.Join(dst, "c.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), b)
+	_, err = os.Stat(filepath.Join(dst, "a.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// a directory can replace the root
+	_, err = m.ImportPath(ctx, src, "/")
+	require.NoError(t, err)
+	got, err = m.ReadBytes(ctx, "/c.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), got)
+	_, err = m.ReadBytes(ctx, "/keep.txt")
+	require.Error(t, err)
+}
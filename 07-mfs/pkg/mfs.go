@@ -8,10 +8,12 @@ import (
 	"io"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/ipld/merkledag"
+	ufs "github.com/ipfs/boxo/ipld/unixfs"
 	"github.com/ipfs/boxo/mfs"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
@@ -120,12 +122,132 @@ func (m *MFSWrapper) WriteBytes(ctx context.Context, dst string, data []byte, tr
 	return nil
 }
 
+// WriteAt writes data into the file at p from offset on, creating the file
+// and its parents if needed. Only the chunks covering the written range
+// are rebuilt; the rest of the file keeps its blocks. Writing past the
+// end fills the gap with zeros.
+func (m *MFSWrapper) WriteAt(ctx context.Context, p string, data []byte, offset int64) error {
+	return m.withFile(ctx, p, true, func(fd mfs.FileDescriptor) error {
+		_, err := fd.WriteAt(data, offset)
+		return err
+	})
+}
+
+// Append adds data to the end of the file at p, creating it if needed
+func (m *MFSWrapper) Append(ctx context.Context, p string, data []byte) error {
+	return m.withFile(ctx, p, true, func(fd mfs.FileDescriptor) error {
+		if _, err := fd.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		_, err := fd.Write(data)
+		return err
+	})
+}
+
+// Truncate cuts the file at p to size bytes, or extends it with zeros
+func (m *MFSWrapper) Truncate(ctx context.Context, p string, size int64) error {
+	return m.withFile(ctx, p, false, func(fd mfs.FileDescriptor) error {
+		return fd.Truncate(size)
+	})
+}
+
+// withFile runs fn on a write descriptor of the file at p and flushes the
+// change up to the root
+func (m *MFSWrapper) withFile(ctx context.Context, p string, create bool, fn func(mfs.FileDescriptor) error) error {
+	p = NormPath(p)
+	fsn, err := mfs.Lookup(m.root, p)
+	if errors.Is(err, os.ErrNotExist) && create {
+		dirp, _ := path.Split(p)
+		if err := mfs.Mkdir(m.root, NormPath(dirp), mfs.MkdirOpts{Mkparents: true}); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("mkdir parents for %s: %w", p, err)
+		}
+		if err := mfs.PutNode(m.root, p, ufs.EmptyFileNode()); err != nil {
+			return fmt.Errorf("create %s: %w", p, err)
+		}
+		fsn, err = mfs.Lookup(m.root, p)
+	}
+	if err != nil {
+		return err
+	}
+	f, ok := fsn.(*mfs.File)
+	if !ok {
+		return fmt.Errorf("%s is not a file", p)
+	}
+
+	fd, err := f.Open(mfs.Flags{Write: true, Sync: true})
+	if err != nil {
+		return fmt.Errorf("open %s: %w", p, err)
+	}
+	if err := fn(fd); err != nil {
+		return errors.Join(fmt.Errorf("%s: %w", p, err), fd.Close())
+	}
+	return fd.Close()
+}
+
 func (m *MFSWrapper) Move(_ context.Context, src, dst string) error {
 	return mfs.Mv(m.root, NormPath(src), NormPath(dst))
 }
 
-func (m *MFSWrapper) Remove(_ context.Context, target string) error {
+// Copy puts the file or directory at src under dst. src is an MFS path or
+// an IPFS path such as /ipfs/<cid>/docs/a.txt, whose blocks are fetched
+// through the BlockService when they are not local. A dst ending in "/"
+// is the directory to copy into under the source's name. Missing parents
+// of dst are created; an existing dst is not overwritten.
+func (m *MFSWrapper) Copy(ctx context.Context, src, dst string) error {
+	nd, err := m.lookupSource(ctx, src)
+	if err != nil {
+		return fmt.Errorf("cp %s: %w", src, err)
+	}
+	if strings.HasSuffix(dst, "/") {
+		dst = path.Join(dst, path.Base(src))
+	}
+	dst = NormPath(dst)
+
+	dirp, _ := path.Split(dst)
+	if err := mfs.Mkdir(m.root, NormPath(dirp), mfs.MkdirOpts{Mkparents: true}); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("mkdir parents for %s: %w", dst, err)
+	}
+	if err := mfs.PutNode(m.root, dst, nd); err != nil {
+		return fmt.Errorf("mfs.PutNode(%s): %w", dst, err)
+	}
+	return nil
+}
+
+func (m *MFSWrapper) lookupSource(ctx context.Context, src string) (format.Node, error) {
+	rest, ok := strings.CutPrefix(src, "/ipfs/")
+	if !ok {
+		return m.FlushPath(ctx, src)
+	}
+	cidStr, sub, _ := strings.Cut(rest, "/")
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return nil, err
+	}
+	nd, _, inside, err := m.ResolvePath(ctx, c, sub)
+	if err != nil {
+		return nil, err
+	}
+	if len(inside) > 0 {
+		return nil, fmt.Errorf("%s is a value inside a block, not a file or directory", src)
+	}
+	return nd, nil
+}
+
+// Remove deletes a file or an empty directory
+func (m *MFSWrapper) Remove(ctx context.Context, target string) error {
+	return m.remove(ctx, target, false)
+}
+
+// RemoveAll deletes target and, if it is a directory, everything in it
+func (m *MFSWrapper) RemoveAll(ctx context.Context, target string) error {
+	return m.remove(ctx, target, true)
+}
+
+func (m *MFSWrapper) remove(ctx context.Context, target string, recursive bool) error {
 	target = NormPath(target)
+	if target == "/" {
+		return fmt.Errorf("cannot remove the root directory")
+	}
 	dirp, name := path.Split(target)
 	fsn, err := mfs.Lookup(m.root, dirp)
 	if err != nil {
@@ -135,6 +257,21 @@ func (m *MFSWrapper) Remove(_ context.Context, target string) error {
 	if !ok {
 		return fmt.Errorf("%s is not a directory", dirp)
 	}
+	if !recursive {
+		child, err := d.Child(name)
+		if err != nil {
+			return err
+		}
+		if cd, ok := child.(*mfs.Directory); ok {
+			names, err := cd.ListNames(ctx)
+			if err != nil {
+				return err
+			}
+			if len(names) > 0 {
+				return fmt.Errorf("%s: directory not empty", target)
+			}
+		}
+	}
 	return d.Unlink(name)
 }
 
@@ -177,6 +314,68 @@ func (m *MFSWrapper) FlushPath(ctx context.Context, path string) (format.Node, e
 	return mfs.FlushPath(ctx, m.root, NormPath(path))
 }
 
+// PathStat describes a file or directory in MFS
+type PathStat struct {
+	Cid            cid.Cid
+	Type           string // "file", "directory" or "symlink"
+	Size           uint64 // file content size; 0 for directories
+	CumulativeSize uint64 // the node plus everything below it, as link sizes count it
+	Blocks         int    // links of the node itself
+	Mode           os.FileMode
+	ModTime        time.Time
+}
+
+// StatPath reports the type, sizes and metadata of the node at p
+func (m *MFSWrapper) StatPath(ctx context.Context, p string) (*PathStat, error) {
+	nd, err := m.FlushPath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	st := &PathStat{Cid: nd.Cid(), Blocks: len(nd.Links())}
+	if st.CumulativeSize, err = nd.Size(); err != nil {
+		return nil, err
+	}
+
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		st.Type = "file"
+		st.Size = uint64(len(n.RawData()))
+	case *merkledag.ProtoNode:
+		fsn, err := ufs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		switch fsn.Type() {
+		case ufs.TDirectory, ufs.THAMTShard:
+			st.Type = "directory"
+		case ufs.TSymlink:
+			st.Type = "symlink"
+		default:
+			st.Type = "file"
+			st.Size = fsn.FileSize()
+		}
+		st.Mode = fsn.Mode()
+		st.ModTime = fsn.ModTime()
+	default:
+		return nil, fmt.Errorf("%s: unexpected node type %T", p, nd)
+	}
+	return st, nil
+}
+
+// DiskUsage is the size of the distinct blocks under p; blocks shared by
+// several files are counted once
+func (m *MFSWrapper) DiskUsage(ctx context.Context, p string) (uint64, error) {
+	nd, err := m.FlushPath(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	st, err := m.IpldWrapper.Stat(ctx, nd.Cid())
+	if err != nil {
+		return 0, err
+	}
+	return st.Bytes, nil
+}
+
 func (m *MFSWrapper) SnapshotCID(ctx context.Context) (cid.Cid, error) {
 	nd, err := m.FlushPath(ctx, "/")
 	if err != nil {
@@ -185,6 +384,16 @@ func (m *MFSWrapper) SnapshotCID(ctx context.Context) (cid.Cid, error) {
 	return nd.Cid(), nil
 }
 
+// GCRoots returns the flushed root directory, so a garbage collector
+// keeps everything reachable in MFS (see 08-pin-gc RootSource)
+func (m *MFSWrapper) GCRoots(ctx context.Context) ([]cid.Cid, error) {
+	root, err := m.SnapshotCID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []cid.Cid{root}, nil
+}
+
 func (m *MFSWrapper) ExportCAR(ctx context.Context, ws io.WriteSeeker) error {
 	root, err := m.SnapshotCID(ctx)
 	if err != nil {
@@ -220,3 +429,56 @@ func (m *MFSWrapper) ImportCAR(ctx context.Context, r io.Reader, choose func([]c
 	m.root = newRoot
 	return m.SnapshotCID(ctx)
 }
+
+// ImportPath adds the local file or directory tree at localPath to MFS at
+// mfsPath, replacing whatever was there and creating missing parents.
+// Importing into "/" replaces the whole root with a local directory.
+func (m *MFSWrapper) ImportPath(ctx context.Context, localPath, mfsPath string) (cid.Cid, error) {
+	c, err := m.PutPath(ctx, localPath)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("import %s: %w", localPath, err)
+	}
+	nd, err := m.IpldWrapper.Get(ctx, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	mfsPath = NormPath(mfsPath)
+	if mfsPath == "/" {
+		pn, ok := nd.(*merkledag.ProtoNode)
+		if !ok {
+			return cid.Undef, fmt.Errorf("%s is not a directory and cannot be the root", localPath)
+		}
+		newRoot, err := mfs.NewRoot(ctx, m.IpldWrapper, pn, dummypf, nil)
+		if err != nil {
+			return cid.Undef, err
+		}
+		m.root = newRoot
+		return c, nil
+	}
+
+	dirp, _ := path.Split(mfsPath)
+	if err := mfs.Mkdir(m.root, NormPath(dirp), mfs.MkdirOpts{Mkparents: true}); err != nil && !errors.Is(err, os.ErrExist) {
+		return cid.Undef, fmt.Errorf("mkdir parents for %s: %w", mfsPath, err)
+	}
+	if err := m.RemoveAll(ctx, mfsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cid.Undef, fmt.Errorf("replace %s: %w", mfsPath, err)
+	}
+	if err := mfs.PutNode(m.root, mfsPath, nd); err != nil {
+		return cid.Undef, fmt.Errorf("mfs.PutNode(%s): %w", mfsPath, err)
+	}
+	return c, nil
+}
+
+// ExportPath writes the file or directory at mfsPath to localPath on disk,
+// creating directories as needed and overwriting files that exist
+func (m *MFSWrapper) ExportPath(ctx context.Context, mfsPath, localPath string) error {
+	nd, err := m.FlushPath(ctx, mfsPath)
+	if err != nil {
+		return err
+	}
+	if err := m.GetPath(ctx, nd.Cid(), localPath); err != nil {
+		return fmt.Errorf("export %s: %w", mfsPath, err)
+	}
+	return nil
+}
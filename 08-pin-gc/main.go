@@ -63,13 +63,22 @@ func main() {
 		log.Printf("Failed to list pins: %v", err)
 	} else {
 		for _, pinInfo := range pins {
-			fmt.Printf("   📌 %s (%s) - %s\n",
+			fmt.Printf("   📌 %s (%s) - %s, pinned %s\n",
 				pinInfo.CID.String()[:20]+"...",
 				pinInfo.Type.String(),
-				pinInfo.Name)
+				pinInfo.Name,
+				pinInfo.CreatedAt.Format(time.TimeOnly))
 		}
 	}
 
+	// Only the pins themselves, without the children of recursive pins
+	roots, err := pinManager.ListPins(ctx, pin.DirectPin, pin.RecursivePin)
+	if err != nil {
+		log.Printf("Failed to list pins: %v", err)
+	} else {
+		fmt.Printf("   %d of %d pins are direct or recursive\n", len(roots), len(pins))
+	}
+
 	// Demo 3: Check pin status
 	fmt.Println("\n3. Checking pin status:")
 	for i, c := range cids {
@@ -99,7 +108,15 @@ func main() {
 
 	// Demo 5: Run garbage collection
 	fmt.Println("\n5. Running garbage collection:")
-	gcResult, err := pinManager.RunGC(ctx)
+	dryRun, err := pinManager.RunGCWithOptions(ctx, pin.GCOptions{DryRun: true})
+	if err != nil {
+		log.Printf("Failed to run GC dry run: %v", err)
+	} else {
+		fmt.Printf("   Dry run: would delete %d blocks (%.2f KB)\n",
+			dryRun.DeletedBlocks, float64(dryRun.ReclaimedBytes)/1024)
+	}
+
+	gcResult, err := pinManager.RunGCWithOptions(ctx, pin.GCOptions{SweepRate: 1000})
 	if err != nil {
 		log.Printf("Failed to run GC: %v", err)
 	} else {
@@ -241,6 +258,7 @@ func printStats(stats *pin.PinStats) {
 		fmt.Printf("      Last GC: %s\n", stats.LastGC.Format("2006-01-02 15:04:05"))
 		fmt.Printf("      Last GC duration: %v\n", stats.GCDuration)
 		fmt.Printf("      Last reclaimed: %.2f KB\n", float64(stats.ReclaimedBytes)/1024)
+		fmt.Printf("      Last GC pauses: %v total, %v max\n", stats.GCPauseTotal, stats.GCPauseMax)
 	} else {
 		fmt.Printf("      Last GC: Never run\n")
 	}
@@ -252,7 +270,8 @@ func printGCResult(result *pin.GCResult) {
 	fmt.Printf("      Blocks after: %d\n", result.BlocksAfter)
 	fmt.Printf("      Deleted blocks: %d\n", result.DeletedBlocks)
 	fmt.Printf("      Reclaimed space: %.2f KB\n", float64(result.ReclaimedBytes)/1024)
-	fmt.Printf("      Duration: %v\n", result.Duration)
+	fmt.Printf("      Duration: %v (mark %v, sweep %v)\n", result.Duration, result.MarkDuration, result.SweepDuration)
+	fmt.Printf("      Pauses: %d (%v total, %v max)\n", result.Pauses, result.PauseTotal, result.PauseMax)
 	fmt.Printf("      Pinned blocks: %d\n", result.PinnedBlocks)
 
 	if result.BlocksBefore > 0 {
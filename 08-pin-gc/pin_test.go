@@ -2,14 +2,27 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
+	unixfs "github.com/gosuda/boxo-starter-kit/06-unixfs-car/pkg"
+	mfs "github.com/gosuda/boxo-starter-kit/07-mfs/pkg"
 	pin "github.com/gosuda/boxo-starter-kit/08-pin-gc/pkg"
+	ipns "github.com/gosuda/boxo-starter-kit/09-ipns/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
 )
 
 func TestPinWrapper(t *testing.T) {
@@ -300,8 +313,11 @@ func TestPinManager(t *testing.T) {
 		require.NoError(t, err)
 		assert.True(t, exists, "Pinned content should survive GC")
 
-		// Unpinned content might be garbage collected
-		// (We can't guarantee it will be GC'd immediately due to indirect references)
+		// Unpinned content is collected
+		exists, err = dagWrapper.BlockServiceWrapper.HasBlock(ctx, unpinnedCID)
+		require.NoError(t, err)
+		assert.False(t, exists, "Unpinned content should be garbage collected")
+		assert.Greater(t, result.DeletedBlocks, int64(0))
 	})
 
 	t.Run("Statistics", func(t *testing.T) {
@@ -384,3 +400,582 @@ func TestErrorHandling(t *testing.T) {
 		assert.Contains(t, err.Error(), "is not pinned")
 	})
 }
+
+func TestPinManagerPinsWrites(t *testing.T) {
+	ctx := context.Background()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	pm, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+	dagWrapper.SetPinner(pm)
+
+	leaf, err := dagWrapper.PutAny(ctx, map[string]any{"name": "leaf"})
+	require.NoError(t, err)
+	root, err := dagWrapper.PutAny(ctx, map[string]any{"child": leaf})
+	require.NoError(t, err)
+
+	pinType, err := pm.GetPinType(ctx, root)
+	require.NoError(t, err)
+	assert.Equal(t, pin.RecursivePin, pinType)
+
+	// pinning an already pinned root again is not an error
+	require.NoError(t, pm.PinRoot(ctx, root))
+
+	_, err = pm.RunGC(ctx)
+	require.NoError(t, err)
+}
+
+func TestPinPersistence(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer store.Close()
+	bs, err := bitswap.NewBlockService(ctx, store, nil)
+	require.NoError(t, err)
+	dagWrapper, err := dag.NewIpldWrapper(ctx, bs)
+	require.NoError(t, err)
+
+	// Two roots sharing a leaf, and a loose block
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	rootA := merkledag.NodeWithData([]byte("a"))
+	require.NoError(t, rootA.AddNodeLink("leaf", leaf))
+	rootB := merkledag.NodeWithData([]byte("b"))
+	require.NoError(t, rootB.AddNodeLink("leaf", leaf))
+	loose := merkledag.NewRawNode([]byte("loose"))
+	for _, n := range []format.Node{leaf, rootA, rootB, loose} {
+		_, err := dagWrapper.PutNode(ctx, n)
+		require.NoError(t, err)
+	}
+
+	// Pins a PinnerWrapper stored in the old format
+	legacy, err := pin.NewPinnerWrapper(ctx, dagWrapper)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Pin(ctx, rootA, true, "site"))
+	require.NoError(t, legacy.Pin(ctx, loose, false, "note"))
+	require.NoError(t, legacy.Flush(ctx))
+
+	pm, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+
+	t.Run("Migration", func(t *testing.T) {
+		pins, err := pm.ListPins(ctx, pin.RecursivePin, pin.DirectPin)
+		require.NoError(t, err)
+		require.Len(t, pins, 2)
+		for _, p := range pins {
+			assert.False(t, p.CreatedAt.IsZero())
+			switch {
+			case p.CID.Equals(rootA.Cid()):
+				assert.Equal(t, pin.RecursivePin, p.Type)
+				assert.Equal(t, "site", p.Name)
+			case p.CID.Equals(loose.Cid()):
+				assert.Equal(t, pin.DirectPin, p.Type)
+				assert.Equal(t, "note", p.Name)
+			default:
+				t.Errorf("unexpected pin %s", p.CID)
+			}
+		}
+
+		pinType, err := pm.GetPinType(ctx, leaf.Cid())
+		require.NoError(t, err)
+		assert.Equal(t, pin.IndirectPin, pinType)
+	})
+
+	t.Run("Indirect Tracking", func(t *testing.T) {
+		require.NoError(t, pm.Pin(ctx, rootB.Cid(), pin.PinOptions{Name: "mirror", Recursive: true}))
+
+		indirect, err := pm.ListPins(ctx, pin.IndirectPin)
+		require.NoError(t, err)
+		require.Len(t, indirect, 1)
+		assert.True(t, indirect[0].CID.Equals(leaf.Cid()))
+		assert.ElementsMatch(t, []cid.Cid{rootA.Cid(), rootB.Cid()}, indirect[0].PinnedBy)
+
+		// The leaf stays pinned while one of its roots is
+		require.NoError(t, pm.Unpin(ctx, rootA.Cid(), true))
+		indirect, err = pm.ListPins(ctx, pin.IndirectPin)
+		require.NoError(t, err)
+		require.Len(t, indirect, 1)
+		assert.Equal(t, []cid.Cid{rootB.Cid()}, indirect[0].PinnedBy)
+
+		require.NoError(t, pm.Unpin(ctx, rootB.Cid(), true))
+		pinned, err := pm.IsPinned(ctx, leaf.Cid())
+		require.NoError(t, err)
+		assert.False(t, pinned)
+	})
+
+	t.Run("Direct Becomes Recursive", func(t *testing.T) {
+		require.NoError(t, pm.Pin(ctx, loose.Cid(), pin.PinOptions{Recursive: true}))
+
+		pins, err := pm.ListPins(ctx, pin.DirectPin)
+		require.NoError(t, err)
+		assert.Empty(t, pins)
+		pins, err = pm.ListPins(ctx, pin.RecursivePin)
+		require.NoError(t, err)
+		require.Len(t, pins, 1)
+		assert.Equal(t, "note", pins[0].Name)
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		before, err := pm.ListPins(ctx)
+		require.NoError(t, err)
+		require.NoError(t, pm.Close())
+
+		// The pins come back as stored; the migration does not run again,
+		// so rootA stays unpinned although the old format still lists it
+		reopened, err := pin.NewPinManager(dagWrapper)
+		require.NoError(t, err)
+		defer reopened.Close()
+		after, err := reopened.ListPins(ctx)
+		require.NoError(t, err)
+		require.Len(t, after, 1)
+		assert.True(t, after[0].CID.Equals(loose.Cid()))
+		assert.Equal(t, pin.RecursivePin, after[0].Type)
+		assert.Equal(t, "note", after[0].Name)
+		assert.True(t, before[0].CreatedAt.Equal(after[0].CreatedAt))
+	})
+}
+
+func TestPinUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer store.Close()
+	bs, err := bitswap.NewBlockService(ctx, store, nil)
+	require.NoError(t, err)
+	dagWrapper, err := dag.NewIpldWrapper(ctx, bs)
+	require.NoError(t, err)
+
+	put := func(n format.Node) cid.Cid {
+		c, err := dagWrapper.PutNode(ctx, n)
+		require.NoError(t, err)
+		return c
+	}
+
+	// Two versions of a site sharing its assets
+	assets := merkledag.NodeWithData(nil)
+	var assetCids []cid.Cid
+	for i := range 20 {
+		asset := merkledag.NewRawNode([]byte{byte(i)})
+		assetCids = append(assetCids, put(asset))
+		require.NoError(t, assets.AddNodeLink(fmt.Sprintf("asset%d", i), asset))
+	}
+	put(assets)
+	site := func(page string) (cid.Cid, cid.Cid) {
+		index := merkledag.NewRawNode([]byte(page))
+		root := merkledag.NodeWithData(nil)
+		require.NoError(t, root.AddNodeLink("index.html", index))
+		require.NoError(t, root.AddNodeLink("assets", assets))
+		return put(root), put(index)
+	}
+	v1, index1 := site("v1")
+	v2, index2 := site("v2")
+
+	pm, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+	require.NoError(t, pm.Pin(ctx, v1, pin.PinOptions{Name: "site", Recursive: true}))
+
+	reads := func() int64 {
+		return store.GetMetrics()[persistent.OpGet].Metrics.TotalRequests
+	}
+	before := reads()
+	require.NoError(t, pm.PinUpdate(ctx, v1, v2))
+	// The new root (checked, then walked) and the new page; none of the
+	// 21 shared blocks
+	assert.LessOrEqual(t, reads()-before, int64(3))
+
+	pinType, err := pm.GetPinType(ctx, v2)
+	require.NoError(t, err)
+	assert.Equal(t, pin.RecursivePin, pinType)
+	for _, c := range append([]cid.Cid{index2, assets.Cid()}, assetCids...) {
+		pinType, err := pm.GetPinType(ctx, c)
+		require.NoError(t, err)
+		assert.Equal(t, pin.IndirectPin, pinType)
+	}
+	for _, c := range []cid.Cid{v1, index1} {
+		pinned, err := pm.IsPinned(ctx, c)
+		require.NoError(t, err)
+		assert.False(t, pinned, "old version should be unpinned")
+	}
+
+	pins, err := pm.ListPins(ctx, pin.RecursivePin)
+	require.NoError(t, err)
+	require.Len(t, pins, 1)
+	assert.Equal(t, "site", pins[0].Name)
+
+	// The update is stored
+	require.NoError(t, pm.Close())
+	reopened, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+	defer reopened.Close()
+	pins, err = reopened.ListPins(ctx, pin.RecursivePin)
+	require.NoError(t, err)
+	require.Len(t, pins, 1)
+	assert.True(t, pins[0].CID.Equals(v2))
+
+	t.Run("Old Root Not Pinned", func(t *testing.T) {
+		err := reopened.PinUpdate(ctx, v1, v2)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "not pinned recursively")
+	})
+}
+
+// rootsFunc is a RootSource backed by a function
+type rootsFunc func() []cid.Cid
+
+func (f rootsFunc) GCRoots(context.Context) ([]cid.Cid, error) { return f(), nil }
+
+var (
+	_ pin.RootSource = (*mfs.MFSWrapper)(nil)
+	_ pin.RootSource = (*ipns.IPNSManager)(nil)
+)
+
+func TestGarbageCollection(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer store.Close()
+	bs, err := bitswap.NewBlockService(ctx, store, nil)
+	require.NoError(t, err)
+	dagWrapper, err := dag.NewIpldWrapper(ctx, bs)
+	require.NoError(t, err)
+
+	put := func(n format.Node) cid.Cid {
+		c, err := dagWrapper.PutNode(ctx, n)
+		require.NoError(t, err)
+		return c
+	}
+	has := func(c cid.Cid) bool {
+		ok, err := dagWrapper.BlockServiceWrapper.HasBlock(ctx, c)
+		require.NoError(t, err)
+		return ok
+	}
+
+	// A recursively pinned tree, a directly pinned node whose child is
+	// only reachable from a root source, and garbage
+	pinnedLeaf := merkledag.NewRawNode([]byte("pinned leaf"))
+	pinnedRoot := merkledag.NodeWithData([]byte("pinned"))
+	require.NoError(t, pinnedRoot.AddNodeLink("leaf", pinnedLeaf))
+	put(pinnedLeaf)
+	put(pinnedRoot)
+
+	sourceLeaf := merkledag.NewRawNode([]byte("source leaf"))
+	direct := merkledag.NodeWithData([]byte("direct"))
+	require.NoError(t, direct.AddNodeLink("leaf", sourceLeaf))
+	sourceRoot := merkledag.NodeWithData([]byte("source"))
+	require.NoError(t, sourceRoot.AddNodeLink("direct", direct))
+	put(sourceLeaf)
+	put(direct)
+	put(sourceRoot)
+
+	var garbage []cid.Cid
+	var garbageBytes int64
+	for i := range 10 {
+		n := merkledag.NewRawNode(fmt.Appendf(nil, "garbage %d", i))
+		garbage = append(garbage, put(n))
+		garbageBytes += int64(len(n.RawData()))
+	}
+
+	pm, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+	defer pm.Close()
+	require.NoError(t, pm.Pin(ctx, pinnedRoot.Cid(), pin.PinOptions{Recursive: true}))
+	require.NoError(t, pm.Pin(ctx, direct.Cid(), pin.PinOptions{}))
+	pm.AddRootSource(rootsFunc(func() []cid.Cid { return []cid.Cid{sourceRoot.Cid()} }))
+
+	t.Run("Dry Run", func(t *testing.T) {
+		result, err := pm.RunGCWithOptions(ctx, pin.GCOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.True(t, result.DryRun)
+		assert.Equal(t, int64(len(garbage)), result.DeletedBlocks)
+		assert.Equal(t, garbageBytes, result.ReclaimedBytes)
+		assert.Equal(t, result.BlocksBefore-result.DeletedBlocks, result.BlocksAfter)
+		for _, c := range garbage {
+			assert.True(t, has(c), "dry run should delete nothing")
+		}
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		result, err := pm.RunGCWithOptions(ctx, pin.GCOptions{SweepRate: 1000, SweepBatch: 3})
+		require.NoError(t, err)
+		assert.False(t, result.DryRun)
+		assert.Equal(t, int64(len(garbage)), result.DeletedBlocks)
+		assert.Equal(t, garbageBytes, result.ReclaimedBytes)
+		assert.Equal(t, int64(5), result.MarkedBlocks)
+		assert.Equal(t, 4, result.Pauses) // 10 blocks, 3 per batch
+		assert.Greater(t, result.PauseMax, time.Duration(0))
+		assert.LessOrEqual(t, result.PauseMax, result.PauseTotal)
+
+		for _, c := range garbage {
+			assert.False(t, has(c), "garbage should be deleted")
+		}
+		for _, c := range []cid.Cid{pinnedRoot.Cid(), pinnedLeaf.Cid(), direct.Cid(), sourceLeaf.Cid(), sourceRoot.Cid()} {
+			assert.True(t, has(c), "%s should survive", c)
+		}
+
+		stats, err := pm.GetStats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, result.PauseMax, stats.GCPauseMax)
+		assert.Equal(t, garbageBytes, stats.ReclaimedBytes)
+	})
+
+	t.Run("Nothing Left", func(t *testing.T) {
+		result, err := pm.RunGC(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, result.DeletedBlocks)
+		assert.Zero(t, result.Pauses)
+		assert.Equal(t, result.BlocksBefore, result.BlocksAfter)
+	})
+
+	t.Run("Children Stored After Pinning", func(t *testing.T) {
+		// pinned while the rest of its DAG was missing; it arrives later
+		lateLeaf := merkledag.NewRawNode([]byte("late leaf"))
+		lateMid := merkledag.NodeWithData([]byte("late mid"))
+		require.NoError(t, lateMid.AddNodeLink("leaf", lateLeaf))
+		lateRoot := merkledag.NodeWithData([]byte("late"))
+		require.NoError(t, lateRoot.AddNodeLink("mid", lateMid))
+		put(lateRoot)
+		pinCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		require.NoError(t, pm.Pin(pinCtx, lateRoot.Cid(), pin.PinOptions{Recursive: true}))
+		put(lateMid)
+		put(lateLeaf)
+
+		result, err := pm.RunGC(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, result.DeletedBlocks)
+		assert.True(t, has(lateLeaf.Cid()), "late blocks of a recursive pin should survive")
+	})
+}
+
+// mapFetcher serves the blocks it holds, standing in for the multifetcher
+type mapFetcher map[cid.Cid]blocks.Block
+
+func (f mapFetcher) GetBlock(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	if b, ok := f[c]; ok {
+		return b, nil
+	}
+	return nil, format.ErrNotFound{Cid: c}
+}
+
+func (f mapFetcher) GetBlocks(ctx context.Context, cs []cid.Cid) (<-chan blocks.Block, error) {
+	out := make(chan blocks.Block, len(cs))
+	for _, c := range cs {
+		if b, err := f.GetBlock(ctx, c); err == nil {
+			out <- b
+		}
+	}
+	close(out)
+	return out, nil
+}
+
+func TestPinVerify(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer store.Close()
+	bs, err := bitswap.NewBlockService(ctx, store, nil)
+	require.NoError(t, err)
+	dagWrapper, err := dag.NewIpldWrapper(ctx, bs)
+	require.NoError(t, err)
+
+	missing := merkledag.NewRawNode([]byte("missing"))
+	corrupt := merkledag.NewRawNode([]byte("corrupt"))
+	root := merkledag.NodeWithData([]byte("root"))
+	require.NoError(t, root.AddNodeLink("missing", missing))
+	require.NoError(t, root.AddNodeLink("corrupt", corrupt))
+	lost := merkledag.NewRawNode([]byte("lost"))
+	for _, n := range []format.Node{missing, corrupt, root, lost} {
+		_, err := dagWrapper.PutNode(ctx, n)
+		require.NoError(t, err)
+	}
+
+	pm, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+	defer pm.Close()
+	require.NoError(t, pm.Pin(ctx, root.Cid(), pin.PinOptions{Recursive: true}))
+	require.NoError(t, pm.Pin(ctx, lost.Cid(), pin.PinOptions{}))
+
+	t.Run("Intact", func(t *testing.T) {
+		res := pm.VerifyOnce(ctx, pin.VerifyOptions{})
+		require.NoError(t, res.Err)
+		assert.Equal(t, int64(2), res.Pins)
+		assert.Equal(t, int64(4), res.Blocks)
+		assert.Zero(t, res.Missing+res.Corrupt)
+		assert.Empty(t, res.Broken)
+	})
+
+	// Lose one block, flip the bytes of another, and lose a direct pin
+	// the fetcher cannot find either
+	require.NoError(t, store.Delete(ctx, missing.Cid()))
+	require.NoError(t, store.Delete(ctx, corrupt.Cid()))
+	bad, err := blocks.NewBlockWithCid([]byte("garbage"), corrupt.Cid())
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, bad))
+	require.NoError(t, store.Delete(ctx, lost.Cid()))
+	fetcher := mapFetcher{missing.Cid(): missing, corrupt.Cid(): corrupt}
+
+	t.Run("Refetch", func(t *testing.T) {
+		res := pm.VerifyOnce(ctx, pin.VerifyOptions{Fetcher: fetcher})
+		assert.Equal(t, int64(2), res.Missing)
+		assert.Equal(t, int64(1), res.Corrupt)
+		assert.Equal(t, int64(2), res.Refetched)
+		assert.Equal(t, []cid.Cid{lost.Cid()}, res.Broken)
+
+		for _, n := range []format.Node{missing, corrupt} {
+			data, err := store.GetRaw(ctx, n.Cid())
+			require.NoError(t, err)
+			assert.Equal(t, n.RawData(), data)
+		}
+	})
+
+	t.Run("Health Alert", func(t *testing.T) {
+		vctx, cancel := context.WithCancel(ctx)
+		results := make(chan pin.VerifyResult, 1)
+		v := pm.Verify(vctx, pin.VerifyOptions{
+			Fetcher:  fetcher,
+			OnResult: func(r pin.VerifyResult) { results <- r },
+		})
+		check := v.HealthCheck()
+
+		select {
+		case res := <-results:
+			assert.Zero(t, res.Refetched, "restored blocks stay restored")
+		case <-time.After(5 * time.Second):
+			t.Fatal("no verification pass")
+		}
+		result := check.Check(ctx)
+		assert.Equal(t, health.StatusUnhealthy, result.Status)
+		assert.Contains(t, result.Metadata["broken"], lost.Cid().String())
+
+		cancel()
+		<-v.Done()
+	})
+}
+
+func TestPinCollections(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	defer store.Close()
+	bs, err := bitswap.NewBlockService(ctx, store, nil)
+	require.NoError(t, err)
+	dagWrapper, err := dag.NewIpldWrapper(ctx, bs)
+	require.NoError(t, err)
+
+	put := func(data string) cid.Cid {
+		n := merkledag.NodeWithData([]byte(data))
+		c, err := dagWrapper.PutNode(ctx, n)
+		require.NoError(t, err)
+		return c
+	}
+	v1, v2, v3 := put("site v1"), put("site v2"), put("site v3")
+	dataset := put("dataset")
+
+	pm, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+
+	t.Run("Pin", func(t *testing.T) {
+		col, err := pm.PinCollection(ctx, "website", v1, v2)
+		require.NoError(t, err)
+		assert.Equal(t, []cid.Cid{v1, v2}, col.Roots)
+		_, err = pm.PinCollection(ctx, "datasets", dataset, v2)
+		require.NoError(t, err)
+
+		pinType, err := pm.GetPinType(ctx, v1)
+		require.NoError(t, err)
+		assert.Equal(t, pin.RecursivePin, pinType)
+
+		cols, err := pm.ListCollections(ctx)
+		require.NoError(t, err)
+		require.Len(t, cols, 2)
+		assert.Equal(t, "datasets", cols[0].Name)
+		assert.Equal(t, "website", cols[1].Name)
+
+		_, err = pm.PinCollection(ctx, "bad/name", v1)
+		assert.Error(t, err)
+		missing := merkledag.NodeWithData([]byte("never stored")).Cid()
+		shortCtx, cancel := context.WithTimeout(ctx, time.Second) // or bitswap keeps looking
+		defer cancel()
+		_, err = pm.PinCollection(shortCtx, "website", v3, missing)
+		assert.Error(t, err)
+		pinned, err := pm.IsPinned(ctx, v3)
+		require.NoError(t, err)
+		assert.False(t, pinned, "a missing root should pin none")
+	})
+
+	t.Run("Held Roots", func(t *testing.T) {
+		err := pm.Unpin(ctx, v1, true)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "website")
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		require.NoError(t, pm.PinUpdate(ctx, v1, v3))
+		col, err := pm.GetCollection(ctx, "website")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []cid.Cid{v2, v3}, col.Roots)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "*.car")
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, pm.ExportCollection(ctx, "website", f))
+
+		_, err = f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		imported, err := persistent.New(persistent.Memory, "")
+		require.NoError(t, err)
+		defer imported.Close()
+		roots, err := unixfs.CarImport(ctx, imported, f)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []cid.Cid{v2, v3}, roots)
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		require.NoError(t, pm.Close())
+		pm, err = pin.NewPinManager(dagWrapper)
+		require.NoError(t, err)
+		cols, err := pm.ListCollections(ctx)
+		require.NoError(t, err)
+		require.Len(t, cols, 2)
+		assert.ElementsMatch(t, []cid.Cid{v2, v3}, cols[1].Roots)
+	})
+
+	t.Run("Unpin", func(t *testing.T) {
+		// v2 stays pinned through the other collection
+		require.NoError(t, pm.UnpinCollection(ctx, "website"))
+		_, err := pm.GetCollection(ctx, "website")
+		assert.ErrorIs(t, err, pin.ErrCollectionNotFound)
+		for c, want := range map[cid.Cid]bool{v2: true, v3: false} {
+			pinned, err := pm.IsPinned(ctx, c)
+			require.NoError(t, err)
+			assert.Equal(t, want, pinned, c.String())
+		}
+
+		require.NoError(t, pm.UnpinCollection(ctx, "datasets", v2))
+		col, err := pm.GetCollection(ctx, "datasets")
+		require.NoError(t, err)
+		assert.Equal(t, []cid.Cid{dataset}, col.Roots)
+		pinned, err := pm.IsPinned(ctx, v2)
+		require.NoError(t, err)
+		assert.False(t, pinned)
+	})
+
+	t.Run("Pins Made Elsewhere", func(t *testing.T) {
+		own := put("pinned by hand")
+		require.NoError(t, pm.Pin(ctx, own, pin.PinOptions{Name: "mine", Recursive: true}))
+		_, err := pm.PinCollection(ctx, "backups", own)
+		require.NoError(t, err)
+		require.NoError(t, pm.UnpinCollection(ctx, "backups"))
+
+		pinned, err := pm.IsPinned(ctx, own)
+		require.NoError(t, err)
+		assert.True(t, pinned, "the collection did not pin it")
+	})
+}
@@ -0,0 +1,277 @@
+package pin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	unixfs "github.com/gosuda/boxo-starter-kit/06-unixfs-car/pkg"
+)
+
+// ErrCollectionNotFound is returned for names no collection has
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// Collection is a named group of recursively pinned roots, e.g. the
+// versions of a website or a set of datasets, pinned, listed, exported and
+// unpinned together
+type Collection struct {
+	Name  string    `json:"name"`
+	Roots []cid.Cid `json:"roots"`
+	// Pinned lists the roots pinned on the collection's behalf; only
+	// these are unpinned when the collections let go of them
+	Pinned    []cid.Cid `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *Collection) clone() *Collection {
+	cp := *c
+	cp.Roots = slices.Clone(c.Roots)
+	cp.Pinned = slices.Clone(c.Pinned)
+	return &cp
+}
+
+func (c *Collection) has(root cid.Cid) bool {
+	return slices.ContainsFunc(c.Roots, root.Equals)
+}
+
+func (c *Collection) owns(root cid.Cid) bool {
+	return slices.ContainsFunc(c.Pinned, root.Equals)
+}
+
+// replaceRoot swaps oldRoot for newRoot, dropping it if newRoot is in the
+// collection already. The collection owns newRoot's pin if it owned
+// oldRoot's and movesPin is set.
+func (c *Collection) replaceRoot(oldRoot, newRoot cid.Cid, movesPin bool) {
+	c.Roots = slices.DeleteFunc(c.Roots, oldRoot.Equals)
+	if !c.has(newRoot) {
+		c.Roots = append(c.Roots, newRoot)
+	}
+	if c.owns(oldRoot) {
+		c.Pinned = slices.DeleteFunc(c.Pinned, oldRoot.Equals)
+		if movesPin && !c.owns(newRoot) {
+			c.Pinned = append(c.Pinned, newRoot)
+		}
+	}
+	c.UpdatedAt = time.Now()
+}
+
+// ValidateCollectionName checks that name can name a collection: 1 to 64
+// letters, digits, '-', '_' or '.'
+func ValidateCollectionName(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("collection name must be 1 to 64 characters")
+	}
+	if strings.Trim(name, ".") == "" {
+		return fmt.Errorf("invalid collection name %q", name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("invalid character %q in collection name %q", r, name)
+		}
+	}
+	return nil
+}
+
+// PinCollection pins roots recursively and adds them to the collection
+// name, creating it if needed. Roots pinned before are kept as they are;
+// new pins are named after the collection.
+func (pm *PinManager) PinCollection(ctx context.Context, name string, roots ...cid.Cid) (*Collection, error) {
+	if err := ValidateCollectionName(name); err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		if !root.Defined() {
+			return nil, fmt.Errorf("invalid CID")
+		}
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	now := time.Now()
+	col := &Collection{Name: name, CreatedAt: now}
+	if existing, ok := pm.collections[name]; ok {
+		col = existing.clone()
+	}
+	// Check every root first, so a missing one pins none
+	for _, root := range roots {
+		if _, ok := pm.recursivePins[root]; !ok {
+			if err := pm.checkContent(ctx, root); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, root := range roots {
+		if col.has(root) {
+			continue
+		}
+		if _, ok := pm.recursivePins[root]; !ok {
+			if err := pm.pin(ctx, root, PinOptions{Name: name, Recursive: true}); err != nil {
+				return nil, err
+			}
+			col.Pinned = append(col.Pinned, root)
+		}
+		col.Roots = append(col.Roots, root)
+	}
+	col.UpdatedAt = now
+
+	if pm.store != nil {
+		if err := pm.store.saveCollection(ctx, col); err != nil {
+			return nil, err
+		}
+	}
+	pm.collections[name] = col
+	return col.clone(), nil
+}
+
+// UnpinCollection removes roots from the collection name, or deletes the
+// whole collection if no roots are given. Removed roots the collection
+// pinned itself are unpinned once no other collection holds them; pins
+// made some other way are left alone.
+func (pm *PinManager) UnpinCollection(ctx context.Context, name string, roots ...cid.Cid) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	existing, ok := pm.collections[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	col := existing.clone()
+	removed := col.Roots
+	if len(roots) > 0 {
+		removed = nil
+		for _, root := range roots {
+			if !col.has(root) {
+				return fmt.Errorf("CID %s is not in collection %s", root, name)
+			}
+			col.Roots = slices.DeleteFunc(col.Roots, root.Equals)
+			col.Pinned = slices.DeleteFunc(col.Pinned, root.Equals)
+			removed = append(removed, root)
+		}
+		col.UpdatedAt = time.Now()
+	}
+
+	// A pin the collection made passes to the other collections holding
+	// its root, or is removed if there are none
+	heirs := make(map[string]*Collection)
+	var unpin []cid.Cid
+	for _, root := range removed {
+		if !existing.owns(root) {
+			continue
+		}
+		holders := slices.DeleteFunc(pm.holders(root), func(n string) bool { return n == name })
+		if len(holders) == 0 {
+			unpin = append(unpin, root)
+			continue
+		}
+		for _, holder := range holders {
+			heir, ok := heirs[holder]
+			if !ok {
+				heir = pm.collections[holder].clone()
+				heirs[holder] = heir
+			}
+			if !heir.owns(root) {
+				heir.Pinned = append(heir.Pinned, root)
+			}
+		}
+	}
+
+	if pm.store != nil {
+		var err error
+		if len(roots) == 0 {
+			err = pm.store.deleteCollection(ctx, name)
+		} else {
+			err = pm.store.saveCollection(ctx, col)
+		}
+		if err != nil {
+			return err
+		}
+		for _, heir := range heirs {
+			if err := pm.store.saveCollection(ctx, heir); err != nil {
+				return err
+			}
+		}
+	}
+	if len(roots) == 0 {
+		delete(pm.collections, name)
+	} else {
+		pm.collections[name] = col
+	}
+	for holder, heir := range heirs {
+		pm.collections[holder] = heir
+	}
+
+	for _, root := range unpin {
+		if _, ok := pm.recursivePins[root]; !ok {
+			continue
+		}
+		if err := pm.unsave(ctx, root); err != nil {
+			return err
+		}
+		delete(pm.recursivePins, root)
+		pm.removeIndirect(root)
+	}
+	return nil
+}
+
+// GetCollection returns the collection name
+func (pm *PinManager) GetCollection(ctx context.Context, name string) (*Collection, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	col, ok := pm.collections[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	return col.clone(), nil
+}
+
+// ListCollections returns every collection, sorted by name
+func (pm *PinManager) ListCollections(ctx context.Context) ([]*Collection, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	cols := make([]*Collection, 0, len(pm.collections))
+	for _, col := range pm.collections {
+		cols = append(cols, col.clone())
+	}
+	slices.SortFunc(cols, func(a, b *Collection) int { return strings.Compare(a.Name, b.Name) })
+	return cols, nil
+}
+
+// ExportCollection writes the DAGs of the collection name into one CAR
+// with the collection's roots. w must be an io.WriteSeeker (see
+// unixfs.CarExport).
+func (pm *PinManager) ExportCollection(ctx context.Context, name string, w io.Writer) error {
+	col, err := pm.GetCollection(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(col.Roots) == 0 {
+		return fmt.Errorf("collection %s is empty", name)
+	}
+	return unixfs.CarExport(ctx, pm.dagWrapper, col.Roots, w)
+}
+
+// holders returns the names of the collections holding root, sorted. Must
+// be called with the mutex held.
+func (pm *PinManager) holders(root cid.Cid) []string {
+	var names []string
+	for name, col := range pm.collections {
+		if col.has(root) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,367 @@
+package pin
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/time/rate"
+
+	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
+)
+
+// DefaultSweepBatch is how many blocks one sweep pause deletes at most
+const DefaultSweepBatch = 256
+
+// RootSource adds GC roots besides the pins: RunGC keeps every block
+// reachable from the CIDs it returns. The 07-mfs MFSWrapper (its root
+// directory) and the 09-ipns IPNSManager (the paths its records point to)
+// implement it.
+type RootSource interface {
+	GCRoots(ctx context.Context) ([]cid.Cid, error)
+}
+
+// AddRootSource makes every later collection keep what src refers to
+func (pm *PinManager) AddRootSource(src RootSource) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.rootSources = append(pm.rootSources, src)
+}
+
+// GCOptions tunes RunGCWithOptions; zero values keep the defaults
+type GCOptions struct {
+	DryRun          bool // report what would be deleted, delete nothing
+	SweepRate       int  // blocks deleted per second; 0 means no limit
+	SweepBatch      int  // blocks deleted per pause (default DefaultSweepBatch)
+	MarkConcurrency int  // blocks read at once while marking (default dag.DefaultWalkConcurrency)
+}
+
+// GCResult contains garbage collection results. In a dry run
+// DeletedBlocks, ReclaimedBytes and BlocksAfter tell what a real run
+// would have done.
+type GCResult struct {
+	BlocksBefore   int64         `json:"blocks_before"`
+	BlocksAfter    int64         `json:"blocks_after"`
+	DeletedBlocks  int64         `json:"deleted_blocks"`
+	ReclaimedBytes int64         `json:"reclaimed_bytes"`
+	Duration       time.Duration `json:"duration"`
+	PinnedBlocks   int64         `json:"pinned_blocks"`
+	MarkedBlocks   int64         `json:"marked_blocks"` // reachable from a root source
+	DryRun         bool          `json:"dry_run"`
+
+	MarkDuration  time.Duration `json:"mark_duration"`
+	SweepDuration time.Duration `json:"sweep_duration"`
+
+	// Pauses are the stretches in which writes were held back, one per
+	// sweep batch
+	Pauses     int           `json:"pauses"`
+	PauseTotal time.Duration `json:"pause_total"`
+	PauseMax   time.Duration `json:"pause_max"`
+}
+
+func (r *GCResult) addPause(d time.Duration) {
+	r.Pauses++
+	r.PauseTotal += d
+	r.PauseMax = max(r.PauseMax, d)
+}
+
+// markSet holds the multihashes of the blocks to keep. Blockstores key
+// blocks by multihash, so a CIDv0 pin keeps the block listed as CIDv1.
+type markSet struct {
+	mu sync.Mutex
+	m  map[string]struct{}
+}
+
+// add marks c and reports whether it was not marked yet
+func (s *markSet) add(c cid.Cid) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := string(c.Hash())
+	if _, ok := s.m[k]; ok {
+		return false
+	}
+	s.m[k] = struct{}{}
+	return true
+}
+
+func (s *markSet) has(c cid.Cid) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.m[string(c.Hash())]
+	return ok
+}
+
+func (s *markSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.m)
+}
+
+// RunGC performs garbage collection, removing unpinned blocks
+func (pm *PinManager) RunGC(ctx context.Context) (*GCResult, error) {
+	return pm.RunGCWithOptions(ctx, GCOptions{})
+}
+
+// RunGCWithOptions deletes the stored blocks that are neither pinned nor
+// reachable from a RootSource.
+//
+// The mark phase runs alongside writes: the pins are marked as they are,
+// since their children were found when they were pinned, and the DAGs of
+// the root sources are walked reading several blocks at once. The sweep
+// then deletes the unmarked blocks in batches, at most SweepRate a second.
+// Each batch is a short pause: it waits for writes that have not pinned
+// their roots yet (WriteLock), marks pins and roots that appeared since
+// the mark, and only then deletes. The root sources are asked again before
+// every batch.
+func (pm *PinManager) RunGCWithOptions(ctx context.Context, opts GCOptions) (*GCResult, error) {
+	bs := pm.dagWrapper.BlockServiceWrapper
+	if bs == nil || bs.PersistentWrapper == nil {
+		return nil, fmt.Errorf("no blockstore to collect")
+	}
+	store := bs.PersistentWrapper
+	if opts.SweepBatch <= 0 {
+		opts.SweepBatch = DefaultSweepBatch
+	}
+	if opts.MarkConcurrency <= 0 {
+		opts.MarkConcurrency = dag.DefaultWalkConcurrency
+	}
+
+	pm.gcRun.Lock()
+	defer pm.gcRun.Unlock()
+
+	start := time.Now()
+	result := &GCResult{DryRun: opts.DryRun}
+	// Kept apart: a direct pin keeps its block but not its children,
+	// which a root source may still reach
+	pinned := &markSet{m: make(map[string]struct{})}
+	marks := &markSet{m: make(map[string]struct{})}
+
+	// Only stored blocks are read, so content a root source names but
+	// never fetched does not turn into network requests
+	local := merkledag.NewDAGService(blockservice.New(store.Blockstore, nil))
+
+	// Mark
+	pm.mutex.RLock()
+	result.PinnedBlocks = int64(pm.markPins(pinned))
+	recursive := pm.recursiveRoots()
+	pm.mutex.RUnlock()
+	roots, err := pm.gcRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range append(recursive, roots...) {
+		if err := markDAG(ctx, local, marks, root, opts.MarkConcurrency); err != nil {
+			return nil, fmt.Errorf("mark %s: %w", root, err)
+		}
+	}
+	result.MarkDuration = time.Since(start)
+
+	// Sweep
+	sweepStart := time.Now()
+	keys, err := store.AllKeysChan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list blocks: %w", err)
+	}
+	var candidates []cid.Cid
+	for c := range keys {
+		result.BlocksBefore++
+		if !pinned.has(c) && !marks.has(c) {
+			candidates = append(candidates, c)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var limiter *rate.Limiter
+	if opts.SweepRate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.SweepRate), opts.SweepBatch)
+	}
+	for len(candidates) > 0 {
+		batch := candidates[:min(opts.SweepBatch, len(candidates))]
+		candidates = candidates[len(batch):]
+		if limiter != nil {
+			if err := limiter.WaitN(ctx, len(batch)); err != nil {
+				return nil, err
+			}
+		}
+		roots, err := pm.gcRoots(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := pm.sweep(ctx, local, pinned, marks, roots, batch, opts, result); err != nil {
+			return nil, err
+		}
+	}
+	result.SweepDuration = time.Since(sweepStart)
+
+	result.BlocksAfter = result.BlocksBefore - result.DeletedBlocks
+	result.MarkedBlocks = int64(marks.len())
+	result.Duration = time.Since(start)
+
+	pm.mutex.Lock()
+	pm.stats.LastGC = start
+	pm.stats.GCDuration = result.Duration
+	pm.stats.ReclaimedBytes = result.ReclaimedBytes
+	pm.stats.PauseTotal = result.PauseTotal
+	pm.stats.PauseMax = result.PauseMax
+	pm.mutex.Unlock()
+
+	return result, nil
+}
+
+// sweep deletes the blocks of batch that are still unmarked, holding
+// writes back meanwhile
+func (pm *PinManager) sweep(ctx context.Context, local format.DAGService, pinned, marks *markSet, roots []cid.Cid, batch []cid.Cid, opts GCOptions, result *GCResult) error {
+	paused := time.Now()
+	// wait for writes that have not pinned their roots yet
+	pm.gcLock.Lock()
+	defer pm.gcLock.Unlock()
+	// and keep pins from being added to blocks about to go
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	defer func() { result.addPause(time.Since(paused)) }()
+
+	// Pins and roots that appeared since the mark
+	pm.markPins(pinned)
+	for _, root := range append(pm.recursiveRoots(), roots...) {
+		if err := markDAG(ctx, local, marks, root, opts.MarkConcurrency); err != nil {
+			return fmt.Errorf("mark %s: %w", root, err)
+		}
+	}
+
+	store := pm.dagWrapper.BlockServiceWrapper.PersistentWrapper
+	for _, c := range batch {
+		if pinned.has(c) || marks.has(c) {
+			continue
+		}
+		size, err := store.GetSize(ctx, c)
+		if format.IsNotFound(err) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return fmt.Errorf("sweep %s: %w", c, err)
+		}
+		if !opts.DryRun {
+			if err := store.Delete(ctx, c); err != nil {
+				return fmt.Errorf("sweep %s: %w", c, err)
+			}
+		}
+		result.DeletedBlocks++
+		result.ReclaimedBytes += int64(size)
+	}
+	return nil
+}
+
+// markPins marks every pinned CID and returns how many there are. Must
+// be called with the mutex held.
+func (pm *PinManager) markPins(marks *markSet) int {
+	for c := range pm.directPins {
+		marks.add(c)
+	}
+	for c := range pm.recursivePins {
+		marks.add(c)
+	}
+	for c := range pm.indirectPins {
+		marks.add(c)
+	}
+	return len(pm.directPins) + len(pm.recursivePins) + len(pm.indirectPins)
+}
+
+// recursiveRoots returns the recursive pins. Their DAGs are marked through
+// the blockstore: the children recorded at pin time miss blocks that were
+// not stored yet. Must be called with the mutex held.
+func (pm *PinManager) recursiveRoots() []cid.Cid {
+	out := make([]cid.Cid, 0, len(pm.recursivePins))
+	for c := range pm.recursivePins {
+		out = append(out, c)
+	}
+	return out
+}
+
+// gcRoots asks the root sources for their current roots
+func (pm *PinManager) gcRoots(ctx context.Context) ([]cid.Cid, error) {
+	pm.mutex.RLock()
+	sources := slices.Clone(pm.rootSources)
+	pm.mutex.RUnlock()
+
+	var roots []cid.Cid
+	for _, src := range sources {
+		rs, err := src.GCRoots(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("gc roots: %w", err)
+		}
+		roots = append(roots, rs...)
+	}
+	return roots, nil
+}
+
+// markDAG marks root and every stored block below it, level by level,
+// reading up to conc blocks at once. Subtrees marked before are skipped,
+// and so are blocks that are not stored: nothing below them can be kept.
+func markDAG(ctx context.Context, local format.DAGService, marks *markSet, root cid.Cid, conc int) error {
+	if !marks.add(root) {
+		return nil
+	}
+
+	level := []cid.Cid{root}
+	for len(level) > 0 {
+		var (
+			mu       sync.Mutex
+			next     []cid.Cid
+			firstErr error
+			wg       sync.WaitGroup
+		)
+		work := make(chan cid.Cid)
+		for range min(conc, len(level)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for c := range work {
+					nd, err := local.Get(ctx, c)
+					if format.IsNotFound(err) {
+						continue
+					}
+					mu.Lock()
+					if err != nil {
+						firstErr = cmp.Or(firstErr, err)
+					} else {
+						for _, l := range nd.Links() {
+							if marks.add(l.Cid) {
+								next = append(next, l.Cid)
+							}
+						}
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+
+	feed:
+		for _, c := range level {
+			select {
+			case work <- c:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(work)
+		wg.Wait()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if firstErr != nil {
+			return firstErr
+		}
+		level = next
+	}
+	return nil
+}
@@ -3,14 +3,23 @@ package pin
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
 
+	dht "github.com/gosuda/boxo-starter-kit/03-dht-router/pkg"
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 )
 
+// PinManager can drive the DHT's pinned and roots reprovide strategies
+var _ dht.PinSource = (*PinManager)(nil)
+
+// PinManager pins what the dag and unixfs wrappers write (SetPinner)
+var _ dag.Pinner = (*PinManager)(nil)
+
 // PinType represents different types of pins
 type PinType int
 
@@ -38,24 +47,46 @@ type PinInfo struct {
 	CID       cid.Cid   `json:"cid"`
 	Type      PinType   `json:"type"`
 	Name      string    `json:"name,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// PinnedBy lists the recursive pins an indirect pin is a child of
+	PinnedBy []cid.Cid `json:"pinned_by,omitempty"`
 }
 
-// PinManager manages pins and garbage collection using a simple in-memory approach
+// PinManager manages pins and garbage collection. Direct and recursive
+// pins are kept in the datastore under the dag's blockstore, so they
+// survive restarts; indirect pins are derived from the DAG.
 type PinManager struct {
 	dagWrapper *dag.IpldWrapper
+	store      *pinStore // nil keeps pins in memory only
 	mutex      sync.RWMutex
+	gcLock     sync.RWMutex // held shared by writes, exclusively by GC
 
-	// Simple in-memory pin tracking
 	directPins    map[cid.Cid]PinInfo
 	recursivePins map[cid.Cid]PinInfo
-	indirectPins  map[cid.Cid]PinInfo // Calculated from recursive pins
+
+	// Indirect pins: the children of each recursive pin, and for each
+	// child the recursive pins it is reached from
+	descendants  map[cid.Cid][]cid.Cid
+	indirectPins map[cid.Cid]map[cid.Cid]struct{}
+
+	// links holds the child links of the pinned nodes that have any, so
+	// walks do not read blocks already pinned again
+	links map[cid.Cid][]cid.Cid
+
+	// collections groups recursive pins by name
+	collections map[string]*Collection
+
+	rootSources []RootSource // GC roots besides the pins
+	gcRun       sync.Mutex   // one collection at a time
 
 	// Statistics
 	stats struct {
 		LastGC         time.Time     `json:"last_gc"`
 		GCDuration     time.Duration `json:"gc_duration"`
 		ReclaimedBytes int64         `json:"reclaimed_bytes"`
+		PauseTotal     time.Duration `json:"pause_total"`
+		PauseMax       time.Duration `json:"pause_max"`
 	}
 }
 
@@ -65,7 +96,9 @@ type PinOptions struct {
 	Recursive bool   // Whether to pin recursively
 }
 
-// NewPinManager creates a new pin manager
+// NewPinManager creates a pin manager and loads the pins stored with the
+// dag's blocks. Pins a PinnerWrapper stored there are migrated the first
+// time.
 func NewPinManager(dagWrapper *dag.IpldWrapper) (*PinManager, error) {
 	if dagWrapper == nil {
 		return nil, fmt.Errorf("dag wrapper cannot be nil")
@@ -73,15 +106,45 @@ func NewPinManager(dagWrapper *dag.IpldWrapper) (*PinManager, error) {
 
 	pm := &PinManager{
 		dagWrapper:    dagWrapper,
+		store:         newPinStore(dagWrapper),
 		directPins:    make(map[cid.Cid]PinInfo),
 		recursivePins: make(map[cid.Cid]PinInfo),
-		indirectPins:  make(map[cid.Cid]PinInfo),
+		descendants:   make(map[cid.Cid][]cid.Cid),
+		indirectPins:  make(map[cid.Cid]map[cid.Cid]struct{}),
+		links:         make(map[cid.Cid][]cid.Cid),
+		collections:   make(map[string]*Collection),
+	}
+
+	if pm.store != nil {
+		ctx := context.Background()
+		pins, err := pm.store.load(ctx, dagWrapper)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range pins {
+			if info.Type == RecursivePin {
+				pm.addIndirect(ctx, info.CID)
+				pm.recursivePins[info.CID] = info
+			} else {
+				pm.directPins[info.CID] = info
+			}
+		}
+
+		collections, err := pm.store.loadCollections(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range collections {
+			pm.collections[col.Name] = col
+		}
 	}
 
 	return pm, nil
 }
 
-// Pin adds a pin for the given CID
+// Pin adds a pin for the given CID. Pinning a directly pinned CID
+// recursively turns the direct pin into a recursive one, keeping its name
+// unless opts gives a new one.
 func (pm *PinManager) Pin(ctx context.Context, c cid.Cid, opts PinOptions) error {
 	if !c.Defined() {
 		return fmt.Errorf("invalid CID")
@@ -89,16 +152,54 @@ func (pm *PinManager) Pin(ctx context.Context, c cid.Cid, opts PinOptions) error
 
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
+	return pm.pin(ctx, c, opts)
+}
 
+// pin is Pin with the mutex held
+func (pm *PinManager) pin(ctx context.Context, c cid.Cid, opts PinOptions) error {
 	// Check if already pinned
-	if _, exists := pm.directPins[c]; exists {
+	direct, isDirect := pm.directPins[c]
+	if isDirect && !opts.Recursive {
 		return fmt.Errorf("CID %s is already pinned directly", c.String())
 	}
 	if _, exists := pm.recursivePins[c]; exists {
 		return fmt.Errorf("CID %s is already pinned recursively", c.String())
 	}
 
-	// Verify the content exists in the DAG (try both DAG service and direct block access)
+	if err := pm.checkContent(ctx, c); err != nil {
+		return err
+	}
+
+	pinInfo := PinInfo{
+		CID:       c,
+		Type:      DirectPin,
+		Name:      opts.Name,
+		CreatedAt: time.Now(),
+	}
+	if opts.Recursive {
+		pinInfo.Type = RecursivePin
+		if pinInfo.Name == "" {
+			pinInfo.Name = direct.Name
+		}
+	}
+
+	if err := pm.save(ctx, pinInfo); err != nil {
+		return err
+	}
+	if opts.Recursive {
+		delete(pm.directPins, c)
+		pm.addIndirect(ctx, c)
+		pm.recursivePins[c] = pinInfo
+	} else {
+		pm.directPins[c] = pinInfo
+	}
+
+	return nil
+}
+
+// checkContent verifies c exists in the DAG (trying both the DAG service
+// and direct block access)
+func (pm *PinManager) checkContent(ctx context.Context, c cid.Cid) error {
 	_, err := pm.dagWrapper.Get(ctx, c)
 	if err != nil {
 		// If DAG service fails (e.g., for DAG-CBOR), try direct block access
@@ -107,28 +208,100 @@ func (pm *PinManager) Pin(ctx context.Context, c cid.Cid, opts PinOptions) error
 			return fmt.Errorf("content not found for CID %s: %w (also tried raw access: %w)", c.String(), err, err2)
 		}
 	}
+	return nil
+}
+
+// PinUpdate moves the recursive pin on oldRoot to newRoot, keeping its
+// name, e.g. when a website is republished. Only the blocks of newRoot
+// that oldRoot does not share are read; the shared ones are followed
+// through the links recorded when oldRoot was pinned. Pins and GC never
+// see a state in between, and blocks both DAGs share stay pinned.
+// Collections holding oldRoot hold newRoot afterwards.
+func (pm *PinManager) PinUpdate(ctx context.Context, oldRoot, newRoot cid.Cid) error {
+	if !oldRoot.Defined() || !newRoot.Defined() {
+		return fmt.Errorf("invalid CID")
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	old, exists := pm.recursivePins[oldRoot]
+	if !exists {
+		return fmt.Errorf("CID %s is not pinned recursively", oldRoot.String())
+	}
+	if oldRoot.Equals(newRoot) {
+		return nil
+	}
+
+	_, alreadyPinned := pm.recursivePins[newRoot]
+	if !alreadyPinned {
+		if err := pm.checkContent(ctx, newRoot); err != nil {
+			return err
+		}
+	}
 
 	pinInfo := PinInfo{
-		CID:       c,
-		Name:      opts.Name,
-		Timestamp: time.Now(),
+		CID:       newRoot,
+		Type:      RecursivePin,
+		Name:      old.Name,
+		CreatedAt: time.Now(),
+	}
+	if alreadyPinned {
+		pinInfo = pm.recursivePins[newRoot]
 	}
 
-	if opts.Recursive {
-		pinInfo.Type = RecursivePin
-		pm.recursivePins[c] = pinInfo
+	// Collections holding oldRoot hold newRoot instead
+	var cols []*Collection
+	for _, name := range pm.holders(oldRoot) {
+		col := pm.collections[name].clone()
+		col.replaceRoot(oldRoot, newRoot, !alreadyPinned)
+		cols = append(cols, col)
+	}
 
-		// Update indirect pins by recalculating all recursive dependencies
-		pm.updateIndirectPins(ctx)
-	} else {
-		pinInfo.Type = DirectPin
-		pm.directPins[c] = pinInfo
+	if pm.store != nil {
+		if err := pm.store.replace(ctx, oldRoot, pinInfo, cols...); err != nil {
+			return err
+		}
 	}
+	for _, col := range cols {
+		pm.collections[col.Name] = col
+	}
+
+	// Walk the new DAG while the old one is still pinned
+	if !alreadyPinned {
+		delete(pm.directPins, newRoot)
+		pm.addIndirect(ctx, newRoot)
+		pm.recursivePins[newRoot] = pinInfo
+	}
+	delete(pm.recursivePins, oldRoot)
+	pm.removeIndirect(oldRoot)
 
 	return nil
 }
 
-// Unpin removes a pin for the given CID
+// WriteLock keeps RunGC from starting until unlock is called, so blocks
+// a write stores cannot be collected before the write pins its root
+func (pm *PinManager) WriteLock() (unlock func()) {
+	pm.gcLock.RLock()
+	return pm.gcLock.RUnlock
+}
+
+// PinRoot pins c recursively; a CID that is already pinned recursively is
+// left as it is, and a direct pin becomes recursive
+func (pm *PinManager) PinRoot(ctx context.Context, c cid.Cid) error {
+	err := pm.Pin(ctx, c, PinOptions{Recursive: true})
+	if err != nil {
+		pm.mutex.RLock()
+		defer pm.mutex.RUnlock()
+		if _, ok := pm.recursivePins[c]; ok {
+			return nil // pinned before, or by a concurrent write
+		}
+	}
+	return err
+}
+
+// Unpin removes a pin for the given CID. Roots a collection holds are
+// unpinned with UnpinCollection instead.
 func (pm *PinManager) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
 	if !c.Defined() {
 		return fmt.Errorf("invalid CID")
@@ -142,12 +315,21 @@ func (pm *PinManager) Unpin(ctx context.Context, c cid.Cid, recursive bool) erro
 		if _, exists := pm.recursivePins[c]; !exists {
 			return fmt.Errorf("CID %s is not pinned recursively", c.String())
 		}
+		if names := pm.holders(c); len(names) > 0 {
+			return fmt.Errorf("CID %s is held by collection %s; unpin it from there", c.String(), names[0])
+		}
+		if err := pm.unsave(ctx, c); err != nil {
+			return err
+		}
 		delete(pm.recursivePins, c)
-		pm.updateIndirectPins(ctx)
+		pm.removeIndirect(c)
 	} else {
 		if _, exists := pm.directPins[c]; !exists {
 			return fmt.Errorf("CID %s is not pinned directly", c.String())
 		}
+		if err := pm.unsave(ctx, c); err != nil {
+			return err
+		}
 		delete(pm.directPins, c)
 	}
 
@@ -192,72 +374,89 @@ func (pm *PinManager) GetPinType(ctx context.Context, c cid.Cid) (PinType, error
 	return DirectPin, fmt.Errorf("CID %s is not pinned", c.String())
 }
 
-// ListPins returns all pinned CIDs with their types
-func (pm *PinManager) ListPins(ctx context.Context) ([]PinInfo, error) {
+// ListPins returns the pins of the given types, or all pins if no type is
+// given, ordered by CID. A CID is listed once, with the type GetPinType
+// reports, so a pin that is also a child of a recursive pin is not listed
+// as indirect.
+func (pm *PinManager) ListPins(ctx context.Context, types ...PinType) ([]PinInfo, error) {
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
 
-	var result []PinInfo
-
-	for _, pinInfo := range pm.directPins {
-		result = append(result, pinInfo)
+	want := func(t PinType) bool {
+		return len(types) == 0 || slices.Contains(types, t)
 	}
 
-	for _, pinInfo := range pm.recursivePins {
-		result = append(result, pinInfo)
+	var result []PinInfo
+	if want(DirectPin) {
+		for _, pinInfo := range pm.directPins {
+			result = append(result, pinInfo)
+		}
 	}
-
-	// Include indirect pins for completeness
-	for _, pinInfo := range pm.indirectPins {
-		result = append(result, pinInfo)
+	if want(RecursivePin) {
+		for _, pinInfo := range pm.recursivePins {
+			result = append(result, pinInfo)
+		}
+	}
+	if want(IndirectPin) {
+		for c, roots := range pm.indirectPins {
+			if _, direct := pm.directPins[c]; direct {
+				continue
+			}
+			if _, recursive := pm.recursivePins[c]; recursive {
+				continue
+			}
+			pinInfo := PinInfo{CID: c, Type: IndirectPin}
+			for root := range roots {
+				pinInfo.PinnedBy = append(pinInfo.PinnedBy, root)
+				// pinned since the first of its roots was
+				if created := pm.recursivePins[root].CreatedAt; pinInfo.CreatedAt.IsZero() || created.Before(pinInfo.CreatedAt) {
+					pinInfo.CreatedAt = created
+				}
+			}
+			slices.SortFunc(pinInfo.PinnedBy, compareCids)
+			result = append(result, pinInfo)
+		}
 	}
 
+	slices.SortFunc(result, func(a, b PinInfo) int { return compareCids(a.CID, b.CID) })
 	return result, nil
 }
 
-// GCResult contains garbage collection results
-type GCResult struct {
-	BlocksBefore   int64         `json:"blocks_before"`
-	BlocksAfter    int64         `json:"blocks_after"`
-	DeletedBlocks  int64         `json:"deleted_blocks"`
-	ReclaimedBytes int64         `json:"reclaimed_bytes"`
-	Duration       time.Duration `json:"duration"`
-	PinnedBlocks   int64         `json:"pinned_blocks"`
+func compareCids(a, b cid.Cid) int {
+	return strings.Compare(a.KeyString(), b.KeyString())
 }
 
-// RunGC performs garbage collection, removing unpinned blocks
-func (pm *PinManager) RunGC(ctx context.Context) (*GCResult, error) {
-	start := time.Now()
-
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
-
-	// For this demo, we'll simulate GC by counting what would be kept vs removed
-	// In a real implementation, this would traverse the blockstore and delete unpinned blocks
-
-	// Count pinned blocks
-	pinnedCount := int64(len(pm.directPins) + len(pm.recursivePins) + len(pm.indirectPins))
-
-	// Simulate block counting (this would normally enumerate all blocks in storage)
-	blocksBefore := pinnedCount + 50 // Simulate some unpinned blocks
-	blocksAfter := pinnedCount
-	deletedBlocks := blocksBefore - blocksAfter
-	reclaimedBytes := deletedBlocks * 1024 // Simulate 1KB average block size
+// PinnedCids returns every pinned CID: direct and recursive pins plus the
+// children of recursive pins
+func (pm *PinManager) PinnedCids(ctx context.Context) ([]cid.Cid, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
 
-	result := &GCResult{
-		BlocksBefore:   blocksBefore,
-		BlocksAfter:    blocksAfter,
-		DeletedBlocks:  deletedBlocks,
-		ReclaimedBytes: reclaimedBytes,
-		Duration:       time.Since(start),
-		PinnedBlocks:   pinnedCount,
+	result := make([]cid.Cid, 0, len(pm.directPins)+len(pm.recursivePins)+len(pm.indirectPins))
+	for c := range pm.directPins {
+		result = append(result, c)
+	}
+	for c := range pm.recursivePins {
+		result = append(result, c)
+	}
+	for c := range pm.indirectPins {
+		result = append(result, c)
 	}
+	return result, nil
+}
 
-	// Update stats
-	pm.stats.LastGC = start
-	pm.stats.GCDuration = result.Duration
-	pm.stats.ReclaimedBytes = result.ReclaimedBytes
+// PinRoots returns the direct and recursive pins without their children
+func (pm *PinManager) PinRoots(ctx context.Context) ([]cid.Cid, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
 
+	result := make([]cid.Cid, 0, len(pm.directPins)+len(pm.recursivePins))
+	for c := range pm.directPins {
+		result = append(result, c)
+	}
+	for c := range pm.recursivePins {
+		result = append(result, c)
+	}
 	return result, nil
 }
 
@@ -269,6 +468,8 @@ type PinStats struct {
 	LastGC         time.Time     `json:"last_gc"`
 	GCDuration     time.Duration `json:"gc_duration"`
 	ReclaimedBytes int64         `json:"reclaimed_bytes"`
+	GCPauseTotal   time.Duration `json:"gc_pause_total"` // writes held back by the last GC
+	GCPauseMax     time.Duration `json:"gc_pause_max"`
 }
 
 // GetStats returns current pin manager statistics
@@ -283,6 +484,8 @@ func (pm *PinManager) GetStats(ctx context.Context) (*PinStats, error) {
 		LastGC:         pm.stats.LastGC,
 		GCDuration:     pm.stats.GCDuration,
 		ReclaimedBytes: pm.stats.ReclaimedBytes,
+		GCPauseTotal:   pm.stats.PauseTotal,
+		GCPauseMax:     pm.stats.PauseMax,
 	}, nil
 }
 
@@ -291,37 +494,77 @@ func (pm *PinManager) Close() error {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
-	// Clear all pin maps
+	// Clear all pin maps; the stored pins stay for the next PinManager
 	pm.directPins = make(map[cid.Cid]PinInfo)
 	pm.recursivePins = make(map[cid.Cid]PinInfo)
-	pm.indirectPins = make(map[cid.Cid]PinInfo)
+	pm.descendants = make(map[cid.Cid][]cid.Cid)
+	pm.indirectPins = make(map[cid.Cid]map[cid.Cid]struct{})
+	pm.links = make(map[cid.Cid][]cid.Cid)
+	pm.collections = make(map[string]*Collection)
 
 	return nil
 }
 
-// updateIndirectPins recalculates indirect pins based on recursive pins
-// This is called whenever recursive pins change
-func (pm *PinManager) updateIndirectPins(ctx context.Context) {
-	// Clear current indirect pins
-	pm.indirectPins = make(map[cid.Cid]PinInfo)
-
-	// For each recursive pin, find all its children
-	for rootCID, rootPin := range pm.recursivePins {
-		children := make(map[cid.Cid]bool)
-		pm.findChildren(ctx, rootCID, children)
-
-		// Add all children as indirect pins (except the root itself)
-		for childCID := range children {
-			if !childCID.Equals(rootCID) {
-				pm.indirectPins[childCID] = PinInfo{
-					CID:       childCID,
-					Type:      IndirectPin,
-					Name:      fmt.Sprintf("Child of %s", rootPin.Name),
-					Timestamp: rootPin.Timestamp,
-				}
+// save stores a direct or recursive pin, if pins are persisted
+func (pm *PinManager) save(ctx context.Context, info PinInfo) error {
+	if pm.store == nil {
+		return nil
+	}
+	return pm.store.save(ctx, info)
+}
+
+// unsave removes a stored pin, if pins are persisted
+func (pm *PinManager) unsave(ctx context.Context, c cid.Cid) error {
+	if pm.store == nil {
+		return nil
+	}
+	return pm.store.delete(ctx, c)
+}
+
+// addIndirect records the children of the recursive pin root as indirect
+// pins. Must be called with the mutex held, before root is added to
+// recursivePins.
+func (pm *PinManager) addIndirect(ctx context.Context, root cid.Cid) {
+	visited := make(map[cid.Cid]bool)
+	pm.findChildren(ctx, root, visited)
+	delete(visited, root)
+
+	children := make([]cid.Cid, 0, len(visited))
+	for child := range visited {
+		children = append(children, child)
+		if pm.indirectPins[child] == nil {
+			pm.indirectPins[child] = make(map[cid.Cid]struct{})
+		}
+		pm.indirectPins[child][root] = struct{}{}
+	}
+	pm.descendants[root] = children
+}
+
+// removeIndirect drops the indirect pins of the recursive pin root;
+// children another recursive pin reaches stay pinned. Must be called
+// with the mutex held, after root is removed from recursivePins.
+func (pm *PinManager) removeIndirect(root cid.Cid) {
+	for _, child := range pm.descendants[root] {
+		delete(pm.indirectPins[child], root)
+		if len(pm.indirectPins[child]) == 0 {
+			delete(pm.indirectPins, child)
+			if !pm.walked(child) {
+				delete(pm.links, child)
 			}
 		}
 	}
+	delete(pm.descendants, root)
+	if !pm.walked(root) {
+		delete(pm.links, root)
+	}
+}
+
+// walked reports whether c is part of a pinned DAG, so its links are
+// recorded and its block need not be read to walk it
+func (pm *PinManager) walked(c cid.Cid) bool {
+	_, recursive := pm.recursivePins[c]
+	_, indirect := pm.indirectPins[c]
+	return recursive || indirect
 }
 
 // findChildren recursively finds all children of a given CID
@@ -331,6 +574,14 @@ func (pm *PinManager) findChildren(ctx context.Context, c cid.Cid, visited map[c
 	}
 	visited[c] = true
 
+	// Pinned subtrees were walked when they were pinned
+	if pm.walked(c) {
+		for _, child := range pm.links[c] {
+			pm.findChildren(ctx, child, visited)
+		}
+		return
+	}
+
 	// Try to get the node and its links using DAG service
 	node, err := pm.dagWrapper.Get(ctx, c)
 	if err != nil {
@@ -341,7 +592,12 @@ func (pm *PinManager) findChildren(ctx context.Context, c cid.Cid, visited map[c
 	}
 
 	// Traverse all links (works for DAG-PB and Raw nodes)
+	var children []cid.Cid
 	for _, link := range node.Links() {
+		children = append(children, link.Cid)
 		pm.findChildren(ctx, link.Cid, visited)
 	}
+	if len(children) > 0 {
+		pm.links[c] = children
+	}
 }
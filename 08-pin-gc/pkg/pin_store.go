@@ -0,0 +1,265 @@
+package pin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ipfspinner "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/rs/zerolog/log"
+
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
+)
+
+// Inside the persistent.NamespacePins view. The dspinner used by
+// PinnerWrapper keeps its pins next to these, under /pin and /index.
+var (
+	// pinRecordPrefix holds one pinRecord per direct or recursive pin,
+	// keyed by CID. Indirect pins are derived from the DAG on load.
+	pinRecordPrefix = ds.NewKey("/records")
+	// collectionPrefix holds one Collection per name
+	collectionPrefix = ds.NewKey("/collections")
+	// pinFormatKey marks the store as migrated to the record format
+	pinFormatKey = ds.NewKey("/state/format")
+)
+
+// pinFormatVersion is the current record format; stores without
+// pinFormatKey still hold their pins in the dspinner format only
+const pinFormatVersion = "1"
+
+// pinRecord is the stored form of a direct or recursive pin
+type pinRecord struct {
+	Type      string    `json:"type"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// pinStore persists pins in the datastore under the dag's blockstore
+type pinStore struct {
+	store  ds.Batching
+	legacy ds.Batching // root of the dspinner pins, for the migration
+}
+
+// newPinStore returns the pin store of dagWrapper's datastore, or nil if
+// the dag has no persistent store and pins are kept in memory only
+func newPinStore(dagWrapper *dag.IpldWrapper) *pinStore {
+	bs := dagWrapper.BlockServiceWrapper
+	if bs == nil || bs.PersistentWrapper == nil {
+		return nil
+	}
+	return &pinStore{
+		store:  bs.PersistentWrapper.WithNamespace(persistent.NamespacePins).Batching,
+		legacy: bs.PersistentWrapper.Batching,
+	}
+}
+
+func pinRecordKey(c cid.Cid) ds.Key {
+	return pinRecordPrefix.ChildString(c.String())
+}
+
+func marshalPin(info PinInfo) ([]byte, error) {
+	return json.Marshal(pinRecord{Type: info.Type.String(), Name: info.Name, CreatedAt: info.CreatedAt})
+}
+
+func (s *pinStore) save(ctx context.Context, info PinInfo) error {
+	data, err := marshalPin(info)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, pinRecordKey(info.CID), data); err != nil {
+		return fmt.Errorf("store pin %s: %w", info.CID, err)
+	}
+	return nil
+}
+
+func (s *pinStore) delete(ctx context.Context, c cid.Cid) error {
+	if err := s.store.Delete(ctx, pinRecordKey(c)); err != nil {
+		return fmt.Errorf("delete pin %s: %w", c, err)
+	}
+	return nil
+}
+
+// replace swaps the record of oldRoot for info in one batch, along with
+// the collections that now hold info, so a crash leaves either pin
+// stored, never neither
+func (s *pinStore) replace(ctx context.Context, oldRoot cid.Cid, info PinInfo, cols ...*Collection) error {
+	data, err := marshalPin(info)
+	if err != nil {
+		return err
+	}
+	batch, err := s.store.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(ctx, pinRecordKey(info.CID), data); err != nil {
+		return err
+	}
+	if err := batch.Delete(ctx, pinRecordKey(oldRoot)); err != nil {
+		return err
+	}
+	for _, col := range cols {
+		data, err := json.Marshal(col)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, collectionKey(col.Name), data); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("update pin %s to %s: %w", oldRoot, info.CID, err)
+	}
+	return nil
+}
+
+// load returns the stored direct and recursive pins, migrating the
+// dspinner pins of the store first if that has not been done yet
+func (s *pinStore) load(ctx context.Context, dagWrapper *dag.IpldWrapper) ([]PinInfo, error) {
+	if err := s.migrate(ctx, dagWrapper); err != nil {
+		return nil, err
+	}
+
+	results, err := s.store.Query(ctx, query.Query{Prefix: pinRecordPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("load pins: %w", err)
+	}
+	defer results.Close()
+
+	var pins []PinInfo
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("load pins: %w", r.Error)
+		}
+		c, err := cid.Decode(ds.RawKey(r.Key).BaseNamespace())
+		var rec pinRecord
+		if err == nil {
+			err = json.Unmarshal(r.Value, &rec)
+		}
+		var pinType PinType
+		if err == nil {
+			pinType, err = ParsePinType(rec.Type)
+		}
+		if err != nil || pinType == IndirectPin {
+			log.Warn().Str("key", r.Key).Msg("skipping corrupt pin record")
+			continue
+		}
+		pins = append(pins, PinInfo{CID: c, Type: pinType, Name: rec.Name, CreatedAt: rec.CreatedAt})
+	}
+	return pins, nil
+}
+
+// migrate copies the pins a PinnerWrapper (boxo's dspinner) stored into
+// records, once per store. The dspinner keeps no creation time, so the
+// migrated pins are stamped with the time of the migration. The dspinner
+// pins are left in place; from here on the records are authoritative.
+func (s *pinStore) migrate(ctx context.Context, dagWrapper *dag.IpldWrapper) error {
+	_, err := s.store.Get(ctx, pinFormatKey)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ds.ErrNotFound) {
+		return fmt.Errorf("read pin format: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx) // stops the key streams on error
+	defer cancel()
+	legacy, err := dspinner.New(ctx, s.legacy, dagWrapper)
+	if err != nil {
+		return fmt.Errorf("open legacy pins: %w", err)
+	}
+	now := time.Now()
+	migrated := 0
+	for pinType, keys := range map[PinType]<-chan ipfspinner.StreamedPin{
+		RecursivePin: legacy.RecursiveKeys(ctx, true),
+		DirectPin:    legacy.DirectKeys(ctx, true),
+	} {
+		for sp := range keys {
+			if sp.Err != nil {
+				return fmt.Errorf("read legacy pins: %w", sp.Err)
+			}
+			info := PinInfo{CID: sp.Pin.Key, Type: pinType, Name: sp.Pin.Name, CreatedAt: now}
+			if err := s.save(ctx, info); err != nil {
+				return err
+			}
+			migrated++
+		}
+	}
+
+	if err := s.store.Put(ctx, pinFormatKey, []byte(pinFormatVersion)); err != nil {
+		return fmt.Errorf("write pin format: %w", err)
+	}
+	if err := s.store.Sync(ctx, pinRecordPrefix); err != nil {
+		return fmt.Errorf("sync pins: %w", err)
+	}
+	if migrated > 0 {
+		log.Info().Int("pins", migrated).Msg("migrated legacy pins to pin records")
+	}
+	return nil
+}
+
+func collectionKey(name string) ds.Key {
+	return collectionPrefix.ChildString(name)
+}
+
+func (s *pinStore) saveCollection(ctx context.Context, col *Collection) error {
+	data, err := json.Marshal(col)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, collectionKey(col.Name), data); err != nil {
+		return fmt.Errorf("store collection %s: %w", col.Name, err)
+	}
+	return nil
+}
+
+func (s *pinStore) deleteCollection(ctx context.Context, name string) error {
+	if err := s.store.Delete(ctx, collectionKey(name)); err != nil {
+		return fmt.Errorf("delete collection %s: %w", name, err)
+	}
+	return nil
+}
+
+// loadCollections returns the stored collections
+func (s *pinStore) loadCollections(ctx context.Context) ([]*Collection, error) {
+	results, err := s.store.Query(ctx, query.Query{Prefix: collectionPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("load collections: %w", err)
+	}
+	defer results.Close()
+
+	var cols []*Collection
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("load collections: %w", r.Error)
+		}
+		var col Collection
+		if err := json.Unmarshal(r.Value, &col); err != nil || col.Name == "" {
+			log.Warn().Str("key", r.Key).Msg("skipping corrupt collection record")
+			continue
+		}
+		cols = append(cols, &col)
+	}
+	return cols, nil
+}
+
+// ParsePinType parses the names PinType.String returns
+func ParsePinType(s string) (PinType, error) {
+	switch strings.ToLower(s) {
+	case "direct":
+		return DirectPin, nil
+	case "recursive":
+		return RecursivePin, nil
+	case "indirect":
+		return IndirectPin, nil
+	default:
+		return DirectPin, fmt.Errorf("unknown pin type %q", s)
+	}
+}
@@ -0,0 +1,275 @@
+package pin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/health"
+)
+
+// DefaultVerifyInterval is the pause between background verification passes
+const DefaultVerifyInterval = 6 * time.Hour
+
+// DefaultVerifyFetchTimeout bounds the re-fetch of one block
+const DefaultVerifyFetchTimeout = 30 * time.Second
+
+type VerifyOptions struct {
+	Interval     time.Duration // pause between passes (default 6h)
+	FetchTimeout time.Duration // per re-fetched block (default 30s)
+
+	// Fetcher re-fetches missing and corrupt blocks, typically
+	// multifetcher.NewExchange(mf) from 18-multifetcher. Without one the
+	// damage is only reported.
+	Fetcher exchange.Fetcher
+
+	// OnResult is called after every pass
+	OnResult func(VerifyResult)
+}
+
+type VerifyResult struct {
+	Pins      int64 // direct and recursive pins checked
+	Blocks    int64 // pinned blocks checked
+	Missing   int64
+	Corrupt   int64
+	Refetched int64 // missing or corrupt blocks restored
+
+	// Broken lists the direct and recursive pins with a block that could
+	// not be restored
+	Broken  []cid.Cid
+	Started time.Time
+	Elapsed time.Duration
+	Err     error
+}
+
+// Verifier runs verification passes in the background until its context
+// ends
+type Verifier struct {
+	pm   *PinManager
+	opts VerifyOptions
+
+	mu     sync.RWMutex
+	last   *VerifyResult
+	passes int64
+	done   chan struct{}
+}
+
+// Verify starts a background job that checks every pinned block against
+// its CID and re-fetches the missing and corrupt ones through
+// opts.Fetcher. The first pass starts immediately.
+func (pm *PinManager) Verify(ctx context.Context, opts VerifyOptions) *Verifier {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultVerifyInterval
+	}
+	v := &Verifier{pm: pm, opts: opts, done: make(chan struct{})}
+
+	go func() {
+		defer close(v.done)
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			res := pm.VerifyOnce(ctx, opts)
+			if ctx.Err() != nil {
+				return
+			}
+			v.mu.Lock()
+			v.last = &res
+			v.passes++
+			v.mu.Unlock()
+			if opts.OnResult != nil {
+				opts.OnResult(res)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return v
+}
+
+// Last returns the most recent completed pass
+func (v *Verifier) Last() (VerifyResult, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.last == nil {
+		return VerifyResult{}, false
+	}
+	return *v.last, true
+}
+
+// Done is closed once the background job has stopped
+func (v *Verifier) Done() <-chan struct{} {
+	return v.done
+}
+
+// HealthCheck reports the last pass: unhealthy if a pin could not be
+// fully restored, degraded if the pass failed, unknown before the first
+// pass completes.
+func (v *Verifier) HealthCheck() health.HealthChecker {
+	const name = "pin-verify"
+	return health.NewHealthCheckFunc(name, func(ctx context.Context) health.CheckResult {
+		result := health.CheckResult{
+			ComponentName: name,
+			Status:        health.StatusUnknown,
+			Message:       "No verification pass completed yet",
+			Metadata:      make(map[string]string),
+		}
+
+		v.mu.RLock()
+		last, passes := v.last, v.passes
+		v.mu.RUnlock()
+		if last == nil {
+			return result
+		}
+
+		result.Metadata["passes"] = fmt.Sprint(passes)
+		result.Metadata["blocks"] = fmt.Sprint(last.Blocks)
+		result.Metadata["missing"] = fmt.Sprint(last.Missing)
+		result.Metadata["corrupt"] = fmt.Sprint(last.Corrupt)
+		result.Metadata["refetched"] = fmt.Sprint(last.Refetched)
+		result.Metadata["last_run"] = last.Started.Format(time.RFC3339)
+
+		switch {
+		case len(last.Broken) > 0:
+			result.Status = health.StatusUnhealthy
+			result.Message = fmt.Sprintf("%d pins could not be restored", len(last.Broken))
+			result.Metadata["broken"] = fmt.Sprint(last.Broken)
+		case last.Err != nil:
+			result.Status = health.StatusDegraded
+			result.Message = fmt.Sprintf("Verification failed: %v", last.Err)
+			result.Metadata["error"] = last.Err.Error()
+		default:
+			result.Status = health.StatusHealthy
+			result.Message = fmt.Sprintf("%d pinned blocks verified", last.Blocks)
+		}
+		return result
+	})
+}
+
+// VerifyOnce runs a single verification pass in the foreground. It checks
+// the blocks of the pins as they were when the pass started; the links
+// recorded at pin time tell which blocks belong to a pin, so a missing
+// block does not hide its children.
+func (pm *PinManager) VerifyOnce(ctx context.Context, opts VerifyOptions) VerifyResult {
+	res := VerifyResult{Started: time.Now()}
+	defer func() { res.Elapsed = time.Since(res.Started) }()
+
+	bs := pm.dagWrapper.BlockServiceWrapper
+	if bs == nil || bs.PersistentWrapper == nil {
+		res.Err = fmt.Errorf("no blockstore to verify")
+		return res
+	}
+	if opts.FetchTimeout <= 0 {
+		opts.FetchTimeout = DefaultVerifyFetchTimeout
+	}
+
+	// The pinned blocks, each with the pins it belongs to
+	pm.mutex.RLock()
+	owners := make(map[cid.Cid][]cid.Cid)
+	for c := range pm.directPins {
+		owners[c] = append(owners[c], c)
+	}
+	for c := range pm.recursivePins {
+		owners[c] = append(owners[c], c)
+	}
+	for c, roots := range pm.indirectPins {
+		for root := range roots {
+			owners[c] = append(owners[c], root)
+		}
+	}
+	res.Pins = int64(len(pm.directPins) + len(pm.recursivePins))
+	pm.mutex.RUnlock()
+
+	broken := make(map[cid.Cid]struct{})
+	for c, pins := range owners {
+		if err := ctx.Err(); err != nil {
+			res.Err = err
+			break
+		}
+		res.Blocks++
+		ok, err := pm.verifyBlock(ctx, c, opts, &res)
+		if err != nil {
+			log.Warn().Err(err).Str("cid", c.String()).Msg("pin verify: block not restored")
+		}
+		if !ok {
+			for _, p := range pins {
+				broken[p] = struct{}{}
+			}
+		}
+	}
+
+	for c := range broken {
+		res.Broken = append(res.Broken, c)
+	}
+	slices.SortFunc(res.Broken, compareCids)
+	if len(res.Broken) > 0 {
+		log.Error().Int("pins", len(res.Broken)).Msg("pin verify: pins could not be fully restored")
+	}
+	return res
+}
+
+// verifyBlock checks c and restores it if it is missing or corrupt,
+// reporting whether it is intact afterwards
+func (pm *PinManager) verifyBlock(ctx context.Context, c cid.Cid, opts VerifyOptions, res *VerifyResult) (bool, error) {
+	store := pm.dagWrapper.BlockServiceWrapper.PersistentWrapper
+
+	blk, err := store.Get(ctx, c)
+	switch {
+	case format.IsNotFound(err):
+		res.Missing++
+	case err != nil:
+		return false, err
+	case blockMatches(c, blk.RawData()):
+		return true, nil
+	default:
+		res.Corrupt++
+	}
+
+	if opts.Fetcher == nil {
+		return false, fmt.Errorf("no fetcher to restore %s", c)
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, opts.FetchTimeout)
+	defer cancel()
+	fetched, err := opts.Fetcher.GetBlock(fetchCtx, c)
+	if err != nil {
+		return false, fmt.Errorf("refetch %s: %w", c, err)
+	}
+	if !blockMatches(c, fetched.RawData()) {
+		return false, fmt.Errorf("refetch %s: data does not match", c)
+	}
+
+	// The blockstore skips puts of blocks it has, so the corrupt copy goes
+	// first
+	unlock := pm.WriteLock()
+	defer unlock()
+	if err := store.Delete(ctx, c); err != nil {
+		return false, fmt.Errorf("remove %s: %w", c, err)
+	}
+	good, err := blocks.NewBlockWithCid(fetched.RawData(), c)
+	if err != nil {
+		return false, err
+	}
+	if err := store.Put(ctx, good); err != nil {
+		return false, fmt.Errorf("store %s: %w", c, err)
+	}
+	res.Refetched++
+	return true, nil
+}
+
+// blockMatches reports whether data hashes to c
+func blockMatches(c cid.Cid, data []byte) bool {
+	sum, err := c.Prefix().Sum(data)
+	return err == nil && bytes.Equal(sum.Hash(), c.Hash())
+}
@@ -2,13 +2,30 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	boxoipns "github.com/ipfs/boxo/ipns"
+	ipns_pb "github.com/ipfs/boxo/ipns/pb"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
+	dht "github.com/gosuda/boxo-starter-kit/03-dht-router/pkg"
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 	ipns "github.com/gosuda/boxo-starter-kit/09-ipns/pkg"
 )
@@ -146,8 +163,7 @@ func TestIPNSManager(t *testing.T) {
 		var foundNames []string
 		for _, record := range records {
 			foundNames = append(foundNames, record.Name)
-			// Ensure private key is not exposed
-			assert.Nil(t, record.PrivateKey, "Private key should not be exposed in listing")
+			assert.NotNil(t, record.Record, "Listing should carry the signed record")
 		}
 
 		for _, expectedName := range expectedNames {
@@ -273,3 +289,606 @@ func TestPathFormatting(t *testing.T) {
 		}
 	})
 }
+
+func TestIPNSOverNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	newNode := func() (*network.HostWrapper, *dht.DHTWrapper) {
+		h, err := network.New(nil)
+		require.NoError(t, err)
+		d, err := dht.New(ctx, h, nil)
+		require.NoError(t, err)
+		return h, d
+	}
+	hA, dA := newNode()
+	defer hA.Close()
+	hB, dB := newNode()
+	defer hB.Close()
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+	require.NoError(t, dA.Bootstrap(ctx))
+	require.NoError(t, dB.Bootstrap(ctx))
+	require.Eventually(t, func() bool {
+		return dA.RoutingTableSize() > 0 && dB.RoutingTableSize() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	publisher := ipns.NewIPNSManagerWithRouter(dagWrapper, dA)
+	resolver := ipns.NewIPNSManagerWithRouter(dagWrapper, dB)
+
+	v1, err := dagWrapper.PutAny(ctx, map[string]any{"version": 1})
+	require.NoError(t, err)
+	v2, err := dagWrapper.PutAny(ctx, map[string]any{"version": 2})
+	require.NoError(t, err)
+
+	name, err := publisher.GenerateKey(ctx, "net-key")
+	require.NoError(t, err)
+	_, err = publisher.PublishIPNS(ctx, "net-key", v1, time.Hour)
+	require.NoError(t, err)
+
+	value, err := resolver.ResolveIPNS(ctx, ipns.FormatIPNSPath(name.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "/ipfs/"+v1.String(), value)
+
+	_, err = publisher.UpdateIPNS(ctx, "net-key", v2, time.Hour)
+	require.NoError(t, err)
+	value, err = resolver.ResolveIPNS(ctx, name.String())
+	require.NoError(t, err)
+	assert.Equal(t, "/ipfs/"+v2.String(), value)
+
+	// Without a router, unknown names stay unresolved
+	_, err = ipns.NewIPNSManager(dagWrapper).ResolveIPNS(ctx, name.String())
+	assert.Error(t, err)
+}
+
+func TestIPNSRouters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// A minimal delegated routing endpoint that stores records verbatim
+	var mu sync.Mutex
+	stored := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/routing/v1/ipns/")
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			stored[name] = body
+		case http.MethodGet:
+			body, ok := stored[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.ipfs.ipns-record")
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	delegated, err := dht.NewDelegatedIPNSRouter(srv.URL)
+	require.NoError(t, err)
+
+	hA, err := network.New(nil)
+	require.NoError(t, err)
+	defer hA.Close()
+	hB, err := network.New(nil)
+	require.NoError(t, err)
+	defer hB.Close()
+	psA, err := ipns.NewPubsubNameRouter(ctx, hA)
+	require.NoError(t, err)
+	defer psA.Close()
+	psB, err := ipns.NewPubsubNameRouter(ctx, hB)
+	require.NoError(t, err)
+	defer psB.Close()
+	require.NoError(t, hB.ConnectToPeer(ctx, hA.GetFullAddresses()...))
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	composed, err := ipns.NewComposedNameRouter(delegated, psA)
+	require.NoError(t, err)
+	publisher := ipns.NewIPNSManagerWithRouter(dagWrapper, composed)
+
+	v1, err := dagWrapper.PutAny(ctx, map[string]any{"version": 1})
+	require.NoError(t, err)
+	v2, err := dagWrapper.PutAny(ctx, map[string]any{"version": 2})
+	require.NoError(t, err)
+
+	id, err := publisher.GenerateKey(ctx, "routed")
+	require.NoError(t, err)
+	name := boxoipns.NameFromPeer(id)
+	_, err = publisher.PublishIPNS(ctx, "routed", v1, time.Hour)
+	require.NoError(t, err)
+
+	t.Run("Delegated", func(t *testing.T) {
+		resolver := ipns.NewIPNSManagerWithRouter(dagWrapper, delegated)
+		value, err := resolver.ResolveIPNS(ctx, name.String())
+		require.NoError(t, err)
+		assert.Equal(t, "/ipfs/"+v1.String(), value)
+	})
+
+	t.Run("Pubsub", func(t *testing.T) {
+		// The first lookup joins the topic; updates arrive from then on
+		_, err := psB.GetIPNS(ctx, name)
+		require.ErrorIs(t, err, routing.ErrNotFound)
+
+		_, err = publisher.UpdateIPNS(ctx, "routed", v2, time.Hour)
+		require.NoError(t, err)
+
+		resolver := ipns.NewIPNSManagerWithRouter(dagWrapper, psB)
+		require.Eventually(t, func() bool {
+			value, err := resolver.ResolveIPNS(ctx, name.String())
+			if err != nil {
+				// gossipsub may not have grafted the mesh yet; publish again
+				_, _ = publisher.UpdateIPNS(ctx, "routed", v2, time.Hour)
+				return false
+			}
+			return value == "/ipfs/"+v2.String()
+		}, 10*time.Second, 200*time.Millisecond)
+	})
+
+	t.Run("Composed", func(t *testing.T) {
+		// The delegated endpoint saw every update too, so both agree on v2
+		both, err := ipns.NewComposedNameRouter(delegated, psB)
+		require.NoError(t, err)
+		rec, err := both.GetIPNS(ctx, name)
+		require.NoError(t, err)
+		value, err := rec.Value()
+		require.NoError(t, err)
+		assert.Equal(t, "/ipfs/"+v2.String(), value.String())
+
+		_, err = ipns.NewComposedNameRouter()
+		assert.Error(t, err)
+	})
+}
+
+func TestIPNSSignedRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	v1, err := dagWrapper.PutAny(ctx, map[string]any{"version": 1})
+	require.NoError(t, err)
+	v2, err := dagWrapper.PutAny(ctx, map[string]any{"version": 2})
+	require.NoError(t, err)
+
+	publisher := ipns.NewIPNSManager(dagWrapper)
+	id, err := publisher.GenerateKey(ctx, "signed")
+	require.NoError(t, err)
+	name := boxoipns.NameFromPeer(id)
+
+	t.Run("V2 Signatures", func(t *testing.T) {
+		record, err := publisher.PublishIPNS(ctx, "signed", v1, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, boxoipns.ValidateWithName(record.Record, name))
+		assert.True(t, record.V1Compatible, "records carry V1 signatures by default")
+		assert.WithinDuration(t, time.Now().Add(time.Hour), record.Validity, time.Minute)
+
+		vt, err := record.Record.ValidityType()
+		require.NoError(t, err)
+		assert.Equal(t, boxoipns.ValidityEOL, vt)
+
+		publisher.SetV1Compatibility(false)
+		defer publisher.SetV1Compatibility(true)
+		record, err = publisher.UpdateIPNS(ctx, "signed", v1, time.Hour)
+		require.NoError(t, err)
+		assert.False(t, record.V1Compatible)
+		require.NoError(t, boxoipns.ValidateWithName(record.Record, name))
+	})
+
+	t.Run("Wire Format Round Trip", func(t *testing.T) {
+		_, err := publisher.UpdateIPNS(ctx, "signed", v2, time.Hour)
+		require.NoError(t, err)
+		data, err := publisher.ExportRecord(ctx, name.String())
+		require.NoError(t, err)
+
+		// The exported bytes are the protobuf IpnsRecord Kubo exchanges
+		var pb ipns_pb.IpnsRecord
+		require.NoError(t, proto.Unmarshal(data, &pb))
+		assert.NotEmpty(t, pb.GetSignatureV2())
+		assert.NotEmpty(t, pb.GetData())
+
+		resolver := ipns.NewIPNSManager(dagWrapper)
+		record, err := resolver.ImportRecord(ctx, ipns.FormatIPNSPath(name.String()), data)
+		require.NoError(t, err)
+		assert.Equal(t, "/ipfs/"+v2.String(), record.Value)
+		value, err := resolver.ResolveIPNS(ctx, name.String())
+		require.NoError(t, err)
+		assert.Equal(t, "/ipfs/"+v2.String(), value)
+
+		// The record only validates for the name that signed it
+		other, err := resolver.GenerateKey(ctx, "other")
+		require.NoError(t, err)
+		_, err = resolver.ImportRecord(ctx, other.String(), data)
+		assert.Error(t, err)
+	})
+
+	t.Run("Rejects Invalid Records", func(t *testing.T) {
+		data, err := publisher.ExportRecord(ctx, name.String())
+		require.NoError(t, err)
+
+		// V1-only records, as very old nodes made them, are not accepted
+		var pb ipns_pb.IpnsRecord
+		require.NoError(t, proto.Unmarshal(data, &pb))
+		pb.SignatureV2 = nil
+		v1Only, err := proto.Marshal(&pb)
+		require.NoError(t, err)
+		_, err = ipns.NewIPNSManager(dagWrapper).ImportRecord(ctx, name.String(), v1Only)
+		assert.ErrorIs(t, err, boxoipns.ErrSignature)
+
+		// Tampered data fails signature verification
+		require.NoError(t, proto.Unmarshal(data, &pb))
+		pb.SignatureV2[0] ^= 0xff
+		tampered, err := proto.Marshal(&pb)
+		require.NoError(t, err)
+		_, err = ipns.NewIPNSManager(dagWrapper).ImportRecord(ctx, name.String(), tampered)
+		assert.ErrorIs(t, err, boxoipns.ErrSignature)
+
+		// A lower sequence than the held record is refused
+		_, err = publisher.UpdateIPNS(ctx, "signed", v1, time.Hour)
+		require.NoError(t, err)
+		newer, err := publisher.ExportRecord(ctx, name.String())
+		require.NoError(t, err)
+		resolver := ipns.NewIPNSManager(dagWrapper)
+		_, err = resolver.ImportRecord(ctx, name.String(), newer)
+		require.NoError(t, err)
+		_, err = resolver.ImportRecord(ctx, name.String(), data)
+		assert.Error(t, err)
+	})
+}
+
+// TestIPNSKuboFixtures imports records produced by Kubo. Each file in
+// testdata is named <name>.ipns-record, as `ipfs routing get` and the
+// gateway's ?format=ipns-record save them; see the README for how to make one.
+// Both record formats Kubo publishes must be covered: V1+V2 and V2-only.
+func TestIPNSKuboFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.ipns-record"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures, "no Kubo records in testdata")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	var v1Compatible, v2Only int
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".ipns-record")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(fixture)
+			require.NoError(t, err)
+
+			m := ipns.NewIPNSManager(dagWrapper)
+			record, err := m.ImportRecord(ctx, name, data)
+			require.NoError(t, err)
+			if record.V1Compatible {
+				v1Compatible++
+			} else {
+				v2Only++
+			}
+			assert.True(t, strings.HasPrefix(record.Value, "/ipfs/") || strings.HasPrefix(record.Value, "/ipns/"))
+
+			value, err := m.ResolveIPNS(ctx, name)
+			require.NoError(t, err)
+			assert.Equal(t, record.Value, value)
+
+			// Exporting hands Kubo back the bytes it produced
+			exported, err := m.ExportRecord(ctx, name)
+			require.NoError(t, err)
+			assert.Equal(t, data, exported)
+		})
+	}
+	assert.Positive(t, v1Compatible, "testdata needs a V1+V2 record")
+	assert.Positive(t, v2Only, "testdata needs a V2-only record")
+}
+
+// fakeTXT answers TXT lookups from a map and counts them
+type fakeTXT struct {
+	mu      sync.Mutex
+	records map[string][]string
+	lookups int
+}
+
+func (f *fakeTXT) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups++
+	txts, ok := f.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txts, nil
+}
+
+func TestDNSLink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	site, err := dagWrapper.PutAny(ctx, map[string]any{"site": "v1"})
+	require.NoError(t, err)
+	other, err := dagWrapper.PutAny(ctx, map[string]any{"site": "other"})
+	require.NoError(t, err)
+
+	m := ipns.NewIPNSManager(dagWrapper)
+	id, err := m.GenerateKey(ctx, "site")
+	require.NoError(t, err)
+	_, err = m.PublishIPNS(ctx, "site", site, time.Hour)
+	require.NoError(t, err)
+
+	txt := &fakeTXT{records: map[string][]string{
+		"_dnslink.example.com": {"v=spf1 -all", "dnslink=/ipfs/" + site.String()},
+		"legacy.org":           {"dnslink=/ipfs/" + other.String()},
+		"_dnslink.docs.org":    {"dnslink=/ipns/example.com/guide"},
+		"_dnslink.keyed.org":   {"dnslink=/ipns/" + id.String()},
+		"_dnslink.multi.org":   {"dnslink=/ipfs/" + site.String(), "dnslink=/ipfs/" + other.String()},
+		"_dnslink.loop.org":    {"dnslink=/ipns/loop.org"},
+		"_dnslink.bad.org":     {"dnslink=not-a-path"},
+	}}
+	m.SetDNSLinkResolver(ipns.NewDNSLinkResolver(txt, time.Hour))
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", "/ipfs/" + site.String()},
+		{"Example.com.", "/ipfs/" + site.String()},
+		{"legacy.org", "/ipfs/" + other.String()},
+		{"docs.org", "/ipfs/" + site.String() + "/guide"},
+		{"keyed.org", "/ipfs/" + site.String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			value, err := m.ResolveDNSLink(ctx, tt.domain)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, value)
+		})
+	}
+
+	t.Run("Multiple Values", func(t *testing.T) {
+		want := "/ipfs/" + site.String()
+		if other.String() < site.String() {
+			want = "/ipfs/" + other.String()
+		}
+		value, err := m.ResolveDNSLink(ctx, "multi.org")
+		require.NoError(t, err)
+		assert.Equal(t, want, value)
+	})
+
+	t.Run("Failures", func(t *testing.T) {
+		for _, domain := range []string{"missing.org", "bad.org"} {
+			_, err := m.ResolveDNSLink(ctx, domain)
+			assert.ErrorIs(t, err, ipns.ErrNoDNSLink, domain)
+		}
+		_, err := m.ResolveDNSLink(ctx, "loop.org")
+		assert.ErrorContains(t, err, "too many redirections")
+	})
+
+	t.Run("Through ResolveIPNS", func(t *testing.T) {
+		value, err := m.ResolveIPNS(ctx, "/ipns/example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "/ipfs/"+site.String(), value)
+	})
+
+	t.Run("Caching", func(t *testing.T) {
+		resolver := ipns.NewDNSLinkResolver(txt, time.Hour)
+		txt.mu.Lock()
+		txt.lookups = 0
+		txt.mu.Unlock()
+		for range 3 {
+			_, err := resolver.Resolve(ctx, "example.com")
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 1, txt.lookups)
+
+		resolver.Forget("example.com")
+		_, err := resolver.Resolve(ctx, "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, 2, txt.lookups)
+	})
+}
+
+func TestIPNSPublishPath(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	blog, err := dagWrapper.PutAny(ctx, map[string]any{"posts": 3})
+	require.NoError(t, err)
+	site, err := dagWrapper.PutAny(ctx, map[string]any{"pages": 10})
+	require.NoError(t, err)
+	v1, err := dagWrapper.PutAny(ctx, map[string]any{"docs": 1})
+	require.NoError(t, err)
+
+	m := ipns.NewIPNSManager(dagWrapper)
+	id, err := m.GenerateKey(ctx, "home")
+	require.NoError(t, err)
+
+	// resolve follows /ipns/<key>/<subpath> to the CID it names
+	resolve := func(subpath string) (cid.Cid, error) {
+		value, err := m.ResolveIPNS(ctx, id.String())
+		if err != nil {
+			return cid.Undef, err
+		}
+		root, err := ipns.ExtractCIDFromIPFSPath(value)
+		if err != nil {
+			return cid.Undef, err
+		}
+		_, c, _, err := dagWrapper.ResolvePath(ctx, root, subpath)
+		return c, err
+	}
+
+	record, err := m.PublishPath(ctx, "home", "blog", blog, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), record.Sequence)
+	record, err = m.PublishPath(ctx, "home", "/site/", site, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), record.Sequence)
+	_, err = m.PublishPath(ctx, "home", "docs/v1", v1, time.Hour)
+	require.NoError(t, err)
+
+	for subpath, want := range map[string]cid.Cid{"blog": blog, "site": site, "docs/v1": v1} {
+		got, err := resolve(subpath)
+		require.NoError(t, err, subpath)
+		assert.Equal(t, want, got, subpath)
+	}
+
+	t.Run("Replace Entry", func(t *testing.T) {
+		before, err := resolve("site")
+		require.NoError(t, err)
+		_, err = m.PublishPath(ctx, "home", "blog", site, time.Hour)
+		require.NoError(t, err)
+		got, err := resolve("blog")
+		require.NoError(t, err)
+		assert.Equal(t, site, got)
+		after, err := resolve("site")
+		require.NoError(t, err)
+		assert.Equal(t, before, after, "other entries keep their CIDs")
+	})
+
+	t.Run("Unpublish", func(t *testing.T) {
+		_, err := m.UnpublishPath(ctx, "home", "docs/v1", time.Hour)
+		require.NoError(t, err)
+		_, err = resolve("docs/v1")
+		assert.Error(t, err)
+		_, err = resolve("docs")
+		assert.NoError(t, err, "parent directory stays")
+
+		_, err = m.UnpublishPath(ctx, "home", "missing", time.Hour)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, subpath := range []string{"", "/", "a/../b", "a//b"} {
+			_, err := m.PublishPath(ctx, "home", subpath, blog, time.Hour)
+			assert.Error(t, err, subpath)
+		}
+		_, err := m.PublishPath(ctx, "unknown", "blog", blog, time.Hour)
+		assert.Error(t, err)
+
+		// blog now holds a dag-cbor node, not a directory
+		_, err = m.PublishPath(ctx, "home", "blog/inner", v1, time.Hour)
+		assert.Error(t, err)
+
+		// A name published at its root with a non-directory cannot be extended
+		_, err = m.GenerateKey(ctx, "flat")
+		require.NoError(t, err)
+		_, err = m.PublishIPNS(ctx, "flat", blog, time.Hour)
+		require.NoError(t, err)
+		_, err = m.PublishPath(ctx, "flat", "blog", blog, time.Hour)
+		assert.ErrorContains(t, err, "does not point at a directory")
+	})
+}
+
+func TestIPNSOfflineSigning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+
+	v1, err := dagWrapper.PutAny(ctx, map[string]any{"release": 1})
+	require.NoError(t, err)
+	v2, err := dagWrapper.PutAny(ctx, map[string]any{"release": 2})
+	require.NoError(t, err)
+
+	// The private key never reaches the publishing manager
+	for keyName, keyType := range map[string]int{"Ed25519": crypto.Ed25519, "RSA": crypto.RSA} {
+		sk, pub, err := crypto.GenerateKeyPair(keyType, 2048)
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+
+		t.Run(keyName, func(t *testing.T) {
+			publisher := ipns.NewIPNSManager(dagWrapper)
+
+			unsigned, err := publisher.CreateUnsignedRecord(ctx, pub, v1, time.Hour)
+			require.NoError(t, err)
+			assert.Equal(t, id.String(), unsigned.Name)
+			assert.Equal(t, "/ipfs/"+v1.String(), unsigned.Value)
+
+			// Carried to the air-gapped machine and back as JSON
+			exported, err := json.Marshal(unsigned)
+			require.NoError(t, err)
+			var offline ipns.UnsignedRecord
+			require.NoError(t, json.Unmarshal(exported, &offline))
+			signature, err := ipns.SignRecord(sk, &offline)
+			require.NoError(t, err)
+
+			record, err := publisher.PublishSigned(ctx, unsigned, signature)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(0), record.Sequence)
+			assert.False(t, record.V1Compatible)
+			require.NoError(t, boxoipns.ValidateWithName(record.Record, boxoipns.NameFromPeer(id)))
+			value, err := publisher.ResolveIPNS(ctx, id.String())
+			require.NoError(t, err)
+			assert.Equal(t, "/ipfs/"+v1.String(), value)
+
+			// The next record continues the sequence
+			next, err := publisher.CreateUnsignedRecord(ctx, pub, v2, time.Hour)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), next.Sequence)
+
+			// A signature over other data, or by another key, is rejected
+			_, err = publisher.PublishSigned(ctx, next, signature)
+			assert.ErrorIs(t, err, boxoipns.ErrSignature)
+			otherSK, _, err := crypto.GenerateEd25519Key(nil)
+			require.NoError(t, err)
+			_, err = ipns.SignRecord(otherSK, next)
+			assert.Error(t, err)
+			payload, err := next.SigningPayload()
+			require.NoError(t, err)
+			forged, err := otherSK.Sign(payload)
+			require.NoError(t, err)
+			_, err = publisher.PublishSigned(ctx, next, forged)
+			assert.ErrorIs(t, err, boxoipns.ErrSignature)
+
+			signature, err = ipns.SignRecord(sk, next)
+			require.NoError(t, err)
+			_, err = publisher.PublishSigned(ctx, next, signature)
+			require.NoError(t, err)
+			value, err = publisher.ResolveIPNS(ctx, id.String())
+			require.NoError(t, err)
+			assert.Equal(t, "/ipfs/"+v2.String(), value)
+
+			// A record swapped on the way to the signer is refused, even
+			// though the fields shown for review are untouched
+			swapped, err := publisher.CreateUnsignedRecord(ctx, pub, v1, time.Hour)
+			require.NoError(t, err)
+			tampered := *next
+			tampered.Record = swapped.Record
+			_, err = ipns.SignRecord(sk, &tampered)
+			assert.ErrorContains(t, err, "record points to")
+			require.NoError(t, next.Check())
+
+			// Replaying the first record after the second is refused
+			first, err := ipns.SignRecord(sk, unsigned)
+			require.NoError(t, err)
+			_, err = publisher.PublishSigned(ctx, unsigned, first)
+			assert.Error(t, err)
+		})
+	}
+}
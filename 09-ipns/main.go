@@ -251,7 +251,7 @@ func testIPNSResolution(ctx context.Context, manager *ipns.IPNSManager, name str
 
 func getRecordStatus(record *ipns.IPNSRecord) string {
 	now := time.Now()
-	expirationTime := record.CreatedAt.Add(time.Duration(record.TTL) * time.Second)
+	expirationTime := record.Validity
 
 	if now.After(expirationTime) {
 		return fmt.Sprintf("❌ EXPIRED (%s ago)",
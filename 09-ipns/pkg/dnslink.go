@@ -0,0 +1,128 @@
+package ipns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/path"
+)
+
+// DefaultDNSLinkCacheTTL is how long resolved DNSLink values are reused
+const DefaultDNSLinkCacheTTL = time.Minute
+
+// maxDNSLinkDepth bounds chains of DNSLinks pointing at /ipns/<domain>
+const maxDNSLinkDepth = 32
+
+// ErrNoDNSLink is returned when a domain has no dnslink= TXT record
+var ErrNoDNSLink = errors.New("no dnslink record")
+
+// TXTResolver looks up DNS TXT records. *net.Resolver implements it;
+// tests and DNS-over-HTTPS clients can supply their own.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DNSLinkResolver reads DNSLink values ("dnslink=/ipfs/<cid>") from the
+// _dnslink TXT records of a domain and caches them
+type DNSLinkResolver struct {
+	txt TXTResolver
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]dnslinkEntry
+}
+
+type dnslinkEntry struct {
+	value   string
+	expires time.Time
+}
+
+// NewDNSLinkResolver creates a resolver using txt for lookups and keeping
+// answers for ttl. A nil txt uses the system resolver; ttl 0 uses
+// DefaultDNSLinkCacheTTL.
+func NewDNSLinkResolver(txt TXTResolver, ttl time.Duration) *DNSLinkResolver {
+	if txt == nil {
+		txt = net.DefaultResolver
+	}
+	if ttl == 0 {
+		ttl = DefaultDNSLinkCacheTTL
+	}
+	return &DNSLinkResolver{txt: txt, ttl: ttl, cache: make(map[string]dnslinkEntry)}
+}
+
+// Resolve returns the DNSLink value of domain, e.g. "/ipfs/<cid>" or
+// "/ipns/<name>". _dnslink.<domain> is tried first, then the domain
+// itself as older setups do. When several values are published the
+// lexicographically first valid one wins, so every resolver agrees.
+func (r *DNSLinkResolver) Resolve(ctx context.Context, domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return "", fmt.Errorf("empty domain")
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[domain]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	var lookupErr error
+	for _, host := range []string{"_dnslink." + domain, domain} {
+		txts, err := r.txt.LookupTXT(ctx, host)
+		if err != nil {
+			lookupErr = err
+			continue
+		}
+		if value, ok := parseDNSLink(txts); ok {
+			r.mu.Lock()
+			r.cache[domain] = dnslinkEntry{value: value, expires: time.Now().Add(r.ttl)}
+			r.mu.Unlock()
+			return value, nil
+		}
+	}
+	if lookupErr != nil {
+		return "", fmt.Errorf("%w for %s: %w", ErrNoDNSLink, domain, lookupErr)
+	}
+	return "", fmt.Errorf("%w for %s", ErrNoDNSLink, domain)
+}
+
+// Forget drops the cached value of domain
+func (r *DNSLinkResolver) Forget(domain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, strings.TrimSuffix(strings.ToLower(domain), "."))
+}
+
+// parseDNSLink picks the value out of TXT records of the form
+// "dnslink=/ipfs/<cid>[/path]" or "dnslink=/ipns/<name>[/path]"
+func parseDNSLink(txts []string) (string, bool) {
+	var values []string
+	for _, txt := range txts {
+		value, ok := strings.CutPrefix(strings.TrimSpace(txt), "dnslink=")
+		if !ok {
+			continue
+		}
+		if _, err := path.NewPath(value); err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	sort.Strings(values)
+	return values[0], true
+}
+
+// isDomainName tells DNSLink names apart from keys: peer IDs and CID-form
+// IPNS names never contain a dot
+func isDomainName(name string) bool {
+	return strings.Contains(name, ".")
+}
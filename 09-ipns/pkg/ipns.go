@@ -4,35 +4,94 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ipfs/boxo/ipns"
+	ipns_pb "github.com/ipfs/boxo/ipns/pb"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
 
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 )
 
+// NameRouter publishes and resolves IPNS records over the network.
+// The 03-dht-router DHTWrapper implements it.
+type NameRouter interface {
+	PutIPNS(ctx context.Context, name ipns.Name, rec *ipns.Record) error
+	GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error)
+}
+
 // IPNSManager manages IPNS records and name resolution
 type IPNSManager struct {
 	dagWrapper *dag.IpldWrapper
+	router     NameRouter // nil keeps records local
 	records    map[string]*IPNSRecord
 	keys       map[string]crypto.PrivKey
+	noV1       bool             // sign V2 only
+	dnslink    *DNSLinkResolver // created on first use
 	mutex      sync.RWMutex
 }
 
-// IPNSRecord represents an IPNS record with metadata
+// IPNSRecord is a signed IPNS record plus the metadata the manager keeps
+// about it. Value, TTL, Sequence and Validity are read from Record.
 type IPNSRecord struct {
-	Name       string         `json:"name"`       // IPNS name (peer ID)
-	Value      string         `json:"value"`      // CID or path this name points to
-	CreatedAt  time.Time      `json:"created_at"` // When record was created
-	UpdatedAt  time.Time      `json:"updated_at"` // Last update time
-	TTL        uint64         `json:"ttl"`        // Time to live in seconds
-	Sequence   uint64         `json:"sequence"`   // Sequence number for updates
-	PrivateKey crypto.PrivKey `json:"-"`          // Private key (not exported)
+	Name         string       `json:"name"`          // IPNS name (peer ID)
+	Value        string       `json:"value"`         // CID or path this name points to
+	CreatedAt    time.Time    `json:"created_at"`    // When record was created
+	UpdatedAt    time.Time    `json:"updated_at"`    // Last update time
+	TTL          uint64       `json:"ttl"`           // How long resolvers may cache it, in seconds
+	Sequence     uint64       `json:"sequence"`      // Sequence number for updates
+	Validity     time.Time    `json:"validity"`      // End of life; the record is invalid after it
+	V1Compatible bool         `json:"v1_compatible"` // Also carries the legacy V1 signature
+	Record       *ipns.Record `json:"-"`             // The signed record as sent on the network
+}
+
+// newIPNSRecord reads the fields of an IPNSRecord out of rec
+func newIPNSRecord(name ipns.Name, rec *ipns.Record, createdAt, updatedAt time.Time) (*IPNSRecord, error) {
+	value, err := rec.Value()
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS record value: %w", err)
+	}
+	seq, err := rec.Sequence()
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS record sequence: %w", err)
+	}
+	ttl, err := rec.TTL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS record TTL: %w", err)
+	}
+	vt, err := rec.ValidityType()
+	if err != nil || vt != ipns.ValidityEOL {
+		return nil, fmt.Errorf("invalid IPNS record: %w", ipns.ErrUnrecognizedValidity)
+	}
+	eol, err := rec.Validity()
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS record validity: %w", err)
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	var pb ipns_pb.IpnsRecord
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	return &IPNSRecord{
+		Name:         name.Peer().String(),
+		Value:        value.String(),
+		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+		TTL:          uint64(ttl.Seconds()),
+		Sequence:     seq,
+		Validity:     eol,
+		V1Compatible: len(pb.GetSignatureV1()) > 0,
+		Record:       rec,
+	}, nil
 }
 
 // NewIPNSManager creates a new IPNS manager
@@ -44,6 +103,31 @@ func NewIPNSManager(dagWrapper *dag.IpldWrapper) *IPNSManager {
 	}
 }
 
+// NewIPNSManagerWithRouter creates an IPNS manager that also publishes
+// records through router and resolves unknown names from it
+func NewIPNSManagerWithRouter(dagWrapper *dag.IpldWrapper, router NameRouter) *IPNSManager {
+	m := NewIPNSManager(dagWrapper)
+	m.router = router
+	return m
+}
+
+// SetV1Compatibility controls whether new records also carry the legacy
+// V1 signature. Only the V2 signature is verified by current nodes; like
+// Kubo, the manager adds V1 by default so older nodes accept the records.
+func (m *IPNSManager) SetV1Compatibility(enabled bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.noV1 = !enabled
+}
+
+// SetDNSLinkResolver sets the resolver used for DNSLink names; by
+// default one using the system resolver is created on first use
+func (m *IPNSManager) SetDNSLinkResolver(r *DNSLinkResolver) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dnslink = r
+}
+
 // GenerateKey generates a new keypair for IPNS
 func (m *IPNSManager) GenerateKey(ctx context.Context, keyName string) (peer.ID, error) {
 	m.mutex.Lock()
@@ -72,148 +156,242 @@ func (m *IPNSManager) PublishIPNS(ctx context.Context, keyName string, value cid
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// Get the private key
-	privKey, exists := m.keys[keyName]
-	if !exists {
-		return nil, fmt.Errorf("key not found: %s", keyName)
+	privKey, name, err := m.key(keyName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get peer ID
-	peerID, err := peer.IDFromPrivateKey(privKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get peer ID: %w", err)
+	// Continue the sequence of an existing record
+	var sequence uint64 = 0
+	createdAt := time.Now()
+	if existing, exists := m.records[name.Peer().String()]; exists {
+		sequence = existing.Sequence + 1
+		createdAt = existing.CreatedAt
 	}
 
-	ipnsName := peerID.String()
+	return m.publish(ctx, privKey, name, value, sequence, ttl, createdAt)
+}
 
-	// Check if record exists for sequence number
-	var sequence uint64 = 0
-	if existingRecord, exists := m.records[ipnsName]; exists {
-		sequence = existingRecord.Sequence + 1
+// UpdateIPNS updates an existing IPNS record
+func (m *IPNSManager) UpdateIPNS(ctx context.Context, keyName string, newValue cid.Cid, ttl time.Duration) (*IPNSRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	privKey, name, err := m.key(keyName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create IPNS record
-	now := time.Now()
-	eol := now.Add(ttl)
+	existing, exists := m.records[name.Peer().String()]
+	if !exists {
+		return nil, fmt.Errorf("IPNS record not found: %s", name.Peer())
+	}
 
-	// Create path from CID
-	ipfsPath := path.FromCid(value)
+	return m.publish(ctx, privKey, name, newValue, existing.Sequence+1, ttl, existing.CreatedAt)
+}
 
-	// Create the actual IPNS record using boxo
-	ipnsRecord, err := ipns.NewRecord(privKey, ipfsPath, sequence, eol, ttl)
+// key returns the private key stored as keyName and the name it signs for
+func (m *IPNSManager) key(keyName string) (crypto.PrivKey, ipns.Name, error) {
+	privKey, exists := m.keys[keyName]
+	if !exists {
+		return nil, ipns.Name{}, fmt.Errorf("key not found: %s", keyName)
+	}
+	peerID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, ipns.Name{}, fmt.Errorf("failed to get peer ID: %w", err)
+	}
+	return privKey, ipns.NameFromPeer(peerID), nil
+}
+
+// publish signs a record for value valid for ttl and commits it. The
+// caller holds the write lock.
+func (m *IPNSManager) publish(ctx context.Context, privKey crypto.PrivKey, name ipns.Name, value cid.Cid, sequence uint64, ttl time.Duration, createdAt time.Time) (*IPNSRecord, error) {
+	rec, err := ipns.NewRecord(privKey, path.FromCid(value), sequence, time.Now().Add(ttl), ttl,
+		ipns.WithV1Compatibility(!m.noV1))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create IPNS record: %w", err)
 	}
+	return m.commit(ctx, name, rec, createdAt)
+}
 
-	// Create IPNS name from peer ID
-	ipnsNameObj := ipns.NameFromPeer(peerID)
+// commit checks rec the way a resolver would, sends it through the
+// router and stores it. The caller holds the write lock.
+func (m *IPNSManager) commit(ctx context.Context, name ipns.Name, rec *ipns.Record, createdAt time.Time) (*IPNSRecord, error) {
+	if err := ipns.ValidateWithName(rec, name); err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
 
-	// Validate the record
-	err = ipns.ValidateWithName(ipnsRecord, ipnsNameObj)
+	// Publish to the network before committing locally
+	if m.router != nil {
+		if err := m.router.PutIPNS(ctx, name, rec); err != nil {
+			return nil, fmt.Errorf("failed to publish IPNS record: %w", err)
+		}
+	}
+
+	record, err := newIPNSRecord(name, rec, createdAt, time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+		return nil, err
 	}
+	m.records[record.Name] = record
+	return record, nil
+}
 
-	// Store our record
-	record := &IPNSRecord{
-		Name:       ipnsName,
-		Value:      "/ipfs/" + value.String(),
-		CreatedAt:  now,
-		UpdatedAt:  now,
-		TTL:        uint64(ttl.Seconds()),
-		Sequence:   sequence,
-		PrivateKey: privKey,
+// ImportRecord stores a record signed elsewhere, e.g. by Kubo
+// (`ipfs routing get /ipns/<name>`), after checking its signature against
+// name. A record older than the one already held is rejected.
+func (m *IPNSManager) ImportRecord(ctx context.Context, name string, data []byte) (*IPNSRecord, error) {
+	ipnsName, err := ipns.NameFromString(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS name: %w", err)
+	}
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	if err := ipns.ValidateWithName(rec, ipnsName); err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
 	}
 
-	m.records[ipnsName] = record
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
+	now := time.Now()
+	key := ipnsName.Peer().String()
+	createdAt := now
+	if existing, exists := m.records[key]; exists {
+		if newerRecord(existing.Record, rec) {
+			return nil, fmt.Errorf("IPNS record for %s is older than the one held", key)
+		}
+		createdAt = existing.CreatedAt
+	}
+	record, err := newIPNSRecord(ipnsName, rec, createdAt, now)
+	if err != nil {
+		return nil, err
+	}
+	m.records[key] = record
 	return record, nil
 }
 
-// ResolveIPNS resolves an IPNS name to its current value
-func (m *IPNSManager) ResolveIPNS(ctx context.Context, name string) (string, error) {
+// ExportRecord returns the signed record for name in the protobuf wire
+// format, which Kubo accepts with `ipfs routing put`. Names the manager
+// holds no record for are fetched through the router.
+func (m *IPNSManager) ExportRecord(ctx context.Context, name string) ([]byte, error) {
+	name = cleanIPNSName(name)
+
 	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	record, exists := m.records[name]
+	m.mutex.RUnlock()
+	if exists {
+		return ipns.MarshalRecord(record.Record)
+	}
+	if m.router == nil {
+		return nil, fmt.Errorf("IPNS record not found: %s", name)
+	}
+
+	ipnsName, err := ipns.NameFromString(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS name: %w", err)
+	}
+	rec, err := m.router.GetIPNS(ctx, ipnsName)
+	if err != nil {
+		return nil, fmt.Errorf("IPNS record not found: %s: %w", name, err)
+	}
+	if err := ipns.ValidateWithName(rec, ipnsName); err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	return ipns.MarshalRecord(rec)
+}
 
+// ResolveIPNS resolves an IPNS name to its current value
+func (m *IPNSManager) ResolveIPNS(ctx context.Context, name string) (string, error) {
 	// Clean the name (remove /ipns/ prefix if present)
 	name = cleanIPNSName(name)
 
+	if isDomainName(name) {
+		return m.ResolveDNSLink(ctx, name)
+	}
+
+	m.mutex.RLock()
 	record, exists := m.records[name]
+	m.mutex.RUnlock()
 	if !exists {
+		if m.router != nil {
+			return m.resolveRemote(ctx, name)
+		}
 		return "", fmt.Errorf("IPNS name not found: %s", name)
 	}
 
 	// Check if record has expired
-	expirationTime := record.CreatedAt.Add(time.Duration(record.TTL) * time.Second)
-	if time.Now().After(expirationTime) {
+	if time.Now().After(record.Validity) {
 		return "", fmt.Errorf("IPNS record expired: %s", name)
 	}
 
 	return record.Value, nil
 }
 
-// UpdateIPNS updates an existing IPNS record
-func (m *IPNSManager) UpdateIPNS(ctx context.Context, keyName string, newValue cid.Cid, ttl time.Duration) (*IPNSRecord, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// ResolveDNSLink resolves the DNSLink of domain, e.g. "example.com", to
+// an /ipfs/ path. Values pointing at /ipns/ names or other domains are
+// followed.
+func (m *IPNSManager) ResolveDNSLink(ctx context.Context, domain string) (string, error) {
+	return m.resolveDNSLink(ctx, domain, 0)
+}
 
-	// Get the private key
-	privKey, exists := m.keys[keyName]
-	if !exists {
-		return nil, fmt.Errorf("key not found: %s", keyName)
+func (m *IPNSManager) resolveDNSLink(ctx context.Context, domain string, depth int) (string, error) {
+	if depth >= maxDNSLinkDepth {
+		return "", fmt.Errorf("dnslink %s: too many redirections", domain)
 	}
 
-	// Get peer ID
-	peerID, err := peer.IDFromPrivateKey(privKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get peer ID: %w", err)
+	m.mutex.Lock()
+	if m.dnslink == nil {
+		m.dnslink = NewDNSLinkResolver(nil, 0)
 	}
+	resolver := m.dnslink
+	m.mutex.Unlock()
 
-	ipnsName := peerID.String()
-
-	// Get existing record for sequence number
-	existingRecord, exists := m.records[ipnsName]
-	if !exists {
-		return nil, fmt.Errorf("IPNS record not found: %s", ipnsName)
+	value, err := resolver.Resolve(ctx, domain)
+	if err != nil {
+		return "", err
+	}
+	target, ok := strings.CutPrefix(value, "/ipns/")
+	if !ok {
+		return value, nil
 	}
 
-	// Create updated record
-	now := time.Now()
-	sequence := existingRecord.Sequence + 1
-	eol := now.Add(ttl)
-
-	// Create path from CID
-	ipfsPath := path.FromCid(newValue)
-
-	// Create the actual IPNS record using boxo
-	ipnsRecord, err := ipns.NewRecord(privKey, ipfsPath, sequence, eol, ttl)
+	name, rest, _ := strings.Cut(target, "/")
+	var resolved string
+	if isDomainName(name) {
+		resolved, err = m.resolveDNSLink(ctx, name, depth+1)
+	} else {
+		resolved, err = m.ResolveIPNS(ctx, name)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create IPNS record: %w", err)
+		return "", fmt.Errorf("dnslink %s: %w", domain, err)
 	}
+	if rest != "" {
+		resolved = strings.TrimSuffix(resolved, "/") + "/" + rest
+	}
+	return resolved, nil
+}
 
-	// Create IPNS name from peer ID
-	ipnsNameObj := ipns.NameFromPeer(peerID)
-
-	// Validate the record
-	err = ipns.ValidateWithName(ipnsRecord, ipnsNameObj)
+// resolveRemote looks name up through the router; the record's
+// signature and expiry are checked before its value is used
+func (m *IPNSManager) resolveRemote(ctx context.Context, name string) (string, error) {
+	ipnsName, err := ipns.NameFromString(name)
 	if err != nil {
-		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+		return "", fmt.Errorf("invalid IPNS name: %w", err)
 	}
-
-	// Update our record
-	record := &IPNSRecord{
-		Name:       ipnsName,
-		Value:      "/ipfs/" + newValue.String(),
-		CreatedAt:  existingRecord.CreatedAt,
-		UpdatedAt:  now,
-		TTL:        uint64(ttl.Seconds()),
-		Sequence:   sequence,
-		PrivateKey: privKey,
+	rec, err := m.router.GetIPNS(ctx, ipnsName)
+	if err != nil {
+		return "", fmt.Errorf("IPNS name not found: %s: %w", name, err)
 	}
-
-	m.records[ipnsName] = record
-
-	return record, nil
+	if err := ipns.ValidateWithName(rec, ipnsName); err != nil {
+		return "", fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	value, err := rec.Value()
+	if err != nil {
+		return "", fmt.Errorf("invalid IPNS record value: %w", err)
+	}
+	return value.String(), nil
 }
 
 // ListIPNSRecords lists all IPNS records
@@ -223,21 +401,31 @@ func (m *IPNSManager) ListIPNSRecords(ctx context.Context) ([]*IPNSRecord, error
 
 	var records []*IPNSRecord
 	for _, record := range m.records {
-		// Create a copy without the private key for safety
-		recordCopy := &IPNSRecord{
-			Name:      record.Name,
-			Value:     record.Value,
-			CreatedAt: record.CreatedAt,
-			UpdatedAt: record.UpdatedAt,
-			TTL:       record.TTL,
-			Sequence:  record.Sequence,
-		}
-		records = append(records, recordCopy)
+		recordCopy := *record
+		records = append(records, &recordCopy)
 	}
 
 	return records, nil
 }
 
+// GCRoots returns the CIDs the records point to, so a garbage collector
+// keeps the content published under them (see 08-pin-gc RootSource).
+// Values that are not /ipfs paths are skipped.
+func (m *IPNSManager) GCRoots(ctx context.Context) ([]cid.Cid, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var roots []cid.Cid
+	for _, record := range m.records {
+		c, err := ExtractCIDFromIPFSPath(record.Value)
+		if err != nil {
+			continue
+		}
+		roots = append(roots, c)
+	}
+	return roots, nil
+}
+
 // GetIPNSRecord gets a specific IPNS record
 func (m *IPNSManager) GetIPNSRecord(ctx context.Context, name string) (*IPNSRecord, error) {
 	m.mutex.RLock()
@@ -250,15 +438,8 @@ func (m *IPNSManager) GetIPNSRecord(ctx context.Context, name string) (*IPNSReco
 		return nil, fmt.Errorf("IPNS record not found: %s", name)
 	}
 
-	// Return a copy without the private key
-	return &IPNSRecord{
-		Name:      record.Name,
-		Value:     record.Value,
-		CreatedAt: record.CreatedAt,
-		UpdatedAt: record.UpdatedAt,
-		TTL:       record.TTL,
-		Sequence:  record.Sequence,
-	}, nil
+	recordCopy := *record
+	return &recordCopy, nil
 }
 
 // DeleteIPNS deletes an IPNS record
@@ -266,22 +447,13 @@ func (m *IPNSManager) DeleteIPNS(ctx context.Context, keyName string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// Get the private key to find the peer ID
-	privKey, exists := m.keys[keyName]
-	if !exists {
-		return fmt.Errorf("key not found: %s", keyName)
-	}
-
-	// Get peer ID
-	peerID, err := peer.IDFromPrivateKey(privKey)
+	_, name, err := m.key(keyName)
 	if err != nil {
-		return fmt.Errorf("failed to get peer ID: %w", err)
+		return err
 	}
 
-	ipnsName := peerID.String()
-
 	// Delete the record and key
-	delete(m.records, ipnsName)
+	delete(m.records, name.Peer().String())
 	delete(m.keys, keyName)
 
 	return nil
@@ -299,8 +471,7 @@ func (m *IPNSManager) IsExpired(ctx context.Context, name string) (bool, error)
 		return true, fmt.Errorf("IPNS record not found: %s", name)
 	}
 
-	expirationTime := record.CreatedAt.Add(time.Duration(record.TTL) * time.Second)
-	return time.Now().After(expirationTime), nil
+	return time.Now().After(record.Validity), nil
 }
 
 // GetStats returns IPNS manager statistics
@@ -316,8 +487,7 @@ func (m *IPNSManager) GetStats(ctx context.Context) (*IPNSStats, error) {
 		totalRecords++
 
 		// Check expiration
-		expirationTime := record.CreatedAt.Add(time.Duration(record.TTL) * time.Second)
-		if now.After(expirationTime) {
+		if now.After(record.Validity) {
 			expiredRecords++
 		} else {
 			activeRecords++
@@ -352,10 +522,14 @@ type IPNSStats struct {
 	NewestRecord   time.Time `json:"newest_record"`
 }
 
-// cleanIPNSName removes /ipns/ prefix from name if present
+// cleanIPNSName removes /ipns/ prefix from name if present and turns the
+// CID form of a name (k51...) into the peer ID records are kept under
 func cleanIPNSName(name string) string {
 	if len(name) > 6 && name[:6] == "/ipns/" {
-		return name[6:]
+		name = name[6:]
+	}
+	if n, err := ipns.NameFromString(name); err == nil {
+		return n.Peer().String()
 	}
 	return name
 }
@@ -0,0 +1,186 @@
+package ipns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	ipns_pb "github.com/ipfs/boxo/ipns/pb"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// signaturePrefix is prepended to a record's Data to form the bytes its
+// V2 signature covers
+var signaturePrefix = []byte("ipns-signature:")
+
+// UnsignedRecord is an IPNS record waiting for its signature, for names
+// whose private key is kept offline. It is created where only the public
+// key is known, carried (e.g. as JSON) to the machine holding the private
+// key for SignRecord, and completed with PublishSigned. The other fields
+// describe Record for whoever reviews it before signing.
+type UnsignedRecord struct {
+	Name     string    `json:"name"`     // IPNS name (peer ID)
+	Value    string    `json:"value"`    // Path the name will point to
+	Sequence uint64    `json:"sequence"` // Sequence number of the record
+	Validity time.Time `json:"validity"` // End of life of the record
+	TTL      uint64    `json:"ttl"`      // Cache time in seconds
+	Record   []byte    `json:"record"`   // Protobuf record without its signature
+}
+
+// unsignedKey stands in for a private key when only the public one is
+// available: boxo builds the record as usual and the signature stays empty
+type unsignedKey struct {
+	crypto.PubKey
+}
+
+func (k unsignedKey) Sign([]byte) ([]byte, error) { return nil, nil }
+func (k unsignedKey) GetPublic() crypto.PubKey    { return k.PubKey }
+
+// CreateUnsignedRecord builds the record pointing the name of pub at
+// value, continuing the sequence of the record held for that name. The
+// record only carries a V2 signature once signed.
+func (m *IPNSManager) CreateUnsignedRecord(ctx context.Context, pub crypto.PubKey, value cid.Cid, ttl time.Duration) (*UnsignedRecord, error) {
+	peerID, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer ID: %w", err)
+	}
+
+	m.mutex.RLock()
+	var sequence uint64 = 0
+	if existing, exists := m.records[peerID.String()]; exists {
+		sequence = existing.Sequence + 1
+	}
+	m.mutex.RUnlock()
+
+	eol := time.Now().Add(ttl)
+	rec, err := ipns.NewRecord(unsignedKey{pub}, path.FromCid(value), sequence, eol, ttl,
+		ipns.WithV1Compatibility(false))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPNS record: %w", err)
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnsignedRecord{
+		Name:     peerID.String(),
+		Value:    path.FromCid(value).String(),
+		Sequence: sequence,
+		Validity: eol,
+		TTL:      uint64(ttl.Seconds()),
+		Record:   data,
+	}, nil
+}
+
+// SigningPayload returns the bytes the record's signature must cover, for
+// signers other than SignRecord such as hardware tokens
+func (u *UnsignedRecord) SigningPayload() ([]byte, error) {
+	var pb ipns_pb.IpnsRecord
+	if err := proto.Unmarshal(u.Record, &pb); err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	if len(pb.GetData()) == 0 {
+		return nil, ipns.ErrDataMissing
+	}
+	return append(bytes.Clone(signaturePrefix), pb.GetData()...), nil
+}
+
+// Check reports whether Record says what the other fields show, so a
+// reviewer approving Value is not signing a record that points elsewhere
+func (u *UnsignedRecord) Check() error {
+	rec, err := ipns.UnmarshalRecord(u.Record)
+	if err != nil {
+		return fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	value, err := rec.Value()
+	if err != nil {
+		return fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	sequence, err := rec.Sequence()
+	if err != nil {
+		return fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	validity, err := rec.Validity()
+	if err != nil {
+		return fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	ttl, err := rec.TTL()
+	if err != nil {
+		return fmt.Errorf("invalid IPNS record: %w", err)
+	}
+
+	switch {
+	case value.String() != u.Value:
+		return fmt.Errorf("record points to %s, not %s", value, u.Value)
+	case sequence != u.Sequence:
+		return fmt.Errorf("record has sequence %d, not %d", sequence, u.Sequence)
+	case !validity.Equal(u.Validity):
+		return fmt.Errorf("record expires at %s, not %s", validity, u.Validity)
+	case uint64(ttl.Seconds()) != u.TTL:
+		return fmt.Errorf("record has a TTL of %s, not %ds", ttl, u.TTL)
+	}
+	return nil
+}
+
+// SignRecord signs u with sk, on the machine that keeps the key. It
+// refuses keys that do not belong to u.Name and records whose content
+// differs from the fields shown for review.
+func SignRecord(sk crypto.PrivKey, u *UnsignedRecord) ([]byte, error) {
+	peerID, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer ID: %w", err)
+	}
+	if peerID.String() != u.Name {
+		return nil, fmt.Errorf("key %s cannot sign for %s", peerID, u.Name)
+	}
+	if err := u.Check(); err != nil {
+		return nil, err
+	}
+	payload, err := u.SigningPayload()
+	if err != nil {
+		return nil, err
+	}
+	return sk.Sign(payload)
+}
+
+// PublishSigned attaches signature to u and publishes the record like
+// PublishIPNS: it is validated against u.Name, sent through the router
+// and stored
+func (m *IPNSManager) PublishSigned(ctx context.Context, u *UnsignedRecord, signature []byte) (*IPNSRecord, error) {
+	name, err := ipns.NameFromString(u.Name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS name: %w", err)
+	}
+	var pb ipns_pb.IpnsRecord
+	if err := proto.Unmarshal(u.Record, &pb); err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
+	pb.SignatureV2 = signature
+	data, err := proto.Marshal(&pb)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS record: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	createdAt := time.Now()
+	if existing, exists := m.records[name.Peer().String()]; exists {
+		if newerRecord(existing.Record, rec) {
+			return nil, fmt.Errorf("IPNS record for %s is older than the one held", u.Name)
+		}
+		createdAt = existing.CreatedAt
+	}
+	return m.commit(ctx, name, rec, createdAt)
+}
@@ -0,0 +1,147 @@
+package ipns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ufs "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+)
+
+// PublishPath points subpath below the name of keyName at value, so that
+// /ipns/<key>/blog and /ipns/<key>/site can be published separately. The
+// name resolves to a UnixFS directory: the one it currently points at is
+// copied with the entry replaced (missing directories on the way are
+// created), and the new root is published with the next sequence number.
+// A key without a record starts from an empty directory.
+func (m *IPNSManager) PublishPath(ctx context.Context, keyName, subpath string, value cid.Cid, ttl time.Duration) (*IPNSRecord, error) {
+	target, err := m.dagWrapper.Get(ctx, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", value, err)
+	}
+	return m.editPath(ctx, keyName, subpath, ttl, func(dir *merkledag.ProtoNode, name string) error {
+		_ = dir.RemoveNodeLink(name)
+		return dir.AddNodeLink(name, target)
+	})
+}
+
+// UnpublishPath removes subpath from the directory the name of keyName
+// points at and re-publishes it
+func (m *IPNSManager) UnpublishPath(ctx context.Context, keyName, subpath string, ttl time.Duration) (*IPNSRecord, error) {
+	return m.editPath(ctx, keyName, subpath, ttl, func(dir *merkledag.ProtoNode, name string) error {
+		if err := dir.RemoveNodeLink(name); err != nil {
+			return fmt.Errorf("%s: %w", subpath, err)
+		}
+		return nil
+	})
+}
+
+// editPath applies edit to the directory holding the last segment of
+// subpath, stores the directories above it again and publishes the root
+func (m *IPNSManager) editPath(ctx context.Context, keyName, subpath string, ttl time.Duration, edit func(dir *merkledag.ProtoNode, name string) error) (*IPNSRecord, error) {
+	segments, err := splitSubpath(subpath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	privKey, name, err := m.key(keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	var sequence uint64 = 0
+	createdAt := time.Now()
+	root := ufs.EmptyDirNode()
+	if existing, exists := m.records[name.Peer().String()]; exists {
+		sequence = existing.Sequence + 1
+		createdAt = existing.CreatedAt
+		root, err = m.loadDir(ctx, existing.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s does not point at a directory: %w", name.Peer(), err)
+		}
+	}
+
+	newRoot, err := m.editDir(ctx, root, segments, edit)
+	if err != nil {
+		return nil, err
+	}
+	return m.publish(ctx, privKey, name, newRoot.Cid(), sequence, ttl, createdAt)
+}
+
+// editDir copies dir, descends into segments[0] and stores the result.
+// Directories that do not exist yet are created empty.
+func (m *IPNSManager) editDir(ctx context.Context, dir *merkledag.ProtoNode, segments []string, edit func(dir *merkledag.ProtoNode, name string) error) (*merkledag.ProtoNode, error) {
+	dir = dir.Copy().(*merkledag.ProtoNode)
+	if len(segments) == 1 {
+		if err := edit(dir, segments[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		child := ufs.EmptyDirNode()
+		if link, err := dir.GetNodeLink(segments[0]); err == nil {
+			if child, err = m.loadDir(ctx, "/ipfs/"+link.Cid.String()); err != nil {
+				return nil, fmt.Errorf("%s: %w", segments[0], err)
+			}
+		} else if !errors.Is(err, merkledag.ErrLinkNotFound) {
+			return nil, err
+		}
+
+		child, err := m.editDir(ctx, child, segments[1:], edit)
+		if err != nil {
+			return nil, err
+		}
+		_ = dir.RemoveNodeLink(segments[0])
+		if err := dir.AddNodeLink(segments[0], child); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := m.dagWrapper.PutNode(ctx, dir); err != nil {
+		return nil, fmt.Errorf("failed to store directory: %w", err)
+	}
+	return dir, nil
+}
+
+// loadDir loads the UnixFS directory at an /ipfs/<cid> value
+func (m *IPNSManager) loadDir(ctx context.Context, value string) (*merkledag.ProtoNode, error) {
+	c, err := ExtractCIDFromIPFSPath(value)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := m.dagWrapper.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a directory", c)
+	}
+	fsn, err := ufs.FSNodeFromBytes(pn.Data())
+	if err != nil || fsn.Type() != ufs.TDirectory {
+		return nil, fmt.Errorf("%s is not a plain directory", c)
+	}
+	return pn, nil
+}
+
+// splitSubpath turns "blog/posts" into its segments; empty, "." and ".."
+// segments are refused
+func splitSubpath(subpath string) ([]string, error) {
+	subpath = strings.Trim(subpath, "/")
+	if subpath == "" {
+		return nil, fmt.Errorf("empty sub-path; use PublishIPNS to publish the root")
+	}
+	segments := strings.Split(subpath, "/")
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			return nil, fmt.Errorf("invalid sub-path %q", subpath)
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,250 @@
+package ipns
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/boxo/ipns"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// ComposedNameRouter publishes to several name routers at once, e.g. the
+// DHT, a delegated routing endpoint and pubsub, and resolves from all of
+// them, keeping the newest valid record
+type ComposedNameRouter struct {
+	routers []NameRouter
+}
+
+var _ NameRouter = (*ComposedNameRouter)(nil)
+
+func NewComposedNameRouter(routers ...NameRouter) (*ComposedNameRouter, error) {
+	if len(routers) == 0 {
+		return nil, fmt.Errorf("composed name router needs at least one router")
+	}
+	for i, r := range routers {
+		if r == nil {
+			return nil, fmt.Errorf("name router %d is nil", i)
+		}
+	}
+	return &ComposedNameRouter{routers: routers}, nil
+}
+
+// PutIPNS sends rec to every router in parallel. Routers that report
+// routing.ErrNotSupported are skipped; the error joins the failures of the
+// others.
+func (r *ComposedNameRouter) PutIPNS(ctx context.Context, name ipns.Name, rec *ipns.Record) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i, nr := range r.routers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := nr.PutIPNS(ctx, name, rec)
+			if err == nil || errors.Is(err, routing.ErrNotSupported) {
+				return
+			}
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("name router %d: %w", i, err))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// GetIPNS asks every router in parallel and returns the record with the
+// highest sequence number, or the longest validity among equal ones.
+// It fails only if no router returned a valid record.
+func (r *ComposedNameRouter) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		best *ipns.Record
+		errs []error
+	)
+	for i, nr := range r.routers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec, err := nr.GetIPNS(ctx, name)
+			if err == nil {
+				err = ipns.ValidateWithName(rec, name)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("name router %d: %w", i, err))
+				return
+			}
+			if best == nil || newerRecord(rec, best) {
+				best = rec
+			}
+		}()
+	}
+	wg.Wait()
+	if best == nil {
+		return nil, errors.Join(append([]error{routing.ErrNotFound}, errs...)...)
+	}
+	return best, nil
+}
+
+// newerRecord reports whether a should replace b, the way DHT peers
+// choose between records
+func newerRecord(a, b *ipns.Record) bool {
+	sa, err := a.Sequence()
+	if err != nil {
+		return false
+	}
+	sb, err := b.Sequence()
+	if err != nil || sa != sb {
+		return err != nil || sa > sb
+	}
+	va, err := a.Validity()
+	if err != nil {
+		return false
+	}
+	vb, err := b.Validity()
+	return err != nil || va.After(vb)
+}
+
+// PubsubNameRouter spreads IPNS records over libp2p pubsub, one topic per
+// name as Kubo names them. Pubsub keeps no history: a node learns a
+// record once it has joined the name's topic, which GetIPNS does on the
+// first lookup, so that lookup usually finds nothing and later ones see
+// every update as it is published.
+type PubsubNameRouter struct {
+	ps *pubsub.PubSub
+
+	mu     sync.Mutex
+	topics map[ipns.Name]*nameTopic
+}
+
+var _ NameRouter = (*PubsubNameRouter)(nil)
+
+type nameTopic struct {
+	topic  *pubsub.Topic
+	sub    *pubsub.Subscription
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	best *ipns.Record
+}
+
+// NewPubsubNameRouter starts GossipSub on h
+func NewPubsubNameRouter(ctx context.Context, h host.Host) (*PubsubNameRouter, error) {
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("start gossipsub: %w", err)
+	}
+	return &PubsubNameRouter{ps: ps, topics: make(map[ipns.Name]*nameTopic)}, nil
+}
+
+// NameTopic is the pubsub topic records for name are published on
+func NameTopic(name ipns.Name) string {
+	return "/record/" + base64.RawURLEncoding.EncodeToString(name.RoutingKey())
+}
+
+// PutIPNS publishes rec to the peers subscribed to name
+func (r *PubsubNameRouter) PutIPNS(ctx context.Context, name ipns.Name, rec *ipns.Record) error {
+	if err := ipns.ValidateWithName(rec, name); err != nil {
+		return fmt.Errorf("invalid ipns record for %s: %w", name, err)
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return err
+	}
+	t, err := r.join(name)
+	if err != nil {
+		return err
+	}
+	t.offer(name, rec)
+	if err := t.topic.Publish(ctx, data); err != nil {
+		return fmt.Errorf("publish ipns %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetIPNS returns the newest record seen on name's topic, joining the
+// topic first if needed
+func (r *PubsubNameRouter) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	t, err := r.join(name)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.best == nil {
+		return nil, fmt.Errorf("ipns %s: no record seen on pubsub yet: %w", name, routing.ErrNotFound)
+	}
+	return t.best, nil
+}
+
+// Close leaves every joined topic
+func (r *PubsubNameRouter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for name, t := range r.topics {
+		t.cancel()
+		t.sub.Cancel()
+		errs = append(errs, t.topic.Close())
+		delete(r.topics, name)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *PubsubNameRouter) join(name ipns.Name) (*nameTopic, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.topics[name]; ok {
+		return t, nil
+	}
+	topic, err := r.ps.Join(NameTopic(name))
+	if err != nil {
+		return nil, fmt.Errorf("join topic for %s: %w", name, err)
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		return nil, fmt.Errorf("subscribe to %s: %w", name, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &nameTopic{topic: topic, sub: sub, cancel: cancel}
+	r.topics[name] = t
+	go t.receive(ctx, name)
+	return t, nil
+}
+
+// receive keeps the newest valid record published on the topic
+func (t *nameTopic) receive(ctx context.Context, name ipns.Name) {
+	for {
+		msg, err := t.sub.Next(ctx)
+		if err != nil {
+			return // cancelled by Close
+		}
+		rec, err := ipns.UnmarshalRecord(msg.Data)
+		if err != nil {
+			continue
+		}
+		t.offer(name, rec)
+	}
+}
+
+func (t *nameTopic) offer(name ipns.Name, rec *ipns.Record) {
+	if ipns.ValidateWithName(rec, name) != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.best == nil || newerRecord(rec, t.best) {
+		t.best = rec
+	}
+}
@@ -3,22 +3,43 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	boxoipns "github.com/ipfs/boxo/ipns"
 	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
+	dht "github.com/gosuda/boxo-starter-kit/03-dht-router/pkg"
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 	unixfs "github.com/gosuda/boxo-starter-kit/06-unixfs-car/pkg"
+	pin "github.com/gosuda/boxo-starter-kit/08-pin-gc/pkg"
+	ipns "github.com/gosuda/boxo-starter-kit/09-ipns/pkg"
 	gateway "github.com/gosuda/boxo-starter-kit/10-gateway/pkg"
+	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
+	multifetcher "github.com/gosuda/boxo-starter-kit/18-multifetcher/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
+	"github.com/gosuda/boxo-starter-kit/pkg/security"
 )
 
 func TestGateway(t *testing.T) {
@@ -347,3 +368,1103 @@ func TestHTMLTemplateLogic(t *testing.T) {
 		}
 	})
 }
+
+// staticTXT serves fixed DNS TXT records
+type staticTXT map[string][]string
+
+func (s staticTXT) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txts, ok := s[name]; ok {
+		return txts, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestGatewayIPNS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(256*1024, dagWrapper)
+	require.NoError(t, err)
+
+	root, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"index.html": files.NewBytesFile([]byte("<h1>home</h1>")),
+		"docs": files.NewMapDirectory(map[string]files.Node{
+			"guide.txt": files.NewBytesFile([]byte("read me")),
+		}),
+	}))
+	require.NoError(t, err)
+
+	names := ipns.NewIPNSManager(dagWrapper)
+	id, err := names.GenerateKey(ctx, "site")
+	require.NoError(t, err)
+	_, err = names.PublishIPNS(ctx, "site", root, time.Hour)
+	require.NoError(t, err)
+	names.SetDNSLinkResolver(ipns.NewDNSLinkResolver(staticTXT{
+		"_dnslink.example.com": {"dnslink=/ipns/" + id.String()},
+		"_dnslink.docs.org":    {"dnslink=/ipfs/" + root.String() + "/docs"},
+	}, 0))
+
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{Names: names})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/ipns/" + id.String() + "/index.html", http.StatusOK, "<h1>home</h1>"},
+		{"/ipns/example.com/index.html", http.StatusOK, "<h1>home</h1>"},
+		{"/ipns/example.com/docs/guide.txt", http.StatusOK, "read me"},
+		{"/ipns/docs.org/guide.txt", http.StatusOK, "read me"},
+		{"/ipns/missing.org/index.html", http.StatusNotFound, ""},
+		{"/ipns/", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := get(tt.path)
+			require.Equal(t, tt.code, rr.Code, rr.Body.String())
+			if tt.body != "" {
+				assert.Equal(t, tt.body, rr.Body.String())
+				assert.Equal(t, "public, max-age=60", rr.Header().Get("Cache-Control"))
+			}
+		})
+	}
+
+	t.Run("Immutable IPFS Paths", func(t *testing.T) {
+		rr := get("/ipfs/" + root.String() + "/index.html")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Header().Get("Cache-Control"), "immutable")
+	})
+
+	t.Run("Without Resolver", func(t *testing.T) {
+		plain := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{})
+		rr := httptest.NewRecorder()
+		plain.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ipns/example.com", nil))
+		assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	})
+}
+
+// readCAR checks every block in a CARv1 response against its CID and
+// returns the roots and the block CIDs in stream order
+func readCAR(t *testing.T, body []byte) ([]cid.Cid, []cid.Cid) {
+	t.Helper()
+	br, err := carv2.NewBlockReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), br.Version)
+	var got []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		sum, err := blk.Cid().Prefix().Sum(blk.RawData())
+		require.NoError(t, err)
+		require.True(t, sum.Equals(blk.Cid()), "block %s does not match its data", blk.Cid())
+		got = append(got, blk.Cid())
+	}
+	return br.Roots, got
+}
+
+func TestGatewayTrustless(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(32*1024, dagWrapper)
+	require.NoError(t, err)
+
+	big := make([]byte, 100*1024) // 4 distinct chunks
+	for i := range big {
+		big[i] = byte(i ^ i>>8 ^ i>>16)
+	}
+	root, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"index.html": files.NewBytesFile([]byte("<h1>home</h1>")),
+		"a.txt":      files.NewBytesFile([]byte("same")),
+		"b.txt":      files.NewBytesFile([]byte("same")),
+		"docs": files.NewMapDirectory(map[string]files.Node{
+			"big.bin": files.NewBytesFile(big),
+		}),
+	}))
+	require.NoError(t, err)
+
+	_, docs, _, err := dagWrapper.ResolvePath(ctx, root, "docs")
+	require.NoError(t, err)
+	_, bigFile, _, err := dagWrapper.ResolvePath(ctx, root, "docs/big.bin")
+	require.NoError(t, err)
+	bigNode, err := dagWrapper.Get(ctx, bigFile)
+	require.NoError(t, err)
+	require.Len(t, bigNode.Links(), 4)
+
+	names := ipns.NewIPNSManager(dagWrapper)
+	id, err := names.GenerateKey(ctx, "site")
+	require.NoError(t, err)
+	_, err = names.PublishIPNS(ctx, "site", root, time.Hour)
+	require.NoError(t, err)
+	names.SetDNSLinkResolver(ipns.NewDNSLinkResolver(staticTXT{
+		"_dnslink.example.com": {"dnslink=/ipfs/" + root.String()},
+	}, 0))
+
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{Names: names})
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	accept := func(v string) http.Header { return http.Header{"Accept": {v}} }
+
+	t.Run("Raw Block", func(t *testing.T) {
+		rr := get("/ipfs/"+root.String(), accept(gateway.MediaTypeRaw))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, gateway.MediaTypeRaw, rr.Header().Get("Content-Type"))
+		assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, `"`+root.String()+`.raw"`, rr.Header().Get("Etag"))
+		sum, err := root.Prefix().Sum(rr.Body.Bytes())
+		require.NoError(t, err)
+		assert.True(t, sum.Equals(root))
+
+		// With a path, the block the path ends on
+		rr = get("/ipfs/"+root.String()+"/docs/big.bin?format=raw", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		sum, err = bigFile.Prefix().Sum(rr.Body.Bytes())
+		require.NoError(t, err)
+		assert.True(t, sum.Equals(bigFile))
+
+		rr = get("/ipfs/"+root.String(), http.Header{
+			"Accept":        {gateway.MediaTypeRaw},
+			"If-None-Match": {`"` + root.String() + `.raw"`},
+		})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+	})
+
+	t.Run("CAR Scopes", func(t *testing.T) {
+		rr := get("/ipfs/"+root.String(), accept(gateway.MediaTypeCAR))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "application/vnd.ipld.car; version=1; order=dfs; dups=n", rr.Header().Get("Content-Type"))
+		roots, all := readCAR(t, rr.Body.Bytes())
+		assert.Equal(t, []cid.Cid{root}, roots)
+		assert.Equal(t, root, all[0], "depth-first order starts at the root")
+		// root, index.html, one shared a/b block, docs, big.bin and its 4 chunks
+		assert.Len(t, all, 9)
+
+		rr = get("/ipfs/"+root.String()+"/docs/big.bin?format=car&dag-scope=block", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		roots, blocks := readCAR(t, rr.Body.Bytes())
+		assert.Equal(t, []cid.Cid{root}, roots)
+		assert.Equal(t, []cid.Cid{root, docs, bigFile}, blocks, "path blocks let the client verify the path")
+
+		rr = get("/ipfs/"+root.String()+"/docs/big.bin?format=car&dag-scope=entity", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		_, blocks = readCAR(t, rr.Body.Bytes())
+		assert.Len(t, blocks, 3+4, "a file entity includes all its chunks")
+
+		rr = get("/ipfs/"+root.String()+"/docs?format=car&dag-scope=entity", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		_, blocks = readCAR(t, rr.Body.Bytes())
+		assert.Equal(t, []cid.Cid{root, docs}, blocks, "a directory entity is just the directory")
+	})
+
+	t.Run("CAR Duplicates", func(t *testing.T) {
+		rr := get("/ipfs/"+root.String(), accept("application/vnd.ipld.car; version=1; dups=y"))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "application/vnd.ipld.car; version=1; order=dfs; dups=y", rr.Header().Get("Content-Type"))
+		_, blocks := readCAR(t, rr.Body.Bytes())
+		assert.Len(t, blocks, 10, "the block shared by a.txt and b.txt is sent twice")
+	})
+
+	t.Run("Negotiation", func(t *testing.T) {
+		rr := get("/ipfs/"+root.String(), accept("application/vnd.ipld.car;q=0.5, application/vnd.ipld.raw"))
+		assert.Equal(t, gateway.MediaTypeRaw, rr.Header().Get("Content-Type"))
+
+		rr = get("/ipfs/"+root.String()+"/index.html", accept("text/html,*/*"))
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "<h1>home</h1>", rr.Body.String(), "deserialized by default")
+
+		// ?format= wins over Accept
+		rr = get("/ipfs/"+root.String()+"?format=car", accept(gateway.MediaTypeRaw))
+		assert.Contains(t, rr.Header().Get("Content-Type"), gateway.MediaTypeCAR)
+
+		for _, tt := range []struct {
+			path   string
+			header http.Header
+		}{
+			{"/ipfs/" + root.String() + "?format=tar", nil},
+			{"/ipfs/" + root.String(), accept("application/vnd.ipld.car; version=2")},
+			{"/ipfs/" + root.String(), accept("application/vnd.ipld.car; dups=maybe")},
+			{"/ipfs/" + root.String() + "?format=car&dag-scope=most", nil},
+			{"/ipfs/" + root.String() + "?format=ipns-record", nil},
+		} {
+			rr := get(tt.path, tt.header)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, tt.path)
+		}
+	})
+
+	t.Run("IPNS", func(t *testing.T) {
+		rr := get("/ipns/"+id.String(), accept(gateway.MediaTypeIPNSRecord))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, gateway.MediaTypeIPNSRecord, rr.Header().Get("Content-Type"))
+		rec, err := boxoipns.UnmarshalRecord(rr.Body.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, boxoipns.ValidateWithName(rec, boxoipns.NameFromPeer(id)))
+		value, err := rec.Value()
+		require.NoError(t, err)
+		assert.Equal(t, "/ipfs/"+root.String(), value.String())
+
+		rr = get("/ipns/"+id.String()+"?format=car&dag-scope=block", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		roots, _ := readCAR(t, rr.Body.Bytes())
+		assert.Equal(t, []cid.Cid{root}, roots)
+
+		rr = get("/ipns/example.com?format=ipns-record", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	// The response headers gateway-conformance checks for each format
+	t.Run("Conformance", func(t *testing.T) {
+		for _, tt := range []struct {
+			name        string
+			path        string
+			header      http.Header
+			contentType string
+			disposition string
+			etag        string
+		}{
+			{
+				name:        "raw",
+				path:        "/ipfs/" + root.String() + "/docs",
+				header:      accept(gateway.MediaTypeRaw),
+				contentType: gateway.MediaTypeRaw,
+				disposition: `attachment; filename="` + docs.String() + `.bin"`,
+				etag:        `"` + docs.String() + `.raw"`,
+			},
+			{
+				name:        "car",
+				path:        "/ipfs/" + root.String() + "?format=car",
+				contentType: "application/vnd.ipld.car; version=1; order=dfs; dups=n",
+				disposition: `attachment; filename="` + root.String() + `.car"`,
+				etag:        `"` + root.String() + `.car.all.n"`,
+			},
+			{
+				name:        "ipns-record",
+				path:        "/ipns/" + id.String() + "?format=ipns-record",
+				contentType: gateway.MediaTypeIPNSRecord,
+				disposition: `attachment; filename="` + id.String() + `.ipns-record"`,
+			},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := get(tt.path, tt.header)
+				require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+				h := rr.Header()
+				assert.Equal(t, tt.contentType, h.Get("Content-Type"))
+				assert.Equal(t, tt.disposition, h.Get("Content-Disposition"))
+				assert.Equal(t, "nosniff", h.Get("X-Content-Type-Options"))
+				assert.Contains(t, h.Values("Vary"), "Accept")
+				assert.NotEmpty(t, h.Get("Cache-Control"))
+				if tt.etag != "" {
+					assert.Equal(t, tt.etag, h.Get("Etag"))
+					again := get(tt.path, http.Header{"If-None-Match": {tt.etag}, "Accept": tt.header.Values("Accept")})
+					assert.Equal(t, http.StatusNotModified, again.Code)
+					assert.Empty(t, again.Body.Bytes())
+				}
+				if cl := h.Get("Content-Length"); cl != "" {
+					assert.Equal(t, strconv.Itoa(rr.Body.Len()), cl)
+				}
+			})
+		}
+
+		rr := get("/ipfs/"+root.String()+"/missing?format=raw", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		rr = get("/ipfs/"+root.String()+"/missing?format=car", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("CAR Aborted", func(t *testing.T) {
+		// The child is never stored, so the CAR cannot be completed
+		parent := merkledag.NodeWithData([]byte("parent of a missing block"))
+		require.NoError(t, parent.AddNodeLink("missing", merkledag.NodeWithData([]byte("never stored"))))
+		broken, err := dagWrapper.PutNode(ctx, parent)
+		require.NoError(t, err)
+
+		reqCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/ipfs/"+broken.String()+"?format=car", nil).WithContext(reqCtx)
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			gw.Handler().ServeHTTP(httptest.NewRecorder(), req)
+		})
+	})
+}
+
+func TestGatewayRange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(32*1024, dagWrapper)
+	require.NoError(t, err)
+
+	video := make([]byte, 200*1024) // several chunks
+	for i := range video {
+		video[i] = byte(i ^ i>>8 ^ i>>16)
+	}
+	root, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"clip.mp4": files.NewBytesFile(video),
+	}))
+	require.NoError(t, err)
+	_, clip, _, err := dagWrapper.ResolvePath(ctx, root, "clip.mp4")
+	require.NoError(t, err)
+
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{})
+	path := "/ipfs/" + root.String() + "/clip.mp4"
+	do := func(method string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	etag := `"` + clip.String() + `"`
+
+	t.Run("Whole File", func(t *testing.T) {
+		rr := do(http.MethodGet, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, video, rr.Body.Bytes())
+		assert.Equal(t, "video/mp4", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "bytes", rr.Header().Get("Accept-Ranges"))
+		assert.Equal(t, etag, rr.Header().Get("Etag"))
+	})
+
+	tests := []struct {
+		name       string
+		rangeHdr   string
+		start, end int // inclusive
+	}{
+		{"Inside One Chunk", "bytes=100-199", 100, 199},
+		{"Across Chunks", "bytes=32000-70000", 32000, 70000},
+		{"Open Ended", "bytes=150000-", 150000, len(video) - 1},
+		{"Suffix", "bytes=-1000", len(video) - 1000, len(video) - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := do(http.MethodGet, http.Header{"Range": {tt.rangeHdr}})
+			require.Equal(t, http.StatusPartialContent, rr.Code)
+			assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", tt.start, tt.end, len(video)), rr.Header().Get("Content-Range"))
+			assert.Equal(t, video[tt.start:tt.end+1], rr.Body.Bytes())
+		})
+	}
+
+	t.Run("Multiple Ranges", func(t *testing.T) {
+		rr := do(http.MethodGet, http.Header{"Range": {"bytes=0-9,100000-100009"}})
+		require.Equal(t, http.StatusPartialContent, rr.Code)
+		_, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		mr := multipart.NewReader(rr.Body, params["boundary"])
+		for _, start := range []int{0, 100000} {
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			data, err := io.ReadAll(part)
+			require.NoError(t, err)
+			assert.Equal(t, video[start:start+10], data)
+		}
+	})
+
+	t.Run("Conditional", func(t *testing.T) {
+		// Resuming against the same file gets the range...
+		rr := do(http.MethodGet, http.Header{"Range": {"bytes=1000-"}, "If-Range": {etag}})
+		assert.Equal(t, http.StatusPartialContent, rr.Code)
+		// ...but if the file changed, the whole new one
+		rr = do(http.MethodGet, http.Header{"Range": {"bytes=1000-"}, "If-Range": {`"other"`}})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Len(t, rr.Body.Bytes(), len(video))
+
+		rr = do(http.MethodGet, http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+	})
+
+	t.Run("Unsatisfiable", func(t *testing.T) {
+		rr := do(http.MethodGet, http.Header{"Range": {fmt.Sprintf("bytes=%d-", len(video)+10)}})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rr.Code)
+		assert.Equal(t, fmt.Sprintf("bytes */%d", len(video)), rr.Header().Get("Content-Range"))
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		rr := do(http.MethodHead, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, strconv.Itoa(len(video)), rr.Header().Get("Content-Length"))
+		assert.Empty(t, rr.Body.Bytes())
+	})
+}
+
+func TestGatewayHostnames(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(256*1024, dagWrapper)
+	require.NoError(t, err)
+
+	root, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"index.html": files.NewBytesFile([]byte("<h1>home</h1>")),
+		"docs": files.NewMapDirectory(map[string]files.Node{
+			"guide.txt": files.NewBytesFile([]byte("read me")),
+		}),
+	}))
+	require.NoError(t, err)
+	rootLabel := cid.NewCidV1(root.Type(), root.Hash()).String()
+
+	names := ipns.NewIPNSManager(dagWrapper)
+	id, err := names.GenerateKey(ctx, "site")
+	require.NoError(t, err)
+	_, err = names.PublishIPNS(ctx, "site", root, time.Hour)
+	require.NoError(t, err)
+	keyLabel, err := peer.ToCid(id).StringOfBase(multibase.Base36)
+	require.NoError(t, err)
+	names.SetDNSLinkResolver(ipns.NewDNSLinkResolver(staticTXT{
+		"_dnslink.my-site.example.org": {"dnslink=/ipns/" + id.String()},
+		"_dnslink.docs.org":            {"dnslink=/ipfs/" + root.String() + "/docs"},
+	}, 0))
+
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{
+		Names: names,
+		Hostnames: &gateway.HostnameConfig{PublicGateways: map[string]*gateway.PublicGateway{
+			"localhost":  {Paths: []string{"/ipfs", "/ipns"}, UseSubdomains: true},
+			"paths.test": {Paths: []string{"/ipfs"}},
+		}},
+	})
+	do := func(handler http.Handler, host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(host, path string) *httptest.ResponseRecorder {
+		return do(gw.Handler(), host, path)
+	}
+
+	t.Run("Redirect To Subdomain", func(t *testing.T) {
+		tests := []struct {
+			path     string
+			location string
+		}{
+			{"/ipfs/" + root.String() + "/docs/guide.txt?download=1",
+				"http://" + rootLabel + ".ipfs.localhost:8080/docs/guide.txt?download=1"},
+			{"/ipns/" + id.String() + "/index.html",
+				"http://" + keyLabel + ".ipns.localhost:8080/index.html"},
+			{"/ipns/my-site.example.org",
+				"http://my--site-example-org.ipns.localhost:8080/"},
+		}
+		for _, tt := range tests {
+			rr := get("localhost:8080", tt.path)
+			require.Equal(t, http.StatusMovedPermanently, rr.Code, tt.path)
+			assert.Equal(t, tt.location, rr.Header().Get("Location"))
+		}
+	})
+
+	t.Run("Subdomains", func(t *testing.T) {
+		tests := []struct {
+			host, path string
+			body       string
+		}{
+			{rootLabel + ".ipfs.localhost:8080", "/docs/guide.txt", "read me"},
+			{keyLabel + ".ipns.localhost:8080", "/index.html", "<h1>home</h1>"},
+			{"my--site-example-org.ipns.localhost:8080", "/index.html", "<h1>home</h1>"},
+			{"my-site.example.org.ipns.localhost:8080", "/index.html", "<h1>home</h1>"},
+		}
+		for _, tt := range tests {
+			rr := get(tt.host, tt.path)
+			require.Equal(t, http.StatusOK, rr.Code, tt.host)
+			assert.Equal(t, tt.body, rr.Body.String())
+		}
+
+		// Each site is its own origin, so listings link within it
+		rr := get(rootLabel+".ipfs.localhost:8080", "/docs")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `href="/docs/guide.txt"`)
+		assert.NotContains(t, rr.Body.String(), "/ipfs/")
+	})
+
+	t.Run("Canonical Labels", func(t *testing.T) {
+		// Peer IDs are case-sensitive; hostnames use base36 libp2p-key CIDs
+		base32Key := peer.ToCid(id).String()
+		rr := get(base32Key+".ipns.localhost", "/index.html")
+		require.Equal(t, http.StatusMovedPermanently, rr.Code)
+		assert.Equal(t, "http://"+keyLabel+".ipns.localhost/index.html", rr.Header().Get("Location"))
+
+		rr = get("not-a-cid.ipfs.localhost", "/")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Gateway Paths", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, get("paths.test", "/ipfs/"+root.String()+"/index.html").Code)
+		assert.Equal(t, http.StatusNotFound, get("paths.test", "/ipns/"+id.String()).Code)
+		// The homepage and API stay reachable on gateway hostnames
+		assert.Equal(t, http.StatusOK, get("localhost:8080", "/").Code)
+	})
+
+	t.Run("DNSLink Hostnames", func(t *testing.T) {
+		rr := get("docs.org", "/guide.txt")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "read me", rr.Body.String())
+		assert.Equal(t, "public, max-age=60", rr.Header().Get("Cache-Control"))
+
+		// Hostnames without DNSLink are path gateways
+		assert.Equal(t, http.StatusOK, get("127.0.0.1:8080", "/ipfs/"+root.String()+"/index.html").Code)
+		assert.Equal(t, http.StatusOK, get("nolink.org", "/ipfs/"+root.String()+"/index.html").Code)
+	})
+
+	t.Run("Per Listener", func(t *testing.T) {
+		noDNSLink := gw.HostnameHandler(gateway.HostnameConfig{NoDNSLink: true})
+		assert.Equal(t, http.StatusNotFound, do(noDNSLink, "docs.org", "/guide.txt").Code)
+		assert.Equal(t, http.StatusOK, do(noDNSLink, "docs.org", "/ipfs/"+root.String()+"/index.html").Code)
+		// No subdomain gateways configured: localhost serves paths
+		assert.Equal(t, http.StatusOK, do(noDNSLink, "localhost:8080", "/ipfs/"+root.String()+"/index.html").Code)
+	})
+}
+
+func TestGatewayCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(256*1024, dagWrapper)
+	require.NoError(t, err)
+
+	root, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("first file")),
+		"b.txt": files.NewBytesFile([]byte("second file")),
+	}))
+	require.NoError(t, err)
+	_, fileA, _, err := dagWrapper.ResolvePath(ctx, root, "a.txt")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cache, err := gateway.NewResponseCache(gateway.CacheConfig{Dir: dir, MemoryBytes: 12})
+	require.NoError(t, err)
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{Cache: cache})
+
+	do := func(gw *gateway.Gateway, method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	pathA := "/ipfs/" + root.String() + "/a.txt"
+	pathB := "/ipfs/" + root.String() + "/b.txt"
+
+	t.Run("Hits And Misses", func(t *testing.T) {
+		for range 2 {
+			rr := do(gw, http.MethodGet, pathA, nil)
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "first file", rr.Body.String())
+			assert.Equal(t, "public, max-age=31536000, immutable", rr.Header().Get("Cache-Control"))
+			assert.Equal(t, `"`+fileA.String()+`"`, rr.Header().Get("Etag"))
+		}
+		stats := cache.Stats()
+		assert.Equal(t, int64(1), stats.Misses)
+		assert.Equal(t, int64(1), stats.Hits)
+
+		// Ranges are cut from the cached body
+		rr := do(gw, http.MethodGet, pathA, http.Header{"Range": {"bytes=6-9"}})
+		require.Equal(t, http.StatusPartialContent, rr.Code)
+		assert.Equal(t, "file", rr.Body.String())
+		assert.Equal(t, int64(2), cache.Stats().Hits)
+	})
+
+	t.Run("Spill To Disk", func(t *testing.T) {
+		// Memory holds one file, so b evicts a, which the disk still has
+		require.Equal(t, http.StatusOK, do(gw, http.MethodGet, pathB, nil).Code)
+		stats := cache.Stats()
+		assert.Equal(t, 1, stats.Entries)
+		assert.Equal(t, 2, stats.DiskEntries)
+		assert.Equal(t, int64(1), stats.Evictions)
+
+		rr := do(gw, http.MethodGet, pathA, nil)
+		assert.Equal(t, "first file", rr.Body.String())
+		assert.Equal(t, int64(1), cache.Stats().DiskHits)
+	})
+
+	t.Run("Survives Restart", func(t *testing.T) {
+		reopened, err := gateway.NewResponseCache(gateway.CacheConfig{Dir: dir})
+		require.NoError(t, err)
+		assert.Equal(t, 2, reopened.Stats().DiskEntries)
+		body, ok := reopened.Get(fileA)
+		require.True(t, ok)
+		assert.Equal(t, "first file", string(body))
+	})
+
+	t.Run("API", func(t *testing.T) {
+		rr := do(gw, http.MethodGet, "/api/v0/cache/stats", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var stats gateway.CacheStats
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+		assert.Equal(t, cache.Stats(), stats)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, do(gw, http.MethodGet, "/api/v0/cache/purge", nil).Code)
+
+		var purged struct{ Purged int }
+		rr = do(gw, http.MethodPost, "/api/v0/cache/purge?cid="+fileA.String(), nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &purged))
+		assert.Equal(t, 1, purged.Purged)
+		_, ok := cache.Get(fileA)
+		assert.False(t, ok)
+		assert.NoFileExists(t, filepath.Join(dir, fileA.String()))
+
+		rr = do(gw, http.MethodPost, "/api/v0/cache/purge", nil)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &purged))
+		assert.Equal(t, 1, purged.Purged)
+		assert.Zero(t, cache.Stats().DiskEntries)
+
+		plain := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{})
+		assert.Equal(t, http.StatusNotImplemented, do(plain, http.MethodGet, "/api/v0/cache/stats", nil).Code)
+	})
+
+	t.Run("IPNS Revalidation", func(t *testing.T) {
+		names := ipns.NewIPNSManager(dagWrapper)
+		id, err := names.GenerateKey(ctx, "site")
+		require.NoError(t, err)
+		_, err = names.PublishIPNS(ctx, "site", root, time.Hour)
+		require.NoError(t, err)
+		gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{Names: names, Cache: cache})
+
+		for _, p := range []string{"/a.txt", ""} {
+			path := "/ipns/" + id.String() + p
+			rr := do(gw, http.MethodGet, path, nil)
+			require.Equal(t, http.StatusOK, rr.Code, path)
+			assert.Equal(t, "public, max-age=60", rr.Header().Get("Cache-Control"))
+			etag := rr.Header().Get("Etag")
+			require.NotEmpty(t, etag)
+
+			// Unchanged name: 304 without a body
+			rr = do(gw, http.MethodGet, path, http.Header{"If-None-Match": {etag}})
+			assert.Equal(t, http.StatusNotModified, rr.Code, path)
+			assert.Empty(t, rr.Body.Bytes())
+		}
+
+		// Pointing the name elsewhere changes the Etag
+		other, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+			"a.txt": files.NewBytesFile([]byte("new content")),
+		}))
+		require.NoError(t, err)
+		_, err = names.PublishIPNS(ctx, "site", other, time.Hour)
+		require.NoError(t, err)
+		rr := do(gw, http.MethodGet, "/ipns/"+id.String()+"/a.txt", http.Header{"If-None-Match": {`"` + fileA.String() + `"`}})
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "new content", rr.Body.String())
+	})
+}
+
+func TestGatewayMultiFetcher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Node A holds the content
+	hA, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+	require.NoError(t, err)
+	defer hA.Close()
+	bsA, err := bitswap.NewBitswap(ctx, nil, hA, nil)
+	require.NoError(t, err)
+	defer bsA.Close()
+	serviceA, err := bitswap.NewBlockService(ctx, nil, bsA)
+	require.NoError(t, err)
+	dagA, err := dag.NewIpldWrapper(ctx, serviceA)
+	require.NoError(t, err)
+	unixfsA, err := unixfs.New(32*1024, dagA)
+	require.NoError(t, err)
+
+	page := make([]byte, 100*1024) // several blocks
+	for i := range page {
+		page[i] = byte(i ^ i>>8 ^ i>>16)
+	}
+	root, err := unixfsA.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"page.bin": files.NewBytesFile(page),
+	}))
+	require.NoError(t, err)
+
+	// A delegated routing endpoint that points at A for every CID
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var addrs []string
+		for _, a := range hA.Addrs() {
+			addrs = append(addrs, a.String())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"Providers": []map[string]any{{"Schema": "peer", "ID": hA.ID().String(), "Addrs": addrs}},
+		})
+	}))
+	defer srv.Close()
+	router, err := dht.NewDelegatedRouter(srv.URL)
+	require.NoError(t, err)
+
+	// The gateway node B starts empty and fetches through the multifetcher
+	hB, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+	require.NoError(t, err)
+	defer hB.Close()
+	bsB, err := bitswap.NewBitswapWithConfig(ctx, router, hB, nil, &bitswap.BitswapConfig{
+		Retry: &bitswap.RetryConfig{Timeout: 2 * time.Second, Attempts: 2},
+	})
+	require.NoError(t, err)
+	defer bsB.Close()
+	ipniWrapper, err := ipni.New("", "topic", nil, nil, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+
+	cfg := multifetcher.DefaultConfig()
+	cfg.Router = router
+	mf := multifetcher.NewMultiFetcher(ipniWrapper, nil, bsB, &cfg)
+	defer mf.Close()
+
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	dagB, err := gateway.NewMultiFetcherBackend(ctx, mf, store)
+	require.NoError(t, err)
+	defer dagB.BlockServiceWrapper.Close()
+	unixfsB, err := unixfs.New(32*1024, dagB)
+	require.NoError(t, err)
+
+	gw := gateway.NewGateway(dagB, unixfsB, gateway.GatewayConfig{FetchTimeout: 10 * time.Second})
+	get := func(gw *gateway.Gateway, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	t.Run("Fetch Missing Blocks", func(t *testing.T) {
+		has, err := store.Has(ctx, root)
+		require.NoError(t, err)
+		require.False(t, has)
+
+		rr := get(gw, "/ipfs/"+root.String()+"/page.bin")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, page, rr.Body.Bytes())
+
+		// Everything read was fetched over bitswap and kept
+		has, err = store.Has(ctx, root)
+		require.NoError(t, err)
+		assert.True(t, has)
+		stats := mf.GetMetrics().ProtocolStats["bitswap"]
+		assert.Positive(t, stats.Successes)
+	})
+
+	t.Run("Budget Exhausted", func(t *testing.T) {
+		// A has no such block, so bitswap keeps asking until the budget ends
+		missing, err := cid.Parse("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+		require.NoError(t, err)
+		short := gateway.NewGateway(dagB, unixfsB, gateway.GatewayConfig{FetchTimeout: 300 * time.Millisecond})
+
+		start := time.Now()
+		rr := get(short, "/ipfs/"+missing.String())
+		assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Local Only", func(t *testing.T) {
+		// Without a budget the gateway does not try to fetch at all
+		local := gateway.NewGateway(dagB, unixfsB, gateway.GatewayConfig{})
+		rr := get(local, "/ipfs/bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestGatewayRedirects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(256*1024, dagWrapper)
+	require.NoError(t, err)
+
+	redirects := `# moved pages
+/old/:name    /new/:name       301
+/blog/*       /posts/:splat    302
+/docs         https://docs.example.net/
+/gone         /missing.html    410
+/app/*        /index.html      200
+/*            /404.html        404
+`
+	site := files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(redirects)),
+		"index.html": files.NewBytesFile([]byte("<div id=app></div>")),
+		"404.html":   files.NewBytesFile([]byte("custom not found")),
+		"new": files.NewMapDirectory(map[string]files.Node{
+			"page.txt": files.NewBytesFile([]byte("new page")),
+		}),
+	})
+	root, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+		"site": site,
+		"broken": files.NewMapDirectory(map[string]files.Node{
+			"_redirects": files.NewBytesFile([]byte("/a /b 999\n")),
+		}),
+	}))
+	require.NoError(t, err)
+	_, siteCID, _, err := dagWrapper.ResolvePath(ctx, root, "site")
+	require.NoError(t, err)
+	_, brokenCID, _, err := dagWrapper.ResolvePath(ctx, root, "broken")
+	require.NoError(t, err)
+
+	names := ipns.NewIPNSManager(dagWrapper)
+	names.SetDNSLinkResolver(ipns.NewDNSLinkResolver(staticTXT{
+		"_dnslink.app.org": {"dnslink=/ipfs/" + root.String() + "/site"},
+	}, 0))
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{
+		Names:     names,
+		Hostnames: &gateway.HostnameConfig{PublicGateways: gateway.DefaultPublicGateways()},
+	})
+	get := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	siteHost := cid.NewCidV1(siteCID.Type(), siteCID.Hash()).String() + ".ipfs.localhost"
+	tests := []struct {
+		path     string
+		code     int
+		location string
+		body     string
+	}{
+		{"/new/page.txt", http.StatusOK, "", "new page"}, // existing files win
+		{"/old/page.txt", http.StatusMovedPermanently, "/new/page.txt", ""},
+		{"/old/page.txt?ref=1", http.StatusMovedPermanently, "/new/page.txt?ref=1", ""},
+		{"/blog/2024/post", http.StatusFound, "/posts/2024/post", ""},
+		{"/docs", http.StatusMovedPermanently, "https://docs.example.net/", ""},
+		{"/app/settings/profile", http.StatusOK, "", "<div id=app></div>"},
+		{"/gone", http.StatusGone, "", ""},
+		{"/nothing/here", http.StatusNotFound, "", "custom not found"},
+	}
+	for _, host := range []string{siteHost, "app.org"} {
+		for _, tt := range tests {
+			t.Run(host+tt.path, func(t *testing.T) {
+				rr := get(host, tt.path)
+				require.Equal(t, tt.code, rr.Code, rr.Body.String())
+				assert.Equal(t, tt.location, rr.Header().Get("Location"))
+				if tt.body != "" {
+					assert.Equal(t, tt.body, rr.Body.String())
+				}
+			})
+		}
+	}
+
+	t.Run("Not On Path Gateways", func(t *testing.T) {
+		// One origin serves every site there, so no site's rules apply
+		rr := get("127.0.0.1:8080", "/ipfs/"+siteCID.String()+"/app/settings")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "custom not found")
+	})
+
+	t.Run("Invalid File", func(t *testing.T) {
+		host := cid.NewCidV1(brokenCID.Type(), brokenCID.Hash()).String() + ".ipfs.localhost"
+		rr := get(host, "/a")
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Contains(t, rr.Body.String(), "unsupported status")
+	})
+}
+
+func TestGatewaySecurityLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(256*1024, dagWrapper)
+	require.NoError(t, err)
+
+	hot, err := unixfsSystem.Put(ctx, files.NewBytesFile(bytes.Repeat([]byte("h"), 1000)))
+	require.NoError(t, err)
+	cold, err := unixfsSystem.Put(ctx, files.NewBytesFile([]byte("cold")))
+	require.NoError(t, err)
+
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{
+		Security: &security.SecurityConfig{
+			EnableQuota:       true,
+			Quota:             security.QuotaConfig{BytesPerWindow: 1500, Window: time.Hour},
+			EnableConcurrency: true,
+			Concurrency:       security.ConcurrencyConfig{MaxPerKey: 4},
+		},
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:4000"
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Per-CID Quota", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rr := get("/ipfs/" + hot.String())
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Len(t, rr.Body.Bytes(), 1000)
+		}
+
+		rr := get("/ipfs/" + hot.String())
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		retry, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		assert.InDelta(t, 3600, retry, 5)
+
+		// Other CIDs have their own quota
+		rr = get("/ipfs/" + cold.String())
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "cold", rr.Body.String())
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		metrics.NewHTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/limits", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var body struct {
+			Limits map[string]security.LimitStats `json:"limits"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		stats := body.Limits[gw.LimitName()]
+		require.NotNil(t, stats.Quota)
+		require.NotNil(t, stats.Concurrency)
+
+		// Quotas are kept per CIDv1, whichever version was asked for
+		v1 := func(c cid.Cid) string { return cid.NewCidV1(c.Type(), c.Hash()).String() }
+		usage := stats.Quota.Keys[v1(hot)]
+		assert.Equal(t, int64(2000), usage.Used)
+		assert.Equal(t, int64(1500), usage.Limit)
+		assert.Equal(t, int64(4), stats.Quota.Keys[v1(cold)].Used)
+		assert.Equal(t, int64(1), stats.Quota.Rejected)
+		assert.Equal(t, 4, stats.Concurrency.MaxPerKey)
+		assert.Empty(t, stats.Concurrency.InFlight)
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		// A second gateway publishes its own entry and stopping it leaves ours
+		other := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{
+			Security: &security.SecurityConfig{EnableConcurrency: true},
+		})
+		require.NotEqual(t, gw.LimitName(), other.LimitName())
+		require.Contains(t, metrics.GetGlobalLimits(), other.LimitName())
+		require.NoError(t, other.Stop())
+		assert.NotContains(t, metrics.GetGlobalLimits(), other.LimitName())
+
+		require.Contains(t, metrics.GetGlobalLimits(), gw.LimitName())
+		require.NoError(t, gw.Stop())
+		assert.NotContains(t, metrics.GetGlobalLimits(), gw.LimitName())
+	})
+}
+
+func TestGatewayCollections(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dagWrapper, err := dag.NewIpldWrapper(ctx, nil)
+	require.NoError(t, err)
+	defer dagWrapper.BlockServiceWrapper.Close()
+	unixfsSystem, err := unixfs.New(256*1024, dagWrapper)
+	require.NoError(t, err)
+	pins, err := pin.NewPinManager(dagWrapper)
+	require.NoError(t, err)
+	defer pins.Close()
+
+	var roots []cid.Cid
+	for _, content := range []string{"<h1>v1</h1>", "<h1>v2</h1>"} {
+		c, err := unixfsSystem.Put(ctx, files.NewMapDirectory(map[string]files.Node{
+			"index.html": files.NewBytesFile([]byte(content)),
+		}))
+		require.NoError(t, err)
+		roots = append(roots, c)
+	}
+
+	gw := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{Pins: pins})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		gw.Handler().ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) pin.Collection {
+		var col pin.Collection
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &col))
+		return col
+	}
+
+	t.Run("Create And Get", func(t *testing.T) {
+		rr := do(http.MethodPost, "/api/v0/collections/website?cid="+roots[0].String()+"&cid="+roots[1].String())
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, roots, decode(rr).Roots)
+
+		rr = do(http.MethodGet, "/api/v0/collections/website")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "website", decode(rr).Name)
+
+		rr = do(http.MethodGet, "/api/v0/collections")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var list struct{ Collections []pin.Collection }
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		require.Len(t, list.Collections, 1)
+
+		pinned, err := pins.IsPinned(ctx, roots[1])
+		require.NoError(t, err)
+		assert.True(t, pinned)
+	})
+
+	t.Run("Export CAR", func(t *testing.T) {
+		rr := do(http.MethodGet, "/api/v0/collections/website/car")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "application/vnd.ipld.car", rr.Header().Get("Content-Type"))
+		br, err := carv2.NewBlockReader(rr.Body)
+		require.NoError(t, err)
+		assert.Equal(t, roots, br.Roots)
+	})
+
+	t.Run("Remove Roots", func(t *testing.T) {
+		rr := do(http.MethodDelete, "/api/v0/collections/website?cid="+roots[0].String())
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, roots[1:], decode(rr).Roots)
+
+		rr = do(http.MethodDelete, "/api/v0/collections/website")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		for _, c := range roots {
+			pinned, err := pins.IsPinned(ctx, c)
+			require.NoError(t, err)
+			assert.False(t, pinned)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v0/collections/website").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v0/collections/website/car").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v0/collections/website?cid=nope").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v0/collections/bad%20name").Code)
+
+		plain := gateway.NewGateway(dagWrapper, unixfsSystem, gateway.GatewayConfig{})
+		rr := httptest.NewRecorder()
+		plain.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v0/collections", nil))
+		assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	})
+}
@@ -50,6 +50,8 @@ func main() {
 
 	config := gateway.GatewayConfig{
 		Port: 8080,
+		// Like Kubo, give every CID its own origin under *.ipfs.localhost
+		Hostnames: &gateway.HostnameConfig{PublicGateways: gateway.DefaultPublicGateways()},
 	}
 	gw := gateway.NewGateway(dagWrapper, unixfsSystem, config)
 
@@ -210,6 +212,8 @@ func showUsageExamples(sampleFiles map[string]string) {
 	fmt.Println("\n   💡 Try These:")
 	fmt.Println("      • Visit http://localhost:8080 for the gateway homepage")
 	fmt.Println("      • Browse directories by clicking links")
+	fmt.Println("      • Note how /ipfs/<cid> redirects to http://<cid>.ipfs.localhost:8080")
+	fmt.Println("      • Use http://127.0.0.1:8080/ipfs/<cid> to stay on the path gateway")
 	fmt.Println("      • View source of HTML/CSS files")
 	fmt.Println("      • Upload new files via API")
 }
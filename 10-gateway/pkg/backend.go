@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
+	multifetcher "github.com/gosuda/boxo-starter-kit/18-multifetcher/pkg"
+)
+
+// NewMultiFetcherBackend returns a DAG over store whose missing blocks are
+// fetched through mf: raced across the Bitswap, GraphSync and HTTP
+// providers IPNI lists, or the bitswap providers its router finds.
+// Fetched blocks are kept in store, so each is fetched only once. Pair it
+// with GatewayConfig.FetchTimeout so the gateway tries to fetch at all.
+func NewMultiFetcherBackend(ctx context.Context, mf *multifetcher.MultiFetcher, store *persistent.PersistentWrapper) (*dag.IpldWrapper, error) {
+	bs, err := bitswap.NewBlockServiceWithConfig(ctx, store, nil, &bitswap.BlockServiceConfig{
+		Exchange: multifetcher.NewExchange(mf),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create block service: %w", err)
+	}
+	return dag.NewIpldWrapper(ctx, bs)
+}
+
+// budgetWriter reports 504 Gateway Timeout instead of whatever error a
+// handler runs into once the request's fetch budget is spent, since the
+// content may well exist and just could not be fetched in time
+type budgetWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (bw budgetWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && errors.Is(bw.ctx.Err(), context.DeadlineExceeded) {
+		code = http.StatusGatewayTimeout
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,309 @@
+package gateway
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	// DefaultCacheMemory is the size of the in-memory tier
+	DefaultCacheMemory = 64 << 20
+	// DefaultCacheDisk is the size of the on-disk tier
+	DefaultCacheDisk = 1 << 30
+	// DefaultCacheMaxEntry is the largest response that is cached
+	DefaultCacheMaxEntry = 8 << 20
+)
+
+// CacheConfig sizes a ResponseCache. Zero values keep the defaults noted
+// on each field.
+type CacheConfig struct {
+	MemoryBytes int64  // in-memory tier (default 64MiB)
+	Dir         string // on-disk tier; "" keeps the cache in memory only
+	DiskBytes   int64  // on-disk tier (default 1GiB)
+	MaxEntry    int64  // larger files bypass the cache (default 8MiB)
+}
+
+// CacheStats counts the work of a ResponseCache
+type CacheStats struct {
+	Hits        int64 // served from memory
+	DiskHits    int64 // served from disk
+	Misses      int64 // rebuilt from the DAG
+	Evictions   int64 // entries dropped from either tier
+	Entries     int   // entries in memory
+	MemoryBytes int64 // bytes in memory
+	DiskEntries int   // entries on disk
+	DiskBytes   int64 // bytes on disk
+}
+
+// ResponseCache keeps assembled file bodies keyed by the CID they were
+// read from. Content under a CID never changes, so entries never go
+// stale; they only make way for newer ones, least recently used first.
+// Entries are written through to disk, when configured, so they survive
+// restarts and outlive their place in memory.
+type ResponseCache struct {
+	dir      string
+	maxEntry int64
+
+	mu     sync.Mutex
+	memory lruBytes
+	disk   lruBytes
+	bodies map[cid.Cid][]byte
+
+	hits, diskHits, misses, evictions atomic.Int64
+}
+
+// lruBytes orders entries by use and tracks their total size
+type lruBytes struct {
+	limit int64
+	size  int64
+	order *list.List // of lruEntry, most recent first
+	items map[cid.Cid]*list.Element
+}
+
+type lruEntry struct {
+	key  cid.Cid
+	size int64
+}
+
+func newLRUBytes(limit int64) lruBytes {
+	return lruBytes{limit: limit, order: list.New(), items: make(map[cid.Cid]*list.Element)}
+}
+
+// add inserts or refreshes key and returns the keys evicted to fit it
+func (l *lruBytes) add(key cid.Cid, size int64) []cid.Cid {
+	if e, ok := l.items[key]; ok {
+		l.order.MoveToFront(e)
+		return nil
+	}
+	l.items[key] = l.order.PushFront(lruEntry{key, size})
+	l.size += size
+	var evicted []cid.Cid
+	for l.size > l.limit && l.order.Len() > 1 {
+		oldest := l.order.Back()
+		evicted = append(evicted, oldest.Value.(lruEntry).key)
+		l.remove(oldest.Value.(lruEntry).key)
+	}
+	return evicted
+}
+
+func (l *lruBytes) touch(key cid.Cid) {
+	if e, ok := l.items[key]; ok {
+		l.order.MoveToFront(e)
+	}
+}
+
+func (l *lruBytes) remove(key cid.Cid) bool {
+	e, ok := l.items[key]
+	if !ok {
+		return false
+	}
+	l.size -= e.Value.(lruEntry).size
+	l.order.Remove(e)
+	delete(l.items, key)
+	return true
+}
+
+// NewResponseCache creates a cache. With a Dir, entries already on disk
+// from an earlier run are picked up again.
+func NewResponseCache(cfg CacheConfig) (*ResponseCache, error) {
+	if cfg.MemoryBytes <= 0 {
+		cfg.MemoryBytes = DefaultCacheMemory
+	}
+	if cfg.DiskBytes <= 0 {
+		cfg.DiskBytes = DefaultCacheDisk
+	}
+	if cfg.MaxEntry <= 0 {
+		cfg.MaxEntry = DefaultCacheMaxEntry
+	}
+
+	rc := &ResponseCache{
+		dir:      cfg.Dir,
+		maxEntry: cfg.MaxEntry,
+		memory:   newLRUBytes(cfg.MemoryBytes),
+		disk:     newLRUBytes(cfg.DiskBytes),
+		bodies:   make(map[cid.Cid][]byte),
+	}
+	if rc.dir == "" {
+		return rc, nil
+	}
+
+	if err := os.MkdirAll(rc.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	entries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	type found struct {
+		key  cid.Cid
+		info os.FileInfo
+	}
+	var existing []found
+	for _, e := range entries {
+		key, err := cid.Decode(e.Name())
+		if err != nil {
+			continue // a temporary file or something foreign
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, found{key, info})
+	}
+	// Oldest first, so the most recently written end up most recent
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].info.ModTime().Before(existing[j].info.ModTime())
+	})
+	for _, f := range existing {
+		rc.dropFiles(rc.disk.add(f.key, f.info.Size()))
+	}
+	return rc, nil
+}
+
+// MaxEntry is the largest body the cache accepts
+func (rc *ResponseCache) MaxEntry() int64 {
+	return rc.maxEntry
+}
+
+// Get returns the body cached for c, loading it back into memory if only
+// the disk still has it
+func (rc *ResponseCache) Get(c cid.Cid) ([]byte, bool) {
+	rc.mu.Lock()
+	if body, ok := rc.bodies[c]; ok {
+		rc.memory.touch(c)
+		rc.disk.touch(c)
+		rc.mu.Unlock()
+		rc.hits.Add(1)
+		return body, true
+	}
+	_, onDisk := rc.disk.items[c]
+	rc.mu.Unlock()
+
+	if onDisk {
+		if body, err := os.ReadFile(rc.path(c)); err == nil {
+			rc.diskHits.Add(1)
+			rc.putMemory(c, body)
+			return body, true
+		}
+		rc.mu.Lock()
+		rc.disk.remove(c)
+		rc.mu.Unlock()
+	}
+	rc.misses.Add(1)
+	return nil, false
+}
+
+// Put caches body as the content of c. Bodies over MaxEntry are ignored.
+func (rc *ResponseCache) Put(c cid.Cid, body []byte) {
+	if int64(len(body)) > rc.maxEntry {
+		return
+	}
+	rc.putMemory(c, body)
+	if rc.dir == "" {
+		return
+	}
+
+	// Write to a temporary name first so a crash never leaves a
+	// truncated entry behind
+	tmp, err := os.CreateTemp(rc.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), rc.path(c))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	rc.mu.Lock()
+	evicted := rc.disk.add(c, int64(len(body)))
+	rc.mu.Unlock()
+	rc.dropFiles(evicted)
+}
+
+// Purge drops c from both tiers and reports whether it was cached
+func (rc *ResponseCache) Purge(c cid.Cid) bool {
+	rc.mu.Lock()
+	inMemory := rc.memory.remove(c)
+	delete(rc.bodies, c)
+	onDisk := rc.disk.remove(c)
+	rc.mu.Unlock()
+	if onDisk {
+		os.Remove(rc.path(c))
+	}
+	return inMemory || onDisk
+}
+
+// PurgeAll empties the cache and returns how many entries it held
+func (rc *ResponseCache) PurgeAll() int {
+	rc.mu.Lock()
+	keys := make(map[cid.Cid]struct{}, len(rc.memory.items)+len(rc.disk.items))
+	for c := range rc.memory.items {
+		keys[c] = struct{}{}
+	}
+	var onDisk []cid.Cid
+	for c := range rc.disk.items {
+		keys[c] = struct{}{}
+		onDisk = append(onDisk, c)
+	}
+	rc.memory = newLRUBytes(rc.memory.limit)
+	rc.disk = newLRUBytes(rc.disk.limit)
+	rc.bodies = make(map[cid.Cid][]byte)
+	rc.mu.Unlock()
+
+	for _, c := range onDisk {
+		os.Remove(rc.path(c))
+	}
+	return len(keys)
+}
+
+// Stats returns the cache counters
+func (rc *ResponseCache) Stats() CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return CacheStats{
+		Hits:        rc.hits.Load(),
+		DiskHits:    rc.diskHits.Load(),
+		Misses:      rc.misses.Load(),
+		Evictions:   rc.evictions.Load(),
+		Entries:     len(rc.memory.items),
+		MemoryBytes: rc.memory.size,
+		DiskEntries: len(rc.disk.items),
+		DiskBytes:   rc.disk.size,
+	}
+}
+
+func (rc *ResponseCache) putMemory(c cid.Cid, body []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies[c] = body
+	for _, old := range rc.memory.add(c, int64(len(body))) {
+		delete(rc.bodies, old)
+		rc.evictions.Add(1)
+	}
+}
+
+// dropFiles deletes the files of entries evicted from the disk tier
+func (rc *ResponseCache) dropFiles(evicted []cid.Cid) {
+	for _, c := range evicted {
+		os.Remove(rc.path(c))
+		rc.evictions.Add(1)
+	}
+}
+
+func (rc *ResponseCache) path(c cid.Cid) string {
+	return filepath.Join(rc.dir, c.String())
+}
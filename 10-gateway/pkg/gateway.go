@@ -1,16 +1,20 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/boxo/files"
@@ -18,19 +22,54 @@ import (
 
 	dag "github.com/gosuda/boxo-starter-kit/05-dag-ipld/pkg"
 	unixfs "github.com/gosuda/boxo-starter-kit/06-unixfs-car/pkg"
+	pin "github.com/gosuda/boxo-starter-kit/08-pin-gc/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/metrics"
+	"github.com/gosuda/boxo-starter-kit/pkg/security"
 )
 
+// NameResolver resolves /ipns/ names, keys or DNSLink domains, to
+// /ipfs/ paths. The 09-ipns IPNSManager implements it.
+type NameResolver interface {
+	ResolveIPNS(ctx context.Context, name string) (string, error)
+}
+
 // Gateway represents an HTTP gateway for IPFS content
 type Gateway struct {
 	dagWrapper   *dag.IpldWrapper
 	unixfsSystem *unixfs.UnixFsWrapper
+	names        NameResolver
+	cache        *ResponseCache
+	pins         *pin.PinManager
+	fetchTimeout time.Duration
 	port         int
+	mux          *http.ServeMux
 	server       *http.Server
+	limitName    string // "gateway/<n>" in the global limits, "" without Security
 }
 
+// gatewaySeq numbers gateways so each publishes its limits under its own name
+var gatewaySeq atomic.Int64
+
 // GatewayConfig configures the gateway
 type GatewayConfig struct {
-	Port int // HTTP port to listen on (default: 8080)
+	Port      int             // HTTP port to listen on (default: 8080)
+	Names     NameResolver    // Serves /ipns/ paths when set
+	Hostnames *HostnameConfig // Subdomain and DNSLink hosting; nil serves paths only
+	Cache     *ResponseCache  // Keeps assembled files; nil reads every response from the DAG
+
+	// FetchTimeout is how long one request may spend fetching blocks that
+	// are not stored locally, e.g. through NewMultiFetcherBackend; running
+	// out gives 504. 0 serves local content only.
+	FetchTimeout time.Duration
+
+	// Security wraps every request, e.g. with per-CID bandwidth quotas
+	// and per-IP concurrency limits whose consumption is published as a
+	// limit of pkg/metrics named by LimitName. nil applies no limits.
+	Security *security.SecurityConfig
+
+	// Pins serves the pin collection admin API under
+	// /api/v0/collections; nil answers it with 501
+	Pins *pin.PinManager
 }
 
 // NewGateway creates a new HTTP gateway
@@ -42,6 +81,10 @@ func NewGateway(dagWrapper *dag.IpldWrapper, unixfsSystem *unixfs.UnixFsWrapper,
 	gateway := &Gateway{
 		dagWrapper:   dagWrapper,
 		unixfsSystem: unixfsSystem,
+		names:        config.Names,
+		cache:        config.Cache,
+		pins:         config.Pins,
+		fetchTimeout: config.FetchTimeout,
 		port:         config.Port,
 	}
 
@@ -49,11 +92,25 @@ func NewGateway(dagWrapper *dag.IpldWrapper, unixfsSystem *unixfs.UnixFsWrapper,
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", gateway.handleRoot)
 	mux.HandleFunc("/ipfs/", gateway.handleIPFS)
+	mux.HandleFunc("/ipns/", gateway.handleIPNS)
 	mux.HandleFunc("/api/v0/", gateway.handleAPI)
+	gateway.mux = mux
+
+	var handler http.Handler = mux
+	if config.Hostnames != nil {
+		handler = gateway.HostnameHandler(*config.Hostnames)
+	}
+	if config.Security != nil {
+		// Outermost, so quotas see the subdomain a CID arrived on
+		sm := security.NewSecurityMiddleware(*config.Security)
+		handler = sm.Handler()(handler)
+		gateway.limitName = fmt.Sprintf("gateway/%d", gatewaySeq.Add(1))
+		metrics.RegisterGlobalLimit(gateway.limitName, func() any { return sm.LimitStats() })
+	}
 
 	gateway.server = &http.Server{
 		Addr:           fmt.Sprintf(":%d", config.Port),
-		Handler:        mux,
+		Handler:        handler,
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		IdleTimeout:    60 * time.Second,
@@ -70,8 +127,24 @@ func (g *Gateway) Start() error {
 	return g.server.ListenAndServe()
 }
 
-// Stop stops the gateway server
+// Handler returns the gateway's HTTP handler, for mounting it elsewhere
+// or testing it with httptest
+func (g *Gateway) Handler() http.Handler {
+	return g.server.Handler
+}
+
+// LimitName returns the name the gateway's limit consumption is published
+// under in pkg/metrics, or "" when it was created without Security
+func (g *Gateway) LimitName() string {
+	return g.limitName
+}
+
+// Stop stops the gateway server and withdraws its limits from the metrics
+// server
 func (g *Gateway) Stop() error {
+	if g.limitName != "" {
+		metrics.UnregisterGlobalLimit(g.limitName)
+	}
 	if g.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -161,9 +234,72 @@ func (g *Gateway) handleIPFS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cidStr := pathParts[1]
-	subPath := strings.Join(pathParts[2:], "/")
+	g.serveIPFSPath(w, r, pathParts[1], strings.Join(pathParts[2:], "/"))
+}
+
+// handleIPNS handles /ipns/<name> requests, where name is an IPNS key or
+// a DNSLink domain, by resolving it and serving the /ipfs/ path it names
+func (g *Gateway) handleIPNS(w http.ResponseWriter, r *http.Request) {
+	if g.names == nil {
+		http.Error(w, "IPNS resolution is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "ipns" || pathParts[1] == "" {
+		http.Error(w, "Invalid IPNS path", http.StatusBadRequest)
+		return
+	}
+
+	if mediaType, _, err := negotiateFormat(r); err == nil && mediaType == MediaTypeIPNSRecord {
+		g.serveIPNSRecord(w, r, pathParts[1])
+		return
+	}
+
+	resolved, err := g.names.ResolveIPNS(r.Context(), pathParts[1])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to resolve %s: %s", pathParts[1], err), http.StatusNotFound)
+		return
+	}
+
+	// The resolved value may carry a path of its own: /ipfs/<cid>/dir
+	resolvedParts := strings.Split(strings.Trim(resolved, "/"), "/")
+	if len(resolvedParts) < 2 || resolvedParts[0] != "ipfs" {
+		http.Error(w, fmt.Sprintf("%s resolved to unsupported path %s", pathParts[1], resolved), http.StatusBadGateway)
+		return
+	}
+	subParts := append(resolvedParts[2:], pathParts[2:]...)
+	g.serveIPFSPath(w, r, resolvedParts[1], strings.Join(subParts, "/"))
+}
+
+// serveIPNSRecord answers with the signed record of name, which clients
+// verify against the name themselves
+func (g *Gateway) serveIPNSRecord(w http.ResponseWriter, r *http.Request, name string) {
+	exporter, ok := g.names.(RecordExporter)
+	if !ok {
+		http.Error(w, "IPNS records are not available", http.StatusNotImplemented)
+		return
+	}
+	if strings.Contains(name, ".") {
+		http.Error(w, "DNSLink names have no IPNS record", http.StatusBadRequest)
+		return
+	}
+	data, err := exporter.ExportRecord(r.Context(), name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("No record for %s: %s", name, err), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", MediaTypeIPNSRecord)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ipns-record"`, name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", cacheControl(r))
+	w.Write(data)
+}
 
+// serveIPFSPath serves subPath below the root cidStr
+func (g *Gateway) serveIPFSPath(w http.ResponseWriter, r *http.Request, cidStr, subPath string) {
 	// Parse CID
 	c, err := cid.Parse(cidStr)
 	if err != nil {
@@ -173,14 +309,38 @@ func (g *Gateway) handleIPFS(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	// Check if CID exists
-	exists, err := g.dagWrapper.BlockServiceWrapper.HasBlock(ctx, c)
+	if g.fetchTimeout > 0 {
+		// Missing blocks are fetched as they are read, within the budget
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.fetchTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		w = budgetWriter{ResponseWriter: w, ctx: ctx}
+	} else {
+		// Check if CID exists
+		exists, err := g.dagWrapper.BlockServiceWrapper.HasBlock(ctx, c)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check CID: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Content not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	// Verifiable responses: single blocks and CAR streams
+	mediaType, params, err := negotiateFormat(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to check CID: %s", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !exists {
-		http.Error(w, "Content not found", http.StatusNotFound)
+	switch mediaType {
+	case MediaTypeRaw, MediaTypeCAR:
+		g.serveTrustless(w, r, c, subPath, mediaType, params)
+		return
+	case MediaTypeIPNSRecord:
+		http.Error(w, "IPNS records are served under /ipns/", http.StatusBadRequest)
 		return
 	}
 
@@ -198,6 +358,19 @@ func (g *Gateway) handleIPFS(w http.ResponseWriter, r *http.Request) {
 func (g *Gateway) handleUnixFS(w http.ResponseWriter, r *http.Request, c cid.Cid, subPath string) {
 	ctx := r.Context()
 
+	// The CID the path ends on identifies the response, whichever name or
+	// root led to it
+	var terminal cid.Cid
+	if _, t, inBlock, err := g.resolveBlocks(ctx, c, subPath); err == nil && !inBlock {
+		terminal = t
+	}
+	if g.cache != nil && terminal.Defined() {
+		if body, ok := g.cache.Get(terminal); ok {
+			g.serveFile(w, r, bytes.NewReader(body), subPath, `"`+terminal.String()+`"`)
+			return
+		}
+	}
+
 	// Try to get as UnixFS node
 	node, err := g.unixfsSystem.Get(ctx, c)
 	if err == nil {
@@ -205,6 +378,9 @@ func (g *Gateway) handleUnixFS(w http.ResponseWriter, r *http.Request, c cid.Cid
 		if subPath != "" {
 			node, err = g.navigateToPath(ctx, node, subPath)
 			if err != nil {
+				if g.serveRedirects(w, r, c, subPath) {
+					return
+				}
 				http.Error(w, fmt.Sprintf("Path not found: %s", err), http.StatusNotFound)
 				return
 			}
@@ -214,16 +390,42 @@ func (g *Gateway) handleUnixFS(w http.ResponseWriter, r *http.Request, c cid.Cid
 		switch n := node.(type) {
 		case files.File:
 			defer n.Close()
-			data, err := io.ReadAll(n)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to read file: %s", err), http.StatusInternalServerError)
+			if !terminal.Defined() {
+				g.serveFile(w, r, n, subPath, "")
 				return
 			}
-			g.serveFile(w, r, data, subPath)
+			etag := `"` + terminal.String() + `"`
+
+			// Whole small files are assembled once and cached; ranges
+			// of them are cut from the cached copy next time
+			var content io.ReadSeeker = n
+			if g.cache != nil && r.Header.Get("Range") == "" {
+				if size, err := n.Size(); err == nil && size <= g.cache.MaxEntry() {
+					body, err := io.ReadAll(n)
+					if err != nil {
+						http.Error(w, fmt.Sprintf("Failed to read file: %s", err), http.StatusInternalServerError)
+						return
+					}
+					g.cache.Put(terminal, body)
+					content = bytes.NewReader(body)
+				}
+			}
+			g.serveFile(w, r, content, subPath, etag)
 			return
 
 		case files.Directory:
 			defer n.Close()
+			// /ipns/ listings expire quickly; the Etag lets clients
+			// revalidate them without downloading the page again
+			if terminal.Defined() {
+				etag := `"DirIndex-` + terminal.String() + `"`
+				w.Header().Set("Etag", etag)
+				if etagMatches(r.Header.Get("If-None-Match"), etag) {
+					w.Header().Set("Cache-Control", cacheControl(r))
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
 			entries := g.collectDirectoryEntries(n)
 			g.serveDirectoryListing(w, r, c, subPath, entries)
 			return
@@ -248,14 +450,17 @@ func (g *Gateway) handleRawContent(w http.ResponseWriter, r *http.Request, c cid
 	// Set appropriate headers
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable") // 1 year cache for immutable content
+	w.Header().Set("Cache-Control", cacheControl(r))
 
 	// Serve content
 	w.Write(data)
 }
 
-// serveFile serves a file with appropriate content type
-func (g *Gateway) serveFile(w http.ResponseWriter, r *http.Request, data []byte, filename string) {
+// serveFile streams a file with appropriate content type. Range and
+// If-Range requests are answered by seeking in the UnixFS DAG, so only
+// the chunks holding the requested bytes are loaded; this is what lets
+// players scrub through videos and downloads resume.
+func (g *Gateway) serveFile(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, filename, etag string) {
 	// Detect content type
 	contentType := "application/octet-stream"
 	if filename != "" {
@@ -264,13 +469,37 @@ func (g *Gateway) serveFile(w http.ResponseWriter, r *http.Request, data []byte,
 		}
 	}
 
-	// Set headers
+	// Set headers; ServeContent adds Content-Length, Content-Range and
+	// Accept-Ranges and checks If-None-Match and If-Range against the Etag
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Cache-Control", cacheControl(r))
+	if etag != "" {
+		w.Header().Set("Etag", etag)
+	}
 
 	// Serve content
-	w.Write(data)
+	http.ServeContent(w, r, filename, time.Time{}, content)
+}
+
+// cacheControl lets /ipfs/ responses be cached for a year, since content
+// under a CID never changes, but /ipns/ ones only briefly, since the name
+// can be pointed elsewhere
+func cacheControl(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/ipns/") {
+		return "public, max-age=60"
+	}
+	return "public, max-age=31536000, immutable"
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
 
 // DirectoryEntry represents a directory entry for listing
@@ -347,18 +576,30 @@ func (g *Gateway) collectDirectoryEntries(dir files.Directory) []DirectoryEntry
 // serveDirectoryListing serves an HTML directory listing
 func (g *Gateway) serveDirectoryListing(w http.ResponseWriter, r *http.Request, rootCID cid.Cid, subPath string, entries []DirectoryEntry) {
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", cacheControl(r))
+
+	// Links are relative to the content root: /ipfs/<cid> on the path
+	// gateway, the origin itself on subdomain and DNSLink hosts
+	rootPath := "/ipfs/" + rootCID.String()
+	if orig, ok := r.Context().Value(originalPathKey{}).(string); ok {
+		rootPath, subPath = "", strings.Trim(orig, "/")
+	}
+	rootHref := rootPath
+	if rootHref == "" {
+		rootHref = "/"
+	}
 
 	// Build breadcrumb path
 	breadcrumbs := []struct {
 		Name string
 		Path string
 	}{
-		{"Root", "/ipfs/" + rootCID.String()},
+		{"Root", rootHref},
 	}
 
 	if subPath != "" {
 		parts := strings.Split(subPath, "/")
-		currentPath := "/ipfs/" + rootCID.String()
+		currentPath := rootPath
 		for _, part := range parts {
 			currentPath = currentPath + "/" + part
 			breadcrumbs = append(breadcrumbs, struct {
@@ -440,7 +681,7 @@ func (g *Gateway) serveDirectoryListing(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Prepare template data
-	currentPath := "/ipfs/" + rootCID.String()
+	currentPath := rootPath
 	if subPath != "" {
 		currentPath = currentPath + "/" + subPath
 	}
@@ -449,9 +690,9 @@ func (g *Gateway) serveDirectoryListing(w http.ResponseWriter, r *http.Request,
 	if subPath != "" {
 		parentParts := strings.Split(subPath, "/")
 		if len(parentParts) > 1 {
-			parentPath = "/ipfs/" + rootCID.String() + "/" + strings.Join(parentParts[:len(parentParts)-1], "/")
+			parentPath = rootPath + "/" + strings.Join(parentParts[:len(parentParts)-1], "/")
 		} else {
-			parentPath = "/ipfs/" + rootCID.String()
+			parentPath = rootHref
 		}
 	}
 
@@ -497,6 +738,14 @@ func (g *Gateway) handleAPI(w http.ResponseWriter, r *http.Request) {
 		} else {
 			http.Error(w, "Unknown object endpoint", http.StatusNotFound)
 		}
+	case "cache":
+		if len(pathParts) >= 4 {
+			g.handleAPICache(w, r, pathParts[3])
+		} else {
+			http.Error(w, "Unknown cache endpoint", http.StatusNotFound)
+		}
+	case "collections":
+		g.handleAPICollections(w, r, pathParts[3:])
 	default:
 		http.Error(w, "Unknown API endpoint", http.StatusNotFound)
 	}
@@ -603,3 +852,161 @@ func (g *Gateway) handleAPIObjectStat(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// handleAPICache reports cache statistics (stats) or drops entries
+// (purge, optionally limited to ?cid=<file cid>)
+func (g *Gateway) handleAPICache(w http.ResponseWriter, r *http.Request, action string) {
+	if g.cache == nil {
+		http.Error(w, "Response cache is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	var response any
+	switch action {
+	case "stats":
+		response = g.cache.Stats()
+	case "purge":
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		purged := 0
+		if cidStr := r.URL.Query().Get("cid"); cidStr != "" {
+			c, err := cid.Parse(cidStr)
+			if err != nil {
+				http.Error(w, "Invalid CID", http.StatusBadRequest)
+				return
+			}
+			if g.cache.Purge(c) {
+				purged = 1
+			}
+		} else {
+			purged = g.cache.PurgeAll()
+		}
+		response = map[string]any{"Purged": purged}
+	default:
+		http.Error(w, "Unknown cache endpoint", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAPICollections manages pin collections:
+//
+//	GET    /api/v0/collections                  list them
+//	GET    /api/v0/collections/<name>           show one
+//	POST   /api/v0/collections/<name>?cid=...   pin roots into it, creating it
+//	DELETE /api/v0/collections/<name>[?cid=...] unpin the roots, or all of it
+//	GET    /api/v0/collections/<name>/car       export it as a CAR
+func (g *Gateway) handleAPICollections(w http.ResponseWriter, r *http.Request, parts []string) {
+	if g.pins == nil {
+		http.Error(w, "Pin collections are not enabled", http.StatusNotImplemented)
+		return
+	}
+	ctx := r.Context()
+
+	if len(parts) == 0 {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cols, err := g.pins.ListCollections(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"Collections": cols})
+		return
+	}
+
+	name := parts[0]
+	if len(parts) == 2 && parts[1] == "car" {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		g.serveCollectionCAR(w, r, name)
+		return
+	}
+	if len(parts) > 1 {
+		http.Error(w, "Unknown collections endpoint", http.StatusNotFound)
+		return
+	}
+
+	var roots []cid.Cid
+	for _, s := range r.URL.Query()["cid"] {
+		c, err := cid.Parse(s)
+		if err != nil {
+			http.Error(w, "Invalid CID", http.StatusBadRequest)
+			return
+		}
+		roots = append(roots, c)
+	}
+
+	var (
+		col *pin.Collection
+		err error
+	)
+	switch r.Method {
+	case "GET":
+		col, err = g.pins.GetCollection(ctx, name)
+	case "POST", "PUT":
+		col, err = g.pins.PinCollection(ctx, name, roots...)
+	case "DELETE":
+		if err = g.pins.UnpinCollection(ctx, name, roots...); err == nil && len(roots) > 0 {
+			col, err = g.pins.GetCollection(ctx, name)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, pin.ErrCollectionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if col == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(col)
+}
+
+// serveCollectionCAR sends the collection name as one CAR with its roots
+func (g *Gateway) serveCollectionCAR(w http.ResponseWriter, r *http.Request, name string) {
+	// CAR v2 export seeks back to write its header, so it goes through a
+	// temporary file
+	f, err := os.CreateTemp("", "collection-*.car")
+	if err != nil {
+		http.Error(w, "Failed to export collection", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = g.pins.ExportCollection(r.Context(), name, f)
+	if errors.Is(err, pin.ErrCollectionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export collection: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Failed to export collection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.ipld.car")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".car"))
+	io.Copy(w, f)
+}
@@ -0,0 +1,288 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+)
+
+// dnsLabelMaxLength is the longest a single DNS label may be
+const dnsLabelMaxLength = 63
+
+// HostnameConfig selects how a listener treats the Host header, following
+// Kubo's Gateway.PublicGateways and Gateway.NoDNSLink settings
+type HostnameConfig struct {
+	// PublicGateways are the hostnames (without port) this listener is a
+	// gateway for, such as "localhost" or "dweb.link"
+	PublicGateways map[string]*PublicGateway
+	// NoDNSLink stops other hostnames from being served as DNSLink
+	// websites, e.g. en.wikipedia-on-ipfs.org -> /ipns/en.wikipedia-on-ipfs.org
+	NoDNSLink bool
+}
+
+// PublicGateway configures one gateway hostname
+type PublicGateway struct {
+	// Paths are the content namespaces served on the hostname, usually
+	// "/ipfs" and "/ipns". Other content paths get 404.
+	Paths []string
+	// UseSubdomains redirects /ipfs/<cid> to <cid>.ipfs.<hostname> and
+	// /ipns/<name> to <name>.ipns.<hostname>, so every site gets its own
+	// origin and cannot read the cookies or storage of another
+	UseSubdomains bool
+	// NoDNSLink stops the hostname itself from being served as a DNSLink
+	// website on paths outside Paths
+	NoDNSLink bool
+}
+
+// DefaultPublicGateways is what Kubo uses without configuration: a
+// subdomain gateway on localhost, which browsers resolve for any
+// *.localhost name
+func DefaultPublicGateways() map[string]*PublicGateway {
+	return map[string]*PublicGateway{
+		"localhost": {Paths: []string{"/ipfs", "/ipns"}, UseSubdomains: true},
+	}
+}
+
+// originalPathKey holds the request path from before the Host header was
+// folded into it
+type originalPathKey struct{}
+
+// HostnameHandler returns the gateway's routes behind config, for serving
+// them on another listener with different hostname settings:
+//
+//	http.ListenAndServe("127.0.0.1:8081", gw.HostnameHandler(gateway.HostnameConfig{NoDNSLink: true}))
+func (g *Gateway) HostnameHandler(config HostnameConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.serveHostname(w, r, config)
+	})
+}
+
+// serveHostname maps the Host header onto a content path: subdomains of a
+// public gateway and DNSLink hostnames are rewritten to /ipfs/ or /ipns/
+// paths, and path requests on subdomain gateways are redirected
+func (g *Gateway) serveHostname(w http.ResponseWriter, r *http.Request, config HostnameConfig) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if gw, ok := config.PublicGateways[host]; ok {
+		if !isContentPath(r.URL.Path) {
+			// The homepage and the API are not content
+			g.mux.ServeHTTP(w, r)
+			return
+		}
+		if hasPathPrefix(r.URL.Path, gw.Paths...) {
+			if gw.UseSubdomains {
+				g.redirectToSubdomain(w, r)
+				return
+			}
+			g.mux.ServeHTTP(w, r)
+			return
+		}
+		if !gw.NoDNSLink && g.hasDNSLink(r.Context(), host) {
+			g.serveRewritten(w, r, "/ipns/"+host)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	if ns, rootID, ok := subdomainOf(host, config.PublicGateways); ok {
+		g.serveSubdomain(w, r, ns, rootID)
+		return
+	}
+
+	if !config.NoDNSLink && g.hasDNSLink(r.Context(), host) {
+		g.serveRewritten(w, r, "/ipns/"+host)
+		return
+	}
+
+	// An unknown hostname is an ordinary path gateway
+	g.mux.ServeHTTP(w, r)
+}
+
+// serveSubdomain serves <rootID>.<ns>.<gateway> by rewriting the request to
+// /<ns>/<rootID>/<path>, after redirecting names that have a canonical
+// label in another form
+func (g *Gateway) serveSubdomain(w http.ResponseWriter, r *http.Request, ns, rootID string) {
+	if ns == "ipfs" || !isDomain(rootID) {
+		label, err := subdomainLabel(ns, rootID)
+		if ns == "ipns" && err != nil {
+			// Not a key: a DNSLink name inlined into one label
+			if fqdn := fromDNSLabel(rootID); isDomain(fqdn) {
+				g.serveRewritten(w, r, "/ipns/"+fqdn)
+				return
+			}
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s subdomain: %s", ns, err), http.StatusBadRequest)
+			return
+		}
+		if label != rootID {
+			// Hostnames are case-insensitive, so CIDs must be in a
+			// lowercase base, and peer IDs must be libp2p-key CIDs
+			host := label + strings.TrimPrefix(strings.ToLower(r.Host), rootID)
+			http.Redirect(w, r, requestScheme(r)+"://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+	}
+	g.serveRewritten(w, r, "/"+ns+"/"+rootID)
+}
+
+// redirectToSubdomain sends /ipfs/<cid>/rest to <cid>.ipfs.<host>/rest
+func (g *Gateway) redirectToSubdomain(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		http.Error(w, "Missing content root", http.StatusBadRequest)
+		return
+	}
+	ns, rootID := parts[0], parts[1]
+	label, err := subdomainLabel(ns, rootID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot use %s as a subdomain: %s", rootID, err), http.StatusBadRequest)
+		return
+	}
+	rest := "/"
+	if len(parts) == 3 {
+		rest += parts[2]
+	}
+	target := fmt.Sprintf("%s://%s.%s.%s%s", requestScheme(r), label, ns, r.Host, rest)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// serveRewritten serves the request with prefix put in front of its path
+func (g *Gateway) serveRewritten(w http.ResponseWriter, r *http.Request, prefix string) {
+	ctx := context.WithValue(r.Context(), originalPathKey{}, r.URL.Path)
+	r = r.Clone(ctx)
+	r.URL.Path = prefix + r.URL.Path
+	r.URL.RawPath = ""
+	g.mux.ServeHTTP(w, r)
+}
+
+// hasDNSLink reports whether host is a domain with a DNSLink the gateway
+// can resolve. The resolver caches answers, so repeated requests are cheap.
+func (g *Gateway) hasDNSLink(ctx context.Context, host string) bool {
+	if g.names == nil || !isDomain(host) {
+		return false
+	}
+	_, err := g.names.ResolveIPNS(ctx, host)
+	return err == nil
+}
+
+// subdomainOf splits host into <rootID>.<ns>.<gateway> for a public
+// gateway using subdomains. rootID may itself contain dots when it is a
+// DNSLink name that was not inlined.
+func subdomainOf(host string, gateways map[string]*PublicGateway) (ns, rootID string, ok bool) {
+	for gwHost, gw := range gateways {
+		if !gw.UseSubdomains {
+			continue
+		}
+		prefix, found := strings.CutSuffix(host, "."+gwHost)
+		if !found {
+			continue
+		}
+		i := strings.LastIndex(prefix, ".")
+		if i <= 0 {
+			continue
+		}
+		ns, rootID = prefix[i+1:], prefix[:i]
+		if (ns == "ipfs" || ns == "ipns") && hasPathPrefix("/"+ns, gw.Paths...) {
+			return ns, rootID, true
+		}
+	}
+	return "", "", false
+}
+
+// subdomainLabel returns the form of rootID that is valid in a hostname:
+// CIDv1 in base32 for /ipfs/, a libp2p-key CID in base36 for IPNS keys
+// and an inlined label for DNSLink names. base36 is used when base32
+// would exceed the 63 characters a label can hold.
+func subdomainLabel(ns, rootID string) (string, error) {
+	var c cid.Cid
+	switch ns {
+	case "ipfs":
+		parsed, err := cid.Decode(rootID)
+		if err != nil {
+			return "", err
+		}
+		c = cid.NewCidV1(parsed.Type(), parsed.Hash())
+	case "ipns":
+		if isDomain(rootID) {
+			return toDNSLabel(rootID)
+		}
+		pid, err := peer.Decode(rootID)
+		if err != nil {
+			return "", err
+		}
+		c = peer.ToCid(pid)
+	default:
+		return "", fmt.Errorf("unknown namespace %q", ns)
+	}
+
+	label := c.String()
+	if ns == "ipns" || len(label) > dnsLabelMaxLength {
+		label, _ = c.StringOfBase(multibase.Base36)
+	}
+	if len(label) > dnsLabelMaxLength {
+		return "", fmt.Errorf("%s is too long for a DNS label", label)
+	}
+	return label, nil
+}
+
+// toDNSLabel inlines a DNSLink name into one label, so that a wildcard
+// TLS certificate for *.ipns.<gateway> covers it: "-" becomes "--" and
+// "." becomes "-" (my.v-long.example.com -> my-v--long-example-com)
+func toDNSLabel(fqdn string) (string, error) {
+	label := strings.ReplaceAll(strings.ReplaceAll(fqdn, "-", "--"), ".", "-")
+	if len(label) > dnsLabelMaxLength {
+		return "", fmt.Errorf("%s is too long to inline into a DNS label", fqdn)
+	}
+	return label, nil
+}
+
+// fromDNSLabel reverses toDNSLabel
+func fromDNSLabel(label string) string {
+	fqdn := strings.ReplaceAll(label, "--", "@") // @ never occurs in hostnames
+	fqdn = strings.ReplaceAll(fqdn, "-", ".")
+	return strings.ReplaceAll(fqdn, "@", "-")
+}
+
+// isDomain tells DNSLink names from keys and IP addresses
+func isDomain(name string) bool {
+	return strings.Contains(name, ".") && net.ParseIP(name) == nil
+}
+
+// isContentPath reports whether path is below /ipfs/ or /ipns/
+func isContentPath(path string) bool {
+	return hasPathPrefix(path, "/ipfs", "/ipns")
+}
+
+// hasPathPrefix reports whether path is one of prefixes or below one
+func hasPathPrefix(path string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme is the scheme the client used, as far as it can be told
+// behind a TLS-terminating proxy
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
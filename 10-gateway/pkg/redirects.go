@@ -0,0 +1,216 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/go-cid"
+)
+
+// maxRedirectsSize is the largest _redirects file the spec allows
+const maxRedirectsSize = 64 << 10
+
+// redirectsFile is the name of the rules file at the root of a site
+const redirectsFile = "_redirects"
+
+// redirectRule is one line of a _redirects file
+// (https://specs.ipfs.tech/http-gateways/web-redirects-file/)
+type redirectRule struct {
+	From   string // path pattern with :placeholders and a trailing *
+	To     string // path or URL, may use the placeholders and :splat
+	Status int
+}
+
+// redirectStatuses are the codes a rule may use. 200 rewrites to another
+// path in the site; 404, 410 and 451 serve one with that status.
+var redirectStatuses = map[int]bool{
+	200: true, 301: true, 302: true, 303: true, 307: true, 308: true,
+	404: true, 410: true, 451: true,
+}
+
+// redirectedKey marks requests already rewritten by a rule, so a rule
+// pointing at a missing path cannot loop
+type redirectedKey struct{}
+
+// parseRedirects reads rules, one "from to [status]" per line
+func parseRedirects(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want \"from to [status]\", got %q", n, line)
+		}
+
+		rule := redirectRule{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil || !redirectStatuses[status] {
+				return nil, fmt.Errorf("line %d: unsupported status %q", n, fields[2])
+			}
+			rule.Status = status
+		}
+		if !strings.HasPrefix(rule.From, "/") {
+			return nil, fmt.Errorf("line %d: from %q must be a path", n, rule.From)
+		}
+		external := strings.HasPrefix(rule.To, "http://") || strings.HasPrefix(rule.To, "https://")
+		if !external && !strings.HasPrefix(rule.To, "/") {
+			return nil, fmt.Errorf("line %d: to %q must be a path or URL", n, rule.To)
+		}
+		if external && (rule.Status < 300 || rule.Status >= 400) {
+			return nil, fmt.Errorf("line %d: status %d needs a path in the site, not %q", n, rule.Status, rule.To)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// match reports whether path fits the rule and returns the target with
+// placeholders filled in. ":name" matches one segment; a final "*"
+// matches the rest of the path, which ":splat" inserts.
+func (rule redirectRule) match(path string) (string, bool) {
+	from := strings.Split(strings.TrimPrefix(rule.From, "/"), "/")
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	values := make(map[string]string)
+	for i, part := range from {
+		if part == "*" && i == len(from)-1 {
+			values["splat"] = strings.Join(segments[min(i, len(segments)):], "/")
+			segments = segments[:min(i, len(segments))]
+			from = from[:i]
+			break
+		}
+		if i >= len(segments) {
+			return "", false
+		}
+		if name, ok := strings.CutPrefix(part, ":"); ok && name != "" {
+			values[name] = segments[i]
+		} else if part != segments[i] {
+			return "", false
+		}
+	}
+	if len(segments) != len(from) {
+		return "", false
+	}
+
+	// Longest names first, so :splat is not mistaken for :s
+	to := rule.To
+	for len(values) > 0 {
+		longest := ""
+		for name := range values {
+			if len(name) > len(longest) {
+				longest = name
+			}
+		}
+		to = strings.ReplaceAll(to, ":"+longest, values[longest])
+		delete(values, longest)
+	}
+	return to, true
+}
+
+// serveRedirects applies the _redirects file of the site rooted at
+// rootSub below c to a path that does not exist, and reports whether a
+// rule answered the request. Rules only apply on subdomain and DNSLink
+// hosts: on a path gateway every site shares one origin, and one site's
+// rules must not reach beyond it.
+func (g *Gateway) serveRedirects(w http.ResponseWriter, r *http.Request, c cid.Cid, subPath string) bool {
+	orig, ok := r.Context().Value(originalPathKey{}).(string)
+	if !ok || r.Context().Value(redirectedKey{}) != nil {
+		return false
+	}
+	ctx := r.Context()
+
+	// subPath is the site root's own path within c followed by orig
+	rootSub := strings.Trim(strings.TrimSuffix(strings.Trim(subPath, "/"), strings.Trim(orig, "/")), "/")
+	root, err := g.unixfsSystem.Get(ctx, c)
+	if err != nil {
+		return false
+	}
+	node, err := g.navigateToPath(ctx, root, strings.TrimPrefix(rootSub+"/"+redirectsFile, "/"))
+	if err != nil {
+		return false // no rules
+	}
+	file, ok := node.(files.File)
+	if !ok {
+		return false
+	}
+	defer file.Close()
+	if size, err := file.Size(); err == nil && size > maxRedirectsSize {
+		http.Error(w, fmt.Sprintf("%s is larger than %d bytes", redirectsFile, maxRedirectsSize), http.StatusInternalServerError)
+		return true
+	}
+	rules, err := parseRedirects(io.LimitReader(file, maxRedirectsSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s: %s", redirectsFile, err), http.StatusInternalServerError)
+		return true
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(orig)
+		if !ok {
+			continue
+		}
+		switch {
+		case rule.Status == http.StatusOK:
+			// Rewrite: serve the target as if it had been asked for
+			target := strings.Trim(rootSub+to, "/")
+			r = r.WithContext(context.WithValue(ctx, redirectedKey{}, true))
+			g.handleUnixFS(w, r, c, target)
+		case rule.Status >= 400:
+			g.serveWithStatus(w, r, c, strings.Trim(rootSub+to, "/"), rule.Status)
+		default:
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, to, rule.Status)
+		}
+		return true
+	}
+	return false
+}
+
+// serveWithStatus answers with the file at subPath, such as a custom 404
+// page, and the given error status
+func (g *Gateway) serveWithStatus(w http.ResponseWriter, r *http.Request, c cid.Cid, subPath string, status int) {
+	ctx := r.Context()
+	root, err := g.unixfsSystem.Get(ctx, c)
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	node, err := g.navigateToPath(ctx, root, subPath)
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	file, ok := node.(files.File)
+	if !ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(subPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl(r))
+	w.WriteHeader(status)
+	io.Copy(w, file)
+}
@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ufs "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+)
+
+// Response types of the trustless gateway spec
+// (https://specs.ipfs.tech/http-gateways/trustless-gateway/). Clients
+// verify these themselves, so any gateway can serve them.
+const (
+	MediaTypeRaw        = "application/vnd.ipld.raw"
+	MediaTypeCAR        = "application/vnd.ipld.car"
+	MediaTypeIPNSRecord = "application/vnd.ipfs.ipns-record"
+)
+
+// RecordExporter is implemented by name resolvers that can hand out the
+// signed record of a name, as the 09-ipns IPNSManager does. The gateway
+// uses it for application/vnd.ipfs.ipns-record responses.
+type RecordExporter interface {
+	ExportRecord(ctx context.Context, name string) ([]byte, error)
+}
+
+// carParams are the CAR options a client asked for
+type carParams struct {
+	scope string // block, entity or all
+	dups  bool
+}
+
+// negotiateFormat picks the response type: ?format= wins over the Accept
+// header, in which the highest-q trustless type is used. "" means the
+// usual deserialized response.
+func negotiateFormat(r *http.Request) (string, map[string]string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "":
+	case "raw":
+		return MediaTypeRaw, nil, nil
+	case "car":
+		return MediaTypeCAR, nil, nil
+	case "ipns-record":
+		return MediaTypeIPNSRecord, nil, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported format %q", f)
+	}
+
+	var (
+		best       string
+		bestParams map[string]string
+		bestQ      float64
+	)
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case MediaTypeRaw, MediaTypeCAR, MediaTypeIPNSRecord:
+		default:
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestParams, bestQ = mediaType, params, q
+		}
+	}
+	return best, bestParams, nil
+}
+
+// parseCARParams checks the CAR options from the Accept header and the
+// dag-scope query parameter
+func parseCARParams(r *http.Request, params map[string]string) (carParams, error) {
+	p := carParams{scope: "all"}
+	if v, ok := params["version"]; ok && v != "1" {
+		return p, fmt.Errorf("unsupported CAR version %q", v)
+	}
+	if v, ok := params["order"]; ok && v != "dfs" && v != "unk" {
+		return p, fmt.Errorf("unsupported CAR order %q", v)
+	}
+	switch params["dups"] {
+	case "", "n":
+	case "y":
+		p.dups = true
+	default:
+		return p, fmt.Errorf("invalid CAR dups %q", params["dups"])
+	}
+	switch scope := r.URL.Query().Get("dag-scope"); scope {
+	case "":
+	case "block", "entity", "all":
+		p.scope = scope
+	default:
+		return p, fmt.Errorf("invalid dag-scope %q", scope)
+	}
+	return p, nil
+}
+
+// serveTrustless answers a raw or CAR request for subPath below root
+func (g *Gateway) serveTrustless(w http.ResponseWriter, r *http.Request, root cid.Cid, subPath, mediaType string, params map[string]string) {
+	ctx := r.Context()
+
+	var p carParams
+	if mediaType == MediaTypeCAR {
+		var err error
+		if p, err = parseCARParams(r, params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pathBlocks, terminal, inBlock, err := g.resolveBlocks(ctx, root, subPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Path not found: %s", err), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Ipfs-Path", r.URL.Path)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", cacheControl(r))
+
+	switch mediaType {
+	case MediaTypeRaw:
+		if inBlock {
+			http.Error(w, "Path ends inside a block", http.StatusNotFound)
+			return
+		}
+		etag := `"` + terminal.String() + `.raw"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		data, err := g.dagWrapper.BlockServiceWrapper.GetBlockRaw(ctx, terminal)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get block: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeRaw)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.bin"`, terminal))
+		w.Header().Set("Etag", etag)
+		w.Write(data)
+
+	case MediaTypeCAR:
+		if inBlock {
+			p.scope = "block"
+		}
+		dups := "n"
+		if p.dups {
+			dups = "y"
+		}
+		etag := fmt.Sprintf(`"%s.car.%s.%s"`, root, p.scope, dups)
+		if subPath != "" {
+			etag = fmt.Sprintf(`"%s.car.%s.%s.%s"`, root, url.PathEscape(subPath), p.scope, dups)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeCAR+"; version=1; order=dfs; dups="+dups)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.car"`, root))
+		w.Header().Set("Etag", etag)
+		if err := g.writeCAR(ctx, w, root, pathBlocks, terminal, p); err != nil {
+			// The status and the start of the CAR are sent already. Abort
+			// the connection so the client sees a failed transfer instead
+			// of a CAR that merely ends early.
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// resolveBlocks walks subPath from root one segment at a time and
+// returns the blocks passed on the way, the block the path ends on, and
+// whether the path ends inside that block (a dag-cbor field) rather than
+// on a link
+func (g *Gateway) resolveBlocks(ctx context.Context, root cid.Cid, subPath string) ([]cid.Cid, cid.Cid, bool, error) {
+	var pathBlocks []cid.Cid
+	current := root
+	for _, seg := range strings.Split(subPath, "/") {
+		if seg == "" {
+			continue
+		}
+		_, next, rest, err := g.dagWrapper.ResolvePath(ctx, current, url.PathEscape(seg))
+		if err != nil {
+			return nil, cid.Undef, false, err
+		}
+		if len(rest) > 0 {
+			return pathBlocks, current, true, nil
+		}
+		pathBlocks = append(pathBlocks, current)
+		current = next
+	}
+	return pathBlocks, current, false, nil
+}
+
+// writeCAR streams a CARv1 with root as its root: the blocks on the
+// path, then the terminal block and, depending on the scope, the DAG
+// below it in depth-first order
+func (g *Gateway) writeCAR(ctx context.Context, w io.Writer, root cid.Cid, pathBlocks []cid.Cid, terminal cid.Cid, p carParams) error {
+	car, err := storage.NewWritable(w, []cid.Cid{root},
+		carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(p.dups))
+	if err != nil {
+		return err
+	}
+	put := func(c cid.Cid) error {
+		data, err := g.dagWrapper.BlockServiceWrapper.GetBlockRaw(ctx, c)
+		if err != nil {
+			return fmt.Errorf("get block %s: %w", c, err)
+		}
+		return car.Put(ctx, c.KeyString(), data)
+	}
+
+	for _, c := range pathBlocks {
+		if err := put(c); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[cid.Cid]struct{})
+	var walk func(c cid.Cid, scope string) error
+	walk = func(c cid.Cid, scope string) error {
+		if _, ok := seen[c]; ok && !p.dups {
+			return nil
+		}
+		seen[c] = struct{}{}
+		if err := put(c); err != nil {
+			return err
+		}
+		if scope == "block" {
+			return nil
+		}
+		nd, err := g.dagWrapper.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("load node %s: %w", c, err)
+		}
+		for _, l := range entityLinks(nd, scope) {
+			if err := walk(l, scope); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(terminal, p.scope)
+}
+
+// entityLinks returns the children to follow for scope. "all" follows
+// every link; "entity" stops at the boundary of a UnixFS entity: a file
+// includes all its chunks, a directory only itself, and a sharded
+// directory its shards but not the entries in them.
+func entityLinks(nd format.Node, scope string) []cid.Cid {
+	var links []cid.Cid
+	pn, isProto := nd.(*merkledag.ProtoNode)
+	var fsn *ufs.FSNode
+	if scope == "entity" {
+		if !isProto {
+			return nil
+		}
+		var err error
+		if fsn, err = ufs.FSNodeFromBytes(pn.Data()); err != nil || fsn.Type() == ufs.TDirectory {
+			return nil
+		}
+	}
+	for _, l := range nd.Links() {
+		if fsn != nil && fsn.Type() == ufs.THAMTShard {
+			padLen := len(fmt.Sprintf("%X", fsn.Fanout()-1))
+			if len(l.Name) != padLen {
+				continue // an entry, not a child shard
+			}
+		}
+		links = append(links, l.Cid)
+	}
+	return links
+}
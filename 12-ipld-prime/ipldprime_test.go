@@ -1,13 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	mc "github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
 	ipld "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
 )
 
@@ -108,3 +120,455 @@ func TestIpldLink(t *testing.T) {
 	assert.Equal(t, "leaf2", s2)
 
 }
+
+const articleSchema = `
+type Status enum {
+  | Draft
+  | Published ("pub")
+}
+
+type Author struct {
+  name  String
+  email optional String
+}
+
+type Article struct {
+  title  String (rename "t")
+  author Author
+  status Status
+  tags   [String]
+  prev   nullable &Article
+  views  {String:Int}
+}
+`
+
+type Author struct {
+	Name  string
+	Email *string
+}
+
+type Article struct {
+	Title  string
+	Author Author
+	Status string
+	Tags   []string
+	Prev   *cid.Cid
+	Views  struct {
+		Keys   []string
+		Values map[string]int64
+	}
+}
+
+func TestSchemaTyped(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), time.Second*5)
+	defer timeout()
+
+	d, err := ipld.NewDefault(nil, nil)
+	require.NoError(t, err)
+	s, err := ipld.ParseSchema(articleSchema)
+	require.NoError(t, err)
+	articles, err := ipld.Bind[Article](d, s, "Article")
+	require.NoError(t, err)
+
+	email := "ada@example.com"
+	first := &Article{
+		Title:  "hello",
+		Author: Author{Name: "ada", Email: &email},
+		Status: "Published",
+		Tags:   []string{"ipld"},
+	}
+	first.Views.Keys = []string{"web"}
+	first.Views.Values = map[string]int64{"web": 3}
+
+	t.Run("Round Trip", func(t *testing.T) {
+		c1, err := articles.Put(ctx, first)
+		require.NoError(t, err)
+		second := &Article{Title: "again", Author: Author{Name: "ada"}, Status: "Draft", Prev: &c1}
+		c2, err := articles.Put(ctx, second)
+		require.NoError(t, err)
+
+		got, err := articles.Get(ctx, c1)
+		require.NoError(t, err)
+		assert.Equal(t, first, got)
+		got, err = articles.Get(ctx, c2)
+		require.NoError(t, err)
+		require.NotNil(t, got.Prev)
+		assert.True(t, got.Prev.Equals(c1))
+		assert.Nil(t, got.Author.Email)
+
+		// The serial form uses the renames and enum serials
+		n, err := d.GetIPLD(ctx, c1)
+		require.NoError(t, err)
+		title, err := n.LookupByString("t")
+		require.NoError(t, err)
+		status, err := n.LookupByString("status")
+		require.NoError(t, err)
+		sv, err := status.AsString()
+		require.NoError(t, err)
+		assert.Equal(t, "pub", sv)
+		tv, _ := title.AsString()
+		assert.Equal(t, "hello", tv)
+	})
+
+	t.Run("Invalid Put", func(t *testing.T) {
+		bad := *first
+		bad.Status = "Archived"
+		_, err := articles.Put(ctx, &bad)
+		var verr *ipld.ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, "status", verr.Path.String())
+	})
+
+	t.Run("Invalid Data", func(t *testing.T) {
+		for _, tc := range []struct {
+			data map[string]any
+			path string
+		}{
+			{map[string]any{"author": map[string]any{"name": "ada"}, "status": "pub", "tags": []any{}, "prev": nil, "views": map[string]any{}}, "t"},
+			{map[string]any{"t": "x", "author": map[string]any{"name": 42}, "status": "pub", "tags": []any{}, "prev": nil, "views": map[string]any{}}, "author/name"},
+			{map[string]any{"t": "x", "author": map[string]any{"name": "ada"}, "status": "pub", "tags": []any{"a", 1}, "prev": nil, "views": map[string]any{}}, "tags/1"},
+			{map[string]any{"t": "x", "author": map[string]any{"name": "ada"}, "status": "Published", "tags": []any{}, "prev": nil, "views": map[string]any{}}, "status"},
+			{map[string]any{"t": "x", "author": map[string]any{"name": "ada"}, "status": "pub", "tags": []any{}, "prev": nil, "views": map[string]any{"web": "many"}}, "views/web"},
+			{map[string]any{"t": "x", "author": map[string]any{"name": "ada"}, "status": "pub", "tags": []any{}, "prev": nil, "views": map[string]any{}, "extra": true}, "extra"},
+		} {
+			c, err := d.PutIPLDAny(ctx, tc.data)
+			require.NoError(t, err)
+			_, err = articles.Get(ctx, c)
+			var verr *ipld.ValidationError
+			require.ErrorAs(t, err, &verr, tc.path)
+			assert.Equal(t, tc.path, verr.Path.String())
+		}
+	})
+
+	t.Run("Mismatched Go Type", func(t *testing.T) {
+		_, err := ipld.Bind[Author](d, s, "Article")
+		assert.Error(t, err)
+		_, err = ipld.Bind[Article](d, s, "Missing")
+		assert.Error(t, err)
+	})
+}
+
+func TestIPLDStream(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), time.Second*5)
+	defer timeout()
+
+	pw, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	d, err := ipld.NewDefault(nil, pw)
+	require.NoError(t, err)
+
+	t.Run("Round Trip", func(t *testing.T) {
+		in := `{"items":[1,2,3],"name":"stream"}`
+		c, err := d.PutIPLDStream(ctx, strings.NewReader(in), ipld.StreamOptions{})
+		require.NoError(t, err)
+
+		// Stored with the wrapper's codec, read back as any codec
+		v, err := d.GetIPLDAny(ctx, c)
+		require.NoError(t, err)
+		assert.Equal(t, "stream", v.(map[string]any)["name"])
+
+		var out bytes.Buffer
+		require.NoError(t, d.GetIPLDStream(ctx, c, &out, ipld.StreamOptions{}))
+		assert.JSONEq(t, in, out.String())
+
+		var raw bytes.Buffer
+		require.NoError(t, d.GetIPLDStream(ctx, c, &raw, ipld.StreamOptions{Codec: mc.DagCbor}))
+		c2, err := d.PutIPLDStream(ctx, &raw, ipld.StreamOptions{Codec: mc.DagCbor})
+		require.NoError(t, err)
+		assert.True(t, c.Equals(c2))
+	})
+
+	t.Run("Size Guard", func(t *testing.T) {
+		big := `{"data":"` + strings.Repeat("x", 1024) + `"}`
+		_, err := d.PutIPLDStream(ctx, strings.NewReader(big), ipld.StreamOptions{MaxSize: 512})
+		assert.ErrorIs(t, err, ipld.ErrNodeTooLarge)
+
+		c, err := d.PutIPLDStream(ctx, strings.NewReader(big), ipld.StreamOptions{})
+		require.NoError(t, err)
+		err = d.GetIPLDStream(ctx, c, &bytes.Buffer{}, ipld.StreamOptions{MaxSize: 512})
+		assert.ErrorIs(t, err, ipld.ErrNodeTooLarge)
+
+		// 511 bytes of JSON, 512 of CBOR: at the limit is fine
+		exact := `"` + strings.Repeat("y", 509) + `"`
+		_, err = d.PutIPLDStream(ctx, strings.NewReader(exact), ipld.StreamOptions{MaxSize: 512})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Hash Mismatch", func(t *testing.T) {
+		c, err := d.PutIPLDStream(ctx, strings.NewReader(`{"n":1}`), ipld.StreamOptions{})
+		require.NoError(t, err)
+		other, err := d.PutIPLDStream(ctx, strings.NewReader(`{"n":2}`), ipld.StreamOptions{})
+		require.NoError(t, err)
+		otherBlk, err := pw.Get(ctx, other)
+		require.NoError(t, err)
+
+		require.NoError(t, pw.DeleteBlock(ctx, c))
+		bad, err := blocks.NewBlockWithCid(otherBlk.RawData(), c)
+		require.NoError(t, err)
+		require.NoError(t, pw.Put(ctx, bad))
+
+		var out bytes.Buffer
+		err = d.GetIPLDStream(ctx, c, &out, ipld.StreamOptions{})
+		assert.ErrorAs(t, err, &linking.ErrHashMismatch{})
+		assert.Zero(t, out.Len())
+	})
+}
+
+func TestADL(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), time.Second*10)
+	defer timeout()
+
+	d, err := ipld.NewDefault(nil, nil)
+	require.NoError(t, err)
+
+	t.Run("HAMT", func(t *testing.T) {
+		entries := make(map[string]any)
+		for i := range 500 {
+			entries[fmt.Sprintf("key-%03d", i)] = fmt.Sprintf("value-%03d", i)
+		}
+		c, err := d.PutHAMT(ctx, entries)
+		require.NoError(t, err)
+		hamtEntries := func(c cid.Cid) map[string]any {
+			n, err := d.GetHAMT(ctx, c)
+			require.NoError(t, err)
+			v, err := ipld.NodeToAny(n)
+			require.NoError(t, err)
+			return v.(map[string]any)
+		}
+		assert.Equal(t, entries, hamtEntries(c))
+
+		root, err := d.GetHAMT(ctx, c)
+		require.NoError(t, err)
+		n, err := root.LookupByString("key-123")
+		require.NoError(t, err)
+		s, err := n.AsString()
+		require.NoError(t, err)
+		assert.Equal(t, "value-123", s)
+
+		// The stored layout stays reachable as plain data
+		adl, ok := root.(interface{ Substrate() datamodel.Node })
+		require.True(t, ok)
+		_, err = adl.Substrate().LookupByString("hamt")
+		assert.NoError(t, err)
+
+		// and a plain load is not reified
+		plain, err := d.GetIPLD(ctx, c)
+		require.NoError(t, err)
+		_, err = plain.LookupByString("hamt")
+		assert.NoError(t, err)
+
+		updated, err := d.UpdateHAMT(ctx, c, map[string]any{"key-500": "value-500"}, []string{"key-000"})
+		require.NoError(t, err)
+		entries["key-500"] = "value-500"
+		delete(entries, "key-000")
+		assert.Equal(t, entries, hamtEntries(updated))
+
+		empty, err := d.UpdateHAMT(ctx, cid.Undef, nil, nil)
+		require.NoError(t, err)
+		assert.Empty(t, hamtEntries(empty))
+	})
+
+	t.Run("FBL", func(t *testing.T) {
+		data := make([]byte, 5000)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		// 1250 leaves: two list levels
+		c, err := d.PutFBL(ctx, bytes.NewReader(data), 4)
+		require.NoError(t, err)
+
+		n, err := d.GetFBL(ctx, c)
+		require.NoError(t, err)
+		b, err := n.AsBytes()
+		require.NoError(t, err)
+		assert.Equal(t, data, b)
+
+		lb, ok := n.(datamodel.LargeBytesNode)
+		require.True(t, ok)
+		r, err := lb.AsLargeBytes()
+		require.NoError(t, err)
+		_, err = r.Seek(4097, io.SeekStart)
+		require.NoError(t, err)
+		tail, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data[4097:], tail)
+
+		// streaming encodes the stored list, not the whole byte array
+		var out bytes.Buffer
+		require.NoError(t, d.GetIPLDStream(ctx, c, &out, ipld.StreamOptions{MaxSize: 4 << 10}))
+		assert.Less(t, out.Len(), len(data))
+
+		c, err = d.PutFBL(ctx, bytes.NewReader(nil), 0)
+		require.NoError(t, err)
+		n, err = d.GetFBL(ctx, c)
+		require.NoError(t, err)
+		b, err = n.AsBytes()
+		require.NoError(t, err)
+		assert.Empty(t, b)
+	})
+
+	t.Run("Lookalike Data Untouched", func(t *testing.T) {
+		// user data that merely has the shape of a HAMT root
+		c, err := d.PutIPLDAny(ctx, map[string]any{"hashAlg": int64(0x12), "bucketSize": int64(3), "hamt": []any{[]byte{0}, []any{}}})
+		require.NoError(t, err)
+		n, err := d.GetIPLD(ctx, c)
+		require.NoError(t, err)
+		_, ok := n.(interface{ Substrate() datamodel.Node })
+		assert.False(t, ok)
+		v, err := n.LookupByString("bucketSize")
+		require.NoError(t, err)
+		assert.Equal(t, datamodel.Kind_Int, v.Kind())
+	})
+
+	t.Run("Plain Lists Untouched", func(t *testing.T) {
+		c, err := d.PutIPLDAny(ctx, []any{[]any{int64(1), "x"}})
+		require.NoError(t, err)
+		n, err := d.GetIPLD(ctx, c)
+		require.NoError(t, err)
+		assert.Equal(t, datamodel.Kind_List, n.Kind())
+	})
+}
+
+func TestConvert(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), time.Second*5)
+	defer timeout()
+
+	pw, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	d, err := ipld.NewDefault(nil, pw)
+	require.NoError(t, err)
+
+	t.Run("Round Trip", func(t *testing.T) {
+		c, err := d.PutIPLDAny(ctx, map[string]any{"name": "convert", "n": int64(7), "raw": []byte{1, 2}})
+		require.NoError(t, err)
+
+		j, err := d.Convert(ctx, c, mc.DagJson)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(mc.DagJson), j.Type())
+		assert.Equal(t, c.Prefix().MhType, j.Prefix().MhType)
+
+		want, err := d.GetIPLDAny(ctx, c)
+		require.NoError(t, err)
+		got, err := d.GetIPLDAny(ctx, j)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		back, err := d.Convert(ctx, j, mc.DagCbor)
+		require.NoError(t, err)
+		assert.True(t, c.Equals(back))
+
+		same, err := d.Convert(ctx, c, mc.DagCbor)
+		require.NoError(t, err)
+		assert.True(t, c.Equals(same))
+	})
+
+	t.Run("Dag-PB Source", func(t *testing.T) {
+		pb := merkledag.NodeWithData([]byte("hello"))
+		require.NoError(t, pw.Put(ctx, pb))
+
+		j, err := d.Convert(ctx, pb.Cid(), mc.DagJson)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), j.Version())
+		n, err := d.GetIPLD(ctx, j)
+		require.NoError(t, err)
+		data, err := n.LookupByString("Data")
+		require.NoError(t, err)
+		b, err := data.AsBytes()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello"), b)
+	})
+
+	t.Run("Unsupported Target", func(t *testing.T) {
+		c, err := d.PutIPLDAny(ctx, "x")
+		require.NoError(t, err)
+		_, err = d.Convert(ctx, c, mc.DagPb)
+		assert.Error(t, err)
+	})
+}
+
+func TestNodeCache(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), time.Second*5)
+	defer timeout()
+
+	pw, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	d, err := ipld.NewDefault(nil, pw)
+	require.NoError(t, err)
+	nc := d.EnableNodeCache(ipld.NodeCacheConfig{})
+	require.Same(t, nc, d.NodeCache())
+
+	leaf, err := d.PutIPLDAny(ctx, map[string]any{"v": "leaf"})
+	require.NoError(t, err)
+	root, err := d.PutIPLDAny(ctx, map[string]any{"child": leaf})
+	require.NoError(t, err)
+
+	t.Run("Hits", func(t *testing.T) {
+		for range 3 {
+			n, _, err := d.ResolvePath(ctx, root, "child/v")
+			require.NoError(t, err)
+			s, _ := n.AsString()
+			assert.Equal(t, "leaf", s)
+		}
+		stats := nc.Stats()
+		assert.Equal(t, int64(2), stats.Misses)
+		assert.Equal(t, int64(4), stats.Hits)
+		assert.Equal(t, 2, stats.Entries)
+		assert.Positive(t, stats.Bytes)
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		// Same CID, stored elsewhere
+		elsewhere, err := ipld.NewDefault(nil, nil)
+		require.NoError(t, err)
+		later, err := elsewhere.PutIPLDAny(ctx, "later")
+		require.NoError(t, err)
+
+		_, err = d.GetIPLD(ctx, later)
+		require.Error(t, err)
+		_, err = d.GetIPLD(ctx, later)
+		assert.True(t, format.IsNotFound(err))
+		assert.Equal(t, int64(1), nc.Stats().NegativeHits)
+
+		// Storing it through the wrapper clears the miss
+		stored, err := d.PutIPLDAny(ctx, "later")
+		require.NoError(t, err)
+		require.True(t, stored.Equals(later))
+		_, err = d.GetIPLD(ctx, later)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		small, err := ipld.NewDefault(nil, pw)
+		require.NoError(t, err)
+		snc := small.EnableNodeCache(ipld.NodeCacheConfig{MaxBytes: 64})
+		for i := range 10 {
+			c, err := small.PutIPLDAny(ctx, fmt.Sprintf("value number %d", i))
+			require.NoError(t, err)
+			_, err = small.GetIPLD(ctx, c)
+			require.NoError(t, err)
+		}
+		stats := snc.Stats()
+		assert.LessOrEqual(t, stats.Bytes, int64(64))
+		assert.Positive(t, stats.Evictions)
+	})
+
+	t.Run("Corrupt Block Not Cached", func(t *testing.T) {
+		c, err := ipld.NewDefault(nil, pw)
+		require.NoError(t, err)
+		cnc := c.EnableNodeCache(ipld.NodeCacheConfig{})
+		good, err := c.PutIPLDAny(ctx, "good")
+		require.NoError(t, err)
+		other, err := c.PutIPLDAny(ctx, "other")
+		require.NoError(t, err)
+		otherBlk, err := pw.Get(ctx, other)
+		require.NoError(t, err)
+		require.NoError(t, pw.DeleteBlock(ctx, good))
+		bad, err := blocks.NewBlockWithCid(otherBlk.RawData(), good)
+		require.NoError(t, err)
+		require.NoError(t, pw.Put(ctx, bad))
+
+		_, err = c.GetIPLD(ctx, good)
+		assert.ErrorAs(t, err, &linking.ErrHashMismatch{})
+		assert.Zero(t, cnc.Stats().Entries)
+	})
+}
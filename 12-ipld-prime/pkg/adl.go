@@ -0,0 +1,478 @@
+package ipldprime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipld/go-ipld-prime/node/mixins"
+	"github.com/ipld/go-ipld-prime/schema"
+	mc "github.com/multiformats/go-multicodec"
+)
+
+//-------------------------------------------------------------------------------//
+// Advanced Data Layouts
+//-------------------------------------------------------------------------------//
+
+// Names of the ADLs in LinkSystem.KnownReifiers, for selectors that use
+// InterpretAs
+const (
+	ADLHAMT = "hamt"
+	ADLFBL  = "fbl"
+)
+
+// DefaultFBLChunkSize is the size of the leaves PutFBL writes
+const DefaultFBLChunkSize = 256 << 10
+
+// fblWidth is how many parts one FBL list node refers to at most
+const fblWidth = 1024
+
+// registerADLs adds the HAMT and FBL reifiers to lsys.KnownReifiers, for
+// selectors that use InterpretAs. Plain loads are not reified: ordinary data
+// can have the shape of either layout.
+func registerADLs(lsys *linking.LinkSystem) {
+	// copies of a LinkSystem share the map
+	lsys.KnownReifiers = maps.Clone(lsys.KnownReifiers)
+	if lsys.KnownReifiers == nil {
+		lsys.KnownReifiers = make(map[string]linking.NodeReifier)
+	}
+	for name, reify := range map[string]linking.NodeReifier{ADLHAMT: ReifyHAMT, ADLFBL: ReifyFBL} {
+		if _, ok := lsys.KnownReifiers[name]; !ok {
+			lsys.KnownReifiers[name] = reify
+		}
+	}
+}
+
+// ReifyHAMT reads n as the root of an IPLD HAMT
+// (https://ipld.io/specs/advanced-data-layouts/hamt/spec/)
+func ReifyHAMT(_ linking.LinkContext, n datamodel.Node, lsys *linking.LinkSystem) (datamodel.Node, error) {
+	if !isHAMTRoot(n) {
+		return nil, fmt.Errorf("reify %s: not a HAMT root", ADLHAMT)
+	}
+	nb := hamt.HashMapRootPrototype.Representation().NewBuilder()
+	if err := datamodel.Copy(n, nb); err != nil {
+		return nil, fmt.Errorf("reify %s: %w", ADLHAMT, err)
+	}
+	root, ok := bindnode.Unwrap(nb.Build()).(*hamt.HashMapRoot)
+	if !ok {
+		return nil, fmt.Errorf("reify %s: unexpected root type", ADLHAMT)
+	}
+	node := hamt.Node{HashMapRoot: *root}
+	return node.WithLinking(substrateLinkSystem(lsys), cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version: 1, Codec: uint64(mc.DagCbor), MhType: uint64(mc.Sha2_256), MhLength: -1,
+	}}), nil
+}
+
+// ReifyFBL reads n as a Flexible Byte Layout
+// (https://ipld.io/specs/advanced-data-layouts/fbl/): a list of
+// [length, link] parts, each pointing at bytes or at another such list
+func ReifyFBL(_ linking.LinkContext, n datamodel.Node, lsys *linking.LinkSystem) (datamodel.Node, error) {
+	if n.Kind() == datamodel.Kind_Bytes {
+		return n, nil
+	}
+	if !isFBL(n) {
+		return nil, fmt.Errorf("reify %s: not a byte layout", ADLFBL)
+	}
+	size, err := fblLength(n)
+	if err != nil {
+		return nil, err
+	}
+	return &fblNode{Bytes: mixins.Bytes{TypeName: ADLFBL}, root: n, lsys: substrateLinkSystem(lsys), size: size}, nil
+}
+
+// substrateLinkSystem loads the blocks inside an ADL as plain data
+func substrateLinkSystem(lsys *linking.LinkSystem) linking.LinkSystem {
+	sub := *lsys
+	sub.NodeReifier = nil
+	return sub
+}
+
+func isHAMTRoot(n datamodel.Node) bool {
+	if n.Kind() != datamodel.Kind_Map || n.Length() != 3 {
+		return false
+	}
+	for key, kind := range map[string]datamodel.Kind{
+		"hashAlg":    datamodel.Kind_Int,
+		"bucketSize": datamodel.Kind_Int,
+		"hamt":       datamodel.Kind_List,
+	} {
+		v, err := n.LookupByString(key)
+		if err != nil || v.Kind() != kind {
+			return false
+		}
+	}
+	return true
+}
+
+// isFBL reports whether n is a non-empty list of [length, link] parts
+func isFBL(n datamodel.Node) bool {
+	if n.Kind() != datamodel.Kind_List || n.Length() == 0 {
+		return false
+	}
+	it := n.ListIterator()
+	for !it.Done() {
+		_, part, err := it.Next()
+		if err != nil || part.Kind() != datamodel.Kind_List || part.Length() != 2 {
+			return false
+		}
+		length, err1 := part.LookupByIndex(0)
+		link, err2 := part.LookupByIndex(1)
+		if err1 != nil || err2 != nil || length.Kind() != datamodel.Kind_Int || link.Kind() != datamodel.Kind_Link {
+			return false
+		}
+	}
+	return true
+}
+
+// fblPart is one [length, link] entry of an FBL list
+type fblPart struct {
+	length int64
+	link   datamodel.Link
+}
+
+func fblParts(n datamodel.Node) ([]fblPart, error) {
+	parts := make([]fblPart, 0, n.Length())
+	it := n.ListIterator()
+	for !it.Done() {
+		_, p, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		ln, err := p.LookupByIndex(0)
+		if err != nil {
+			return nil, err
+		}
+		length, err := ln.AsInt()
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("%s: negative part length %d", ADLFBL, length)
+		}
+		lk, err := p.LookupByIndex(1)
+		if err != nil {
+			return nil, err
+		}
+		link, err := lk.AsLink()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, fblPart{length: length, link: link})
+	}
+	return parts, nil
+}
+
+func fblLength(n datamodel.Node) (int64, error) {
+	parts, err := fblParts(n)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, p := range parts {
+		size += p.length
+	}
+	return size, nil
+}
+
+// fblNode is a reified FBL: a bytes node read part by part
+type fblNode struct {
+	mixins.Bytes
+	root datamodel.Node
+	lsys linking.LinkSystem
+	size int64
+}
+
+var _ datamodel.LargeBytesNode = (*fblNode)(nil)
+
+func (n *fblNode) Prototype() datamodel.NodePrototype {
+	return basicnode.Prototype.Bytes
+}
+
+// Substrate returns the list the bytes are laid out in
+func (n *fblNode) Substrate() datamodel.Node {
+	return n.root
+}
+
+// Length is the number of bytes, unlike the -1 of other scalar nodes
+func (n *fblNode) Length() int64 {
+	return n.size
+}
+
+// AsBytes reads every part into memory; AsLargeBytes streams them
+func (n *fblNode) AsBytes() ([]byte, error) {
+	r, err := n.AsLargeBytes()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n.size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("%s: %w", ADLFBL, err)
+	}
+	return buf, nil
+}
+
+func (n *fblNode) AsLargeBytes() (io.ReadSeeker, error) {
+	return &fblReader{node: n}, nil
+}
+
+// fblReader walks down from the root to the leaf holding the read
+// offset, keeping only that leaf in memory
+type fblReader struct {
+	node      *fblNode
+	off       int64
+	leaf      []byte
+	leafStart int64
+}
+
+func (r *fblReader) Read(p []byte) (int, error) {
+	if r.off >= r.node.size {
+		return 0, io.EOF
+	}
+	if r.leaf == nil || r.off < r.leafStart || r.off >= r.leafStart+int64(len(r.leaf)) {
+		leaf, start, err := r.findLeaf(r.node.root, 0, r.node.size)
+		if err != nil {
+			return 0, err
+		}
+		r.leaf, r.leafStart = leaf, start
+	}
+	n := copy(p, r.leaf[r.off-r.leafStart:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *fblReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.node.size
+	default:
+		return r.off, fmt.Errorf("%s: invalid whence %d", ADLFBL, whence)
+	}
+	if offset < 0 {
+		return r.off, fmt.Errorf("%s: negative offset %d", ADLFBL, offset)
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// findLeaf returns the leaf of layout n, starting at start and length
+// bytes long, that holds the read offset
+func (r *fblReader) findLeaf(n datamodel.Node, start, length int64) ([]byte, int64, error) {
+	if n.Kind() == datamodel.Kind_Bytes {
+		b, err := n.AsBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		if int64(len(b)) != length {
+			return nil, 0, fmt.Errorf("%s: part at %d has %d bytes, %d declared", ADLFBL, start, len(b), length)
+		}
+		return b, start, nil
+	}
+	parts, err := fblParts(n)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", ADLFBL, err)
+	}
+	for _, p := range parts {
+		if r.off < start+p.length {
+			child, err := r.node.lsys.Load(linking.LinkContext{Ctx: context.Background()}, p.link, basicnode.Prototype.Any)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: load part %s: %w", ADLFBL, p.link, err)
+			}
+			return r.findLeaf(child, start, p.length)
+		}
+		start += p.length
+	}
+	return nil, 0, fmt.Errorf("%s: offset %d is past the parts: %w", ADLFBL, r.off, io.ErrUnexpectedEOF)
+}
+
+// PutHAMT stores entries as an IPLD HAMT and returns the CID of its
+// root. Read back through GetHAMT, the root is a map node again.
+func (d *IpldWrapper) PutHAMT(ctx context.Context, entries map[string]any) (cid.Cid, error) {
+	lp := cidlink.LinkPrototype{Prefix: *d.Prefix}
+	b := hamt.NewBuilder(hamt.Prototype{}).WithLinking(substrateLinkSystem(&d.LinkSystem), lp)
+	ma, err := b.BeginMap(int64(len(entries)))
+	if err != nil {
+		return cid.Undef, err
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return cid.Undef, err
+		}
+		v, err := AnyToNode(entries[k])
+		if err != nil {
+			return cid.Undef, fmt.Errorf("put %s: entry %q: %w", ADLHAMT, k, err)
+		}
+		if err := ma.AssembleKey().AssignString(k); err != nil {
+			return cid.Undef, err
+		}
+		if err := ma.AssembleValue().AssignNode(v); err != nil {
+			return cid.Undef, fmt.Errorf("put %s: entry %q: %w", ADLHAMT, k, err)
+		}
+	}
+	if err := ma.Finish(); err != nil {
+		return cid.Undef, err
+	}
+	// Substrate is the typed view; the blocks hold its representation
+	root := hamt.Build(b).Substrate()
+	if tn, ok := root.(schema.TypedNode); ok {
+		root = tn.Representation()
+	}
+	return d.PutIPLD(ctx, root)
+}
+
+// GetHAMT loads the HAMT root at c as a map node, loading the blocks
+// inside it as entries are read
+func (d *IpldWrapper) GetHAMT(ctx context.Context, c cid.Cid) (datamodel.Node, error) {
+	n, err := d.GetIPLD(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return ReifyHAMT(linking.LinkContext{Ctx: ctx}, n, &d.LinkSystem)
+}
+
+// GetFBL loads the FBL root at c as a bytes node, loading its leaves as
+// bytes are read. A raw block is returned as it is.
+func (d *IpldWrapper) GetFBL(ctx context.Context, c cid.Cid) (datamodel.Node, error) {
+	n, err := d.GetIPLD(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return ReifyFBL(linking.LinkContext{Ctx: ctx}, n, &d.LinkSystem)
+}
+
+// UpdateHAMT sets and then removes entries of the HAMT under root (an
+// empty one if root is cid.Undef) and returns the CID of the new root.
+// The HAMT library cannot change a map in place, so every entry is read
+// and the map is built again.
+func (d *IpldWrapper) UpdateHAMT(ctx context.Context, root cid.Cid, set map[string]any, remove []string) (cid.Cid, error) {
+	entries := make(map[string]any, len(set))
+	if root.Defined() {
+		n, err := d.GetHAMT(ctx, root)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("update %s %s: %w", ADLHAMT, root, err)
+		}
+		it := n.MapIterator()
+		for !it.Done() {
+			k, v, err := it.Next()
+			if err != nil {
+				return cid.Undef, fmt.Errorf("update %s %s: %w", ADLHAMT, root, err)
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return cid.Undef, err
+			}
+			entries[key] = v
+		}
+	}
+	for k, v := range set {
+		entries[k] = v
+	}
+	for _, k := range remove {
+		delete(entries, k)
+	}
+	return d.PutHAMT(ctx, entries)
+}
+
+// PutFBL stores the bytes of r as an FBL of raw leaves of chunkSize bytes
+// (DefaultFBLChunkSize if 0), reading one leaf at a time, and returns the
+// CID of its root. Read back through GetFBL, the root is a bytes node
+// again.
+func (d *IpldWrapper) PutFBL(ctx context.Context, r io.Reader, chunkSize int) (cid.Cid, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultFBLChunkSize
+	}
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	leafProto := cidlink.LinkPrototype{Prefix: *d.Prefix}
+	leafProto.Codec = uint64(mc.Raw)
+	listProto := cidlink.LinkPrototype{Prefix: *d.Prefix}
+
+	var parts []fblPart
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			lnk, serr := d.LinkSystem.Store(lnkCtx, leafProto, basicnode.NewBytes(buf[:n]))
+			if serr != nil {
+				return cid.Undef, fmt.Errorf("put %s: %w", ADLFBL, serr)
+			}
+			parts = append(parts, fblPart{length: int64(n), link: lnk})
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return cid.Undef, fmt.Errorf("put %s: %w", ADLFBL, err)
+		}
+	}
+	if len(parts) == 0 {
+		return d.PutIPLD(ctx, basicnode.NewBytes(nil))
+	}
+
+	// Group the parts into lists until one list holds them all
+	for {
+		var next []fblPart
+		for chunk := range slices.Chunk(parts, fblWidth) {
+			list, err := fblList(chunk)
+			if err != nil {
+				return cid.Undef, err
+			}
+			if len(parts) <= fblWidth {
+				return d.PutIPLD(ctx, list)
+			}
+			lnk, err := d.LinkSystem.Store(lnkCtx, listProto, list)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("put %s: %w", ADLFBL, err)
+			}
+			var length int64
+			for _, p := range chunk {
+				length += p.length
+			}
+			next = append(next, fblPart{length: length, link: lnk})
+		}
+		parts = next
+	}
+}
+
+func fblList(parts []fblPart) (datamodel.Node, error) {
+	nb := basicnode.Prototype.List.NewBuilder()
+	la, err := nb.BeginList(int64(len(parts)))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		pa, err := la.AssembleValue().BeginList(2)
+		if err != nil {
+			return nil, err
+		}
+		if err := pa.AssembleValue().AssignInt(p.length); err != nil {
+			return nil, err
+		}
+		if err := pa.AssembleValue().AssignLink(p.link); err != nil {
+			return nil, err
+		}
+		if err := pa.Finish(); err != nil {
+			return nil, err
+		}
+	}
+	if err := la.Finish(); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
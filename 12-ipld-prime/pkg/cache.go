@@ -0,0 +1,292 @@
+package ipldprime
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+const (
+	// DefaultNodeCacheBytes is the size of the blocks a NodeCache keeps
+	DefaultNodeCacheBytes = 32 << 20
+	// DefaultNegativeTTL is how long a NodeCache remembers a missing block
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// NodeCacheConfig sizes a NodeCache. MaxBytes counts the encoded size of
+// each block, not the memory its decoded node takes.
+type NodeCacheConfig struct {
+	MaxBytes    int64         // serial bytes of the cached nodes (default 32MiB)
+	NegativeTTL time.Duration // how long misses are remembered (default 10s); negative disables it
+}
+
+// NodeCacheStats counts the work of a NodeCache
+type NodeCacheStats struct {
+	Hits         int64 // loads served without decoding
+	Misses       int64 // loads decoded from storage
+	NegativeHits int64 // loads failed fast on a remembered miss
+	Evictions    int64
+	Entries      int   // nodes held
+	Bytes        int64 // serial bytes of the nodes held
+}
+
+// NodeCache keeps decoded nodes keyed by CID, least recently used out
+// first, and remembers for a while which CIDs storage did not have.
+// Nodes are only evicted for space: a node decoded from a hash-verified
+// block stays correct for as long as it is held. A block stored through
+// the LinkSystem clears its remembered miss at once.
+type NodeCache struct {
+	negativeTTL time.Duration
+
+	mu       sync.Mutex
+	limit    int64
+	size     int64
+	order    *list.List // of *nodeEntry, most recent first
+	items    map[cid.Cid]*list.Element
+	negative map[cid.Cid]time.Time // CID -> remembered until
+
+	hits, misses, negativeHits, evictions atomic.Int64
+}
+
+type nodeEntry struct {
+	key  cid.Cid
+	node datamodel.Node
+	raw  []byte // the block, for the hash check of a cached load
+}
+
+func newNodeCache(cfg NodeCacheConfig) *NodeCache {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultNodeCacheBytes
+	}
+	if cfg.NegativeTTL == 0 {
+		cfg.NegativeTTL = DefaultNegativeTTL
+	}
+	return &NodeCache{
+		negativeTTL: cfg.NegativeTTL,
+		limit:       cfg.MaxBytes,
+		order:       list.New(),
+		items:       make(map[cid.Cid]*list.Element),
+		negative:    make(map[cid.Cid]time.Time),
+	}
+}
+
+// EnableNodeCache puts a NodeCache in front of the wrapper's LinkSystem
+// and returns it. Loads of a cached CID skip storage and decoding; only
+// the block hash is checked again. Copies of the LinkSystem taken before
+// the call, e.g. by a graphsync exchange, do not use the cache.
+func (d *IpldWrapper) EnableNodeCache(cfg NodeCacheConfig) *NodeCache {
+	nc := newNodeCache(cfg)
+	nc.wrap(&d.LinkSystem)
+	d.cache = nc
+	return nc
+}
+
+// NodeCache returns the cache EnableNodeCache set up, or nil
+func (d *IpldWrapper) NodeCache() *NodeCache {
+	return d.cache
+}
+
+// Stats returns the counters and current size of the cache
+func (nc *NodeCache) Stats() NodeCacheStats {
+	nc.mu.Lock()
+	entries, size := nc.order.Len(), nc.size
+	nc.mu.Unlock()
+	return NodeCacheStats{
+		Hits:         nc.hits.Load(),
+		Misses:       nc.misses.Load(),
+		NegativeHits: nc.negativeHits.Load(),
+		Evictions:    nc.evictions.Load(),
+		Entries:      entries,
+		Bytes:        size,
+	}
+}
+
+// Purge drops every cached node and remembered miss
+func (nc *NodeCache) Purge() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.order.Init()
+	clear(nc.items)
+	clear(nc.negative)
+	nc.size = 0
+}
+
+// wrap routes the loads and stores of lsys through the cache. The
+// decoder of a cached CID hands out the cached node; the storage opener
+// hands out the cached block, so Fill still checks its hash.
+func (nc *NodeCache) wrap(lsys *linking.LinkSystem) {
+	chooseDecoder := lsys.DecoderChooser
+	openRead := lsys.StorageReadOpener
+	openWrite := lsys.StorageWriteOpener
+
+	lsys.DecoderChooser = func(lnk datamodel.Link) (codec.Decoder, error) {
+		decode, err := chooseDecoder(lnk)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := linkCid(lnk)
+		if !ok {
+			return decode, nil
+		}
+		if e := nc.get(c); e != nil {
+			nc.hits.Add(1)
+			return func(na datamodel.NodeAssembler, r io.Reader) error {
+				if _, err := io.Copy(io.Discard, r); err != nil { // feed the hasher
+					return err
+				}
+				return na.AssignNode(e.node)
+			}, nil
+		}
+		return func(na datamodel.NodeAssembler, r io.Reader) error {
+			nc.misses.Add(1)
+			var raw bytes.Buffer
+			nb := basicnode.Prototype.Any.NewBuilder()
+			if err := decode(nb, io.TeeReader(r, &raw)); err != nil {
+				return err
+			}
+			if _, err := io.Copy(&raw, r); err != nil {
+				return err
+			}
+			n := nb.Build()
+			// Fill checks the hash only after decoding; nothing that
+			// fails it may be cached
+			if blockMatches(c, raw.Bytes()) {
+				nc.add(c, n, raw.Bytes())
+			}
+			return na.AssignNode(n)
+		}, nil
+	}
+
+	if openRead != nil {
+		lsys.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			c, ok := linkCid(lnk)
+			if !ok {
+				return openRead(lnkCtx, lnk)
+			}
+			if e := nc.get(c); e != nil {
+				return bytes.NewReader(e.raw), nil
+			}
+			if nc.missing(c) {
+				nc.negativeHits.Add(1)
+				return nil, format.ErrNotFound{Cid: c}
+			}
+			r, err := openRead(lnkCtx, lnk)
+			if format.IsNotFound(err) {
+				nc.addMissing(c)
+			}
+			return r, err
+		}
+	}
+
+	if openWrite != nil {
+		lsys.StorageWriteOpener = func(lnkCtx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+			w, commit, err := openWrite(lnkCtx)
+			if err != nil {
+				return nil, nil, err
+			}
+			return w, func(lnk datamodel.Link) error {
+				if err := commit(lnk); err != nil {
+					return err
+				}
+				if c, ok := linkCid(lnk); ok {
+					nc.forgetMissing(c)
+				}
+				return nil
+			}, nil
+		}
+	}
+}
+
+func linkCid(lnk datamodel.Link) (cid.Cid, bool) {
+	cl, ok := lnk.(cidlink.Link)
+	return cl.Cid, ok && cl.Cid.Defined()
+}
+
+// blockMatches reports whether data hashes to c
+func blockMatches(c cid.Cid, data []byte) bool {
+	sum, err := c.Prefix().Sum(data)
+	return err == nil && bytes.Equal(sum.Hash(), c.Hash())
+}
+
+func (nc *NodeCache) get(c cid.Cid) *nodeEntry {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	el, ok := nc.items[c]
+	if !ok {
+		return nil
+	}
+	nc.order.MoveToFront(el)
+	return el.Value.(*nodeEntry)
+}
+
+func (nc *NodeCache) add(c cid.Cid, n datamodel.Node, raw []byte) {
+	if int64(len(raw)) > nc.limit {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if _, ok := nc.items[c]; ok {
+		return
+	}
+	nc.items[c] = nc.order.PushFront(&nodeEntry{key: c, node: n, raw: raw})
+	nc.size += int64(len(raw))
+	delete(nc.negative, c)
+	for nc.size > nc.limit {
+		oldest := nc.order.Back()
+		e := oldest.Value.(*nodeEntry)
+		nc.order.Remove(oldest)
+		delete(nc.items, e.key)
+		nc.size -= int64(len(e.raw))
+		nc.evictions.Add(1)
+	}
+}
+
+func (nc *NodeCache) missing(c cid.Cid) bool {
+	if nc.negativeTTL < 0 {
+		return false
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	until, ok := nc.negative[c]
+	if ok && time.Now().After(until) {
+		delete(nc.negative, c)
+		return false
+	}
+	return ok
+}
+
+func (nc *NodeCache) addMissing(c cid.Cid) {
+	if nc.negativeTTL < 0 {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	now := time.Now()
+	// Expired misses are dropped here, so the map stays as small as the
+	// misses of the last TTL
+	if len(nc.negative) >= 1024 {
+		for k, until := range nc.negative {
+			if now.After(until) {
+				delete(nc.negative, k)
+			}
+		}
+	}
+	nc.negative[c] = now.Add(nc.negativeTTL)
+}
+
+func (nc *NodeCache) forgetMissing(c cid.Cid) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	delete(nc.negative, c)
+}
@@ -1,7 +1,47 @@
 package ipldprime
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	_ "github.com/ipld/go-codec-dagpb"
 	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
 	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
 	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mc "github.com/multiformats/go-multicodec"
 )
+
+// Convert loads c in its own codec, re-encodes it as target (dag-json or
+// dag-cbor) and stores the result, returning its CID. The hash function
+// of c is kept; the new CID is always version 1. Converting to the codec
+// c already has returns c. Links are kept as they are, so only the one
+// block is converted, and ADLs are converted as their stored layout.
+func (d *IpldWrapper) Convert(ctx context.Context, c cid.Cid, target mc.Code) (cid.Cid, error) {
+	switch target {
+	case mc.DagJson, mc.DagCbor:
+	default:
+		return cid.Undef, fmt.Errorf("convert %s: unsupported target codec %s", c, target)
+	}
+	if mc.Code(c.Type()) == target {
+		return c, nil
+	}
+
+	lsys := substrateLinkSystem(&d.LinkSystem)
+	n, err := lsys.Load(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, basicnode.Prototype.Any)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("convert %s: %w", c, err)
+	}
+
+	prefix := c.Prefix()
+	prefix.Version = 1
+	prefix.Codec = uint64(target)
+	lnk, err := lsys.Store(linking.LinkContext{Ctx: ctx}, cidlink.LinkPrototype{Prefix: prefix}, n)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("convert %s to %s: %w", c, target, err)
+	}
+	return lnk.(cidlink.Link).Cid, nil
+}
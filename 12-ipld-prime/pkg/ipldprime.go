@@ -20,6 +20,8 @@ import (
 type IpldWrapper struct {
 	Prefix     *cid.Prefix
 	LinkSystem linking.LinkSystem
+
+	cache *NodeCache
 }
 
 func New(prefix *cid.Prefix, linkSystem *linking.LinkSystem) (*IpldWrapper, error) {
@@ -29,10 +31,12 @@ func New(prefix *cid.Prefix, linkSystem *linking.LinkSystem) (*IpldWrapper, erro
 	if linkSystem == nil {
 		return nil, fmt.Errorf("linkSystem is required")
 	}
-	return &IpldWrapper{
+	d := &IpldWrapper{
 		Prefix:     prefix,
 		LinkSystem: *linkSystem,
-	}, nil
+	}
+	registerADLs(&d.LinkSystem)
+	return d, nil
 }
 
 func NewDefault(prefix *cid.Prefix, persistentWrapper *persistent.PersistentWrapper) (*IpldWrapper, error) {
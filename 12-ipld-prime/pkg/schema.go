@@ -0,0 +1,396 @@
+package ipldprime
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	schemadmt "github.com/ipld/go-ipld-prime/schema/dmt"
+	schemadsl "github.com/ipld/go-ipld-prime/schema/dsl"
+)
+
+//-------------------------------------------------------------------------------//
+// Schema-typed nodes
+//-------------------------------------------------------------------------------//
+
+// Schema is a compiled IPLD schema whose types Go structs can be bound to
+type Schema struct {
+	ts *schema.TypeSystem
+}
+
+// ParseSchema compiles a schema written in the IPLD schema DSL
+func ParseSchema(dsl string) (*Schema, error) {
+	file, err := schemadsl.ParseBytes([]byte(dsl))
+	if err != nil {
+		return nil, fmt.Errorf("schema parse file: %w", err)
+	}
+	ts := schema.TypeSystem{}
+	ts.Init()
+	if err := schemadmt.Compile(&ts, file); err != nil {
+		return nil, fmt.Errorf("schema compile: %w", err)
+	}
+	return &Schema{ts: &ts}, nil
+}
+
+// TypeSystem returns the compiled types
+func (s *Schema) TypeSystem() *schema.TypeSystem {
+	return s.ts
+}
+
+// Type returns the type called name
+func (s *Schema) Type(name string) (schema.Type, error) {
+	t := s.ts.TypeByName(name)
+	if t == nil {
+		return nil, fmt.Errorf("schema type %q not found", name)
+	}
+	return t, nil
+}
+
+// ValidationError tells where data does not match a schema
+type ValidationError struct {
+	Path     datamodel.Path // from the validated node to the offending one
+	Type     string         // schema type that rejected the data
+	Msg      string
+	Expected datamodel.Kind // on a kind mismatch, the kind the schema wants; else Kind_Invalid
+	Actual   datamodel.Kind // on a kind mismatch, the kind found
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path.Len() == 0 {
+		return fmt.Sprintf("schema %s: %s", e.Type, e.Msg)
+	}
+	return fmt.Sprintf("schema %s at %q: %s", e.Type, e.Path.String(), e.Msg)
+}
+
+// TypedStore puts and gets Go values of type T as the schema type they
+// are bound to with bindnode
+type TypedStore[T any] struct {
+	ipld  *IpldWrapper
+	typ   schema.Type
+	proto schema.TypedPrototype
+}
+
+// Bind binds T to the schema type typeName. It fails if the fields of T
+// do not line up with the type.
+func Bind[T any](ipld *IpldWrapper, s *Schema, typeName string) (ts *TypedStore[T], err error) {
+	if ipld == nil || s == nil {
+		return nil, fmt.Errorf("bind %s: wrapper and schema are required", typeName)
+	}
+	typ, err := s.Type(typeName)
+	if err != nil {
+		return nil, err
+	}
+	// bindnode panics on Go types that do not match the schema
+	defer func() {
+		if r := recover(); r != nil {
+			ts, err = nil, fmt.Errorf("bind %T to %s: %v", (*T)(nil), typeName, r)
+		}
+	}()
+	return &TypedStore[T]{
+		ipld:  ipld,
+		typ:   typ,
+		proto: bindnode.Prototype((*T)(nil), typ),
+	}, nil
+}
+
+// Type returns the schema type T is bound to
+func (t *TypedStore[T]) Type() schema.Type {
+	return t.typ
+}
+
+// Put validates v against the schema and stores it
+func (t *TypedStore[T]) Put(ctx context.Context, v *T) (cid.Cid, error) {
+	n, err := t.Wrap(v)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return t.ipld.PutIPLD(ctx, n)
+}
+
+// Get loads c and checks it against the schema before binding it to T
+func (t *TypedStore[T]) Get(ctx context.Context, c cid.Cid) (*T, error) {
+	raw, err := t.ipld.GetIPLD(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return t.Unwrap(raw)
+}
+
+// Wrap returns the serial form of v, checked against the schema
+func (t *TypedStore[T]) Wrap(v *T) (n datamodel.Node, err error) {
+	if v == nil {
+		return nil, fmt.Errorf("put %s: nil value", t.typ.Name())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = nil, &ValidationError{Type: t.typ.Name(), Msg: fmt.Sprint(r)}
+		}
+	}()
+
+	n = bindnode.Wrap(v, t.typ).Representation()
+	if err := Validate(n, t.typ); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Unwrap checks raw, a node in serial form, against the schema and binds
+// it to T
+func (t *TypedStore[T]) Unwrap(raw datamodel.Node) (*T, error) {
+	if err := Validate(raw, t.typ); err != nil {
+		return nil, err
+	}
+
+	nb := t.proto.Representation().NewBuilder()
+	if err := datamodel.Copy(raw, nb); err != nil {
+		return nil, &ValidationError{Type: t.typ.Name(), Msg: err.Error()}
+	}
+	out, ok := bindnode.Unwrap(nb.Build()).(*T)
+	if !ok {
+		return nil, fmt.Errorf("unwrap %s: type assertion to %T failed", t.typ.Name(), (*T)(nil))
+	}
+	return out, nil
+}
+
+// Validate checks that n, in its serial form, matches t. Struct fields,
+// list items and map values are checked recursively; the first mismatch
+// is returned as a *ValidationError with its path. Struct and union
+// representations other than map, tuple, keyed and kinded are accepted
+// as they are.
+func Validate(n datamodel.Node, t schema.Type) error {
+	return validate(n, t, datamodel.Path{})
+}
+
+func validate(n datamodel.Node, t schema.Type, path datamodel.Path) error {
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Type: t.Name(), Msg: fmt.Sprintf(format, args...)}
+	}
+	if _, ok := t.(*schema.TypeAny); ok {
+		return nil
+	}
+	if n.IsNull() {
+		return &ValidationError{Path: path, Type: t.Name(), Msg: "null is not allowed", Expected: t.RepresentationBehavior(), Actual: datamodel.Kind_Null}
+	}
+	if n.IsAbsent() {
+		return fail("value is missing")
+	}
+	kindIs := func(want datamodel.Kind) error {
+		if n.Kind() != want {
+			return kindMismatch(path, t, want, n.Kind())
+		}
+		return nil
+	}
+
+	switch t := t.(type) {
+	case *schema.TypeBool, *schema.TypeString, *schema.TypeBytes, *schema.TypeInt, *schema.TypeFloat:
+		return kindIs(t.RepresentationBehavior())
+
+	case *schema.TypeLink:
+		if err := kindIs(datamodel.Kind_Link); err != nil {
+			return err
+		}
+		l, err := n.AsLink()
+		if err != nil {
+			return fail("%v", err)
+		}
+		if cl, ok := l.(cidlink.Link); ok && !cl.Cid.Defined() {
+			return fail("link is undefined")
+		}
+		return nil
+
+	case *schema.TypeEnum:
+		return validateEnum(n, t, path, fail)
+
+	case *schema.TypeList:
+		if err := kindIs(datamodel.Kind_List); err != nil {
+			return err
+		}
+		it := n.ListIterator()
+		for !it.Done() {
+			i, v, err := it.Next()
+			if err != nil {
+				return fail("%v", err)
+			}
+			if v.IsNull() && t.ValueIsNullable() {
+				continue
+			}
+			if err := validate(v, t.ValueType(), path.AppendSegment(datamodel.PathSegmentOfInt(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *schema.TypeMap:
+		if err := kindIs(datamodel.Kind_Map); err != nil {
+			return err
+		}
+		it := n.MapIterator()
+		for !it.Done() {
+			k, v, err := it.Next()
+			if err != nil {
+				return fail("%v", err)
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return fail("map keys must be strings")
+			}
+			if v.IsNull() && t.ValueIsNullable() {
+				continue
+			}
+			if err := validate(v, t.ValueType(), path.AppendSegment(datamodel.PathSegmentOfString(key))); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *schema.TypeStruct:
+		return validateStruct(n, t, path, fail, kindIs)
+
+	case *schema.TypeUnion:
+		switch repr := t.RepresentationStrategy().(type) {
+		case schema.UnionRepresentation_Keyed:
+			if err := kindIs(datamodel.Kind_Map); err != nil {
+				return err
+			}
+			if n.Length() != 1 {
+				return fail("keyed union needs exactly one key, got %d", n.Length())
+			}
+			k, v, err := n.MapIterator().Next()
+			if err != nil {
+				return fail("%v", err)
+			}
+			key, _ := k.AsString()
+			for _, member := range t.Members() {
+				if repr.GetDiscriminant(member) == key {
+					return validate(v, member, path.AppendSegment(datamodel.PathSegmentOfString(key)))
+				}
+			}
+			return fail("unknown union member %q", key)
+		case schema.UnionRepresentation_Kinded:
+			name := repr.GetMember(n.Kind())
+			if name == "" {
+				return &ValidationError{Path: path, Type: t.Name(), Msg: fmt.Sprintf("no union member is a %s", n.Kind()), Actual: n.Kind()}
+			}
+			return validate(n, t.TypeSystem().TypeByName(string(name)), path)
+		}
+	}
+	return nil
+}
+
+// kindMismatch reports n being of kind got where t wants kind want
+func kindMismatch(path datamodel.Path, t schema.Type, want, got datamodel.Kind) error {
+	return &ValidationError{
+		Path:     path,
+		Type:     t.Name(),
+		Msg:      fmt.Sprintf("expected %s, got %s", want, got),
+		Expected: want,
+		Actual:   got,
+	}
+}
+
+func validateEnum(n datamodel.Node, t *schema.TypeEnum, path datamodel.Path, fail func(string, ...any) error) error {
+	switch repr := t.RepresentationStrategy().(type) {
+	case schema.EnumRepresentation_Int:
+		if n.Kind() != datamodel.Kind_Int {
+			return kindMismatch(path, t, datamodel.Kind_Int, n.Kind())
+		}
+		v, err := n.AsInt()
+		if err != nil {
+			return fail("%v", err)
+		}
+		for _, member := range t.Members() {
+			if int64(repr[member]) == v {
+				return nil
+			}
+		}
+		return fail("%d is not a member", v)
+	default:
+		if n.Kind() != datamodel.Kind_String {
+			return kindMismatch(path, t, datamodel.Kind_String, n.Kind())
+		}
+		v, err := n.AsString()
+		if err != nil {
+			return fail("%v", err) // bindnode rejects Go values that are not members
+		}
+		serials, _ := repr.(schema.EnumRepresentation_String)
+		for _, member := range t.Members() {
+			serial, ok := serials[member]
+			if !ok {
+				serial = member
+			}
+			if serial == v {
+				return nil
+			}
+		}
+		return fail("%q is not a member", v)
+	}
+}
+
+func validateStruct(n datamodel.Node, t *schema.TypeStruct, path datamodel.Path, fail func(string, ...any) error, kindIs func(datamodel.Kind) error) error {
+	switch repr := t.RepresentationStrategy().(type) {
+	case schema.StructRepresentation_Map:
+		if err := kindIs(datamodel.Kind_Map); err != nil {
+			return err
+		}
+		known := make(map[string]bool, len(t.Fields()))
+		for _, f := range t.Fields() {
+			key := repr.GetFieldKey(f)
+			known[key] = true
+			fieldPath := path.AppendSegment(datamodel.PathSegmentOfString(key))
+			v, err := n.LookupByString(key)
+			if err != nil || v.IsAbsent() {
+				if f.IsOptional() || repr.FieldImplicit(f) != nil {
+					continue
+				}
+				return &ValidationError{Path: fieldPath, Type: t.Name(), Msg: "missing required field"}
+			}
+			if v.IsNull() && f.IsNullable() {
+				continue
+			}
+			if err := validate(v, f.Type(), fieldPath); err != nil {
+				return err
+			}
+		}
+		it := n.MapIterator()
+		for !it.Done() {
+			k, _, err := it.Next()
+			if err != nil {
+				return fail("%v", err)
+			}
+			if key, _ := k.AsString(); !known[key] {
+				return &ValidationError{Path: path.AppendSegment(datamodel.PathSegmentOfString(key)), Type: t.Name(), Msg: "unknown field"}
+			}
+		}
+		return nil
+
+	case schema.StructRepresentation_Tuple:
+		if err := kindIs(datamodel.Kind_List); err != nil {
+			return err
+		}
+		fields := t.Fields()
+		if n.Length() > int64(len(fields)) {
+			return fail("tuple has %d items, type has %d fields", n.Length(), len(fields))
+		}
+		for i, f := range fields {
+			fieldPath := path.AppendSegment(datamodel.PathSegmentOfInt(int64(i)))
+			v, err := n.LookupByIndex(int64(i))
+			if err != nil {
+				if f.IsOptional() {
+					continue
+				}
+				return &ValidationError{Path: fieldPath, Type: t.Name(), Msg: fmt.Sprintf("missing required field %s", f.Name())}
+			}
+			if v.IsNull() && f.IsNullable() {
+				continue
+			}
+			if err := validate(v, f.Type(), fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
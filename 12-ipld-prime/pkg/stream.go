@@ -0,0 +1,203 @@
+package ipldprime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mc "github.com/multiformats/go-multicodec"
+)
+
+//-------------------------------------------------------------------------------//
+// Streaming encode/decode
+//-------------------------------------------------------------------------------//
+
+// DefaultMaxNodeSize caps the serial form of one node, the largest block
+// most IPFS implementations exchange
+const DefaultMaxNodeSize = 2 << 20
+
+// ErrNodeTooLarge is returned for nodes whose serial form exceeds MaxSize
+var ErrNodeTooLarge = errors.New("node too large")
+
+// StreamOptions tunes PutIPLDStream and GetIPLDStream; zero values keep
+// the defaults
+type StreamOptions struct {
+	Codec   mc.Code // serial form read or written (default dag-json)
+	MaxSize int64   // bytes of one serial form (default DefaultMaxNodeSize)
+}
+
+func (o StreamOptions) withDefaults() StreamOptions {
+	if o.Codec == 0 {
+		o.Codec = mc.DagJson
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = DefaultMaxNodeSize
+	}
+	return o
+}
+
+// PutIPLDStream decodes one node in the serial form opts.Codec from r and
+// stores it with the wrapper's prefix. Unlike PutIPLDAny the data goes
+// straight into an IPLD node, without a Go value or a buffered copy in
+// between, and the codec output is fed to storage and hasher as it is
+// written. Input or encoded blocks larger than opts.MaxSize fail with
+// ErrNodeTooLarge before they are stored.
+func (d *IpldWrapper) PutIPLDStream(ctx context.Context, r io.Reader, opts StreamOptions) (cid.Cid, error) {
+	opts = opts.withDefaults()
+	decode, err := multicodec.LookupDecoder(uint64(opts.Codec))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("put stream: %w", err)
+	}
+
+	in := &limitedReader{r: r, left: opts.MaxSize}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decode(nb, in); err != nil {
+		if in.exceeded {
+			return cid.Undef, fmt.Errorf("put stream: input over %d bytes: %w", opts.MaxSize, ErrNodeTooLarge)
+		}
+		return cid.Undef, fmt.Errorf("put stream: decode %s: %w", opts.Codec, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return cid.Undef, err
+	}
+
+	// Same link system, with the encoder output capped
+	var out *limitedWriter
+	lsys := d.LinkSystem
+	lsys.EncoderChooser = func(lp datamodel.LinkPrototype) (codec.Encoder, error) {
+		encode, err := d.LinkSystem.EncoderChooser(lp)
+		if err != nil {
+			return nil, err
+		}
+		return func(n datamodel.Node, w io.Writer) error {
+			out = &limitedWriter{w: w, left: opts.MaxSize}
+			return encode(n, out)
+		}, nil
+	}
+	lnk, err := lsys.Store(linking.LinkContext{Ctx: ctx}, cidlink.LinkPrototype{Prefix: *d.Prefix}, nb.Build())
+	if err != nil {
+		if out != nil && out.exceeded {
+			return cid.Undef, fmt.Errorf("put stream: block over %d bytes: %w", opts.MaxSize, ErrNodeTooLarge)
+		}
+		return cid.Undef, fmt.Errorf("put stream: %w", err)
+	}
+	return lnk.(cidlink.Link).Cid, nil
+}
+
+// GetIPLDStream loads c and writes it to w in the serial form opts.Codec.
+// The block is hashed while it is decoded and only written once it
+// matches c; it is encoded straight into w, so no second copy is built.
+// Stored blocks larger than opts.MaxSize fail with ErrNodeTooLarge.
+func (d *IpldWrapper) GetIPLDStream(ctx context.Context, c cid.Cid, w io.Writer, opts StreamOptions) error {
+	opts = opts.withDefaults()
+	encode, err := multicodec.LookupEncoder(uint64(opts.Codec))
+	if err != nil {
+		return fmt.Errorf("get stream: %w", err)
+	}
+
+	lnk := cidlink.Link{Cid: c}
+	lsys := d.LinkSystem
+	decode, err := lsys.DecoderChooser(lnk)
+	if err != nil {
+		return fmt.Errorf("get stream %s: %w", c, err)
+	}
+	hasher, err := lsys.HasherChooser(lnk.Prototype())
+	if err != nil {
+		return fmt.Errorf("get stream %s: %w", c, err)
+	}
+	if lsys.StorageReadOpener == nil {
+		return fmt.Errorf("get stream %s: no storage configured for reading", c)
+	}
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	r, err := lsys.StorageReadOpener(lnkCtx, lnk)
+	if err != nil {
+		return err
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	in := &limitedReader{r: io.TeeReader(r, hasher), left: opts.MaxSize}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decode(nb, in); err != nil {
+		if in.exceeded {
+			return fmt.Errorf("get stream %s: block over %d bytes: %w", c, opts.MaxSize, ErrNodeTooLarge)
+		}
+		return fmt.Errorf("get stream %s: decode: %w", c, err)
+	}
+	// The decoder may stop before the end; the hash covers the whole block
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		if in.exceeded {
+			return fmt.Errorf("get stream %s: block over %d bytes: %w", c, opts.MaxSize, ErrNodeTooLarge)
+		}
+		return err
+	}
+	if actual := lnk.Prototype().BuildLink(hasher.Sum(nil)); actual.Binary() != lnk.Binary() {
+		return linking.ErrHashMismatch{Actual: actual, Expected: lnk}
+	}
+
+	n := nb.Build()
+	if lsys.NodeReifier != nil {
+		if n, err = lsys.NodeReifier(lnkCtx, n, &lsys); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := encode(n, w); err != nil {
+		return fmt.Errorf("get stream %s: encode %s: %w", c, opts.Codec, err)
+	}
+	return nil
+}
+
+// limitedReader reads up to left bytes and fails after that, where
+// io.LimitReader would end the data early and let a truncated node decode
+type limitedReader struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		// one byte tells a reader at the limit from one over it
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			l.exceeded = true
+			return 0, ErrNodeTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
+}
+
+// limitedWriter fails writes past left bytes
+type limitedWriter struct {
+	w        io.Writer
+	left     int64
+	exceeded bool
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.left {
+		l.exceeded = true
+		return 0, ErrNodeTooLarge
+	}
+	l.left -= int64(len(p))
+	return l.w.Write(p)
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/ipld/go-ipld-prime/datamodel"
 	mc "github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
 	dasl "github.com/gosuda/boxo-starter-kit/13-dasl/pkg"
 )
 
@@ -67,3 +72,376 @@ func TestDaslWrapperPutGet(t *testing.T) {
 	require.Equal(t, uint64(mc.DagCbor), u1Cid.Prefix().Codec)
 	require.Equal(t, uint64(mc.DagCbor), p1Cid.Prefix().Codec)
 }
+
+func TestGenerateGo(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Built-in Schema Up To Date", func(t *testing.T) {
+		src, err := os.ReadFile("pkg/codegen/schema.dasl")
+		require.NoError(t, err)
+		want, err := os.ReadFile("pkg/schema_gen.go")
+		require.NoError(t, err)
+		got, err := dasl.GenerateGo(string(src), "dasl", "DaslWrapper")
+		require.NoError(t, err)
+		require.Equal(t, string(want), string(got), "run go generate ./13-dasl/pkg")
+	})
+
+	t.Run("Own Schema", func(t *testing.T) {
+		code, err := dasl.GenerateGo(`
+type Status enum {
+  | Draft ("d")
+  | Published ("p")
+} representation string
+
+type Media union {
+  | Image "image"
+  | String "text"
+} representation keyed
+
+type Image struct {
+  url String
+}
+
+type Article struct {
+  title  String
+  status Status
+  media  Media
+  meta   {String:Int}
+  note   optional String
+  prev   nullable &Article
+}`, "blog", "BlogStore")
+		require.NoError(t, err)
+		src := string(code)
+		for _, want := range []string{
+			"package blog",
+			"type BlogStore struct {\n\t*dasl.DaslWrapper\n}",
+			"func NewBlogStore(ipld *ipldprime.IpldWrapper) (*BlogStore, error)",
+			"StatusPublished Status = \"Published\"",
+			"Note *string",
+			"Prev *cid.Cid",
+			"Values map[string]int64",
+			"func (w *BlogStore) PutArticle(ctx context.Context, v *Article) (cid.Cid, error)",
+			"func (w *BlogStore) GetMedia(ctx context.Context, c cid.Cid) (*Media, error)",
+		} {
+			assert.Contains(t, src, want)
+		}
+		assert.NotContains(t, src, "PutStatus")
+	})
+
+	t.Run("Invalid Schema", func(t *testing.T) {
+		_, err := dasl.GenerateGo(`type A struct { b Missing }`, "x", "X")
+		assert.Error(t, err)
+	})
+}
+
+func TestSchemaMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	const v1 = `
+type Profile struct {
+  id       String
+  fullName String
+}`
+	const v2 = `
+type Profile struct {
+  id     String
+  name   String
+  active Bool
+  bio    optional String
+}`
+	type ProfileV1 struct {
+		Id       string
+		FullName string
+	}
+	type Profile struct {
+		Id     string
+		Name   string
+		Active bool
+		Bio    *string
+	}
+
+	ipld, err := ipldprime.NewDefault(nil, nil)
+	require.NoError(t, err)
+
+	old, err := dasl.NewWithSchema(ipld, v1)
+	require.NoError(t, err)
+	oldCid, err := dasl.Put(ctx, old, "Profile", &ProfileV1{Id: "p1", FullName: "Trinity"})
+	require.NoError(t, err)
+
+	w, err := dasl.NewVersioned(ipld,
+		dasl.SchemaVersion{Version: 2, DSL: v2},
+		dasl.SchemaVersion{Version: 1, DSL: v1},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, w.Version())
+
+	t.Run("Missing Migration", func(t *testing.T) {
+		fresh, err := dasl.NewVersioned(ipld, dasl.SchemaVersion{Version: 1, DSL: v1}, dasl.SchemaVersion{Version: 2, DSL: v2})
+		require.NoError(t, err)
+		_, err = dasl.Get[Profile](ctx, fresh, "Profile", oldCid)
+		var verr *ipldprime.ValidationError
+		assert.ErrorAs(t, err, &verr)
+	})
+
+	require.NoError(t, w.RegisterMigration("Profile", 1, func(n datamodel.Node) (datamodel.Node, error) {
+		n, err := dasl.RenameFields(map[string]string{"fullName": "name"})(n)
+		if err != nil {
+			return nil, err
+		}
+		return dasl.SetDefaults(map[string]any{"active": true})(n)
+	}))
+	assert.Error(t, w.RegisterMigration("Profile", 2, dasl.RenameFields(nil)))
+
+	t.Run("Read Untagged V1", func(t *testing.T) {
+		got, err := dasl.Get[Profile](ctx, w, "Profile", oldCid)
+		require.NoError(t, err)
+		assert.Equal(t, "p1", got.Id)
+		assert.Equal(t, "Trinity", got.Name)
+		assert.True(t, got.Active)
+		assert.Nil(t, got.Bio)
+	})
+
+	t.Run("Read Tagged V1", func(t *testing.T) {
+		tagged, err := ipldprime.MapToNode(map[string]any{
+			"version": 1,
+			"data":    map[string]any{"id": "p2", "fullName": "Morpheus"},
+		})
+		require.NoError(t, err)
+		c, err := ipld.PutIPLD(ctx, tagged)
+		require.NoError(t, err)
+
+		got, err := dasl.Get[Profile](ctx, w, "Profile", c)
+		require.NoError(t, err)
+		assert.Equal(t, "Morpheus", got.Name)
+	})
+
+	t.Run("Put Is Tagged", func(t *testing.T) {
+		bio := "operator"
+		c, err := dasl.Put(ctx, w, "Profile", &Profile{Id: "p3", Name: "Tank", Bio: &bio})
+		require.NoError(t, err)
+
+		raw, err := ipld.GetIPLD(ctx, c)
+		require.NoError(t, err)
+		version, err := raw.LookupByString("version")
+		require.NoError(t, err)
+		v, err := version.AsInt()
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), v)
+
+		got, err := dasl.Get[Profile](ctx, w, "Profile", c)
+		require.NoError(t, err)
+		require.NotNil(t, got.Bio)
+		assert.Equal(t, "operator", *got.Bio)
+
+		same, err := w.Upgrade(ctx, "Profile", c)
+		require.NoError(t, err)
+		assert.Equal(t, c, same)
+	})
+
+	t.Run("Upgrade", func(t *testing.T) {
+		c, err := w.Upgrade(ctx, "Profile", oldCid)
+		require.NoError(t, err)
+		assert.NotEqual(t, oldCid, c)
+
+		got, err := dasl.Get[Profile](ctx, w, "Profile", c)
+		require.NoError(t, err)
+		assert.Equal(t, "Trinity", got.Name)
+	})
+
+	t.Run("Newer Than Schema", func(t *testing.T) {
+		tagged, err := ipldprime.MapToNode(map[string]any{
+			"version": 3,
+			"data":    map[string]any{"id": "p4"},
+		})
+		require.NoError(t, err)
+		c, err := ipld.PutIPLD(ctx, tagged)
+		require.NoError(t, err)
+
+		_, err = dasl.Get[Profile](ctx, w, "Profile", c)
+		assert.ErrorContains(t, err, "newer than the schema")
+	})
+
+	t.Run("Invalid V1 Data", func(t *testing.T) {
+		bad, err := ipldprime.MapToNode(map[string]any{"id": "p5"})
+		require.NoError(t, err)
+		c, err := ipld.PutIPLD(ctx, bad)
+		require.NoError(t, err)
+
+		_, err = dasl.Get[Profile](ctx, w, "Profile", c)
+		assert.ErrorContains(t, err, "from v1")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	ipld, err := ipldprime.NewDefault(nil, nil)
+	require.NoError(t, err)
+	w, err := dasl.NewDaslWrapper(ipld)
+	require.NoError(t, err)
+
+	u := dasl.User{Id: "u1", Name: "Neo", Email: "neo@matrix.io", Avatar: []byte{1}}
+	c, err := w.PutUser(ctx, &u)
+	require.NoError(t, err)
+	require.NoError(t, w.Validate(ctx, c, "User"))
+
+	for _, tc := range []struct {
+		name     string
+		data     map[string]any
+		path     string
+		expected datamodel.Kind
+		actual   datamodel.Kind
+	}{
+		{
+			name:     "Wrong Kind",
+			data:     map[string]any{"id": "u2", "name": 7, "email": "x", "friends": []any{}, "avatar": []byte{}},
+			path:     "name",
+			expected: datamodel.Kind_String,
+			actual:   datamodel.Kind_Int,
+		},
+		{
+			name:     "Wrong List Item",
+			data:     map[string]any{"id": "u2", "name": "x", "email": "x", "friends": []any{"not a link"}, "avatar": []byte{}},
+			path:     "friends/0",
+			expected: datamodel.Kind_Link,
+			actual:   datamodel.Kind_String,
+		},
+		{
+			name:     "Missing Field",
+			data:     map[string]any{"id": "u2", "name": "x", "friends": []any{}, "avatar": []byte{}},
+			path:     "email",
+			expected: datamodel.Kind_Invalid,
+			actual:   datamodel.Kind_Invalid,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bad, err := ipld.PutIPLDAny(ctx, tc.data)
+			require.NoError(t, err)
+
+			err = w.Validate(ctx, bad, "User")
+			var verr *ipldprime.ValidationError
+			require.ErrorAs(t, err, &verr)
+			assert.Equal(t, tc.path, verr.Path.String())
+			assert.Equal(t, tc.expected, verr.Expected)
+			assert.Equal(t, tc.actual, verr.Actual)
+		})
+	}
+
+	t.Run("Unknown Type", func(t *testing.T) {
+		assert.Error(t, w.Validate(ctx, c, "Missing"))
+	})
+
+	t.Run("Node Before Store", func(t *testing.T) {
+		n, err := ipldprime.MapToNode(map[string]any{"id": "u3"})
+		require.NoError(t, err)
+		var verr *ipldprime.ValidationError
+		require.ErrorAs(t, w.ValidateNode(n, "User"), &verr)
+		assert.Equal(t, "User", verr.Type)
+	})
+}
+
+func TestCollection(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	w, err := dasl.NewDaslWrapper(nil)
+	require.NoError(t, err)
+
+	alice, err := w.PutUser(ctx, &dasl.User{Id: "alice", Name: "Alice", Email: "a@x.io", Avatar: []byte{}})
+	require.NoError(t, err)
+	bob, err := w.PutUser(ctx, &dasl.User{Id: "bob", Name: "Bob", Email: "b@x.io", Avatar: []byte{}})
+	require.NoError(t, err)
+
+	byAuthor := dasl.Index[dasl.Post]{Name: "byAuthor", Keys: func(p *dasl.Post) []string {
+		return []string{p.Author.String()}
+	}}
+	byTag := dasl.Index[dasl.Post]{Name: "byTag", Keys: func(p *dasl.Post) []string { return p.Tags }}
+	newPosts := func() *dasl.Collection[dasl.Post] {
+		posts, err := dasl.NewCollection(w, "Post", func(p *dasl.Post) string { return p.Id }, byAuthor, byTag)
+		require.NoError(t, err)
+		return posts
+	}
+	posts := newPosts()
+
+	for i := range 30 {
+		author := alice
+		if i%3 == 0 {
+			author = bob
+		}
+		_, err := posts.Put(ctx, &dasl.Post{
+			Id:     fmt.Sprintf("p%02d", i),
+			Author: author,
+			Title:  fmt.Sprintf("post %d", i),
+			Tags:   []string{fmt.Sprintf("t%d", i%2)},
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		p, err := posts.Get(ctx, "p07")
+		require.NoError(t, err)
+		assert.Equal(t, "post 7", p.Title)
+
+		_, err = posts.Get(ctx, "missing")
+		assert.ErrorIs(t, err, dasl.ErrKeyNotFound)
+
+		n, err := posts.Len(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), n)
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		found, err := posts.Find(ctx, "byAuthor", bob.String())
+		require.NoError(t, err)
+		require.Len(t, found, 10)
+		for _, p := range found {
+			assert.Equal(t, bob, p.Author)
+		}
+		assert.Equal(t, "p00", found[0].Id)
+
+		_, err = posts.Find(ctx, "byTitle", "x")
+		assert.Error(t, err)
+	})
+
+	t.Run("Replace And Delete", func(t *testing.T) {
+		p, err := posts.Get(ctx, "p03")
+		require.NoError(t, err)
+		p.Author = alice
+		_, err = posts.Put(ctx, p)
+		require.NoError(t, err)
+
+		found, err := posts.Find(ctx, "byAuthor", bob.String())
+		require.NoError(t, err)
+		assert.Len(t, found, 9)
+
+		require.NoError(t, posts.Delete(ctx, "p00"))
+		assert.ErrorIs(t, posts.Delete(ctx, "p00"), dasl.ErrKeyNotFound)
+		found, err = posts.Find(ctx, "byAuthor", bob.String())
+		require.NoError(t, err)
+		assert.Len(t, found, 8)
+
+		found, err = posts.Find(ctx, "byTag", "t0")
+		require.NoError(t, err)
+		assert.Len(t, found, 14)
+	})
+
+	t.Run("Iterate And Load", func(t *testing.T) {
+		reopened := newPosts()
+		require.NoError(t, reopened.Load(ctx, posts.Root()))
+
+		seen := make(map[string]bool)
+		require.NoError(t, reopened.Iterate(ctx, func(key string, p *dasl.Post) error {
+			assert.Equal(t, key, p.Id)
+			seen[key] = true
+			return nil
+		}))
+		assert.Len(t, seen, 29)
+		assert.False(t, seen["p00"])
+
+		users, err := dasl.NewCollection(w, "User", func(u *dasl.User) string { return u.Id })
+		require.NoError(t, err)
+		assert.Error(t, users.Load(ctx, posts.Root()))
+	})
+}
@@ -0,0 +1,257 @@
+package dasl
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/format"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ipld/go-ipld-prime/schema"
+	schemadmt "github.com/ipld/go-ipld-prime/schema/dmt"
+	schemadsl "github.com/ipld/go-ipld-prime/schema/dsl"
+)
+
+const (
+	daslImport      = "github.com/gosuda/boxo-starter-kit/13-dasl/pkg"
+	ipldprimeImport = "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
+)
+
+type generator struct {
+	ts       *schema.TypeSystem
+	declared map[string]bool
+	imports  map[string]string // path -> name
+	inDasl   bool
+}
+
+// GenerateGo returns Go source for the IPLD schema dsl: a struct for
+// every struct and union type, a named type for every other type, and
+// Put and Get methods on wrapper for the structs and unions. Outside
+// package dasl, wrapper is declared too, embedding *DaslWrapper.
+func GenerateGo(dsl, pkg, wrapper string) ([]byte, error) {
+	file, err := schemadsl.ParseBytes([]byte(dsl))
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	ts := schema.TypeSystem{}
+	ts.Init()
+	if err := schemadmt.Compile(&ts, file); err != nil {
+		return nil, fmt.Errorf("compile: %w", err)
+	}
+
+	g := &generator{
+		ts:       &ts,
+		declared: make(map[string]bool, len(file.Types.Keys)),
+		imports:  map[string]string{"context": "", "github.com/ipfs/go-cid": ""},
+		inDasl:   pkg == "dasl",
+	}
+	for _, name := range file.Types.Keys {
+		g.declared[name] = true
+	}
+
+	var body bytes.Buffer
+	schemaVar := "schemaDSL"
+	if g.inDasl {
+		schemaVar = "schemaDasl"
+	}
+	fmt.Fprintf(&body, "// %s is the schema the types below are generated from\nconst %s = %s\n\n", schemaVar, schemaVar, quote(dsl))
+	if !g.inDasl {
+		g.imports[daslImport] = "dasl"
+		g.imports[ipldprimeImport] = "ipldprime"
+		fmt.Fprintf(&body, "// %s stores the types of the schema\ntype %s struct {\n\t*dasl.DaslWrapper\n}\n\n", wrapper, wrapper)
+		fmt.Fprintf(&body, "// New%s binds the schema to ipld, or to an in-memory store if ipld is nil\n", wrapper)
+		fmt.Fprintf(&body, "func New%s(ipld *ipldprime.IpldWrapper) (*%s, error) {\n", wrapper, wrapper)
+		fmt.Fprintf(&body, "\tw, err := dasl.NewWithSchema(ipld, %s)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n\treturn &%s{w}, nil\n}\n\n", schemaVar, wrapper)
+	}
+
+	for _, name := range file.Types.Keys {
+		t := ts.TypeByName(name)
+		if err := g.typeDecl(&body, t); err != nil {
+			return nil, fmt.Errorf("type %s: %w", name, err)
+		}
+		switch t.(type) {
+		case *schema.TypeStruct, *schema.TypeUnion:
+			g.accessors(&body, name, wrapper)
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by codegen from an IPLD schema. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	// grouped as in the rest of the repo: standard library, others, ours
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return cmp.Or(cmp.Compare(importGroup(a), importGroup(b)), strings.Compare(a, b))
+	})
+	for i, path := range paths {
+		if i > 0 && importGroup(paths[i-1]) != importGroup(path) {
+			b.WriteString("\n")
+		}
+		if name := g.imports[path]; name != "" {
+			fmt.Fprintf(&b, "\t%s %q\n", name, path)
+		} else {
+			fmt.Fprintf(&b, "\t%q\n", path)
+		}
+	}
+	b.WriteString(")\n\n")
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+// typeDecl writes the Go declaration of the named schema type t
+func (g *generator) typeDecl(b *bytes.Buffer, t schema.Type) error {
+	name := goName(t.Name())
+	switch t := t.(type) {
+	case *schema.TypeStruct:
+		fmt.Fprintf(b, "type %s struct {\n", name)
+		for _, f := range t.Fields() {
+			typ, err := g.goType(f.Type(), f.IsMaybe())
+			if err != nil {
+				return fmt.Errorf("field %s: %w", f.Name(), err)
+			}
+			fmt.Fprintf(b, "\t%s %s\n", goName(f.Name()), typ)
+		}
+		b.WriteString("}\n\n")
+
+	case *schema.TypeUnion:
+		// bindnode fills exactly one of the pointers
+		fmt.Fprintf(b, "type %s struct {\n", name)
+		for _, m := range t.Members() {
+			typ, err := g.goType(m, true)
+			if err != nil {
+				return fmt.Errorf("member %s: %w", m.Name(), err)
+			}
+			fmt.Fprintf(b, "\t%s %s\n", goName(m.Name()), typ)
+		}
+		b.WriteString("}\n\n")
+
+	case *schema.TypeEnum:
+		fmt.Fprintf(b, "type %s string\n\nconst (\n", name)
+		for _, m := range t.Members() {
+			fmt.Fprintf(b, "\t%s%s %s = %q\n", name, goName(m), name, m)
+		}
+		b.WriteString(")\n\n")
+
+	case *schema.TypeLink:
+		// every link is a cid.Cid, whatever it points to
+		fmt.Fprintf(b, "type %s = cid.Cid\n\n", name)
+
+	default:
+		typ, err := g.inline(t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "type %s %s\n\n", name, typ)
+	}
+	return nil
+}
+
+// goType is the Go type of a field or member of type t; maybe types are
+// pointers, as bindnode wants them
+func (g *generator) goType(t schema.Type, maybe bool) (string, error) {
+	typ := goName(t.Name())
+	if !g.declared[t.Name()] {
+		var err error
+		if typ, err = g.inline(t); err != nil {
+			return "", err
+		}
+	}
+	if maybe && typ != "datamodel.Node" {
+		typ = "*" + typ
+	}
+	return typ, nil
+}
+
+// inline spells out a type the schema did not name: a prelude type or an
+// inline list, map or link
+func (g *generator) inline(t schema.Type) (string, error) {
+	switch t := t.(type) {
+	case *schema.TypeBool:
+		return "bool", nil
+	case *schema.TypeString:
+		return "string", nil
+	case *schema.TypeBytes:
+		return "[]byte", nil
+	case *schema.TypeInt:
+		return "int64", nil
+	case *schema.TypeFloat:
+		return "float64", nil
+	case *schema.TypeLink:
+		return "cid.Cid", nil
+	case *schema.TypeAny:
+		g.imports["github.com/ipld/go-ipld-prime/datamodel"] = ""
+		return "datamodel.Node", nil
+	case *schema.TypeList:
+		v, err := g.goType(t.ValueType(), t.ValueIsNullable())
+		if err != nil {
+			return "", err
+		}
+		return "[]" + v, nil
+	case *schema.TypeMap:
+		k, err := g.goType(t.KeyType(), false)
+		if err != nil {
+			return "", err
+		}
+		v, err := g.goType(t.ValueType(), t.ValueIsNullable())
+		if err != nil {
+			return "", err
+		}
+		// the form bindnode binds maps to, keeping the key order
+		return fmt.Sprintf("struct {\n\tKeys []%s\n\tValues map[%s]%s\n}", k, k, v), nil
+	}
+	return "", fmt.Errorf("unsupported type %s (%T)", t.Name(), t)
+}
+
+// accessors writes the Put and Get methods of the named type
+func (g *generator) accessors(b *bytes.Buffer, typeName, wrapper string) {
+	name := goName(typeName)
+	qual, recv := "", "w"
+	if !g.inDasl {
+		qual, recv = "dasl.", "w.DaslWrapper"
+	}
+	fmt.Fprintf(b, "// Put%s validates v against the schema and stores it\n", name)
+	fmt.Fprintf(b, "func (w *%s) Put%s(ctx context.Context, v *%s) (cid.Cid, error) {\n\treturn %sPut(ctx, %s, %q, v)\n}\n\n", wrapper, name, name, qual, recv, typeName)
+	fmt.Fprintf(b, "// Get%s loads c and checks it against the schema\n", name)
+	fmt.Fprintf(b, "func (w *%s) Get%s(ctx context.Context, c cid.Cid) (*%s, error) {\n\treturn %sGet[%s](ctx, %s, %q, c)\n}\n\n", wrapper, name, name, qual, name, recv, typeName)
+}
+
+// goName exports a schema name: createdAt becomes CreatedAt
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// quote writes s as a raw string literal where it can
+func quote(s string) string {
+	if strings.Contains(s, "`") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+func importGroup(path string) int {
+	switch first, _, _ := strings.Cut(path, "/"); {
+	case !strings.Contains(first, "."):
+		return 0
+	case strings.HasPrefix(path, "github.com/gosuda/"):
+		return 2
+	}
+	return 1
+}
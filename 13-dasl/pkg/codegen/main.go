@@ -1,27 +1,45 @@
+// Command codegen turns an IPLD schema into Go structs that bindnode can
+// bind, plus Put and Get methods for every struct and union type. It is
+// meant for go:generate:
+//
+//	//go:generate go run github.com/gosuda/boxo-starter-kit/13-dasl/pkg/codegen -schema blog.ipldsch -out blog_gen.go -wrapper BlogStore
+//
+// Inside package dasl the methods go on DaslWrapper. Anywhere else the
+// generator also emits the wrapper type, embedding *dasl.DaslWrapper, and
+// a constructor for it.
 package main
 
 import (
-	"github.com/ipld/go-ipld-prime/schema"
-	schemadmt "github.com/ipld/go-ipld-prime/schema/dmt"
-	schemadsl "github.com/ipld/go-ipld-prime/schema/dsl"
-	gengo "github.com/ipld/go-ipld-prime/schema/gen/go"
+	"flag"
+	"log"
+	"os"
+
+	dasl "github.com/gosuda/boxo-starter-kit/13-dasl/pkg"
 )
 
 func main() {
-	file, err := schemadsl.ParseFile("schema.dasl")
-	if err != nil {
-		panic(err)
+	schemaPath := flag.String("schema", "schema.ipldsch", "IPLD schema file to read")
+	out := flag.String("out", "", "Go file to write (default stdout)")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated file (default $GOPACKAGE)")
+	wrapper := flag.String("wrapper", "DaslWrapper", "type that gets the Put and Get methods")
+	flag.Parse()
+	if *pkg == "" {
+		log.Fatal("codegen: -pkg is required outside go:generate")
 	}
 
-	ts := schema.TypeSystem{}
-	ts.Init()
-	err = schemadmt.Compile(&ts, file)
+	src, err := os.ReadFile(*schemaPath)
 	if err != nil {
-		panic(err)
+		log.Fatalf("codegen: %v", err)
+	}
+	code, err := dasl.GenerateGo(string(src), *pkg, *wrapper)
+	if err != nil {
+		log.Fatalf("codegen %s: %v", *schemaPath, err)
+	}
+	if *out == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		log.Fatalf("codegen: %v", err)
 	}
-
-	// It is not working with the latest version.
-	// https://github.com/ipld/go-ipld-prime/issues/528
-	// TODO: Update the code to work.
-	gengo.Generate("./", "main", ts, &gengo.AdjunctCfg{})
 }
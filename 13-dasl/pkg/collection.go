@@ -0,0 +1,418 @@
+package dasl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+
+	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
+)
+
+// ErrKeyNotFound is returned for a key a collection does not hold
+var ErrKeyNotFound = errors.New("key not found")
+
+// Index derives the keys a value is found under in a secondary index,
+// e.g. the author of a post
+type Index[T any] struct {
+	Name string
+	Keys func(v *T) []string
+}
+
+// Collection keeps values of one schema type in a HAMT keyed by a string
+// taken from each value. A secondary index maps each of its keys to the
+// primary keys of the values that have it, so "all posts by author" reads
+// one index entry instead of every post.
+//
+// The state of a collection is one manifest block:
+//
+//	{"type": "Post", "items": &hamt, "indexes": {"byAuthor": &hamt}}
+//
+// Every change stores a new manifest, whose CID Root returns. The HAMTs
+// are rebuilt on each change, so a collection suits thousands of values,
+// not millions.
+type Collection[T any] struct {
+	w        *DaslWrapper
+	typeName string
+	key      func(*T) string
+	indexes  []Index[T]
+
+	mu         sync.Mutex
+	root       cid.Cid
+	items      cid.Cid
+	indexRoots map[string]cid.Cid
+}
+
+// NewCollection returns an empty collection of typeName values keyed by
+// key. Load switches it to a stored state.
+func NewCollection[T any](w *DaslWrapper, typeName string, key func(*T) string, indexes ...Index[T]) (*Collection[T], error) {
+	if key == nil {
+		return nil, fmt.Errorf("collection %s: key func is required", typeName)
+	}
+	if _, err := typedStore[T](w, typeName); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(indexes))
+	for _, ix := range indexes {
+		if ix.Name == "" || ix.Keys == nil {
+			return nil, fmt.Errorf("collection %s: index needs a name and a keys func", typeName)
+		}
+		if seen[ix.Name] {
+			return nil, fmt.Errorf("collection %s: index %q given twice", typeName, ix.Name)
+		}
+		seen[ix.Name] = true
+	}
+	return &Collection[T]{
+		w:          w,
+		typeName:   typeName,
+		key:        key,
+		indexes:    indexes,
+		indexRoots: make(map[string]cid.Cid),
+	}, nil
+}
+
+// Root returns the CID of the manifest, or cid.Undef while the
+// collection has never been changed
+func (c *Collection[T]) Root() cid.Cid {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.root
+}
+
+// Load switches the collection to the manifest under root. The manifest
+// must hold values of the collection's type and every configured index.
+func (c *Collection[T]) Load(ctx context.Context, root cid.Cid) error {
+	n, err := c.w.ipld.GetIPLD(ctx, root)
+	if err != nil {
+		return fmt.Errorf("load collection %s: %w", root, err)
+	}
+	typeName, err := lookupString(n, "type")
+	if err != nil {
+		return fmt.Errorf("load collection %s: %w", root, err)
+	}
+	if typeName != c.typeName {
+		return fmt.Errorf("load collection %s: holds %s, want %s", root, typeName, c.typeName)
+	}
+	items, err := lookupLink(n, "items")
+	if err != nil {
+		return fmt.Errorf("load collection %s: %w", root, err)
+	}
+	indexes, err := n.LookupByString("indexes")
+	if err != nil {
+		return fmt.Errorf("load collection %s: %w", root, err)
+	}
+	indexRoots := make(map[string]cid.Cid, len(c.indexes))
+	for _, ix := range c.indexes {
+		l, err := lookupLink(indexes, ix.Name)
+		if err != nil {
+			return fmt.Errorf("load collection %s: index %q: %w", root, ix.Name, err)
+		}
+		indexRoots[ix.Name] = l
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.root, c.items, c.indexRoots = root, items, indexRoots
+	return nil
+}
+
+// Put stores v, files it under its key, replacing any value there, and
+// updates the indexes. It returns the CID of v.
+func (c *Collection[T]) Put(ctx context.Context, v *T) (cid.Cid, error) {
+	if v == nil {
+		return cid.Undef, fmt.Errorf("collection %s: nil value", c.typeName)
+	}
+	key := c.key(v)
+	if key == "" {
+		return cid.Undef, fmt.Errorf("collection %s: empty key", c.typeName)
+	}
+	vc, err := Put(ctx, c.w, c.typeName, v)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, err := c.getLocked(ctx, key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return cid.Undef, err
+	}
+	items, err := c.w.ipld.UpdateHAMT(ctx, c.items, map[string]any{key: vc}, nil)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("collection %s: %w", c.typeName, err)
+	}
+	if err := c.commit(ctx, items, key, old, v); err != nil {
+		return cid.Undef, err
+	}
+	return vc, nil
+}
+
+// Get returns the value under key, or ErrKeyNotFound
+func (c *Collection[T]) Get(ctx context.Context, key string) (*T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLocked(ctx, key)
+}
+
+// Delete removes the value under key from the collection and its
+// indexes. The value itself stays in the blockstore.
+func (c *Collection[T]) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, err := c.getLocked(ctx, key)
+	if err != nil {
+		return err
+	}
+	items, err := c.w.ipld.UpdateHAMT(ctx, c.items, nil, []string{key})
+	if err != nil {
+		return fmt.Errorf("collection %s: %w", c.typeName, err)
+	}
+	return c.commit(ctx, items, key, old, nil)
+}
+
+// Iterate calls fn for every value in HAMT order, stopping at the first
+// error fn returns
+func (c *Collection[T]) Iterate(ctx context.Context, fn func(key string, v *T) error) error {
+	c.mu.Lock()
+	items := c.items
+	c.mu.Unlock()
+	if !items.Defined() {
+		return nil
+	}
+	m, err := c.hamt(ctx, items)
+	if err != nil {
+		return err
+	}
+	it := m.MapIterator()
+	for !it.Done() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k, ln, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("collection %s: %w", c.typeName, err)
+		}
+		key, err := k.AsString()
+		if err != nil {
+			return err
+		}
+		v, err := c.load(ctx, ln)
+		if err != nil {
+			return fmt.Errorf("collection %s: key %q: %w", c.typeName, key, err)
+		}
+		if err := fn(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of values in the collection
+func (c *Collection[T]) Len(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	items := c.items
+	c.mu.Unlock()
+	if !items.Defined() {
+		return 0, nil
+	}
+	m, err := c.hamt(ctx, items)
+	if err != nil {
+		return 0, err
+	}
+	return m.Length(), nil
+}
+
+// Find returns the values filed under key in the index called index, in
+// the order of their primary keys
+func (c *Collection[T]) Find(ctx context.Context, index, key string) ([]*T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.ContainsFunc(c.indexes, func(ix Index[T]) bool { return ix.Name == index }) {
+		return nil, fmt.Errorf("collection %s: no index %q", c.typeName, index)
+	}
+	keys, err := c.indexEntry(ctx, c.indexRoots[index], key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*T, 0, len(keys))
+	for _, k := range keys {
+		v, err := c.getLocked(ctx, k)
+		if err != nil {
+			return nil, fmt.Errorf("collection %s: index %q: %w", c.typeName, index, err)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (c *Collection[T]) getLocked(ctx context.Context, key string) (*T, error) {
+	if !c.items.Defined() {
+		return nil, fmt.Errorf("collection %s: %q: %w", c.typeName, key, ErrKeyNotFound)
+	}
+	m, err := c.hamt(ctx, c.items)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := m.LookupByString(key)
+	if err != nil {
+		if errors.As(err, &datamodel.ErrNotExists{}) {
+			return nil, fmt.Errorf("collection %s: %q: %w", c.typeName, key, ErrKeyNotFound)
+		}
+		return nil, err
+	}
+	return c.load(ctx, ln)
+}
+
+func (c *Collection[T]) load(ctx context.Context, ln datamodel.Node) (*T, error) {
+	l, err := ln.AsLink()
+	if err != nil {
+		return nil, err
+	}
+	vc, ok := l.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link %s", l)
+	}
+	return Get[T](ctx, c.w, c.typeName, vc.Cid)
+}
+
+// commit moves the primary key between index entries for the change from
+// old to v (either may be nil) and stores the new manifest
+func (c *Collection[T]) commit(ctx context.Context, items cid.Cid, key string, old, v *T) error {
+	indexRoots := make(map[string]cid.Cid, len(c.indexes))
+	for _, ix := range c.indexes {
+		var before, after []string
+		if old != nil {
+			before = ix.Keys(old)
+		}
+		if v != nil {
+			after = ix.Keys(v)
+		}
+		set := make(map[string]any)
+		var remove []string
+		for _, k := range before {
+			if slices.Contains(after, k) {
+				continue
+			}
+			keys, err := c.indexEntry(ctx, c.indexRoots[ix.Name], k)
+			if err != nil {
+				return err
+			}
+			keys = slices.DeleteFunc(keys, func(s string) bool { return s == key })
+			if len(keys) == 0 {
+				remove = append(remove, k)
+			} else {
+				set[k] = keys
+			}
+		}
+		for _, k := range after {
+			keys, err := c.indexEntry(ctx, c.indexRoots[ix.Name], k)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+				slices.Sort(keys)
+			}
+			set[k] = keys
+		}
+		r, err := c.w.ipld.UpdateHAMT(ctx, c.indexRoots[ix.Name], set, remove)
+		if err != nil {
+			return fmt.Errorf("collection %s: index %q: %w", c.typeName, ix.Name, err)
+		}
+		indexRoots[ix.Name] = r
+	}
+
+	links := make(map[string]any, len(indexRoots))
+	for name, r := range indexRoots {
+		links[name] = r
+	}
+	root, err := c.w.ipld.PutIPLDAny(ctx, map[string]any{
+		"type":    c.typeName,
+		"items":   items,
+		"indexes": links,
+	})
+	if err != nil {
+		return fmt.Errorf("collection %s: manifest: %w", c.typeName, err)
+	}
+	c.root, c.items, c.indexRoots = root, items, indexRoots
+	return nil
+}
+
+// indexEntry returns the primary keys filed under key in the index HAMT
+// under root
+func (c *Collection[T]) indexEntry(ctx context.Context, root cid.Cid, key string) ([]string, error) {
+	if !root.Defined() {
+		return nil, nil
+	}
+	m, err := c.hamt(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	n, err := m.LookupByString(key)
+	if err != nil {
+		if errors.As(err, &datamodel.ErrNotExists{}) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if n.Kind() != datamodel.Kind_List {
+		return nil, fmt.Errorf("index entry %q: got %s, want list", key, n.Kind())
+	}
+	keys := make([]string, 0, n.Length())
+	it := n.ListIterator()
+	for !it.Done() {
+		_, v, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		s, err := v.AsString()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, s)
+	}
+	return keys, nil
+}
+
+// hamt loads the HAMT under root as a map
+func (c *Collection[T]) hamt(ctx context.Context, root cid.Cid) (datamodel.Node, error) {
+	n, err := c.w.ipld.GetIPLD(ctx, root)
+	if err != nil {
+		return nil, fmt.Errorf("collection %s: %w", c.typeName, err)
+	}
+	if _, ok := n.(*hamt.Node); !ok { // the LinkSystem does not reify
+		return ipldprime.ReifyHAMT(linking.LinkContext{Ctx: ctx}, n, &c.w.ipld.LinkSystem)
+	}
+	return n, nil
+}
+
+func lookupString(n datamodel.Node, key string) (string, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return "", err
+	}
+	return v.AsString()
+}
+
+func lookupLink(n datamodel.Node, key string) (cid.Cid, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return cid.Undef, err
+	}
+	l, err := v.AsLink()
+	if err != nil {
+		return cid.Undef, err
+	}
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return cid.Undef, fmt.Errorf("unsupported link %s", l)
+	}
+	return cl.Cid, nil
+}
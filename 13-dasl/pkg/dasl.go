@@ -2,29 +2,39 @@ package dasl
 
 import (
 	"context"
-	_ "embed"
 	"fmt"
+	"sync"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipld/go-ipld-prime/datamodel"
-	"github.com/ipld/go-ipld-prime/node/bindnode"
-	"github.com/ipld/go-ipld-prime/schema"
-	schemadmt "github.com/ipld/go-ipld-prime/schema/dmt"
-	schemadsl "github.com/ipld/go-ipld-prime/schema/dsl"
 
 	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
 )
 
+// The Go types and their Put/Get methods come from codegen/schema.dasl
+//go:generate go run ./codegen -schema codegen/schema.dasl -out schema_gen.go
+
 type DaslWrapper struct {
-	ipld *ipldprime.IpldWrapper
-	ts   *schema.TypeSystem
+	ipld   *ipldprime.IpldWrapper
+	schema *ipldprime.Schema
+
+	stores sync.Map // type name -> *ipldprime.TypedStore[T]
 
-	tRoot schema.Type
-	tUser schema.Type
-	tPost schema.Type
+	// Set by NewVersioned; version 0 stores values untagged
+	version    int
+	oldest     int
+	history    map[int]*ipldprime.Schema
+	mu         sync.RWMutex
+	migrations map[migrationKey]MigrateFunc
 }
 
+// NewDaslWrapper binds the built-in schema (Root, User, Post)
 func NewDaslWrapper(ipld *ipldprime.IpldWrapper) (*DaslWrapper, error) {
+	return NewWithSchema(ipld, schemaDasl)
+}
+
+// NewWithSchema binds the schema dsl to ipld, or to an in-memory store if
+// ipld is nil. Wrappers generated by codegen for other schemas call it.
+func NewWithSchema(ipld *ipldprime.IpldWrapper, dsl string) (*DaslWrapper, error) {
 	var err error
 	if ipld == nil {
 		ipld, err = ipldprime.NewDefault(nil, nil)
@@ -32,101 +42,69 @@ func NewDaslWrapper(ipld *ipldprime.IpldWrapper) (*DaslWrapper, error) {
 			return nil, err
 		}
 	}
-	file, err := schemadsl.ParseBytes([]byte(schemaDasl))
+	s, err := ipldprime.ParseSchema(dsl)
 	if err != nil {
-		return nil, fmt.Errorf("schema parse file: %w", err)
-	}
-
-	ts := schema.TypeSystem{}
-	ts.Init()
-	if err := schemadmt.Compile(&ts, file); err != nil {
-		return nil, fmt.Errorf("schema parse: %w", err)
-	}
-	w := &DaslWrapper{
-		ipld:  ipld,
-		ts:    &ts,
-		tRoot: ts.TypeByName("Root"),
-		tUser: ts.TypeByName("User"),
-		tPost: ts.TypeByName("Post"),
-	}
-	if w.tRoot == nil || w.tUser == nil || w.tPost == nil {
-		return nil, fmt.Errorf("schema type missing (Root/User/Post)")
+		return nil, err
 	}
-	return w, nil
-}
-
-// ----- Prototypes -----
-
-func (w *DaslWrapper) protoRoot() datamodel.NodePrototype {
-	return bindnode.Prototype((*Root)(nil), w.tRoot)
-}
-func (w *DaslWrapper) protoUser() datamodel.NodePrototype {
-	return bindnode.Prototype((*User)(nil), w.tUser)
-}
-func (w *DaslWrapper) protoPost() datamodel.NodePrototype {
-	return bindnode.Prototype((*Post)(nil), w.tPost)
+	return &DaslWrapper{ipld: ipld, schema: s}, nil
 }
 
-func (w *DaslWrapper) PutRoot(ctx context.Context, v *Root) (cid.Cid, error) {
-	if v == nil {
-		return cid.Undef, fmt.Errorf("PutRoot: nil value")
-	}
-	n := bindnode.Wrap(v, w.tRoot) // Go → Node
-	return w.ipld.PutIPLD(ctx, n)
+// Schema returns the compiled schema
+func (w *DaslWrapper) Schema() *ipldprime.Schema {
+	return w.schema
 }
 
-func (w *DaslWrapper) GetRoot(ctx context.Context, c cid.Cid) (*Root, error) {
-	n, err := w.ipld.GetIPLDWith(ctx, c, w.protoRoot())
+// Put validates v against the schema type typeName and stores it, tagged
+// with the schema version if the wrapper is versioned. The generated Put
+// methods call it.
+func Put[T any](ctx context.Context, w *DaslWrapper, typeName string, v *T) (cid.Cid, error) {
+	s, err := typedStore[T](w, typeName)
 	if err != nil {
-		return nil, err
+		return cid.Undef, err
 	}
-	val := bindnode.Unwrap(n) // Node → Go
-	out, ok := val.(*Root)
-	if !ok {
-		return nil, fmt.Errorf("unwrap Root: type assertion to *Root failed")
+	if w.version == 0 {
+		return s.Put(ctx, v)
 	}
-	return out, nil
-}
-
-func (w *DaslWrapper) PutUser(ctx context.Context, v *User) (cid.Cid, error) {
-	if v == nil {
-		return cid.Undef, fmt.Errorf("PutUser: nil value")
+	n, err := s.Wrap(v)
+	if err != nil {
+		return cid.Undef, err
 	}
-	n := bindnode.Wrap(v, w.tUser)
-	return w.ipld.PutIPLD(ctx, n)
+	return w.putTagged(ctx, n)
 }
 
-func (w *DaslWrapper) GetUser(ctx context.Context, c cid.Cid) (*User, error) {
-	n, err := w.ipld.GetIPLDWith(ctx, c, w.protoUser())
+// Get loads c, checks it against the schema type typeName and binds it
+// to T. A versioned wrapper first migrates values written under an older
+// schema version. The generated Get methods call it.
+func Get[T any](ctx context.Context, w *DaslWrapper, typeName string, c cid.Cid) (*T, error) {
+	s, err := typedStore[T](w, typeName)
 	if err != nil {
 		return nil, err
 	}
-	val := bindnode.Unwrap(n)
-	out, ok := val.(*User)
-	if !ok {
-		return nil, fmt.Errorf("unwrap User: type assertion to *User failed")
+	if w.version == 0 {
+		return s.Get(ctx, c)
 	}
-	return out, nil
-}
-
-func (w *DaslWrapper) PutPost(ctx context.Context, v *Post) (cid.Cid, error) {
-	if v == nil {
-		return cid.Undef, fmt.Errorf("PutPost: nil value")
+	n, err := w.getCurrent(ctx, typeName, c)
+	if err != nil {
+		return nil, err
 	}
-	n := bindnode.Wrap(v, w.tPost)
-	return w.ipld.PutIPLD(ctx, n)
+	return s.Unwrap(n)
 }
 
-func (w *DaslWrapper) GetPost(ctx context.Context, c cid.Cid) (*Post, error) {
-	n, err := w.ipld.GetIPLDWith(ctx, c, w.protoPost())
+// typedStore binds T to typeName once per wrapper
+func typedStore[T any](w *DaslWrapper, typeName string) (*ipldprime.TypedStore[T], error) {
+	if s, ok := w.stores.Load(typeName); ok {
+		if ts, ok := s.(*ipldprime.TypedStore[T]); ok {
+			return ts, nil
+		}
+		return nil, fmt.Errorf("schema type %s is bound to another Go type than %T", typeName, (*T)(nil))
+	}
+	ts, err := ipldprime.Bind[T](w.ipld, w.schema, typeName)
 	if err != nil {
 		return nil, err
 	}
-	val := bindnode.Unwrap(n)
-	out, ok := val.(*Post)
-	if !ok {
-		return nil, fmt.Errorf("unwrap Post: type assertion to *Post failed")
+	s, _ := w.stores.LoadOrStore(typeName, ts)
+	if ts, ok := s.(*ipldprime.TypedStore[T]); ok {
+		return ts, nil
 	}
-
-	return out, nil
+	return nil, fmt.Errorf("schema type %s is bound to another Go type than %T", typeName, (*T)(nil))
 }
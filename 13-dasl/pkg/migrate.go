@@ -0,0 +1,302 @@
+package dasl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+
+	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
+)
+
+// A versioned wrapper stores every value inside a version tag:
+//
+//	{"version": 2, "data": {...}}
+//
+// Data without the tag, e.g. written by an unversioned wrapper, is read as
+// the oldest registered version.
+const (
+	versionKey = "version"
+	dataKey    = "data"
+)
+
+// SchemaVersion is one revision of a schema
+type SchemaVersion struct {
+	Version int
+	DSL     string
+}
+
+// MigrateFunc upgrades the serial form of a value by one schema version
+type MigrateFunc func(n datamodel.Node) (datamodel.Node, error)
+
+type migrationKey struct {
+	typeName string
+	from     int
+}
+
+// NewVersioned binds the newest of versions as the current schema. Values
+// written under an older version are upgraded on load by the migrations
+// registered with RegisterMigration.
+func NewVersioned(ipld *ipldprime.IpldWrapper, versions ...SchemaVersion) (*DaslWrapper, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("versioned schema: no versions")
+	}
+	sorted := append([]SchemaVersion(nil), versions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	history := make(map[int]*ipldprime.Schema, len(sorted))
+	for i, v := range sorted {
+		if v.Version <= 0 {
+			return nil, fmt.Errorf("versioned schema: version %d must be positive", v.Version)
+		}
+		if i > 0 && sorted[i-1].Version == v.Version {
+			return nil, fmt.Errorf("versioned schema: version %d given twice", v.Version)
+		}
+		s, err := ipldprime.ParseSchema(v.DSL)
+		if err != nil {
+			return nil, fmt.Errorf("versioned schema v%d: %w", v.Version, err)
+		}
+		history[v.Version] = s
+	}
+
+	current := sorted[len(sorted)-1]
+	w, err := NewWithSchema(ipld, current.DSL)
+	if err != nil {
+		return nil, err
+	}
+	w.version = current.Version
+	w.oldest = sorted[0].Version
+	w.history = history
+	w.migrations = make(map[migrationKey]MigrateFunc)
+	return w, nil
+}
+
+// Version returns the current schema version, or 0 for an unversioned
+// wrapper
+func (w *DaslWrapper) Version() int {
+	return w.version
+}
+
+// RegisterMigration sets fn as the upgrade of typeName from version from
+// to the next registered version. A type without a migration for a step
+// is taken to be unchanged by it. Added optional fields need no
+// migration; renamed fields and new required ones do.
+func (w *DaslWrapper) RegisterMigration(typeName string, from int, fn MigrateFunc) error {
+	if w.version == 0 {
+		return fmt.Errorf("migrate %s: wrapper is not versioned", typeName)
+	}
+	if _, ok := w.history[from]; !ok || from >= w.version {
+		return fmt.Errorf("migrate %s: no schema version after v%d", typeName, from)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.migrations[migrationKey{typeName, from}] = fn
+	return nil
+}
+
+// Upgrade loads c as typeName, migrates it to the current version and
+// stores it again, returning the new CID. Values already current come
+// back under c.
+func (w *DaslWrapper) Upgrade(ctx context.Context, typeName string, c cid.Cid) (cid.Cid, error) {
+	if w.version == 0 {
+		return cid.Undef, fmt.Errorf("upgrade %s: wrapper is not versioned", typeName)
+	}
+	raw, err := w.ipld.GetIPLD(ctx, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	data, version, err := untag(raw, w.oldest)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("upgrade %s: %w", c, err)
+	}
+	if version == w.version {
+		return c, nil
+	}
+	data, err = w.migrate(typeName, version, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	typ, err := w.schema.Type(typeName)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := ipldprime.Validate(data, typ); err != nil {
+		return cid.Undef, err
+	}
+	return w.putTagged(ctx, data)
+}
+
+// putTagged stores n inside the current version tag
+func (w *DaslWrapper) putTagged(ctx context.Context, n datamodel.Node) (cid.Cid, error) {
+	tagged, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, versionKey, qp.Int(int64(w.version)))
+		qp.MapEntry(ma, dataKey, qp.Node(n))
+	})
+	if err != nil {
+		return cid.Undef, fmt.Errorf("tag v%d: %w", w.version, err)
+	}
+	return w.ipld.PutIPLD(ctx, tagged)
+}
+
+// getCurrent loads c and returns its serial form migrated to the current
+// version
+func (w *DaslWrapper) getCurrent(ctx context.Context, typeName string, c cid.Cid) (datamodel.Node, error) {
+	raw, err := w.ipld.GetIPLD(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	data, version, err := untag(raw, w.oldest)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", c, err)
+	}
+	return w.migrate(typeName, version, data)
+}
+
+// migrate runs the migrations of typeName from version up to the current
+// one. The data is checked against its own version first, so a bad
+// value is reported as such and not as a failed migration.
+func (w *DaslWrapper) migrate(typeName string, version int, n datamodel.Node) (datamodel.Node, error) {
+	if version > w.version {
+		return nil, fmt.Errorf("migrate %s: v%d is newer than the schema (v%d)", typeName, version, w.version)
+	}
+	if version == w.version {
+		return n, nil
+	}
+	s, ok := w.history[version]
+	if !ok {
+		return nil, fmt.Errorf("migrate %s: unknown schema version v%d", typeName, version)
+	}
+	if typ, err := s.Type(typeName); err == nil {
+		if err := ipldprime.Validate(n, typ); err != nil {
+			return nil, fmt.Errorf("migrate %s from v%d: %w", typeName, version, err)
+		}
+	}
+
+	for _, from := range w.versionsFrom(version) {
+		w.mu.RLock()
+		fn := w.migrations[migrationKey{typeName, from}]
+		w.mu.RUnlock()
+		if fn == nil {
+			continue
+		}
+		next, err := fn(n)
+		if err != nil {
+			return nil, fmt.Errorf("migrate %s from v%d: %w", typeName, from, err)
+		}
+		n = next
+	}
+	return n, nil
+}
+
+// versionsFrom lists the versions from version up to, not including, the
+// current one
+func (w *DaslWrapper) versionsFrom(version int) []int {
+	var out []int
+	for v := range w.history {
+		if v >= version && v < w.version {
+			out = append(out, v)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// untag splits a stored value into its data and version. Untagged values
+// are of version untagged.
+func untag(n datamodel.Node, untagged int) (datamodel.Node, int, error) {
+	if n.Kind() != datamodel.Kind_Map || n.Length() != 2 {
+		return n, untagged, nil
+	}
+	vn, err := n.LookupByString(versionKey)
+	if err != nil {
+		return n, untagged, nil
+	}
+	data, err := n.LookupByString(dataKey)
+	if err != nil {
+		return n, untagged, nil
+	}
+	v, err := vn.AsInt()
+	if err != nil {
+		return nil, 0, fmt.Errorf("version tag: %w", err)
+	}
+	return data, int(v), nil
+}
+
+// RenameFields returns a migration that renames the keys of a map, old
+// name to new
+func RenameFields(renames map[string]string) MigrateFunc {
+	return func(n datamodel.Node) (datamodel.Node, error) {
+		keys, values, err := mapEntries(n)
+		if err != nil {
+			return nil, fmt.Errorf("rename fields: %w", err)
+		}
+		for i, k := range keys {
+			if to, ok := renames[k]; ok {
+				keys[i] = to
+			}
+		}
+		return buildMap(keys, values)
+	}
+}
+
+// SetDefaults returns a migration that adds the given fields to a map
+// that lacks them, e.g. for a new required field
+func SetDefaults(defaults map[string]any) MigrateFunc {
+	return func(n datamodel.Node) (datamodel.Node, error) {
+		keys, values, err := mapEntries(n)
+		if err != nil {
+			return nil, fmt.Errorf("set defaults: %w", err)
+		}
+		missing := make([]string, 0, len(defaults))
+		for k := range defaults {
+			if _, err := n.LookupByString(k); err != nil {
+				missing = append(missing, k)
+			}
+		}
+		sort.Strings(missing)
+		for _, k := range missing {
+			v, err := ipldprime.AnyToNode(defaults[k])
+			if err != nil {
+				return nil, fmt.Errorf("set default %s: %w", k, err)
+			}
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		return buildMap(keys, values)
+	}
+}
+
+// mapEntries returns the keys and values of the map n in order
+func mapEntries(n datamodel.Node) ([]string, []datamodel.Node, error) {
+	if n.Kind() != datamodel.Kind_Map {
+		return nil, nil, fmt.Errorf("got %s, want map", n.Kind())
+	}
+	keys := make([]string, 0, n.Length())
+	values := make([]datamodel.Node, 0, n.Length())
+	it := n.MapIterator()
+	for !it.Done() {
+		k, v, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := k.AsString()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, v)
+	}
+	return keys, values, nil
+}
+
+func buildMap(keys []string, values []datamodel.Node) (datamodel.Node, error) {
+	return qp.BuildMap(basicnode.Prototype.Any, int64(len(keys)), func(ma datamodel.MapAssembler) {
+		for i, k := range keys {
+			qp.MapEntry(ma, k, qp.Node(values[i]))
+		}
+	})
+}
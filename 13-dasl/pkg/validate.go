@@ -0,0 +1,42 @@
+package dasl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+
+	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
+)
+
+// Validate checks the node stored under c against the schema type
+// typeName without binding it to a Go type. A versioned wrapper checks
+// the value as migrated to the current version. A mismatch is returned as
+// a *ipldprime.ValidationError carrying the path and, for a wrong kind,
+// the expected and actual kinds.
+func (w *DaslWrapper) Validate(ctx context.Context, c cid.Cid, typeName string) error {
+	var (
+		n   datamodel.Node
+		err error
+	)
+	if w.version == 0 {
+		n, err = w.ipld.GetIPLD(ctx, c)
+	} else {
+		n, err = w.getCurrent(ctx, typeName, c)
+	}
+	if err != nil {
+		return fmt.Errorf("validate %s: %w", c, err)
+	}
+	return w.ValidateNode(n, typeName)
+}
+
+// ValidateNode checks n, in its serial form, against the schema type
+// typeName. Gateways can call it on a decoded block before storing it.
+func (w *DaslWrapper) ValidateNode(n datamodel.Node, typeName string) error {
+	typ, err := w.schema.Type(typeName)
+	if err != nil {
+		return err
+	}
+	return ipldprime.Validate(n, typ)
+}
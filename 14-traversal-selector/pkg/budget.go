@@ -0,0 +1,218 @@
+package traversalselector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// BudgetKind names the limit a walk ran into
+type BudgetKind string
+
+const (
+	BudgetNodes     BudgetKind = "nodes"
+	BudgetBytes     BudgetKind = "bytes"
+	BudgetLinkDepth BudgetKind = "link depth"
+	BudgetDeadline  BudgetKind = "deadline"
+)
+
+// Budget limits a walk. Zero fields are unlimited.
+type Budget struct {
+	MaxNodes     int64         // nodes visited, matched or not
+	MaxBytes     int64         // block bytes loaded, the root included
+	MaxLinkDepth int64         // links followed from the root to any block
+	Timeout      time.Duration // wall-clock time for the whole walk
+}
+
+// BudgetUsage is what a walk used, up to where it stopped
+type BudgetUsage struct {
+	Nodes     int64
+	Bytes     int64
+	Blocks    int64
+	LinkDepth int64 // deepest block reached
+}
+
+// BudgetExceeded is returned by WalkBudget when a walk hits one of the
+// limits of its Budget. The walk stops before the node or block that
+// would go over; everything visited before it has been handed to the
+// visit function, so the caller keeps a partial result.
+type BudgetExceeded struct {
+	Kind  BudgetKind
+	Limit int64 // nanoseconds for BudgetDeadline
+	Path  datamodel.Path
+	Usage BudgetUsage
+}
+
+func (e *BudgetExceeded) Error() string {
+	limit := fmt.Sprint(e.Limit)
+	if e.Kind == BudgetDeadline {
+		limit = time.Duration(e.Limit).String()
+	}
+	return fmt.Sprintf("traversal budget exceeded: %s limit %s at %q", e.Kind, limit, e.Path.String())
+}
+
+var errBudgetDeadline = errors.New("traversal deadline")
+
+// budgetWalk tracks one walk against its budget
+type budgetWalk struct {
+	budget Budget
+	ctx    context.Context
+
+	mu       sync.Mutex
+	usage    BudgetUsage
+	blocks   map[string]int64 // path of each loaded block -> its link depth
+	exceeded *BudgetExceeded
+}
+
+// WalkBudget walks root like WalkAdv, within budget. It returns what the
+// walk used and, when it stops early, a *BudgetExceeded or the error of
+// ctx. Cancelling ctx stops the walk at the next node or block.
+func (d *TraversalSelectorWrapper) WalkBudget(
+	ctx context.Context,
+	root cid.Cid,
+	sel selector.Selector,
+	budget Budget,
+	visit traversal.AdvVisitFn,
+) (BudgetUsage, error) {
+	if budget.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, budget.Timeout, errBudgetDeadline)
+		defer cancel()
+	}
+	w := &budgetWalk{budget: budget, ctx: ctx, blocks: make(map[string]int64)}
+
+	lsys := d.LinkSystem
+	w.wrap(&lsys)
+	prog := newProgress(ctx, lsys)
+
+	node, err := lsys.Load(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, basicnode.Prototype.Any)
+	if err == nil {
+		err = prog.WalkAdv(node, sel, func(p traversal.Progress, n datamodel.Node, r traversal.VisitReason) error {
+			if err := w.visit(p.Path); err != nil {
+				return err
+			}
+			return visit(p, n, r)
+		})
+	}
+	return w.result(root, err)
+}
+
+func (w *budgetWalk) result(root cid.Cid, err error) (BudgetUsage, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exceeded != nil {
+		w.exceeded.Usage = w.usage
+		return w.usage, w.exceeded
+	}
+	if err != nil {
+		return w.usage, fmt.Errorf("walk %s: %w", root, err)
+	}
+	return w.usage, nil
+}
+
+// check reports the cancellation of the walk, as BudgetExceeded if it was
+// the budget's own deadline
+func (w *budgetWalk) check(path datamodel.Path) error {
+	if w.ctx.Err() == nil {
+		return nil
+	}
+	if context.Cause(w.ctx) == errBudgetDeadline {
+		return w.exceed(BudgetDeadline, int64(w.budget.Timeout), path)
+	}
+	return w.ctx.Err()
+}
+
+// exceed records the first limit hit; later errors are its consequences
+func (w *budgetWalk) exceed(kind BudgetKind, limit int64, path datamodel.Path) error {
+	if w.exceeded == nil {
+		w.exceeded = &BudgetExceeded{Kind: kind, Limit: limit, Path: path}
+	}
+	return w.exceeded
+}
+
+func (w *budgetWalk) visit(path datamodel.Path) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.check(path); err != nil {
+		return err
+	}
+	if w.budget.MaxNodes > 0 && w.usage.Nodes >= w.budget.MaxNodes {
+		return w.exceed(BudgetNodes, w.budget.MaxNodes, path)
+	}
+	w.usage.Nodes++
+	return nil
+}
+
+// wrap counts the blocks lsys loads and their bytes, and refuses blocks
+// past the budget's link depth
+func (w *budgetWalk) wrap(lsys *linking.LinkSystem) {
+	open := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		w.mu.Lock()
+		if err := w.check(lnkCtx.LinkPath); err != nil {
+			w.mu.Unlock()
+			return nil, err
+		}
+		depth := w.linkDepth(lnkCtx.LinkPath)
+		if w.budget.MaxLinkDepth > 0 && depth > w.budget.MaxLinkDepth {
+			err := w.exceed(BudgetLinkDepth, w.budget.MaxLinkDepth, lnkCtx.LinkPath)
+			w.mu.Unlock()
+			return nil, err
+		}
+		w.blocks[lnkCtx.LinkPath.String()] = depth
+		w.usage.Blocks++
+		w.usage.LinkDepth = max(w.usage.LinkDepth, depth)
+		w.mu.Unlock()
+
+		r, err := open(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		return &budgetReader{r: r, w: w, path: lnkCtx.LinkPath}, nil
+	}
+}
+
+// linkDepth is the number of blocks already loaded on the way to path;
+// the root block is at depth 0
+func (w *budgetWalk) linkDepth(path datamodel.Path) int64 {
+	if path.Len() == 0 {
+		return 0
+	}
+	var depth int64
+	segs := path.Segments()
+	for i := range segs {
+		prefix := datamodel.NewPath(segs[:i]).String()
+		if d, ok := w.blocks[prefix]; ok {
+			depth = d + 1
+		}
+	}
+	return depth
+}
+
+type budgetReader struct {
+	r    io.Reader
+	w    *budgetWalk
+	path datamodel.Path
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	br.w.mu.Lock()
+	defer br.w.mu.Unlock()
+	br.w.usage.Bytes += int64(n)
+	if br.w.budget.MaxBytes > 0 && br.w.usage.Bytes > br.w.budget.MaxBytes {
+		return n, br.w.exceed(BudgetBytes, br.w.budget.MaxBytes, br.path)
+	}
+	return n, err
+}
@@ -0,0 +1,264 @@
+package traversalselector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	sb "github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+//-------------------------------------------------------------------------------//
+// Composable selector nodes
+//-------------------------------------------------------------------------------//
+
+// nodeSpec lets a selector node take part in a builder expression
+type nodeSpec struct {
+	n ipld.Node
+}
+
+func (s nodeSpec) Node() datamodel.Node { return s.n }
+
+func (s nodeSpec) Selector() (selector.Selector, error) { return selector.CompileSelector(s.n) }
+
+// Spec turns a selector node into a spec for the go-ipld-prime builder
+func Spec(n ipld.Node) sb.SelectorSpec {
+	return nodeSpec{n: n}
+}
+
+// SelectorMatchSubset matches the bytes or string at the node, sliced to
+// [from, to)
+func SelectorMatchSubset(from, to int64) ipld.Node {
+	return newSSB().MatcherSubset(from, to).Node()
+}
+
+// SelectorUnion applies every member selector at the node
+func SelectorUnion(members ...ipld.Node) ipld.Node {
+	specs := make([]sb.SelectorSpec, len(members))
+	for i, m := range members {
+		specs[i] = Spec(m)
+	}
+	return newSSB().ExploreUnion(specs...).Node()
+}
+
+// SelectorRange applies next to the list items from start up to, not
+// including, end
+func SelectorRange(start, end int64, next ipld.Node) ipld.Node {
+	return newSSB().ExploreRange(start, end, Spec(next)).Node()
+}
+
+// SelectorFields applies to each named field its own selector
+func SelectorFields(fields map[string]ipld.Node) ipld.Node {
+	return newSSB().ExploreFields(func(ef sb.ExploreFieldsSpecBuilder) {
+		for k, n := range fields {
+			ef.Insert(k, Spec(n))
+		}
+	}).Node()
+}
+
+// SelectorEachItem applies next to every list item and map value
+func SelectorEachItem(next ipld.Node) ipld.Node {
+	return newSSB().ExploreAll(Spec(next)).Node()
+}
+
+// SelectorEdge marks where a SelectorRecursive sequence starts over
+func SelectorEdge() ipld.Node {
+	return newSSB().ExploreRecursiveEdge().Node()
+}
+
+// RecursionOptions bounds a SelectorRecursive
+type RecursionOptions struct {
+	// Depth is how many levels the sequence is applied at; 0 means no limit
+	Depth int64
+	// StopAt, if defined, ends the recursion at links to this CID: the
+	// link is neither followed nor matched
+	StopAt cid.Cid
+}
+
+// SelectorRecursive applies sequence again wherever it reaches a
+// SelectorEdge, within the limits of opts
+func SelectorRecursive(sequence ipld.Node, opts RecursionOptions) (ipld.Node, error) {
+	return recursive(sequence, opts.Depth, opts.StopAt)
+}
+
+func recursive(sequence ipld.Node, depth int64, stopAt cid.Cid) (ipld.Node, error) {
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, selector.SelectorKey_ExploreRecursive, qp.Map(3, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, selector.SelectorKey_Limit, qp.Map(1, func(ma datamodel.MapAssembler) {
+				if depth > 0 {
+					qp.MapEntry(ma, selector.SelectorKey_LimitDepth, qp.Int(depth))
+				} else {
+					qp.MapEntry(ma, selector.SelectorKey_LimitNone, qp.Map(0, func(datamodel.MapAssembler) {}))
+				}
+			}))
+			qp.MapEntry(ma, selector.SelectorKey_Sequence, qp.Node(sequence))
+			if stopAt.Defined() {
+				qp.MapEntry(ma, selector.SelectorKey_StopAt, qp.Map(1, func(ma datamodel.MapAssembler) {
+					qp.MapEntry(ma, string(selector.ConditionMode_Link), qp.Link(cidlink.Link{Cid: stopAt}))
+				}))
+			}
+		}))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("selector recursive: %w", err)
+	}
+	if _, err := selector.CompileSelector(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+//-------------------------------------------------------------------------------//
+// Serial form
+//-------------------------------------------------------------------------------//
+
+// SelectorFromJSON reads a selector in its dag-json form, e.g.
+// {"R":{"l":{"depth":2},":>":{"a":{">":{"@":{}}}}}}, and checks that it
+// compiles
+func SelectorFromJSON(data []byte) (ipld.Node, error) {
+	return decodeSelector(data, dagjson.Decode)
+}
+
+// SelectorFromCBOR reads a selector in its dag-cbor form and checks that
+// it compiles
+func SelectorFromCBOR(data []byte) (ipld.Node, error) {
+	return decodeSelector(data, dagcbor.Decode)
+}
+
+// SelectorToJSON writes a selector node in its dag-json form
+func SelectorToJSON(n ipld.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SelectorToCBOR writes a selector node in its dag-cbor form
+func SelectorToCBOR(n ipld.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(n, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSelector(data []byte, decode func(datamodel.NodeAssembler, io.Reader) error) (ipld.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("decode selector: %w", err)
+	}
+	n := nb.Build()
+	if _, err := selector.CompileSelector(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+//-------------------------------------------------------------------------------//
+// Conditional matching
+//-------------------------------------------------------------------------------//
+
+// Condition decides whether a node may match
+type Condition func(n datamodel.Node) bool
+
+// MatchIf lets sel match only the nodes cond accepts, at every level
+// below it as well. go-ipld-prime compiles no conditional matchers from
+// the serial form, so this works on a compiled selector and has no
+// serial form; exploration is not affected.
+func MatchIf(sel selector.Selector, cond Condition) selector.Selector {
+	if sel == nil {
+		return nil
+	}
+	c := conditional{Selector: sel, cond: cond}
+	if r, ok := sel.(selector.Reifiable); ok {
+		return conditionalReifiable{conditional: c, reifier: r.NamedReifier()}
+	}
+	return c
+}
+
+type conditional struct {
+	selector.Selector
+	cond Condition
+}
+
+func (c conditional) Explore(n datamodel.Node, p datamodel.PathSegment) (selector.Selector, error) {
+	next, err := c.Selector.Explore(n, p)
+	if err != nil || next == nil {
+		return next, err
+	}
+	return MatchIf(next, c.cond), nil
+}
+
+func (c conditional) Decide(n datamodel.Node) bool {
+	return c.Selector.Decide(n) && c.cond(n)
+}
+
+func (c conditional) Match(n datamodel.Node) (datamodel.Node, error) {
+	if !c.cond(n) {
+		return nil, nil
+	}
+	return c.Selector.Match(n)
+}
+
+// conditionalReifiable keeps an InterpretAs selector visible to the walk
+type conditionalReifiable struct {
+	conditional
+	reifier string
+}
+
+func (c conditionalReifiable) NamedReifier() string { return c.reifier }
+
+// KindIs accepts nodes of kind k
+func KindIs(k datamodel.Kind) Condition {
+	return func(n datamodel.Node) bool { return n.Kind() == k }
+}
+
+// HasField accepts maps with the field key
+func HasField(key string) Condition {
+	return func(n datamodel.Node) bool {
+		if n.Kind() != datamodel.Kind_Map {
+			return false
+		}
+		v, err := n.LookupByString(key)
+		return err == nil && !v.IsAbsent()
+	}
+}
+
+// FieldEquals accepts maps whose field key is the string, int or bool
+// value
+func FieldEquals(key string, value any) Condition {
+	return func(n datamodel.Node) bool {
+		if n.Kind() != datamodel.Kind_Map {
+			return false
+		}
+		v, err := n.LookupByString(key)
+		if err != nil {
+			return false
+		}
+		switch want := value.(type) {
+		case string:
+			got, err := v.AsString()
+			return err == nil && got == want
+		case int:
+			got, err := v.AsInt()
+			return err == nil && got == int64(want)
+		case int64:
+			got, err := v.AsInt()
+			return err == nil && got == want
+		case bool:
+			got, err := v.AsBool()
+			return err == nil && got == want
+		}
+		return false
+	}
+}
@@ -0,0 +1,126 @@
+package traversalselector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// ExportCAR walks root with sel and streams every block the walk loads to
+// w as a CARv1 rooted at root. Besides the blocks holding matches, these
+// are the blocks on the way to them, so the CAR can be verified from the
+// root like a trustless gateway response. It returns the number of
+// blocks written.
+func (d *TraversalSelectorWrapper) ExportCAR(ctx context.Context, root cid.Cid, sel selector.Selector, w io.Writer) (int, error) {
+	car, err := storage.NewWritable(w, []cid.Cid{root}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return 0, fmt.Errorf("export car: %w", err)
+	}
+
+	var blocks int
+	lsys := d.LinkSystem
+	open := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		r, err := open(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("export car: unsupported link %s", lnk)
+		}
+		// The LinkSystem checks the hash only after decoding; a bad
+		// block must not reach the CAR first
+		sum, err := cl.Cid.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		if !sum.Equals(cl.Cid) {
+			return nil, fmt.Errorf("export car: block %s: %w", cl.Cid, linking.ErrHashMismatch{Actual: cidlink.Link{Cid: sum}, Expected: lnk})
+		}
+		if err := car.Put(ctx, cl.Cid.KeyString(), data); err != nil {
+			return nil, fmt.Errorf("export car: write %s: %w", cl.Cid, err)
+		}
+		blocks++
+		return bytes.NewReader(data), nil
+	}
+
+	node, err := lsys.Load(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, basicnode.Prototype.Any)
+	if err != nil {
+		return blocks, fmt.Errorf("load root %s: %w", root, err)
+	}
+	prog := newProgress(ctx, lsys)
+	if err := prog.WalkMatching(node, sel, func(traversal.Progress, datamodel.Node) error {
+		return ctx.Err()
+	}); err != nil {
+		return blocks, err
+	}
+	return blocks, nil
+}
+
+// ExportNDJSON walks root with sel and writes every matched node to w as
+// one line of JSON; see NewVisitNDJSON. It returns the number of lines
+// written.
+func (d *TraversalSelectorWrapper) ExportNDJSON(ctx context.Context, root cid.Cid, sel selector.Selector, w io.Writer) (int, error) {
+	visit, sink := NewVisitNDJSON(root, w)
+	err := d.WalkMatching(ctx, root, sel, func(p traversal.Progress, n datamodel.Node) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return visit(p, n)
+	})
+	return sink.Lines, err
+}
+
+// NDJSONSink counts the lines a NewVisitNDJSON visit function wrote
+type NDJSONSink struct {
+	Lines int
+}
+
+// NewVisitNDJSON returns a visit function that writes each node to w as
+//
+//	{"path":"posts/0/title","cid":"bafy...","node":<dag-json>}
+//
+// where cid is the block holding the node. Links in the node are written
+// as {"/":"bafy..."}, bytes as {"/":{"bytes":"..."}}.
+func NewVisitNDJSON(root cid.Cid, w io.Writer) (traversal.VisitFn, *NDJSONSink) {
+	sink := &NDJSONSink{}
+	var buf bytes.Buffer
+	visit := func(p traversal.Progress, n datamodel.Node) error {
+		path, err := json.Marshal(p.Path.String())
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		buf.WriteString(`{"path":`)
+		buf.Write(path)
+		fmt.Fprintf(&buf, `,"cid":%q,"node":`, resolvedFromProgress(p, root).String())
+		if err := dagjson.Encode(n, &buf); err != nil {
+			return fmt.Errorf("encode %q: %w", p.Path.String(), err)
+		}
+		buf.WriteString("}\n")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		sink.Lines++
+		return nil
+	}
+	return visit, sink
+}
@@ -0,0 +1,107 @@
+package traversalselector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	sb "github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+// CompilePathPattern turns a glob-like path pattern into a selector that
+// matches the nodes at the end of every path it describes. Segments are
+// separated by "/" and may be:
+//
+//	name     the field or list index name, e.g. "posts" or "0"
+//	*        every child, one level
+//	**       zero or more levels of any children
+//	{a,b}    each of the listed names
+//	[i:j]    list items i up to, not including, j
+//
+// "posts/*/author" matches the author of every post; "**/cid" matches
+// every "cid" field at any depth. An empty pattern matches the root.
+// Links are followed wherever the pattern crosses them.
+func CompilePathPattern(pattern string) (ipld.Node, error) {
+	trimmed := strings.Trim(pattern, "/")
+	var segs []string
+	if trimmed != "" {
+		segs = strings.Split(trimmed, "/")
+	}
+	spec, err := patternSpec(newSSB(), segs)
+	if err != nil {
+		return nil, fmt.Errorf("path pattern %q: %w", pattern, err)
+	}
+	n := spec.Node()
+	if _, err := selector.CompileSelector(n); err != nil {
+		return nil, fmt.Errorf("path pattern %q: %w", pattern, err)
+	}
+	return n, nil
+}
+
+// patternSpec builds the selector for segs, the first segment outermost
+func patternSpec(ssb sb.SelectorSpecBuilder, segs []string) (sb.SelectorSpec, error) {
+	if len(segs) == 0 {
+		return ssb.Matcher(), nil
+	}
+	seg := segs[0]
+	next, err := patternSpec(ssb, segs[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case seg == "":
+		return nil, fmt.Errorf("empty segment")
+
+	case seg == "**":
+		// Try the rest here, and again one level down
+		return ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreUnion(
+			next,
+			ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
+		)), nil
+
+	case seg == "*":
+		return ssb.ExploreAll(next), nil
+
+	case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+		names := strings.Split(seg[1:len(seg)-1], ",")
+		for _, name := range names {
+			if name == "" || strings.ContainsAny(name, "*[]{}") {
+				return nil, fmt.Errorf("segment %q: bad name %q", seg, name)
+			}
+		}
+		return ssb.ExploreFields(func(ef sb.ExploreFieldsSpecBuilder) {
+			for _, name := range names {
+				ef.Insert(name, next)
+			}
+		}), nil
+
+	case strings.HasPrefix(seg, "[") && strings.HasSuffix(seg, "]"):
+		from, to, ok := strings.Cut(seg[1:len(seg)-1], ":")
+		if !ok {
+			return nil, fmt.Errorf("segment %q: want [start:end]", seg)
+		}
+		start, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("segment %q: bad start: %w", seg, err)
+		}
+		end, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("segment %q: bad end: %w", seg, err)
+		}
+		if start < 0 || end <= start {
+			return nil, fmt.Errorf("segment %q: want 0 <= start < end", seg)
+		}
+		return ssb.ExploreRange(start, end, next), nil
+
+	case strings.ContainsAny(seg, "*[]{}"):
+		return nil, fmt.Errorf("segment %q: wildcards must be a whole segment", seg)
+	}
+
+	// A field name; list nodes take it as an index
+	return ssb.ExploreFields(func(ef sb.ExploreFieldsSpecBuilder) {
+		ef.Insert(seg, next)
+	}), nil
+}
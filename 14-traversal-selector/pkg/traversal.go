@@ -34,9 +34,15 @@ func New(ipld *ipldprime.IpldWrapper) (*TraversalSelectorWrapper, error) {
 }
 
 func (d *TraversalSelectorWrapper) defaultTraversalProgress() traversal.Progress {
+	return newProgress(nil, d.LinkSystem)
+}
+
+// newProgress starts a walk that loads blocks through lsys
+func newProgress(ctx context.Context, lsys linking.LinkSystem) traversal.Progress {
 	return traversal.Progress{
 		Cfg: &traversal.Config{
-			LinkSystem: d.LinkSystem,
+			Ctx:        ctx,
+			LinkSystem: lsys,
 			LinkTargetNodePrototypeChooser: func(_ datamodel.Link, lc linking.LinkContext) (datamodel.NodePrototype, error) {
 				return basicnode.Prototype.Any, nil
 			},
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
 	"github.com/stretchr/testify/require"
 
 	ts "github.com/gosuda/boxo-starter-kit/14-traversal-selector/pkg"
@@ -113,3 +123,302 @@ func TestWalkMatchingAll(t *testing.T) {
 	// 	fmt.Printf("%v\n", val)
 	// }
 }
+
+func countMatches(t *testing.T, ctx context.Context, w *ts.TraversalSelectorWrapper, root cid.Cid, sel selector.Selector) []ts.VisitRecord {
+	visit, col := ts.NewVisitAll(root)
+	require.NoError(t, w.WalkMatching(ctx, root, sel, visit))
+	return col.Records
+}
+
+func TestSelectorBuilders(t *testing.T) {
+	ctx := context.Background()
+	w, err := ts.New(nil)
+	require.NoError(t, err)
+	root := buildBinaryTree(t, ctx, w, 3, "root")
+
+	t.Run("Union", func(t *testing.T) {
+		sel, err := ts.CompileSelector(ts.SelectorUnion(ts.SelectorField("name"), ts.SelectorField("leaf")))
+		require.NoError(t, err)
+		require.Len(t, countMatches(t, ctx, w, root, sel), 2)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		list, err := w.PutIPLDAny(ctx, map[string]any{"items": []any{"a", "b", "c", "d"}})
+		require.NoError(t, err)
+		sel, err := ts.CompileSelector(ts.SelectorFields(map[string]ipld.Node{
+			"items": ts.SelectorRange(1, 3, ts.SelectorOne()),
+		}))
+		require.NoError(t, err)
+		recs := countMatches(t, ctx, w, list, sel)
+		require.Len(t, recs, 2)
+		s, err := recs[0].Node.AsString()
+		require.NoError(t, err)
+		require.Equal(t, "b", s)
+	})
+
+	t.Run("Recursion Stop At", func(t *testing.T) {
+		rootNode, err := w.GetIPLD(ctx, root)
+		require.NoError(t, err)
+		left := loadLink(t, rootNode, "L")
+
+		sequence := ts.SelectorUnion(ts.SelectorOne(), ts.SelectorEachItem(ts.SelectorEdge()))
+		all, err := ts.SelectorRecursive(sequence, ts.RecursionOptions{})
+		require.NoError(t, err)
+		stopped, err := ts.SelectorRecursive(sequence, ts.RecursionOptions{StopAt: left})
+		require.NoError(t, err)
+		shallow, err := ts.SelectorRecursive(sequence, ts.RecursionOptions{Depth: 2})
+		require.NoError(t, err)
+
+		count := func(n ipld.Node) int {
+			sel, err := ts.CompileSelector(n)
+			require.NoError(t, err)
+			return len(countMatches(t, ctx, w, root, sel))
+		}
+		// the left subtree is its map, name and leaf, and two leaves of 3
+		require.Equal(t, 21, count(all))
+		require.Equal(t, 21-9, count(stopped))
+		require.Equal(t, 5, count(shallow))
+
+		_, err = ts.SelectorRecursive(ts.SelectorOne(), ts.RecursionOptions{})
+		require.Error(t, err, "a sequence without an edge does not compile")
+	})
+
+	t.Run("Match If", func(t *testing.T) {
+		sel, err := ts.CompileSelector(ts.SelectorAll(true))
+		require.NoError(t, err)
+		leaves := countMatches(t, ctx, w, root, ts.MatchIf(sel, ts.FieldEquals("leaf", true)))
+		require.Len(t, leaves, 4)
+		for _, rec := range leaves {
+			require.True(t, loadBool(t, rec.Node, "leaf"))
+		}
+		maps := countMatches(t, ctx, w, root, ts.MatchIf(sel, ts.KindIs(datamodel.Kind_Map)))
+		require.Len(t, maps, 7)
+		named := countMatches(t, ctx, w, root, ts.MatchIf(sel, ts.HasField("L")))
+		require.Len(t, named, 3)
+	})
+
+	t.Run("Serial Form", func(t *testing.T) {
+		n, err := ts.SelectorFromJSON([]byte(`{"R":{"l":{"depth":2},":>":{"|":[{".":{}},{"a":{">":{"@":{}}}}]}}}`))
+		require.NoError(t, err)
+		require.True(t, ipld.DeepEqual(ts.SelectorDepth(2, true), n))
+
+		data, err := ts.SelectorToCBOR(n)
+		require.NoError(t, err)
+		back, err := ts.SelectorFromCBOR(data)
+		require.NoError(t, err)
+		require.True(t, ipld.DeepEqual(n, back))
+
+		js, err := ts.SelectorToJSON(ts.SelectorField("name"))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"f":{"f>":{"name":{".":{}}}}}`, string(js))
+
+		_, err = ts.SelectorFromJSON([]byte(`{"R":{"l":{"depth":2},":>":{".":{}}}}`))
+		require.Error(t, err)
+		_, err = ts.SelectorFromJSON([]byte(`not json`))
+		require.Error(t, err)
+	})
+}
+
+func TestCompilePathPattern(t *testing.T) {
+	ctx := context.Background()
+	w, err := ts.New(nil)
+	require.NoError(t, err)
+
+	alice, err := w.PutIPLDAny(ctx, map[string]any{"name": "alice"})
+	require.NoError(t, err)
+	posts := make([]any, 5)
+	for i := range posts {
+		posts[i] = map[string]any{"title": fmt.Sprintf("post %d", i), "author": alice, "n": i}
+	}
+	root, err := w.PutIPLDAny(ctx, map[string]any{
+		"posts": posts,
+		"meta":  map[string]any{"name": "blog", "owner": map[string]any{"name": "bob"}},
+	})
+	require.NoError(t, err)
+
+	match := func(t *testing.T, pattern string) []string {
+		n, err := ts.CompilePathPattern(pattern)
+		require.NoError(t, err)
+		sel, err := ts.CompileSelector(n)
+		require.NoError(t, err)
+		var paths []string
+		require.NoError(t, w.WalkMatching(ctx, root, sel, func(p traversal.Progress, _ datamodel.Node) error {
+			paths = append(paths, p.Path.String())
+			return nil
+		}))
+		return paths
+	}
+
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"", []string{""}},
+		{"meta/name", []string{"meta/name"}},
+		{"posts/1/title", []string{"posts/1/title"}},
+		{"posts/*/n", []string{"posts/0/n", "posts/1/n", "posts/2/n", "posts/3/n", "posts/4/n"}},
+		{"posts/[1:3]/title", []string{"posts/1/title", "posts/2/title"}},
+		{"meta/{name,missing}", []string{"meta/name"}},
+		{"posts/0/author/name", []string{"posts/0/author/name"}},
+		{"**/owner/name", []string{"meta/owner/name"}},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			require.ElementsMatch(t, tc.want, match(t, tc.pattern))
+		})
+	}
+
+	t.Run("Any Depth", func(t *testing.T) {
+		// All posts link the same author block, which the walk visits once
+		require.ElementsMatch(t, []string{"meta/name", "meta/owner/name", "posts/0/author/name"}, match(t, "**/name"))
+	})
+
+	for _, bad := range []string{"a//b", "a*", "[1:x]", "[3:1]", "{a,}", "[2]"} {
+		t.Run("Invalid "+bad, func(t *testing.T) {
+			_, err := ts.CompilePathPattern(bad)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestWalkBudget(t *testing.T) {
+	ctx := context.Background()
+	w, err := ts.New(nil)
+	require.NoError(t, err)
+	root := buildBinaryTree(t, ctx, w, 4, "root") // 15 blocks, 3 links deep
+
+	sel, err := ts.CompileSelector(ts.SelectorAll(true))
+	require.NoError(t, err)
+
+	walk := func(ctx context.Context, budget ts.Budget) (ts.BudgetUsage, []ts.AdvVisitRecord, error) {
+		visit, col := ts.NewAdvVisitAll(root)
+		usage, err := w.WalkBudget(ctx, root, sel, budget, visit)
+		return usage, col.Records, err
+	}
+
+	t.Run("Unlimited", func(t *testing.T) {
+		usage, recs, err := walk(ctx, ts.Budget{})
+		require.NoError(t, err)
+		require.Equal(t, int64(15), usage.Blocks)
+		require.Equal(t, int64(3), usage.LinkDepth)
+		require.Equal(t, int64(len(recs)), usage.Nodes)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		budget ts.Budget
+		kind   ts.BudgetKind
+	}{
+		{"Nodes", ts.Budget{MaxNodes: 10}, ts.BudgetNodes},
+		{"Bytes", ts.Budget{MaxBytes: 300}, ts.BudgetBytes},
+		{"Link Depth", ts.Budget{MaxLinkDepth: 1}, ts.BudgetLinkDepth},
+		{"Deadline", ts.Budget{Timeout: time.Nanosecond}, ts.BudgetDeadline},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			usage, recs, err := walk(ctx, tc.budget)
+			var exceeded *ts.BudgetExceeded
+			require.ErrorAs(t, err, &exceeded)
+			require.Equal(t, tc.kind, exceeded.Kind)
+			require.Equal(t, usage, exceeded.Usage)
+			require.Equal(t, int64(len(recs)), usage.Nodes, "every counted node was visited")
+
+			switch tc.kind {
+			case ts.BudgetNodes:
+				require.Len(t, recs, 10)
+			case ts.BudgetLinkDepth:
+				require.Equal(t, int64(1), usage.LinkDepth)
+				require.Less(t, usage.Blocks, int64(15))
+				require.NotEmpty(t, recs)
+			}
+		})
+	}
+
+	t.Run("Cancel", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var seen int
+		_, err := w.WalkBudget(cctx, root, sel, ts.Budget{}, func(traversal.Progress, datamodel.Node, traversal.VisitReason) error {
+			seen++
+			if seen == 3 {
+				cancel()
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		var exceeded *ts.BudgetExceeded
+		require.False(t, errors.As(err, &exceeded))
+		require.Equal(t, 3, seen)
+	})
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	w, err := ts.New(nil)
+	require.NoError(t, err)
+	root := buildBinaryTree(t, ctx, w, 3, "root")
+
+	t.Run("CAR", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			sel    ipld.Node
+			blocks int
+		}{
+			{"All", ts.SelectorAll(true), 7},
+			{"Path", ts.SelectorPath(datamodel.ParsePath("L/R/name")), 3},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				sel, err := ts.CompileSelector(tc.sel)
+				require.NoError(t, err)
+				var buf bytes.Buffer
+				n, err := w.ExportCAR(ctx, root, sel, &buf)
+				require.NoError(t, err)
+				require.Equal(t, tc.blocks, n)
+
+				br, err := car.NewBlockReader(&buf)
+				require.NoError(t, err)
+				require.Equal(t, []cid.Cid{root}, br.Roots)
+				var read int
+				for {
+					blk, err := br.Next()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					sum, err := blk.Cid().Prefix().Sum(blk.RawData())
+					require.NoError(t, err)
+					require.Equal(t, blk.Cid(), sum)
+					read++
+				}
+				require.Equal(t, tc.blocks, read)
+			})
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		sel, err := ts.CompileSelector(ts.SelectorAll(true))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		n, err := w.ExportNDJSON(ctx, root, sel, &buf)
+		require.NoError(t, err)
+		require.Equal(t, 21, n)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 21)
+		var names []string
+		for _, line := range lines {
+			var rec struct {
+				Path string          `json:"path"`
+				Cid  string          `json:"cid"`
+				Node json.RawMessage `json:"node"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &rec), line)
+			_, err := cid.Decode(rec.Cid)
+			require.NoError(t, err)
+			if strings.HasSuffix(rec.Path, "name") {
+				var name string
+				require.NoError(t, json.Unmarshal(rec.Node, &name))
+				names = append(names, name)
+			}
+		}
+		require.ElementsMatch(t, []string{"root", "rootL", "rootR", "rootLL", "rootLR", "rootRL", "rootRR"}, names)
+	})
+}
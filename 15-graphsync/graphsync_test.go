@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ipfs/go-cid"
+	igs "github.com/ipfs/go-graphsync"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/stretchr/testify/require"
 
 	traversalselector "github.com/gosuda/boxo-starter-kit/14-traversal-selector/pkg"
@@ -31,9 +40,9 @@ func TestGraphSyncPubsub(t *testing.T) {
 	require.NoError(t, err)
 
 	// fetch with default selector (whole graph)
-	progress, err := gs2.Fetch(ctx, gs1.Host.ID(), c1, nil)
+	res, err := gs2.Fetch(ctx, gs1.Host.ID(), c1, nil)
 	require.NoError(t, err)
-	require.True(t, progress)
+	require.True(t, res.Progress())
 
 	// get data
 	got, err := gs2.Ipld.GetIPLDAny(ctx, c1)
@@ -70,9 +79,9 @@ func TestGraphSyncPubsubWithSelector(t *testing.T) {
 	rootCID, err := gs1.Ipld.PutIPLDAny(ctx, root)
 	require.NoError(t, err)
 
-	progress, err := gs2.Fetch(ctx, gs1.Host.ID(), rootCID, traversalselector.SelectorField("left"))
+	res, err := gs2.Fetch(ctx, gs1.Host.ID(), rootCID, traversalselector.SelectorField("left"))
 	require.NoError(t, err)
-	require.True(t, progress)
+	require.True(t, res.Progress())
 
 	// leftVal should be fetched
 	got, err := gs2.Ipld.GetIPLDAny(ctx, leftCID)
@@ -84,9 +93,9 @@ func TestGraphSyncPubsubWithSelector(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, got)
 
-	progress, err = gs2.Fetch(ctx, gs1.Host.ID(), rootCID, nil)
+	res, err = gs2.Fetch(ctx, gs1.Host.ID(), rootCID, nil)
 	require.NoError(t, err)
-	require.True(t, progress)
+	require.True(t, res.Progress())
 
 	// rightVal should be fetched now
 	got, err = gs2.Ipld.GetIPLDAny(ctx, rightCID)
@@ -99,3 +108,503 @@ func TestGraphSyncPubsubWithSelector(t *testing.T) {
 	expected := map[string]any{"left": leftCID, "right": rightCID}
 	require.EqualValues(t, expected, got)
 }
+
+func TestGraphSyncRequestPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	provider, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	requestor, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, requestor.Host.ConnectToPeer(ctx, provider.Host.GetFullAddresses()[0]))
+	require.NoError(t, provider.Host.ConnectToPeer(ctx, requestor.Host.GetFullAddresses()[0]))
+
+	leafCID, err := provider.Ipld.PutIPLDAny(ctx, "leaf")
+	require.NoError(t, err)
+	rootCID, err := provider.Ipld.PutIPLDAny(ctx, map[string]any{"leaf": cidlink.Link{Cid: leafCID}})
+	require.NoError(t, err)
+
+	var rejected []error
+	reject := func(p peer.ID, req igs.RequestData, err error) { rejected = append(rejected, err) }
+
+	t.Run("PeerNotAllowed", func(t *testing.T) {
+		rejected = nil
+		provider.SetRequestPolicy(graphsync.RequestPolicy{
+			AllowPeer: func(p peer.ID) bool { return p != requestor.Host.ID() },
+			OnReject:  reject,
+		})
+		_, err := requestor.Fetch(ctx, provider.Host.ID(), rootCID, nil)
+		require.Error(t, err)
+		require.Len(t, rejected, 1)
+		require.ErrorIs(t, rejected[0], graphsync.ErrPeerNotAllowed)
+	})
+
+	t.Run("RootRejected", func(t *testing.T) {
+		rejected = nil
+		provider.SetRequestPolicy(graphsync.RequestPolicy{
+			RejectRoot: func(c cid.Cid) bool { return c.Equals(rootCID) },
+			OnReject:   reject,
+		})
+		_, err := requestor.Fetch(ctx, provider.Host.ID(), rootCID, nil)
+		require.Error(t, err)
+		require.Len(t, rejected, 1)
+		require.ErrorIs(t, rejected[0], graphsync.ErrRootRejected)
+	})
+
+	t.Run("SelectorDepth", func(t *testing.T) {
+		rejected = nil
+		provider.SetRequestPolicy(graphsync.RequestPolicy{MaxSelectorDepth: 5, OnReject: reject})
+
+		// the default selector recurses without a limit
+		_, err := requestor.Fetch(ctx, provider.Host.ID(), rootCID, nil)
+		require.Error(t, err)
+		require.Len(t, rejected, 1)
+		require.ErrorIs(t, rejected[0], graphsync.ErrSelectorNotAllowed)
+
+		res, err := requestor.Fetch(ctx, provider.Host.ID(), rootCID, traversalselector.SelectorDepth(2, true))
+		require.NoError(t, err)
+		require.True(t, res.Progress())
+		require.Len(t, rejected, 1)
+	})
+
+	t.Run("PauseResume", func(t *testing.T) {
+		provider.SetRequestPolicy(graphsync.RequestPolicy{
+			Pause: func(peer.ID, igs.RequestData) bool { return true },
+		})
+		// a root the requestor does not hold yet, or it is served locally
+		pausedCID, err := provider.Ipld.PutIPLDAny(ctx, "paused")
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := requestor.Fetch(ctx, provider.Host.ID(), pausedCID, nil)
+			done <- err
+		}()
+
+		require.Eventually(t, func() bool { return len(provider.Paused()) == 1 }, 5*time.Second, 10*time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("fetch finished while paused: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		require.NoError(t, provider.Resume(ctx, provider.Paused()[0]))
+		require.NoError(t, <-done)
+		require.Empty(t, provider.Paused())
+	})
+}
+
+func TestGraphSyncFetchStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	requestor, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, requestor.Host.ConnectToPeer(ctx, provider.Host.GetFullAddresses()[0]))
+	require.NoError(t, provider.Host.ConnectToPeer(ctx, requestor.Host.GetFullAddresses()[0]))
+
+	links := map[string]any{}
+	for _, name := range []string{"a", "b"} {
+		c, err := provider.Ipld.PutIPLDAny(ctx, "leaf "+name)
+		require.NoError(t, err)
+		links[name] = cidlink.Link{Cid: c}
+	}
+	rootCID, err := provider.Ipld.PutIPLDAny(ctx, links)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var events []graphsync.ProgressEvent
+	requestor.OnProgress(func(ev graphsync.ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})
+
+	res, err := requestor.Fetch(ctx, provider.Host.ID(), rootCID, nil)
+	require.NoError(t, err)
+	require.True(t, res.Progress())
+	require.Equal(t, provider.Host.ID(), res.Peer)
+	require.Equal(t, rootCID, res.Root)
+	require.Equal(t, 3, res.Blocks)
+	require.Greater(t, res.BytesOnWire, uint64(0))
+	require.Greater(t, res.Duration, time.Duration(0))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 3)
+	var size uint64
+	for i, ev := range events {
+		require.Equal(t, res.RequestID, ev.Result.RequestID)
+		require.Equal(t, i+1, ev.Result.Blocks)
+		size += ev.Size
+	}
+	require.Equal(t, rootCID, events[0].Block)
+	require.Equal(t, res.Bytes, size)
+}
+
+func TestGraphSyncExtensions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	requestor, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, requestor.Host.ConnectToPeer(ctx, provider.Host.GetFullAddresses()[0]))
+	require.NoError(t, provider.Host.ConnectToPeer(ctx, requestor.Host.GetFullAddresses()[0]))
+
+	const name = igs.ExtensionName("boxo-starter-kit/greeting")
+	var seen []string
+	require.NoError(t, provider.RegisterExtension(graphsync.Extension{
+		Name: name,
+		Respond: func(p peer.ID, req igs.RequestData, data datamodel.Node) (datamodel.Node, error) {
+			s, err := data.AsString()
+			if err != nil {
+				return nil, err
+			}
+			if s == "reject me" {
+				return nil, errors.New("rejected")
+			}
+			return basicnode.NewString("hello " + s), nil
+		},
+	}))
+
+	var greeting string
+	require.NoError(t, requestor.RegisterExtension(graphsync.Extension{
+		Name: name,
+		Request: func(p peer.ID, root cid.Cid) datamodel.Node {
+			return basicnode.NewString("requestor")
+		},
+		OnResponse: func(p peer.ID, resp igs.ResponseData, data datamodel.Node) {
+			greeting, _ = data.AsString()
+			seen = append(seen, greeting)
+		},
+	}))
+
+	c, err := provider.Ipld.PutIPLDAny(ctx, "with extensions")
+	require.NoError(t, err)
+	res, err := requestor.Fetch(ctx, provider.Host.ID(), c, nil)
+	require.NoError(t, err)
+	require.True(t, res.Progress())
+	require.Equal(t, "hello requestor", greeting)
+
+	// An error from Respond refuses the request
+	require.NoError(t, requestor.RegisterExtension(graphsync.Extension{
+		Name: name,
+		Request: func(p peer.ID, root cid.Cid) datamodel.Node {
+			return basicnode.NewString("reject me")
+		},
+	}))
+	c, err = provider.Ipld.PutIPLDAny(ctx, "refused")
+	require.NoError(t, err)
+	_, err = requestor.Fetch(ctx, provider.Host.ID(), c, nil)
+	require.Error(t, err)
+	require.Len(t, seen, 1)
+}
+
+func TestGraphSyncCompression(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newPeer := func(compression bool) *graphsync.GraphSyncWrapper {
+		gs, err := graphsync.NewWithConfig(ctx, nil, nil, &graphsync.Config{Compression: compression})
+		require.NoError(t, err)
+		return gs
+	}
+	connect := func(a, b *graphsync.GraphSyncWrapper) {
+		require.NoError(t, a.Host.ConnectToPeer(ctx, b.Host.GetFullAddresses()[0]))
+		require.NoError(t, b.Host.ConnectToPeer(ctx, a.Host.GetFullAddresses()[0]))
+	}
+	// Repetitive text compresses well
+	putData := func(gs *graphsync.GraphSyncWrapper) cid.Cid {
+		items := map[string]any{}
+		for i := range 8 {
+			c, err := gs.Ipld.PutIPLDAny(ctx, strings.Repeat(fmt.Sprintf("item %d, ", i), 200))
+			require.NoError(t, err)
+			items[fmt.Sprint(i)] = cidlink.Link{Cid: c}
+		}
+		root, err := gs.Ipld.PutIPLDAny(ctx, items)
+		require.NoError(t, err)
+		return root
+	}
+
+	t.Run("Negotiated", func(t *testing.T) {
+		provider, requestor := newPeer(true), newPeer(true)
+		connect(requestor, provider)
+		root := putData(provider)
+
+		res, err := requestor.Fetch(ctx, provider.Host.ID(), root, nil)
+		require.NoError(t, err)
+		require.Equal(t, 9, res.Blocks)
+
+		stats := requestor.CompressionStats()
+		require.Positive(t, stats.Streams)
+		require.Greater(t, stats.RawBytes, int64(res.BytesOnWire))
+		require.Greater(t, stats.Ratio(), 5.0)
+		require.Positive(t, provider.CompressionStats().Streams)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		provider, requestor := newPeer(false), newPeer(true)
+		connect(requestor, provider)
+		root := putData(provider)
+
+		res, err := requestor.Fetch(ctx, provider.Host.ID(), root, nil)
+		require.NoError(t, err)
+		require.Equal(t, 9, res.Blocks)
+		require.Zero(t, requestor.CompressionStats().Streams)
+		require.Zero(t, provider.CompressionStats())
+	})
+}
+
+func TestTransferManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	newNode := func() (*graphsync.GraphSyncWrapper, *graphsync.TransferManager) {
+		gs, err := graphsync.New(ctx, nil, nil)
+		require.NoError(t, err)
+		m, err := graphsync.NewTransferManager(ctx, gs)
+		require.NoError(t, err)
+		return gs, m
+	}
+	sender, senderM := newNode()
+	receiver, receiverM := newNode()
+	require.NoError(t, receiver.Host.ConnectToPeer(ctx, sender.Host.GetFullAddresses()[0]))
+	require.NoError(t, sender.Host.ConnectToPeer(ctx, receiver.Host.GetFullAddresses()[0]))
+
+	// Only vouchers naming "demo" are accepted, both ways
+	validator := func(p peer.ID, dir graphsync.TransferDirection, root cid.Cid, voucher datamodel.Node) error {
+		if voucher == nil {
+			return errors.New("voucher required")
+		}
+		if s, _ := voucher.AsString(); s != "demo" {
+			return fmt.Errorf("unknown voucher %q", s)
+		}
+		return nil
+	}
+	senderM.SetVoucherValidator(validator)
+	receiverM.SetVoucherValidator(validator)
+	voucher := basicnode.NewString("demo")
+
+	putTree := func(gs *graphsync.GraphSyncWrapper, name string) (cid.Cid, cid.Cid) {
+		leaf, err := gs.Ipld.PutIPLDAny(ctx, "leaf of "+name)
+		require.NoError(t, err)
+		root, err := gs.Ipld.PutIPLDAny(ctx, map[string]any{"name": name, "leaf": cidlink.Link{Cid: leaf}})
+		require.NoError(t, err)
+		return root, leaf
+	}
+
+	t.Run("Push", func(t *testing.T) {
+		root, leaf := putTree(sender, "push")
+		id, err := senderM.Push(ctx, receiver.Host.ID(), root, nil, voucher)
+		require.NoError(t, err)
+
+		got, err := receiverM.Wait(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, graphsync.TransferReceive, got.Direction)
+		require.False(t, got.Initiator)
+		require.Equal(t, 2, got.Result.Blocks)
+		_, err = receiver.Ipld.GetIPLDAny(ctx, leaf)
+		require.NoError(t, err)
+
+		sent, err := senderM.Wait(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, graphsync.TransferSend, sent.Direction)
+		require.True(t, sent.Initiator)
+	})
+
+	t.Run("PushRejected", func(t *testing.T) {
+		root, _ := putTree(sender, "rejected")
+		id, err := senderM.Push(ctx, receiver.Host.ID(), root, nil, basicnode.NewString("forged"))
+		require.ErrorContains(t, err, "unknown voucher")
+		got, err := senderM.Transfer(id)
+		require.NoError(t, err)
+		require.Equal(t, graphsync.TransferFailed, got.Status)
+		_, err = receiverM.Transfer(id)
+		require.ErrorIs(t, err, graphsync.ErrTransferNotFound)
+	})
+
+	t.Run("Pull", func(t *testing.T) {
+		root, _ := putTree(sender, "pull")
+		id, err := receiverM.Pull(ctx, sender.Host.ID(), root, nil, voucher)
+		require.NoError(t, err)
+		_, err = receiverM.Wait(ctx, id)
+		require.NoError(t, err)
+		sent, err := senderM.Wait(ctx, id)
+		require.NoError(t, err)
+		require.False(t, sent.Initiator)
+
+		// Without a voucher the sender refuses
+		root, _ = putTree(sender, "pull without voucher")
+		id, err = receiverM.Pull(ctx, sender.Host.ID(), root, nil, nil)
+		require.NoError(t, err)
+		_, err = receiverM.Wait(ctx, id)
+		require.Error(t, err)
+	})
+
+	t.Run("PlainRequestValidated", func(t *testing.T) {
+		// A graphsync request outside any transfer carries no voucher
+		root, _ := putTree(sender, "plain")
+		_, err := receiver.Fetch(ctx, sender.Host.ID(), root, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("PushWithoutValidator", func(t *testing.T) {
+		open, openM := newNode()
+		require.NoError(t, sender.Host.ConnectToPeer(ctx, open.Host.GetFullAddresses()[0]))
+		root, _ := putTree(sender, "unvalidated")
+		_, err := senderM.Push(ctx, open.Host.ID(), root, nil, voucher)
+		require.ErrorContains(t, err, "no validator accepts pushes")
+		require.Empty(t, openM.Transfers())
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		senderM.SetRetention(50 * time.Millisecond)
+		defer senderM.SetRetention(0)
+		root, _ := putTree(sender, "forgotten")
+		id, err := senderM.Push(ctx, receiver.Host.ID(), root, nil, voucher)
+		require.NoError(t, err)
+		_, err = senderM.Wait(ctx, id)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			_, err := senderM.Transfer(id)
+			return errors.Is(err, graphsync.ErrTransferNotFound)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		// The sender lacks the leaf at first, so the transfer fails
+		other, err := graphsync.New(ctx, nil, nil)
+		require.NoError(t, err)
+		leaf, err := other.Ipld.PutIPLDAny(ctx, "late leaf")
+		require.NoError(t, err)
+		root, err := sender.Ipld.PutIPLDAny(ctx, map[string]any{"leaf": cidlink.Link{Cid: leaf}})
+		require.NoError(t, err)
+
+		id, err := senderM.Push(ctx, receiver.Host.ID(), root, nil, voucher)
+		require.NoError(t, err)
+		_, err = receiverM.Wait(ctx, id)
+		require.Error(t, err)
+
+		_, err = sender.Ipld.PutIPLDAny(ctx, "late leaf")
+		require.NoError(t, err)
+		require.NoError(t, senderM.Restart(ctx, id))
+
+		got, err := receiverM.Wait(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, 2, got.Attempts)
+		_, err = receiver.Ipld.GetIPLDAny(ctx, leaf)
+		require.NoError(t, err)
+		// the root arrived with the first attempt
+		require.Less(t, got.Result.BytesOnWire, got.Result.Bytes)
+
+		_, err = senderM.Wait(ctx, id)
+		require.NoError(t, err)
+		require.Error(t, senderM.Restart(ctx, id))
+	})
+}
+
+func TestGraphSyncClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	pair := func(providerCfg, requestorCfg *graphsync.Config) (*graphsync.GraphSyncWrapper, *graphsync.GraphSyncWrapper) {
+		provider, err := graphsync.NewWithConfig(ctx, nil, nil, providerCfg)
+		require.NoError(t, err)
+		requestor, err := graphsync.NewWithConfig(ctx, nil, nil, requestorCfg)
+		require.NoError(t, err)
+		require.NoError(t, requestor.Host.ConnectToPeer(ctx, provider.Host.GetFullAddresses()[0]))
+		require.NoError(t, provider.Host.ConnectToPeer(ctx, requestor.Host.GetFullAddresses()[0]))
+		provider.SetRequestPolicy(graphsync.RequestPolicy{
+			Pause: func(peer.ID, igs.RequestData) bool { return true },
+		})
+		return provider, requestor
+	}
+	fetch := func(requestor, provider *graphsync.GraphSyncWrapper, value string) <-chan error {
+		c, err := provider.Ipld.PutIPLDAny(ctx, value)
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := requestor.Fetch(ctx, provider.Host.ID(), c, nil)
+			done <- err
+		}()
+		return done
+	}
+	pausedCount := func(gs *graphsync.GraphSyncWrapper, n int) func() bool {
+		return func() bool { return len(gs.Paused()) == n }
+	}
+
+	t.Run("Drain", func(t *testing.T) {
+		provider, requestor := pair(nil, nil)
+		done := fetch(requestor, provider, "drain")
+		require.Eventually(t, pausedCount(provider, 1), 5*time.Second, 10*time.Millisecond)
+
+		closed := make(chan error, 1)
+		go func() { closed <- provider.Close() }()
+		select {
+		case err := <-closed:
+			t.Fatalf("closed with a request in flight: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		require.NoError(t, provider.Resume(ctx, provider.Paused()[0]))
+		require.NoError(t, <-done)
+		require.NoError(t, <-closed)
+		require.Zero(t, provider.InFlight())
+
+		_, _, err := provider.Request(ctx, requestor.Host.ID(), cid.Undef, nil)
+		require.ErrorIs(t, err, graphsync.ErrClosed)
+		require.NoError(t, provider.Close())
+	})
+
+	t.Run("DrainTimeout", func(t *testing.T) {
+		provider, requestor := pair(&graphsync.Config{DrainTimeout: 200 * time.Millisecond}, nil)
+		// never resumed; the fetch ends with the test's context
+		fetch(requestor, provider, "stuck")
+		require.Eventually(t, pausedCount(provider, 1), 5*time.Second, 10*time.Millisecond)
+
+		err := provider.Close()
+		require.ErrorContains(t, err, "still in flight")
+	})
+
+	t.Run("OutgoingPerPeer", func(t *testing.T) {
+		provider, requestor := pair(nil, &graphsync.Config{MaxOutgoingRequestsPerPeer: 1})
+		first := fetch(requestor, provider, "first")
+		second := fetch(requestor, provider, "second")
+
+		// Only one request reaches the provider until it finishes
+		require.Eventually(t, pausedCount(provider, 1), 5*time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		require.Len(t, provider.Paused(), 1)
+
+		for range 2 {
+			require.Eventually(t, pausedCount(provider, 1), 5*time.Second, 10*time.Millisecond)
+			require.NoError(t, provider.Resume(ctx, provider.Paused()[0]))
+		}
+		require.NoError(t, <-first)
+		require.NoError(t, <-second)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		provider, requestor := pair(nil, &graphsync.Config{MaxOutgoingRequestsPerPeer: 1})
+		c, err := provider.Ipld.PutIPLDAny(ctx, "cancelled")
+		require.NoError(t, err)
+		fetchCtx, fetchCancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			_, err := requestor.Fetch(fetchCtx, provider.Host.ID(), c, nil)
+			done <- err
+		}()
+		require.Eventually(t, pausedCount(provider, 1), 5*time.Second, 10*time.Millisecond)
+		fetchCancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+
+		// the request ends and frees its slot though nobody reads its errors
+		require.Eventually(t, func() bool { return requestor.InFlight() == 0 }, 5*time.Second, 10*time.Millisecond)
+		provider.SetRequestPolicy(graphsync.RequestPolicy{})
+		require.NoError(t, <-fetch(requestor, provider, "after"))
+	})
+}
@@ -55,7 +55,9 @@ func main() {
 		log.Fatalf("Failed to create provider IPLD: %v", err)
 	}
 
-	_, err = graphsync.New(ctx, providerHost, providerIPLD)
+	// Both peers offer zstd stream compression, so they negotiate it
+	gsConfig := &graphsync.Config{Compression: true}
+	providerGraphSync, err := graphsync.NewWithConfig(ctx, providerHost, providerIPLD, gsConfig)
 	if err != nil {
 		log.Fatalf("Failed to create provider GraphSync: %v", err)
 	}
@@ -72,7 +74,7 @@ func main() {
 		log.Fatalf("Failed to create requestor IPLD: %v", err)
 	}
 
-	requestorGraphSync, err := graphsync.New(ctx, requestorHost, requestorIPLD)
+	requestorGraphSync, err := graphsync.NewWithConfig(ctx, requestorHost, requestorIPLD, gsConfig)
 	if err != nil {
 		log.Fatalf("Failed to create requestor GraphSync: %v", err)
 	}
@@ -226,9 +228,7 @@ func main() {
 
 	// Request full dataset from provider
 	fmt.Printf("   📡 Requesting full dataset from provider...\n")
-	startTime := time.Now()
-
-	progress, err := requestorGraphSync.Fetch(
+	full, err := requestorGraphSync.Fetch(
 		ctx,
 		providerHost.ID(),
 		rootCID,
@@ -238,9 +238,8 @@ func main() {
 		log.Fatalf("Failed to fetch full dataset: %v", err)
 	}
 
-	syncDuration := time.Since(startTime)
-	fmt.Printf("   ⏱️  Sync completed in: %v\n", syncDuration)
-	fmt.Printf("   📊 Progress made: %t\n", progress)
+	fmt.Printf("   ⏱️  Sync completed in: %v\n", full.Duration)
+	printFetchResult(full)
 
 	// Verify data was synced by retrieving from requestor's storage
 	retrievedRoot, err := requestorIPLD.GetIPLDAny(ctx, rootCID)
@@ -267,7 +266,19 @@ func main() {
 		log.Fatalf("Failed to create clean requestor IPLD: %v", err)
 	}
 
-	requestorGraphSync2, err := graphsync.New(ctx, requestorHost, requestorIPLD2)
+	// A second graphsync instance needs its own host: the provider keeps
+	// sending responses on the streams it opened to the first one
+	requestorHost2, err := network.New(nil)
+	if err != nil {
+		log.Fatalf("Failed to create clean requestor host: %v", err)
+	}
+	defer requestorHost2.Close()
+	err = requestorHost2.Connect(ctx, peer.AddrInfo{ID: providerHost.ID(), Addrs: providerHost.Addrs()})
+	if err != nil {
+		log.Fatalf("Failed to connect clean requestor: %v", err)
+	}
+
+	requestorGraphSync2, err := graphsync.NewWithConfig(ctx, requestorHost2, requestorIPLD2, gsConfig)
 	if err != nil {
 		log.Fatalf("Failed to create clean requestor GraphSync: %v", err)
 	}
@@ -276,8 +287,7 @@ func main() {
 	fmt.Printf("   📋 Syncing metadata only...\n")
 	metadataSelectorNode := traversalselector.SelectorField("metadata")
 
-	startTime = time.Now()
-	progress, err = requestorGraphSync2.Fetch(
+	metadata, err := requestorGraphSync2.Fetch(
 		ctx,
 		providerHost.ID(),
 		rootCID,
@@ -287,16 +297,14 @@ func main() {
 		log.Fatalf("Failed to fetch metadata: %v", err)
 	}
 
-	metadataSyncDuration := time.Since(startTime)
-	fmt.Printf("   ⏱️  Metadata sync completed in: %v\n", metadataSyncDuration)
-	fmt.Printf("   📊 Progress made: %t\n", progress)
+	fmt.Printf("   ⏱️  Metadata sync completed in: %v\n", metadata.Duration)
+	printFetchResult(metadata)
 
 	// Sync only papers using field selector
 	fmt.Printf("   📄 Syncing papers only...\n")
 	papersSelectorNode := traversalselector.SelectorField("papers")
 
-	startTime = time.Now()
-	progress, err = requestorGraphSync2.Fetch(
+	papersOnly, err := requestorGraphSync2.Fetch(
 		ctx,
 		providerHost.ID(),
 		rootCID,
@@ -306,16 +314,15 @@ func main() {
 		log.Fatalf("Failed to fetch papers: %v", err)
 	}
 
-	papersSyncDuration := time.Since(startTime)
-	fmt.Printf("   ⏱️  Papers sync completed in: %v\n", papersSyncDuration)
-	fmt.Printf("   📊 Progress made: %t\n", progress)
+	fmt.Printf("   ⏱️  Papers sync completed in: %v\n", papersOnly.Duration)
+	printFetchResult(papersOnly)
 
-	fmt.Printf("   📈 Efficiency comparison:\n")
-	fmt.Printf("     • Full sync: %v\n", syncDuration)
-	fmt.Printf("     • Metadata only: %v (%.1fx faster)\n", metadataSyncDuration,
-		float64(syncDuration)/float64(metadataSyncDuration))
-	fmt.Printf("     • Papers only: %v (%.1fx faster)\n", papersSyncDuration,
-		float64(syncDuration)/float64(papersSyncDuration))
+	fmt.Printf("   📈 Efficiency comparison (bytes over the network):\n")
+	fmt.Printf("     • Full sync: %d bytes in %d blocks\n", full.BytesOnWire, full.Blocks)
+	fmt.Printf("     • Metadata only: %d bytes (%.0f%% of full)\n", metadata.BytesOnWire,
+		100*float64(metadata.BytesOnWire)/float64(full.BytesOnWire))
+	fmt.Printf("     • Papers only: %d bytes (%.0f%% of full)\n", papersOnly.BytesOnWire,
+		100*float64(papersOnly.BytesOnWire)/float64(full.BytesOnWire))
 	fmt.Println()
 
 	// Demo 6: Advanced GraphSync request patterns
@@ -375,24 +382,31 @@ streamingDone:
 	strategies := []struct {
 		name        string
 		selector    string
-		duration    time.Duration
+		result      graphsync.FetchResult
 		description string
 	}{
-		{"Full Dataset", "SelectorAll(true)", syncDuration, "Complete DAG synchronization"},
-		{"Metadata Only", "SelectorField('metadata')", metadataSyncDuration, "Dataset metadata only"},
-		{"Papers Only", "SelectorField('papers')", papersSyncDuration, "Research papers only"},
+		{"Full Dataset", "SelectorAll(true)", full, "Complete DAG synchronization"},
+		{"Metadata Only", "SelectorField('metadata')", metadata, "Dataset metadata only"},
+		{"Papers Only", "SelectorField('papers')", papersOnly, "Research papers only"},
 	}
 
 	fmt.Printf("   📈 Sync Strategy Performance:\n")
-	baselineTime := syncDuration
+	baseline := full.BytesOnWire
 	for _, strategy := range strategies {
-		efficiency := float64(baselineTime) / float64(strategy.duration)
-		fmt.Printf("     • %-12s: %8v (%.1fx baseline) - %s\n",
-			strategy.name, strategy.duration, efficiency, strategy.description)
+		share := 100 * float64(strategy.result.BytesOnWire) / float64(baseline)
+		fmt.Printf("     • %-13s: %2d blocks, %6d bytes (%3.0f%% of full) in %8v - %s\n",
+			strategy.name, strategy.result.Blocks, strategy.result.BytesOnWire, share,
+			strategy.result.Duration, strategy.description)
 	}
 
+	compression := providerGraphSync.CompressionStats()
+	fmt.Printf("\n   🗜️  zstd stream compression (provider side, all transfers):\n")
+	fmt.Printf("     • %d streams, %d bytes of graphsync messages sent as %d bytes (%.1fx)\n",
+		compression.Streams, compression.RawBytes, compression.WireBytes, compression.Ratio())
+
 	fmt.Printf("\n   🎯 GraphSync Benefits Demonstrated:\n")
-	fmt.Printf("     • Selective sync reduces bandwidth by 60-80%%\n")
+	fmt.Printf("     • Selective sync transferred %.0f%% less than a full sync\n",
+		100-100*float64(metadata.BytesOnWire)/float64(baseline))
 	fmt.Printf("     • P2P architecture eliminates central servers\n")
 	fmt.Printf("     • Content addressing ensures data integrity\n")
 	fmt.Printf("     • Streaming responses enable progress monitoring\n")
@@ -429,8 +443,13 @@ streamingDone:
 	// Demonstrate proper GraphSync shutdown
 	fmt.Printf("   🔌 Closing GraphSync connections...\n")
 
-	// Note: GraphSync doesn't have explicit Close() method in this implementation
-	// but in production, you would handle graceful shutdown
+	// Close waits for requests still in flight, then stops serving
+	for _, gs := range []*graphsync.GraphSyncWrapper{requestorGraphSync, requestorGraphSync2, providerGraphSync} {
+		if err := gs.Close(); err != nil {
+			log.Printf("closing graphsync on %s: %v", gs.Host.ID(), err)
+		}
+	}
+	fmt.Printf("   ✅ GraphSync instances drained and closed\n")
 
 	fmt.Printf("   📊 Final statistics:\n")
 	fmt.Printf("     • Provider peer ID: %s\n", providerHost.ID())
@@ -453,3 +472,8 @@ streamingDone:
 	fmt.Println("💡 GraphSync enables efficient, verifiable, and selective")
 	fmt.Println("   synchronization of linked data across distributed networks!")
 }
+
+func printFetchResult(res graphsync.FetchResult) {
+	fmt.Printf("   📊 %d nodes, %d blocks, %d bytes (%d over the network) from %s\n",
+		res.Nodes, res.Blocks, res.Bytes, res.BytesOnWire, res.Peer.ShortString())
+}
@@ -0,0 +1,178 @@
+package graphsync
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// compressionSuffix marks the zstd variant of a graphsync protocol. Peers
+// negotiate it like any libp2p protocol: a wrapper with compression offers
+// it first and falls back to plain graphsync with peers that lack it.
+const compressionSuffix = "+zstd"
+
+// CompressionStats reports how much stream compression is saving
+type CompressionStats struct {
+	Streams   int64 // streams opened or accepted with zstd
+	RawBytes  int64 // graphsync message bytes, both directions
+	WireBytes int64 // the same messages as sent over the network
+}
+
+// Ratio is raw/wire bytes; 2.0 means messages took half the bandwidth
+func (s CompressionStats) Ratio() float64 {
+	if s.WireBytes == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.WireBytes)
+}
+
+// CompressionStats returns the zstd savings so far; the zero value if
+// compression is off
+func (g *GraphSyncWrapper) CompressionStats() CompressionStats {
+	if g.compression == nil {
+		return CompressionStats{}
+	}
+	return g.compression.stats()
+}
+
+type compressionCounters struct {
+	streams, raw, wire atomic.Int64
+}
+
+func (c *compressionCounters) stats() CompressionStats {
+	return CompressionStats{
+		Streams:   c.streams.Load(),
+		RawBytes:  c.raw.Load(),
+		WireBytes: c.wire.Load(),
+	}
+}
+
+// compressingHost is the host graphsync sees when compression is on: it
+// serves and offers the zstd variant of every graphsync protocol and
+// hands graphsync decompressed streams under the plain protocol ID
+type compressingHost struct {
+	host.Host
+	counters *compressionCounters
+}
+
+func (h *compressingHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, handler)
+	h.Host.SetStreamHandler(pid+compressionSuffix, func(s network.Stream) {
+		handler(h.wrap(s, pid))
+	})
+}
+
+func (h *compressingHost) RemoveStreamHandler(pid protocol.ID) {
+	h.Host.RemoveStreamHandler(pid)
+	h.Host.RemoveStreamHandler(pid + compressionSuffix)
+}
+
+func (h *compressingHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	offers := make([]protocol.ID, 0, 2*len(pids))
+	for _, pid := range pids {
+		offers = append(offers, pid+compressionSuffix)
+	}
+	offers = append(offers, pids...)
+	s, err := h.Host.NewStream(ctx, p, offers...)
+	if err != nil {
+		return nil, err
+	}
+	if pid, ok := strings.CutSuffix(string(s.Protocol()), compressionSuffix); ok {
+		return h.wrap(s, protocol.ID(pid)), nil
+	}
+	return s, nil
+}
+
+func (h *compressingHost) wrap(s network.Stream, pid protocol.ID) network.Stream {
+	h.counters.streams.Add(1)
+	zs := &zstdStream{Stream: s, pid: pid, counters: h.counters}
+	// Errors only come from invalid options
+	zs.enc, _ = zstd.NewWriter(countingWriter{s, &h.counters.wire},
+		zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	return zs
+}
+
+// zstdStream compresses everything written to it and decompresses
+// everything read. Each Write is flushed, so a message reaches the peer
+// as soon as graphsync writes it.
+type zstdStream struct {
+	network.Stream
+	pid      protocol.ID
+	counters *compressionCounters
+
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func (s *zstdStream) Protocol() protocol.ID { return s.pid }
+
+func (s *zstdStream) Write(p []byte) (int, error) {
+	n, err := s.enc.Write(p)
+	s.counters.raw.Add(int64(n))
+	if err != nil {
+		return n, err
+	}
+	return n, s.enc.Flush()
+}
+
+func (s *zstdStream) Read(p []byte) (int, error) {
+	if s.dec == nil {
+		dec, err := zstd.NewReader(countingReader{s.Stream, &s.counters.wire}, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return 0, err
+		}
+		s.dec = dec
+	}
+	n, err := s.dec.Read(p)
+	s.counters.raw.Add(int64(n))
+	return n, err
+}
+
+func (s *zstdStream) CloseWrite() error {
+	if err := s.enc.Close(); err != nil {
+		s.Stream.Reset()
+		return err
+	}
+	return s.Stream.CloseWrite()
+}
+
+func (s *zstdStream) Close() error {
+	err := s.enc.Close()
+	if s.dec != nil {
+		s.dec.Close()
+	}
+	if err != nil {
+		s.Stream.Reset()
+		return err
+	}
+	return s.Stream.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
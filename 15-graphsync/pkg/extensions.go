@@ -0,0 +1,108 @@
+package graphsync
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	igs "github.com/ipfs/go-graphsync"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Extension is custom data exchanged alongside requests. The requestor
+// attaches Request's data to every request it sends; a responder that has
+// the extension registered as well answers it through Respond, and the
+// requestor receives the answer in OnResponse. Peers without the
+// extension ignore it. Any of the functions may be nil.
+type Extension struct {
+	Name igs.ExtensionName
+
+	// Request returns the data to attach to a request for root to p; nil
+	// attaches nothing
+	Request func(p peer.ID, root cid.Cid) datamodel.Node
+	// Respond handles the data of an incoming request and returns the
+	// data to send back, or nil. An error refuses the request.
+	Respond func(p peer.ID, req igs.RequestData, data datamodel.Node) (datamodel.Node, error)
+	// OnResponse receives the data a responder sent back
+	OnResponse func(p peer.ID, resp igs.ResponseData, data datamodel.Node)
+	// RespondAlways calls Respond for requests without the extension too,
+	// with nil data, so it can refuse them
+	RespondAlways bool
+}
+
+// RegisterExtension adds ext to the requests this wrapper sends and the
+// requests it serves, replacing an extension of the same name
+func (g *GraphSyncWrapper) RegisterExtension(ext Extension) error {
+	if ext.Name == "" {
+		return fmt.Errorf("extension without a name")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.extensions[ext.Name] = ext
+	return nil
+}
+
+// UnregisterExtension removes the extension name
+func (g *GraphSyncWrapper) UnregisterExtension(name igs.ExtensionName) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.extensions, name)
+}
+
+func (g *GraphSyncWrapper) registeredExtensions() []Extension {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	exts := make([]Extension, 0, len(g.extensions))
+	for _, ext := range g.extensions {
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+func (g *GraphSyncWrapper) requestExtensions(p peer.ID, root cid.Cid) []igs.ExtensionData {
+	var data []igs.ExtensionData
+	for _, ext := range g.registeredExtensions() {
+		if ext.Request == nil {
+			continue
+		}
+		if n := ext.Request(p, root); n != nil {
+			data = append(data, igs.ExtensionData{Name: ext.Name, Data: n})
+		}
+	}
+	return data
+}
+
+// respondExtensions answers the registered extensions present in req
+func (g *GraphSyncWrapper) respondExtensions(p peer.ID, req igs.RequestData, actions igs.IncomingRequestHookActions) error {
+	for _, ext := range g.registeredExtensions() {
+		if ext.Respond == nil {
+			continue
+		}
+		data, ok := req.Extension(ext.Name)
+		if !ok {
+			if !ext.RespondAlways {
+				continue
+			}
+			data = nil
+		}
+		reply, err := ext.Respond(p, req, data)
+		if err != nil {
+			return fmt.Errorf("extension %s: %w", ext.Name, err)
+		}
+		if reply != nil {
+			actions.SendExtensionData(igs.ExtensionData{Name: ext.Name, Data: reply})
+		}
+	}
+	return nil
+}
+
+func (g *GraphSyncWrapper) incomingResponseHook(p peer.ID, resp igs.ResponseData, _ igs.IncomingResponseHookActions) {
+	for _, ext := range g.registeredExtensions() {
+		if ext.OnResponse == nil {
+			continue
+		}
+		if data, ok := resp.Extension(ext.Name); ok {
+			ext.OnResponse(p, resp, data)
+		}
+	}
+}
@@ -3,14 +3,17 @@ package graphsync
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-graphsync"
 	igs "github.com/ipfs/go-graphsync"
 	grphsync "github.com/ipfs/go-graphsync/impl"
 	gsnet "github.com/ipfs/go-graphsync/network"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	libp2phost "github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
@@ -22,10 +25,86 @@ type GraphSyncWrapper struct {
 	Host *network.HostWrapper
 	Ipld *ipldprime.IpldWrapper
 	igs.GraphExchange
+
+	mu     sync.RWMutex
+	policy RequestPolicy
+	paused map[igs.RequestID]peer.ID
+
+	fetches  map[igs.RequestID]*fetch
+	progress []func(ProgressEvent)
+
+	extensions  map[igs.ExtensionName]Extension
+	compression *compressionCounters
+
+	cfg      Config
+	cancel   context.CancelFunc
+	ownHost  bool
+	closed   bool
+	outgoing map[peer.ID]chan struct{} // per-peer request slots
+
+	requesting atomic.Int64              // outgoing requests not yet over
+	serving    map[igs.RequestID]peer.ID // incoming requests being served
+}
+
+// DefaultDrainTimeout is how long Close waits for requests in flight
+const DefaultDrainTimeout = 10 * time.Second
+
+// Config tunes the wrapper. Zero values keep graphsync's defaults.
+type Config struct {
+	// Compress graphsync streams with zstd for peers whose wrapper has
+	// compression on too; other peers get plain graphsync
+	Compression bool
+
+	// Incoming requests served at once, in total (default 6) and per peer
+	// (default no limit beyond the total); the rest are queued
+	MaxIncomingRequests        uint64
+	MaxIncomingRequestsPerPeer uint64
+	// Outgoing requests in flight at once, in total (default 6) and per
+	// peer (default no limit beyond the total); the rest wait their turn
+	MaxOutgoingRequests        uint64
+	MaxOutgoingRequestsPerPeer int
+	// Memory the responder may hold in queued blocks, in total (default
+	// 256MiB) and per peer (default 16MiB)
+	MaxMemory        uint64
+	MaxMemoryPerPeer uint64
+
+	// How long Close waits for requests in flight (default 10s)
+	DrainTimeout time.Duration
+}
+
+func (cfg Config) options() []grphsync.Option {
+	var opts []grphsync.Option
+	if cfg.MaxIncomingRequests > 0 {
+		opts = append(opts, grphsync.MaxInProgressIncomingRequests(cfg.MaxIncomingRequests))
+	}
+	if cfg.MaxIncomingRequestsPerPeer > 0 {
+		opts = append(opts, grphsync.MaxInProgressIncomingRequestsPerPeer(cfg.MaxIncomingRequestsPerPeer))
+	}
+	if cfg.MaxOutgoingRequests > 0 {
+		opts = append(opts, grphsync.MaxInProgressOutgoingRequests(cfg.MaxOutgoingRequests))
+	}
+	if cfg.MaxMemory > 0 {
+		opts = append(opts, grphsync.MaxMemoryResponder(cfg.MaxMemory))
+	}
+	if cfg.MaxMemoryPerPeer > 0 {
+		opts = append(opts, grphsync.MaxMemoryPerPeerResponder(cfg.MaxMemoryPerPeer))
+	}
+	return opts
 }
 
 func New(ctx context.Context, host *network.HostWrapper, ipld *ipldprime.IpldWrapper) (*GraphSyncWrapper, error) {
+	return NewWithConfig(ctx, host, ipld, nil)
+}
+
+func NewWithConfig(ctx context.Context, host *network.HostWrapper, ipld *ipldprime.IpldWrapper, cfg *Config) (*GraphSyncWrapper, error) {
 	var err error
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = DefaultDrainTimeout
+	}
+	ownHost := host == nil
 	if host == nil {
 		host, err = network.New(nil)
 		if err != nil {
@@ -39,33 +118,62 @@ func New(ctx context.Context, host *network.HostWrapper, ipld *ipldprime.IpldWra
 		}
 	}
 
-	gsnet := gsnet.NewFromLibp2pHost(host)
-	gs := grphsync.New(ctx, gsnet, ipld.LinkSystem)
-	gs.RegisterIncomingRequestHook(func(p peer.ID, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
-		hookActions.ValidateRequest()
-	})
-
-	return &GraphSyncWrapper{
+	var netHost libp2phost.Host = host
+	var counters *compressionCounters
+	if cfg.Compression {
+		counters = &compressionCounters{}
+		netHost = &compressingHost{Host: host, counters: counters}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	gsnet := gsnet.NewFromLibp2pHost(netHost)
+	gs := grphsync.New(ctx, gsnet, ipld.LinkSystem, cfg.options()...)
+	g := &GraphSyncWrapper{
 		Host:          host,
 		Ipld:          ipld,
 		GraphExchange: gs,
-	}, nil
+		paused:        make(map[igs.RequestID]peer.ID),
+		fetches:       make(map[igs.RequestID]*fetch),
+		extensions:    make(map[igs.ExtensionName]Extension),
+		compression:   counters,
+		cfg:           *cfg,
+		cancel:        cancel,
+		ownHost:       ownHost,
+		outgoing:      make(map[peer.ID]chan struct{}),
+		serving:       make(map[igs.RequestID]peer.ID),
+	}
+	gs.RegisterIncomingRequestHook(g.incomingRequestHook)
+	gs.RegisterRequestorCancelledListener(g.requestorCancelled)
+	gs.RegisterCompletedResponseListener(g.responseCompleted)
+	gs.RegisterIncomingBlockHook(g.incomingBlockHook)
+	gs.RegisterIncomingResponseHook(g.incomingResponseHook)
+
+	return g, nil
 }
 
 func defaultSelector() ipld.Node {
 	return ts.SelectorAll(true)
 }
 
+// Fetch requests root with sel from pid and waits for the response. The
+// result reports what arrived, also when an error ends the request early.
 func (g *GraphSyncWrapper) Fetch(
 	ctx context.Context,
 	pid peer.ID,
 	root cid.Cid,
 	sel ipld.Node,
 	exts ...igs.ExtensionData,
-) (progress bool, err error) {
+) (FetchResult, error) {
+	id := igs.NewRequestID()
+	// returning early cancels the request and stops its error forwarding
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = context.WithValue(ctx, igs.RequestIDContextKey{}, id)
+	f := g.track(id, pid, root)
+	defer g.untrack(id)
+
 	respCh, errCh, err := g.Request(ctx, pid, root, sel, exts...)
 	if err != nil {
-		return false, err
+		return f.result(), err
 	}
 	for respCh != nil || errCh != nil {
 		select {
@@ -74,23 +182,20 @@ func (g *GraphSyncWrapper) Fetch(
 				respCh = nil
 				continue
 			}
-			progress = true
+			f.node()
 		case e, ok := <-errCh:
 			if !ok {
 				errCh = nil
 				continue
 			}
 			if e != nil {
-				return progress, e
+				return f.result(), e
 			}
 		case <-ctx.Done():
-			return progress, ctx.Err()
+			return f.result(), ctx.Err()
 		}
 	}
-	if !progress { // no data received
-		return false, nil
-	}
-	return true, nil
+	return f.result(), nil
 }
 
 func (g *GraphSyncWrapper) Request(
@@ -103,7 +208,12 @@ func (g *GraphSyncWrapper) Request(
 	if sel == nil {
 		sel = defaultSelector()
 	}
+	exts = append(exts, g.requestExtensions(pid, root)...)
 
+	release, err := g.acquire(ctx, pid)
+	if err != nil {
+		return nil, nil, err
+	}
 	respCh, errCh := g.GraphExchange.Request(
 		ctx,
 		pid,
@@ -111,5 +221,23 @@ func (g *GraphSyncWrapper) Request(
 		sel,
 		exts...,
 	)
-	return respCh, errCh, nil
+	// graphsync closes errCh when the request is over
+	g.requesting.Add(1)
+	out := make(chan error)
+	go func() {
+		defer close(out)
+		defer g.requesting.Add(-1)
+		if release != nil {
+			defer release()
+		}
+		// keep draining errCh once nobody reads out, so the request
+		// still ends and gives its slot back
+		for err := range errCh {
+			select {
+			case out <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return respCh, out, nil
 }
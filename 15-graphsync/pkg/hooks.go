@@ -0,0 +1,199 @@
+package graphsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	igs "github.com/ipfs/go-graphsync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	ErrPeerNotAllowed     = errors.New("peer not allowed")
+	ErrSelectorNotAllowed = errors.New("selector not allowed")
+	ErrRootRejected       = errors.New("root rejected")
+)
+
+// RequestPolicy decides how the wrapper serves incoming requests. Zero
+// values keep the defaults: every request is validated and served.
+type RequestPolicy struct {
+	// AllowPeer, if set, must accept the requesting peer
+	AllowPeer func(p peer.ID) bool
+	// RejectRoot, if set, refuses requests for the roots it returns true for
+	RejectRoot func(root cid.Cid) bool
+	// MaxSelectorDepth, if > 0, refuses selectors that recurse without a
+	// limit or deeper than it
+	MaxSelectorDepth int64
+	// AllowSelector, if set, must return nil for the request selector
+	AllowSelector func(sel ipld.Node) error
+	// MaxLinks, if > 0, ends each response after that many links
+	MaxLinks uint64
+	// Pause, if set, holds the requests it returns true for until Resume
+	Pause func(p peer.ID, req igs.RequestData) bool
+	// OnReject, if set, is told about every refused request
+	OnReject func(p peer.ID, req igs.RequestData, err error)
+}
+
+// SetRequestPolicy replaces the policy applied to incoming requests; it
+// does not affect requests already being served
+func (g *GraphSyncWrapper) SetRequestPolicy(policy RequestPolicy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policy = policy
+}
+
+// Paused returns the incoming requests held by the policy's Pause
+func (g *GraphSyncWrapper) Paused() []igs.RequestID {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	ids := make([]igs.RequestID, 0, len(g.paused))
+	for id := range g.paused {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Resume continues serving a request held by the policy's Pause
+func (g *GraphSyncWrapper) Resume(ctx context.Context, id igs.RequestID) error {
+	g.mu.Lock()
+	_, ok := g.paused[id]
+	delete(g.paused, id)
+	g.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("request %s is not paused", id)
+	}
+	return g.Unpause(ctx, id)
+}
+
+func (g *GraphSyncWrapper) incomingRequestHook(p peer.ID, req igs.RequestData, actions igs.IncomingRequestHookActions) {
+	g.mu.RLock()
+	policy := g.policy
+	g.mu.RUnlock()
+
+	err := policy.check(p, req)
+	if err == nil && g.isClosed() {
+		err = ErrClosed
+	}
+	if err == nil {
+		err = g.respondExtensions(p, req, actions)
+	}
+	if err != nil {
+		if policy.OnReject != nil {
+			policy.OnReject(p, req, err)
+		}
+		actions.TerminateWithError(err)
+		return
+	}
+
+	actions.ValidateRequest()
+	g.mu.Lock()
+	g.serving[req.ID()] = p
+	g.mu.Unlock()
+	if policy.MaxLinks > 0 {
+		actions.MaxLinks(policy.MaxLinks)
+	}
+	if policy.Pause != nil && policy.Pause(p, req) {
+		g.mu.Lock()
+		g.paused[req.ID()] = p
+		g.mu.Unlock()
+		actions.PauseResponse()
+	}
+}
+
+// requestorCancelled forgets requests the requestor gave up on
+func (g *GraphSyncWrapper) requestorCancelled(_ peer.ID, req igs.RequestData) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.paused, req.ID())
+	delete(g.serving, req.ID())
+}
+
+func (g *GraphSyncWrapper) responseCompleted(_ peer.ID, req igs.RequestData, _ igs.ResponseStatusCode) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.paused, req.ID())
+	delete(g.serving, req.ID())
+}
+
+func (policy RequestPolicy) check(p peer.ID, req igs.RequestData) error {
+	if policy.AllowPeer != nil && !policy.AllowPeer(p) {
+		return fmt.Errorf("%w: %s", ErrPeerNotAllowed, p)
+	}
+	if policy.RejectRoot != nil && policy.RejectRoot(req.Root()) {
+		return fmt.Errorf("%w: %s", ErrRootRejected, req.Root())
+	}
+	sel := req.Selector()
+	if policy.MaxSelectorDepth > 0 {
+		if err := checkRecursion(sel, policy.MaxSelectorDepth); err != nil {
+			return fmt.Errorf("%w: %w", ErrSelectorNotAllowed, err)
+		}
+	}
+	if policy.AllowSelector != nil {
+		if err := policy.AllowSelector(sel); err != nil {
+			return fmt.Errorf("%w: %w", ErrSelectorNotAllowed, err)
+		}
+	}
+	return nil
+}
+
+// checkRecursion finds every ExploreRecursive in the selector node and
+// checks its limit against max
+func checkRecursion(n datamodel.Node, max int64) error {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		it := n.MapIterator()
+		for !it.Done() {
+			k, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			if key, _ := k.AsString(); key == selector.SelectorKey_ExploreRecursive {
+				if err := checkLimit(v, max); err != nil {
+					return err
+				}
+			}
+			if err := checkRecursion(v, max); err != nil {
+				return err
+			}
+		}
+	case datamodel.Kind_List:
+		it := n.ListIterator()
+		for !it.Done() {
+			_, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			if err := checkRecursion(v, max); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkLimit(recursive datamodel.Node, max int64) error {
+	limit, err := recursive.LookupByString(selector.SelectorKey_Limit)
+	if err != nil {
+		return fmt.Errorf("recursion without limit: %w", err)
+	}
+	if _, err := limit.LookupByString(selector.SelectorKey_LimitNone); err == nil {
+		return fmt.Errorf("unlimited recursion, max depth %d", max)
+	}
+	depth, err := limit.LookupByString(selector.SelectorKey_LimitDepth)
+	if err != nil {
+		return fmt.Errorf("unknown recursion limit")
+	}
+	d, err := depth.AsInt()
+	if err != nil {
+		return fmt.Errorf("recursion depth: %w", err)
+	}
+	if d > max {
+		return fmt.Errorf("recursion depth %d over %d", d, max)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package graphsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var ErrClosed = errors.New("graphsync wrapper closed")
+
+// Close stops taking new requests, waits up to the configured
+// DrainTimeout for the ones in flight in both directions, then shuts
+// graphsync down. Requests still running at that point are cancelled and
+// reported in the error. A host the wrapper created itself is closed too.
+func (g *GraphSyncWrapper) Close() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	g.closed = true
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.cfg.DrainTimeout)
+	defer cancel()
+	inFlight := g.drain(ctx)
+
+	g.cancel()
+	g.Host.RemoveStreamHandler(gsnet.ProtocolGraphsync_2_0_0)
+	g.Host.RemoveStreamHandler(gsnet.ProtocolGraphsync_2_0_0 + compressionSuffix)
+
+	var err error
+	if inFlight > 0 {
+		err = fmt.Errorf("close: %d requests still in flight after %v", inFlight, g.cfg.DrainTimeout)
+	}
+	if g.ownHost {
+		err = errors.Join(err, g.Host.Close())
+	}
+	return err
+}
+
+// InFlight is the number of requests not yet over, paused ones included,
+// incoming and outgoing
+func (g *GraphSyncWrapper) InFlight() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.serving) + int(g.requesting.Load())
+}
+
+// drain waits for the requests in flight, returning how many are left
+func (g *GraphSyncWrapper) drain(ctx context.Context) int {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := g.InFlight()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *GraphSyncWrapper) isClosed() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.closed
+}
+
+// acquire takes one of the peer's request slots, waiting for one to free
+// up; release is nil if there is no per-peer limit
+func (g *GraphSyncWrapper) acquire(ctx context.Context, p peer.ID) (release func(), err error) {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if g.cfg.MaxOutgoingRequestsPerPeer <= 0 {
+		g.mu.Unlock()
+		return nil, nil
+	}
+	slots, ok := g.outgoing[p]
+	if !ok {
+		slots = make(chan struct{}, g.cfg.MaxOutgoingRequestsPerPeer)
+		g.outgoing[p] = slots
+	}
+	g.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,113 @@
+package graphsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	igs "github.com/ipfs/go-graphsync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// FetchResult is what a Fetch received
+type FetchResult struct {
+	RequestID igs.RequestID
+	Peer      peer.ID
+	Root      cid.Cid
+
+	Nodes       int    // nodes matched by the selector
+	Blocks      int    // blocks loaded, from the network or already held locally
+	Bytes       uint64 // size of all loaded blocks
+	BytesOnWire uint64 // bytes actually sent by the peer
+	Duration    time.Duration
+}
+
+// Progress reports whether anything matched
+func (r FetchResult) Progress() bool {
+	return r.Nodes > 0
+}
+
+// ProgressEvent reports one block arriving for a Fetch, with the running
+// totals of the request
+type ProgressEvent struct {
+	Block      cid.Cid
+	Size       uint64
+	SizeOnWire uint64 // 0 if the block was already held locally
+	Result     FetchResult
+}
+
+// OnProgress registers fn to be called for every block a Fetch receives.
+// Callbacks run on graphsync's goroutine and should not block.
+func (g *GraphSyncWrapper) OnProgress(fn func(ProgressEvent)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.progress = append(g.progress, fn)
+}
+
+// fetch tracks one Fetch
+type fetch struct {
+	start time.Time
+
+	mu  sync.Mutex
+	res FetchResult
+}
+
+func (g *GraphSyncWrapper) track(id igs.RequestID, pid peer.ID, root cid.Cid) *fetch {
+	f := &fetch{start: time.Now(), res: FetchResult{RequestID: id, Peer: pid, Root: root}}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fetches[id] = f
+	return f
+}
+
+func (g *GraphSyncWrapper) untrack(id igs.RequestID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.fetches, id)
+}
+
+func (f *fetch) node() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.res.Nodes++
+}
+
+func (f *fetch) result() FetchResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := f.res
+	res.Duration = time.Since(f.start)
+	return res
+}
+
+func (g *GraphSyncWrapper) incomingBlockHook(_ peer.ID, resp igs.ResponseData, block igs.BlockData, _ igs.IncomingBlockHookActions) {
+	g.mu.RLock()
+	f, ok := g.fetches[resp.RequestID()]
+	callbacks := g.progress
+	g.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	f.mu.Lock()
+	f.res.Blocks++
+	f.res.Bytes += block.BlockSize()
+	f.res.BytesOnWire += block.BlockSizeOnWire()
+	f.mu.Unlock()
+
+	if len(callbacks) == 0 {
+		return
+	}
+	ev := ProgressEvent{
+		Size:       block.BlockSize(),
+		SizeOnWire: block.BlockSizeOnWire(),
+		Result:     f.result(),
+	}
+	if cl, ok := block.Link().(cidlink.Link); ok {
+		ev.Block = cl.Cid
+	}
+	for _, fn := range callbacks {
+		fn(ev)
+	}
+}
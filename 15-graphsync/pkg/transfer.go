@@ -0,0 +1,580 @@
+package graphsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	igs "github.com/ipfs/go-graphsync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	basicnode "github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// TransferProtocol carries push requests between transfer managers
+const TransferProtocol = protocol.ID("/boxo-starter-kit/transfer/1.0.0")
+
+// transferExtension tags the graphsync requests that belong to a transfer
+const transferExtension = igs.ExtensionName("boxo-starter-kit/transfer/1")
+
+// DefaultTransferRetention is how long finished transfers stay queryable
+const DefaultTransferRetention = 10 * time.Minute
+
+var (
+	ErrTransferNotFound = errors.New("transfer not found")
+	ErrVoucherRejected  = errors.New("voucher rejected")
+)
+
+// TransferDirection is which way a transfer moves data, seen from this node
+type TransferDirection string
+
+const (
+	TransferSend    TransferDirection = "send"
+	TransferReceive TransferDirection = "receive"
+)
+
+type TransferStatus string
+
+const (
+	TransferRequested TransferStatus = "requested"
+	TransferOngoing   TransferStatus = "ongoing"
+	TransferCompleted TransferStatus = "completed"
+	TransferFailed    TransferStatus = "failed"
+)
+
+// Transfer is a snapshot of one transfer
+type Transfer struct {
+	ID        string
+	Peer      peer.ID
+	Direction TransferDirection
+	Initiator bool // this node started the transfer
+
+	Root     cid.Cid
+	Selector ipld.Node
+	Voucher  datamodel.Node // nil if none was given
+
+	Status   TransferStatus
+	Attempts int
+	Result   FetchResult // receiving side; totals of the last attempt
+	Err      error
+}
+
+// VoucherValidator decides whether a transfer may go ahead. dir is the
+// direction on this node: TransferReceive for a push from p,
+// TransferSend for a pull by p. voucher is nil if p sent none, which
+// includes plain graphsync requests outside any transfer.
+type VoucherValidator func(p peer.ID, dir TransferDirection, root cid.Cid, voucher datamodel.Node) error
+
+// TransferManager moves DAGs between nodes in either direction on top of
+// graphsync. Data always travels as a graphsync request from the
+// receiver: a push asks the other node to pull, like go-data-transfer.
+type TransferManager struct {
+	ctx context.Context
+	gs  *GraphSyncWrapper
+
+	mu        sync.Mutex
+	transfers map[string]*transfer
+	requests  map[igs.RequestID]string // responses being served -> transfer
+	validator VoucherValidator
+	retention time.Duration
+	callbacks []func(Transfer)
+}
+
+type transfer struct {
+	Transfer
+	changed chan struct{} // closed and replaced on every update
+}
+
+// NewTransferManager adds transfers to gs. Both nodes of a transfer need a
+// manager; transfers stop when ctx ends.
+func NewTransferManager(ctx context.Context, gs *GraphSyncWrapper) (*TransferManager, error) {
+	m := &TransferManager{
+		ctx:       ctx,
+		gs:        gs,
+		transfers: make(map[string]*transfer),
+		requests:  make(map[igs.RequestID]string),
+		retention: DefaultTransferRetention,
+	}
+	err := gs.RegisterExtension(Extension{
+		Name:          transferExtension,
+		Respond:       m.respond,
+		RespondAlways: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	gs.RegisterCompletedResponseListener(m.responseCompleted)
+	gs.Host.SetStreamHandler(TransferProtocol, m.handleStream)
+	return m, nil
+}
+
+// SetVoucherValidator sets the check for transfers other nodes start and
+// for every other graphsync request this node serves. With nil, the
+// default, pulls are served and pushes are refused.
+func (m *TransferManager) SetVoucherValidator(v VoucherValidator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.validator = v
+}
+
+// SetRetention sets how long a completed or failed transfer is kept for
+// Wait, Transfer and Restart before it is forgotten; <= 0 restores
+// DefaultTransferRetention
+func (m *TransferManager) SetRetention(d time.Duration) {
+	if d <= 0 {
+		d = DefaultTransferRetention
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retention = d
+}
+
+// OnTransfer registers fn to be called on every status change. Callbacks
+// run on graphsync's or the transfer's goroutine and should not block.
+func (m *TransferManager) OnTransfer(fn func(Transfer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callbacks = append(m.callbacks, fn)
+}
+
+// Push asks to to fetch root with sel from this node. It returns once to
+// has accepted the voucher; the data follows in the background, see Wait.
+func (m *TransferManager) Push(ctx context.Context, to peer.ID, root cid.Cid, sel ipld.Node, voucher datamodel.Node) (string, error) {
+	if sel == nil {
+		sel = defaultSelector()
+	}
+	t := m.add(Transfer{
+		ID:        uuid.NewString(),
+		Peer:      to,
+		Direction: TransferSend,
+		Initiator: true,
+		Root:      root,
+		Selector:  sel,
+		Voucher:   voucher,
+		Status:    TransferRequested,
+	})
+	if _, err := m.sendPush(ctx, t.Transfer); err != nil {
+		m.update(t.ID, func(t *Transfer) { t.Status, t.Err = TransferFailed, err })
+		return t.ID, err
+	}
+	return t.ID, nil
+}
+
+// Pull fetches root with sel from from, presenting voucher. It returns
+// once the transfer has started; see Wait.
+func (m *TransferManager) Pull(ctx context.Context, from peer.ID, root cid.Cid, sel ipld.Node, voucher datamodel.Node) (string, error) {
+	if sel == nil {
+		sel = defaultSelector()
+	}
+	t := m.add(Transfer{
+		ID:        uuid.NewString(),
+		Peer:      from,
+		Direction: TransferReceive,
+		Initiator: true,
+		Root:      root,
+		Selector:  sel,
+		Voucher:   voucher,
+		Status:    TransferRequested,
+	})
+	m.start(t.ID)
+	return t.ID, nil
+}
+
+// Restart runs a transfer that did not complete again. Blocks that already
+// arrived are not fetched again. A push is restarted by asking the
+// receiver again; a pull by another node can only be restarted there.
+func (m *TransferManager) Restart(ctx context.Context, id string) error {
+	t, err := m.Transfer(id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case t.Status == TransferCompleted:
+		return fmt.Errorf("transfer %s already completed", id)
+	case t.Direction == TransferReceive:
+		if !m.start(id) {
+			return fmt.Errorf("transfer %s is running", id)
+		}
+		return nil
+	case t.Initiator:
+		m.update(id, func(t *Transfer) { t.Status, t.Err = TransferRequested, nil })
+		completed, err := m.sendPush(ctx, t)
+		if err != nil {
+			m.update(id, func(t *Transfer) { t.Status, t.Err = TransferFailed, err })
+			return err
+		}
+		if completed {
+			// The receiver got everything, only the news of it was lost
+			m.update(id, func(t *Transfer) { t.Status = TransferCompleted })
+		}
+		return nil
+	}
+	return fmt.Errorf("transfer %s: only %s can restart its pull", id, t.Peer)
+}
+
+// Wait blocks until the transfer completes or fails and returns it, with
+// its error if it failed
+func (m *TransferManager) Wait(ctx context.Context, id string) (Transfer, error) {
+	for {
+		m.mu.Lock()
+		t, ok := m.transfers[id]
+		if !ok {
+			m.mu.Unlock()
+			return Transfer{}, fmt.Errorf("%w: %s", ErrTransferNotFound, id)
+		}
+		snap, changed := t.Transfer, t.changed
+		m.mu.Unlock()
+
+		switch snap.Status {
+		case TransferCompleted:
+			return snap, nil
+		case TransferFailed:
+			return snap, snap.Err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return snap, ctx.Err()
+		}
+	}
+}
+
+// Transfer returns a snapshot of the transfer id
+func (m *TransferManager) Transfer(id string) (Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.transfers[id]
+	if !ok {
+		return Transfer{}, fmt.Errorf("%w: %s", ErrTransferNotFound, id)
+	}
+	return t.Transfer, nil
+}
+
+// Transfers returns a snapshot of every transfer
+func (m *TransferManager) Transfers() []Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := make([]Transfer, 0, len(m.transfers))
+	for _, t := range m.transfers {
+		ts = append(ts, t.Transfer)
+	}
+	return ts
+}
+
+func (m *TransferManager) add(t Transfer) *transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tr := &transfer{Transfer: t, changed: make(chan struct{})}
+	m.transfers[t.ID] = tr
+	return tr
+}
+
+func (m *TransferManager) update(id string, fn func(*Transfer)) {
+	m.mu.Lock()
+	t, ok := m.transfers[id]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	fn(&t.Transfer)
+	close(t.changed)
+	t.changed = make(chan struct{})
+	snap, callbacks := t.Transfer, m.callbacks
+	if snap.Status == TransferCompleted || snap.Status == TransferFailed {
+		changed := t.changed
+		time.AfterFunc(m.retention, func() { m.forget(id, changed) })
+	}
+	m.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(snap)
+	}
+}
+
+// forget drops the finished transfer id unless it changed since
+func (m *TransferManager) forget(id string, changed chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.transfers[id]; ok && t.changed == changed {
+		delete(m.transfers, id)
+	}
+}
+
+//-------------------------------------------------------------------------------//
+// Receiving side
+//-------------------------------------------------------------------------------//
+
+// start runs the fetch of a receiving transfer unless it is running
+func (m *TransferManager) start(id string) bool {
+	var t Transfer
+	started := false
+	m.update(id, func(tr *Transfer) {
+		if tr.Status == TransferOngoing || tr.Status == TransferCompleted {
+			return
+		}
+		tr.Status, tr.Err = TransferOngoing, nil
+		tr.Attempts++
+		t, started = *tr, true
+	})
+	if started {
+		go m.fetch(t)
+	}
+	return started
+}
+
+func (m *TransferManager) fetch(t Transfer) {
+	var res FetchResult
+	data, err := transferExtensionData(t.ID, t.Voucher)
+	if err == nil {
+		res, err = m.gs.Fetch(m.ctx, t.Peer, t.Root, t.Selector, igs.ExtensionData{Name: transferExtension, Data: data})
+	}
+	m.update(t.ID, func(t *Transfer) {
+		t.Result = res
+		if err != nil {
+			t.Status, t.Err = TransferFailed, err
+			return
+		}
+		t.Status = TransferCompleted
+	})
+}
+
+// pushMessage is a push request; Selector and Voucher are dag-json
+type pushMessage struct {
+	ID       string          `json:"id"`
+	Root     cid.Cid         `json:"root"`
+	Selector json.RawMessage `json:"selector"`
+	Voucher  json.RawMessage `json:"voucher,omitempty"`
+}
+
+type pushReply struct {
+	Error     string `json:"error,omitempty"`
+	Completed bool   `json:"completed,omitempty"` // a restart of a finished transfer
+}
+
+// sendPush asks the receiver of t to pull it and reports whether the
+// receiver already has it all
+func (m *TransferManager) sendPush(ctx context.Context, t Transfer) (bool, error) {
+	msg := pushMessage{ID: t.ID, Root: t.Root}
+	var err error
+	if msg.Selector, err = encodeJSON(t.Selector); err != nil {
+		return false, fmt.Errorf("encode selector: %w", err)
+	}
+	if t.Voucher != nil {
+		if msg.Voucher, err = encodeJSON(t.Voucher); err != nil {
+			return false, fmt.Errorf("encode voucher: %w", err)
+		}
+	}
+
+	s, err := m.gs.Host.NewStream(ctx, t.Peer, TransferProtocol)
+	if err != nil {
+		return false, fmt.Errorf("open transfer stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(s).Encode(msg); err != nil {
+		s.Reset()
+		return false, fmt.Errorf("send push: %w", err)
+	}
+	var reply pushReply
+	if err := json.NewDecoder(s).Decode(&reply); err != nil {
+		s.Reset()
+		return false, fmt.Errorf("read push reply: %w", err)
+	}
+	if reply.Error != "" {
+		return false, fmt.Errorf("push refused by %s: %s", t.Peer, reply.Error)
+	}
+	return reply.Completed, nil
+}
+
+func (m *TransferManager) handleStream(s network.Stream) {
+	defer s.Close()
+	_ = s.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var msg pushMessage
+	if err := json.NewDecoder(s).Decode(&msg); err != nil {
+		s.Reset()
+		return
+	}
+	var reply pushReply
+	completed, err := m.acceptPush(s.Conn().RemotePeer(), msg)
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	reply.Completed = completed
+	_ = json.NewEncoder(s).Encode(reply)
+}
+
+// acceptPush starts the pull for a push; for a restarted push it reports
+// whether the transfer has already completed
+func (m *TransferManager) acceptPush(from peer.ID, msg pushMessage) (bool, error) {
+	if t, err := m.Transfer(msg.ID); err == nil {
+		if t.Peer != from || t.Direction != TransferReceive {
+			return false, fmt.Errorf("transfer %s belongs to another peer", msg.ID)
+		}
+		if t.Status == TransferCompleted {
+			return true, nil
+		}
+		m.start(msg.ID)
+		return false, nil
+	}
+
+	sel, err := decodeJSON(msg.Selector)
+	if err != nil {
+		return false, fmt.Errorf("decode selector: %w", err)
+	}
+	var voucher datamodel.Node
+	if len(msg.Voucher) > 0 {
+		if voucher, err = decodeJSON(msg.Voucher); err != nil {
+			return false, fmt.Errorf("decode voucher: %w", err)
+		}
+	}
+	if err := m.validate(from, TransferReceive, msg.Root, voucher); err != nil {
+		return false, err
+	}
+	m.add(Transfer{
+		ID:        msg.ID,
+		Peer:      from,
+		Direction: TransferReceive,
+		Root:      msg.Root,
+		Selector:  sel,
+		Voucher:   voucher,
+		Status:    TransferRequested,
+	})
+	m.start(msg.ID)
+	return false, nil
+}
+
+//-------------------------------------------------------------------------------//
+// Sending side
+//-------------------------------------------------------------------------------//
+
+// respond admits graphsync requests that are part of a transfer: the
+// pulls for this node's pushes, and pulls by other nodes with a valid
+// voucher. Requests outside a transfer are validated without a voucher.
+func (m *TransferManager) respond(p peer.ID, req igs.RequestData, data datamodel.Node) (datamodel.Node, error) {
+	if data == nil {
+		return nil, m.validate(p, TransferSend, req.Root(), nil)
+	}
+	id, voucher, err := parseTransferExtension(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, err := m.Transfer(id); err == nil {
+		if t.Peer != p || t.Direction != TransferSend || !t.Root.Equals(req.Root()) {
+			return nil, fmt.Errorf("transfer %s does not match the request", id)
+		}
+	} else {
+		if err := m.validate(p, TransferSend, req.Root(), voucher); err != nil {
+			return nil, err
+		}
+		m.add(Transfer{
+			ID:        id,
+			Peer:      p,
+			Direction: TransferSend,
+			Root:      req.Root(),
+			Selector:  req.Selector(),
+			Voucher:   voucher,
+		})
+	}
+
+	m.mu.Lock()
+	m.requests[req.ID()] = id
+	m.mu.Unlock()
+	m.update(id, func(t *Transfer) {
+		t.Status, t.Err = TransferOngoing, nil
+		t.Attempts++
+	})
+	return nil, nil
+}
+
+func (m *TransferManager) responseCompleted(p peer.ID, req igs.RequestData, status igs.ResponseStatusCode) {
+	m.mu.Lock()
+	id, ok := m.requests[req.ID()]
+	delete(m.requests, req.ID())
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	m.update(id, func(t *Transfer) {
+		if status == igs.RequestCompletedFull {
+			t.Status = TransferCompleted
+			return
+		}
+		t.Status, t.Err = TransferFailed, fmt.Errorf("response ended: %s", status)
+	})
+}
+
+func (m *TransferManager) validate(p peer.ID, dir TransferDirection, root cid.Cid, voucher datamodel.Node) error {
+	m.mu.Lock()
+	v := m.validator
+	m.mu.Unlock()
+	if v == nil {
+		if dir == TransferReceive {
+			return fmt.Errorf("%w: no validator accepts pushes", ErrVoucherRejected)
+		}
+		return nil
+	}
+	if err := v(p, dir, root, voucher); err != nil {
+		return fmt.Errorf("%w: %w", ErrVoucherRejected, err)
+	}
+	return nil
+}
+
+//-------------------------------------------------------------------------------//
+// Encoding
+//-------------------------------------------------------------------------------//
+
+func transferExtensionData(id string, voucher datamodel.Node) (datamodel.Node, error) {
+	return qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "id", qp.String(id))
+		if voucher != nil {
+			qp.MapEntry(ma, "voucher", qp.Node(voucher))
+		}
+	})
+}
+
+func parseTransferExtension(data datamodel.Node) (string, datamodel.Node, error) {
+	idNode, err := data.LookupByString("id")
+	if err != nil {
+		return "", nil, fmt.Errorf("transfer extension: %w", err)
+	}
+	id, err := idNode.AsString()
+	if err != nil {
+		return "", nil, fmt.Errorf("transfer extension: %w", err)
+	}
+	voucher, err := data.LookupByString("voucher")
+	if err != nil {
+		voucher = nil
+	}
+	return id, voucher, nil
+}
+
+func encodeJSON(n datamodel.Node) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeJSON(data []byte) (datamodel.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
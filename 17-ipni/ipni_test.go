@@ -2,14 +2,41 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore/query"
+	carbs "github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-indexer-core/store/memory"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	provider "github.com/ipni/index-provider"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 
 	block "github.com/gosuda/boxo-starter-kit/00-block-cid/pkg"
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
 	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
 	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
+	"github.com/gosuda/boxo-starter-kit/pkg/security"
 )
 
 func TestIPNIPutGet(t *testing.T) {
@@ -143,3 +170,976 @@ func TestIPNITransport(t *testing.T) {
 	require.Equal(t, ctxBitswap, results[0].ContextID)
 	require.Equal(t, ipni.TBitswap, ipni.ExportTransportKind(results[0]))
 }
+
+// fixedLatency reports a constant median and p90 RTT per peer
+type fixedLatency map[peer.ID]time.Duration
+
+func (f fixedLatency) PeerLatency(p peer.ID) (network.LatencyStats, bool) {
+	rtt, ok := f[p]
+	return network.LatencyStats{Samples: 1, P50: rtt, P90: rtt}, ok
+}
+
+func TestIPNIPlannerLatency(t *testing.T) {
+	ctx := context.Background()
+
+	ipniWrapper, err := ipni.New("", "", nil, nil, nil)
+	require.NoError(t, err)
+	c, err := block.ComputeCID([]byte("planner-latency"), nil)
+	require.NoError(t, err)
+
+	newPeer := func() peer.ID {
+		_, pub, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		id, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		return id
+	}
+	slow, fast, unknown := newPeer(), newPeer(), newPeer()
+	require.NoError(t, ipniWrapper.PutBitswap(slow, []byte("slow"), c))
+	require.NoError(t, ipniWrapper.PutBitswap(fast, []byte("fast"), c))
+	require.NoError(t, ipniWrapper.PutBitswap(unknown, []byte("unknown"), c))
+
+	ipniWrapper.SetLatencySource(fixedLatency{slow: 400 * time.Millisecond, fast: 20 * time.Millisecond})
+	attempts, hit, err := ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+	require.NoError(t, err)
+	require.True(t, hit)
+	require.Len(t, attempts, 3)
+
+	// fast (0.4+0.18) > unknown (0.4) > slow (0.4-0.2)
+	require.Equal(t, fast.String(), attempts[0].ProviderID)
+	require.Equal(t, unknown.String(), attempts[1].ProviderID)
+	require.Equal(t, slow.String(), attempts[2].ProviderID)
+
+	// Stagger follows the measured p90 of the previous provider
+	require.Equal(t, time.Duration(0), attempts[0].Stagger)
+	require.Equal(t, 20*time.Millisecond, attempts[1].Stagger)
+	require.Equal(t, 20*time.Millisecond+150*time.Millisecond, attempts[2].Stagger)
+
+	ipniWrapper.SetLatencySource(nil)
+	attempts, _, err = ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+	require.NoError(t, err)
+	require.Equal(t, 150*time.Millisecond, attempts[1].Stagger)
+}
+
+func TestIPNIContentRouting(t *testing.T) {
+	ctx := context.Background()
+
+	ipniWrapper, err := ipni.New("", "", nil, nil, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+
+	c, err := block.ComputeCID([]byte("routed-by-index"), nil)
+	require.NoError(t, err)
+	pid := ipniWrapper.Provider.ProviderID()
+	require.NoError(t, ipniWrapper.PutBitswap(pid, []byte("ctx-bw"), c))
+	require.NoError(t, ipniWrapper.PutHTTP(pid, []byte("ctx-http"), c))
+
+	other, err := network.New(nil)
+	require.NoError(t, err)
+	defer other.Close()
+	require.NoError(t, ipniWrapper.PutBitswap(other.ID(), []byte("ctx-other"), c))
+
+	r := ipniWrapper.ContentRouting()
+	var found []peer.ID
+	for pi := range r.FindProvidersAsync(ctx, c, 0) {
+		found = append(found, pi.ID)
+	}
+	require.ElementsMatch(t, []peer.ID{pid, other.ID()}, found)
+
+	var limited int
+	for range r.FindProvidersAsync(ctx, c, 1) {
+		limited++
+	}
+	require.Equal(t, 1, limited)
+
+	missing, err := block.ComputeCID([]byte("not indexed"), nil)
+	require.NoError(t, err)
+	for range r.FindProvidersAsync(ctx, missing, 0) {
+		t.Fatal("unexpected provider")
+	}
+
+	require.ErrorIs(t, r.Provide(ctx, c, true), routing.ErrNotSupported)
+}
+
+func TestIPNIFindClient(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := block.ComputeCID([]byte("indexed-remotely"), nil)
+	require.NoError(t, err)
+	remotePriv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	remoteID, err := peer.IDFromPrivateKey(remotePriv)
+	require.NoError(t, err)
+	addr := multiaddr.StringCast("/ip4/192.0.2.1/tcp/4001")
+	broken, err := block.ComputeCID([]byte("indexer fails on this one"), nil)
+	require.NoError(t, err)
+	httpMeta, err := metadata.IpfsGatewayHttp{}.MarshalBinary()
+	require.NoError(t, err)
+
+	res := &model.FindResponse{MultihashResults: []model.MultihashResult{{
+		Multihash: c.Hash(),
+		ProviderResults: []model.ProviderResult{
+			{ContextID: []byte("ctx-remote"), Metadata: httpMeta, Provider: &peer.AddrInfo{ID: remoteID, Addrs: []multiaddr.Multiaddr{addr}}},
+			{ContextID: []byte("ctx-remote"), Metadata: httpMeta, Provider: &peer.AddrInfo{ID: remoteID, Addrs: []multiaddr.Multiaddr{addr}}},
+			{ContextID: []byte("no-provider")},
+		},
+	}}}
+	body, err := model.MarshalFindResponse(res)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cid/" + c.String(), "/multihash/" + c.Hash().B58String():
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+		case "/multihash/" + broken.Hash().B58String():
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	finder := ipni.NewFindClient(srv.URL+"/", nil)
+
+	t.Run("Endpoints", func(t *testing.T) {
+		byCID, err := finder.FindCID(ctx, c)
+		require.NoError(t, err)
+		byHash, err := finder.Find(ctx, c.Hash())
+		require.NoError(t, err)
+		require.Equal(t, byCID, byHash)
+
+		require.Len(t, byCID, 1)
+		rec := byCID[0]
+		require.Equal(t, remoteID, rec.Provider.AddrInfo.ID)
+		require.Equal(t, []multiaddr.Multiaddr{addr}, rec.Provider.AddrInfo.Addrs)
+		require.Equal(t, []byte("ctx-remote"), rec.ContextID)
+		require.Equal(t, ipni.THTTP, ipni.ExportTransportKind(rec.Value()))
+
+		missing, err := block.ComputeCID([]byte("unknown to the indexer"), nil)
+		require.NoError(t, err)
+		recs, err := finder.FindCID(ctx, missing)
+		require.NoError(t, err)
+		require.Empty(t, recs)
+
+		_, err = finder.Find(ctx, broken.Hash())
+		require.Error(t, err)
+	})
+
+	t.Run("Planner", func(t *testing.T) {
+		host, err := network.New(nil)
+		require.NoError(t, err)
+		defer host.Close()
+		ipniWrapper, err := ipni.New("", "", nil, host, nil)
+		require.NoError(t, err)
+		defer ipniWrapper.Close()
+		local := ipniWrapper.Provider.ProviderID()
+		require.NoError(t, ipniWrapper.PutBitswap(local, []byte("ctx-local"), c))
+
+		attempts, hit, err := ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+		require.NoError(t, err)
+		require.True(t, hit)
+		require.Len(t, attempts, 1)
+
+		ipniWrapper.SetFindClient(finder)
+		attempts, hit, err = ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+		require.NoError(t, err)
+		require.True(t, hit)
+		require.Len(t, attempts, 2)
+		require.Equal(t, remoteID.String(), attempts[0].ProviderID)
+		require.Equal(t, ipni.THTTP, attempts[0].Proto)
+		require.Equal(t, "indexer", attempts[0].Meta["source"])
+		require.Equal(t, local.String(), attempts[1].ProviderID)
+		require.Empty(t, attempts[1].Meta["source"])
+		require.Contains(t, host.Peerstore().Addrs(remoteID), addr)
+
+		// records held locally are not planned twice
+		require.NoError(t, ipniWrapper.PutHTTP(remoteID, []byte("ctx-remote"), c))
+		attempts, _, err = ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+		require.NoError(t, err)
+		require.Len(t, attempts, 2)
+
+		attempts, _, err = ipniWrapper.PlanByCID(ctx, c, ipni.Intent{LocalOnly: true})
+		require.NoError(t, err)
+		require.Empty(t, attempts)
+
+		// a failing indexer leaves the local plan
+		ipniWrapper.SetFindClient(ipni.NewFindClient("http://127.0.0.1:1", nil))
+		attempts, hit, err = ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+		require.NoError(t, err)
+		require.True(t, hit)
+		require.Len(t, attempts, 2)
+	})
+}
+
+func TestIPNIAdvertise(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	announced := make(chan cid.Cid, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/announce" {
+			http.NotFound(w, r)
+			return
+		}
+		var msg message.Message
+		var err error
+		if r.Header.Get("Content-Type") == "application/json" {
+			err = json.NewDecoder(r.Body).Decode(&msg)
+		} else {
+			err = msg.UnmarshalCBOR(r.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		announced <- msg.Cid
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	host1, err := network.New(nil)
+	require.NoError(t, err)
+	defer host1.Close()
+	prov, err := ipni.NewProviderWrapperWithConfig(t.TempDir(), ipni.MakeTopic("advertise-test"), nil, host1, &ipni.ProviderConfig{
+		AnnounceURLs:     []string{srv.URL},
+		DisablePubsub:    true,
+		EntriesChunkSize: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, prov.Start(ctx))
+
+	var cids []cid.Cid
+	for i := range 5 {
+		c, err := block.ComputeCID([]byte(fmt.Sprintf("advertised-%d", i)), nil)
+		require.NoError(t, err)
+		cids = append(cids, c)
+	}
+
+	_, err = prov.AdvertiseCID(ctx, []byte("ctx-empty"), metadata.Default.New(metadata.Bitswap{}))
+	require.ErrorIs(t, err, ipni.ErrNothingToAdvertise)
+
+	first, err := prov.AdvertiseCID(ctx, []byte("ctx-a"), metadata.Default.New(metadata.Bitswap{}), cids...)
+	require.NoError(t, err)
+	require.Equal(t, first, <-announced)
+
+	second, err := prov.AdvertiseCID(ctx, []byte("ctx-b"), metadata.Default.New(metadata.IpfsGatewayHttp{}), cids[0])
+	require.NoError(t, err)
+	require.Equal(t, second, <-announced)
+
+	_, err = prov.AdvertiseCID(ctx, []byte("ctx-b"), metadata.Default.New(metadata.IpfsGatewayHttp{}), cids[0])
+	require.ErrorIs(t, err, provider.ErrAlreadyAdvertised)
+
+	t.Run("Chain", func(t *testing.T) {
+		head, ad, err := prov.Advertisement(ctx, cid.Undef)
+		require.NoError(t, err)
+		require.Equal(t, second, head)
+		signer, err := ad.VerifySignature()
+		require.NoError(t, err)
+		require.Equal(t, prov.ProviderID(), signer)
+		require.Equal(t, first, ad.PreviousID.(cidlink.Link).Cid)
+
+		pid, err := ipni.VerifyAdvertisement(*ad, host1.ID())
+		require.NoError(t, err)
+		require.Equal(t, prov.ProviderID(), pid)
+
+		// Signed by a third peer, or not at all
+		forger, _, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		forged := *ad
+		require.NoError(t, forged.Sign(forger))
+		_, err = ipni.VerifyAdvertisement(forged, host1.ID())
+		require.ErrorIs(t, err, ipni.ErrAdSignature)
+		forged.Signature = nil
+		_, err = ipni.VerifyAdvertisement(forged, host1.ID())
+		require.ErrorIs(t, err, ipni.ErrAdSignature)
+
+		_, ad, err = prov.Advertisement(ctx, first)
+		require.NoError(t, err)
+		require.Nil(t, ad.PreviousID)
+		require.Equal(t, []byte("ctx-a"), ad.ContextID)
+
+		// 5 multihashes in chunks of 2
+		lsys := prov.LinkSystem()
+		var got []multihash.Multihash
+		var chunks int
+		for next := ad.Entries; next != nil; chunks++ {
+			n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, next, schema.EntryChunkPrototype)
+			require.NoError(t, err)
+			chunk, err := schema.UnwrapEntryChunk(n)
+			require.NoError(t, err)
+			got = append(got, chunk.Entries...)
+			next = chunk.Next
+		}
+		require.Equal(t, 3, chunks)
+		require.Len(t, got, len(cids))
+	})
+
+	t.Run("Ingest", func(t *testing.T) {
+		host2, err := network.New(nil)
+		require.NoError(t, err)
+		defer host2.Close()
+		indexer, err := ipni.New("", ipni.MakeTopic("advertise-test"), nil, host2, nil)
+		require.NoError(t, err)
+		defer indexer.Close()
+		require.NoError(t, indexer.Subscriber.Start(ctx, indexer.Put, indexer.Remove))
+		defer indexer.Subscriber.Stop()
+
+		require.NoError(t, host2.ConnectToPeer(ctx, host1.GetFullAddresses()...))
+		// the sync protocol is picked from what identify reports, which
+		// may not have finished yet
+		publisher := peer.AddrInfo{ID: host1.ID(), Addrs: host1.Addrs()}
+		sync := func() bool {
+			_, err := indexer.Subscriber.SyncAdChain(ctx, publisher)
+			return err == nil
+		}
+		require.Eventually(t, sync, 10*time.Second, 50*time.Millisecond)
+
+		require.Eventually(t, func() bool {
+			vals, found, err := indexer.GetProvidersByCID(cids[0])
+			return err == nil && found && len(vals) == 2
+		}, 10*time.Second, 50*time.Millisecond)
+		vals, _, err := indexer.GetProvidersByCID(cids[4])
+		require.NoError(t, err)
+		require.Len(t, vals, 1)
+		require.Equal(t, prov.ProviderID(), vals[0].ProviderID)
+		require.Equal(t, ipni.TBitswap, ipni.ExportTransportKind(vals[0]))
+
+		removed, err := prov.Withdraw(ctx, []byte("ctx-a"))
+		require.NoError(t, err)
+		require.Equal(t, removed, <-announced)
+		require.True(t, sync())
+		require.Eventually(t, func() bool {
+			_, found, err := indexer.GetProvidersByCID(cids[4])
+			return err == nil && !found
+		}, 10*time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestIPNIStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var cids []cid.Cid
+	for i := range 1000 {
+		c, err := block.ComputeCID([]byte(fmt.Sprintf("stored-%d", i)), nil)
+		require.NoError(t, err)
+		cids = append(cids, c)
+	}
+	pid := peer.ID("store-test-provider")
+
+	t.Run("Reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		cfg := &ipni.Config{CacheSize: -1, PebbleCacheSize: 1 << 20, CompactionConcurrency: 2, FlushInterval: 10 * time.Millisecond}
+		w, err := ipni.NewWithConfig(dir, "", nil, nil, nil, cfg)
+		require.NoError(t, err)
+		require.NoError(t, w.PutBitswap(pid, []byte("ctx"), cids...))
+		require.NoError(t, w.Close())
+
+		w, err = ipni.NewWithConfig(dir, "", nil, nil, nil, cfg)
+		require.NoError(t, err)
+		defer w.Close()
+		for _, c := range cids {
+			vals, found, err := w.GetProvidersByCID(c)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, pid, vals[0].ProviderID)
+		}
+	})
+
+	t.Run("Pluggable", func(t *testing.T) {
+		store := memory.New()
+		w, err := ipni.NewWithConfig(t.TempDir(), "", nil, nil, nil, &ipni.Config{ValueStore: store})
+		require.NoError(t, err)
+		defer w.Close()
+		require.NoError(t, w.PutHTTP(pid, []byte("ctx"), cids[0]))
+		vals, found, err := store.Get(cids[0].Hash())
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, ipni.THTTP, ipni.ExportTransportKind(vals[0]))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ipni.NewWithConfig("", "", nil, nil, nil, &ipni.Config{Store: ipni.PebbleStore})
+		require.Error(t, err)
+		_, err = ipni.NewWithConfig(t.TempDir(), "", nil, nil, nil, &ipni.Config{Store: "leveldb"})
+		require.Error(t, err)
+	})
+
+	t.Run("ResumeSync", func(t *testing.T) {
+		host1, err := network.New(nil)
+		require.NoError(t, err)
+		defer host1.Close()
+		topic := ipni.MakeTopic("store-test")
+		prov, err := ipni.NewProviderWrapperWithConfig(t.TempDir(), topic, nil, host1, &ipni.ProviderConfig{DisablePubsub: true})
+		require.NoError(t, err)
+		require.NoError(t, prov.Start(ctx))
+		head, err := prov.AdvertiseCID(ctx, []byte("ctx"), metadata.Default.New(metadata.Bitswap{}), cids[:10]...)
+		require.NoError(t, err)
+
+		indexDir, dsDir := t.TempDir(), t.TempDir()
+		start := func() (*ipni.IPNIWrapper, *persistent.PersistentWrapper, *network.HostWrapper) {
+			dstore, err := persistent.New(persistent.Pebbledb, dsDir)
+			require.NoError(t, err)
+			host2, err := network.New(nil)
+			require.NoError(t, err)
+			w, err := ipni.NewWithConfig(indexDir, topic, dstore, host2, nil, nil)
+			require.NoError(t, err)
+			require.NoError(t, w.Start(ctx))
+			return w, dstore, host2
+		}
+
+		w, dstore, host2 := start()
+		require.NoError(t, host2.ConnectToPeer(ctx, host1.GetFullAddresses()...))
+		publisher := peer.AddrInfo{ID: host1.ID(), Addrs: host1.Addrs()}
+		require.Eventually(t, func() bool {
+			_, err := w.Subscriber.SyncAdChain(ctx, publisher)
+			return err == nil
+		}, 10*time.Second, 50*time.Millisecond)
+		require.Eventually(t, func() bool {
+			last, ok, err := w.LastSynced(ctx, host1.ID())
+			return err == nil && ok && last == head
+		}, 10*time.Second, 50*time.Millisecond)
+		w.Subscriber.Stop()
+		require.NoError(t, w.Close())
+		require.NoError(t, dstore.Close())
+		host2.Close()
+
+		w, dstore, host2 = start()
+		defer dstore.Close()
+		defer host2.Close()
+		defer w.Close()
+		defer w.Subscriber.Stop()
+		require.Equal(t, cidlink.Link{Cid: head}, w.Subscriber.GetLatestSync(host1.ID()))
+		_, found, err := w.GetProvidersByCID(cids[9])
+		require.NoError(t, err)
+		require.True(t, found)
+	})
+}
+
+func TestIPNIHealth(t *testing.T) {
+	ctx := context.Background()
+
+	host, err := network.New(nil)
+	require.NoError(t, err)
+	defer host.Close()
+	ipniWrapper, err := ipni.New("", "", nil, host, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+
+	c, err := block.ComputeCID([]byte("probed providers"), nil)
+	require.NoError(t, err)
+
+	alive, err := network.New(nil)
+	require.NoError(t, err)
+	defer alive.Close()
+	host.Peerstore().AddAddrs(alive.ID(), alive.Addrs(), time.Hour)
+
+	_, deadPub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	dead, err := peer.IDFromPublicKey(deadPub)
+	require.NoError(t, err)
+	host.Peerstore().AddAddrs(dead, []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")}, time.Hour)
+
+	var heads atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/ipfs/bafkqaaa" {
+			heads.Add(1)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	_, gwPub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	gw, err := peer.IDFromPublicKey(gwPub)
+	require.NoError(t, err)
+	port := gateway.Listener.Addr().(*net.TCPAddr).Port
+	host.Peerstore().AddAddrs(gw, []multiaddr.Multiaddr{multiaddr.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d/http", port))}, time.Hour)
+
+	require.NoError(t, ipniWrapper.PutBitswap(alive.ID(), []byte("alive"), c))
+	require.NoError(t, ipniWrapper.PutBitswap(dead, []byte("dead"), c))
+	require.NoError(t, ipniWrapper.PutHTTP(gw, []byte("gateway"), c))
+	// this host is never probed
+	require.NoError(t, ipniWrapper.PutBitswap(host.ID(), []byte("self"), c))
+
+	cfg := &ipni.ProbeConfig{Timeout: 2 * time.Second, HalfLife: time.Minute}
+	require.Equal(t, 3, ipniWrapper.ProbeProviders(ctx, cfg))
+
+	h, ok := ipniWrapper.ProviderHealth(alive.ID(), ipni.TBitswap)
+	require.True(t, ok)
+	require.Equal(t, 1.0, h.SuccessRate)
+	require.Positive(t, h.Latency)
+
+	h, ok = ipniWrapper.ProviderHealth(dead, ipni.TBitswap)
+	require.True(t, ok)
+	require.Equal(t, 1, h.Failures)
+	require.Zero(t, h.Score(time.Now(), cfg.HalfLife))
+
+	h, ok = ipniWrapper.ProviderHealth(gw, ipni.THTTP)
+	require.True(t, ok)
+	require.Equal(t, 1.0, h.SuccessRate)
+	require.EqualValues(t, 1, heads.Load())
+
+	_, ok = ipniWrapper.ProviderHealth(host.ID(), ipni.TBitswap)
+	require.False(t, ok)
+
+	t.Run("Ranking", func(t *testing.T) {
+		attempts, hit, err := ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+		require.NoError(t, err)
+		require.True(t, hit)
+		require.Len(t, attempts, 4)
+		weights := func() map[string]float64 {
+			attempts, _, err := ipniWrapper.PlanByCID(ctx, c, ipni.Intent{})
+			require.NoError(t, err)
+			out := map[string]float64{}
+			for _, a := range attempts {
+				out[a.ProviderID] = a.Weight
+			}
+			return out
+		}
+		// the unreachable provider sinks below the unprobed one
+		require.Equal(t, dead.String(), attempts[3].ProviderID)
+		withHealth := weights()
+		require.InDelta(t, withHealth[host.ID().String()]-0.3, withHealth[dead.String()], 1e-9)
+
+		ipniWrapper.SetHealthSource(nil)
+		without := weights()
+		require.InDelta(t, without[host.ID().String()], without[dead.String()], 1e-9)
+		require.InDelta(t, without[gw.String()]+0.3, withHealth[gw.String()], 1e-3)
+	})
+
+	t.Run("Decay", func(t *testing.T) {
+		h, _ := ipniWrapper.ProviderHealth(alive.ID(), ipni.TBitswap)
+		require.InDelta(t, 0.5, h.Score(h.LastSuccess.Add(cfg.HalfLife), cfg.HalfLife), 1e-9)
+		require.InDelta(t, 0.25, h.Score(h.LastSuccess.Add(2*cfg.HalfLife), cfg.HalfLife), 1e-9)
+
+		// a provider that stops answering loses its success rate
+		gateway.Close()
+		ipniWrapper.ProbeProviders(ctx, cfg)
+		h, _ = ipniWrapper.ProviderHealth(gw, ipni.THTTP)
+		require.Equal(t, 1, h.Failures)
+		require.Less(t, h.SuccessRate, 1.0)
+		require.NotEmpty(t, h.LastError)
+	})
+
+	t.Run("Background", func(t *testing.T) {
+		pctx, cancel := context.WithCancel(ctx)
+		before, _ := ipniWrapper.ProviderHealth(alive.ID(), ipni.TBitswap)
+		probe := ipniWrapper.StartHealthProbe(pctx, &ipni.ProbeConfig{Interval: 50 * time.Millisecond, Timeout: time.Second})
+		require.Eventually(t, func() bool {
+			h, _ := ipniWrapper.ProviderHealth(alive.ID(), ipni.TBitswap)
+			return h.Probes >= before.Probes+2
+		}, 5*time.Second, 20*time.Millisecond)
+		cancel()
+		select {
+		case <-probe.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("probe did not stop")
+		}
+	})
+}
+
+func TestIPNIAdmin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var cids []cid.Cid
+	for i := range 3 {
+		c, err := block.ComputeCID([]byte(fmt.Sprintf("admin-%d", i)), nil)
+		require.NoError(t, err)
+		cids = append(cids, c)
+	}
+
+	host1, err := network.New(nil)
+	require.NoError(t, err)
+	defer host1.Close()
+	topic := ipni.MakeTopic("admin-test")
+	prov, err := ipni.NewProviderWrapperWithConfig(t.TempDir(), topic, nil, host1, &ipni.ProviderConfig{DisablePubsub: true})
+	require.NoError(t, err)
+	require.NoError(t, prov.Start(ctx))
+	head, err := prov.AdvertiseCID(ctx, []byte("ctx-pub"), metadata.Default.New(metadata.Bitswap{}), cids...)
+	require.NoError(t, err)
+
+	host2, err := network.New(nil)
+	require.NoError(t, err)
+	defer host2.Close()
+	w, err := ipni.New("", topic, nil, host2, nil)
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Start(ctx))
+	defer w.Subscriber.Stop()
+
+	require.NoError(t, host2.ConnectToPeer(ctx, host1.GetFullAddresses()...))
+	publisher := peer.AddrInfo{ID: host1.ID(), Addrs: host1.Addrs()}
+	require.Eventually(t, func() bool {
+		_, err := w.Subscriber.SyncAdChain(ctx, publisher)
+		return err == nil
+	}, 10*time.Second, 50*time.Millisecond)
+	indexed := func() bool {
+		_, found, err := w.GetProvidersByCID(cids[2])
+		return err == nil && found
+	}
+	require.Eventually(t, indexed, 10*time.Second, 50*time.Millisecond)
+
+	_, otherPub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	other, err := peer.IDFromPublicKey(otherPub)
+	require.NoError(t, err)
+	require.NoError(t, w.PutHTTP(other, []byte("ctx-local"), cids[0]))
+
+	_, err = w.AdminHandler(ipni.AdminConfig{})
+	require.Error(t, err)
+
+	authCfg := security.AuthConfig{JWTSecret: []byte("admin-secret"), TokenTTL: time.Hour, AdminUsers: []string{"root"}}
+	admin, err := w.AdminHandler(ipni.AdminConfig{Auth: authCfg})
+	require.NoError(t, err)
+	tokens := security.NewAuthMiddleware(authCfg)
+	rootToken, err := tokens.GenerateToken("1", "root", "admin")
+	require.NoError(t, err)
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Auth", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, do("GET", "/admin/providers", "").Code)
+		userToken, err := tokens.GenerateToken("2", "guest", "admin")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, do("GET", "/admin/providers", userToken).Code)
+
+		keyed, err := w.AdminHandler(ipni.AdminConfig{APIKeys: []string{"k1"}})
+		require.NoError(t, err)
+		req := httptest.NewRequest("GET", "/admin/metrics", nil)
+		req.Header.Set("X-API-Key", "k1")
+		rec := httptest.NewRecorder()
+		keyed.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Providers", func(t *testing.T) {
+		rec := do("GET", "/admin/providers", rootToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct{ Providers []ipni.ProviderSummary }
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		require.Len(t, body.Providers, 2)
+		byID := map[peer.ID]ipni.ProviderSummary{}
+		for _, p := range body.Providers {
+			byID[p.ID] = p
+		}
+		pub := byID[prov.ProviderID()]
+		require.Equal(t, 3, pub.Multihashes)
+		require.Equal(t, []ipni.ContextSummary{{ContextID: ipni.EncodeContextID([]byte("ctx-pub")), Transport: ipni.TBitswap, Multihashes: 3}}, pub.Contexts)
+		require.Equal(t, ipni.THTTP, byID[other].Contexts[0].Transport)
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		path := "/admin/providers/" + prov.ProviderID().String() + "/contexts/" + ipni.EncodeContextID([]byte("ctx-pub"))
+		rec := do("GET", path, rootToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Entries []string
+			More    bool
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		var want []string
+		for _, c := range cids {
+			want = append(want, c.Hash().B58String())
+		}
+		require.ElementsMatch(t, want, body.Entries)
+		require.False(t, body.More)
+
+		rec = do("GET", path+"?limit=2", rootToken)
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		require.Len(t, body.Entries, 2)
+		require.True(t, body.More)
+
+		require.Equal(t, http.StatusBadRequest, do("GET", path+"?limit=x", rootToken).Code)
+		require.Equal(t, http.StatusBadRequest, do("GET", "/admin/providers/"+other.String()+"/contexts/!", rootToken).Code)
+		require.Equal(t, http.StatusNotFound, do("GET", "/admin/providers/"+other.String()+"/contexts/"+ipni.EncodeContextID([]byte("nope")), rootToken).Code)
+		require.Equal(t, http.StatusBadRequest, do("GET", "/admin/providers/not-a-peer/contexts/x", rootToken).Code)
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		rec := do("GET", "/admin/metrics", rootToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var m ipni.AdminMetrics
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&m))
+		require.Equal(t, head.String(), m.Publishers[host1.ID().String()])
+		require.Equal(t, http.StatusMethodNotAllowed, do("POST", "/admin/metrics", rootToken).Code)
+	})
+
+	t.Run("DeleteAndResync", func(t *testing.T) {
+		rec := do("DELETE", "/admin/providers/"+prov.ProviderID().String(), rootToken)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.False(t, indexed())
+
+		// the chain is walked from the start again, so the deleted
+		// records come back
+		rec = do("POST", "/admin/providers/"+host1.ID().String()+"/resync", rootToken)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		var body struct{ Head string }
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		require.Equal(t, head.String(), body.Head)
+		require.Eventually(t, indexed, 10*time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestIPNIReaderPrivacy(t *testing.T) {
+	ctx := context.Background()
+
+	host, err := network.New(nil)
+	require.NoError(t, err)
+	defer host.Close()
+	store, err := persistent.New(persistent.Memory, "")
+	require.NoError(t, err)
+	index, err := ipni.NewWithConfig("", "", store, host, nil, &ipni.Config{ReaderPrivacy: true})
+	require.NoError(t, err)
+	defer index.Close()
+
+	c, err := block.ComputeCID([]byte("looked up privately"), nil)
+	require.NoError(t, err)
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	addr := multiaddr.StringCast("/ip4/192.0.2.7/tcp/443/https")
+	host.Peerstore().AddAddrs(pid, []multiaddr.Multiaddr{addr}, time.Hour)
+	require.NoError(t, index.PutHTTP(pid, []byte("ctx-private"), c))
+
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		index.FindHandler().ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	private := ipni.NewPrivateFindClient(srv.URL, nil)
+	recs, err := private.FindCID(ctx, c)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	require.Equal(t, pid, recs[0].Provider.AddrInfo.ID)
+	require.Equal(t, []multiaddr.Multiaddr{addr}, recs[0].Provider.AddrInfo.Addrs)
+	require.Equal(t, []byte("ctx-private"), recs[0].ContextID)
+	require.Equal(t, ipni.THTTP, ipni.ExportTransportKind(recs[0].Value()))
+
+	// the indexer only ever saw the second hash
+	mu.Lock()
+	for _, p := range paths {
+		require.NotContains(t, p, c.String())
+		require.NotContains(t, p, c.Hash().B58String())
+	}
+	mu.Unlock()
+
+	t.Run("Plain", func(t *testing.T) {
+		plain, err := ipni.NewFindClient(srv.URL, nil).FindCID(ctx, c)
+		require.NoError(t, err)
+		require.Equal(t, recs, plain)
+	})
+
+	t.Run("Planner", func(t *testing.T) {
+		client, err := ipni.New("", "", nil, nil, nil)
+		require.NoError(t, err)
+		defer client.Close()
+		client.SetFindClient(private)
+		attempts, hit, err := client.PlanByCID(ctx, c, ipni.Intent{})
+		require.NoError(t, err)
+		require.True(t, hit)
+		require.Len(t, attempts, 1)
+		require.Equal(t, "indexer", attempts[0].Meta["source"])
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		plainIndex, err := ipni.New("", "", nil, nil, nil)
+		require.NoError(t, err)
+		defer plainIndex.Close()
+		require.NoError(t, plainIndex.PutHTTP(pid, []byte("ctx-private"), c))
+		plainSrv := httptest.NewServer(plainIndex.FindHandler())
+		defer plainSrv.Close()
+
+		_, err = ipni.NewPrivateFindClient(plainSrv.URL, nil).FindCID(ctx, c)
+		require.ErrorContains(t, err, "501")
+		recs, err := ipni.NewFindClient(plainSrv.URL, nil).FindCID(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		dhKeys := func() int {
+			res, err := store.Datastore().Query(ctx, query.Query{Prefix: "/ipni/dh", KeysOnly: true})
+			require.NoError(t, err)
+			entries, err := res.Rest()
+			require.NoError(t, err)
+			return len(entries)
+		}
+		require.NoError(t, index.Remove(pid, []byte("ctx-private")))
+		recs, err := private.FindCID(ctx, c)
+		require.NoError(t, err)
+		require.Empty(t, recs)
+		require.Zero(t, dhKeys(), "entries of the removed context ID are left")
+
+		require.NoError(t, index.PutHTTP(pid, []byte("ctx-again"), c))
+		recs, err = private.FindCID(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+		require.NoError(t, index.RemoveProvider(ctx, pid))
+		recs, err = private.FindCID(ctx, c)
+		require.NoError(t, err)
+		require.Empty(t, recs)
+		require.Zero(t, dhKeys())
+	})
+}
+
+func TestIPNIBulkIndex(t *testing.T) {
+	ctx := context.Background()
+
+	var blks []blocks.Block
+	for i := range 10 {
+		blks = append(blks, blocks.NewBlock([]byte(fmt.Sprintf("dataset-%d", i))))
+	}
+
+	w, err := ipni.NewWithConfig("", "", nil, nil, nil, &ipni.Config{IndexBatchSize: 4})
+	require.NoError(t, err)
+	defer w.Close()
+	var events []ipni.IndexProgress
+	w.OnIndexProgress(func(p ipni.IndexProgress) { events = append(events, p) })
+
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+
+	t.Run("Car", func(t *testing.T) {
+		events = nil
+		path := filepath.Join(t.TempDir(), "dataset.car")
+		bs, err := carbs.OpenReadWrite(path, []cid.Cid{blks[0].Cid()})
+		require.NoError(t, err)
+		require.NoError(t, bs.PutMany(ctx, blks))
+		require.NoError(t, bs.Finalize())
+
+		httpMeta, err := metadata.IpfsGatewayHttp{}.MarshalBinary()
+		require.NoError(t, err)
+		res, err := w.IndexCar(ctx, path, pid, httpMeta)
+		require.NoError(t, err)
+		require.True(t, res.Finished)
+		require.Equal(t, 10, res.Indexed)
+		require.Equal(t, 3, res.Batches)
+
+		// 4 + 4 + 2, then the final report
+		require.Len(t, events, 4)
+		require.Equal(t, []int{4, 8, 10, 10}, []int{events[0].Indexed, events[1].Indexed, events[2].Indexed, events[3].Indexed})
+		require.True(t, events[3].Finished)
+
+		for _, b := range blks {
+			vals, found, err := w.GetProvidersByCID(b.Cid())
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Len(t, vals, 1)
+			require.Equal(t, res.ContextID, vals[0].ContextID)
+			require.Equal(t, ipni.THTTP, ipni.ExportTransportKind(vals[0]))
+		}
+
+		// the same file keeps its context ID
+		again, err := w.IndexCar(ctx, path, pid, httpMeta)
+		require.NoError(t, err)
+		require.Equal(t, res.ContextID, again.ContextID)
+		vals, _, err := w.GetProvidersByCID(blks[0].Cid())
+		require.NoError(t, err)
+		require.Len(t, vals, 1)
+
+		_, err = w.IndexCar(ctx, filepath.Join(t.TempDir(), "missing.car"), pid, httpMeta)
+		require.Error(t, err)
+	})
+
+	t.Run("Blockstore", func(t *testing.T) {
+		events = nil
+		store, err := persistent.New(persistent.Memory, "")
+		require.NoError(t, err)
+		defer store.Close()
+		require.NoError(t, store.PutMany(ctx, blks[:6]))
+
+		other := w.Provider.ProviderID()
+		res, err := w.IndexBlockstore(ctx, "dataset", store, other)
+		require.NoError(t, err)
+		require.Equal(t, 6, res.Indexed)
+		require.Equal(t, ipni.BlockstoreContextID("dataset"), res.ContextID)
+		require.NotEqual(t, ipni.BlockstoreContextID("other"), res.ContextID)
+		require.Len(t, events, 3)
+		require.False(t, res.Advertisement.Defined(), "announcing is off")
+
+		vals, _, err := w.GetProvidersByCID(blks[5].Cid())
+		require.NoError(t, err)
+		require.Len(t, vals, 2)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = w.IndexBlockstore(cancelled, "dataset", store, other)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Announce", func(t *testing.T) {
+		announcer, err := ipni.NewWithConfig("", "", nil, nil, nil, &ipni.Config{AnnounceIndexed: true})
+		require.NoError(t, err)
+		defer announcer.Close()
+		require.NoError(t, announcer.Start(ctx))
+		store, err := persistent.New(persistent.Memory, "")
+		require.NoError(t, err)
+		defer store.Close()
+		require.NoError(t, store.PutMany(ctx, blks[:3]))
+
+		res, err := announcer.IndexBlockstore(ctx, "announced", store, announcer.Provider.ProviderID())
+		require.NoError(t, err)
+		require.True(t, res.Advertisement.Defined())
+		_, ad, err := announcer.Provider.Advertisement(ctx, res.Advertisement)
+		require.NoError(t, err)
+		require.Equal(t, ipni.BlockstoreContextID("announced"), ad.ContextID)
+		_, err = ad.VerifySignature()
+		require.NoError(t, err)
+
+		// unchanged, the published advertisement still covers the store
+		again, err := announcer.IndexBlockstore(ctx, "announced", store, announcer.Provider.ProviderID())
+		require.NoError(t, err)
+		require.False(t, again.Advertisement.Defined())
+
+		// blocks added since are advertised under a fresh entries chain
+		require.NoError(t, store.PutMany(ctx, blks[3:5]))
+		grown, err := announcer.IndexBlockstore(ctx, "announced", store, announcer.Provider.ProviderID())
+		require.NoError(t, err)
+		require.Equal(t, 5, grown.Indexed)
+		require.True(t, grown.Advertisement.Defined())
+		require.NotEqual(t, res.Advertisement, grown.Advertisement)
+		_, ad, err = announcer.Provider.Advertisement(ctx, grown.Advertisement)
+		require.NoError(t, err)
+		require.False(t, ad.IsRm)
+		require.Equal(t, ipni.BlockstoreContextID("announced"), ad.ContextID)
+
+		entries := make(map[string]bool)
+		lsys := announcer.Provider.LinkSystem()
+		for next := ad.Entries; next != nil; {
+			n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, next, schema.EntryChunkPrototype)
+			require.NoError(t, err)
+			chunk, err := schema.UnwrapEntryChunk(n)
+			require.NoError(t, err)
+			for _, m := range chunk.Entries {
+				entries[string(m)] = true
+			}
+			next = chunk.Next
+		}
+		for _, b := range blks[:5] {
+			require.True(t, entries[string(b.Cid().Hash())], "block %s advertised", b.Cid())
+		}
+
+		// only the local provider's datasets can be advertised
+		res, err = announcer.IndexBlockstore(ctx, "foreign", store, pid)
+		require.NoError(t, err)
+		require.False(t, res.Advertisement.Defined())
+	})
+}
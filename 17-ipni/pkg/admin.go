@@ -0,0 +1,371 @@
+package ipni
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/dagsync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/gosuda/boxo-starter-kit/pkg/security"
+)
+
+// DefaultEntriesLimit caps how many multihashes Entries and the admin API
+// return unless asked for more
+const DefaultEntriesLimit = 1000
+
+// ProviderSummary is what the index holds for one provider
+type ProviderSummary struct {
+	ID          peer.ID          `json:"id"`
+	Multihashes int              `json:"multihashes"`
+	Contexts    []ContextSummary `json:"contexts"`
+}
+
+// ContextSummary is one context ID of a provider. ContextID is unpadded
+// base64url, as used in admin API paths.
+type ContextSummary struct {
+	ContextID   string        `json:"contextId"`
+	Transport   TransportKind `json:"transport"`
+	Multihashes int           `json:"multihashes"`
+}
+
+// EncodeContextID encodes a context ID for admin API paths
+func EncodeContextID(contextID []byte) string {
+	return base64.RawURLEncoding.EncodeToString(contextID)
+}
+
+// DecodeContextID decodes a context ID from admin API paths
+func DecodeContextID(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// Providers lists every provider in the index with its context IDs. It
+// scans the whole value store, so it is meant for admin use, not lookups.
+func (w *IPNIWrapper) Providers(ctx context.Context) ([]ProviderSummary, error) {
+	type ctxKey struct {
+		pid peer.ID
+		ctx string
+	}
+	counts := make(map[ctxKey]*ContextSummary)
+	err := w.scan(ctx, func(_ mh.Multihash, vals []indexer.Value) bool {
+		for _, v := range vals {
+			k := ctxKey{v.ProviderID, string(v.ContextID)}
+			cs, ok := counts[k]
+			if !ok {
+				cs = &ContextSummary{ContextID: EncodeContextID(v.ContextID), Transport: ExportTransportKind(v)}
+				counts[k] = cs
+			}
+			cs.Multihashes++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[peer.ID]*ProviderSummary)
+	for k, cs := range counts {
+		ps, ok := byID[k.pid]
+		if !ok {
+			ps = &ProviderSummary{ID: k.pid}
+			byID[k.pid] = ps
+		}
+		ps.Multihashes += cs.Multihashes
+		ps.Contexts = append(ps.Contexts, *cs)
+	}
+	out := make([]ProviderSummary, 0, len(byID))
+	for _, ps := range byID {
+		slices.SortFunc(ps.Contexts, func(a, b ContextSummary) int { return strings.Compare(a.ContextID, b.ContextID) })
+		out = append(out, *ps)
+	}
+	slices.SortFunc(out, func(a, b ProviderSummary) int { return strings.Compare(a.ID.String(), b.ID.String()) })
+	return out, nil
+}
+
+// Entries returns up to limit multihashes indexed for pid under contextID
+// (DefaultEntriesLimit when limit <= 0); more reports whether some were
+// left out. Like Providers it scans the value store.
+func (w *IPNIWrapper) Entries(ctx context.Context, pid peer.ID, contextID []byte, limit int) (mhs []mh.Multihash, more bool, err error) {
+	if limit <= 0 {
+		limit = DefaultEntriesLimit
+	}
+	err = w.scan(ctx, func(m mh.Multihash, vals []indexer.Value) bool {
+		for _, v := range vals {
+			if v.ProviderID != pid || !bytes.Equal(v.ContextID, contextID) {
+				continue
+			}
+			if len(mhs) == limit {
+				more = true
+				return false
+			}
+			mhs = append(mhs, m)
+			break
+		}
+		return true
+	})
+	return mhs, more, err
+}
+
+// scan calls fn with every multihash in the value store until fn returns
+// false or ctx ends
+func (w *IPNIWrapper) scan(ctx context.Context, fn func(mh.Multihash, []indexer.Value) bool) error {
+	it, err := w.Engine.Iter()
+	if err != nil {
+		return fmt.Errorf("iterate index: %w", err)
+	}
+	defer it.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, vals, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("iterate index: %w", err)
+		}
+		if !fn(m, vals) {
+			return nil
+		}
+	}
+}
+
+// Resync forgets where ingestion from publisher stopped and syncs its whole
+// advertisement chain again; every advertisement is re-ingested in the
+// background. Without addresses the peerstore's are used. It returns the
+// chain's head.
+func (w *IPNIWrapper) Resync(ctx context.Context, publisher peer.AddrInfo) (cid.Cid, error) {
+	if err := w.ds.Delete(ctx, syncKey(publisher.ID)); err != nil {
+		return cid.Undef, fmt.Errorf("reset sync state: %w", err)
+	}
+	head, err := w.Subscriber.SyncAdChain(ctx, publisher, dagsync.WithAdsResync(true))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("resync %s: %w", publisher.ID, err)
+	}
+	return head, nil
+}
+
+// AdminMetrics is a snapshot of the index for the admin API
+type AdminMetrics struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	SizeBytes   int64             `json:"sizeBytes"`
+	Multihashes *uint64           `json:"multihashes,omitempty"` // nil if the store cannot count
+	Publishers  map[string]string `json:"publishers"`            // last ingested advertisement per publisher
+	Health      []HealthEntry     `json:"health"`
+}
+
+// HealthEntry is the probe state of one provider transport
+type HealthEntry struct {
+	Provider  peer.ID        `json:"provider"`
+	Transport TransportKind  `json:"transport"`
+	Score     float64        `json:"score"`
+	Health    ProviderHealth `json:"health"`
+}
+
+// Metrics reports the index size, sync state and provider health
+func (w *IPNIWrapper) Metrics(ctx context.Context) (*AdminMetrics, error) {
+	size, err := w.Engine.Size()
+	if err != nil {
+		return nil, fmt.Errorf("index size: %w", err)
+	}
+	m := &AdminMetrics{
+		Timestamp:  time.Now().UTC(),
+		SizeBytes:  size,
+		Publishers: map[string]string{},
+		Health:     []HealthEntry{},
+	}
+	stats, err := w.Engine.Stats()
+	switch {
+	case err == nil:
+		m.Multihashes = &stats.MultihashCount
+	case !errors.Is(err, indexer.ErrStatsNotSupported):
+		return nil, fmt.Errorf("index stats: %w", err)
+	}
+
+	state, err := w.syncState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for pid, adCid := range state {
+		m.Publishers[pid.String()] = adCid.String()
+	}
+
+	w.health.each(func(p peer.ID, tk TransportKind, h ProviderHealth, score float64) {
+		m.Health = append(m.Health, HealthEntry{Provider: p, Transport: tk, Score: score, Health: h})
+	})
+	slices.SortFunc(m.Health, func(a, b HealthEntry) int {
+		if c := strings.Compare(a.Provider.String(), b.Provider.String()); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Transport), string(b.Transport))
+	})
+	return m, nil
+}
+
+// AdminConfig protects the admin API; JWTSecret or APIKeys must be set
+type AdminConfig struct {
+	// Bearer JWTs signed with Auth.JWTSecret, from a user in
+	// Auth.AdminUsers (and with Auth.RequiredScope, if set)
+	Auth security.AuthConfig
+	// Or keys accepted in the X-API-Key header
+	APIKeys []string
+}
+
+// AdminHandler serves the admin API:
+//
+//	GET    /admin/providers                          providers and their context IDs
+//	GET    /admin/providers/{peer}/contexts/{ctx}   entries under a context ID (?limit=)
+//	DELETE /admin/providers/{peer}                  drop a provider from the index
+//	POST   /admin/providers/{peer}/resync           re-ingest a publisher's chain (?addr=)
+//	GET    /admin/metrics                            size, sync state and provider health
+//
+// Context IDs in paths are unpadded base64url.
+func (w *IPNIWrapper) AdminHandler(cfg AdminConfig) (http.Handler, error) {
+	var auth func(http.Handler) http.Handler
+	switch {
+	case len(cfg.Auth.JWTSecret) > 0:
+		am := security.NewAuthMiddleware(cfg.Auth)
+		auth = func(next http.Handler) http.Handler { return am.JWTAuth()(am.AdminOnly()(next)) }
+	case len(cfg.APIKeys) > 0:
+		auth = security.APIKeyAuth(cfg.APIKeys)
+	default:
+		return nil, errors.New("admin API needs a JWT secret or API keys")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/providers", w.handleAdminProviders)
+	mux.HandleFunc("/admin/providers/", w.handleAdminProvider)
+	mux.HandleFunc("/admin/metrics", w.handleAdminMetrics)
+	return auth(mux), nil
+}
+
+func (w *IPNIWrapper) handleAdminProviders(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	providers, err := w.Providers(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, map[string]any{"providers": providers})
+}
+
+func (w *IPNIWrapper) handleAdminProvider(rw http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/providers/"), "/"), "/")
+	pid, err := peer.Decode(parts[0])
+	if err != nil {
+		http.Error(rw, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != "DELETE" {
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := w.RemoveProvider(ctx, pid); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 3 && parts[1] == "contexts":
+		if r.Method != "GET" {
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		contextID, err := DecodeContextID(parts[2])
+		if err != nil {
+			http.Error(rw, "Invalid context ID", http.StatusBadRequest)
+			return
+		}
+		var limit int
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				http.Error(rw, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		mhs, more, err := w.Entries(ctx, pid, contextID, limit)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(mhs) == 0 {
+			http.Error(rw, "No entries for context", http.StatusNotFound)
+			return
+		}
+		entries := make([]string, 0, len(mhs))
+		for _, m := range mhs {
+			entries = append(entries, m.B58String())
+		}
+		writeJSON(rw, map[string]any{"entries": entries, "more": more})
+
+	case len(parts) == 2 && parts[1] == "resync":
+		if r.Method != "POST" {
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		publisher := peer.AddrInfo{ID: pid}
+		for _, s := range r.URL.Query()["addr"] {
+			a, err := multiaddr.NewMultiaddr(s)
+			if err != nil {
+				http.Error(rw, "Invalid address", http.StatusBadRequest)
+				return
+			}
+			publisher.Addrs = append(publisher.Addrs, a)
+		}
+		head, err := w.Resync(ctx, publisher)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		resp := map[string]any{"head": nil}
+		if head != cid.Undef {
+			resp["head"] = head.String()
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(resp)
+
+	default:
+		http.Error(rw, "Unknown providers endpoint", http.StatusNotFound)
+	}
+}
+
+func (w *IPNIWrapper) handleAdminMetrics(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m, err := w.Metrics(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, m)
+}
+
+func writeJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,156 @@
+package ipni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/ingest/schema"
+	md "github.com/ipni/go-libipni/metadata"
+	provider "github.com/ipni/index-provider"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ErrNothingToAdvertise is returned by Advertise without multihashes
+var ErrNothingToAdvertise = errors.New("no multihashes to advertise")
+
+// Advertise publishes the local provider as a source of mhs under
+// contextID. The engine chunks mhs into linked entries blocks, signs a new
+// advertisement pointing at the previous one and announces its CID on the
+// gossipsub topic and to the configured AnnounceURLs. Indexers then sync
+// the chain from this host. Re-advertising a contextID with the same
+// metadata returns provider.ErrAlreadyAdvertised.
+func (p *ProviderWrapper) Advertise(ctx context.Context, contextID []byte, meta md.Metadata, mhs ...mh.Multihash) (cid.Cid, error) {
+	if len(mhs) == 0 {
+		return cid.Undef, ErrNothingToAdvertise
+	}
+	key := string(contextID)
+	p.mu.Lock()
+	prev, had := p.entries[key]
+	p.entries[key] = mhs
+	p.mu.Unlock()
+
+	adCid, err := p.engine.NotifyPut(ctx, nil, contextID, meta)
+	if err != nil {
+		p.mu.Lock()
+		if had {
+			p.entries[key] = prev
+		} else {
+			delete(p.entries, key)
+		}
+		p.mu.Unlock()
+		return cid.Undef, fmt.Errorf("advertise: %w", err)
+	}
+	return adCid, nil
+}
+
+// Readvertise is Advertise for a dataset whose multihashes may have
+// changed since it was last advertised. The engine never re-links the
+// entries of a context ID it already knows, so a changed set is withdrawn
+// and advertised again. provider.ErrAlreadyAdvertised is returned only when
+// the multihashes and the metadata are both unchanged.
+func (p *ProviderWrapper) Readvertise(ctx context.Context, contextID []byte, meta md.Metadata, mhs ...mh.Multihash) (cid.Cid, error) {
+	adCid, err := p.Advertise(ctx, contextID, meta, mhs...)
+	if !errors.Is(err, provider.ErrAlreadyAdvertised) {
+		return adCid, err
+	}
+	p.mu.RLock()
+	prev, had := p.entries[string(contextID)]
+	p.mu.RUnlock()
+	// Without the previous set, e.g. after a restart, assume it changed
+	if had && sameMultihashes(prev, mhs) {
+		return cid.Undef, err
+	}
+	if _, err := p.Withdraw(ctx, contextID); err != nil {
+		return cid.Undef, err
+	}
+	return p.Advertise(ctx, contextID, meta, mhs...)
+}
+
+// sameMultihashes reports whether a and b hold the same multihashes in
+// any order
+func sameMultihashes(a, b []mh.Multihash) bool {
+	set := make(map[string]struct{}, len(a))
+	for _, m := range a {
+		set[string(m)] = struct{}{}
+	}
+	seen := make(map[string]struct{}, len(b))
+	for _, m := range b {
+		if _, ok := set[string(m)]; !ok {
+			return false
+		}
+		seen[string(m)] = struct{}{}
+	}
+	return len(seen) == len(set)
+}
+
+// AdvertiseCID is Advertise for CIDs
+func (p *ProviderWrapper) AdvertiseCID(ctx context.Context, contextID []byte, meta md.Metadata, cids ...cid.Cid) (cid.Cid, error) {
+	mhs := make([]mh.Multihash, 0, len(cids))
+	for _, c := range cids {
+		mhs = append(mhs, c.Hash())
+	}
+	return p.Advertise(ctx, contextID, meta, mhs...)
+}
+
+// Withdraw publishes a removal advertisement for contextID, telling
+// indexers to drop everything advertised under it
+func (p *ProviderWrapper) Withdraw(ctx context.Context, contextID []byte) (cid.Cid, error) {
+	adCid, err := p.engine.NotifyRemove(ctx, "", contextID)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("withdraw: %w", err)
+	}
+	p.mu.Lock()
+	delete(p.entries, string(contextID))
+	p.mu.Unlock()
+	return adCid, nil
+}
+
+// Announce re-sends the head of the advertisement chain to the indexers at
+// urls with an HTTP PUT /announce, e.g. after they were down. It returns
+// the head's CID.
+func (p *ProviderWrapper) Announce(ctx context.Context, urls ...string) (cid.Cid, error) {
+	targets := make([]*url.URL, 0, len(urls))
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("announce url %q: %w", u, err)
+		}
+		targets = append(targets, parsed)
+	}
+	adCid, err := p.engine.PublishLatestHTTP(ctx, targets...)
+	if err != nil {
+		return adCid, fmt.Errorf("announce: %w", err)
+	}
+	return adCid, nil
+}
+
+// Advertisement loads a published advertisement; cid.Undef means the
+// head of the chain
+func (p *ProviderWrapper) Advertisement(ctx context.Context, adCid cid.Cid) (cid.Cid, *schema.Advertisement, error) {
+	if adCid == cid.Undef {
+		return p.engine.GetLatestAdv(ctx)
+	}
+	ad, err := p.engine.GetAdv(ctx, adCid)
+	return adCid, ad, err
+}
+
+// LinkSystem serves the advertisement chain and its entries blocks
+func (p *ProviderWrapper) LinkSystem() *ipld.LinkSystem {
+	return p.engine.LinkSystem()
+}
+
+// listMultihashes is the engine's MultihashLister
+func (p *ProviderWrapper) listMultihashes(ctx context.Context, _ peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+	p.mu.RLock()
+	mhs, ok := p.entries[string(contextID)]
+	p.mu.RUnlock()
+	if ok {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+	return p.CarSupplier.ListMultihashes(ctx, p.provider.ID, contextID)
+}
@@ -0,0 +1,217 @@
+package ipni
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipni/go-indexer-core"
+	md "github.com/ipni/go-libipni/metadata"
+	provider "github.com/ipni/index-provider"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DefaultIndexBatchSize is how many multihashes IndexCar and
+// IndexBlockstore put into the index at once
+const DefaultIndexBatchSize = 4096
+
+// BlockstoreContextID is the context ID IndexBlockstore indexes the store
+// name under
+func BlockstoreContextID(name string) []byte {
+	sum := sha256.Sum256([]byte("blockstore:" + name))
+	return sum[:]
+}
+
+// BlockLister enumerates a blockstore, e.g. a PersistentWrapper
+type BlockLister interface {
+	AllKeysChan(ctx context.Context) (<-chan cid.Cid, error)
+}
+
+// IndexProgress reports a running IndexCar or IndexBlockstore
+type IndexProgress struct {
+	ContextID []byte
+	Indexed   int // multihashes indexed so far
+	Batches   int
+	Elapsed   time.Duration
+	Finished  bool
+	// Advertisement is the advertisement published for the dataset, if
+	// Config.AnnounceIndexed is set and provider is the local one
+	Advertisement cid.Cid
+}
+
+// OnIndexProgress registers fn to be called after every batch IndexCar and
+// IndexBlockstore index, and once when they finish. fn runs on the
+// indexing goroutine and should not block.
+func (w *IPNIWrapper) OnIndexProgress(fn func(IndexProgress)) {
+	w.onIndex = fn
+}
+
+// IndexCar indexes every block of the CAR (v1 or v2) at carPath as held by
+// provider, in batches, under a context ID derived from the file's path;
+// indexing the same file again updates the same records. Blocks are
+// skipped over, not read. It returns the final progress.
+func (w *IPNIWrapper) IndexCar(ctx context.Context, carPath string, providerID peer.ID, metadata []byte) (IndexProgress, error) {
+	abs, err := filepath.Abs(carPath)
+	if err != nil {
+		return IndexProgress{}, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return IndexProgress{}, fmt.Errorf("open CAR: %w", err)
+	}
+	defer f.Close()
+	br, err := carv2.NewBlockReader(f)
+	if err != nil {
+		return IndexProgress{}, fmt.Errorf("read CAR header: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+	b := w.newIndexBatch(indexer.Value{ProviderID: providerID, ContextID: sum[:], MetadataBytes: metadata})
+	for {
+		if err := ctx.Err(); err != nil {
+			return b.progress, err
+		}
+		meta, err := br.SkipNext()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return b.progress, fmt.Errorf("read CAR block: %w", err)
+		}
+		if err := b.add(meta.Cid.Hash()); err != nil {
+			return b.progress, err
+		}
+	}
+	return b.finish(ctx)
+}
+
+// IndexBlockstore indexes every block in bs as held by provider over
+// bitswap, in batches, under the BlockstoreContextID of name; indexing
+// the same name again updates the same records. It returns the final
+// progress.
+func (w *IPNIWrapper) IndexBlockstore(ctx context.Context, name string, bs BlockLister, providerID peer.ID) (IndexProgress, error) {
+	// stops the listing if indexing fails part way
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return IndexProgress{}, fmt.Errorf("list blocks: %w", err)
+	}
+
+	b := w.newIndexBatch(indexer.Value{ProviderID: providerID, ContextID: BlockstoreContextID(name), MetadataBytes: bitswapMeta})
+	for c := range keys {
+		if err := b.add(c.Hash()); err != nil {
+			return b.progress, err
+		}
+	}
+	// AllKeysChan closes early, without an error, if ctx ends
+	if err := ctx.Err(); err != nil {
+		return b.progress, err
+	}
+	return b.finish(ctx)
+}
+
+// indexBatch collects multihashes and puts them into the index batchSize
+// at a time
+type indexBatch struct {
+	w        *IPNIWrapper
+	val      indexer.Value
+	size     int
+	pending  []mh.Multihash
+	start    time.Time
+	progress IndexProgress
+
+	announce bool
+	indexed  []mh.Multihash // everything indexed, when announcing
+}
+
+func (w *IPNIWrapper) newIndexBatch(val indexer.Value) *indexBatch {
+	size := w.batchSize
+	if size <= 0 {
+		size = DefaultIndexBatchSize
+	}
+	return &indexBatch{
+		w:        w,
+		val:      val,
+		size:     size,
+		pending:  make([]mh.Multihash, 0, size),
+		start:    time.Now(),
+		progress: IndexProgress{ContextID: val.ContextID},
+		announce: w.announce && val.ProviderID == w.Provider.ProviderID(),
+	}
+}
+
+func (b *indexBatch) add(m mh.Multihash) error {
+	b.pending = append(b.pending, m)
+	if len(b.pending) < b.size {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *indexBatch) flush() error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	if err := b.w.PutMultihashes(b.val, b.pending...); err != nil {
+		return fmt.Errorf("index batch %d: %w", b.progress.Batches+1, err)
+	}
+	b.progress.Indexed += len(b.pending)
+	b.progress.Batches++
+	if b.announce {
+		b.indexed = append(b.indexed, b.pending...)
+	}
+	b.pending = b.pending[:0]
+	b.report()
+	return nil
+}
+
+func (b *indexBatch) finish(ctx context.Context) (IndexProgress, error) {
+	if err := b.flush(); err != nil {
+		return b.progress, err
+	}
+	if b.announce && len(b.indexed) > 0 {
+		if err := b.advertise(ctx); err != nil {
+			return b.progress, err
+		}
+	}
+	b.progress.Finished = true
+	b.report()
+	return b.progress, nil
+}
+
+// advertise publishes everything indexed in one advertisement; the
+// provider engine splits its entries into chunks. A dataset that gained
+// or lost blocks since it was last indexed is advertised afresh.
+func (b *indexBatch) advertise(ctx context.Context) error {
+	meta := md.Default.New()
+	if err := meta.UnmarshalBinary(b.val.MetadataBytes); err != nil {
+		return fmt.Errorf("announce: metadata: %w", err)
+	}
+	adCid, err := b.w.Provider.Readvertise(ctx, b.val.ContextID, meta, b.indexed...)
+	switch {
+	case errors.Is(err, provider.ErrAlreadyAdvertised):
+		// indexed before with the same blocks and metadata; the
+		// advertisement published then still covers them
+	case err != nil:
+		return fmt.Errorf("announce: %w", err)
+	default:
+		b.progress.Advertisement = adCid
+	}
+	return nil
+}
+
+func (b *indexBatch) report() {
+	b.progress.Elapsed = time.Since(b.start)
+	if b.w.onIndex != nil {
+		b.w.onIndex(b.progress)
+	}
+}
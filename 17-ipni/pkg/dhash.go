@@ -0,0 +1,212 @@
+package ipni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/dhash"
+	"github.com/libp2p/go-libp2p/core/peer"
+	b58 "github.com/mr-tron/base58/base58"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Reader privacy: alongside the plain index, every record is kept keyed by
+// the multihash's second hash with the provider and context ID (the value
+// key) encrypted under the original multihash, and the metadata encrypted
+// under the value key. Only a reader who already knows the multihash can
+// decrypt what a double-hashed lookup returns, so the index never learns
+// which CID was asked for.
+//
+//	/ipni/dh/mh/<second hash>/<value key hash>  encrypted value key
+//	/ipni/dh/md/<value key hash>                encrypted metadata
+//	/ipni/dh/vk/<value key hash>/<second hash>  for removing a context ID
+//	/ipni/dh/pv/<peer>/<value key hash>         for RemoveProvider
+var (
+	dhMultihashPrefix = ds.NewKey("/ipni/dh/mh")
+	dhMetadataPrefix  = ds.NewKey("/ipni/dh/md")
+	dhValueKeyPrefix  = ds.NewKey("/ipni/dh/vk")
+	dhProviderPrefix  = ds.NewKey("/ipni/dh/pv")
+)
+
+// ErrReaderPrivacyDisabled is returned by double-hashed lookups on an index
+// created without Config.ReaderPrivacy
+var ErrReaderPrivacyDisabled = errors.New("reader privacy is not enabled")
+
+func valueKeyHash(vk []byte) string {
+	return b58.Encode(dhash.SHA256(vk, nil))
+}
+
+func dhMultihashKey(m mh.Multihash, vkHash string) ds.Key {
+	return dhMultihashPrefix.ChildString(dhash.SecondMultihash(m).B58String()).ChildString(vkHash)
+}
+
+func dhValueKeyKey(m mh.Multihash, vkHash string) ds.Key {
+	return dhValueKeyPrefix.ChildString(vkHash).ChildString(dhash.SecondMultihash(m).B58String())
+}
+
+// putDH adds val for mhs to the double-hashed index
+func (w *IPNIWrapper) putDH(ctx context.Context, val indexer.Value, mhs []mh.Multihash) error {
+	vk := dhash.CreateValueKey(val.ProviderID, val.ContextID)
+	vkHash := valueKeyHash(vk)
+	encMeta, err := dhash.EncryptMetadata(val.MetadataBytes, vk)
+	if err != nil {
+		return fmt.Errorf("encrypt metadata: %w", err)
+	}
+
+	b, err := w.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range mhs {
+		evk, err := dhash.EncryptValueKey(vk, m)
+		if err != nil {
+			return fmt.Errorf("encrypt value key: %w", err)
+		}
+		if err := b.Put(ctx, dhMultihashKey(m, vkHash), evk); err != nil {
+			return err
+		}
+		if err := b.Put(ctx, dhValueKeyKey(m, vkHash), nil); err != nil {
+			return err
+		}
+	}
+	if err := b.Put(ctx, dhMetadataPrefix.ChildString(vkHash), encMeta); err != nil {
+		return err
+	}
+	if err := b.Put(ctx, dhProviderPrefix.ChildString(val.ProviderID.String()).ChildString(vkHash), nil); err != nil {
+		return err
+	}
+	return b.Commit(ctx)
+}
+
+// removeDH drops mhs of val from the double-hashed index
+func (w *IPNIWrapper) removeDH(ctx context.Context, val indexer.Value, mhs []mh.Multihash) error {
+	vkHash := valueKeyHash(dhash.CreateValueKey(val.ProviderID, val.ContextID))
+	b, err := w.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range mhs {
+		if err := b.Delete(ctx, dhMultihashKey(m, vkHash)); err != nil {
+			return err
+		}
+		if err := b.Delete(ctx, dhValueKeyKey(m, vkHash)); err != nil {
+			return err
+		}
+	}
+	return b.Commit(ctx)
+}
+
+// removeDHContext drops a provider's context ID: its metadata and every
+// multihash entry of it
+func (w *IPNIWrapper) removeDHContext(ctx context.Context, pid peer.ID, contextID []byte) error {
+	vkHash := valueKeyHash(dhash.CreateValueKey(pid, contextID))
+	b, err := w.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	if err := w.deleteDHValueKey(ctx, b, vkHash); err != nil {
+		return err
+	}
+	if err := b.Delete(ctx, dhProviderPrefix.ChildString(pid.String()).ChildString(vkHash)); err != nil {
+		return err
+	}
+	return b.Commit(ctx)
+}
+
+// deleteDHValueKey adds the deletion of everything stored for vkHash but
+// its provider entry to b
+func (w *IPNIWrapper) deleteDHValueKey(ctx context.Context, b ds.Batch, vkHash string) error {
+	prefix := dhValueKeyPrefix.ChildString(vkHash)
+	res, err := w.ds.Query(ctx, query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		if err := b.Delete(ctx, dhMultihashPrefix.ChildString(k.BaseNamespace()).ChildString(vkHash)); err != nil {
+			return err
+		}
+		if err := b.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return b.Delete(ctx, dhMetadataPrefix.ChildString(vkHash))
+}
+
+// removeDHProvider drops every context ID of pid
+func (w *IPNIWrapper) removeDHProvider(ctx context.Context, pid peer.ID) error {
+	res, err := w.ds.Query(ctx, query.Query{Prefix: dhProviderPrefix.ChildString(pid.String()).String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	b, err := w.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		if err := w.deleteDHValueKey(ctx, b, k.BaseNamespace()); err != nil {
+			return err
+		}
+		if err := b.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return b.Commit(ctx)
+}
+
+// FindEncrypted returns the encrypted value keys indexed under the second
+// hash of a multihash, as served at GET /encrypted/multihash/{hash}
+func (w *IPNIWrapper) FindEncrypted(ctx context.Context, secondHash mh.Multihash) ([][]byte, error) {
+	if !w.privacy {
+		return nil, ErrReaderPrivacyDisabled
+	}
+	res, err := w.ds.Query(ctx, query.Query{Prefix: dhMultihashPrefix.ChildString(secondHash.B58String()).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var out [][]byte
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		// skip entries whose context ID was removed before its entries
+		// were tracked
+		has, err := w.ds.Has(ctx, dhMetadataPrefix.ChildString(ds.RawKey(r.Key).BaseNamespace()))
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			out = append(out, r.Value)
+		}
+	}
+	return out, nil
+}
+
+// FindEncryptedMetadata returns the encrypted metadata stored under the
+// hash of a value key, as served at GET /metadata/{hash}; nil if there is
+// none
+func (w *IPNIWrapper) FindEncryptedMetadata(ctx context.Context, valueKeyHash []byte) ([]byte, error) {
+	if !w.privacy {
+		return nil, ErrReaderPrivacyDisabled
+	}
+	b, err := w.ds.Get(ctx, dhMetadataPrefix.ChildString(b58.Encode(valueKeyHash)))
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, nil
+	}
+	return b, err
+}
@@ -0,0 +1,247 @@
+package ipni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/dhash"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	b58 "github.com/mr-tron/base58/base58"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DefaultIndexerURL is the public indexer run by cid.contact
+const DefaultIndexerURL = "https://cid.contact"
+
+// FindClient queries a remote IPNI indexer over the HTTP find API
+// (GET /cid/{cid} and GET /multihash/{multihash}), as served by
+// cid.contact and storetheindex.
+type FindClient struct {
+	baseURL string
+	client  *http.Client
+	private bool
+}
+
+// NewFindClient creates a client for the indexer at baseURL. An empty
+// baseURL means DefaultIndexerURL; a nil client gets a 30s timeout.
+func NewFindClient(baseURL string, client *http.Client) *FindClient {
+	if baseURL == "" {
+		baseURL = DefaultIndexerURL
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &FindClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// NewPrivateFindClient is NewFindClient for reader-privacy lookups: the
+// indexer is only sent the second hash of a multihash and answers with
+// records encrypted under the multihash itself, which the client decrypts.
+// The indexer never learns which CID was looked up, at the cost of a
+// metadata request per record and a provider request per provider.
+func NewPrivateFindClient(baseURL string, client *http.Client) *FindClient {
+	f := NewFindClient(baseURL, client)
+	f.private = true
+	return f
+}
+
+// FindRecord is one provider record returned by an indexer
+type FindRecord struct {
+	Provider  *model.ProviderInfo // peer ID and addresses of the provider
+	ContextID []byte
+	Metadata  []byte
+}
+
+// Value converts the record to the form the local engine and the planner use
+func (r FindRecord) Value() indexer.Value {
+	return indexer.Value{
+		ProviderID:    r.Provider.AddrInfo.ID,
+		ContextID:     r.ContextID,
+		MetadataBytes: r.Metadata,
+	}
+}
+
+// FindCID looks up the providers of c. No records and no error means the
+// indexer does not know c.
+func (f *FindClient) FindCID(ctx context.Context, c cid.Cid) ([]FindRecord, error) {
+	if f.private {
+		return f.findPrivate(ctx, c.Hash())
+	}
+	return f.find(ctx, "/cid/"+c.String())
+}
+
+// Find looks up the providers of a multihash
+func (f *FindClient) Find(ctx context.Context, m mh.Multihash) ([]FindRecord, error) {
+	if f.private {
+		return f.findPrivate(ctx, m)
+	}
+	return f.find(ctx, "/multihash/"+m.B58String())
+}
+
+func (f *FindClient) find(ctx context.Context, path string) ([]FindRecord, error) {
+	body, err := f.get(ctx, path)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	res, err := model.UnmarshalFindResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode find response: %w", err)
+	}
+	return normalizeFindResponse(res), nil
+}
+
+// findPrivate does a double-hashed lookup of m. Records that cannot be
+// decrypted or whose metadata is gone are skipped, as are provider
+// addresses the indexer does not know.
+func (f *FindClient) findPrivate(ctx context.Context, m mh.Multihash) ([]FindRecord, error) {
+	body, err := f.get(ctx, "/encrypted/multihash/"+dhash.SecondMultihash(m).B58String())
+	if err != nil || body == nil {
+		return nil, err
+	}
+	res, err := model.UnmarshalFindResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode find response: %w", err)
+	}
+
+	var out []FindRecord
+	providers := make(map[peer.ID]*model.ProviderInfo)
+	for _, emr := range res.EncryptedMultihashResults {
+		for _, evk := range emr.EncryptedValueKeys {
+			vk, err := dhash.DecryptValueKey(evk, m)
+			if err != nil {
+				continue
+			}
+			pid, contextID, err := dhash.SplitValueKey(vk)
+			if err != nil {
+				continue
+			}
+			meta, err := f.metadata(ctx, vk)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil {
+				continue
+			}
+			pr := model.ProviderResult{ContextID: contextID, Metadata: meta, Provider: &peer.AddrInfo{ID: pid}}
+			if containsResult(out, pr) {
+				continue
+			}
+			info, ok := providers[pid]
+			if !ok {
+				info = f.providerInfo(ctx, pid)
+				providers[pid] = info
+			}
+			out = append(out, FindRecord{Provider: info, ContextID: contextID, Metadata: meta})
+		}
+	}
+	return out, nil
+}
+
+// metadata fetches and decrypts the metadata of value key vk; nil if the
+// indexer has none
+func (f *FindClient) metadata(ctx context.Context, vk []byte) ([]byte, error) {
+	body, err := f.get(ctx, "/metadata/"+b58.Encode(dhash.SHA256(vk, nil)))
+	if err != nil || body == nil {
+		return nil, err
+	}
+	var res struct{ EncryptedMetadata []byte }
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata response: %w", err)
+	}
+	meta, err := dhash.DecryptMetadata(res.EncryptedMetadata, vk)
+	if err != nil {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+// providerInfo asks the indexer for pid's addresses, falling back to the
+// bare peer ID
+func (f *FindClient) providerInfo(ctx context.Context, pid peer.ID) *model.ProviderInfo {
+	info := &model.ProviderInfo{AddrInfo: peer.AddrInfo{ID: pid}}
+	body, err := f.get(ctx, "/providers/"+pid.String())
+	if err != nil || body == nil {
+		return info
+	}
+	var got model.ProviderInfo
+	if json.Unmarshal(body, &got) == nil && got.AddrInfo.ID == pid {
+		return &got
+	}
+	return info
+}
+
+// get returns the body of a GET to path; nil without error on 404
+func (f *FindClient) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("find request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("find %s: HTTP %d", path, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return body, nil
+}
+
+// normalizeFindResponse flattens the per-multihash results, dropping
+// records without a provider and repeats of the same provider record
+func normalizeFindResponse(res *model.FindResponse) []FindRecord {
+	var out []FindRecord
+	for _, mr := range res.MultihashResults {
+		for _, pr := range mr.ProviderResults {
+			if pr.Provider == nil || pr.Provider.ID == "" {
+				continue
+			}
+			if containsResult(out, pr) {
+				continue
+			}
+			out = append(out, FindRecord{
+				Provider:  &model.ProviderInfo{AddrInfo: *pr.Provider},
+				ContextID: pr.ContextID,
+				Metadata:  pr.Metadata,
+			})
+		}
+	}
+	return out
+}
+
+func containsResult(recs []FindRecord, pr model.ProviderResult) bool {
+	for _, r := range recs {
+		if sameValue(r.Value(), pr.Provider.ID, pr.ContextID, pr.Metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(v indexer.Value, id peer.ID, contextID, metadata []byte) bool {
+	return v.ProviderID == id && bytes.Equal(v.ContextID, contextID) && bytes.Equal(v.MetadataBytes, metadata)
+}
@@ -0,0 +1,368 @@
+package ipni
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
+)
+
+const (
+	DefaultProbeTimeout   = 5 * time.Second
+	DefaultHealthHalfLife = 10 * time.Minute
+	defaultProbeWorkers   = 16
+
+	// each probe moves the success rate and latency this far toward the
+	// new sample
+	healthAlpha = 0.3
+)
+
+// probePath is fetched with HEAD from HTTP providers: the empty identity
+// CID, which any trustless gateway can answer without storage
+const probePath = "/ipfs/bafkqaaa"
+
+// ProviderHealth is what probing learned about one provider transport
+type ProviderHealth struct {
+	Probes      int
+	Failures    int
+	SuccessRate float64       // moving average of probe outcomes, 0..1
+	Latency     time.Duration // moving average of successful probes
+	LastProbe   time.Time
+	LastSuccess time.Time
+	LastError   string
+}
+
+// Score is SuccessRate halved for every halfLife since the last success,
+// so providers that stop responding fade out even between probes
+func (h ProviderHealth) Score(now time.Time, halfLife time.Duration) float64 {
+	if h.LastSuccess.IsZero() {
+		return 0
+	}
+	if halfLife <= 0 {
+		return h.SuccessRate
+	}
+	age := now.Sub(h.LastSuccess)
+	return h.SuccessRate * math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// HealthSource scores provider transports for the planner; ok is false for
+// ones never probed
+type HealthSource interface {
+	HealthScore(p peer.ID, tk TransportKind) (score float64, ok bool)
+}
+
+// ProbeConfig tunes StartHealthProbe. Zero values keep the defaults.
+type ProbeConfig struct {
+	Interval    time.Duration // between probe rounds (default 30s)
+	Timeout     time.Duration // per probe (default 5s)
+	HalfLife    time.Duration // score decay without successes (default 10m)
+	Concurrency int           // probes at once (default 16)
+	HTTPClient  *http.Client  // for HTTP providers (default one with Timeout)
+}
+
+type healthKey struct {
+	pid peer.ID
+	tk  TransportKind
+}
+
+// healthTracker holds probe results and the providers worth probing
+type healthTracker struct {
+	mu       sync.RWMutex
+	halfLife time.Duration
+	targets  map[healthKey]struct{}
+	health   map[healthKey]*ProviderHealth
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		halfLife: DefaultHealthHalfLife,
+		targets:  make(map[healthKey]struct{}),
+		health:   make(map[healthKey]*ProviderHealth),
+	}
+}
+
+func (t *healthTracker) track(pid peer.ID, tk TransportKind) {
+	if tk == TLocal || tk == TUnknown {
+		return
+	}
+	k := healthKey{pid, tk}
+	t.mu.RLock()
+	_, ok := t.targets[k]
+	t.mu.RUnlock()
+	if ok {
+		return
+	}
+	t.mu.Lock()
+	t.targets[k] = struct{}{}
+	t.mu.Unlock()
+}
+
+func (t *healthTracker) forget(pid peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.targets {
+		if k.pid == pid {
+			delete(t.targets, k)
+			delete(t.health, k)
+		}
+	}
+}
+
+func (t *healthTracker) keys() []healthKey {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]healthKey, 0, len(t.targets))
+	for k := range t.targets {
+		out = append(out, k)
+	}
+	return out
+}
+
+func (t *healthTracker) record(k healthKey, rtt time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.health[k]
+	if !ok {
+		h = &ProviderHealth{}
+		t.health[k] = h
+	}
+	now := time.Now()
+	h.Probes++
+	h.LastProbe = now
+	outcome := 0.0
+	if err != nil {
+		h.Failures++
+		h.LastError = err.Error()
+	} else {
+		outcome = 1
+		h.LastSuccess = now
+		h.LastError = ""
+		if h.Latency == 0 {
+			h.Latency = rtt
+		} else {
+			h.Latency += time.Duration(healthAlpha * float64(rtt-h.Latency))
+		}
+	}
+	if h.Probes == 1 {
+		h.SuccessRate = outcome
+	} else {
+		h.SuccessRate += healthAlpha * (outcome - h.SuccessRate)
+	}
+}
+
+func (t *healthTracker) get(k healthKey) (ProviderHealth, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	h, ok := t.health[k]
+	if !ok {
+		return ProviderHealth{}, false
+	}
+	return *h, true
+}
+
+// each calls fn with every probed provider transport and its score
+func (t *healthTracker) each(fn func(p peer.ID, tk TransportKind, h ProviderHealth, score float64)) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	now := time.Now()
+	for k, h := range t.health {
+		fn(k.pid, k.tk, *h, h.Score(now, t.halfLife))
+	}
+}
+
+func (t *healthTracker) setHalfLife(d time.Duration) {
+	t.mu.Lock()
+	t.halfLife = d
+	t.mu.Unlock()
+}
+
+func (t *healthTracker) HealthScore(p peer.ID, tk TransportKind) (float64, bool) {
+	h, ok := t.get(healthKey{p, tk})
+	if !ok {
+		return 0, false
+	}
+	t.mu.RLock()
+	halfLife := t.halfLife
+	t.mu.RUnlock()
+	return h.Score(time.Now(), halfLife), true
+}
+
+// track schedules the provider behind v for probing; this host is never
+// probed
+func (w *IPNIWrapper) track(v indexer.Value) {
+	if v.ProviderID == w.host.ID() {
+		return
+	}
+	w.health.track(v.ProviderID, ExportTransportKind(v))
+}
+
+// ProviderHealth returns the probe results for a provider transport
+func (w *IPNIWrapper) ProviderHealth(p peer.ID, tk TransportKind) (ProviderHealth, bool) {
+	return w.health.get(healthKey{p, tk})
+}
+
+// SetHealthSource replaces the provider health used to rank providers. By
+// default the wrapper's own probe results are used; nil disables health
+// ranking.
+func (w *IPNIWrapper) SetHealthSource(src HealthSource) {
+	w.healthSrc = src
+}
+
+// HealthProbe probes indexed providers in the background until its
+// context ends
+type HealthProbe struct {
+	done chan struct{}
+}
+
+// Done is closed once the probe has stopped
+func (hp *HealthProbe) Done() <-chan struct{} {
+	return hp.done
+}
+
+// StartHealthProbe probes every provider the index has records for once
+// per interval: a libp2p ping for bitswap and graphsync providers, an HTTP
+// HEAD for HTTP ones. Results feed ProviderHealth and the planner's
+// ranking.
+func (w *IPNIWrapper) StartHealthProbe(ctx context.Context, cfg *ProbeConfig) *HealthProbe {
+	cfg = cfg.withDefaults()
+	w.health.setHalfLife(cfg.HalfLife)
+
+	hp := &HealthProbe{done: make(chan struct{})}
+	go func() {
+		defer close(hp.done)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			w.probe(ctx, cfg)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return hp
+}
+
+// ProbeProviders runs one probe round now and returns how many provider
+// transports it probed
+func (w *IPNIWrapper) ProbeProviders(ctx context.Context, cfg *ProbeConfig) int {
+	cfg = cfg.withDefaults()
+	w.health.setHalfLife(cfg.HalfLife)
+	return w.probe(ctx, cfg)
+}
+
+func (cfg *ProbeConfig) withDefaults() *ProbeConfig {
+	var c ProbeConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Interval <= 0 {
+		c.Interval = network.DefaultProbeInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultProbeTimeout
+	}
+	if c.HalfLife <= 0 {
+		c.HalfLife = DefaultHealthHalfLife
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultProbeWorkers
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: c.Timeout}
+	}
+	return &c
+}
+
+func (w *IPNIWrapper) probe(ctx context.Context, cfg *ProbeConfig) int {
+	keys := w.health.keys()
+	sem := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return 0
+		}
+		wg.Add(1)
+		go func(k healthKey) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+			defer cancel()
+			rtt, err := w.probeOne(pctx, cfg.HTTPClient, k)
+			if ctx.Err() != nil {
+				// shutting down, not the provider's fault
+				return
+			}
+			w.health.record(k, rtt, err)
+		}(k)
+	}
+	wg.Wait()
+	return len(keys)
+}
+
+func (w *IPNIWrapper) probeOne(ctx context.Context, client *http.Client, k healthKey) (time.Duration, error) {
+	if k.tk != THTTP {
+		return w.host.Ping(ctx, k.pid)
+	}
+	urls := httpURLs(w.host.Peerstore().Addrs(k.pid))
+	if len(urls) == 0 {
+		return 0, fmt.Errorf("no HTTP address for %s", k.pid)
+	}
+	var lastErr error
+	for _, u := range urls {
+		rtt, err := headProbe(ctx, client, u+probePath)
+		if err == nil {
+			return rtt, nil
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+func headProbe(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return 0, fmt.Errorf("HEAD %s: HTTP %d", url, resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// httpURLs converts the /http and /https multiaddrs among addrs to URLs
+func httpURLs(addrs []multiaddr.Multiaddr) []string {
+	var out []string
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(multiaddr.P_HTTP); err != nil {
+			if _, err := a.ValueForProtocol(multiaddr.P_HTTPS); err != nil {
+				continue
+			}
+		}
+		u, err := maurl.ToURL(a)
+		if err != nil {
+			continue
+		}
+		out = append(out, u.String())
+	}
+	return out
+}
@@ -5,14 +5,14 @@ import (
 	"fmt"
 
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
 	"github.com/ipni/go-indexer-core"
-	"github.com/ipni/go-indexer-core/cache/radixcache"
 	"github.com/ipni/go-indexer-core/engine"
-	"github.com/ipni/go-indexer-core/store/memory"
-	"github.com/ipni/go-indexer-core/store/pebble"
 	md "github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	mh "github.com/multiformats/go-multihash"
+	"github.com/rs/zerolog/log"
 
 	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
 	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
@@ -24,18 +24,39 @@ type IPNIWrapper struct {
 
 	Provider   *ProviderWrapper
 	Subscriber *SubscriberWrapper
+
+	host    *network.HostWrapper
+	latency network.LatencySource
+	finder  *FindClient
+
+	health    *healthTracker // probe results
+	healthSrc HealthSource
+
+	privacy bool // keep the double-hashed index
+
+	batchSize int // IndexCar and IndexBlockstore
+	announce  bool
+	onIndex   func(IndexProgress)
+
+	ds     ds.Batching // sync state
+	cancel context.CancelFunc
 }
 
 func New(path, topic string, persistentWrapper *persistent.PersistentWrapper, hostWrapper *network.HostWrapper, ipldWrapper *ipldprime.IpldWrapper) (*IPNIWrapper, error) {
-	var err error
-	var store indexer.Interface
-	if path == "" {
-		store = memory.New()
-	} else {
-		store, err = pebble.New(path, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create pebble store: %w", err)
-		}
+	return NewWithConfig(path, topic, persistentWrapper, hostWrapper, ipldWrapper, nil)
+}
+
+// NewWithConfig is New with a choice of value store and cache. A pebble
+// index reopened from path keeps everything indexed before; with a
+// persistent datastore, ingestion also resumes from the last advertisement
+// synced from each publisher.
+func NewWithConfig(path, topic string, persistentWrapper *persistent.PersistentWrapper, hostWrapper *network.HostWrapper, ipldWrapper *ipldprime.IpldWrapper, cfg *Config) (*IPNIWrapper, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	store, err := openStore(path, cfg)
+	if err != nil {
+		return nil, err
 	}
 	if topic == "" {
 		topic = MakeTopic("index")
@@ -70,14 +91,27 @@ func New(path, topic string, persistentWrapper *persistent.PersistentWrapper, ho
 		return nil, fmt.Errorf("failed to create subscriber: %w", err)
 	}
 
-	cache := radixcache.New(4 * 1024 * 1024)
-	eng := engine.New(store, engine.WithCache(cache), engine.WithCacheOnPut(true))
-
-	return &IPNIWrapper{
-		Engine:     eng,
+	ctx, cancel := context.WithCancel(context.Background())
+	health := newHealthTracker()
+	w := &IPNIWrapper{
+		Engine:     newEngine(store, cfg),
 		Provider:   provider,
 		Subscriber: subscriber,
-	}, nil
+		host:       hostWrapper,
+		latency:    hostWrapper,
+		health:     health,
+		healthSrc:  health,
+		privacy:    cfg.ReaderPrivacy,
+		batchSize:  cfg.IndexBatchSize,
+		announce:   cfg.AnnounceIndexed,
+		ds:         persistentWrapper.Batching,
+		cancel:     cancel,
+	}
+	subscriber.OnSynced(w.saveSync)
+	if cfg.FlushInterval > 0 {
+		go w.flushLoop(ctx, cfg.FlushInterval)
+	}
+	return w, nil
 }
 
 func (w *IPNIWrapper) Start(ctx context.Context) error {
@@ -86,6 +120,16 @@ func (w *IPNIWrapper) Start(ctx context.Context) error {
 		return fmt.Errorf("provider engine start: %w", err)
 	}
 
+	// resume ingestion where it stopped
+	n, err := w.restoreSync(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		size, _ := w.Engine.Size()
+		log.Info().Int("publishers", n).Int64("bytes", size).Msg("recovered index")
+	}
+
 	// start subscriber
 	if err := w.Subscriber.Start(ctx, w.Put, w.Remove); err != nil {
 		return fmt.Errorf("subscriber start: %w", err)
@@ -94,7 +138,13 @@ func (w *IPNIWrapper) Start(ctx context.Context) error {
 	return nil
 }
 
-func (w *IPNIWrapper) Close() error                   { return w.Engine.Close() }
+// Close stops the periodic flush and closes the value store, which
+// flushes it
+func (w *IPNIWrapper) Close() error {
+	w.cancel()
+	return w.Engine.Close()
+}
+
 func (w *IPNIWrapper) Flush() error                   { return w.Engine.Flush() }
 func (w *IPNIWrapper) Size() (int64, error)           { return w.Engine.Size() }
 func (w *IPNIWrapper) Stats() (*indexer.Stats, error) { return w.Engine.Stats() }
@@ -103,7 +153,16 @@ func (w *IPNIWrapper) PutMultihashes(val indexer.Value, mhs ...mh.Multihash) err
 	if len(mhs) == 0 {
 		return nil
 	}
-	return w.Engine.Put(val, mhs...)
+	if err := w.Engine.Put(val, mhs...); err != nil {
+		return err
+	}
+	w.track(val)
+	if w.privacy {
+		if err := w.putDH(context.Background(), val, mhs); err != nil {
+			return fmt.Errorf("double-hashed index: %w", err)
+		}
+	}
+	return nil
 }
 
 func (w *IPNIWrapper) Put(providerID peer.ID, contextID []byte, metadataBytes []byte, mhs ...mh.Multihash) error {
@@ -158,15 +217,34 @@ func (w *IPNIWrapper) PutHTTP(pid peer.ID, contextID []byte, c ...cid.Cid) error
 }
 
 func (w *IPNIWrapper) RemoveMultihashes(val indexer.Value, mhs ...mh.Multihash) error {
-	return w.Engine.Remove(val, mhs...)
+	if err := w.Engine.Remove(val, mhs...); err != nil {
+		return err
+	}
+	if w.privacy {
+		return w.removeDH(context.Background(), val, mhs)
+	}
+	return nil
 }
 
 func (w *IPNIWrapper) Remove(id peer.ID, contextID []byte) error {
-	return w.Engine.RemoveProviderContext(id, contextID)
+	if err := w.Engine.RemoveProviderContext(id, contextID); err != nil {
+		return err
+	}
+	if w.privacy {
+		return w.removeDHContext(context.Background(), id, contextID)
+	}
+	return nil
 }
 
 func (w *IPNIWrapper) RemoveProvider(ctx context.Context, id peer.ID) error {
-	return w.Engine.RemoveProvider(ctx, id)
+	if err := w.Engine.RemoveProvider(ctx, id); err != nil {
+		return err
+	}
+	w.health.forget(id)
+	if w.privacy {
+		return w.removeDHProvider(ctx, id)
+	}
+	return nil
 }
 
 func (w *IPNIWrapper) GetProvidersByCID(c cid.Cid) ([]indexer.Value, bool, error) {
@@ -177,6 +255,12 @@ func (w *IPNIWrapper) GetProviders(mh mh.Multihash) ([]indexer.Value, bool, erro
 	return w.Engine.Get(mh)
 }
 
+// SetLatencySource replaces the RTT measurements used to rank providers.
+// By default the wrapper's own host is used; nil disables latency ranking.
+func (w *IPNIWrapper) SetLatencySource(src network.LatencySource) {
+	w.latency = src
+}
+
 // Planning helpers (scoring-only)
 // RankedFetchers returns a simplified prioritized list of (providerID, transport)
 // derived from the Plan, for easy wiring into a multifetcher.
@@ -218,31 +302,77 @@ func (w *IPNIWrapper) RankedFetchers(ctx context.Context, mh mh.Multihash, inten
 	return out, hit, nil
 }
 
+// SetFindClient makes planning also ask a remote indexer such as
+// cid.contact. Its records are planned alongside the local ones, tagged
+// source=indexer, and their addresses are added to the host's peerstore so
+// the planned providers can be dialed. nil goes back to local records only.
+func (w *IPNIWrapper) SetFindClient(f *FindClient) {
+	w.finder = f
+}
+
 // PlanByCID reads local providers (engine), normalizes them, and returns a scoring-only Plan.
-// This does NOT fetch from a remote indexer and does NOT execute any network transfer.
+// This does NOT execute any network transfer; a remote indexer is only
+// queried if one was set with SetFindClient.
 func (w *IPNIWrapper) PlanByCID(ctx context.Context, c cid.Cid, intent Intent) ([]Attempt, bool, error) {
-	vals, hit, err := w.Engine.Get(c.Hash())
+	return w.Plan(ctx, c.Hash(), intent)
+}
+
+// Plan reads local providers (engine) by multihash, normalizes them, and returns a scoring-only Plan.
+func (w *IPNIWrapper) Plan(ctx context.Context, mh mh.Multihash, intent Intent) ([]Attempt, bool, error) {
+	vals, hit, err := w.Engine.Get(mh)
 	if err != nil {
 		return nil, hit, err
 	}
-	pl := Plan(vals, intent, nil)
-	if pl == nil {
-		hit = false
+	var getMeta GetMeta
+	if w.finder != nil && !intent.LocalOnly {
+		var remote []indexer.Value
+		vals, remote = w.findRemote(ctx, mh, vals)
+		getMeta = func(v indexer.Value) map[string]string {
+			for _, r := range remote {
+				if sameValue(r, v.ProviderID, v.ContextID, v.MetadataBytes) {
+					return map[string]string{"source": "indexer"}
+				}
+			}
+			return nil
+		}
+	}
+	for _, v := range vals {
+		w.track(v)
 	}
+	pl := PlanWithHealth(vals, intent, getMeta, w.latency, w.healthSrc)
+	hit = pl != nil
 
 	return pl, hit, nil
 }
 
-// Plan reads local providers (engine) by multihash, normalizes them, and returns a scoring-only Plan.
-func (w *IPNIWrapper) Plan(ctx context.Context, mh mh.Multihash, intent Intent) ([]Attempt, bool, error) {
-	vals, hit, err := w.Engine.Get(mh)
+// findRemote adds the remote indexer's records that are not already held
+// locally to vals. A failed lookup is logged and leaves vals as they are.
+func (w *IPNIWrapper) findRemote(ctx context.Context, m mh.Multihash, vals []indexer.Value) (all, remote []indexer.Value) {
+	recs, err := w.finder.Find(ctx, m)
 	if err != nil {
-		return nil, hit, err
+		log.Debug().Err(err).Str("multihash", m.B58String()).Msg("remote index lookup failed")
+		return vals, nil
 	}
-	pl := Plan(vals, intent, nil)
-	if pl == nil {
-		hit = false
+	all = vals
+	for _, r := range recs {
+		v := r.Value()
+		if containsValue(all, v) {
+			continue
+		}
+		if len(r.Provider.AddrInfo.Addrs) > 0 {
+			w.host.Peerstore().AddAddrs(v.ProviderID, r.Provider.AddrInfo.Addrs, peerstore.AddressTTL)
+		}
+		all = append(all, v)
+		remote = append(remote, v)
 	}
+	return all, remote
+}
 
-	return pl, hit, nil
+func containsValue(vals []indexer.Value, v indexer.Value) bool {
+	for _, have := range vals {
+		if sameValue(have, v.ProviderID, v.ContextID, v.MetadataBytes) {
+			return true
+		}
+	}
+	return false
 }
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/ipni/go-indexer-core"
+
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
 )
 
 type Attempt struct {
@@ -33,6 +35,15 @@ const (
 	partialCarBonus = 0.5
 	prefTopBonus    = 0.15
 	prefStep        = 0.05
+
+	// Measured RTTs move a provider's weight by up to ±latencyWeight,
+	// relative to latencyRef
+	latencyRef    = 200 * time.Millisecond
+	latencyWeight = 0.2
+
+	// Probe health moves a provider's weight by up to ±healthWeight: a
+	// score of 1 adds it all, 0 takes it all away
+	healthWeight = 0.3
 )
 
 var baseWeight = map[TransportKind]float64{
@@ -45,6 +56,21 @@ var baseWeight = map[TransportKind]float64{
 type GetMeta func(indexer.Value) map[string]string
 
 func Plan(vals []indexer.Value, in Intent, getMeta GetMeta) []Attempt {
+	return PlanWithLatency(vals, in, getMeta, nil)
+}
+
+// PlanWithLatency is Plan using measured RTTs where lat has them: a fast
+// provider (median RTT under 200ms) gains weight, a slow one loses it, and
+// the next attempt is staggered by the previous provider's p90 RTT instead
+// of the fixed default.
+func PlanWithLatency(vals []indexer.Value, in Intent, getMeta GetMeta, lat network.LatencySource) []Attempt {
+	return PlanWithHealth(vals, in, getMeta, lat, nil)
+}
+
+// PlanWithHealth is PlanWithLatency also weighing the probe scores health
+// has: providers answering their probes move up, ones that stopped
+// answering sink below everything unprobed.
+func PlanWithHealth(vals []indexer.Value, in Intent, getMeta GetMeta, lat network.LatencySource, health HealthSource) []Attempt {
 	wantPartial := strings.EqualFold(in.Format, "car") && !strings.EqualFold(in.Scope, "block")
 
 	prefBonus := map[TransportKind]float64{}
@@ -64,6 +90,8 @@ func Plan(vals []indexer.Value, in Intent, getMeta GetMeta) []Attempt {
 		tk   TransportKind
 		wt   float64
 		meta map[string]string
+		rtt  network.LatencyStats
+		has  bool
 	}
 	cs := make([]cand, 0, len(vals))
 
@@ -99,24 +127,50 @@ func Plan(vals []indexer.Value, in Intent, getMeta GetMeta) []Attempt {
 			wt += b
 		}
 
-		cs = append(cs, cand{id: pid, tk: tk, wt: wt, meta: meta})
+		var rtt network.LatencyStats
+		var has bool
+		if lat != nil && tk != TLocal {
+			if rtt, has = lat.PeerLatency(v.ProviderID); has {
+				wt += latencyBonus(rtt.P50)
+			}
+		}
+		if health != nil && tk != TLocal {
+			if score, ok := health.HealthScore(v.ProviderID, tk); ok {
+				wt += healthWeight * (2*score - 1)
+			}
+		}
+
+		cs = append(cs, cand{id: pid, tk: tk, wt: wt, meta: meta, rtt: rtt, has: has})
 	}
 
 	sort.SliceStable(cs, func(i, j int) bool { return cs[i].wt > cs[j].wt })
 
 	out := make([]Attempt, 0, len(cs))
+	var stagger time.Duration
 	for i, c := range cs {
+		if i > 0 {
+			if prev := cs[i-1]; prev.has {
+				stagger += prev.rtt.P90
+			} else {
+				stagger += defaultStagger
+			}
+		}
 		out = append(out, Attempt{
 			ProviderID: c.id,
 			Proto:      c.tk,
 			Weight:     c.wt,
-			Stagger:    defaultStagger * time.Duration(i),
+			Stagger:    stagger,
 			Meta:       c.meta,
 		})
 	}
 	return out
 }
 
+func latencyBonus(rtt time.Duration) float64 {
+	b := latencyWeight * (1 - float64(rtt)/float64(latencyRef))
+	return max(-latencyWeight, min(latencyWeight, b))
+}
+
 func contains(xs []string, x string) bool {
 	for _, s := range xs {
 		if s == x {
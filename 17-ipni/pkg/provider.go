@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipni/go-libipni/find/model"
@@ -12,6 +13,7 @@ import (
 	provengine "github.com/ipni/index-provider/engine"
 	carsupplier "github.com/ipni/index-provider/supplier"
 	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
 
 	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
 	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
@@ -27,9 +29,36 @@ type ProviderWrapper struct {
 	engine   *provengine.Engine
 
 	*carsupplier.CarSupplier
+
+	mu      sync.RWMutex
+	entries map[string][]mh.Multihash // multihashes advertised by contextID
+}
+
+// ProviderConfig tunes how advertisements are built and announced. Zero
+// values keep the index-provider defaults.
+type ProviderConfig struct {
+	// Indexer URLs sent a direct HTTP announce (PUT /announce) for every
+	// new advertisement, e.g. DefaultIndexerURL
+	AnnounceURLs []string
+	// Only announce over HTTP, not on the gossipsub topic
+	DisablePubsub bool
+	// Multihashes per entries chunk (default 16384)
+	EntriesChunkSize int
+	// Addresses advertised for retrieval (default the host's addresses)
+	RetrievalAddrs []string
+	// Plain HTTP address the ad chain is served on besides libp2p, e.g.
+	// "0.0.0.0:3104" for indexers that sync over HTTP (default libp2p only)
+	HTTPListenAddr string
 }
 
 func NewProviderWrapper(path, topic string, persistentWrapper *persistent.PersistentWrapper, hostWrapper *network.HostWrapper) (*ProviderWrapper, error) {
+	return NewProviderWrapperWithConfig(path, topic, persistentWrapper, hostWrapper, nil)
+}
+
+func NewProviderWrapperWithConfig(path, topic string, persistentWrapper *persistent.PersistentWrapper, hostWrapper *network.HostWrapper, cfg *ProviderConfig) (*ProviderWrapper, error) {
+	if cfg == nil {
+		cfg = &ProviderConfig{}
+	}
 	if path == "" {
 		path = os.TempDir()
 	}
@@ -54,26 +83,42 @@ func NewProviderWrapper(path, topic string, persistentWrapper *persistent.Persis
 		Addrs: hostWrapper.Addrs(),
 	}
 
-	eng, err := provengine.New(
+	opts := []provengine.Option{
 		provengine.WithDatastore(persistentWrapper.Batching),
 		provengine.WithHost(hostWrapper.Host),
 		provengine.WithTopicName(topic),
 		provengine.WithPublisherKind(provengine.Libp2pHttpPublisher),
-		provengine.WithPubsubAnnounce(true),
-	)
+		provengine.WithPubsubAnnounce(!cfg.DisablePubsub),
+		provengine.WithHttpPublisherListenAddr(cfg.HTTPListenAddr),
+	}
+	if len(cfg.AnnounceURLs) > 0 {
+		opts = append(opts, provengine.WithDirectAnnounce(cfg.AnnounceURLs...))
+	}
+	if cfg.EntriesChunkSize > 0 {
+		opts = append(opts, provengine.WithChainedEntries(cfg.EntriesChunkSize))
+	}
+	if len(cfg.RetrievalAddrs) > 0 {
+		opts = append(opts, provengine.WithRetrievalAddrs(cfg.RetrievalAddrs...))
+	}
+	eng, err := provengine.New(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create index provider: %w", err)
 	}
 
 	carSup := carsupplier.NewCarSupplier(eng, persistentWrapper.Batching)
 
-	return &ProviderWrapper{
+	p := &ProviderWrapper{
 		path:        path,
 		topic:       topic,
 		provider:    provider,
 		engine:      eng,
 		CarSupplier: carSup,
-	}, nil
+		entries:     make(map[string][]mh.Multihash),
+	}
+	// replaces the lister the CAR supplier registered; CAR context IDs
+	// are still listed by it
+	eng.RegisterMultihashLister(p.listMultihashes)
+	return p, nil
 }
 
 func (p *ProviderWrapper) ProviderID() peer.ID {
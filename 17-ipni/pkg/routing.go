@@ -0,0 +1,48 @@
+package ipni
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/rs/zerolog/log"
+)
+
+// ContentRouting answers provider lookups from the local index, so IPNI
+// can sit next to the DHT in a composed router. Addresses come from the
+// host's peerstore. Provide is not supported; publish advertisements
+// through Provider instead.
+func (w *IPNIWrapper) ContentRouting() routing.ContentRouting {
+	return &indexRouter{w: w}
+}
+
+type indexRouter struct {
+	w *IPNIWrapper
+}
+
+func (r *indexRouter) Provide(context.Context, cid.Cid, bool) error {
+	return routing.ErrNotSupported
+}
+
+func (r *indexRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	vals, _, err := r.w.GetProvidersByCID(c)
+	if err != nil {
+		log.Debug().Err(err).Str("cid", c.String()).Msg("index lookup failed")
+	}
+	// one value per provider and context ID; report each provider once
+	out := make(chan peer.AddrInfo, len(vals))
+	defer close(out)
+	seen := make(map[peer.ID]struct{}, len(vals))
+	for _, v := range vals {
+		if _, ok := seen[v.ProviderID]; ok {
+			continue
+		}
+		if count > 0 && len(seen) == count {
+			break
+		}
+		seen[v.ProviderID] = struct{}{}
+		out <- peer.AddrInfo{ID: v.ProviderID, Addrs: r.w.host.Peerstore().Addrs(v.ProviderID)}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package ipni
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	b58 "github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// FindHandler serves the local index over the IPNI HTTP find API, so a
+// FindClient (or any IPNI client) can query it:
+//
+//	GET /cid/{cid}                     provider records of a CID
+//	GET /multihash/{multihash}         provider records of a multihash
+//	GET /encrypted/multihash/{hash}    encrypted value keys (reader privacy)
+//	GET /metadata/{hash}               encrypted metadata (reader privacy)
+//	GET /providers/{peer}              addresses of a provider
+//
+// The encrypted endpoints answer 501 unless the index was created with
+// Config.ReaderPrivacy.
+func (w *IPNIWrapper) FindHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cid/", w.handleFindCID)
+	mux.HandleFunc("/multihash/", w.handleFindMultihash)
+	mux.HandleFunc("/encrypted/multihash/", w.handleFindEncrypted)
+	mux.HandleFunc("/metadata/", w.handleFindMetadata)
+	mux.HandleFunc("/providers/", w.handleFindProvider)
+	return mux
+}
+
+func (w *IPNIWrapper) handleFindCID(rw http.ResponseWriter, r *http.Request) {
+	c, err := cid.Parse(strings.TrimPrefix(r.URL.Path, "/cid/"))
+	if err != nil {
+		http.Error(rw, "Invalid CID", http.StatusBadRequest)
+		return
+	}
+	w.serveFind(rw, r, c.Hash())
+}
+
+func (w *IPNIWrapper) handleFindMultihash(rw http.ResponseWriter, r *http.Request) {
+	m, err := mh.FromB58String(strings.TrimPrefix(r.URL.Path, "/multihash/"))
+	if err != nil {
+		http.Error(rw, "Invalid multihash", http.StatusBadRequest)
+		return
+	}
+	w.serveFind(rw, r, m)
+}
+
+func (w *IPNIWrapper) serveFind(rw http.ResponseWriter, r *http.Request, m mh.Multihash) {
+	if r.Method != "GET" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	vals, found, err := w.Engine.Get(m)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found || len(vals) == 0 {
+		http.Error(rw, "Not found", http.StatusNotFound)
+		return
+	}
+	mr := model.MultihashResult{Multihash: m}
+	for _, v := range vals {
+		info := w.providerAddrInfo(v.ProviderID)
+		mr.ProviderResults = append(mr.ProviderResults, model.ProviderResult{
+			ContextID: v.ContextID,
+			Metadata:  v.MetadataBytes,
+			Provider:  &info,
+		})
+	}
+	writeJSON(rw, &model.FindResponse{MultihashResults: []model.MultihashResult{mr}})
+}
+
+func (w *IPNIWrapper) handleFindEncrypted(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secondHash, err := mh.FromB58String(strings.TrimPrefix(r.URL.Path, "/encrypted/multihash/"))
+	if err != nil {
+		http.Error(rw, "Invalid multihash", http.StatusBadRequest)
+		return
+	}
+	evks, err := w.FindEncrypted(r.Context(), secondHash)
+	if errors.Is(err, ErrReaderPrivacyDisabled) {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(evks) == 0 {
+		http.Error(rw, "Not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(rw, &model.FindResponse{EncryptedMultihashResults: []model.EncryptedMultihashResult{{
+		Multihash:          secondHash,
+		EncryptedValueKeys: evks,
+	}}})
+}
+
+func (w *IPNIWrapper) handleFindMetadata(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hash, err := b58.Decode(strings.TrimPrefix(r.URL.Path, "/metadata/"))
+	if err != nil || len(hash) == 0 {
+		http.Error(rw, "Invalid value key hash", http.StatusBadRequest)
+		return
+	}
+	meta, err := w.FindEncryptedMetadata(r.Context(), hash)
+	if errors.Is(err, ErrReaderPrivacyDisabled) {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if meta == nil {
+		http.Error(rw, "Not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(rw, map[string][]byte{"EncryptedMetadata": meta})
+}
+
+func (w *IPNIWrapper) handleFindProvider(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pid, err := peer.Decode(strings.TrimPrefix(r.URL.Path, "/providers/"))
+	if err != nil {
+		http.Error(rw, "Invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	info := w.providerAddrInfo(pid)
+	if len(info.Addrs) == 0 {
+		http.Error(rw, "Not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(rw, &model.ProviderInfo{AddrInfo: info})
+}
+
+// providerAddrInfo returns the addresses known for pid
+func (w *IPNIWrapper) providerAddrInfo(pid peer.ID) peer.AddrInfo {
+	var addrs []multiaddr.Multiaddr
+	if pid == w.host.ID() {
+		addrs = w.host.Addrs()
+	} else {
+		addrs = w.host.Peerstore().Addrs(pid)
+	}
+	return peer.AddrInfo{ID: pid, Addrs: addrs}
+}
@@ -0,0 +1,226 @@
+package ipni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-indexer-core/cache/radixcache"
+	"github.com/ipni/go-indexer-core/engine"
+	"github.com/ipni/go-indexer-core/store/memory"
+	pebblestore "github.com/ipni/go-indexer-core/store/pebble"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// StoreKind selects the value store behind the index
+type StoreKind string
+
+const (
+	MemoryStore StoreKind = "memory"
+	PebbleStore StoreKind = "pebble"
+)
+
+// DefaultCacheSize is how many multihashes the index caches in memory
+const DefaultCacheSize = 4 * 1024 * 1024
+
+// Config tunes the index. Zero values keep the defaults.
+type Config struct {
+	// Value store kind (default pebble when a path is given, memory otherwise)
+	Store StoreKind
+	// Use this value store instead of a built-in one; the wrapper takes
+	// ownership and closes it
+	ValueStore indexer.Interface
+
+	// Multihashes cached in memory in front of the store (default 4Mi);
+	// negative disables the cache
+	CacheSize int
+
+	// Pebble block cache in bytes (default 8MiB)
+	PebbleCacheSize int64
+	// Pebble memtable size in bytes; larger tables mean fewer, bigger
+	// flushes during bulk ingest (default 4MiB)
+	PebbleMemTableSize uint64
+	// Background compactions pebble may run at once (default 1)
+	CompactionConcurrency int
+	// L0 files that trigger a compaction (default 4)
+	L0CompactionThreshold int
+
+	// Pebble writes skip fsync, so a crash loses what was written since the
+	// last flush; flush this often to bound the loss (default only on Close)
+	FlushInterval time.Duration
+
+	// Also keep a double-hashed, encrypted copy of every record in the
+	// datastore, so FindHandler can answer reader-privacy lookups
+	ReaderPrivacy bool
+
+	// Multihashes IndexCar and IndexBlockstore put at once (default 4096)
+	IndexBatchSize int
+	// IndexCar and IndexBlockstore also advertise what they index for the
+	// local provider, so indexers syncing from this host learn of it
+	AnnounceIndexed bool
+}
+
+func (cfg *Config) kind(path string) StoreKind {
+	if cfg.Store != "" {
+		return cfg.Store
+	}
+	if path == "" {
+		return MemoryStore
+	}
+	return PebbleStore
+}
+
+func (cfg *Config) pebbleOptions() *pebble.Options {
+	opts := &pebble.Options{
+		MemTableSize:          cfg.PebbleMemTableSize,
+		L0CompactionThreshold: cfg.L0CompactionThreshold,
+	}
+	if cfg.PebbleCacheSize > 0 {
+		opts.Cache = pebble.NewCache(cfg.PebbleCacheSize)
+	}
+	if n := cfg.CompactionConcurrency; n > 0 {
+		opts.MaxConcurrentCompactions = func() int { return n }
+	}
+	return opts
+}
+
+// openStore returns the value store cfg selects
+func openStore(path string, cfg *Config) (indexer.Interface, error) {
+	if cfg.ValueStore != nil {
+		return cfg.ValueStore, nil
+	}
+	switch kind := cfg.kind(path); kind {
+	case MemoryStore:
+		return memory.New(), nil
+	case PebbleStore:
+		if path == "" {
+			return nil, errors.New("pebble store needs a path")
+		}
+		opts := cfg.pebbleOptions()
+		store, err := pebblestore.New(path, opts)
+		if opts.Cache != nil {
+			// pebble holds its own reference while open
+			opts.Cache.Unref()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open pebble store: %w", err)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown store kind %q", kind)
+	}
+}
+
+func newEngine(store indexer.Interface, cfg *Config) *engine.Engine {
+	size := cfg.CacheSize
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+	if size < 0 {
+		return engine.New(store)
+	}
+	return engine.New(store, engine.WithCache(radixcache.New(size)), engine.WithCacheOnPut(true))
+}
+
+// flushLoop flushes the index every interval until ctx is done
+func (w *IPNIWrapper) flushLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := w.Engine.Flush(); err != nil {
+				log.Warn().Err(err).Msg("index flush failed")
+			}
+		}
+	}
+}
+
+// Sync state: the last advertisement ingested from each publisher, kept
+// in the datastore so a restarted index resumes where it stopped instead
+// of walking every chain again.
+var syncPrefix = ds.NewKey("/ipni/sync")
+
+func syncKey(pid peer.ID) ds.Key {
+	return syncPrefix.ChildString(pid.String())
+}
+
+// saveSync records adCid as ingested from pid. The index is flushed
+// first: pebble does not sync writes until then, and a sync state ahead of
+// the values on disk would skip those advertisements after a crash.
+func (w *IPNIWrapper) saveSync(pid peer.ID, adCid cid.Cid) {
+	if err := w.Engine.Flush(); err != nil {
+		log.Warn().Err(err).Str("peer", pid.String()).Msg("index flush failed, sync state not saved")
+		return
+	}
+	err := w.ds.Put(context.Background(), syncKey(pid), adCid.Bytes())
+	if err != nil {
+		log.Warn().Err(err).Str("peer", pid.String()).Msg("saving sync state failed")
+	}
+}
+
+// restoreSync hands the saved sync state to the subscriber, returning how
+// many publishers it covers
+func (w *IPNIWrapper) restoreSync(ctx context.Context) (int, error) {
+	state, err := w.syncState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for pid, adCid := range state {
+		if err := w.Subscriber.SetLatestSync(pid, adCid); err != nil {
+			return 0, fmt.Errorf("restore sync state for %s: %w", pid, err)
+		}
+	}
+	return len(state), nil
+}
+
+// syncState reads the last advertisement ingested from every publisher
+func (w *IPNIWrapper) syncState(ctx context.Context) (map[peer.ID]cid.Cid, error) {
+	res, err := w.ds.Query(ctx, query.Query{Prefix: syncPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("query sync state: %w", err)
+	}
+	defer res.Close()
+
+	state := make(map[peer.ID]cid.Cid)
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("read sync state: %w", r.Error)
+		}
+		pid, err := peer.Decode(ds.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+		adCid, err := cid.Cast(r.Value)
+		if err != nil {
+			continue
+		}
+		state[pid] = adCid
+	}
+	return state, nil
+}
+
+// LastSynced returns the last advertisement ingested from publisher pid
+func (w *IPNIWrapper) LastSynced(ctx context.Context, pid peer.ID) (cid.Cid, bool, error) {
+	b, err := w.ds.Get(ctx, syncKey(pid))
+	if errors.Is(err, ds.ErrNotFound) {
+		return cid.Undef, false, nil
+	}
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	c, err := cid.Cast(b)
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	return c, true, nil
+}
@@ -1,179 +1,256 @@
-package ipni
-
-import (
-	"context"
-	"fmt"
-
-	"github.com/ipfs/go-cid"
-	"github.com/ipld/go-ipld-prime"
-	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	dagsync "github.com/ipni/go-libipni/dagsync"
-	"github.com/ipni/go-libipni/find/model"
-	"github.com/ipni/go-libipni/ingest/schema"
-	md "github.com/ipni/go-libipni/metadata"
-	"github.com/ipni/go-libipni/pcache"
-	"github.com/libp2p/go-libp2p/core/peer"
-	mh "github.com/multiformats/go-multihash"
-	"github.com/rs/zerolog/log"
-
-	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
-	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
-)
-
-type SubscriberWrapper struct {
-	*dagsync.Subscriber
-	pcache *pcache.ProviderCache
-	lsys   ipld.LinkSystem
-
-	cancel context.CancelFunc
-}
-
-func NewSubscriberWrapper(topic string, hostWrapper *network.HostWrapper, ipldWrapper *ipldprime.IpldWrapper, providerWrapper *ProviderWrapper, sourceUrl ...string) (*SubscriberWrapper, error) {
-	if len(sourceUrl) == 0 {
-		sourceUrl = append(sourceUrl, "https://cid.contact")
-	}
-
-	subscriber, err := dagsync.NewSubscriber(
-		hostWrapper.Host,
-		ipldWrapper.LinkSystem,
-		dagsync.RecvAnnounce(topic),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	pc, err := pcache.New(
-		pcache.WithSource(providerWrapper),
-		pcache.WithSourceURL(sourceUrl...),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return &SubscriberWrapper{
-		Subscriber: subscriber,
-		pcache:     pc,
-		lsys:       ipldWrapper.LinkSystem,
-	}, nil
-}
-
-type OnPutFn func(providerID peer.ID, contextID []byte, metadataBytes []byte, mhs ...mh.Multihash) error
-type OnRemoveFn func(providerID peer.ID, contextID []byte) error
-
-func (s *SubscriberWrapper) Start(ctx context.Context, onPut OnPutFn, onRemove OnRemoveFn) error {
-	if onPut == nil || onRemove == nil {
-		return fmt.Errorf("subscriber: onPut/onRemove must be non-nil")
-	}
-
-	ch, cancel := s.Subscriber.OnSyncFinished()
-	s.cancel = cancel
-
-	go func() {
-		for ev := range ch {
-			log.Info().Str("peer", ev.PeerID.String()).Str("cid", ev.Cid.String()).Msg("dagsync event")
-			if ev.Err != nil {
-				log.Error().Err(ev.Err).Msg("dagsync error")
-				continue
-			}
-
-			if _, err := s.pcache.Get(ctx, ev.PeerID); err != nil {
-				log.Debug().Err(err).Msg("provider cache get failed (non-fatal)")
-			}
-
-			evCopy := ev
-			if err := s.handleAd(ctx, evCopy.Cid, onPut, onRemove); err != nil {
-				log.Error().Err(err).Str("adCid", evCopy.Cid.String()).Msg("ingest failed")
-				continue
-			}
-		}
-	}()
-	return nil
-}
-
-func (s *SubscriberWrapper) handleAd(ctx context.Context, adCid cid.Cid, onPut OnPutFn, onRemove OnRemoveFn) error {
-	n, err := s.lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: adCid}, schema.AdvertisementPrototype)
-	if err != nil {
-		return fmt.Errorf("load advertisement: %w", err)
-	}
-	ad, err := schema.UnwrapAdvertisement(n)
-	if err != nil {
-		return fmt.Errorf("unwrap advertisement: %w", err)
-	}
-
-	pid, err := peer.Decode(ad.Provider)
-	if err != nil {
-		return fmt.Errorf("decode provider id: %w", err)
-	}
-
-	if ad.IsRm {
-		return onRemove(pid, ad.ContextID)
-	}
-
-	meta := md.Default.New()
-	if len(ad.Metadata) > 0 {
-		if err := meta.UnmarshalBinary(ad.Metadata); err != nil {
-			return fmt.Errorf("metadata unmarshal: %w", err)
-		}
-	}
-	mdBytes, err := meta.MarshalBinary()
-	if err != nil {
-		return fmt.Errorf("metadata marshal: %w", err)
-	}
-
-	mhs, err := s.collectMultihashes(ctx, ad.Entries)
-	if err != nil {
-		return fmt.Errorf("collect entries: %w", err)
-	}
-	if len(mhs) == 0 {
-		return nil
-	}
-	return onPut(pid, ad.ContextID, mdBytes, mhs...)
-}
-
-func (s *SubscriberWrapper) collectMultihashes(ctx context.Context, entries ipld.Link) ([]mh.Multihash, error) {
-	if entries == nil {
-		return nil, nil
-	}
-	lnk, ok := entries.(cidlink.Link)
-	if !ok || lnk.Cid == schema.NoEntries.Cid {
-		return nil, nil
-	}
-
-	out := make([]mh.Multihash, 0, 2048)
-	curr := lnk
-	for {
-		chunkNode, err := s.lsys.Load(ipld.LinkContext{Ctx: ctx}, curr, schema.EntryChunkPrototype)
-		if err != nil {
-			return nil, fmt.Errorf("load entry chunk %s: %w", curr.Cid, err)
-		}
-		chunk, err := schema.UnwrapEntryChunk(chunkNode)
-		if err != nil {
-			return nil, fmt.Errorf("unwrap entry chunk: %w", err)
-		}
-
-		if len(chunk.Entries) > 0 {
-			out = append(out, chunk.Entries...)
-		}
-		if chunk.Next == nil {
-			break
-		}
-		nl, ok := chunk.Next.(cidlink.Link)
-		if !ok {
-			return nil, fmt.Errorf("unexpected next link type")
-		}
-		curr = nl
-	}
-	return out, nil
-}
-
-func (s *SubscriberWrapper) Stop() error {
-	if s.cancel != nil {
-		s.cancel()
-		s.cancel = nil
-	}
-	return nil
-}
-
-func (s *SubscriberWrapper) ProviderInfo(ctx context.Context, pid peer.ID) (*model.ProviderInfo, error) {
-	return s.pcache.Get(ctx, pid)
-}
+package ipni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	dagsync "github.com/ipni/go-libipni/dagsync"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/ingest/schema"
+	md "github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/rs/zerolog/log"
+
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
+	ipldprime "github.com/gosuda/boxo-starter-kit/12-ipld-prime/pkg"
+)
+
+// ErrAdSignature is returned for advertisements that are unsigned, badly
+// signed, or signed by neither their provider nor their publisher
+var ErrAdSignature = errors.New("invalid advertisement signature")
+
+type SubscriberWrapper struct {
+	*dagsync.Subscriber
+	pcache *pcache.ProviderCache
+	lsys   ipld.LinkSystem
+
+	cancel   context.CancelFunc
+	onSynced func(peer.ID, cid.Cid)
+}
+
+func NewSubscriberWrapper(topic string, hostWrapper *network.HostWrapper, ipldWrapper *ipldprime.IpldWrapper, providerWrapper *ProviderWrapper, sourceUrl ...string) (*SubscriberWrapper, error) {
+	if len(sourceUrl) == 0 {
+		sourceUrl = append(sourceUrl, "https://cid.contact")
+	}
+
+	subscriber, err := dagsync.NewSubscriber(
+		hostWrapper.Host,
+		ipldWrapper.LinkSystem,
+		dagsync.RecvAnnounce(topic),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := pcache.New(
+		pcache.WithSource(providerWrapper),
+		pcache.WithSourceURL(sourceUrl...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubscriberWrapper{
+		Subscriber: subscriber,
+		pcache:     pc,
+		lsys:       ipldWrapper.LinkSystem,
+	}, nil
+}
+
+type OnPutFn func(providerID peer.ID, contextID []byte, metadataBytes []byte, mhs ...mh.Multihash) error
+type OnRemoveFn func(providerID peer.ID, contextID []byte) error
+
+func (s *SubscriberWrapper) Start(ctx context.Context, onPut OnPutFn, onRemove OnRemoveFn) error {
+	if onPut == nil || onRemove == nil {
+		return fmt.Errorf("subscriber: onPut/onRemove must be non-nil")
+	}
+
+	ch, cancel := s.Subscriber.OnSyncFinished()
+	s.cancel = cancel
+
+	go func() {
+		for ev := range ch {
+			log.Info().Str("peer", ev.PeerID.String()).Str("cid", ev.Cid.String()).Msg("dagsync event")
+			if ev.Err != nil {
+				log.Error().Err(ev.Err).Msg("dagsync error")
+				continue
+			}
+
+			if _, err := s.pcache.Get(ctx, ev.PeerID); err != nil {
+				log.Debug().Err(err).Msg("provider cache get failed (non-fatal)")
+			}
+
+			ads, err := s.syncedAds(ctx, ev.Cid, ev.Count)
+			if err != nil {
+				log.Error().Err(err).Str("adCid", ev.Cid.String()).Msg("ingest failed")
+				continue
+			}
+			ingested := true
+			for _, adCid := range ads {
+				if err := s.handleAd(ctx, ev.PeerID, adCid, onPut, onRemove); err != nil {
+					log.Error().Err(err).Str("adCid", adCid.String()).Msg("ingest failed")
+					ingested = false
+				}
+			}
+			if ingested && s.onSynced != nil {
+				s.onSynced(ev.PeerID, ev.Cid)
+			}
+		}
+	}()
+	return nil
+}
+
+// OnSynced registers fn to be called with the head of every advertisement
+// chain that was fully ingested. It must be set before Start; fn runs on
+// the ingest goroutine and should not block.
+func (s *SubscriberWrapper) OnSynced(fn func(peer.ID, cid.Cid)) {
+	s.onSynced = fn
+}
+
+// syncedAds walks back count advertisements from head, returning them
+// oldest first so they are applied in the order they were published
+func (s *SubscriberWrapper) syncedAds(ctx context.Context, head cid.Cid, count int) ([]cid.Cid, error) {
+	count = max(count, 1)
+	ads := make([]cid.Cid, 0, count)
+	for curr := head; len(ads) < count; {
+		ads = append(ads, curr)
+		ad, err := s.loadAd(ctx, curr)
+		if err != nil {
+			return nil, err
+		}
+		prev, ok := ad.PreviousID.(cidlink.Link)
+		if !ok {
+			break
+		}
+		curr = prev.Cid
+	}
+	slices.Reverse(ads)
+	return ads, nil
+}
+
+func (s *SubscriberWrapper) loadAd(ctx context.Context, adCid cid.Cid) (schema.Advertisement, error) {
+	n, err := s.lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: adCid}, schema.AdvertisementPrototype)
+	if err != nil {
+		return schema.Advertisement{}, fmt.Errorf("load advertisement: %w", err)
+	}
+	ad, err := schema.UnwrapAdvertisement(n)
+	if err != nil {
+		return schema.Advertisement{}, fmt.Errorf("unwrap advertisement: %w", err)
+	}
+	return *ad, nil
+}
+
+// VerifyAdvertisement checks that ad is signed by its provider or by
+// publisher, the peer it was synced from, and returns the provider
+func VerifyAdvertisement(ad schema.Advertisement, publisher peer.ID) (peer.ID, error) {
+	pid, err := peer.Decode(ad.Provider)
+	if err != nil {
+		return "", fmt.Errorf("decode provider id: %w", err)
+	}
+	signer, err := ad.VerifySignature()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrAdSignature, err)
+	}
+	if signer != pid && signer != publisher {
+		return "", fmt.Errorf("%w: signed by %s for provider %s", ErrAdSignature, signer, pid)
+	}
+	return pid, nil
+}
+
+func (s *SubscriberWrapper) handleAd(ctx context.Context, publisher peer.ID, adCid cid.Cid, onPut OnPutFn, onRemove OnRemoveFn) error {
+	ad, err := s.loadAd(ctx, adCid)
+	if err != nil {
+		return err
+	}
+
+	pid, err := VerifyAdvertisement(ad, publisher)
+	if err != nil {
+		return err
+	}
+
+	if ad.IsRm {
+		return onRemove(pid, ad.ContextID)
+	}
+
+	meta := md.Default.New()
+	if len(ad.Metadata) > 0 {
+		if err := meta.UnmarshalBinary(ad.Metadata); err != nil {
+			return fmt.Errorf("metadata unmarshal: %w", err)
+		}
+	}
+	mdBytes, err := meta.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("metadata marshal: %w", err)
+	}
+
+	// the ad chain sync leaves out entries; fetch them from the publisher
+	if lnk, ok := ad.Entries.(cidlink.Link); ok && lnk.Cid != schema.NoEntries.Cid {
+		if err := s.Subscriber.SyncEntries(ctx, peer.AddrInfo{ID: publisher}, lnk.Cid); err != nil {
+			return fmt.Errorf("sync entries: %w", err)
+		}
+	}
+
+	mhs, err := s.collectMultihashes(ctx, ad.Entries)
+	if err != nil {
+		return fmt.Errorf("collect entries: %w", err)
+	}
+	if len(mhs) == 0 {
+		return nil
+	}
+	return onPut(pid, ad.ContextID, mdBytes, mhs...)
+}
+
+func (s *SubscriberWrapper) collectMultihashes(ctx context.Context, entries ipld.Link) ([]mh.Multihash, error) {
+	if entries == nil {
+		return nil, nil
+	}
+	lnk, ok := entries.(cidlink.Link)
+	if !ok || lnk.Cid == schema.NoEntries.Cid {
+		return nil, nil
+	}
+
+	out := make([]mh.Multihash, 0, 2048)
+	curr := lnk
+	for {
+		chunkNode, err := s.lsys.Load(ipld.LinkContext{Ctx: ctx}, curr, schema.EntryChunkPrototype)
+		if err != nil {
+			return nil, fmt.Errorf("load entry chunk %s: %w", curr.Cid, err)
+		}
+		chunk, err := schema.UnwrapEntryChunk(chunkNode)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap entry chunk: %w", err)
+		}
+
+		if len(chunk.Entries) > 0 {
+			out = append(out, chunk.Entries...)
+		}
+		if chunk.Next == nil {
+			break
+		}
+		nl, ok := chunk.Next.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unexpected next link type")
+		}
+		curr = nl
+	}
+	return out, nil
+}
+
+func (s *SubscriberWrapper) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	return nil
+}
+
+func (s *SubscriberWrapper) ProviderInfo(ctx context.Context, pid peer.ID) (*model.ProviderInfo, error) {
+	return s.pcache.Get(ctx, pid)
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
 	dht "github.com/gosuda/boxo-starter-kit/03-dht-router/pkg"
 	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	graphsync "github.com/gosuda/boxo-starter-kit/15-graphsync/pkg"
 	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
 	multifetcher "github.com/gosuda/boxo-starter-kit/18-multifetcher/pkg"
 )
@@ -132,6 +136,188 @@ func TestMultiFetcher_Integration(t *testing.T) {
 	// 4. Actual fetch operations
 }
 
+func TestMultiFetcher_Router(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hA, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+	require.NoError(t, err)
+	defer hA.Close()
+	bsA, err := bitswap.NewBitswap(ctx, nil, hA, nil)
+	require.NoError(t, err)
+	defer bsA.Close()
+	c, err := bsA.PutBlockRaw(ctx, []byte("found through the composed router"))
+	require.NoError(t, err)
+
+	// a delegated routing endpoint that knows A provides c
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var addrs []string
+		for _, a := range hA.Addrs() {
+			addrs = append(addrs, a.String())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"Providers": []map[string]any{{"Schema": "peer", "ID": hA.ID().String(), "Addrs": addrs}},
+		})
+	}))
+	defer srv.Close()
+	delegated, err := dht.NewDelegatedRouter(srv.URL)
+	require.NoError(t, err)
+
+	ipniWrapper, err := ipni.New("", "topic", nil, nil, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+
+	router, err := dht.NewComposedRouter(&dht.ComposedConfig{Strategy: dht.StrategySequential},
+		dht.NamedRouter{Name: "ipni", Router: ipniWrapper.ContentRouting(), ReadOnly: true},
+		dht.NamedRouter{Name: "delegated", Router: delegated, ReadOnly: true},
+	)
+	require.NoError(t, err)
+
+	hB, err := network.New(&network.Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}})
+	require.NoError(t, err)
+	defer hB.Close()
+	bsB, err := bitswap.NewBitswapWithRouter(ctx, router, hB, nil)
+	require.NoError(t, err)
+	defer bsB.Close()
+	require.Empty(t, hB.Peerstore().Addrs(hA.ID()))
+
+	cfg := multifetcher.DefaultConfig()
+	cfg.Router = router
+	mf := multifetcher.NewMultiFetcher(ipniWrapper, nil, bsB, &cfg)
+	defer mf.Close()
+
+	result, err := mf.FetchBlock(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, "bitswap", result.Protocol)
+	assert.Equal(t, hA.ID().String(), result.Provider)
+	assert.Equal(t, []byte("found through the composed router"), result.Data)
+}
+
+func TestMultiFetcher_BitswapNotFound(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	ipniWrapper, err := ipni.New("", "topic", nil, nil, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+
+	bs, err := bitswap.NewBitswapWithConfig(ctx, ipniWrapper.ContentRouting(), nil, nil, &bitswap.BitswapConfig{
+		Retry: &bitswap.RetryConfig{Timeout: 100 * time.Millisecond, Attempts: 2},
+	})
+	require.NoError(t, err)
+	defer bs.Close()
+
+	mf := multifetcher.NewMultiFetcher(ipniWrapper, nil, bs, nil)
+	defer mf.Close()
+
+	// nobody has the block: the fetch gives up long before ctx ends
+	c, err := cid.Parse("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = mf.FetchBlock(ctx, c)
+	require.ErrorIs(t, err, bitswap.ErrBlockNotFound)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestMultiFetcher_GraphSync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	provider, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	defer provider.Close()
+	fetcher, err := graphsync.New(ctx, nil, nil)
+	require.NoError(t, err)
+	defer fetcher.Close()
+	require.NoError(t, fetcher.Host.ConnectToPeer(ctx, provider.Host.GetFullAddresses()[0]))
+
+	c, err := provider.Ipld.PutIPLDAny(ctx, map[string]any{"fetched": "over graphsync"})
+	require.NoError(t, err)
+
+	ipniWrapper, err := ipni.New("", "topic", nil, nil, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+	require.NoError(t, ipniWrapper.PutGraphSyncFilecoin(provider.Host.ID(), c, false, true, []byte("ctx"), c))
+
+	mf := multifetcher.NewMultiFetcher(ipniWrapper, fetcher, nil, nil)
+	defer mf.Close()
+
+	// the result carries the block itself, so it verifies against c
+	result, err := mf.FetchBlock(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, "graphsync", result.Protocol)
+	sum, err := c.Prefix().Sum(result.Data)
+	require.NoError(t, err)
+	assert.True(t, sum.Equals(c))
+}
+
+func TestMultiFetcher_Breaker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	ipniWrapper, err := ipni.New("", "topic", nil, nil, nil)
+	require.NoError(t, err)
+	defer ipniWrapper.Close()
+
+	bs, err := bitswap.NewBitswapWithConfig(ctx, ipniWrapper.ContentRouting(), nil, nil, &bitswap.BitswapConfig{
+		Retry: &bitswap.RetryConfig{Timeout: 100 * time.Millisecond, Attempts: 2},
+	})
+	require.NoError(t, err)
+	defer bs.Close()
+
+	// the index says a peer that is gone has the block
+	dead, err := network.New(nil)
+	require.NoError(t, err)
+	deadID := dead.ID()
+	require.NoError(t, dead.Close())
+	c, err := cid.Parse("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+	require.NoError(t, err)
+	require.NoError(t, ipniWrapper.PutBitswap(deadID, []byte("ctx"), c))
+
+	cfg := multifetcher.DefaultConfig()
+	cfg.Breaker = multifetcher.BreakerConfig{FailureThreshold: 2, Cooldown: 300 * time.Millisecond}
+	mf := multifetcher.NewMultiFetcher(ipniWrapper, nil, bs, &cfg)
+	defer mf.Close()
+	key := "bitswap/" + deadID.String()
+
+	// two failures open the breaker
+	for i := 0; i < 2; i++ {
+		_, err = mf.FetchBlock(ctx, c)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, multifetcher.ErrProvidersCoolingDown)
+	}
+	st := mf.GetMetrics().Breakers[key]
+	assert.Equal(t, multifetcher.BreakerOpen, st.State)
+	assert.Equal(t, 2, st.ConsecutiveFailures)
+	assert.Equal(t, int64(1), st.Trips)
+	assert.NotEmpty(t, st.LastError)
+
+	// while cooling down the peer is not raced at all
+	start := time.Now()
+	_, err = mf.FetchBlock(ctx, c)
+	require.ErrorIs(t, err, multifetcher.ErrProvidersCoolingDown)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	metrics := mf.GetMetrics()
+	assert.Equal(t, int64(1), metrics.ProtocolStats["bitswap"].Skipped)
+	assert.Equal(t, int64(2), metrics.ProtocolStats["bitswap"].Attempts)
+
+	// after the cooldown one probe goes out; it fails, so the breaker
+	// opens again for twice as long
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, multifetcher.BreakerHalfOpen, mf.GetMetrics().Breakers[key].State)
+	_, err = mf.FetchBlock(ctx, c)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, multifetcher.ErrProvidersCoolingDown)
+	st = mf.GetMetrics().Breakers[key]
+	assert.Equal(t, multifetcher.BreakerOpen, st.State)
+	assert.Equal(t, int64(2), st.Trips)
+	assert.Equal(t, 600*time.Millisecond, st.Cooldown)
+
+	mf.ResetBreakers()
+	assert.Empty(t, mf.GetMetrics().Breakers)
+}
+
 // Benchmark tests for performance measurement
 func BenchmarkMultiFetcher_Creation(b *testing.B) {
 	b.ResetTimer()
@@ -0,0 +1,232 @@
+package multifetcher
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
+)
+
+// Breaker defaults, used for zero BreakerConfig fields
+const (
+	DefaultBreakerThreshold   = 3
+	DefaultBreakerCooldown    = 30 * time.Second
+	DefaultBreakerMaxCooldown = 5 * time.Minute
+	DefaultHalfOpenProbes     = 1
+)
+
+// ErrProvidersCoolingDown is returned when every planned provider's breaker
+// is open, so nothing was raced
+var ErrProvidersCoolingDown = errors.New("all providers are cooling down")
+
+// BreakerState is the state of a provider's circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed: the provider is raced as usual
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen: the provider is skipped until its cooldown ends
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen: the cooldown ended and a few probe fetches decide
+	// whether the provider closes again or goes back to cooling down
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerConfig controls the per-provider circuit breakers. Zero values keep
+// the defaults.
+type BreakerConfig struct {
+	// FailureThreshold consecutive failures open a provider's breaker.
+	// Negative disables the breakers.
+	FailureThreshold int
+	// Cooldown is how long an open breaker skips its provider. It doubles
+	// each time a half-open probe fails, up to MaxCooldown.
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+	// HalfOpenProbes is how many fetches may probe a half-open provider at
+	// once
+	HalfOpenProbes int
+}
+
+// BreakerStatus is a snapshot of one provider's breaker, as reported in
+// Metrics.Breakers
+type BreakerStatus struct {
+	Protocol            string
+	Provider            string
+	State               BreakerState
+	ConsecutiveFailures int
+	Trips               int64 // times the breaker opened
+	Cooldown            time.Duration
+	OpenUntil           time.Time // zero unless open
+	LastFailure         time.Time
+	LastError           string
+}
+
+// breakerOutcome is how a fetch admitted by a breaker ended
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota
+	outcomeFailure
+	// outcomeAborted: the fetch was cancelled before it said anything
+	// about the provider, e.g. another fetcher won
+	outcomeAborted
+)
+
+type breaker struct {
+	status   BreakerStatus
+	inFlight int // half-open probes running
+}
+
+// breakerSet holds a breaker per protocol and provider
+type breakerSet struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newBreakerSet(cfg BreakerConfig) *breakerSet {
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = DefaultBreakerThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultBreakerCooldown
+	}
+	if cfg.MaxCooldown <= 0 {
+		cfg.MaxCooldown = max(DefaultBreakerMaxCooldown, cfg.Cooldown)
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = DefaultHalfOpenProbes
+	}
+	return &breakerSet{cfg: cfg, now: time.Now, breakers: make(map[string]*breaker)}
+}
+
+// breakerKey identifies the breaker of f. HTTP fetchers without a provider
+// ID are keyed by their gateway URL; "" means f has no breaker.
+func breakerKey(f ipni.RankedFetcher) string {
+	id := f.ProviderID
+	if id == "" && f.Proto == ipni.THTTP {
+		id = f.Meta["url"]
+	}
+	if id == "" {
+		return ""
+	}
+	return string(f.Proto) + "/" + id
+}
+
+// allow reports whether f may be raced, admitting it as a probe if its
+// breaker is half-open. Every admitted fetch must be settled with done.
+func (s *breakerSet) allow(f ipni.RankedFetcher) bool {
+	key := breakerKey(f)
+	if key == "" || s.cfg.FailureThreshold < 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[key]
+	if !ok {
+		return true
+	}
+	switch b.status.State {
+	case BreakerOpen:
+		if s.now().Before(b.status.OpenUntil) {
+			return false
+		}
+		b.status.State = BreakerHalfOpen
+		b.status.OpenUntil = time.Time{}
+		fallthrough
+	case BreakerHalfOpen:
+		if b.inFlight >= s.cfg.HalfOpenProbes {
+			return false
+		}
+		b.inFlight++
+	}
+	return true
+}
+
+// done settles a fetch admitted by allow
+func (s *breakerSet) done(f ipni.RankedFetcher, outcome breakerOutcome, err error) {
+	key := breakerKey(f)
+	if key == "" || s.cfg.FailureThreshold < 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[key]
+	if !ok {
+		if outcome != outcomeFailure {
+			return
+		}
+		b = &breaker{status: BreakerStatus{
+			Protocol: string(f.Proto),
+			Provider: key[len(f.Proto)+1:],
+			State:    BreakerClosed,
+			Cooldown: s.cfg.Cooldown,
+		}}
+		s.breakers[key] = b
+	}
+	probe := b.status.State == BreakerHalfOpen && b.inFlight > 0
+	if probe {
+		b.inFlight--
+	}
+
+	switch outcome {
+	case outcomeSuccess:
+		b.status.State = BreakerClosed
+		b.status.ConsecutiveFailures = 0
+		b.status.Cooldown = s.cfg.Cooldown
+		b.status.OpenUntil = time.Time{}
+	case outcomeFailure:
+		now := s.now()
+		b.status.ConsecutiveFailures++
+		b.status.LastFailure = now
+		if err != nil {
+			b.status.LastError = err.Error()
+		}
+		switch {
+		case probe:
+			// the provider is still down: cool down for longer
+			b.status.Cooldown = min(2*b.status.Cooldown, s.cfg.MaxCooldown)
+			s.trip(b, now)
+		case b.status.State == BreakerClosed && b.status.ConsecutiveFailures >= s.cfg.FailureThreshold:
+			s.trip(b, now)
+		}
+	}
+}
+
+func (s *breakerSet) trip(b *breaker, now time.Time) {
+	b.status.State = BreakerOpen
+	b.status.OpenUntil = now.Add(b.status.Cooldown)
+	b.status.Trips++
+}
+
+// snapshot returns the status of every breaker that has seen a failure,
+// keyed by "<protocol>/<provider>"
+func (s *breakerSet) snapshot() map[string]BreakerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]BreakerStatus, len(s.breakers))
+	now := s.now()
+	for key, b := range s.breakers {
+		st := b.status
+		// an expired cooldown is half-open, even before the next fetch
+		if st.State == BreakerOpen && !now.Before(st.OpenUntil) {
+			st.State = BreakerHalfOpen
+			st.OpenUntil = time.Time{}
+		}
+		out[key] = st
+	}
+	return out
+}
+
+// reset closes every breaker
+func (s *breakerSet) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakers = make(map[string]*breaker)
+}
@@ -0,0 +1,108 @@
+package multifetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrBlockMismatch is returned when a backend hands back data that does
+// not hash to the CID that was asked for
+var ErrBlockMismatch = errors.New("multifetcher: data does not match its cid")
+
+// Exchange lets a BlockService fetch its missing blocks through a
+// MultiFetcher, so that anything reading a DAG (UnixFS, a gateway) races
+// the providers IPNI or the router knows of without being aware of it
+type Exchange struct {
+	mf *MultiFetcher
+}
+
+var _ exchange.Interface = (*Exchange)(nil)
+
+// NewExchange wraps mf. Closing the exchange leaves mf open.
+func NewExchange(mf *MultiFetcher) *Exchange {
+	return &Exchange{mf: mf}
+}
+
+// GetBlock fetches c and verifies it. Which backend won is logged, so
+// slow pages can be traced to a protocol or provider.
+func (e *Exchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	result, err := e.mf.FetchBlock(ctx, c)
+	if err != nil {
+		log.Debug().Err(err).Str("cid", c.String()).Msg("multifetcher: block fetch failed")
+		return nil, err
+	}
+
+	got, err := c.Prefix().Sum(result.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !got.Equals(c) {
+		// A gateway or peer answered with bytes that do not hash to c
+		log.Warn().Str("cid", c.String()).Str("protocol", result.Protocol).Str("provider", result.Provider).Msg("multifetcher: backend sent mismatching data")
+		return nil, fmt.Errorf("%w: %s via %s", ErrBlockMismatch, c, result.Protocol)
+	}
+
+	log.Debug().
+		Str("cid", c.String()).
+		Str("protocol", result.Protocol).
+		Str("provider", result.Provider).
+		Dur("took", result.Duration).
+		Msg("multifetcher: block fetched")
+	return blocks.NewBlockWithCid(result.Data, c)
+}
+
+// GetBlocks fetches up to MaxConcurrent blocks at a time; blocks that
+// cannot be fetched are left out of the channel
+func (e *Exchange) GetBlocks(ctx context.Context, cs []cid.Cid) (<-chan blocks.Block, error) {
+	out := make(chan blocks.Block)
+	limit := max(e.mf.config.MaxConcurrent, 1)
+	go func() {
+		defer close(out)
+		sem := make(chan struct{}, limit)
+		var wg sync.WaitGroup
+		for _, c := range cs {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			}
+			wg.Add(1)
+			go func(c cid.Cid) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				blk, err := e.GetBlock(ctx, c)
+				if err != nil {
+					return
+				}
+				select {
+				case out <- blk:
+				case <-ctx.Done():
+				}
+			}(c)
+		}
+		wg.Wait()
+	}()
+	return out, nil
+}
+
+// NotifyNewBlocks announces added blocks over bitswap so peers can fetch
+// them from this node
+func (e *Exchange) NotifyNewBlocks(ctx context.Context, blks ...blocks.Block) error {
+	if e.mf.bitswap == nil {
+		return nil
+	}
+	return e.mf.bitswap.NotifyNewBlocks(ctx, blks...)
+}
+
+// Close is a no-op; the MultiFetcher belongs to the caller
+func (e *Exchange) Close() error {
+	return nil
+}
@@ -3,17 +3,26 @@ package multifetcher
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/cbor"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/routing"
 
+	network "github.com/gosuda/boxo-starter-kit/02-network/pkg"
 	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	ts "github.com/gosuda/boxo-starter-kit/14-traversal-selector/pkg"
 	graphsync "github.com/gosuda/boxo-starter-kit/15-graphsync/pkg"
 	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
 )
@@ -34,6 +43,20 @@ type FetcherConfig struct {
 	Timeout          time.Duration // Overall timeout
 	StaggerDelay     time.Duration // Delay between starting fetchers
 	CancelOnFirstWin bool          // Cancel other fetchers on first success
+
+	// Latency supplies measured RTTs. When the previous provider has
+	// samples, the next fetcher starts after its p90 RTT (at most
+	// StaggerDelay). Defaults to the bitswap host.
+	Latency network.LatencySource
+
+	// Router finds bitswap providers when the IPNI index has none, e.g. a
+	// dht.ComposedRouter. Nil falls back to plain bitswap discovery.
+	Router routing.ContentRouting
+
+	// Breaker opens a provider's circuit after consecutive failures, so a
+	// dead gateway or peer sits out a cooldown instead of being raced on
+	// every request. Zero values keep the defaults.
+	Breaker BreakerConfig
 }
 
 // DefaultConfig returns sensible defaults for fetcher configuration
@@ -55,6 +78,7 @@ type MultiFetcher struct {
 	httpFetcher *HTTPFetcher
 	mu          sync.RWMutex
 	metrics     *Metrics
+	breakers    *breakerSet
 }
 
 // Metrics tracks performance across protocols
@@ -64,6 +88,10 @@ type Metrics struct {
 	SuccessfulRequests int64
 	FailedRequests     int64
 	ProtocolStats      map[string]*ProtocolMetrics
+
+	// Breakers holds the circuit breaker of every provider that has
+	// failed, keyed by "<protocol>/<provider>"
+	Breakers map[string]BreakerStatus
 }
 
 type ProtocolMetrics struct {
@@ -73,6 +101,7 @@ type ProtocolMetrics struct {
 	AvgLatency       time.Duration
 	TotalLatency     time.Duration
 	BytesTransferred int64
+	Skipped          int64 // fetchers not raced because their breaker was open
 }
 
 // NewMultiFetcher creates a new multifetcher instance
@@ -86,13 +115,18 @@ func NewMultiFetcher(
 		defaultConfig := DefaultConfig()
 		config = &defaultConfig
 	}
+	cfg := *config
+	if cfg.Latency == nil && bitswap != nil && bitswap.HostWrapper != nil {
+		cfg.Latency = bitswap.HostWrapper
+	}
 
 	return &MultiFetcher{
-		config:      *config,
+		config:      cfg,
 		ipni:        ipni,
 		graphsync:   graphsync,
 		bitswap:     bitswap,
 		httpFetcher: NewHTTPFetcher(),
+		breakers:    newBreakerSet(cfg.Breaker),
 		metrics: &Metrics{
 			ProtocolStats: map[string]*ProtocolMetrics{
 				"bitswap":   {},
@@ -119,6 +153,9 @@ func (mf *MultiFetcher) FetchBlock(ctx context.Context, c cid.Cid) (*FetchResult
 	}
 
 	if !found || len(rankedFetchers) == 0 {
+		rankedFetchers = mf.routedFetchers(ctx, c)
+	}
+	if len(rankedFetchers) == 0 {
 		// Fallback to direct bitswap if no providers found
 		result := mf.fetchViaBitswap(ctx, c, "")
 		if result.Error != nil {
@@ -163,6 +200,21 @@ func (mf *MultiFetcher) raceProtocols(ctx context.Context, c cid.Cid, fetchers [
 		return nil, fmt.Errorf("no fetchers available")
 	}
 
+	// Leave out providers whose breaker is open
+	allowed := fetchers[:0:0]
+	for _, f := range fetchers {
+		if mf.breakers.allow(f) {
+			allowed = append(allowed, f)
+		} else {
+			mf.recordSkipped(f)
+		}
+	}
+	if len(allowed) == 0 {
+		mf.recordFailure()
+		return nil, ErrProvidersCoolingDown
+	}
+	fetchers = allowed
+
 	// Create context with timeout
 	fetchCtx, cancel := context.WithTimeout(ctx, mf.config.Timeout)
 	defer cancel()
@@ -178,7 +230,7 @@ func (mf *MultiFetcher) raceProtocols(ctx context.Context, c cid.Cid, fetchers [
 	for i, fetcher := range fetchers {
 		// Apply stagger delay
 		if i > 0 {
-			time.Sleep(mf.config.StaggerDelay)
+			time.Sleep(mf.staggerAfter(fetchers[i-1]))
 		}
 
 		wg.Add(1)
@@ -190,6 +242,7 @@ func (mf *MultiFetcher) raceProtocols(ctx context.Context, c cid.Cid, fetchers [
 			case semaphore <- struct{}{}:
 				defer func() { <-semaphore }()
 			case <-fetchCtx.Done():
+				mf.breakers.done(f, outcomeAborted, nil)
 				return
 			}
 
@@ -209,6 +262,7 @@ func (mf *MultiFetcher) raceProtocols(ctx context.Context, c cid.Cid, fetchers [
 					CID:      c,
 				}
 			}
+			mf.breakers.done(f, fetchOutcome(ctx, fetchCtx, result.Error), result.Error)
 
 			select {
 			case resultCh <- result:
@@ -251,6 +305,58 @@ func (mf *MultiFetcher) raceProtocols(ctx context.Context, c cid.Cid, fetchers [
 	return nil, fmt.Errorf("all fetchers failed, last error: %w", lastError)
 }
 
+// fetchOutcome tells the breakers whether a fetch said anything about its
+// provider. Running out of time counts against it; being cancelled, by the
+// caller or because another fetcher won, does not.
+func fetchOutcome(ctx, fetchCtx context.Context, err error) breakerOutcome {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case ctx.Err() != nil, errors.Is(fetchCtx.Err(), context.Canceled):
+		return outcomeAborted
+	default:
+		return outcomeFailure
+	}
+}
+
+// routedFetchers asks the configured router for providers of c and
+// returns them as bitswap fetchers, remembering their addresses for the dial
+func (mf *MultiFetcher) routedFetchers(ctx context.Context, c cid.Cid) []ipni.RankedFetcher {
+	if mf.config.Router == nil {
+		return nil
+	}
+	findCtx, cancel := context.WithTimeout(ctx, mf.config.Timeout)
+	defer cancel()
+
+	var out []ipni.RankedFetcher
+	for pi := range mf.config.Router.FindProvidersAsync(findCtx, c, mf.config.MaxConcurrent) {
+		if mf.bitswap != nil && mf.bitswap.HostWrapper != nil {
+			if pi.ID == mf.bitswap.HostWrapper.ID() {
+				continue
+			}
+			mf.bitswap.HostWrapper.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+		}
+		out = append(out, ipni.RankedFetcher{ProviderID: pi.ID.String(), Proto: ipni.TBitswap})
+	}
+	return out
+}
+
+// staggerAfter returns how long to give f before starting the next fetcher
+func (mf *MultiFetcher) staggerAfter(f ipni.RankedFetcher) time.Duration {
+	if mf.config.Latency == nil {
+		return mf.config.StaggerDelay
+	}
+	p, err := peer.Decode(f.ProviderID)
+	if err != nil {
+		return mf.config.StaggerDelay
+	}
+	rtt, ok := mf.config.Latency.PeerLatency(p)
+	if !ok {
+		return mf.config.StaggerDelay
+	}
+	return min(rtt.P90, mf.config.StaggerDelay)
+}
+
 // fetchViaBitswap fetches using Bitswap protocol
 func (mf *MultiFetcher) fetchViaBitswap(ctx context.Context, c cid.Cid, providerID string) *FetchResult {
 	start := time.Now()
@@ -260,16 +366,24 @@ func (mf *MultiFetcher) fetchViaBitswap(ctx context.Context, c cid.Cid, provider
 		CID:      c,
 	}
 
-	// Parse peer ID from provider string
-	peerID, err := peer.Decode(providerID)
-	if err != nil {
-		result.Error = fmt.Errorf("invalid peer ID %s: %w", providerID, err)
-		result.Duration = time.Since(start)
-		return result
-	}
+	// Without a provider, ask whoever bitswap finds. Either way the fetch
+	// gives up with bitswap.ErrBlockNotFound once its retries run out.
+	var block blocks.Block
+	var err error
+	if providerID == "" {
+		block, err = mf.bitswap.GetBlock(ctx, c)
+	} else {
+		// Parse peer ID from provider string
+		peerID, perr := peer.Decode(providerID)
+		if perr != nil {
+			result.Error = fmt.Errorf("invalid peer ID %s: %w", providerID, perr)
+			result.Duration = time.Since(start)
+			return result
+		}
 
-	// Fetch block via Bitswap from specific peer
-	block, err := mf.bitswap.GetBlockFromPeer(ctx, c, peerID)
+		// Fetch block via Bitswap from specific peer
+		block, err = mf.bitswap.GetBlockFromPeer(ctx, c, peerID)
+	}
 	if err != nil {
 		result.Error = err
 	} else {
@@ -304,31 +418,38 @@ func (mf *MultiFetcher) fetchViaGraphSync(ctx context.Context, c cid.Cid, provid
 		return result
 	}
 
-	// Use default selector if none provided
+	// Without a selector only the root block is wanted
 	if selector == nil {
-		var err error
-		selector, err = createSimpleAllSelector()
-		if err != nil {
-			// Log error but continue without selector
-			selector = nil
-		}
+		selector = ts.SelectorOne()
 	}
 
 	// Fetch via GraphSync
-	success, err := mf.graphsync.Fetch(ctx, targetPeer, c, selector)
+	res, err := mf.graphsync.Fetch(ctx, targetPeer, c, selector)
 	if err != nil {
 		result.Error = err
-	} else if !success {
+	} else if !res.Progress() {
 		result.Error = fmt.Errorf("graphsync fetch returned false")
 	} else {
-		// For GraphSync, we don't return raw data but indicate success
-		result.Data = []byte("graphsync_success")
+		// GraphSync stores what it fetched; return the root block's bytes
+		result.Data, result.Error = mf.localBlock(ctx, c)
 	}
 
 	result.Duration = time.Since(start)
 	return result
 }
 
+// localBlock reads the raw bytes of c from the GraphSync node's storage
+func (mf *MultiFetcher) localBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+	r, err := mf.graphsync.Ipld.LinkSystem.StorageReadOpener(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c})
+	if err != nil {
+		return nil, fmt.Errorf("read fetched block %s: %w", c, err)
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return io.ReadAll(r)
+}
+
 // fetchViaHTTP fetches using HTTP protocol
 func (mf *MultiFetcher) fetchViaHTTP(ctx context.Context, c cid.Cid, providerID string, meta map[string]string) *FetchResult {
 	start := time.Now()
@@ -382,12 +503,20 @@ func (mf *MultiFetcher) GetMetrics() *Metrics {
 			AvgLatency:       stats.AvgLatency,
 			TotalLatency:     stats.TotalLatency,
 			BytesTransferred: stats.BytesTransferred,
+			Skipped:          stats.Skipped,
 		}
 	}
+	metrics.Breakers = mf.breakers.snapshot()
 
 	return metrics
 }
 
+// ResetBreakers closes every provider's circuit breaker, e.g. after the
+// network comes back
+func (mf *MultiFetcher) ResetBreakers() {
+	mf.breakers.reset()
+}
+
 // recordRequest increments the total request counter
 func (mf *MultiFetcher) recordRequest() {
 	mf.metrics.mu.Lock()
@@ -423,6 +552,19 @@ func (mf *MultiFetcher) recordResult(result *FetchResult) {
 	}
 }
 
+// recordSkipped counts a fetcher left out because its breaker was open
+func (mf *MultiFetcher) recordSkipped(f ipni.RankedFetcher) {
+	mf.metrics.mu.Lock()
+	defer mf.metrics.mu.Unlock()
+
+	stats, ok := mf.metrics.ProtocolStats[string(f.Proto)]
+	if !ok {
+		stats = &ProtocolMetrics{}
+		mf.metrics.ProtocolStats[string(f.Proto)] = stats
+	}
+	stats.Skipped++
+}
+
 // recordFailure increments the failed request counter
 func (mf *MultiFetcher) recordFailure() {
 	mf.metrics.mu.Lock()
@@ -524,14 +666,3 @@ func createDefaultSelector() (ipld.Node, error) {
 
 	return nb.Build(), nil
 }
-
-// createSimpleAllSelector creates a simplified "all" selector
-func createSimpleAllSelector() (ipld.Node, error) {
-	// Create a simple selector that matches everything
-	nb := basicnode.Prototype.String.NewBuilder()
-	err := nb.AssignString("*")
-	if err != nil {
-		return nil, err
-	}
-	return nb.Build(), nil
-}
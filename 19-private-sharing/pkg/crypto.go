@@ -0,0 +1,108 @@
+package sharing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	// KeySize is the length of a content key (AES-256).
+	KeySize = 32
+
+	wrapInfo = "boxo-starter-kit/private-sharing/v1"
+)
+
+// NewContentKey returns a fresh random symmetric key for one share.
+func NewContentKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with AES-256-GCM and returns nonce || ciphertext.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open reverses Seal.
+func Open(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	pt, err := aead.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return pt, nil
+}
+
+// WrapKey encrypts a content key to a recipient using an ephemeral X25519
+// exchange (ECIES style). It returns the ephemeral public key and the sealed key.
+func WrapKey(contentKey []byte, recipient *ecdh.PublicKey) (ephemeral []byte, wrapped []byte, err error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	kek, err := deriveKEK(eph, recipient, eph.PublicKey())
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err = Seal(kek, contentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return eph.PublicKey().Bytes(), wrapped, nil
+}
+
+// UnwrapKey recovers a content key wrapped with WrapKey.
+func UnwrapKey(id *Identity, ephemeral, wrapped []byte) ([]byte, error) {
+	ephPub, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral key: %w", err)
+	}
+	kek, err := deriveKEK(id.priv, ephPub, ephPub)
+	if err != nil {
+		return nil, err
+	}
+	return Open(kek, wrapped)
+}
+
+// deriveKEK runs ECDH and binds the result to the ephemeral key via HKDF salt.
+func deriveKEK(priv *ecdh.PrivateKey, peerPub, ephPub *ecdh.PublicKey) ([]byte, error) {
+	shared, err := priv.ECDH(peerPub)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+	return hkdf.Key(sha256.New, shared, ephPub.Bytes(), wrapInfo, KeySize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,78 @@
+package sharing
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	mb "github.com/multiformats/go-multibase"
+	mc "github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+const didKeyPrefix = "did:key:"
+
+// Identity is an X25519 key-agreement key addressed by its did:key.
+// Content keys are wrapped to this key so only its owner can open a share.
+type Identity struct {
+	priv *ecdh.PrivateKey
+}
+
+func NewIdentity() (*Identity, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate x25519 key: %w", err)
+	}
+	return &Identity{priv: priv}, nil
+}
+
+// IdentityFromBytes restores an identity from a 32-byte X25519 private key.
+func IdentityFromBytes(b []byte) (*Identity, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("x25519 private key: %w", err)
+	}
+	return &Identity{priv: priv}, nil
+}
+
+func (id *Identity) Bytes() []byte {
+	return id.priv.Bytes()
+}
+
+func (id *Identity) PublicKey() *ecdh.PublicKey {
+	return id.priv.PublicKey()
+}
+
+// DID returns the did:key form of the public key (multicodec x25519-pub, base58btc).
+func (id *Identity) DID() string {
+	return EncodeDIDKey(id.PublicKey())
+}
+
+func EncodeDIDKey(pub *ecdh.PublicKey) string {
+	raw := append(varint.ToUvarint(uint64(mc.X25519Pub)), pub.Bytes()...)
+	enc, _ := mb.Encode(mb.Base58BTC, raw) // base58btc is always a valid encoding
+	return didKeyPrefix + enc
+}
+
+// ParseDIDKey decodes a did:key carrying an X25519 public key.
+func ParseDIDKey(did string) (*ecdh.PublicKey, error) {
+	if !strings.HasPrefix(did, didKeyPrefix) {
+		return nil, fmt.Errorf("not a did:key: %q", did)
+	}
+	enc, raw, err := mb.Decode(strings.TrimPrefix(did, didKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("decode did:key: %w", err)
+	}
+	if enc != mb.Base58BTC {
+		return nil, fmt.Errorf("did:key must be base58btc encoded")
+	}
+	code, n, err := varint.FromUvarint(raw)
+	if err != nil {
+		return nil, fmt.Errorf("did:key multicodec: %w", err)
+	}
+	if mc.Code(code) != mc.X25519Pub {
+		return nil, fmt.Errorf("unsupported did:key key type %s", mc.Code(code))
+	}
+	return ecdh.X25519().NewPublicKey(raw[n:])
+}
@@ -0,0 +1,290 @@
+package sharing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/go-cid"
+
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
+	multifetcher "github.com/gosuda/boxo-starter-kit/18-multifetcher/pkg"
+)
+
+// MaxFileSize bounds a single encrypted file, which is stored as one block.
+const MaxFileSize = 1 << 20 // 1MiB
+
+var ErrNoGrant = errors.New("no grant for recipient")
+
+// IndexEntry maps a file path inside the shared directory to its ciphertext block.
+type IndexEntry struct {
+	Path string `json:"path"`
+	CID  string `json:"cid"`
+	Size int    `json:"size"`
+}
+
+// Grant carries the content key wrapped to one recipient's did:key.
+type Grant struct {
+	Recipient    string `json:"recipient"`
+	EphemeralKey []byte `json:"epk"`
+	WrappedKey   []byte `json:"wrapped_key"`
+}
+
+// Manifest is the only plaintext block of a share. It points at the
+// encrypted index and lists who may unwrap the content key.
+type Manifest struct {
+	Version int     `json:"version"`
+	Index   string  `json:"index"`
+	Grants  []Grant `json:"grants"`
+}
+
+// Share is the sharer-side state of an encrypted directory.
+type Share struct {
+	Index  cid.Cid
+	Key    []byte
+	Blocks []cid.Cid
+	Grants []Grant
+}
+
+type SharingWrapper struct {
+	Bitswap *bitswap.BitswapWrapper
+	IPNI    *ipni.IPNIWrapper
+}
+
+func New(ctx context.Context, bitswapWrapper *bitswap.BitswapWrapper, ipniWrapper *ipni.IPNIWrapper) (*SharingWrapper, error) {
+	var err error
+	if bitswapWrapper == nil {
+		bitswapWrapper, err = bitswap.NewBitswap(ctx, nil, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bitswap: %w", err)
+		}
+	}
+	if ipniWrapper == nil {
+		ipniWrapper, err = ipni.New("", "", nil, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create IPNI wrapper: %w", err)
+		}
+	}
+	return &SharingWrapper{
+		Bitswap: bitswapWrapper,
+		IPNI:    ipniWrapper,
+	}, nil
+}
+
+// EncryptDirectory encrypts every file of dir under a fresh content key and
+// stores the ciphertexts plus an encrypted index as raw blocks.
+func (s *SharingWrapper) EncryptDirectory(ctx context.Context, dir files.Directory) (*Share, error) {
+	key, err := NewContentKey()
+	if err != nil {
+		return nil, fmt.Errorf("content key: %w", err)
+	}
+	share := &Share{Key: key}
+
+	var index []IndexEntry
+	if err := s.encryptDir(ctx, share, dir, "", &index); err != nil {
+		return nil, err
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	indexCID, err := s.putSealed(ctx, share, indexBytes)
+	if err != nil {
+		return nil, fmt.Errorf("put index: %w", err)
+	}
+	share.Index = indexCID
+	return share, nil
+}
+
+func (s *SharingWrapper) encryptDir(ctx context.Context, share *Share, dir files.Directory, prefix string, index *[]IndexEntry) error {
+	it := dir.Entries()
+	for it.Next() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		name := path.Join(prefix, it.Name())
+		switch n := it.Node().(type) {
+		case files.Directory:
+			if err := s.encryptDir(ctx, share, n, name, index); err != nil {
+				return err
+			}
+		case files.File:
+			data, err := io.ReadAll(io.LimitReader(n, MaxFileSize+1))
+			_ = n.Close()
+			if err != nil {
+				return fmt.Errorf("read %q: %w", name, err)
+			}
+			if len(data) > MaxFileSize {
+				return fmt.Errorf("file %q exceeds %d bytes", name, MaxFileSize)
+			}
+			c, err := s.putSealed(ctx, share, data)
+			if err != nil {
+				return fmt.Errorf("put %q: %w", name, err)
+			}
+			*index = append(*index, IndexEntry{Path: name, CID: c.String(), Size: len(data)})
+		default:
+			return fmt.Errorf("unsupported node type %T for %q", n, name)
+		}
+	}
+	return it.Err()
+}
+
+func (s *SharingWrapper) putSealed(ctx context.Context, share *Share, plaintext []byte) (cid.Cid, error) {
+	sealed, err := Seal(share.Key, plaintext)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c, err := s.Bitswap.PutBlockRaw(ctx, sealed)
+	if err != nil {
+		return cid.Undef, err
+	}
+	share.Blocks = append(share.Blocks, c)
+	return c, nil
+}
+
+// Grant wraps the share's content key to the X25519 key behind recipientDID.
+func (s *SharingWrapper) Grant(share *Share, recipientDID string) error {
+	pub, err := ParseDIDKey(recipientDID)
+	if err != nil {
+		return err
+	}
+	eph, wrapped, err := WrapKey(share.Key, pub)
+	if err != nil {
+		return fmt.Errorf("wrap key: %w", err)
+	}
+	share.Grants = append(share.Grants, Grant{
+		Recipient:    recipientDID,
+		EphemeralKey: eph,
+		WrappedKey:   wrapped,
+	})
+	return nil
+}
+
+// Publish stores the manifest and advertises every block of the share in
+// IPNI as served over Bitswap by this node. Call it again after adding grants.
+func (s *SharingWrapper) Publish(ctx context.Context, share *Share) (cid.Cid, error) {
+	manifest := Manifest{
+		Version: 1,
+		Index:   share.Index.String(),
+		Grants:  share.Grants,
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return cid.Undef, err
+	}
+	manifestCID, err := s.Bitswap.PutBlockRaw(ctx, data)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("put manifest: %w", err)
+	}
+
+	contextID := []byte("private-sharing/" + share.Index.String())
+	all := append([]cid.Cid{manifestCID}, share.Blocks...)
+	if err := s.IPNI.PutBitswap(s.Bitswap.HostWrapper.ID(), contextID, all...); err != nil {
+		return cid.Undef, fmt.Errorf("ipni announce: %w", err)
+	}
+	return manifestCID, nil
+}
+
+func (s *SharingWrapper) Close() error {
+	return s.Bitswap.Close()
+}
+
+// Recipient fetches shares through a MultiFetcher and decrypts them with its identity.
+type Recipient struct {
+	Identity *Identity
+	Fetcher  *multifetcher.MultiFetcher
+}
+
+func NewRecipient(id *Identity, fetcher *multifetcher.MultiFetcher) *Recipient {
+	return &Recipient{Identity: id, Fetcher: fetcher}
+}
+
+// Open fetches the manifest, unwraps the content key granted to this
+// recipient, and returns the decrypted files keyed by path.
+func (r *Recipient) Open(ctx context.Context, manifestCID cid.Cid) (map[string][]byte, error) {
+	raw, err := r.fetch(ctx, manifestCID)
+	if err != nil {
+		return nil, fmt.Errorf("fetch manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	key, err := r.unwrap(manifest.Grants)
+	if err != nil {
+		return nil, err
+	}
+
+	indexCID, err := cid.Decode(manifest.Index)
+	if err != nil {
+		return nil, fmt.Errorf("index cid: %w", err)
+	}
+	sealedIndex, err := r.fetch(ctx, indexCID)
+	if err != nil {
+		return nil, fmt.Errorf("fetch index: %w", err)
+	}
+	indexBytes, err := Open(key, sealedIndex)
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+	var index []IndexEntry
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return nil, fmt.Errorf("decode index: %w", err)
+	}
+
+	out := make(map[string][]byte, len(index))
+	for _, e := range index {
+		c, err := cid.Decode(e.CID)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", e.Path, err)
+		}
+		sealed, err := r.fetch(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("fetch %q: %w", e.Path, err)
+		}
+		pt, err := Open(key, sealed)
+		if err != nil {
+			return nil, fmt.Errorf("open %q: %w", e.Path, err)
+		}
+		out[e.Path] = pt
+	}
+	return out, nil
+}
+
+func (r *Recipient) unwrap(grants []Grant) ([]byte, error) {
+	did := r.Identity.DID()
+	for _, g := range grants {
+		if g.Recipient != did {
+			continue
+		}
+		return UnwrapKey(r.Identity, g.EphemeralKey, g.WrappedKey)
+	}
+	return nil, ErrNoGrant
+}
+
+// fetch retrieves a block via the multifetcher and verifies it against its CID,
+// since providers of ciphertext are untrusted.
+func (r *Recipient) fetch(ctx context.Context, c cid.Cid) ([]byte, error) {
+	res, err := r.Fetcher.FetchBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	got, err := c.Prefix().Sum(res.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !got.Equals(c) {
+		return nil, fmt.Errorf("block %s failed verification (got %s)", c, got)
+	}
+	return res.Data, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+	ipni "github.com/gosuda/boxo-starter-kit/17-ipni/pkg"
+	multifetcher "github.com/gosuda/boxo-starter-kit/18-multifetcher/pkg"
+	sharing "github.com/gosuda/boxo-starter-kit/19-private-sharing/pkg"
+)
+
+func TestDIDKeyRoundTrip(t *testing.T) {
+	id, err := sharing.NewIdentity()
+	require.NoError(t, err)
+
+	did := id.DID()
+	assert.Contains(t, did, "did:key:z6LS")
+
+	pub, err := sharing.ParseDIDKey(did)
+	require.NoError(t, err)
+	assert.True(t, pub.Equal(id.PublicKey()))
+
+	_, err = sharing.ParseDIDKey("did:web:example.com")
+	assert.Error(t, err)
+}
+
+func TestWrapUnwrapKey(t *testing.T) {
+	alice, err := sharing.NewIdentity()
+	require.NoError(t, err)
+	mallory, err := sharing.NewIdentity()
+	require.NoError(t, err)
+
+	key, err := sharing.NewContentKey()
+	require.NoError(t, err)
+
+	eph, wrapped, err := sharing.WrapKey(key, alice.PublicKey())
+	require.NoError(t, err)
+
+	got, err := sharing.UnwrapKey(alice, eph, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	_, err = sharing.UnwrapKey(mallory, eph, wrapped)
+	assert.Error(t, err, "other identities must not unwrap the key")
+}
+
+func TestPrivateSharing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	sharerBS, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	recipientBS, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	defer recipientBS.Close()
+	require.NoError(t, recipientBS.HostWrapper.ConnectToPeer(ctx, sharerBS.HostWrapper.GetFullAddresses()...))
+
+	index, err := ipni.New("", "topic", nil, nil, nil)
+	require.NoError(t, err)
+	defer index.Close()
+
+	sharer, err := sharing.New(ctx, sharerBS, index)
+	require.NoError(t, err)
+	defer sharer.Close()
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"readme.txt": files.NewBytesFile([]byte("top secret")),
+		"docs": files.NewMapDirectory(map[string]files.Node{
+			"plan.md": files.NewBytesFile([]byte("# plan")),
+		}),
+	})
+	share, err := sharer.EncryptDirectory(ctx, dir)
+	require.NoError(t, err)
+	assert.Len(t, share.Blocks, 3, "two files plus the index")
+
+	bob, err := sharing.NewIdentity()
+	require.NoError(t, err)
+	require.NoError(t, sharer.Grant(share, bob.DID()))
+
+	manifestCID, err := sharer.Publish(ctx, share)
+	require.NoError(t, err)
+
+	mf := multifetcher.NewMultiFetcher(index, nil, recipientBS, nil)
+	defer mf.Close()
+
+	got, err := sharing.NewRecipient(bob, mf).Open(ctx, manifestCID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("top secret"), got["readme.txt"])
+	assert.Equal(t, []byte("# plan"), got["docs/plan.md"])
+
+	eve, err := sharing.NewIdentity()
+	require.NoError(t, err)
+	_, err = sharing.NewRecipient(eve, mf).Open(ctx, manifestCID)
+	assert.ErrorIs(t, err, sharing.ErrNoGrant)
+}
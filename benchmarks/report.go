@@ -0,0 +1,194 @@
+package benchmarks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Thresholds are the maximum allowed increases, in percent, before a
+// benchmark counts as regressed. Zero disables the check for that metric.
+type Thresholds struct {
+	NsPerOpPct     float64
+	BytesPerOpPct  float64
+	AllocsPerOpPct float64
+}
+
+// DefaultThresholds tolerates normal run-to-run noise
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		NsPerOpPct:     15,
+		BytesPerOpPct:  20,
+		AllocsPerOpPct: 20,
+	}
+}
+
+// Delta compares one benchmark between a baseline and the current run
+type Delta struct {
+	Name           string          `json:"name"`
+	Baseline       BenchmarkResult `json:"baseline"`
+	Current        BenchmarkResult `json:"current"`
+	NsPerOpPct     float64         `json:"ns_per_op_pct"`
+	BytesPerOpPct  float64         `json:"bytes_per_op_pct"`
+	AllocsPerOpPct float64         `json:"allocs_per_op_pct"`
+	Regressed      bool            `json:"regressed"`
+	Reasons        []string        `json:"reasons,omitempty"`
+}
+
+// Comparison is the result of CompareSuites
+type Comparison struct {
+	Thresholds Thresholds `json:"thresholds"`
+	Deltas     []Delta    `json:"deltas"`
+	Missing    []string   `json:"missing,omitempty"` // in baseline, not in current
+	Added      []string   `json:"added,omitempty"`   // in current, not in baseline
+}
+
+// EnvironmentMetadata describes the machine a suite ran on
+func EnvironmentMetadata() map[string]string {
+	meta := map[string]string{
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"num_cpu":    fmt.Sprint(runtime.NumCPU()),
+		"gomaxprocs": fmt.Sprint(runtime.GOMAXPROCS(0)),
+	}
+	if host, err := os.Hostname(); err == nil {
+		meta["hostname"] = host
+	}
+	if out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output(); err == nil {
+		meta["git_commit"] = strings.TrimSpace(string(out))
+	}
+	return meta
+}
+
+// LoadSuite reads a suite previously written by SaveSuite or RunBenchmarks
+func LoadSuite(path string) (*BenchmarkSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite BenchmarkSuite
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &suite, nil
+}
+
+// SaveSuite writes a suite as indented JSON, creating parent directories
+func SaveSuite(suite *BenchmarkSuite, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// CompareSuites matches benchmarks by name and flags those whose metrics
+// grew beyond the thresholds.
+func CompareSuites(baseline, current *BenchmarkSuite, th Thresholds) *Comparison {
+	base := make(map[string]BenchmarkResult, len(baseline.Results))
+	for _, r := range baseline.Results {
+		base[r.Name] = r
+	}
+
+	cmp := &Comparison{Thresholds: th}
+	seen := make(map[string]bool, len(current.Results))
+	for _, cur := range current.Results {
+		seen[cur.Name] = true
+		old, ok := base[cur.Name]
+		if !ok {
+			cmp.Added = append(cmp.Added, cur.Name)
+			continue
+		}
+
+		d := Delta{
+			Name:           cur.Name,
+			Baseline:       old,
+			Current:        cur,
+			NsPerOpPct:     pctChange(old.NsPerOp, cur.NsPerOp),
+			BytesPerOpPct:  pctChange(old.BytesPerOp, cur.BytesPerOp),
+			AllocsPerOpPct: pctChange(old.AllocsPerOp, cur.AllocsPerOp),
+		}
+		if th.NsPerOpPct > 0 && d.NsPerOpPct > th.NsPerOpPct {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("ns/op +%.1f%% > %.1f%%", d.NsPerOpPct, th.NsPerOpPct))
+		}
+		if th.BytesPerOpPct > 0 && d.BytesPerOpPct > th.BytesPerOpPct {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("B/op +%.1f%% > %.1f%%", d.BytesPerOpPct, th.BytesPerOpPct))
+		}
+		if th.AllocsPerOpPct > 0 && d.AllocsPerOpPct > th.AllocsPerOpPct {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("allocs/op +%.1f%% > %.1f%%", d.AllocsPerOpPct, th.AllocsPerOpPct))
+		}
+		d.Regressed = len(d.Reasons) > 0
+		cmp.Deltas = append(cmp.Deltas, d)
+	}
+
+	for name := range base {
+		if !seen[name] {
+			cmp.Missing = append(cmp.Missing, name)
+		}
+	}
+	sort.Strings(cmp.Missing)
+	sort.Strings(cmp.Added)
+	sort.Slice(cmp.Deltas, func(i, j int) bool { return cmp.Deltas[i].Name < cmp.Deltas[j].Name })
+	return cmp
+}
+
+// Regressions returns only the deltas that exceeded a threshold
+func (c *Comparison) Regressions() []Delta {
+	var out []Delta
+	for _, d := range c.Deltas {
+		if d.Regressed {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Markdown renders the comparison as a table suitable for CI logs or PR comments
+func (c *Comparison) Markdown() string {
+	var sb strings.Builder
+
+	sb.WriteString("# Benchmark Comparison\n\n")
+	sb.WriteString(fmt.Sprintf("**Thresholds:** ns/op %.1f%%, B/op %.1f%%, allocs/op %.1f%%\n\n",
+		c.Thresholds.NsPerOpPct, c.Thresholds.BytesPerOpPct, c.Thresholds.AllocsPerOpPct))
+
+	sb.WriteString("| Benchmark | ns/op (base → cur) | Δ ns/op | Δ B/op | Δ allocs/op | Status |\n")
+	sb.WriteString("|-----------|--------------------|---------|--------|-------------|--------|\n")
+	for _, d := range c.Deltas {
+		status := "✅"
+		if d.Regressed {
+			status = "❌ " + strings.Join(d.Reasons, "; ")
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %d → %d | %+.1f%% | %+.1f%% | %+.1f%% | %s |\n",
+			d.Name, d.Baseline.NsPerOp, d.Current.NsPerOp,
+			d.NsPerOpPct, d.BytesPerOpPct, d.AllocsPerOpPct, status))
+	}
+
+	if len(c.Added) > 0 {
+		sb.WriteString("\n**New benchmarks:** " + strings.Join(c.Added, ", ") + "\n")
+	}
+	if len(c.Missing) > 0 {
+		sb.WriteString("\n**Missing from current run:** " + strings.Join(c.Missing, ", ") + "\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("\n**Regressions:** %d of %d compared\n", len(c.Regressions()), len(c.Deltas)))
+	return sb.String()
+}
+
+func pctChange(old, cur int64) float64 {
+	if old == 0 {
+		if cur == 0 {
+			return 0
+		}
+		return 100
+	}
+	return float64(cur-old) / float64(old) * 100
+}
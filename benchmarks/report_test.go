@@ -0,0 +1,59 @@
+package benchmarks
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareSuites(t *testing.T) {
+	baseline := &BenchmarkSuite{Results: []BenchmarkResult{
+		{Name: "BenchmarkPersistent_Put_memory", NsPerOp: 1000, BytesPerOp: 100, AllocsPerOp: 10},
+		{Name: "BenchmarkPersistent_Get_memory", NsPerOp: 500, BytesPerOp: 50, AllocsPerOp: 5},
+		{Name: "BenchmarkFetch_bitswap", NsPerOp: 100000},
+	}}
+	current := &BenchmarkSuite{Results: []BenchmarkResult{
+		{Name: "BenchmarkPersistent_Put_memory", NsPerOp: 1300, BytesPerOp: 100, AllocsPerOp: 10},
+		{Name: "BenchmarkPersistent_Get_memory", NsPerOp: 510, BytesPerOp: 50, AllocsPerOp: 5},
+		{Name: "BenchmarkFetch_blockstore", NsPerOp: 200},
+	}}
+
+	cmp := CompareSuites(baseline, current, DefaultThresholds())
+
+	regressions := cmp.Regressions()
+	if len(regressions) != 1 || regressions[0].Name != "BenchmarkPersistent_Put_memory" {
+		t.Fatalf("expected only Put_memory to regress, got %+v", regressions)
+	}
+	if len(cmp.Added) != 1 || cmp.Added[0] != "BenchmarkFetch_blockstore" {
+		t.Errorf("unexpected added benchmarks: %v", cmp.Added)
+	}
+	if len(cmp.Missing) != 1 || cmp.Missing[0] != "BenchmarkFetch_bitswap" {
+		t.Errorf("unexpected missing benchmarks: %v", cmp.Missing)
+	}
+
+	// Disabled thresholds never flag a regression
+	if got := CompareSuites(baseline, current, Thresholds{}).Regressions(); len(got) != 0 {
+		t.Errorf("zero thresholds must disable checks, got %d regressions", len(got))
+	}
+}
+
+func TestSaveLoadSuite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "baseline.json")
+	suite := &BenchmarkSuite{
+		Results:  []BenchmarkResult{{Name: "BenchmarkFetch_blockstore", NsPerOp: 42}},
+		Metadata: EnvironmentMetadata(),
+	}
+
+	if err := SaveSuite(suite, path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := LoadSuite(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(loaded.Results) != 1 || loaded.Results[0].NsPerOp != 42 {
+		t.Errorf("round trip mismatch: %+v", loaded.Results)
+	}
+	if loaded.Metadata["go_version"] == "" {
+		t.Error("environment metadata must include go_version")
+	}
+}
@@ -0,0 +1,255 @@
+package benchmarks
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	bitswap "github.com/gosuda/boxo-starter-kit/04-bitswap/pkg"
+)
+
+// Fetch paths exercised by RunWrapperSuite
+const (
+	FetchBlockstore   = "blockstore"   // PersistentWrapper.Get
+	FetchBlockService = "blockservice" // BlockServiceWrapper.GetBlock, local hit
+	FetchBitswap      = "bitswap"      // remote fetch between two connected nodes
+)
+
+// WrapperSuiteConfig selects which wrapper benchmarks to run in-process
+type WrapperSuiteConfig struct {
+	Backends   []persistent.PersistentType
+	FetchPaths []string
+	BlockSize  int
+	DataDir    string // parent directory for on-disk backends
+}
+
+// DefaultWrapperSuiteConfig covers every backend and fetch path
+func DefaultWrapperSuiteConfig() WrapperSuiteConfig {
+	return WrapperSuiteConfig{
+		Backends:   []persistent.PersistentType{persistent.Memory, persistent.File, persistent.Badgerdb, persistent.Pebbledb},
+		FetchPaths: []string{FetchBlockstore, FetchBlockService, FetchBitswap},
+		BlockSize:  DefaultConfig().SmallBlockSize,
+	}
+}
+
+// RunWrapperSuite benchmarks the starter-kit wrappers with testing.Benchmark,
+// so it can run from a regular binary instead of `go test`.
+func RunWrapperSuite(ctx context.Context, cfg WrapperSuiteConfig) (*BenchmarkSuite, error) {
+	if cfg.BlockSize <= 0 {
+		cfg.BlockSize = DefaultConfig().SmallBlockSize
+	}
+	if cfg.DataDir == "" {
+		dir, err := os.MkdirTemp("", "benchreport-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		cfg.DataDir = dir
+	}
+
+	suite := &BenchmarkSuite{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Metadata:  EnvironmentMetadata(),
+	}
+	suite.Metadata["block_size"] = fmt.Sprint(cfg.BlockSize)
+
+	for _, backend := range cfg.Backends {
+		results, err := benchmarkBackend(ctx, backend, filepath.Join(cfg.DataDir, string(backend)), cfg.BlockSize)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", backend, err)
+		}
+		suite.Results = append(suite.Results, results...)
+	}
+
+	for _, path := range cfg.FetchPaths {
+		result, err := benchmarkFetchPath(ctx, path, cfg.BlockSize)
+		if err != nil {
+			return nil, fmt.Errorf("fetch path %s: %w", path, err)
+		}
+		suite.Results = append(suite.Results, result)
+	}
+
+	sort.Slice(suite.Results, func(i, j int) bool {
+		return suite.Results[i].Name < suite.Results[j].Name
+	})
+	return suite, nil
+}
+
+func benchmarkBackend(ctx context.Context, backend persistent.PersistentType, path string, blockSize int) ([]BenchmarkResult, error) {
+	pw, err := persistent.New(backend, path)
+	if err != nil {
+		return nil, err
+	}
+	defer pw.Close()
+
+	var seq uint64
+	var opErr error
+
+	put := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(int64(blockSize))
+		for i := 0; i < b.N; i++ {
+			seq++
+			if _, err := pw.PutV1Cid(ctx, uniqueData(blockSize, seq), nil); err != nil {
+				opErr = err
+				b.FailNow()
+			}
+		}
+	})
+	if opErr != nil {
+		return nil, fmt.Errorf("put: %w", opErr)
+	}
+
+	c, err := pw.PutV1Cid(ctx, uniqueData(blockSize, 0), nil)
+	if err != nil {
+		return nil, err
+	}
+	get := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(int64(blockSize))
+		for i := 0; i < b.N; i++ {
+			if _, err := pw.GetRaw(ctx, c); err != nil {
+				opErr = err
+				b.FailNow()
+			}
+		}
+	})
+	if opErr != nil {
+		return nil, fmt.Errorf("get: %w", opErr)
+	}
+
+	return []BenchmarkResult{
+		toResult("BenchmarkPersistent_Put_"+string(backend), put),
+		toResult("BenchmarkPersistent_Get_"+string(backend), get),
+	}, nil
+}
+
+func benchmarkFetchPath(ctx context.Context, path string, blockSize int) (BenchmarkResult, error) {
+	var bench func(b *testing.B)
+	var opErr error
+
+	switch path {
+	case FetchBlockstore:
+		pw, err := persistent.New(persistent.Memory, "")
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer pw.Close()
+		c, err := pw.PutV1Cid(ctx, uniqueData(blockSize, 0), nil)
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		bench = func(b *testing.B) {
+			b.SetBytes(int64(blockSize))
+			for i := 0; i < b.N; i++ {
+				if _, err := pw.Get(ctx, c); err != nil {
+					opErr = err
+					b.FailNow()
+				}
+			}
+		}
+
+	case FetchBlockService:
+		bs, err := bitswap.NewBlockService(ctx, nil, nil)
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer bs.Close()
+		c, err := bs.AddBlockRaw(ctx, uniqueData(blockSize, 0))
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		bench = func(b *testing.B) {
+			b.SetBytes(int64(blockSize))
+			for i := 0; i < b.N; i++ {
+				if _, err := bs.GetBlock(ctx, c); err != nil {
+					opErr = err
+					b.FailNow()
+				}
+			}
+		}
+
+	case FetchBitswap:
+		provider, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer provider.Close()
+		fetcher, err := bitswap.NewBitswap(ctx, nil, nil, nil)
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer fetcher.Close()
+		if err := fetcher.HostWrapper.ConnectToPeer(ctx, provider.HostWrapper.GetFullAddresses()...); err != nil {
+			return BenchmarkResult{}, err
+		}
+
+		var seq uint64
+		bench = func(b *testing.B) {
+			b.SetBytes(int64(blockSize))
+			for i := 0; i < b.N; i++ {
+				// every iteration needs a block the fetcher has never seen
+				b.StopTimer()
+				seq++
+				c, err := provider.PutBlockRaw(ctx, uniqueData(blockSize, seq))
+				if err != nil {
+					opErr = err
+					b.FailNow()
+				}
+				b.StartTimer()
+
+				fetchCtx, cancel := context.WithTimeout(ctx, DefaultConfig().OperationTimeout)
+				_, err = fetcher.GetBlock(fetchCtx, c)
+				cancel()
+				if err != nil {
+					opErr = err
+					b.FailNow()
+				}
+			}
+		}
+
+	default:
+		return BenchmarkResult{}, fmt.Errorf("unknown fetch path %q", path)
+	}
+
+	r := testing.Benchmark(bench)
+	if opErr != nil {
+		return BenchmarkResult{}, opErr
+	}
+	return toResult("BenchmarkFetch_"+path, r), nil
+}
+
+func toResult(name string, r testing.BenchmarkResult) BenchmarkResult {
+	var mbPerSec float64
+	if r.Bytes > 0 && r.T > 0 {
+		mbPerSec = float64(r.Bytes) * float64(r.N) / 1e6 / r.T.Seconds()
+	}
+	return BenchmarkResult{
+		Name:        name,
+		Iterations:  r.N,
+		NsPerOp:     r.NsPerOp(),
+		MBPerSec:    mbPerSec,
+		BytesPerOp:  r.AllocedBytesPerOp(),
+		AllocsPerOp: r.AllocsPerOp(),
+		Timestamp:   time.Now().Format(time.RFC3339),
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+	}
+}
+
+// uniqueData returns size bytes whose first 8 bytes encode seq, so every seq
+// produces a distinct CID.
+func uniqueData(size int, seq uint64) []byte {
+	if size < 8 {
+		size = 8
+	}
+	data := DefaultConfig().TestData(size)
+	binary.BigEndian.PutUint64(data, seq)
+	return data
+}
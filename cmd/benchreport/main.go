@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	persistent "github.com/gosuda/boxo-starter-kit/01-persistent/pkg"
+	"github.com/gosuda/boxo-starter-kit/benchmarks"
+)
+
+// Command line tool that benchmarks the wrappers and fails on regressions
+func main() {
+	defaults := benchmarks.DefaultThresholds()
+	var (
+		backends       = flag.String("backends", "memory,file,badgerdb,pebbledb", "Comma-separated persistent backends")
+		fetchPaths     = flag.String("paths", "blockstore,blockservice,bitswap", "Comma-separated fetch paths")
+		blockSize      = flag.Int("block-size", benchmarks.DefaultConfig().SmallBlockSize, "Block size in bytes")
+		outputDir      = flag.String("output", "./benchmark_results", "Directory for JSON results and reports")
+		baseline       = flag.String("baseline", "", "Baseline results file to compare against")
+		updateBaseline = flag.Bool("update-baseline", false, "Overwrite the baseline file with this run")
+		maxNs          = flag.Float64("max-ns", defaults.NsPerOpPct, "Max ns/op increase in percent (0 disables)")
+		maxBytes       = flag.Float64("max-bytes", defaults.BytesPerOpPct, "Max B/op increase in percent (0 disables)")
+		maxAllocs      = flag.Float64("max-allocs", defaults.AllocsPerOpPct, "Max allocs/op increase in percent (0 disables)")
+	)
+	flag.Parse()
+
+	cfg := benchmarks.WrapperSuiteConfig{
+		FetchPaths: splitList(*fetchPaths),
+		BlockSize:  *blockSize,
+	}
+	for _, b := range splitList(*backends) {
+		cfg.Backends = append(cfg.Backends, persistent.PersistentType(b))
+	}
+
+	fmt.Printf("🏁 Running wrapper benchmarks (backends=%v, paths=%v, block=%dB)\n", cfg.Backends, cfg.FetchPaths, cfg.BlockSize)
+	suite, err := benchmarks.RunWrapperSuite(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to run benchmarks: %v", err)
+	}
+
+	for _, r := range suite.Results {
+		fmt.Printf("   %-40s %12d ns/op %10.2f MB/s %8d B/op %6d allocs/op\n",
+			r.Name, r.NsPerOp, r.MBPerSec, r.BytesPerOp, r.AllocsPerOp)
+	}
+
+	stamp := time.Now().Format("20060102_150405")
+	resultFile := filepath.Join(*outputDir, fmt.Sprintf("benchreport_%s.json", stamp))
+	if err := benchmarks.SaveSuite(suite, resultFile); err != nil {
+		log.Fatalf("Failed to save results: %v", err)
+	}
+	fmt.Printf("\n💾 Results saved to %s\n", resultFile)
+
+	if *baseline == "" {
+		return
+	}
+
+	if _, err := os.Stat(*baseline); os.IsNotExist(err) {
+		if !*updateBaseline {
+			log.Fatalf("Baseline file not found: %s (use -update-baseline to create it)", *baseline)
+		}
+		if err := benchmarks.SaveSuite(suite, *baseline); err != nil {
+			log.Fatalf("Failed to write baseline: %v", err)
+		}
+		fmt.Printf("📌 Baseline created at %s\n", *baseline)
+		return
+	}
+
+	base, err := benchmarks.LoadSuite(*baseline)
+	if err != nil {
+		log.Fatalf("Failed to load baseline: %v", err)
+	}
+	cmp := benchmarks.CompareSuites(base, suite, benchmarks.Thresholds{
+		NsPerOpPct:     *maxNs,
+		BytesPerOpPct:  *maxBytes,
+		AllocsPerOpPct: *maxAllocs,
+	})
+
+	report := cmp.Markdown()
+	reportFile := filepath.Join(*outputDir, fmt.Sprintf("benchreport_%s.md", stamp))
+	if err := os.WriteFile(reportFile, []byte(report), 0644); err != nil {
+		log.Printf("Warning: failed to write report: %v", err)
+	}
+	fmt.Println()
+	fmt.Println(report)
+
+	if regressions := cmp.Regressions(); len(regressions) > 0 {
+		fmt.Printf("❌ %d regression(s) exceed thresholds\n", len(regressions))
+		os.Exit(1)
+	}
+
+	fmt.Println("✅ No regressions")
+	if *updateBaseline {
+		if err := benchmarks.SaveSuite(suite, *baseline); err != nil {
+			log.Fatalf("Failed to update baseline: %v", err)
+		}
+		fmt.Printf("📌 Baseline updated at %s\n", *baseline)
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nBoxo Starter Kit Benchmark Regression Report\n")
+		fmt.Fprintf(os.Stderr, "============================================\n\n")
+		fmt.Fprintf(os.Stderr, "Benchmarks the persistent backends and block fetch paths in-process,\n")
+		fmt.Fprintf(os.Stderr, "stores the results as JSON with environment metadata, and compares\n")
+		fmt.Fprintf(os.Stderr, "them against a baseline. Exits with status 1 on regressions.\n\n")
+		fmt.Fprintf(os.Stderr, "Examples:\n")
+		fmt.Fprintf(os.Stderr, "  %s -baseline=baseline.json -update-baseline   # Record a baseline\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -baseline=baseline.json                     # Check for regressions\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -backends=badgerdb,pebbledb -paths=bitswap  # Subset of the suite\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+}
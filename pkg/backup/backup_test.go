@@ -255,7 +255,9 @@ func TestMigrationManager_ExecuteMigration(t *testing.T) {
 	targetDS := sync.MutexWrap(datastore.NewMapDatastore())
 	defer targetDS.Close()
 
-	// Create migration plan
+	// Create migration plan, backing up into a temp dir
+	config := DefaultMigrationConfig()
+	config.BackupDir = t.TempDir()
 	plan := &MigrationPlan{
 		ID:          "test-migration",
 		Version:     "1.0",
@@ -272,7 +274,7 @@ func TestMigrationManager_ExecuteMigration(t *testing.T) {
 				Description: "Validate copied data",
 			},
 		},
-		Config: DefaultMigrationConfig(),
+		Config: config,
 	}
 
 	// Execute migration
@@ -332,6 +334,7 @@ func TestMigrationManager_DryRun(t *testing.T) {
 	// Create migration plan with dry run
 	config := DefaultMigrationConfig()
 	config.DryRun = true
+	config.BackupDir = t.TempDir()
 
 	plan := &MigrationPlan{
 		ID:      "test-dry-run",
@@ -523,6 +526,8 @@ func BenchmarkMigrationManager_ExecuteMigration(b *testing.B) {
 		sourceDS.Put(ctx, key, value)
 	}
 
+	config := DefaultMigrationConfig()
+	config.BackupDir = b.TempDir()
 	plan := &MigrationPlan{
 		ID:      "benchmark-migration",
 		Version: "1.0",
@@ -532,7 +537,7 @@ func BenchmarkMigrationManager_ExecuteMigration(b *testing.B) {
 				Type: MigrationCopy,
 			},
 		},
-		Config: DefaultMigrationConfig(),
+		Config: config,
 	}
 
 	manager := NewMigrationManager(plan.Config)
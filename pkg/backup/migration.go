@@ -3,6 +3,7 @@ package backup
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/ipfs/go-datastore"
@@ -23,6 +24,7 @@ type MigrationConfig struct {
 	Timeout         time.Duration // Migration operation timeout
 	VerifyMigration bool          // Whether to verify migration results
 	BackupBefore    bool          // Create backup before migration
+	BackupDir       string        // Where that backup is written (default: working directory)
 	DryRun          bool          // Only simulate migration
 }
 
@@ -158,7 +160,7 @@ func (mm *MigrationManager) ExecuteMigration(ctx context.Context, plan *Migratio
 	// Create backup if requested
 	if plan.Config.BackupBefore {
 		backupManager := NewBackupManager(DefaultBackupConfig())
-		backupPath := fmt.Sprintf("backup_before_migration_%s_%d.tar.gz", plan.ID, start.Unix())
+		backupPath := filepath.Join(plan.Config.BackupDir, fmt.Sprintf("backup_before_migration_%s_%d.tar.gz", plan.ID, start.Unix()))
 
 		_, err := backupManager.CreateBackup(migrationCtx, sourceDS, backupPath)
 		if err != nil {
@@ -0,0 +1,47 @@
+package metrics
+
+import "sync"
+
+// BandwidthTotals is a snapshot of traffic counters for one source
+type BandwidthTotals struct {
+	TotalIn  int64   `json:"total_in"`
+	TotalOut int64   `json:"total_out"`
+	RateIn   float64 `json:"rate_in_bytes_per_sec"`
+	RateOut  float64 `json:"rate_out_bytes_per_sec"`
+}
+
+// BandwidthSource reports the current totals of a traffic counter
+type BandwidthSource func() BandwidthTotals
+
+type bandwidthRegistry struct {
+	mu      sync.RWMutex
+	sources map[string]BandwidthSource
+}
+
+var globalBandwidth = &bandwidthRegistry{sources: make(map[string]BandwidthSource)}
+
+// RegisterGlobalBandwidth publishes a traffic counter under name,
+// replacing any source previously registered with that name
+func RegisterGlobalBandwidth(name string, src BandwidthSource) {
+	globalBandwidth.mu.Lock()
+	defer globalBandwidth.mu.Unlock()
+	globalBandwidth.sources[name] = src
+}
+
+// UnregisterGlobalBandwidth removes a traffic counter
+func UnregisterGlobalBandwidth(name string) {
+	globalBandwidth.mu.Lock()
+	defer globalBandwidth.mu.Unlock()
+	delete(globalBandwidth.sources, name)
+}
+
+// GetGlobalBandwidth returns the totals of every registered source
+func GetGlobalBandwidth() map[string]BandwidthTotals {
+	globalBandwidth.mu.RLock()
+	defer globalBandwidth.mu.RUnlock()
+	out := make(map[string]BandwidthTotals, len(globalBandwidth.sources))
+	for name, src := range globalBandwidth.sources {
+		out[name] = src()
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default bucket upper bounds for latency (milliseconds) and size (bytes) histograms
+var (
+	LatencyBucketsMs = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000}
+	SizeBucketsBytes = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+)
+
+// Histogram counts observations into fixed upper-bound buckets.
+// The last bucket (+Inf) catches everything above the highest bound.
+type Histogram struct {
+	mu     sync.RWMutex
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+	max    float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds
+func NewHistogram(bounds []float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{
+		bounds: b,
+		counts: make([]int64, len(b)+1),
+	}
+}
+
+// Observe records a single value
+func (h *Histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[idx]++
+	h.count++
+	h.sum += v
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// ObserveDuration records a duration in milliseconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(float64(d) / float64(time.Millisecond))
+}
+
+// Snapshot returns a point-in-time copy of the histogram
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s := HistogramSnapshot{
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: append([]int64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+		Max:    h.max,
+	}
+	if h.count > 0 {
+		s.Mean = h.sum / float64(h.count)
+	}
+	return s
+}
+
+// Reset clears all observations
+func (h *Histogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts = make([]int64, len(h.bounds)+1)
+	h.count = 0
+	h.sum = 0
+	h.max = 0
+}
+
+// HistogramSnapshot represents a point-in-time view of a histogram.
+// Counts has one more entry than Bounds for the +Inf bucket.
+type HistogramSnapshot struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum"`
+	Mean   float64   `json:"mean"`
+	Max    float64   `json:"max"`
+}
+
+// Quantile estimates the q-th quantile (0..1) as the upper bound of the
+// bucket that contains it. Values in the +Inf bucket report Max.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Count))
+	if rank >= s.Count {
+		rank = s.Count - 1
+	}
+	var seen int64
+	for i, c := range s.Counts {
+		seen += c
+		if seen > rank {
+			if i < len(s.Bounds) {
+				return s.Bounds[i]
+			}
+			return s.Max
+		}
+	}
+	return s.Max
+}
@@ -45,6 +45,12 @@ func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleAggregated(w, r)
 	case "/metrics/health":
 		h.handleHealth(w, r)
+	case "/metrics/bandwidth":
+		h.handleBandwidth(w, r)
+	case "/metrics/protocols":
+		h.handleProtocols(w, r)
+	case "/metrics/limits":
+		h.handleLimits(w, r)
 	default:
 		h.handleIndex(w, r)
 	}
@@ -56,6 +62,9 @@ func (h *HTTPHandler) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		"timestamp":  time.Now().UTC(),
 		"components": h.collector.GetAllSnapshots(),
 		"aggregated": h.collector.GetAggregatedSnapshot(),
+		"bandwidth":  GetGlobalBandwidth(),
+		"protocols":  GetGlobalProtocols(),
+		"limits":     GetGlobalLimits(),
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -145,6 +154,42 @@ func (h *HTTPHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleBandwidth returns traffic totals for every bandwidth source
+func (h *HTTPHandler) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"bandwidth": GetGlobalBandwidth(),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleProtocols returns the statistics of every protocol source
+func (h *HTTPHandler) handleProtocols(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"protocols": GetGlobalProtocols(),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleLimits returns the consumption of every registered limit
+func (h *HTTPHandler) handleLimits(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"limits":    GetGlobalLimits(),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 // handleIndex returns API documentation
 func (h *HTTPHandler) handleIndex(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
@@ -156,6 +201,9 @@ func (h *HTTPHandler) handleIndex(w http.ResponseWriter, r *http.Request) {
 			"GET /metrics/components": "Individual component metrics (use ?name=component_name for specific component)",
 			"GET /metrics/aggregated": "System-wide aggregated metrics",
 			"GET /metrics/health":     "System health status",
+			"GET /metrics/bandwidth":  "Traffic totals and rates per bandwidth source",
+			"GET /metrics/protocols":  "Protocol statistics, e.g. bitswap blocks, duplicates and want latency",
+			"GET /metrics/limits":     "Quota and concurrency limit consumption, e.g. gateway bytes per CID",
 		},
 		"examples": map[string]string{
 			"all_metrics":        "/metrics",
@@ -0,0 +1,40 @@
+package metrics
+
+import "sync"
+
+// LimitSource reports the current consumption of a limit, e.g. the
+// per-CID bandwidth quotas of a gateway. The value is served as JSON.
+type LimitSource func() any
+
+type limitRegistry struct {
+	mu      sync.RWMutex
+	sources map[string]LimitSource
+}
+
+var globalLimits = &limitRegistry{sources: make(map[string]LimitSource)}
+
+// RegisterGlobalLimit publishes limit consumption under name, replacing
+// any source previously registered with that name
+func RegisterGlobalLimit(name string, src LimitSource) {
+	globalLimits.mu.Lock()
+	defer globalLimits.mu.Unlock()
+	globalLimits.sources[name] = src
+}
+
+// UnregisterGlobalLimit removes a limit source
+func UnregisterGlobalLimit(name string) {
+	globalLimits.mu.Lock()
+	defer globalLimits.mu.Unlock()
+	delete(globalLimits.sources, name)
+}
+
+// GetGlobalLimits returns the consumption of every registered limit
+func GetGlobalLimits() map[string]any {
+	globalLimits.mu.RLock()
+	defer globalLimits.mu.RUnlock()
+	out := make(map[string]any, len(globalLimits.sources))
+	for name, src := range globalLimits.sources {
+		out[name] = src()
+	}
+	return out
+}
@@ -246,6 +246,11 @@ func RegisterGlobalComponent(component *ComponentMetrics) {
 	globalCollector.RegisterComponent(component)
 }
 
+// UnregisterGlobalComponent removes a component from the global collector
+func UnregisterGlobalComponent(componentName string) {
+	globalCollector.UnregisterComponent(componentName)
+}
+
 // GetGlobalSnapshot returns a snapshot of all global metrics
 func GetGlobalSnapshot() map[string]MetricsSnapshot {
 	return globalCollector.GetAllSnapshots()
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -238,3 +240,69 @@ func BenchmarkMetrics_GetSnapshot(b *testing.B) {
 		_ = metrics.GetSnapshot()
 	}
 }
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{10, 1, 100}) // unsorted on purpose
+
+	for _, v := range []float64{0.5, 1, 5, 50, 500} {
+		h.Observe(v)
+	}
+	h.ObserveDuration(2 * time.Millisecond)
+
+	s := h.Snapshot()
+	assert.Equal(t, []float64{1, 10, 100}, s.Bounds)
+	assert.Equal(t, []int64{2, 2, 1, 1}, s.Counts)
+	assert.Equal(t, int64(6), s.Count)
+	assert.Equal(t, 500.0, s.Max)
+	assert.InDelta(t, 558.5/6, s.Mean, 1e-9)
+
+	assert.Equal(t, 1.0, s.Quantile(0.1))
+	assert.Equal(t, 10.0, s.Quantile(0.5))
+	assert.Equal(t, 500.0, s.Quantile(1))
+
+	h.Reset()
+	assert.Equal(t, int64(0), h.Snapshot().Count)
+	assert.Equal(t, 0.0, h.Snapshot().Quantile(0.5))
+}
+
+func TestGlobalBandwidth(t *testing.T) {
+	RegisterGlobalBandwidth("bw-test", func() BandwidthTotals {
+		return BandwidthTotals{TotalIn: 10, TotalOut: 20}
+	})
+	bw := GetGlobalBandwidth()
+	assert.Equal(t, int64(10), bw["bw-test"].TotalIn)
+	assert.Equal(t, int64(20), bw["bw-test"].TotalOut)
+
+	UnregisterGlobalBandwidth("bw-test")
+	assert.NotContains(t, GetGlobalBandwidth(), "bw-test")
+}
+
+func TestGlobalProtocols(t *testing.T) {
+	RegisterGlobalProtocol("proto-test", func() any {
+		return map[string]int{"blocks": 3}
+	})
+	assert.Equal(t, map[string]int{"blocks": 3}, GetGlobalProtocols()["proto-test"])
+
+	rec := httptest.NewRecorder()
+	NewHTTPHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics/protocols", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"proto-test":{"blocks":3}`)
+
+	UnregisterGlobalProtocol("proto-test")
+	assert.NotContains(t, GetGlobalProtocols(), "proto-test")
+}
+
+func TestGlobalLimits(t *testing.T) {
+	RegisterGlobalLimit("limit-test", func() any {
+		return map[string]int64{"used": 42}
+	})
+	assert.Equal(t, map[string]int64{"used": 42}, GetGlobalLimits()["limit-test"])
+
+	rec := httptest.NewRecorder()
+	NewHTTPHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics/limits", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"limit-test":{"used":42}`)
+
+	UnregisterGlobalLimit("limit-test")
+	assert.NotContains(t, GetGlobalLimits(), "limit-test")
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import "sync"
+
+// ProtocolSource reports the current statistics of a protocol, e.g. the
+// block and want counters of bitswap. The value is served as JSON.
+type ProtocolSource func() any
+
+type protocolRegistry struct {
+	mu      sync.RWMutex
+	sources map[string]ProtocolSource
+}
+
+var globalProtocols = &protocolRegistry{sources: make(map[string]ProtocolSource)}
+
+// RegisterGlobalProtocol publishes protocol statistics under name,
+// replacing any source previously registered with that name
+func RegisterGlobalProtocol(name string, src ProtocolSource) {
+	globalProtocols.mu.Lock()
+	defer globalProtocols.mu.Unlock()
+	globalProtocols.sources[name] = src
+}
+
+// UnregisterGlobalProtocol removes a protocol source
+func UnregisterGlobalProtocol(name string) {
+	globalProtocols.mu.Lock()
+	defer globalProtocols.mu.Unlock()
+	delete(globalProtocols.sources, name)
+}
+
+// GetGlobalProtocols returns the statistics of every registered protocol
+func GetGlobalProtocols() map[string]any {
+	globalProtocols.mu.RLock()
+	defer globalProtocols.mu.RUnlock()
+	out := make(map[string]any, len(globalProtocols.sources))
+	for name, src := range globalProtocols.sources {
+		out[name] = src()
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package security
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyConfig limits how many requests one client may have in
+// flight. Unlike RateLimitConfig it is not about how often a client asks
+// but about how much of the server it holds at once, which matters for
+// slow downloads.
+type ConcurrencyConfig struct {
+	MaxPerKey    int                        // Requests in flight per key (default 8)
+	RetryAfter   time.Duration              // Suggested wait when rejected (default 1s)
+	KeyExtractor func(*http.Request) string // Defaults to the client IP
+}
+
+// ConcurrencyStats reports a ConcurrencyLimiter
+type ConcurrencyStats struct {
+	MaxPerKey int            `json:"max_per_key"`
+	InFlight  map[string]int `json:"in_flight"`
+	Rejected  int64          `json:"rejected"`
+}
+
+// ConcurrencyLimiter caps the requests in flight per key
+type ConcurrencyLimiter struct {
+	config ConcurrencyConfig
+
+	mu       sync.Mutex
+	inFlight map[string]int
+
+	rejected atomic.Int64
+}
+
+// NewConcurrencyLimiter creates a limiter; zero config values use the
+// defaults
+func NewConcurrencyLimiter(config ConcurrencyConfig) *ConcurrencyLimiter {
+	if config.MaxPerKey <= 0 {
+		config.MaxPerKey = 8
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	if config.KeyExtractor == nil {
+		config.KeyExtractor = extractClientIP
+	}
+	return &ConcurrencyLimiter{config: config, inFlight: make(map[string]int)}
+}
+
+// Acquire takes a slot for key and reports whether one was free. Every
+// successful Acquire must be followed by a Release.
+func (cl *ConcurrencyLimiter) Acquire(key string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.inFlight[key] >= cl.config.MaxPerKey {
+		cl.rejected.Add(1)
+		return false
+	}
+	cl.inFlight[key]++
+	return true
+}
+
+// Release frees a slot taken by Acquire
+func (cl *ConcurrencyLimiter) Release(key string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.inFlight[key] <= 1 {
+		delete(cl.inFlight, key)
+		return
+	}
+	cl.inFlight[key]--
+}
+
+// Stats returns the current slots in use and the rejections so far
+func (cl *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	inFlight := make(map[string]int, len(cl.inFlight))
+	for k, v := range cl.inFlight {
+		inFlight[k] = v
+	}
+	return ConcurrencyStats{
+		MaxPerKey: cl.config.MaxPerKey,
+		InFlight:  inFlight,
+		Rejected:  cl.rejected.Load(),
+	}
+}
+
+// Middleware returns HTTP middleware that answers 429 with Retry-After
+// while a client already has MaxPerKey requests in flight
+func (cl *ConcurrencyLimiter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := cl.config.KeyExtractor(r)
+			if !cl.Acquire(key) {
+				w.Header().Set("Retry-After", retryAfterSeconds(cl.config.RetryAfter))
+				http.Error(w, "Too many concurrent requests", http.StatusTooManyRequests)
+				return
+			}
+			defer cl.Release(key)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// retryAfterSeconds formats d for the Retry-After header, rounding up so
+// clients never retry too early
+func retryAfterSeconds(d time.Duration) string {
+	return fmt.Sprintf("%d", int64(math.Ceil(max(d, time.Second).Seconds())))
+}
@@ -0,0 +1,175 @@
+package security
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// QuotaConfig limits the bytes served per key within a window, e.g. per
+// CID so a single hot file cannot use up a gateway's bandwidth
+type QuotaConfig struct {
+	BytesPerWindow int64                      // Bytes allowed per key and window (default 1GiB)
+	Window         time.Duration              // Window length (default 1h)
+	KeyExtractor   func(*http.Request) string // Defaults to ExtractCID; "" is not limited
+}
+
+// QuotaUsage is the consumption of one key in the current window
+type QuotaUsage struct {
+	Used    int64     `json:"used"`
+	Limit   int64     `json:"limit"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// QuotaStats reports a BandwidthQuota
+type QuotaStats struct {
+	Keys     map[string]QuotaUsage `json:"keys"`
+	Rejected int64                 `json:"rejected"`
+}
+
+// BandwidthQuota counts the bytes of responses per key in fixed windows
+// and turns requests away once a key has used up its window. The
+// response that crosses the limit is completed; the ones after it are
+// refused until the window resets.
+type BandwidthQuota struct {
+	config QuotaConfig
+
+	mu        sync.Mutex
+	usage     map[string]*QuotaUsage
+	nextPrune time.Time // when Allow next drops the closed windows
+
+	rejected atomic.Int64
+}
+
+// NewBandwidthQuota creates a quota; zero config values use the defaults
+func NewBandwidthQuota(config QuotaConfig) *BandwidthQuota {
+	if config.BytesPerWindow <= 0 {
+		config.BytesPerWindow = 1 << 30
+	}
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+	if config.KeyExtractor == nil {
+		config.KeyExtractor = ExtractCID
+	}
+	return &BandwidthQuota{config: config, usage: make(map[string]*QuotaUsage)}
+}
+
+// current returns the usage of key, starting a new window if the last
+// one is over. Must be called with the lock held.
+func (q *BandwidthQuota) current(key string, now time.Time) *QuotaUsage {
+	u, ok := q.usage[key]
+	if !ok || !now.Before(u.ResetAt) {
+		u = &QuotaUsage{Limit: q.config.BytesPerWindow, ResetAt: now.Add(q.config.Window)}
+		q.usage[key] = u
+	}
+	return u
+}
+
+// prune drops the windows that are over, at most once per window length,
+// so keys seen once do not stay in memory. Must be called with the lock
+// held.
+func (q *BandwidthQuota) prune(now time.Time) {
+	if now.Before(q.nextPrune) {
+		return
+	}
+	for k, u := range q.usage {
+		if !now.Before(u.ResetAt) {
+			delete(q.usage, k)
+		}
+	}
+	q.nextPrune = now.Add(q.config.Window)
+}
+
+// Allow reports whether key has bytes left in its window and, if not,
+// how long until it has
+func (q *BandwidthQuota) Allow(key string) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	q.prune(now)
+	u := q.current(key, now)
+	if u.Used >= u.Limit {
+		q.rejected.Add(1)
+		return false, u.ResetAt.Sub(now)
+	}
+	return true, 0
+}
+
+// Consume charges n bytes to key
+func (q *BandwidthQuota) Consume(key string, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.current(key, time.Now()).Used += n
+}
+
+// Stats returns the usage of every key whose window is still open
+func (q *BandwidthQuota) Stats() QuotaStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	keys := make(map[string]QuotaUsage, len(q.usage))
+	for k, u := range q.usage {
+		if now.Before(u.ResetAt) {
+			keys[k] = *u
+		} else {
+			delete(q.usage, k)
+		}
+	}
+	return QuotaStats{Keys: keys, Rejected: q.rejected.Load()}
+}
+
+// Middleware returns HTTP middleware that answers 429 with Retry-After
+// set to the end of the window once a key's bytes are used up
+func (q *BandwidthQuota) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := q.config.KeyExtractor(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok, wait := q.Allow(key); !ok {
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
+				http.Error(w, "Bandwidth quota exceeded", http.StatusTooManyRequests)
+				return
+			}
+			cw := &countingWriter{ResponseWriter: w}
+			defer func() { q.Consume(key, cw.n) }()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// countingWriter counts the body bytes written through it
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// ExtractCID returns the root CID a gateway request is for, from an
+// /ipfs/<cid> path or a <cid>.ipfs.<gateway> subdomain, as CIDv1 so both
+// versions of a CID share one quota. Other requests give "".
+func ExtractCID(r *http.Request) string {
+	var candidate string
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/ipfs/"); ok {
+		candidate, _, _ = strings.Cut(rest, "/")
+	} else if label, rest, ok := strings.Cut(r.Host, "."); ok && strings.HasPrefix(rest, "ipfs.") {
+		candidate = label
+	}
+	c, err := cid.Decode(candidate)
+	if err != nil {
+		return ""
+	}
+	return cid.NewCidV1(c.Type(), c.Hash()).String()
+}
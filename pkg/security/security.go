@@ -12,6 +12,14 @@ type SecurityConfig struct {
 	RateLimit       RateLimitConfig
 	EnableRateLimit bool
 
+	// Concurrent requests per client
+	Concurrency       ConcurrencyConfig
+	EnableConcurrency bool
+
+	// Bandwidth quotas, per CID by default
+	Quota       QuotaConfig
+	EnableQuota bool
+
 	// Authentication
 	Auth       AuthConfig
 	EnableAuth bool
@@ -66,9 +74,18 @@ func DefaultSecurityConfig() SecurityConfig {
 type SecurityMiddleware struct {
 	config      SecurityConfig
 	rateLimiter *RateLimiter
+	concurrency *ConcurrencyLimiter
+	quota       *BandwidthQuota
 	auth        *AuthMiddleware
 }
 
+// LimitStats reports the concurrency limiter and bandwidth quota of a
+// SecurityMiddleware; disabled ones are nil
+type LimitStats struct {
+	Concurrency *ConcurrencyStats `json:"concurrency,omitempty"`
+	Quota       *QuotaStats       `json:"quota,omitempty"`
+}
+
 // NewSecurityMiddleware creates a new security middleware with the given config
 func NewSecurityMiddleware(config SecurityConfig) *SecurityMiddleware {
 	sm := &SecurityMiddleware{
@@ -79,6 +96,14 @@ func NewSecurityMiddleware(config SecurityConfig) *SecurityMiddleware {
 		sm.rateLimiter = NewRateLimiter(config.RateLimit)
 	}
 
+	if config.EnableConcurrency {
+		sm.concurrency = NewConcurrencyLimiter(config.Concurrency)
+	}
+
+	if config.EnableQuota {
+		sm.quota = NewBandwidthQuota(config.Quota)
+	}
+
 	if config.EnableAuth {
 		sm.auth = NewAuthMiddleware(config.Auth)
 	}
@@ -86,6 +111,21 @@ func NewSecurityMiddleware(config SecurityConfig) *SecurityMiddleware {
 	return sm
 }
 
+// LimitStats returns what the concurrency limiter and bandwidth quota
+// have counted, e.g. for metrics.RegisterGlobalLimit
+func (sm *SecurityMiddleware) LimitStats() LimitStats {
+	var stats LimitStats
+	if sm.concurrency != nil {
+		s := sm.concurrency.Stats()
+		stats.Concurrency = &s
+	}
+	if sm.quota != nil {
+		s := sm.quota.Stats()
+		stats.Quota = &s
+	}
+	return stats
+}
+
 // Handler returns a complete security middleware stack
 func (sm *SecurityMiddleware) Handler() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -113,6 +153,16 @@ func (sm *SecurityMiddleware) Handler() func(http.Handler) http.Handler {
 			handler = sm.auth.JWTAuth()(handler)
 		}
 
+		// Bandwidth quota
+		if sm.quota != nil {
+			handler = sm.quota.Middleware()(handler)
+		}
+
+		// Concurrent requests
+		if sm.concurrency != nil {
+			handler = sm.concurrency.Middleware()(handler)
+		}
+
 		// Rate limiting
 		if sm.config.EnableRateLimit && sm.rateLimiter != nil {
 			handler = sm.rateLimiter.Middleware(sm.config.RateLimit.KeyExtractor)(handler)
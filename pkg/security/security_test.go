@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -338,3 +339,154 @@ func TestCORS(t *testing.T) {
 		t.Error("CORS headers should be set for actual request")
 	}
 }
+
+func TestConcurrencyLimit(t *testing.T) {
+	limiter := security.NewConcurrencyLimiter(security.ConcurrencyConfig{
+		MaxPerKey:  2,
+		RetryAfter: 3 * time.Second,
+	})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := limiter.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Hold both slots of one client
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = "10.0.0.1:1000"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			done <- rec.Code
+		}()
+		<-started
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "10.0.0.1:2000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Third concurrent request should be rejected, got status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After should be 3, got %q", got)
+	}
+
+	// Another client is not affected
+	go func() {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "10.0.0.2:1000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	<-started
+
+	stats := limiter.Stats()
+	if stats.InFlight["10.0.0.1"] != 2 || stats.InFlight["10.0.0.2"] != 1 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("Held request should succeed, got status %d", code)
+		}
+	}
+	if stats := limiter.Stats(); len(stats.InFlight) != 0 {
+		t.Errorf("Slots should be released, got %v", stats.InFlight)
+	}
+}
+
+func TestBandwidthQuota(t *testing.T) {
+	quota := security.NewBandwidthQuota(security.QuotaConfig{
+		BytesPerWindow: 100,
+		Window:         200 * time.Millisecond,
+	})
+	handler := quota.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 60)))
+	}))
+
+	const v0 = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+	get := func(target, host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if host != "" {
+			req.Host = host
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 60 + 60 bytes crosses the limit; the crossing response completes
+	for i := 0; i < 2; i++ {
+		if rec := get("/ipfs/"+v0+"/file", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d should succeed, got status %d", i+1, rec.Code)
+		}
+	}
+
+	// The subdomain form of the same CID shares its quota
+	v1 := security.ExtractCID(httptest.NewRequest("GET", "/ipfs/"+v0, nil))
+	rec := get("/", v1+".ipfs.localhost:8080")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Request over quota should be rejected, got status %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After should be 1, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	// Requests without a CID are not limited
+	if rec := get("/ipns/example.com", ""); rec.Code != http.StatusOK {
+		t.Errorf("IPNS request should not be limited, got status %d", rec.Code)
+	}
+
+	stats := quota.Stats()
+	if usage := stats.Keys[v1]; usage.Used != 120 || usage.Limit != 100 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+	if stats.Rejected != 1 {
+		t.Errorf("Expected 1 rejection, got %d", stats.Rejected)
+	}
+
+	// A new window starts afresh
+	time.Sleep(250 * time.Millisecond)
+	if rec := get("/ipfs/"+v1, ""); rec.Code != http.StatusOK {
+		t.Errorf("Request in a new window should succeed, got status %d", rec.Code)
+	}
+}
+
+func TestExtractCID(t *testing.T) {
+	const v0 = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+	const v1 = "bafybeie5nqv6kd3qnfjupgvz34woh3oksc3iau6abmyajn7qvtf6d2ho34"
+
+	tests := []struct {
+		name   string
+		target string
+		host   string
+		want   string
+	}{
+		{"CIDv0 Path", "/ipfs/" + v0 + "/a/b", "", v1},
+		{"CIDv1 Path", "/ipfs/" + v1, "", v1},
+		{"Subdomain", "/index.html", v1 + ".ipfs.example.com", v1},
+		{"IPNS Path", "/ipns/example.com", "", ""},
+		{"Invalid CID", "/ipfs/not-a-cid", "", ""},
+		{"Plain Host", "/", "example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if got := security.ExtractCID(req); got != tt.want {
+				t.Errorf("ExtractCID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}